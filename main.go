@@ -42,10 +42,13 @@ import (
 	pp "github.com/skygeario/skygear-server/pkg/server/preprocessor"
 	"github.com/skygeario/skygear-server/pkg/server/pubsub"
 	"github.com/skygeario/skygear-server/pkg/server/push"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
-	_ "github.com/skygeario/skygear-server/pkg/server/skydb/pq"
+	_ "github.com/skygeario/skygear-server/pkg/server/skydb/memory"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
 	"github.com/skygeario/skygear-server/pkg/server/skyversion"
 	"github.com/skygeario/skygear-server/pkg/server/subscription"
 )
@@ -113,6 +116,8 @@ func main() {
 		internalHub = pubsub.NewHub()
 		initSubscription(config, connOpener, internalHub, pushSender)
 		initDevice(config, connOpener)
+		initRecordExpirySweeper(config, connOpener, cronjob, pluginContext.HookRegistry)
+		initRecordChangePruner(config, connOpener, cronjob)
 	}
 
 	// Preprocessor
@@ -195,6 +200,11 @@ func main() {
 			Complete: true,
 			Name:     "AuthRecordKeys",
 		},
+		&inject.Object{
+			Value:    initQueryCache(config, connOpener),
+			Complete: true,
+			Name:     "QueryCache",
+		},
 	)
 	if injectErr != nil {
 		panic(fmt.Sprintf("Unable to set up handler: %v", injectErr))
@@ -217,8 +227,17 @@ func main() {
 
 	r.Map("record:fetch", injector.Inject(&handler.RecordFetchHandler{}))
 	r.Map("record:query", injector.Inject(&handler.RecordQueryHandler{}))
+	r.Map("record:query_batch", injector.Inject(&handler.RecordQueryBatchHandler{}))
 	r.Map("record:save", injector.Inject(&handler.RecordSaveHandler{}))
 	r.Map("record:delete", injector.Inject(&handler.RecordDeleteHandler{}))
+	r.Map("record:update_where", injector.Inject(&handler.RecordUpdateWhereHandler{}))
+	r.Map("record:delete_where", injector.Inject(&handler.RecordDeleteWhereHandler{}))
+	r.Map("record:trash", injector.Inject(&handler.RecordTrashHandler{}))
+	r.Map("record:restore", injector.Inject(&handler.RecordRestoreHandler{}))
+	r.Map("record:purge", injector.Inject(&handler.RecordPurgeHandler{}))
+	r.Map("record:history", injector.Inject(&handler.RecordHistoryHandler{}))
+	r.Map("record:revert", injector.Inject(&handler.RecordRevertHandler{}))
+	r.Map("record:changes", injector.Inject(&handler.RecordChangesHandler{}))
 
 	r.Map("device:register", injector.Inject(&handler.DeviceRegisterHandler{}))
 	r.Map("device:unregister", injector.Inject(&handler.DeviceUnregisterHandler{}))
@@ -247,8 +266,18 @@ func main() {
 
 	r.Map("schema:rename", injector.Inject(&handler.SchemaRenameHandler{}))
 	r.Map("schema:delete", injector.Inject(&handler.SchemaDeleteHandler{}))
+	r.Map("schema:alter", injector.Inject(&handler.SchemaAlterHandler{}))
 	r.Map("schema:create", injector.Inject(&handler.SchemaCreateHandler{}))
 	r.Map("schema:fetch", injector.Inject(&handler.SchemaFetchHandler{}))
+	r.Map("schema:search_index:create", injector.Inject(&handler.SchemaSearchIndexCreateHandler{}))
+	r.Map("schema:soft_delete", injector.Inject(&handler.SchemaSoftDeleteHandler{}))
+	r.Map("schema:history", injector.Inject(&handler.SchemaHistoryHandler{}))
+	r.Map("schema:expiry", injector.Inject(&handler.SchemaExpiryHandler{}))
+	r.Map("schema:export", injector.Inject(&handler.SchemaExportHandler{}))
+	r.Map("schema:import", injector.Inject(&handler.SchemaImportHandler{}))
+	r.Map("schema:index:fetch", injector.Inject(&handler.SchemaIndexFetchHandler{}))
+	r.Map("schema:index:create", injector.Inject(&handler.SchemaIndexCreateHandler{}))
+	r.Map("schema:index:delete", injector.Inject(&handler.SchemaIndexDeleteHandler{}))
 	r.Map("schema:access", injector.Inject(&handler.SchemaAccessHandler{}))
 	r.Map("schema:default_access", injector.Inject(&handler.SchemaDefaultAccessHandler{}))
 	r.Map("schema:field_access:get", injector.Inject(&handler.SchemaFieldAccessGetHandler{}))
@@ -325,6 +354,15 @@ func main() {
 }
 
 func ensureDB(config skyconfig.Configuration) func() (skydb.Conn, error) {
+	if config.DB.ImplName == "pq" && len(config.DB.Replicas) > 0 {
+		log.Infof("Routing reads to %d database replicas", len(config.DB.Replicas))
+		pq.SetReplicas(
+			config.DB.Option,
+			config.DB.Replicas,
+			time.Duration(config.DB.ReplicaHealthCheckInterval)*time.Second,
+		)
+	}
+
 	connOpener := func() (skydb.Conn, error) {
 		return skydb.Open(
 			context.Background(),
@@ -428,6 +466,85 @@ func initDevice(config skyconfig.Configuration, connOpener func() (skydb.Conn, e
 	conn.DeleteEmptyDevicesByTime(time.Now().AddDate(0, 0, -1))
 }
 
+// initRecordExpirySweeper schedules the deletion of expired records. The
+// sweeper deletes records as the master key, so that hooks can delete
+// any record.
+func initRecordExpirySweeper(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), cronjob *cron.Cron, hookRegistry *hook.Registry) {
+	if config.App.RecordExpirySweepInterval == 0 {
+		log.Infof("Record expiry sweeper is disabled.")
+		return
+	}
+
+	sweeper := &recordutil.ExpiredRecordSweeper{
+		ConnOpener:   connOpener,
+		HookRegistry: hookRegistry,
+		BatchSize:    uint64(config.App.RecordExpirySweepBatchSize),
+	}
+	spec := fmt.Sprintf("@every %ds", config.App.RecordExpirySweepInterval)
+	err := cronjob.AddFunc(spec, func() {
+		ctx := context.WithValue(context.Background(), router.AccessKeyTypeContextKey, router.MasterAccessKey)
+		if err := sweeper.Sweep(ctx); err != nil {
+			log.Errorf("Failed to sweep expired records: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule record expiry sweeper: %v", err)
+	}
+}
+
+// initRecordChangePruner schedules the pruning of record changes older
+// than the retention.
+func initRecordChangePruner(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), cronjob *cron.Cron) {
+	if config.App.RecordChangeRetention == 0 {
+		log.Infof("Record change pruning is disabled.")
+		return
+	}
+
+	retention := time.Duration(config.App.RecordChangeRetention) * time.Second
+	err := cronjob.AddFunc("@hourly", func() {
+		conn, err := connOpener()
+		if err != nil {
+			log.Errorf("Failed to open database connection to prune record changes: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		pruner, ok := conn.(skydb.RecordChangeLogConn)
+		if !ok {
+			return
+		}
+		if err := pruner.PruneRecordChanges(time.Now().Add(-retention)); err != nil {
+			log.Errorf("Failed to prune record changes: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule record change pruning: %v", err)
+	}
+}
+
+// initQueryCache returns the cache of query results and records, which
+// is invalidated by the record events of the database and expires after
+// the configured max age. The returned cache does not cache anything if
+// it is disabled.
+func initQueryCache(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) *skydbcache.Cache {
+	if config.DB.QueryCacheSize == 0 {
+		log.Infof("Query cache is disabled.")
+		return &skydbcache.Cache{}
+	}
+
+	conn, err := connOpener()
+	if err != nil {
+		log.Fatalf("Failed to open database connection for query cache: %v", err)
+	}
+
+	cache := skydbcache.NewCache(skydbcache.NewLRUStore(config.DB.QueryCacheSize))
+	cache.MaxAge = time.Duration(config.DB.QueryCacheMaxAge) * time.Second
+	if err := cache.Listen(conn); err != nil {
+		log.Fatalf("Failed to subscribe to record events for query cache: %v", err)
+	}
+	return cache
+}
+
 func initPushSender(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) push.Sender {
 	routeSender := push.NewRouteSender()
 	if config.APNS.Enable {
@@ -130,6 +130,7 @@ func TestSignupHandler(t *testing.T) {
 
 		db := mock_skydb.NewMockTxDatabase(ctrl)
 		db.EXPECT().Save(gomock.Any()).Return(nil).AnyTimes()
+		db.EXPECT().RemoteColumnTypes("user").Return(nil, nil).AnyTimes()
 		handler := &SignupHandler{
 			TokenStore:     &tokenStore,
 			AuthRecordKeys: [][]string{[]string{"username"}, []string{"email"}},
@@ -0,0 +1,490 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// hooksMode specifies how hooks are executed when modifying records
+// matching a predicate.
+type hooksMode string
+
+const (
+	// recordHooksMode modifies the records one at a time. The before
+	// hooks, the modification and the after hooks of a record complete
+	// before the next record is modified.
+	recordHooksMode hooksMode = "record"
+
+	// batchHooksMode modifies the records in a single batch. The before
+	// hooks of all records are executed before any record is modified,
+	// and the after hooks are executed after all records are modified.
+	batchHooksMode hooksMode = "batch"
+)
+
+// maxRecordsWhere is the maximum number of records modified by a request
+// with a predicate.
+const maxRecordsWhere = 1000
+
+type recordWherePayload struct {
+	RecordType   string                 `mapstructure:"record_type"`
+	RawPredicate []interface{}          `mapstructure:"predicate"`
+	RawData      map[string]interface{} `mapstructure:"data"`
+	DryRun       bool                   `mapstructure:"dry_run"`
+	Hooks        hooksMode              `mapstructure:"hooks"`
+
+	Query skydb.Query
+	Data  map[string]interface{}
+}
+
+func (payload *recordWherePayload) Decode(data map[string]interface{}, parser *QueryParser) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("record_type is required", []string{"record_type"})
+	}
+
+	if len(payload.RawPredicate) == 0 {
+		return skyerr.NewInvalidArgument("predicate is required", []string{"predicate"})
+	}
+
+	rawQuery := map[string]interface{}{
+		"record_type": payload.RecordType,
+		"predicate":   payload.RawPredicate,
+	}
+	if err := parser.queryFromRaw(rawQuery, &payload.Query); err != nil {
+		return err
+	}
+
+	if payload.RawData != nil {
+		for key := range payload.RawData {
+			if key == "" || key[0] == '_' {
+				return skyerr.NewInvalidArgument(
+					"reserved field cannot be updated",
+					[]string{key},
+				)
+			}
+		}
+
+		payload.Data = map[string]interface{}{}
+		if err := (*skyconv.MapData)(&payload.Data).FromMap(payload.RawData); err != nil {
+			return skyerr.NewError(skyerr.InvalidArgument, err.Error())
+		}
+		if err := skyconv.ParseFieldOperations(payload.Data); err != nil {
+			return skyerr.NewError(skyerr.InvalidArgument, err.Error())
+		}
+	}
+
+	return payload.Validate()
+}
+
+func (payload *recordWherePayload) Validate() skyerr.Error {
+	switch payload.Hooks {
+	case "":
+		payload.Hooks = recordHooksMode
+	case recordHooksMode, batchHooksMode:
+	default:
+		return skyerr.NewInvalidArgument("unexpected hooks mode", []string{"hooks"})
+	}
+	return nil
+}
+
+// queryWritable returns the records matching the query which are writable
+// by the user. If count is true, only the number of records is returned.
+// It fails if more than maxRecordsWhere records match, so that a dry run
+// fails as the request would.
+func (payload *recordWherePayload) queryWritable(routerPayload *router.Payload, count bool) ([]skydb.Record, uint64, skyerr.Error) {
+	query := &payload.Query
+	query.ViewAsUser = routerPayload.AuthInfo
+	query.BypassAccessControl = routerPayload.HasMasterKey()
+	query.AccessLevel = skydb.WriteLevel
+
+	if !query.BypassAccessControl {
+		fieldACL, err := routerPayload.DBConn.GetRecordFieldAccess()
+		if err != nil {
+			return nil, 0, skyerr.MakeError(err)
+		}
+
+		visitor := &queryAccessVisitor{
+			FieldACL:   fieldACL,
+			RecordType: query.Type,
+			AuthInfo:   query.ViewAsUser,
+			ExpressionACLChecker: ExpressionACLChecker{
+				FieldACL:   fieldACL,
+				RecordType: query.Type,
+				AuthInfo:   routerPayload.AuthInfo,
+				Database:   routerPayload.Database,
+			},
+		}
+		query.Accept(visitor)
+		if err := visitor.Error(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	db := routerPayload.Database
+	if count {
+		n, err := db.QueryCount(query)
+		if err != nil {
+			return nil, 0, skyerr.MakeError(err)
+		}
+		if n > maxRecordsWhere {
+			return nil, 0, tooManyRecordsWhereError()
+		}
+		return nil, n, nil
+	}
+
+	limit := uint64(maxRecordsWhere + 1)
+	query.Limit = &limit
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, 0, skyerr.MakeError(err)
+	}
+	defer rows.Close()
+
+	records := []skydb.Record{}
+	for rows.Scan() {
+		records = append(records, rows.Record())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, skyerr.MakeError(err)
+	}
+	if len(records) > maxRecordsWhere {
+		return nil, 0, tooManyRecordsWhereError()
+	}
+	return records, uint64(len(records)), nil
+}
+
+// tooManyRecordsWhereError returns the error of a request matching more
+// than maxRecordsWhere records.
+func tooManyRecordsWhereError() skyerr.Error {
+	return skyerr.NewInvalidArgument(
+		fmt.Sprintf("more than %d records match the predicate", maxRecordsWhere),
+		[]string{"predicate"},
+	)
+}
+
+// modifyRecordsWhere calls modify with the first n records, either once
+// for each record or once for all records depending on the hooks mode.
+// When modify fails for a record, the records before it are already
+// modified, so the handlers return their results together with the error.
+func modifyRecordsWhere(mode hooksMode, n int, modify func(start, end int) skyerr.Error) skyerr.Error {
+	if mode == batchHooksMode {
+		return modify(0, n)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := modify(i, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+RecordUpdateWhereHandler updates the records matching a predicate with
+the specified data, which may contain field operators like record:save.
+Only records writable by the user are updated.
+
+Set `dry_run` to count the records to be updated without updating them. The
+request fails if more than 1000 records match, in a dry run too.
+Set `hooks` to `batch` to execute the before hooks of all records before
+updating any record, instead of executing the hooks of each record in turn.
+If the request fails after some records are updated, the results of those
+records are returned with the error.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:update_where",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "order",
+    "predicate": [
+        "lt",
+        {"$type": "keypath", "$val": "_created_at"},
+        {"$type": "date", "$date": "2016-01-01T00:00:00Z"}
+    ],
+    "data": {
+        "archived": true
+    },
+    "hooks": "batch"
+}
+EOF
+*/
+type RecordUpdateWhereHandler struct {
+	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
+	AssetStore    asset.Store       `inject:"AssetStore"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
+	Authenticator router.Processor  `preprocessor:"authenticator"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
+	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
+	InjectDB      router.Processor  `preprocessor:"inject_db"`
+	RequireAuth   router.Processor  `preprocessor:"require_auth"`
+	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordUpdateWhereHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.RequireAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordUpdateWhereHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordUpdateWhereHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordWherePayload{}
+	parser := QueryParser{UserID: payload.AuthInfoID}
+	if err := p.Decode(payload.Data, &parser); err != nil {
+		response.Err = err
+		return
+	}
+
+	if len(p.Data) == 0 {
+		response.Err = skyerr.NewInvalidArgument("data is required", []string{"data"})
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	records, count, err := p.queryWritable(payload, p.DryRun)
+	if err != nil {
+		response.Err = err
+		return
+	}
+
+	response.Info = map[string]interface{}{"count": count}
+	if p.DryRun {
+		response.Result = []interface{}{}
+		return
+	}
+
+	resultFilter, resultErr := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if resultErr != nil {
+		response.Err = skyerr.MakeError(resultErr)
+		return
+	}
+
+	recordsToSave := make([]*skydb.Record, len(records))
+	for i, record := range records {
+		data := skydb.Data{}
+		for key, value := range p.Data {
+			data[key] = value
+		}
+		recordsToSave[i] = &skydb.Record{
+			ID:   record.ID,
+			Data: data,
+		}
+	}
+
+	if _, err := recordutil.ExtendRecordSchema(payload.Database, recordsToSave); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	results := make([]interface{}, 0, len(recordsToSave))
+	err = modifyRecordsWhere(p.Hooks, len(recordsToSave), func(start, end int) skyerr.Error {
+		req := recordutil.RecordModifyRequest{
+			Db:            payload.Database,
+			Conn:          payload.DBConn,
+			AssetStore:    h.AssetStore,
+			HookRegistry:  h.HookRegistry,
+			Cache:         h.QueryCache,
+			AuthInfo:      payload.AuthInfo,
+			RecordsToSave: recordsToSave[start:end],
+			UpdateOnly:    true,
+			WithMasterKey: payload.HasMasterKey(),
+			Context:       payload.Context,
+			ModifyAt:      timeNow(),
+		}
+		resp := recordutil.RecordModifyResponse{
+			ErrMap: map[skydb.RecordID]skyerr.Error{},
+		}
+
+		if err := recordutil.RecordSaveHandler(&req, &resp); err != nil {
+			return err
+		}
+
+		saved := resp.SavedRecords
+		for _, record := range recordsToSave[start:end] {
+			if err, ok := resp.ErrMap[record.ID]; ok {
+				log.WithFields(logrus.Fields{
+					"recordID": record.ID,
+					"err":      err,
+				}).Debugln("failed to update record")
+				results = append(results, newSerializedError(record.ID.String(), err))
+				continue
+			}
+			results = append(results, resultFilter.JSONResult(saved[0]))
+			saved = saved[1:]
+		}
+		return nil
+	})
+	response.Result = results
+	response.Err = err
+}
+
+/*
+RecordDeleteWhereHandler deletes the records matching a predicate. Only
+records writable by the user are deleted.
+
+Set `dry_run` to count the records to be deleted without deleting them. The
+request fails if more than 1000 records match, in a dry run too.
+Set `hooks` to `batch` to execute the before hooks of all records before
+deleting any record, instead of executing the hooks of each record in turn.
+If the request fails after some records are deleted, the results of those
+records are returned with the error.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:delete_where",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "order",
+    "predicate": [
+        "eq",
+        {"$type": "keypath", "$val": "archived"},
+        true
+    ],
+    "dry_run": true
+}
+EOF
+*/
+type RecordDeleteWhereHandler struct {
+	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
+	Authenticator router.Processor  `preprocessor:"authenticator"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
+	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
+	InjectDB      router.Processor  `preprocessor:"inject_db"`
+	RequireAuth   router.Processor  `preprocessor:"require_auth"`
+	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordDeleteWhereHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.RequireAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordDeleteWhereHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordDeleteWhereHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordWherePayload{}
+	parser := QueryParser{UserID: payload.AuthInfoID}
+	if err := p.Decode(payload.Data, &parser); err != nil {
+		response.Err = err
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	records, count, err := p.queryWritable(payload, p.DryRun)
+	if err != nil {
+		response.Err = err
+		return
+	}
+
+	response.Info = map[string]interface{}{"count": count}
+	if p.DryRun {
+		response.Result = []interface{}{}
+		return
+	}
+
+	recordIDs := make([]skydb.RecordID, len(records))
+	for i, record := range records {
+		recordIDs[i] = record.ID
+	}
+
+	results := make([]interface{}, 0, len(recordIDs))
+	err = modifyRecordsWhere(p.Hooks, len(recordIDs), func(start, end int) skyerr.Error {
+		req := recordutil.RecordModifyRequest{
+			Db:                payload.Database,
+			Conn:              payload.DBConn,
+			HookRegistry:      h.HookRegistry,
+			Cache:             h.QueryCache,
+			RecordIDsToDelete: recordIDs[start:end],
+			WithMasterKey:     payload.HasMasterKey(),
+			Context:           payload.Context,
+			AuthInfo:          payload.AuthInfo,
+			ModifyAt:          timeNow(),
+		}
+		resp := recordutil.RecordModifyResponse{
+			ErrMap: map[skydb.RecordID]skyerr.Error{},
+		}
+
+		if err := recordutil.RecordDeleteHandler(&req, &resp); err != nil {
+			return err
+		}
+
+		for _, recordID := range recordIDs[start:end] {
+			if err, ok := resp.ErrMap[recordID]; ok {
+				log.WithFields(logrus.Fields{
+					"recordID": recordID,
+					"err":      err,
+				}).Debugln("failed to delete record")
+				results = append(results, newSerializedError(recordID.String(), err))
+				continue
+			}
+			results = append(results, struct {
+				ID   skydb.RecordID `json:"_id"`
+				Type string         `json:"_type"`
+			}{recordID, "record"})
+		}
+		return nil
+	})
+	response.Result = results
+	response.Err = err
+}
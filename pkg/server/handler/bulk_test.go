@@ -0,0 +1,349 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// predicateDatabase is a MapDB which supports querying records by
+// equality of a field, with access control of the required level.
+type predicateDatabase struct {
+	*skydbtest.MapDB
+}
+
+func (db *predicateDatabase) match(query *skydb.Query) []skydb.Record {
+	field := query.Predicate.Children[0].(skydb.Expression).Value.(string)
+	value := query.Predicate.Children[1].(skydb.Expression).Value
+
+	level := query.AccessLevel
+	if level == "" {
+		level = skydb.ReadLevel
+	}
+
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type != query.Type || record.Get(field) != value {
+			continue
+		}
+		if !query.BypassAccessControl && !record.Accessible(query.ViewAsUser, level) {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID.Key < records[j].ID.Key
+	})
+	return records
+}
+
+func (db *predicateDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	return skydb.NewRows(skydb.NewMemoryRows(db.match(query))), nil
+}
+
+func (db *predicateDatabase) QueryCount(query *skydb.Query) (uint64, error) {
+	return uint64(len(db.match(query))), nil
+}
+
+// failingFieldAccessConn is a MapConn which fails to get the field ACL
+// after it is got a number of times.
+type failingFieldAccessConn struct {
+	*skydbtest.MapConn
+	remaining int
+}
+
+func (conn *failingFieldAccessConn) GetRecordFieldAccess() (skydb.FieldACL, error) {
+	if conn.remaining == 0 {
+		return skydb.FieldACL{}, errors.New("field acl not available")
+	}
+	conn.remaining--
+	return conn.MapConn.GetRecordFieldAccess()
+}
+
+func TestRecordWhereHandlers(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC) }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("Given orders", t, func() {
+		db := &predicateDatabase{skydbtest.NewMapDB()}
+		for _, record := range []skydb.Record{
+			{
+				ID:      skydb.NewRecordID("order", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"status": "done"},
+			},
+			{
+				ID:      skydb.NewRecordID("order", "2"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"status": "done"},
+			},
+			{
+				ID:      skydb.NewRecordID("order", "3"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"status": "pending"},
+			},
+			{
+				ID:      skydb.NewRecordID("order", "readonly"),
+				OwnerID: "user1",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("user0", skydb.ReadLevel),
+				},
+				Data: skydb.Data{"status": "done"},
+			},
+		} {
+			r := record
+			So(db.Save(&r), ShouldBeNil)
+		}
+
+		events := []string{}
+		registry := hook.NewRegistry()
+		recordEvent := func(event string) hook.Func {
+			return func(ctx context.Context, record *skydb.Record, original *skydb.Record) skyerr.Error {
+				events = append(events, event+" "+record.ID.String())
+				return nil
+			}
+		}
+		registry.Register(hook.BeforeSave, "order", recordEvent("beforeSave"))
+		registry.Register(hook.AfterSave, "order", recordEvent("afterSave"))
+		registry.Register(hook.BeforeDelete, "order", recordEvent("beforeDelete"))
+		registry.Register(hook.AfterDelete, "order", recordEvent("afterDelete"))
+
+		var conn skydb.Conn = skydbtest.NewMapConn()
+		injectPayload := func(p *router.Payload) {
+			p.Database = db
+			p.DBConn = conn
+			p.AuthInfo = &skydb.AuthInfo{ID: "user0"}
+		}
+		updateRouter := handlertest.NewSingleRouteRouter(&RecordUpdateWhereHandler{
+			HookRegistry: registry,
+		}, injectPayload)
+		deleteRouter := handlertest.NewSingleRouteRouter(&RecordDeleteWhereHandler{
+			HookRegistry: registry,
+		}, injectPayload)
+
+		Convey("counts writable records to update in dry run", func() {
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived"},
+				"dry_run": true
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [],
+				"info": {"count": 2}
+			}`)
+			So(db.RecordMap["order/1"].Data["status"], ShouldEqual, "done")
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("updates writable records with hooks per record", func() {
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived", "version": {"$inc": 1}}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["order/1"].Data["status"], ShouldEqual, "archived")
+			So(db.RecordMap["order/1"].Data["version"], ShouldEqual, 1)
+			So(db.RecordMap["order/2"].Data["status"], ShouldEqual, "archived")
+			So(db.RecordMap["order/3"].Data["status"], ShouldEqual, "pending")
+			So(db.RecordMap["order/readonly"].Data["status"], ShouldEqual, "done")
+			So(events, ShouldResemble, []string{
+				"beforeSave order/1",
+				"afterSave order/1",
+				"beforeSave order/2",
+				"afterSave order/2",
+			})
+		})
+
+		Convey("updates writable records with hooks in batch", func() {
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived"},
+				"hooks": "batch"
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(events, ShouldResemble, []string{
+				"beforeSave order/1",
+				"beforeSave order/2",
+				"afterSave order/1",
+				"afterSave order/2",
+			})
+		})
+
+		Convey("does not re-create records deleted before update", func() {
+			registry.Register(hook.BeforeSave, "order", func(ctx context.Context, record *skydb.Record, original *skydb.Record) skyerr.Error {
+				if record.ID.Key == "1" {
+					delete(db.RecordMap, "order/2")
+				}
+				return nil
+			})
+
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived"}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["order/1"].Data["status"], ShouldEqual, "archived")
+			So(db.RecordMap, ShouldNotContainKey, "order/2")
+		})
+
+		Convey("rejects update of too many records", func() {
+			for i := 0; i < maxRecordsWhere; i++ {
+				r := skydb.Record{
+					ID:      skydb.NewRecordID("order", fmt.Sprintf("bulk%d", i)),
+					OwnerID: "user0",
+					Data:    skydb.Data{"status": "done"},
+				}
+				So(db.Save(&r), ShouldBeNil)
+			}
+
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived"}
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(db.RecordMap["order/1"].Data["status"], ShouldEqual, "done")
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("rejects too many records in dry run", func() {
+			for i := 0; i < maxRecordsWhere; i++ {
+				r := skydb.Record{
+					ID:      skydb.NewRecordID("order", fmt.Sprintf("bulk%d", i)),
+					OwnerID: "user0",
+					Data:    skydb.Data{"status": "done"},
+				}
+				So(db.Save(&r), ShouldBeNil)
+			}
+
+			resp := deleteRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"dry_run": true
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "more than 1000 records match the predicate",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["predicate"]}
+				}
+			}`)
+		})
+
+		Convey("returns results of updated records with error", func() {
+			// the field ACL is got by the query, the result filter and
+			// the first update
+			conn = &failingFieldAccessConn{
+				MapConn:   skydbtest.NewMapConn(),
+				remaining: 3,
+			}
+
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"status": "archived"}
+			}`)
+			So(resp.Code, ShouldEqual, 500)
+
+			body := map[string]interface{}{}
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			So(body["error"], ShouldNotBeNil)
+			So(body["result"], ShouldHaveLength, 1)
+			So(body["result"].([]interface{})[0].(map[string]interface{})["_id"], ShouldEqual, "order/1")
+			So(db.RecordMap["order/1"].Data["status"], ShouldEqual, "archived")
+			So(db.RecordMap["order/2"].Data["status"], ShouldEqual, "done")
+		})
+
+		Convey("rejects update of reserved field", func() {
+			resp := updateRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"data": {"_owner_id": "user1"}
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "reserved field cannot be updated",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["_owner_id"]}
+				}
+			}`)
+		})
+
+		Convey("rejects unknown hooks mode", func() {
+			resp := deleteRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"hooks": "never"
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+
+		Convey("requires predicate", func() {
+			resp := deleteRouter.POST(`{
+				"record_type": "order"
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(db.RecordMap, ShouldHaveLength, 4)
+		})
+
+		Convey("deletes writable records", func() {
+			resp := deleteRouter.POST(`{
+				"record_type": "order",
+				"predicate": ["eq", {"$type": "keypath", "$val": "status"}, "done"],
+				"hooks": "batch"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [
+					{"_id": "order/1", "_type": "record"},
+					{"_id": "order/2", "_type": "record"}
+				],
+				"info": {"count": 2}
+			}`)
+			So(db.RecordMap, ShouldNotContainKey, "order/1")
+			So(db.RecordMap, ShouldNotContainKey, "order/2")
+			So(db.RecordMap, ShouldContainKey, "order/readonly")
+			So(events, ShouldResemble, []string{
+				"beforeDelete order/1",
+				"beforeDelete order/2",
+				"afterDelete order/1",
+				"afterDelete order/2",
+			})
+		})
+	})
+}
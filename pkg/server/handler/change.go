@@ -0,0 +1,199 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// defaultRecordChangesLimit is the maximum number of changes returned
+// by record:changes if limit is not specified.
+const defaultRecordChangesLimit = 100
+
+type recordChangesPayload struct {
+	RecordType  string `mapstructure:"record_type"`
+	ResumeToken string `mapstructure:"resume_token"`
+	Limit       uint64 `mapstructure:"limit"`
+	After       skydb.RecordChangeCursor
+}
+
+func (payload *recordChangesPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordChangesPayload) Validate() skyerr.Error {
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"record_type"})
+	}
+
+	if payload.ResumeToken != "" {
+		after, err := parseResumeToken(payload.ResumeToken)
+		if err != nil {
+			return skyerr.NewInvalidArgument("invalid resume token", []string{"resume_token"})
+		}
+		payload.After = after
+	}
+
+	if payload.Limit == 0 {
+		payload.Limit = defaultRecordChangesLimit
+	}
+	return nil
+}
+
+// parseResumeToken parses the resume token formatted by
+// formatResumeToken.
+func parseResumeToken(token string) (skydb.RecordChangeCursor, error) {
+	cursor := skydb.RecordChangeCursor{}
+	parts := strings.Split(token, ":")
+	if len(parts) != 2 {
+		return cursor, fmt.Errorf("malformed resume token %q", token)
+	}
+
+	var err error
+	if cursor.TxID, err = strconv.ParseInt(parts[0], 10, 64); err != nil || cursor.TxID < 0 {
+		return cursor, fmt.Errorf("malformed resume token %q", token)
+	}
+	if cursor.ID, err = strconv.ParseInt(parts[1], 10, 64); err != nil || cursor.ID < 0 {
+		return cursor, fmt.Errorf("malformed resume token %q", token)
+	}
+	return cursor, nil
+}
+
+// formatResumeToken formats the cursor of a change as a resume token.
+func formatResumeToken(cursor skydb.RecordChangeCursor) string {
+	return fmt.Sprintf("%d:%d", cursor.TxID, cursor.ID)
+}
+
+/*
+RecordChangesHandler returns the records of a record type changed since
+the resume token, in the order of the changes. Only the latest change to
+each record is returned. A deleted record, including one moved to trash,
+is returned as a tombstone without the record.
+
+The resume token of the last change is returned in info. Clients should
+keep the token and send it in the next request to receive further
+changes, until no changes are returned. The first request is sent
+without a resume token. If deletions after the resume token have been
+pruned, the request fails and clients should start over without a
+resume token.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:changes",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "note",
+    "resume_token": "1042:42",
+    "limit": 100
+}
+EOF
+*/
+type RecordChangesHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordChangesHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordChangesHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordChangesHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordChangesPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := payload.Database.(skydb.ChangeFeedDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support record changes")
+		return
+	}
+
+	query := skydb.Query{
+		Type:                p.RecordType,
+		Limit:               &p.Limit,
+		ViewAsUser:          payload.AuthInfo,
+		BypassAccessControl: payload.HasMasterKey(),
+	}
+	changes, err := db.GetRecordChanges(&query, p.After)
+	if err == skydb.ErrRecordChangesPruned {
+		response.Err = skyerr.NewInvalidArgument("changes after the resume token have been pruned", []string{"resume_token"})
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	resumeToken := p.ResumeToken
+	results := make([]interface{}, len(changes))
+	for i, change := range changes {
+		result := map[string]interface{}{
+			"id":         change.RecordID.String(),
+			"operation":  change.Operation,
+			"changed_at": change.ChangedAt,
+		}
+		if change.Record != nil {
+			result["record"] = resultFilter.JSONResult(change.Record)
+		}
+		results[i] = result
+		resumeToken = formatResumeToken(change.Cursor())
+	}
+
+	response.Result = results
+	response.Info = map[string]interface{}{
+		"resume_token": resumeToken,
+	}
+}
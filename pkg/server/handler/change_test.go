@@ -0,0 +1,221 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// changeFeedDatabase is a MapDB returning changes from a fixed list,
+// which is assumed to contain only the latest change to each record
+type changeFeedDatabase struct {
+	*skydbtest.MapDB
+	changes   []skydb.RecordChange
+	pruned    skydb.RecordChangeCursor
+	lastQuery *skydb.Query
+}
+
+func cursorBefore(a, b skydb.RecordChangeCursor) bool {
+	return a.TxID < b.TxID || (a.TxID == b.TxID && a.ID < b.ID)
+}
+
+func (db *changeFeedDatabase) GetRecordChanges(query *skydb.Query, after skydb.RecordChangeCursor) ([]skydb.RecordChange, error) {
+	db.lastQuery = query
+
+	if after != (skydb.RecordChangeCursor{}) && cursorBefore(after, db.pruned) {
+		return nil, skydb.ErrRecordChangesPruned
+	}
+
+	changes := []skydb.RecordChange{}
+	for _, change := range db.changes {
+		if change.RecordID.Type != query.Type || !cursorBefore(after, change.Cursor()) {
+			continue
+		}
+		if query.Limit != nil && uint64(len(changes)) >= *query.Limit {
+			break
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func TestRecordChangesHandler(t *testing.T) {
+	changedAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	Convey("Given a Database with record changes", t, func() {
+		db := &changeFeedDatabase{
+			MapDB: skydbtest.NewMapDB(),
+			changes: []skydb.RecordChange{
+				{
+					ID:        3,
+					TxID:      10,
+					RecordID:  skydb.NewRecordID("note", "1"),
+					Operation: skydb.RecordCreateOperation,
+					Record: &skydb.Record{
+						ID:      skydb.NewRecordID("note", "1"),
+						OwnerID: "user0",
+						Data:    skydb.Data{"content": "created"},
+					},
+					ChangedAt: changedAt,
+				},
+				{
+					ID:        4,
+					TxID:      10,
+					RecordID:  skydb.NewRecordID("comment", "1"),
+					Operation: skydb.RecordCreateOperation,
+					Record: &skydb.Record{
+						ID:      skydb.NewRecordID("comment", "1"),
+						OwnerID: "user0",
+					},
+					ChangedAt: changedAt,
+				},
+				{
+					ID:        5,
+					TxID:      11,
+					RecordID:  skydb.NewRecordID("note", "0"),
+					Operation: skydb.RecordUpdateOperation,
+					Record: &skydb.Record{
+						ID:      skydb.NewRecordID("note", "0"),
+						OwnerID: "user0",
+						Data:    skydb.Data{"content": "updated"},
+					},
+					ChangedAt: changedAt,
+				},
+				{
+					ID:        7,
+					TxID:      12,
+					RecordID:  skydb.NewRecordID("note", "2"),
+					Operation: skydb.RecordDeleteOperation,
+					ChangedAt: changedAt,
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordChangesHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{ID: "user0"}
+		})
+
+		Convey("returns all changes without resume token", func() {
+			resp := r.POST(`{"record_type": "note"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"id": "note/1",
+					"operation": "create",
+					"changed_at": "2017-01-02T03:04:05Z",
+					"record": {
+						"_id": "note/1",
+						"_type": "record",
+						"_access": null,
+						"_ownerID": "user0",
+						"content": "created"
+					}
+				}, {
+					"id": "note/0",
+					"operation": "update",
+					"changed_at": "2017-01-02T03:04:05Z",
+					"record": {
+						"_id": "note/0",
+						"_type": "record",
+						"_access": null,
+						"_ownerID": "user0",
+						"content": "updated"
+					}
+				}, {
+					"id": "note/2",
+					"operation": "delete",
+					"changed_at": "2017-01-02T03:04:05Z"
+				}],
+				"info": {"resume_token": "12:7"}
+			}`)
+
+			So(db.lastQuery.ViewAsUser.ID, ShouldEqual, "user0")
+			So(db.lastQuery.BypassAccessControl, ShouldBeFalse)
+			So(*db.lastQuery.Limit, ShouldEqual, defaultRecordChangesLimit)
+		})
+
+		Convey("returns changes after resume token", func() {
+			resp := r.POST(`{"record_type": "note", "resume_token": "10:3", "limit": 1}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"id": "note/0",
+					"operation": "update",
+					"changed_at": "2017-01-02T03:04:05Z",
+					"record": {
+						"_id": "note/0",
+						"_type": "record",
+						"_access": null,
+						"_ownerID": "user0",
+						"content": "updated"
+					}
+				}],
+				"info": {"resume_token": "11:5"}
+			}`)
+		})
+
+		Convey("returns the same resume token without changes", func() {
+			resp := r.POST(`{"record_type": "note", "resume_token": "12:7"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [],
+				"info": {"resume_token": "12:7"}
+			}`)
+		})
+
+		Convey("returns changes without resume token after pruning", func() {
+			db.pruned = skydb.RecordChangeCursor{TxID: 10, ID: 4}
+			resp := r.POST(`{"record_type": "note", "limit": 1}`)
+			So(resp.Code, ShouldEqual, 200)
+		})
+
+		Convey("rejects resume token before pruned changes", func() {
+			db.pruned = skydb.RecordChangeCursor{TxID: 10, ID: 4}
+			resp := r.POST(`{"record_type": "note", "resume_token": "10:3"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "changes after the resume token have been pruned",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["resume_token"]}
+				}
+			}`)
+		})
+
+		Convey("rejects invalid resume token", func() {
+			resp := r.POST(`{"record_type": "note", "resume_token": "7"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "invalid resume token",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["resume_token"]}
+				}
+			}`)
+		})
+
+		Convey("requires record type", func() {
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+	})
+}
@@ -0,0 +1,347 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+type recordHistoryPayload struct {
+	RawID      string `mapstructure:"id"`
+	RevisionID int64  `mapstructure:"revision_id"`
+	RecordID   skydb.RecordID
+}
+
+func (payload *recordHistoryPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordHistoryPayload) Validate() skyerr.Error {
+	if payload.RawID == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"id"})
+	}
+
+	ss := strings.SplitN(payload.RawID, "/", 2)
+	if len(ss) == 1 {
+		return skyerr.NewInvalidArgument(
+			`record: "_id" should be of format '{type}/{id}', got "`+payload.RawID+`"`,
+			[]string{"id"},
+		)
+	}
+	payload.RecordID = skydb.NewRecordID(ss[0], ss[1])
+	return nil
+}
+
+// checkRevisionAccess checks whether the user has the access level on the
+// record. The access is determined by the ACL of the current record, or
+// by that of the latest revision if the record no longer exists.
+func checkRevisionAccess(payload *router.Payload, id skydb.RecordID, latest *skydb.RecordRevision, level skydb.RecordACLLevel) skyerr.Error {
+	if payload.HasMasterKey() {
+		return nil
+	}
+
+	record := skydb.Record{}
+	if err := payload.Database.Get(id, &record); err == nil {
+		if !record.Accessible(payload.AuthInfo, level) {
+			return skyerr.NewError(skyerr.PermissionDenied, "no permission to perform operation")
+		}
+		return nil
+	} else if err != skydb.ErrRecordNotFound {
+		return skyerr.MakeError(err)
+	}
+
+	if latest == nil {
+		return skyerr.NewError(skyerr.ResourceNotFound, "record not found")
+	}
+	if !latest.Accessible(payload.AuthInfo, level) {
+		return skyerr.NewError(skyerr.PermissionDenied, "no permission to perform operation")
+	}
+	return nil
+}
+
+/*
+RecordHistoryHandler lists the revisions of a record, with the latest
+revision first. The record of each revision is the state of the record
+after the operation, or before the deletion for a delete operation. A
+record existing before history is enabled has a baseline revision with
+its state before its first change.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:history",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "id": "note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8"
+}
+EOF
+*/
+type RecordHistoryHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordHistoryHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordHistoryHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordHistoryHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordHistoryPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := payload.Database.(skydb.RecordHistoryDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support record history")
+		return
+	}
+
+	revisions, err := db.GetRecordRevisions(p.RecordID)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	var latest *skydb.RecordRevision
+	if len(revisions) > 0 {
+		latest = &revisions[0]
+	}
+	if err := checkRevisionAccess(payload, p.RecordID, latest, skydb.ReadLevel); err != nil {
+		response.Err = err
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	results := make([]interface{}, len(revisions))
+	for i, revision := range revisions {
+		record := skydb.Record{
+			ID:        revision.RecordID,
+			OwnerID:   revision.OwnerID,
+			ACL:       revision.ACL,
+			Data:      revision.Data,
+			UpdatedAt: revision.UpdatedAt,
+			UpdaterID: revision.UpdaterID,
+		}
+		results[i] = map[string]interface{}{
+			"revision_id": revision.ID,
+			"operation":   revision.Operation,
+			"record":      resultFilter.JSONResult(&record),
+		}
+	}
+
+	response.Result = results
+}
+
+/*
+RecordRevertHandler saves the record with the data and access control of
+the specified revision. Fields not in the revision are set to null. The
+record is saved like record:save, so that hooks are executed and the
+revert is kept in the history as well. A deleted record is re-created with
+its original owner.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:revert",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "id": "note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8",
+    "revision_id": 3
+}
+EOF
+*/
+type RecordRevertHandler struct {
+	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
+	AssetStore    asset.Store       `inject:"AssetStore"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
+	Authenticator router.Processor  `preprocessor:"authenticator"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
+	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
+	InjectDB      router.Processor  `preprocessor:"inject_db"`
+	RequireAuth   router.Processor  `preprocessor:"require_auth"`
+	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordRevertHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.RequireAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordRevertHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordRevertHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordHistoryPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	db, ok := payload.Database.(skydb.RecordHistoryDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support record history")
+		return
+	}
+
+	revision := skydb.RecordRevision{}
+	if err := db.GetRecordRevision(p.RecordID, p.RevisionID, &revision); err == skydb.ErrRevisionNotFound {
+		response.Err = skyerr.NewError(skyerr.ResourceNotFound, "revision not found")
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	// A deleted record is authorized against the latest revision, which
+	// is the record as it was deleted, rather than the revision to revert
+	// to.
+	revisions, err := db.GetRecordRevisions(p.RecordID)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	var latest *skydb.RecordRevision
+	if len(revisions) > 0 {
+		latest = &revisions[0]
+	}
+
+	if err := checkRevisionAccess(payload, p.RecordID, latest, skydb.WriteLevel); err != nil {
+		response.Err = err
+		return
+	}
+
+	schema, err := payload.Database.RemoteColumnTypes(p.RecordID.Type)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	// Fields removed from the record type since the revision are not
+	// reverted, so that reverting a record does not change the schema.
+	record := skydb.Record{
+		ID:   p.RecordID,
+		ACL:  revision.ACL,
+		Data: skydb.Data{},
+	}
+	for key, value := range revision.Data {
+		if _, ok := schema[key]; ok {
+			record.Data[key] = value
+		}
+	}
+	current := skydb.Record{}
+	if err := payload.Database.Get(p.RecordID, &current); err == nil {
+		for key := range current.Data {
+			if _, ok := record.Data[key]; !ok {
+				record.Data[key] = nil
+			}
+		}
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:            payload.Database,
+		Conn:          payload.DBConn,
+		AssetStore:    h.AssetStore,
+		HookRegistry:  h.HookRegistry,
+		Cache:         h.QueryCache,
+		AuthInfo:      payload.AuthInfo,
+		RecordsToSave: []*skydb.Record{&record},
+		OwnerIDs: map[skydb.RecordID]string{
+			p.RecordID: revision.OwnerID,
+		},
+		WithMasterKey: payload.HasMasterKey(),
+		Context:       payload.Context,
+		ModifyAt:      timeNow(),
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+
+	if err := recordutil.RecordSaveHandler(&req, &resp); err != nil {
+		log.Debugf("Failed to revert record: %v", err)
+		response.Err = err
+		return
+	}
+
+	if err, ok := resp.ErrMap[p.RecordID]; ok {
+		response.Err = err
+		return
+	}
+
+	response.Result = resultFilter.JSONResult(resp.SavedRecords[0])
+}
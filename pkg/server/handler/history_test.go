@@ -0,0 +1,425 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// historyDatabase is a MapDB keeping revisions of records of record
+// types with history enabled
+type historyDatabase struct {
+	*skydbtest.MapDB
+	enabled   map[string]bool
+	revisions []skydb.RecordRevision
+	commits   int
+}
+
+func (db *historyDatabase) Begin() error    { return nil }
+func (db *historyDatabase) Rollback() error { return nil }
+
+func (db *historyDatabase) Commit() error {
+	db.commits++
+	return nil
+}
+
+func newHistoryDatabase() *historyDatabase {
+	return &historyDatabase{
+		MapDB:     skydbtest.NewMapDB(),
+		enabled:   map[string]bool{"note": true},
+		revisions: []skydb.RecordRevision{},
+	}
+}
+
+func (db *historyDatabase) RecordHistoryEnabled(recordType string) (bool, error) {
+	return db.enabled[recordType], nil
+}
+
+func (db *historyDatabase) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	db.enabled[recordType] = enabled
+	return nil
+}
+
+func (db *historyDatabase) SaveRecordRevision(revision *skydb.RecordRevision) error {
+	revision.ID = int64(len(db.revisions) + 1)
+	db.revisions = append(db.revisions, *revision)
+	return nil
+}
+
+func (db *historyDatabase) HasRecordRevisions(id skydb.RecordID) (bool, error) {
+	for _, revision := range db.revisions {
+		if revision.RecordID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *historyDatabase) GetRecordRevisions(id skydb.RecordID) ([]skydb.RecordRevision, error) {
+	revisions := []skydb.RecordRevision{}
+	for i := len(db.revisions) - 1; i >= 0; i-- {
+		if db.revisions[i].RecordID == id {
+			revisions = append(revisions, db.revisions[i])
+		}
+	}
+	return revisions, nil
+}
+
+func (db *historyDatabase) GetRecordRevision(id skydb.RecordID, revisionID int64, revision *skydb.RecordRevision) error {
+	for _, r := range db.revisions {
+		if r.RecordID == id && r.ID == revisionID {
+			*revision = r
+			return nil
+		}
+	}
+	return skydb.ErrRevisionNotFound
+}
+
+func TestRecordHistoryHandlers(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC) }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("Given a Database with record history enabled", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := newHistoryDatabase()
+
+		injectUser := func(userID string) func(p *router.Payload) {
+			return func(p *router.Payload) {
+				p.DBConn = conn
+				p.Database = db
+				p.AuthInfo = &skydb.AuthInfo{
+					ID: userID,
+				}
+			}
+		}
+
+		save := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, injectUser("user0"))
+		save.POST(`{
+			"records": [{
+				"_id": "note/0",
+				"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+				"content": "first",
+				"category": "draft"
+			}]
+		}`)
+		save.POST(`{
+			"records": [{
+				"_id": "note/0",
+				"content": "second"
+			}]
+		}`)
+
+		Convey("keeps revisions on save", func() {
+			So(db.revisions, ShouldHaveLength, 2)
+			So(db.revisions[0].Operation, ShouldEqual, skydb.RecordCreateOperation)
+			So(db.revisions[0].Data, ShouldResemble, skydb.Data{
+				"content":  "first",
+				"category": "draft",
+			})
+			So(db.revisions[1].Operation, ShouldEqual, skydb.RecordUpdateOperation)
+			So(db.revisions[1].Data, ShouldResemble, skydb.Data{
+				"content":  "second",
+				"category": "draft",
+			})
+			So(db.revisions[1].UpdaterID, ShouldEqual, "user0")
+		})
+
+		Convey("saves revisions in the transaction of the save", func() {
+			So(db.commits, ShouldEqual, 2)
+		})
+
+		Convey("keeps baseline revision of record saved before history is enabled", func() {
+			db.enabled["comment"] = false
+			save.POST(`{
+				"records": [{
+					"_id": "comment/0",
+					"content": "before history"
+				}]
+			}`)
+			db.enabled["comment"] = true
+
+			r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, injectUser("user1"))
+			r.POST(`{
+				"records": [{
+					"_id": "comment/0",
+					"content": "after history"
+				}]
+			}`)
+
+			So(db.revisions, ShouldHaveLength, 4)
+			So(db.revisions[2].Operation, ShouldEqual, skydb.RecordBaselineOperation)
+			So(db.revisions[2].Data["content"], ShouldEqual, "before history")
+			So(db.revisions[2].UpdaterID, ShouldEqual, "user0")
+			So(db.revisions[3].Operation, ShouldEqual, skydb.RecordUpdateOperation)
+			So(db.revisions[3].Data["content"], ShouldEqual, "after history")
+			So(db.revisions[3].UpdaterID, ShouldEqual, "user1")
+		})
+
+		Convey("keeps revision on delete", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, injectUser("user0"))
+			r.POST(`{
+				"ids": ["note/0"]
+			}`)
+
+			So(db.revisions, ShouldHaveLength, 3)
+			So(db.revisions[2].Operation, ShouldEqual, skydb.RecordDeleteOperation)
+			So(db.revisions[2].Data["content"], ShouldEqual, "second")
+			So(db.revisions[2].UpdatedAt, ShouldResemble, time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC))
+		})
+
+		Convey("does not keep revisions of record type without history", func() {
+			save.POST(`{
+				"records": [{
+					"_id": "comment/0",
+					"content": "comment"
+				}]
+			}`)
+			So(db.revisions, ShouldHaveLength, 2)
+		})
+
+		Convey("lists revisions", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordHistoryHandler{}, injectUser("user0"))
+			resp := r.POST(`{
+				"id": "note/0"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"revision_id": 2,
+					"operation": "update",
+					"record": {
+						"_id": "note/0",
+						"_type": "record",
+						"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+						"_ownerID": "user0",
+						"_updated_at": "2017-01-02T03:04:05Z",
+						"_updated_by": "user0",
+						"content": "second",
+						"category": "draft"
+					}
+				}, {
+					"revision_id": 1,
+					"operation": "create",
+					"record": {
+						"_id": "note/0",
+						"_type": "record",
+						"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+						"_ownerID": "user0",
+						"_updated_at": "2017-01-02T03:04:05Z",
+						"_updated_by": "user0",
+						"content": "first",
+						"category": "draft"
+					}
+				}]
+			}`)
+		})
+
+		Convey("does not list revisions without read access", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordHistoryHandler{}, injectUser("user1"))
+			resp := r.POST(`{
+				"id": "note/0"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 102,
+					"message": "no permission to perform operation",
+					"name": "PermissionDenied"
+				}
+			}`)
+		})
+
+		Convey("reverts record to revision", func() {
+			save.POST(`{
+				"records": [{
+					"_id": "note/0",
+					"tags": "new"
+				}]
+			}`)
+
+			r := handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user0"))
+			resp := r.POST(`{
+				"id": "note/0",
+				"revision_id": 1
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"_id": "note/0",
+					"_type": "record",
+					"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+					"_ownerID": "user0",
+					"_created_at": "2017-01-02T03:04:05Z",
+					"_created_by": "user0",
+					"_updated_at": "2017-01-02T03:04:05Z",
+					"_updated_by": "user0",
+					"content": "first",
+					"category": "draft",
+					"tags": null
+				}
+			}`)
+			So(db.RecordMap["note/0"].Data["content"], ShouldEqual, "first")
+			So(db.revisions, ShouldHaveLength, 4)
+			So(db.revisions[3].Operation, ShouldEqual, skydb.RecordUpdateOperation)
+		})
+
+		Convey("reverts deleted record", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, injectUser("user0"))
+			r.POST(`{
+				"ids": ["note/0"]
+			}`)
+
+			r = handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user0"))
+			r.POST(`{
+				"id": "note/0",
+				"revision_id": 3
+			}`)
+			So(db.RecordMap["note/0"].Data["content"], ShouldEqual, "second")
+			So(db.revisions[3].Operation, ShouldEqual, skydb.RecordCreateOperation)
+		})
+
+		Convey("reverts deleted record with its original owner", func() {
+			save.POST(`{
+				"records": [{
+					"_id": "note/0",
+					"_access": [
+						{"relation": "$direct", "user_id": "user0", "level": "write"},
+						{"relation": "$direct", "user_id": "user1", "level": "write"}
+					]
+				}]
+			}`)
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, injectUser("user0"))
+			r.POST(`{
+				"ids": ["note/0"]
+			}`)
+
+			r = handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user1"))
+			r.POST(`{
+				"id": "note/0",
+				"revision_id": 3
+			}`)
+			So(db.RecordMap["note/0"].OwnerID, ShouldEqual, "user0")
+			So(db.RecordMap["note/0"].CreatorID, ShouldEqual, "user1")
+		})
+
+		Convey("does not revert deleted record without write access at deletion", func() {
+			save.POST(`{
+				"records": [{
+					"_id": "note/0",
+					"_access": [
+						{"relation": "$direct", "user_id": "user0", "level": "write"},
+						{"relation": "$direct", "user_id": "user1", "level": "write"}
+					]
+				}]
+			}`)
+			save.POST(`{
+				"records": [{
+					"_id": "note/0",
+					"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}]
+				}]
+			}`)
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, injectUser("user0"))
+			r.POST(`{
+				"ids": ["note/0"]
+			}`)
+
+			r = handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user1"))
+			resp := r.POST(`{
+				"id": "note/0",
+				"revision_id": 3
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 102,
+					"message": "no permission to perform operation",
+					"name": "PermissionDenied"
+				}
+			}`)
+			_, ok := db.RecordMap["note/0"]
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("does not revert fields removed from schema", func() {
+			So(db.DeleteSchema("note", "category"), ShouldBeNil)
+
+			r := handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user0"))
+			r.POST(`{
+				"id": "note/0",
+				"revision_id": 1
+			}`)
+			So(db.RecordMap["note/0"].Data["content"], ShouldEqual, "first")
+			So(db.RecordSchemaMap["note"], ShouldNotContainKey, "category")
+		})
+
+		Convey("does not revert without write access", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user1"))
+			resp := r.POST(`{
+				"id": "note/0",
+				"revision_id": 1
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 102,
+					"message": "no permission to perform operation",
+					"name": "PermissionDenied"
+				}
+			}`)
+			So(db.RecordMap["note/0"].Data["content"], ShouldEqual, "second")
+		})
+
+		Convey("returns not found for non-existent revision", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordRevertHandler{}, injectUser("user0"))
+			resp := r.POST(`{
+				"id": "note/0",
+				"revision_id": 10
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 110,
+					"message": "revision not found",
+					"name": "ResourceNotFound"
+				}
+			}`)
+		})
+	})
+
+	Convey("Given a Database not supporting record history", t, func() {
+		r := handlertest.NewSingleRouteRouter(&RecordHistoryHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = skydbtest.NewMapDB()
+		})
+
+		Convey("returns not supported", func() {
+			resp := r.POST(`{
+				"id": "note/0"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 111,
+					"message": "database does not support record history",
+					"name": "NotSupported"
+				}
+			}`)
+		})
+	})
+}
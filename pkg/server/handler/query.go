@@ -15,10 +15,13 @@
 package handler
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 
@@ -109,6 +112,14 @@ func (parser *QueryParser) predicateOperatorFromString(operatorString string) sk
 		return skydb.ILike
 	case "in":
 		return skydb.In
+	case "contains_any":
+		return skydb.ContainsAny
+	case "contains_all":
+		return skydb.ContainsAll
+	case "contains":
+		return skydb.Contains
+	case "has_key":
+		return skydb.HasKey
 	case "func":
 		return skydb.Functional
 	default:
@@ -201,8 +212,27 @@ func (parser *QueryParser) parseFunc(s []interface{}) (f skydb.Func, err error)
 	switch funcName {
 	case "distance":
 		f, err = parser.parseDistanceFunc(s[2:])
+	case "within", "intersects":
+		f, err = parser.parseGeometryFunc(funcName, s[2:])
+	case "bbox":
+		f, err = parser.parseBoundingBoxFunc(s[2:])
 	case "userRelation":
 		f, err = parser.parseUserRelationFunc(s[2:])
+	case "search":
+		f, err = parser.parseSearchFunc(s[2:])
+	case "sum":
+		f, err = parser.parseAggregateFunc(skydb.Sum, s[2:])
+	case "avg":
+		f, err = parser.parseAggregateFunc(skydb.Avg, s[2:])
+	case "min":
+		f, err = parser.parseAggregateFunc(skydb.Min, s[2:])
+	case "max":
+		f, err = parser.parseAggregateFunc(skydb.Max, s[2:])
+	case "count":
+		if len(s) != 2 {
+			return nil, fmt.Errorf("want 0 arguments for count func, got %d", len(s)-2)
+		}
+		f = skydb.CountFunc{}
 	case "":
 		return nil, errors.New("empty function name")
 	default:
@@ -234,6 +264,134 @@ func (parser *QueryParser) parseDistanceFunc(s []interface{}) (skydb.DistanceFun
 	}, nil
 }
 
+// parseGeometryFunc parses arguments of within and intersects function,
+// which takes the following form:
+//
+//     [ _key_path_ , { "$type": "geojson", "$val": _geojson_ } ]
+func (parser *QueryParser) parseGeometryFunc(funcName string, s []interface{}) (skydb.Func, error) {
+	if len(s) != 2 {
+		return nil, fmt.Errorf("want 2 arguments for %s func, got %d", funcName, len(s))
+	}
+
+	var field string
+	if err := skyconv.MapFrom(s[0], (*skyconv.MapKeyPath)(&field)); err != nil {
+		return nil, fmt.Errorf("invalid key path: %v", err)
+	}
+
+	var geometry skydb.Geometry
+	if err := skyconv.MapFrom(s[1], (*skyconv.MapGeometry)(&geometry)); err != nil {
+		return nil, fmt.Errorf("invalid geometry: %v", err)
+	}
+
+	if funcName == "intersects" {
+		return skydb.IntersectsFunc{Field: field, Geometry: geometry}, nil
+	}
+	return skydb.WithinFunc{Field: field, Geometry: geometry}, nil
+}
+
+// parseBoundingBoxFunc parses arguments of bbox function, which takes the
+// following form:
+//
+//     [ _key_path_ , _south_west_location_ , _north_east_location_ ]
+func (parser *QueryParser) parseBoundingBoxFunc(s []interface{}) (skydb.BoundingBoxFunc, error) {
+	emptyBoundingBoxFunc := skydb.BoundingBoxFunc{}
+	if len(s) != 3 {
+		return emptyBoundingBoxFunc, fmt.Errorf("want 3 arguments for bbox func, got %d", len(s))
+	}
+
+	var field string
+	if err := skyconv.MapFrom(s[0], (*skyconv.MapKeyPath)(&field)); err != nil {
+		return emptyBoundingBoxFunc, fmt.Errorf("invalid key path: %v", err)
+	}
+
+	var southWest, northEast skydb.Location
+	if err := skyconv.MapFrom(s[1], (*skyconv.MapLocation)(&southWest)); err != nil {
+		return emptyBoundingBoxFunc, fmt.Errorf("invalid location: %v", err)
+	}
+	if err := skyconv.MapFrom(s[2], (*skyconv.MapLocation)(&northEast)); err != nil {
+		return emptyBoundingBoxFunc, fmt.Errorf("invalid location: %v", err)
+	}
+
+	return skydb.BoundingBoxFunc{
+		Field:     field,
+		SouthWest: southWest,
+		NorthEast: northEast,
+	}, nil
+}
+
+// parseSearchFunc parses arguments of search function, which takes the
+// following form:
+//
+//     [ _key_path_ , _terms_ , { "language": "english", "mode": "plain" } ]
+//
+// The options are optional. Mode can be either "plain" (default), which
+// matches all words in terms, or "phrase", which matches terms as a phrase.
+func (parser *QueryParser) parseSearchFunc(s []interface{}) (skydb.SearchFunc, error) {
+	emptySearchFunc := skydb.SearchFunc{}
+	if len(s) != 2 && len(s) != 3 {
+		return emptySearchFunc, fmt.Errorf("want 2 or 3 arguments for search func, got %d", len(s))
+	}
+
+	var field string
+	if err := skyconv.MapFrom(s[0], (*skyconv.MapKeyPath)(&field)); err != nil {
+		return emptySearchFunc, fmt.Errorf("invalid key path: %v", err)
+	}
+
+	terms, ok := s[1].(string)
+	if !ok {
+		return emptySearchFunc, fmt.Errorf("got type(terms) = %T, want string", s[1])
+	}
+
+	f := skydb.SearchFunc{
+		Field:    field,
+		Terms:    terms,
+		Language: skydb.DefaultSearchLanguage,
+		Mode:     skydb.PlainSearch,
+	}
+
+	if len(s) == 3 {
+		options, ok := s[2].(map[string]interface{})
+		if !ok {
+			return emptySearchFunc, fmt.Errorf("got type(options) = %T, want map", s[2])
+		}
+
+		if language, ok := options["language"].(string); ok {
+			if !skydb.IsValidSearchLanguage(language) {
+				return emptySearchFunc, fmt.Errorf("invalid search language: %s", language)
+			}
+			f.Language = language
+		}
+
+		switch mode, _ := options["mode"].(string); mode {
+		case "", "plain":
+			f.Mode = skydb.PlainSearch
+		case "phrase":
+			f.Mode = skydb.PhraseSearch
+		default:
+			return emptySearchFunc, fmt.Errorf("unknown search mode: %s", mode)
+		}
+	}
+
+	return f, nil
+}
+
+func (parser *QueryParser) parseAggregateFunc(op skydb.AggregateOperator, s []interface{}) (skydb.AggregateFunc, error) {
+	emptyAggregateFunc := skydb.AggregateFunc{}
+	if len(s) != 1 {
+		return emptyAggregateFunc, fmt.Errorf("want 1 argument for aggregate func, got %d", len(s))
+	}
+
+	var field string
+	if err := skyconv.MapFrom(s[0], (*skyconv.MapKeyPath)(&field)); err != nil {
+		return emptyAggregateFunc, fmt.Errorf("invalid key path: %v", err)
+	}
+
+	return skydb.AggregateFunc{
+		Operator: op,
+		Field:    field,
+	}, nil
+}
+
 func (parser *QueryParser) parseUserRelationFunc(s []interface{}) (skydb.UserRelationFunc, error) {
 	emptyUserRelationFunc := skydb.UserRelationFunc{}
 	if len(s) != 2 {
@@ -299,6 +457,13 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 	if transientIncludes, ok := rawQuery["include"].(map[string]interface{}); ok {
 		query.ComputedKeys = map[string]skydb.Expression{}
 		for key, value := range transientIncludes {
+			if rawInclude, ok := value.(map[string]interface{}); ok && rawInclude["$type"] == "reverse" {
+				if query.ReverseIncludes == nil {
+					query.ReverseIncludes = map[string]skydb.ReverseInclude{}
+				}
+				query.ReverseIncludes[key] = parser.reverseIncludeFromRaw(key, rawInclude)
+				continue
+			}
 			query.ComputedKeys[key] = parser.parseExpression(value)
 		}
 	}
@@ -327,9 +492,170 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 		query.Limit = new(uint64)
 		*query.Limit = uint64(limit)
 	}
+
+	if aggregations, ok := rawQuery["aggregate"].(map[string]interface{}); ok {
+		query.Aggregations = map[string]skydb.Expression{}
+		for name, value := range aggregations {
+			if name == "" || strings.HasPrefix(name, "_") {
+				return skyerr.NewInvalidArgument(
+					fmt.Sprintf(`invalid aggregation name "%s"`, name),
+					[]string{"aggregate"},
+				)
+			}
+
+			expr := parser.parseExpression(value)
+			if expr.Type != skydb.Function || !skydb.IsAggregateFunc(expr.Value.(skydb.Func)) {
+				return skyerr.NewInvalidArgument(
+					fmt.Sprintf(`aggregation "%s" is not an aggregate function`, name),
+					[]string{"aggregate"},
+				)
+			}
+			query.Aggregations[name] = expr
+		}
+	}
+
+	mustDoSlice(rawQuery, "group_by", func(rawGroupBy []interface{}) skyerr.Error {
+		if len(query.Aggregations) == 0 {
+			return skyerr.NewInvalidArgument("group_by requires aggregate", []string{"group_by"})
+		}
+
+		query.GroupBy = make([]string, len(rawGroupBy))
+		for i, rawKeyPath := range rawGroupBy {
+			var keyPath string
+			if err := skyconv.MapFrom(rawKeyPath, (*skyconv.MapKeyPath)(&keyPath)); err != nil {
+				return skyerr.NewInvalidArgument("unexpected value in group_by", []string{"group_by"})
+			}
+			if _, ok := query.Aggregations[keyPath]; ok {
+				return skyerr.NewInvalidArgument(
+					fmt.Sprintf(`group_by key path "%s" conflicts with aggregation name`, keyPath),
+					[]string{"group_by"},
+				)
+			}
+			query.GroupBy[i] = keyPath
+		}
+		return nil
+	})
+
+	if rawCursor, ok := rawQuery["after"].(string); ok {
+		query.After = parser.cursorFromRaw(rawCursor, query)
+	}
+
+	if rawCursor, ok := rawQuery["before"].(string); ok {
+		query.Before = parser.cursorFromRaw(rawCursor, query)
+	}
 	return nil
 }
 
+// reverseIncludeFromRaw parses an include of the records referencing the
+// query results, which has the following form:
+//
+//     {
+//         "$type": "reverse",
+//         "record_type": "comment",
+//         "field": "post",
+//         "sort": [[{"$type": "keypath", "$val": "_created_at"}, "desc"]],
+//         "limit": 10
+//     }
+//
+// The method panics if the include is malformed.
+func (parser *QueryParser) reverseIncludeFromRaw(key string, rawInclude map[string]interface{}) skydb.ReverseInclude {
+	include := skydb.ReverseInclude{}
+	include.RecordType, _ = rawInclude["record_type"].(string)
+	include.Field, _ = rawInclude["field"].(string)
+	if include.RecordType == "" || include.Field == "" {
+		panic(skyerr.NewInvalidArgument(
+			fmt.Sprintf(`include "%s" requires record_type and field`, key),
+			[]string{"include"},
+		))
+	}
+
+	mustDoSlice(rawInclude, "sort", func(rawSorts []interface{}) skyerr.Error {
+		include.Sorts = parser.sortsFromRaw(rawSorts)
+		return nil
+	})
+
+	if limit, ok := rawInclude["limit"].(float64); ok {
+		include.Limit = new(uint64)
+		*include.Limit = uint64(limit)
+	}
+	return include
+}
+
+// jsonCursor is the serialized form of skydb.Cursor
+type jsonCursor struct {
+	Values []interface{} `json:"v"`
+	ID     string        `json:"id"`
+}
+
+// cursorFromRaw decodes a cursor string returned from encodeCursor.
+//
+// The cursor is validated against the specified query, the method panics
+// if the cursor is invalid.
+func (parser *QueryParser) cursorFromRaw(rawCursor string, query *skydb.Query) *skydb.Cursor {
+	data, err := base64.RawURLEncoding.DecodeString(rawCursor)
+	if err != nil {
+		panic(skyerr.NewInvalidArgument("malformed cursor", []string{"cursor"}))
+	}
+
+	c := jsonCursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		panic(skyerr.NewInvalidArgument("malformed cursor", []string{"cursor"}))
+	}
+
+	cursor := &skydb.Cursor{
+		Values: make([]interface{}, len(c.Values)),
+		ID:     c.ID,
+	}
+	for i, value := range c.Values {
+		cursor.Values[i] = skyconv.ParseLiteral(value)
+	}
+
+	if err := query.ValidateCursor(cursor); err != nil {
+		panic(err)
+	}
+	return cursor
+}
+
+// toLiteral converts a skydb data value to its serialized form, which
+// is the reverse of skyconv.ParseLiteral.
+func toLiteral(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return skyconv.ToMap(skyconv.MapTime(v))
+	case skydb.Reference:
+		return skyconv.ToMap(skyconv.MapReference(v))
+	case skydb.Location:
+		return skyconv.ToMap(skyconv.MapLocation(v))
+	case *skydb.Location:
+		return skyconv.ToMap((*skyconv.MapLocation)(v))
+	case skydb.Geometry:
+		return skyconv.ToMap(skyconv.MapGeometry(v))
+	case *skydb.Asset:
+		return skyconv.ToMap((*skyconv.MapAsset)(v))
+	case skydb.Unknown:
+		return skyconv.ToMap(skyconv.MapUnknown(v))
+	default:
+		return value
+	}
+}
+
+// encodeCursor encodes a cursor into an opaque string.
+func encodeCursor(cursor *skydb.Cursor) (string, error) {
+	c := jsonCursor{
+		Values: make([]interface{}, len(cursor.Values)),
+		ID:     cursor.ID,
+	}
+	for i, value := range cursor.Values {
+		c.Values[i] = toLiteral(value)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
 // execute do when if the value of key in m is []interface{}. If value exists
 // for key but its type is not []interface{} or do returns an error, it panics.
 func mustDoSlice(m map[string]interface{}, key string, do func(value []interface{}) skyerr.Error) {
@@ -523,6 +849,19 @@ func (c *ExpressionACLChecker) checkKeyPath(keyPath string, accessMode skydb.Fie
 	components := strings.Split(keyPath, ".")
 
 	var fields []skydb.FieldType
+	if len(components) > 1 {
+		schema, err := c.Database.RemoteColumnTypes(recordType)
+		if err != nil {
+			return skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		if schema[components[0]].Type == skydb.TypeJSON {
+			// A keypath into a JSON field refers to a value inside
+			// the field rather than a referenced record, so only the
+			// Field ACL of the JSON field itself applies.
+			components = components[:1]
+		}
+	}
+
 	if len(components) > 1 {
 		// Since the keypath is consists of multiple components, we have
 		// to check the column types to find the Field ACL setting for all
@@ -0,0 +1,215 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// maxBatchQueries is the maximum number of queries in a query batch.
+const maxBatchQueries = 20
+
+type recordQueryBatchPayload struct {
+	RawQueries map[string]map[string]interface{} `mapstructure:"queries"`
+}
+
+func (payload *recordQueryBatchPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordQueryBatchPayload) Validate() skyerr.Error {
+	if len(payload.RawQueries) == 0 {
+		return skyerr.NewInvalidArgument("queries is required", []string{"queries"})
+	}
+	if len(payload.RawQueries) > maxBatchQueries {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("cannot run more than %d queries in a batch", maxBatchQueries),
+			[]string{"queries"},
+		)
+	}
+	for name := range payload.RawQueries {
+		if name == "" {
+			return skyerr.NewInvalidArgument("query name cannot be empty", []string{"queries"})
+		}
+	}
+	return nil
+}
+
+// recordQueryBatchResult is the result of a query in a query batch, which
+// is serialized like the response of record:query.
+type recordQueryBatchResult struct {
+	Result interface{}            `json:"result,omitempty"`
+	Info   map[string]interface{} `json:"info,omitempty"`
+	Err    skyerr.Error           `json:"error,omitempty"`
+}
+
+/*
+RecordQueryBatchHandler runs several named queries in one request. Each
+query is specified like the payload of record:query, including the
+database_id of the query. The result of each query is keyed by the name
+of the query. A failed query does not fail the other queries of the batch.
+
+The queries are run one by one in a read-only transaction, in which they
+see the same snapshot of the database, if the database connection supports
+it. The queries are read from the primary database and not served by the
+query cache, which might hold results of a different state of the
+database.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:query_batch",
+    "access_token": "validToken",
+    "queries": {
+        "notes": {
+            "database_id": "_private",
+            "record_type": "note",
+            "limit": 10
+        },
+        "announcements": {
+            "record_type": "announcement",
+            "sort": [[{"$val": "_created_at", "$type": "keypath"}, "desc"]]
+        }
+    }
+}
+EOF
+*/
+type RecordQueryBatchHandler struct {
+	AssetStore    asset.Store       `inject:"AssetStore"`
+	AccessModel   skydb.AccessModel `inject:"AccessModel"`
+	Authenticator router.Processor  `preprocessor:"authenticator"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
+	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
+	InjectDB      router.Processor  `preprocessor:"inject_db"`
+	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+// Setup sets up the preprocessors of the handler. The database of each
+// query is injected by InjectDB when the query is run, instead of once for
+// the request.
+func (h *RecordQueryBatchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordQueryBatchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordQueryBatchHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordQueryBatchPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	fieldACL, err := payload.DBConn.GetRecordFieldAccess()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	names := make([]string, 0, len(p.RawQueries))
+	for name := range p.RawQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	query := func(rawQuery map[string]interface{}) recordQueryBatchResult {
+		return h.query(payload, rawQuery, fieldACL)
+	}
+	if conn, ok := payload.DBConn.(skydb.SnapshotConn); ok {
+		err := conn.BeginSnapshot()
+		if err == nil {
+			defer conn.Rollback()
+		} else if err != skydb.ErrDatabaseTxDidBegin {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		// a failed query does not abort the transaction for the queries
+		// after it
+		query = func(rawQuery map[string]interface{}) (result recordQueryBatchResult) {
+			err := conn.WithSavepoint(func() error {
+				result = h.query(payload, rawQuery, fieldACL)
+				if result.Err != nil {
+					return result.Err
+				}
+				return nil
+			})
+			if err != nil && result.Err == nil {
+				result = recordQueryBatchResult{Err: skyerr.MakeError(err)}
+			}
+			return
+		}
+	}
+
+	// The queries share the connection of the request, so they are run
+	// one by one.
+	result := map[string]interface{}{}
+	for _, name := range names {
+		result[name] = query(p.RawQueries[name])
+	}
+	response.Result = result
+}
+
+// query runs a query of the batch in the database specified by the query.
+func (h *RecordQueryBatchHandler) query(payload *router.Payload, rawQuery map[string]interface{}, fieldACL skydb.FieldACL) (result recordQueryBatchResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Panic occurred while running query of batch: %v", r)
+			result = recordQueryBatchResult{
+				Err: skyerr.NewErrorf(skyerr.UnexpectedError, "%v", r),
+			}
+		}
+	}()
+
+	queryPayload := *payload
+	queryPayload.Data = rawQuery
+	queryPayload.Database = nil
+	queryResponse := router.Response{}
+	if status := h.InjectDB.Preprocess(&queryPayload, &queryResponse); status != http.StatusOK {
+		return recordQueryBatchResult{Err: queryResponse.Err}
+	}
+
+	p := &recordQueryPayload{}
+	parser := QueryParser{UserID: payload.AuthInfoID}
+	if err := p.Decode(rawQuery, &parser); err != nil {
+		return recordQueryBatchResult{Err: err}
+	}
+
+	records, resultInfo, err := queryRecords(&queryPayload, queryPayload.Database, &p.Query, fieldACL, h.AssetStore, nil)
+	if err != nil {
+		return recordQueryBatchResult{Err: err}
+	}
+	return recordQueryBatchResult{Result: records, Info: resultInfo}
+}
@@ -0,0 +1,283 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// databaseIDProcessor injects the database specified by the database_id
+// of the payload.
+type databaseIDProcessor struct {
+	databases map[string]skydb.Database
+}
+
+func (p databaseIDProcessor) Preprocess(payload *router.Payload, response *router.Response) int {
+	databaseID, _ := payload.Data["database_id"].(string)
+	if databaseID == "" {
+		databaseID = "_public"
+	}
+
+	db, ok := p.databases[databaseID]
+	if !ok {
+		response.Err = skyerr.NewInvalidArgument("invalid database ID", []string{"database_id"})
+		return http.StatusBadRequest
+	}
+	payload.Database = db
+	return http.StatusOK
+}
+
+// concurrencyDatabase records the maximum number of queries run at the
+// same time.
+type concurrencyDatabase struct {
+	queryResultsDatabase
+	mutex         sync.Mutex
+	running       int
+	maxConcurrent int
+}
+
+func (db *concurrencyDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	db.mutex.Lock()
+	db.running++
+	if db.running > db.maxConcurrent {
+		db.maxConcurrent = db.running
+	}
+	db.mutex.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	db.mutex.Lock()
+	db.running--
+	db.mutex.Unlock()
+	return db.queryResultsDatabase.Query(query)
+}
+
+func TestRecordQueryBatchHandler(t *testing.T) {
+	Convey("RecordQueryBatchHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		publicDB := &queryResultsDatabase{
+			records: []skydb.Record{
+				{ID: skydb.NewRecordID("note", "0")},
+				{ID: skydb.NewRecordID("note", "1")},
+			},
+		}
+		privateDB := &queryResultsDatabase{
+			databaseID: "user0",
+			records: []skydb.Record{
+				{ID: skydb.NewRecordID("todo", "0"), DatabaseID: "user0"},
+			},
+		}
+
+		handler := &RecordQueryBatchHandler{
+			InjectDB: databaseIDProcessor{
+				databases: map[string]skydb.Database{
+					"_public":  publicDB,
+					"_private": privateDB,
+				},
+			},
+		}
+		r := handlertest.NewSingleRouteRouter(handler, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		Convey("runs queries of different databases", func() {
+			resp := r.POST(`{
+				"queries": {
+					"notes": {
+						"record_type": "note",
+						"count": true
+					},
+					"todos": {
+						"database_id": "_private",
+						"record_type": "todo"
+					}
+				}
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": {
+					"notes": {
+						"result": [{
+							"_type": "record",
+							"_id": "note/0",
+							"_access": null
+						}, {
+							"_type": "record",
+							"_id": "note/1",
+							"_access": null
+						}],
+						"info": {
+							"count": 2
+						}
+					},
+					"todos": {
+						"result": [{
+							"_type": "record",
+							"_id": "todo/0",
+							"_access": null
+						}]
+					}
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+		})
+
+		Convey("returns error of each failed query", func() {
+			resp := r.POST(`{
+				"queries": {
+					"notes": {
+						"record_type": "note"
+					},
+					"unknown": {
+						"database_id": "_unknown",
+						"record_type": "note"
+					}
+				}
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": {
+					"notes": {
+						"result": [{
+							"_type": "record",
+							"_id": "note/0",
+							"_access": null
+						}, {
+							"_type": "record",
+							"_id": "note/1",
+							"_access": null
+						}]
+					},
+					"unknown": {
+						"error": {
+							"code": 108,
+							"message": "invalid database ID",
+							"info": {
+								"arguments": ["database_id"]
+							},
+							"name": "InvalidArgument"
+						}
+					}
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+		})
+
+		Convey("rejects batch with too many queries", func() {
+			queries := map[string]interface{}{}
+			for i := 0; i <= maxBatchQueries; i++ {
+				queries[fmt.Sprintf("notes%d", i)] = map[string]interface{}{
+					"record_type": "note",
+				}
+			}
+			body, _ := json.Marshal(map[string]interface{}{"queries": queries})
+
+			resp := r.POST(string(body))
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "cannot run more than 20 queries in a batch",
+					"info": {
+						"arguments": ["queries"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("rejects batch without queries", func() {
+			resp := r.POST(`{
+				"queries": {}
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "queries is required",
+					"info": {
+						"arguments": ["queries"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
+
+// snapshotConn is a MapConn which records the snapshot transaction
+// begun.
+type snapshotConn struct {
+	*skydbtest.MapConn
+	began      bool
+	rolledBack bool
+	savepoints int
+}
+
+func (conn *snapshotConn) Begin() error         { return nil }
+func (conn *snapshotConn) Commit() error        { return nil }
+func (conn *snapshotConn) Rollback() error      { conn.rolledBack = true; return nil }
+func (conn *snapshotConn) BeginSnapshot() error { conn.began = true; return nil }
+
+func (conn *snapshotConn) WithSavepoint(do func() error) error {
+	conn.savepoints++
+	return do()
+}
+
+func TestRecordQueryBatchHandlerSnapshot(t *testing.T) {
+	Convey("RecordQueryBatchHandler with many queries", t, func() {
+		db := &concurrencyDatabase{}
+		conn := &snapshotConn{MapConn: skydbtest.NewMapConn()}
+		handler := &RecordQueryBatchHandler{
+			InjectDB: databaseIDProcessor{
+				databases: map[string]skydb.Database{
+					"_public": db,
+				},
+			},
+		}
+		r := handlertest.NewSingleRouteRouter(handler, func(p *router.Payload) {
+			p.DBConn = conn
+		})
+
+		queries := map[string]interface{}{}
+		for i := 0; i < maxBatchQueries; i++ {
+			queries[fmt.Sprintf("notes%d", i)] = map[string]interface{}{
+				"record_type": "note",
+			}
+		}
+		body, _ := json.Marshal(map[string]interface{}{"queries": queries})
+
+		Convey("runs queries one by one in a snapshot", func() {
+			resp := r.POST(string(body))
+			So(resp.Code, ShouldEqual, 200)
+			So(db.maxConcurrent, ShouldEqual, 1)
+			So(conn.began, ShouldBeTrue)
+			So(conn.savepoints, ShouldEqual, maxBatchQueries)
+			So(conn.rolledBack, ShouldBeTrue)
+		})
+	})
+}
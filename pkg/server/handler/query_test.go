@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -107,6 +108,324 @@ func TestQueryFromRaw(t *testing.T) {
 				},
 			})
 		})
+
+		Convey("functional predicate with search", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"func",
+					"search",
+					map[string]interface{}{"$type": "keypath", "$val": "content"},
+					"quick fox",
+					map[string]interface{}{"language": "english", "mode": "phrase"},
+				},
+				"sort": []interface{}{
+					[]interface{}{
+						[]interface{}{
+							"func",
+							"search",
+							map[string]interface{}{"$type": "keypath", "$val": "content"},
+							"quick fox",
+						},
+						"desc",
+					},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query, ShouldResemble, skydb.Query{
+				Type: "note",
+				Predicate: skydb.Predicate{
+					skydb.Functional,
+					[]interface{}{
+						skydb.Expression{
+							Type:  skydb.Function,
+							Value: skydb.SearchFunc{"content", "quick fox", "english", skydb.PhraseSearch},
+						},
+					},
+				},
+				Sorts: []skydb.Sort{
+					{
+						Expression: skydb.Expression{
+							Type:  skydb.Function,
+							Value: skydb.SearchFunc{"content", "quick fox", "simple", skydb.PlainSearch},
+						},
+						Order: skydb.Descending,
+					},
+				},
+			})
+		})
+
+		Convey("functional predicate with within", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "restaurant",
+				"predicate": []interface{}{
+					"func",
+					"within",
+					map[string]interface{}{"$type": "keypath", "$val": "location"},
+					map[string]interface{}{
+						"$type": "geojson",
+						"$val": map[string]interface{}{
+							"type":        "Polygon",
+							"coordinates": []interface{}{},
+						},
+					},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query.Predicate, ShouldResemble, skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{
+						Type: skydb.Function,
+						Value: skydb.WithinFunc{
+							Field: "location",
+							Geometry: skydb.Geometry{
+								"type":        "Polygon",
+								"coordinates": []interface{}{},
+							},
+						},
+					},
+				},
+			})
+		})
+
+		Convey("functional predicate with bbox", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "restaurant",
+				"predicate": []interface{}{
+					"func",
+					"bbox",
+					map[string]interface{}{"$type": "keypath", "$val": "location"},
+					map[string]interface{}{"$type": "geo", "$lng": float64(1), "$lat": float64(2)},
+					map[string]interface{}{"$type": "geo", "$lng": float64(3), "$lat": float64(4)},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query.Predicate, ShouldResemble, skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{
+						Type: skydb.Function,
+						Value: skydb.BoundingBoxFunc{
+							Field:     "location",
+							SouthWest: skydb.NewLocation(1, 2),
+							NorthEast: skydb.NewLocation(3, 4),
+						},
+					},
+				},
+			})
+		})
+
+		Convey("aggregations with group by", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "order",
+				"aggregate": map[string]interface{}{
+					"total": []interface{}{
+						"func",
+						"sum",
+						map[string]interface{}{"$type": "keypath", "$val": "amount"},
+					},
+					"orders": []interface{}{"func", "count"},
+				},
+				"group_by": []interface{}{
+					map[string]interface{}{"$type": "keypath", "$val": "category"},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query, ShouldResemble, skydb.Query{
+				Type: "order",
+				Aggregations: map[string]skydb.Expression{
+					"total": skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.AggregateFunc{skydb.Sum, "amount"},
+					},
+					"orders": skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.CountFunc{},
+					},
+				},
+				GroupBy: []string{"category"},
+			})
+		})
+
+		Convey("aggregation that is not aggregate function", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "order",
+				"aggregate": map[string]interface{}{
+					"amount": map[string]interface{}{"$type": "keypath", "$val": "amount"},
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
+		Convey("aggregate function in predicate", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "order",
+				"predicate": []interface{}{
+					"gt",
+					[]interface{}{
+						"func",
+						"max",
+						map[string]interface{}{"$type": "keypath", "$val": "amount"},
+					},
+					float64(100),
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+
+		Convey("list contains any", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"contains_any",
+					map[string]interface{}{"$type": "keypath", "$val": "tags"},
+					[]interface{}{"red", "green"},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query.Predicate, ShouldResemble, skydb.Predicate{
+				skydb.ContainsAny,
+				[]interface{}{
+					skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "tags",
+					},
+					skydb.Expression{
+						Type:  skydb.Literal,
+						Value: []interface{}{"red", "green"},
+					},
+				},
+			})
+		})
+
+		Convey("list contains all with non-array value", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"contains_all",
+					map[string]interface{}{"$type": "keypath", "$val": "tags"},
+					"red",
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+
+		Convey("json has key", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"has_key",
+					map[string]interface{}{"$type": "keypath", "$val": "settings.font"},
+					"size",
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query.Predicate, ShouldResemble, skydb.Predicate{
+				skydb.HasKey,
+				[]interface{}{
+					skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "settings.font",
+					},
+					skydb.Expression{
+						Type:  skydb.Literal,
+						Value: "size",
+					},
+				},
+			})
+		})
+
+		Convey("json contains null", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "note",
+				"predicate": []interface{}{
+					"contains",
+					map[string]interface{}{"$type": "keypath", "$val": "settings"},
+					nil,
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+
+		Convey("nested and reverse includes", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"include": map[string]interface{}{
+					"company": map[string]interface{}{"$type": "keypath", "$val": "author.company"},
+					"comments": map[string]interface{}{
+						"$type":       "reverse",
+						"record_type": "comment",
+						"field":       "post",
+						"sort": []interface{}{
+							[]interface{}{
+								map[string]interface{}{"$type": "keypath", "$val": "_created_at"},
+								"desc",
+							},
+						},
+						"limit": float64(3),
+					},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+
+			limit := uint64(3)
+			So(query, ShouldResemble, skydb.Query{
+				Type: "post",
+				ComputedKeys: map[string]skydb.Expression{
+					"company": skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "author.company",
+					},
+				},
+				ReverseIncludes: map[string]skydb.ReverseInclude{
+					"comments": skydb.ReverseInclude{
+						RecordType: "comment",
+						Field:      "post",
+						Sorts: []skydb.Sort{
+							{
+								Expression: skydb.Expression{
+									Type:  skydb.KeyPath,
+									Value: "_created_at",
+								},
+								Order: skydb.Descending,
+							},
+						},
+						Limit: &limit,
+					},
+				},
+			})
+		})
+
+		Convey("reverse include without field", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"include": map[string]interface{}{
+					"comments": map[string]interface{}{
+						"$type":       "reverse",
+						"record_type": "comment",
+					},
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
 	})
 
 }
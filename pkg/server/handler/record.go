@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
@@ -29,6 +30,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -91,6 +93,10 @@ type recordSavePayload struct {
 	// Records contains the successfully de-serialized record
 	Records []*skydb.Record
 
+	// ExpectedUpdatedAt contains the `_updated_at` of records as specified
+	// in the incoming records
+	ExpectedUpdatedAt map[skydb.RecordID]time.Time
+
 	// Errs is the array of de-serialization errors
 	Errs []skyerr.Error
 
@@ -127,6 +133,7 @@ func (payload *recordSavePayload) Validate() skyerr.Error {
 	payload.Errs = []skyerr.Error{}
 	payload.IncomingItems = []interface{}{}
 	payload.Records = []*skydb.Record{}
+	payload.ExpectedUpdatedAt = map[skydb.RecordID]time.Time{}
 	for _, recordMap := range payload.RawMaps {
 		var record skydb.Record
 		if err := payload.InitRecord(recordMap, &record); err != nil {
@@ -183,16 +190,53 @@ func (payload *recordSavePayload) InitRecord(m map[string]interface{}, r *skydb.
 		r.ACL = acl
 	}
 
+	var expectedUpdatedAt *time.Time
+	if updatedAtData, ok := m["_updated_at"]; ok && updatedAtData != nil {
+		updatedAt, err := parseExpectedUpdatedAt(updatedAtData)
+		if err != nil {
+			return skyerr.NewInvalidArgument(err.Error(), []string{"_updated_at"})
+		}
+		expectedUpdatedAt = &updatedAt
+	}
+
 	payload.purgeReservedKey(m)
 	data := map[string]interface{}{}
 	if err := (*skyconv.MapData)(&data).FromMap(m); err != nil {
 		return skyerr.NewError(skyerr.InvalidArgument, err.Error())
 	}
+	if err := skyconv.ParseFieldOperations(data); err != nil {
+		return skyerr.NewError(skyerr.InvalidArgument, err.Error())
+	}
 	r.Data = data
 
+	if expectedUpdatedAt != nil {
+		payload.ExpectedUpdatedAt[r.ID] = *expectedUpdatedAt
+	}
+
 	return nil
 }
 
+// parseExpectedUpdatedAt parses `_updated_at` of an incoming record, which
+// is either a RFC3339 string as returned in a saved record, or a date.
+func parseExpectedUpdatedAt(i interface{}) (time.Time, error) {
+	switch value := i.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("_updated_at is not a valid time: %s", value)
+		}
+		return t, nil
+	case map[string]interface{}:
+		var t time.Time
+		if err := skyconv.MapFrom(value, (*skyconv.MapTime)(&t)); err != nil {
+			return time.Time{}, fmt.Errorf("_updated_at is not a valid date: %v", err)
+		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("_updated_at must be a string or a date, got %T", i)
+	}
+}
+
 /*
 RecordSaveHandler is dummy implementation on save/modify Records
 curl -X POST -H "Content-Type: application/json" \
@@ -244,6 +288,7 @@ type RecordSaveHandler struct {
 	AccessModel    skydb.AccessModel  `inject:"AccessModel"`
 	EventSender    pluginEvent.Sender `inject:"PluginEventSender"`
 	AuthRecordKeys [][]string         `inject:"AuthRecordKeys"`
+	QueryCache     *skydbcache.Cache  `inject:"QueryCache"`
 	Authenticator  router.Processor   `preprocessor:"authenticator"`
 	DBConn         router.Processor   `preprocessor:"dbconn"`
 	InjectAuth     router.Processor   `preprocessor:"inject_auth"`
@@ -295,16 +340,18 @@ func (h *RecordSaveHandler) Handle(payload *router.Payload, response *router.Res
 	log.Debugf("Working with accessModel %v", h.AccessModel)
 
 	req := recordutil.RecordModifyRequest{
-		Db:            payload.Database,
-		Conn:          payload.DBConn,
-		AssetStore:    h.AssetStore,
-		HookRegistry:  h.HookRegistry,
-		AuthInfo:      payload.AuthInfo,
-		RecordsToSave: p.Records,
-		Atomic:        p.Atomic,
-		WithMasterKey: payload.HasMasterKey(),
-		Context:       payload.Context,
-		ModifyAt:      timeNow(),
+		Db:                payload.Database,
+		Conn:              payload.DBConn,
+		AssetStore:        h.AssetStore,
+		HookRegistry:      h.HookRegistry,
+		Cache:             h.QueryCache,
+		AuthInfo:          payload.AuthInfo,
+		RecordsToSave:     p.Records,
+		ExpectedUpdatedAt: p.ExpectedUpdatedAt,
+		Atomic:            p.Atomic,
+		WithMasterKey:     payload.HasMasterKey(),
+		Context:           payload.Context,
+		ModifyAt:          timeNow(),
 	}
 	resp := recordutil.RecordModifyResponse{
 		ErrMap: map[skydb.RecordID]skyerr.Error{},
@@ -439,6 +486,7 @@ EOF
 type RecordFetchHandler struct {
 	AssetStore    asset.Store       `inject:"AssetStore"`
 	AccessModel   skydb.AccessModel `inject:"AccessModel"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
 	Authenticator router.Processor  `preprocessor:"authenticator"`
 	DBConn        router.Processor  `preprocessor:"dbconn"`
 	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
@@ -481,7 +529,7 @@ func (h *RecordFetchHandler) Handle(payload *router.Payload, response *router.Re
 		return
 	}
 
-	fetcher := recordutil.NewRecordFetcher(db, payload.DBConn, payload.HasMasterKey())
+	fetcher := recordutil.NewCachedRecordFetcher(db, payload.DBConn, payload.HasMasterKey(), h.QueryCache)
 
 	results := make([]interface{}, p.ItemLen(), p.ItemLen())
 	for i, recordID := range p.RecordIDs {
@@ -538,6 +586,7 @@ EOF
 type RecordQueryHandler struct {
 	AssetStore    asset.Store       `inject:"AssetStore"`
 	AccessModel   skydb.AccessModel `inject:"AccessModel"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
 	Authenticator router.Processor  `preprocessor:"authenticator"`
 	DBConn        router.Processor  `preprocessor:"dbconn"`
 	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
@@ -569,14 +618,6 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		return
 	}
 
-	if payload.AuthInfo != nil {
-		p.Query.ViewAsUser = payload.AuthInfo
-	}
-
-	if payload.HasMasterKey() {
-		p.Query.BypassAccessControl = true
-	}
-
 	fieldACL := func() skydb.FieldACL {
 		acl, err := payload.DBConn.GetRecordFieldAccess()
 		if err != nil {
@@ -585,31 +626,74 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		return acl
 	}()
 
-	if !p.Query.BypassAccessControl {
+	result, resultInfo, skyErr := queryRecords(payload, payload.Database, &p.Query, fieldACL, h.AssetStore, h.QueryCache)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	response.Result = result
+	if len(resultInfo) > 0 {
+		response.Info = resultInfo
+	}
+}
+
+// queryRecords executes the query on the database as the user of the
+// payload, and returns the serialized records and the result info. The
+// records are queried through the cache, which may be nil.
+func queryRecords(payload *router.Payload, db skydb.Database, query *skydb.Query, fieldACL skydb.FieldACL, assetStore asset.Store, cache *skydbcache.Cache) ([]interface{}, map[string]interface{}, skyerr.Error) {
+	if payload.AuthInfo != nil {
+		query.ViewAsUser = payload.AuthInfo
+	}
+
+	if payload.HasMasterKey() {
+		query.BypassAccessControl = true
+	}
+
+	if !query.BypassAccessControl {
 		visitor := &queryAccessVisitor{
 			FieldACL:   fieldACL,
-			RecordType: p.Query.Type,
-			AuthInfo:   p.Query.ViewAsUser,
+			RecordType: query.Type,
+			AuthInfo:   query.ViewAsUser,
 			ExpressionACLChecker: ExpressionACLChecker{
 				FieldACL:   fieldACL,
-				RecordType: p.Query.Type,
+				RecordType: query.Type,
 				AuthInfo:   payload.AuthInfo,
-				Database:   payload.Database,
+				Database:   db,
 			},
 		}
-		p.Query.Accept(visitor)
+		query.Accept(visitor)
 		if err := visitor.Error(); err != nil {
-			response.Err = err
-			return
+			return nil, nil, err
+		}
+
+		if err := checkReverseIncludeAccess(query, fieldACL, payload.AuthInfo, db); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	db := payload.Database
+	// A cursor carries the values of the sort keys, so it is only
+	// accepted or returned if the user can read all of them.
+	cursorErr := checkCursorAccess(query, fieldACL, payload.AuthInfo, db)
+	if cursorErr != nil && (query.After != nil || query.Before != nil) {
+		return nil, nil, cursorErr
+	}
+
+	// A query with aggregations returns the aggregations only, the
+	// records being aggregated are not fetched.
+	if len(query.Aggregations) > 0 {
+		aggregation, err := queryAggregation(db, query)
+		if err != nil {
+			return nil, nil, skyerr.MakeError(err)
+		}
+		return []interface{}{}, map[string]interface{}{
+			"aggregation": aggregation,
+		}, nil
+	}
 
-	results, err := db.Query(&p.Query)
+	results, err := cache.Query(db, query)
 	if err != nil {
-		response.Err = skyerr.MakeError(err)
-		return
+		return nil, nil, skyerr.MakeError(err)
 	}
 	defer results.Close()
 
@@ -620,8 +704,7 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 	}
 
 	if results.Err() != nil {
-		response.Err = skyerr.MakeError(results.Err())
-		return
+		return nil, nil, skyerr.MakeError(results.Err())
 	}
 
 	// Scan does not query assets,
@@ -629,23 +712,27 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 	// so we replace them with some complete assets.
 	recordutil.MakeAssetsComplete(db, payload.DBConn, records)
 
-	eagerRecords := recordutil.DoQueryEager(db, recordutil.EagerIDs(db, records, p.Query))
+	eagerRecords := recordutil.DoQueryEager(db, records, *query)
+	reverseRecords, err := recordutil.DoQueryReverseIncludes(db, records, *query)
+	if err != nil {
+		return nil, nil, skyerr.MakeError(err)
+	}
 
 	recordResultFilter, err := recordutil.NewRecordResultFilter(
 		payload.DBConn,
-		h.AssetStore,
+		assetStore,
 		payload.AuthInfo,
-		p.Query.BypassAccessControl,
+		query.BypassAccessControl,
 	)
 	if err != nil {
-		response.Err = skyerr.MakeError(err)
-		return
+		return nil, nil, skyerr.MakeError(err)
 	}
 
 	resultFilter := recordutil.QueryResultFilter{
 		Database:           db,
-		Query:              p.Query,
+		Query:              *query,
 		EagerRecords:       eagerRecords,
+		ReverseRecords:     reverseRecords,
 		RecordResultFilter: recordResultFilter,
 	}
 
@@ -655,16 +742,117 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		output[i] = resultFilter.JSONResult(&record)
 	}
 
-	response.Result = output
+	resultInfo, err := recordutil.QueryResultInfo(db, query, results)
+	if err != nil {
+		return nil, nil, skyerr.MakeError(err)
+	}
+	if cursorErr == nil {
+		if err := addQueryCursors(resultInfo, query, records); err != nil {
+			return nil, nil, skyerr.MakeError(err)
+		}
+	}
+	return output, resultInfo, nil
+}
+
+// checkReverseIncludeAccess checks whether the user is allowed to query
+// the records of the reverse includes by the reference field and to sort
+// them by the sort keys.
+func checkReverseIncludeAccess(query *skydb.Query, fieldACL skydb.FieldACL, authInfo *skydb.AuthInfo, db skydb.Database) skyerr.Error {
+	for _, include := range query.ReverseIncludes {
+		checker := ExpressionACLChecker{
+			FieldACL:   fieldACL,
+			RecordType: include.RecordType,
+			AuthInfo:   authInfo,
+			Database:   db,
+		}
+
+		field := skydb.Expression{Type: skydb.KeyPath, Value: include.Field}
+		if err := checker.Check(field, skydb.DiscoverOrCompareFieldAccessMode); err != nil {
+			return err
+		}
+		for _, sort := range include.Sorts {
+			if err := checker.Check(sort.Expression, skydb.CompareFieldAccessMode); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCursorAccess checks whether the user is allowed to read the sort
+// keys of the query, which are the values of a cursor.
+func checkCursorAccess(query *skydb.Query, fieldACL skydb.FieldACL, authInfo *skydb.AuthInfo, db skydb.Database) skyerr.Error {
+	if query.BypassAccessControl {
+		return nil
+	}
+
+	checker := ExpressionACLChecker{
+		FieldACL:   fieldACL,
+		RecordType: query.Type,
+		AuthInfo:   authInfo,
+		Database:   db,
+	}
+	for _, sort := range query.Sorts {
+		if err := checker.Check(sort.Expression, skydb.ReadFieldAccessMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addQueryCursors adds cursors of the first and the last records to the
+// result info of a paged query. Pass `next_cursor` as `after` of the query
+// to fetch the next page, and `prev_cursor` as `before` to fetch the
+// previous page.
+func addQueryCursors(resultInfo map[string]interface{}, query *skydb.Query, records []skydb.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if query.Limit == nil && query.After == nil && query.Before == nil {
+		return nil
+	}
 
-	resultInfo, err := recordutil.QueryResultInfo(db, &p.Query, results)
+	prevCursor, err := query.CursorForRecord(&records[0])
 	if err != nil {
-		response.Err = skyerr.MakeError(err)
-		return
+		// cursor is not available for query sorted by functions
+		return nil
 	}
-	if len(resultInfo) > 0 {
-		response.Info = resultInfo
+	nextCursor, err := query.CursorForRecord(&records[len(records)-1])
+	if err != nil {
+		return nil
+	}
+
+	if resultInfo["prev_cursor"], err = encodeCursor(prevCursor); err != nil {
+		return err
+	}
+	if resultInfo["next_cursor"], err = encodeCursor(nextCursor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// queryAggregation returns the aggregations of the query, one map for
+// each group of records.
+func queryAggregation(db skydb.Database, query *skydb.Query) ([]interface{}, error) {
+	aggregateDB, ok := db.(skydb.AggregateDatabase)
+	if !ok {
+		return nil, skyerr.NewError(skyerr.NotSupported, "database does not support aggregation")
+	}
+
+	results, err := aggregateDB.QueryAggregation(query)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]interface{}, len(results))
+	for i, result := range results {
+		m := map[string]interface{}{}
+		for key, value := range result {
+			m[key] = toLiteral(value)
+		}
+		output[i] = m
 	}
+	return output, nil
 }
 
 type recordDeletePayload struct {
@@ -721,6 +909,7 @@ EOF
 type RecordDeleteHandler struct {
 	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
 	AccessModel   skydb.AccessModel `inject:"AccessModel"`
+	QueryCache    *skydbcache.Cache `inject:"QueryCache"`
 	Authenticator router.Processor  `preprocessor:"authenticator"`
 	DBConn        router.Processor  `preprocessor:"dbconn"`
 	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
@@ -762,11 +951,13 @@ func (h *RecordDeleteHandler) Handle(payload *router.Payload, response *router.R
 		Db:                payload.Database,
 		Conn:              payload.DBConn,
 		HookRegistry:      h.HookRegistry,
+		Cache:             h.QueryCache,
 		RecordIDsToDelete: p.RecordIDs,
 		Atomic:            p.Atomic,
 		WithMasterKey:     payload.HasMasterKey(),
 		Context:           payload.Context,
 		AuthInfo:          payload.AuthInfo,
+		ModifyAt:          timeNow(),
 	}
 	resp := recordutil.RecordModifyResponse{
 		ErrMap: map[skydb.RecordID]skyerr.Error{},
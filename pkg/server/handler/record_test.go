@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"testing"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/recordutil"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
@@ -136,6 +138,386 @@ func TestRecordDeleteHandler(t *testing.T) {
 	})
 }
 
+// referenceDatabase is a MapDB which supports querying records by the
+// value of a reference field. Transactions are counted but changes are
+// not rolled back.
+type referenceDatabase struct {
+	*skydbtest.MapDB
+	commits   int
+	rollbacks int
+}
+
+func (db *referenceDatabase) Begin() error    { return nil }
+func (db *referenceDatabase) Commit() error   { db.commits++; return nil }
+func (db *referenceDatabase) Rollback() error { db.rollbacks++; return nil }
+
+func (db *referenceDatabase) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
+	return db.RecordSchemaMap, nil
+}
+
+func (db *referenceDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	field := query.Predicate.Children[0].(skydb.Expression).Value.(string)
+	ref := query.Predicate.Children[1].(skydb.Expression).Value.(skydb.Reference)
+
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type != query.Type {
+			continue
+		}
+		if r, ok := record.Get(field).(skydb.Reference); ok && r.ID == ref.ID {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID.Key < records[j].ID.Key
+	})
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func TestRecordDeleteReferentialAction(t *testing.T) {
+	Convey("RecordDeleteHandler with referential actions", t, func() {
+		db := &referenceDatabase{MapDB: skydbtest.NewMapDB()}
+		registry := hook.NewRegistry()
+		beforeHook := hooktest.StackingHook{}
+		afterHook := hooktest.StackingHook{}
+		registry.Register(hook.BeforeDelete, "comment", beforeHook.Func)
+		registry.Register(hook.AfterDelete, "comment", afterHook.Func)
+
+		r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{
+			HookRegistry: registry,
+		}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		acl := skydb.RecordACL{
+			skydb.NewRecordACLEntryDirect("user0", skydb.WriteLevel),
+		}
+		So(db.Save(&skydb.Record{
+			ID:  skydb.NewRecordID("note", "0"),
+			ACL: acl,
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("comment", "0"),
+			Data: skydb.Data{"note": skydb.NewReference("note", "0")},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("comment", "1"),
+			Data: skydb.Data{"note": skydb.NewReference("note", "0")},
+		}), ShouldBeNil)
+
+		setOnDelete := func(action skydb.ReferentialAction) {
+			db.RecordSchemaMap["comment"] = skydb.RecordSchema{
+				"note": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      action,
+				},
+			}
+		}
+
+		Convey("deletes referencing records by cascade", func() {
+			setOnDelete(skydb.CascadeAction)
+
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0", "_type": "record"}
+	]
+}`)
+			So(db.RecordMap, ShouldBeEmpty)
+			So(db.commits, ShouldEqual, 1)
+			So(len(beforeHook.Records), ShouldEqual, 2)
+			So(len(afterHook.Records), ShouldEqual, 2)
+			So(afterHook.Records[0].ID, ShouldResemble, skydb.NewRecordID("comment", "0"))
+			So(afterHook.Records[1].ID, ShouldResemble, skydb.NewRecordID("comment", "1"))
+		})
+
+		Convey("sets referencing fields to null", func() {
+			setOnDelete(skydb.SetNullAction)
+
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0", "_type": "record"}
+	]
+}`)
+			So(db.RecordMap, ShouldNotContainKey, "note/0")
+			So(db.RecordMap["comment/0"].Data["note"], ShouldBeNil)
+			So(db.RecordMap["comment/0"].UpdaterID, ShouldEqual, "user0")
+			So(db.RecordMap["comment/1"].Data["note"], ShouldBeNil)
+			So(beforeHook.Records, ShouldBeEmpty)
+		})
+
+		Convey("fails to delete record with restricting reference", func() {
+			setOnDelete(skydb.RestrictAction)
+
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_type": "error",
+		"code": 113,
+		"message": "cannot delete note/0 because other records have reference to it",
+		"name": "ConstraintViolated",
+		"info": {
+			"referenced_by": [{
+				"record_type": "comment",
+				"field": "note",
+				"ids": ["comment/0", "comment/1"]
+			}]
+		}
+	}]
+}`)
+			So(db.RecordMap, ShouldContainKey, "note/0")
+			So(db.RecordMap, ShouldContainKey, "comment/0")
+			So(db.commits, ShouldEqual, 0)
+			So(db.rollbacks, ShouldEqual, 1)
+		})
+
+		Convey("leaves referencing records without referential action", func() {
+			setOnDelete(skydb.NoReferentialAction)
+
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0", "_type": "record"}
+	]
+}`)
+			So(db.RecordMap, ShouldContainKey, "comment/0")
+			So(db.commits, ShouldEqual, 0)
+		})
+	})
+}
+
+// nullingReferenceDatabase is a referenceDatabase which sets the
+// references to a record to null in place.
+type nullingReferenceDatabase struct {
+	referenceDatabase
+	savedIDs []skydb.RecordID
+}
+
+func (db *nullingReferenceDatabase) Save(record *skydb.Record) error {
+	db.savedIDs = append(db.savedIDs, record.ID)
+	return db.referenceDatabase.Save(record)
+}
+
+func (db *nullingReferenceDatabase) SetNullReferences(recordType, field string, id skydb.RecordID, updatedAt time.Time, updaterID string) ([]skydb.RecordID, error) {
+	ids := []skydb.RecordID{}
+	for key, record := range db.RecordMap {
+		if r, ok := record.Get(field).(skydb.Reference); ok && record.ID.Type == recordType && r.ID == id {
+			record.Set(field, nil)
+			record.UpdatedAt = updatedAt
+			record.UpdaterID = updaterID
+			db.RecordMap[key] = record
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids, nil
+}
+
+func TestRecordSaveExpired(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("RecordSaveHandler with expired records pending deletion", t, func() {
+		db := &expirySchemaDatabase{
+			MapDB: skydbtest.NewMapDB(),
+			expiries: map[string]skydb.RecordExpiry{
+				"session": skydb.RecordExpiry{TTL: time.Hour},
+			},
+			expired: map[skydb.RecordID]bool{
+				skydb.NewRecordID("session", "expired"): true,
+			},
+		}
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("does not create record with the id of an expired record", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "session/expired"
+				}, {
+					"_id": "session/new"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "session/expired",
+					"_type": "error",
+					"code": 109,
+					"message": "record has expired and is pending deletion",
+					"name": "Duplicated"
+				}, {
+					"_id": "session/new",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user0",
+					"_created_by": "user0",
+					"_updated_by": "user0"
+				}]
+			}`)
+			So(db.RecordMap, ShouldNotContainKey, "session/expired")
+		})
+	})
+}
+
+// replicaConn is a MapConn recording whether reads are routed to the
+// primary database
+type replicaConn struct {
+	*skydbtest.MapConn
+	readYourWrites bool
+}
+
+func (c *replicaConn) SetReadYourWrites(enabled bool) {
+	c.readYourWrites = enabled
+}
+
+func (c *replicaConn) ReadYourWrites() bool {
+	return c.readYourWrites
+}
+
+func TestRecordModifyReadFromPrimary(t *testing.T) {
+	Convey("Record modification with read replicas", t, func() {
+		conn := &replicaConn{MapConn: skydbtest.NewMapConn()}
+		db := skydbtest.NewMapDB()
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "0"),
+			OwnerID: "user0",
+		}), ShouldBeNil)
+
+		payloadFunc := func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		}
+
+		Convey("saves records reading from primary", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, payloadFunc)
+			resp := r.POST(`{"records": [{"_id": "note/0", "content": "hello"}]}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(conn.readYourWrites, ShouldBeTrue)
+		})
+
+		Convey("deletes records reading from primary", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, payloadFunc)
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(conn.readYourWrites, ShouldBeTrue)
+		})
+	})
+}
+
+func TestRecordModifyInvalidateCache(t *testing.T) {
+	Convey("Record modification with query cache", t, func() {
+		db := skydbtest.NewMapDB()
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "0"),
+			OwnerID: "user0",
+			Data:    skydb.Data{"content": "hello"},
+		}), ShouldBeNil)
+
+		cache := skydbcache.NewCache(skydbcache.NewLRUStore(10))
+		record := skydb.Record{}
+		So(cache.Get(db, skydb.NewRecordID("note", "0"), &record), ShouldBeNil)
+
+		payloadFunc := func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		}
+
+		Convey("invalidates cache on save", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{
+				QueryCache: cache,
+			}, payloadFunc)
+			resp := r.POST(`{"records": [{"_id": "note/0", "content": "bye"}]}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			So(cache.Get(db, skydb.NewRecordID("note", "0"), &record), ShouldBeNil)
+			So(record.Data["content"], ShouldEqual, "bye")
+		})
+
+		Convey("invalidates cache on delete", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{
+				QueryCache: cache,
+			}, payloadFunc)
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			err := cache.Get(db, skydb.NewRecordID("note", "0"), &record)
+			So(err, ShouldEqual, skydb.ErrRecordNotFound)
+		})
+	})
+}
+
+func TestRecordDeleteSetNullInPlace(t *testing.T) {
+	Convey("RecordDeleteHandler with database setting null references in place", t, func() {
+		db := &nullingReferenceDatabase{
+			referenceDatabase: referenceDatabase{MapDB: skydbtest.NewMapDB()},
+		}
+		r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		So(db.Save(&skydb.Record{
+			ID: skydb.NewRecordID("note", "0"),
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user0", skydb.WriteLevel),
+			},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID: skydb.NewRecordID("comment", "0"),
+			Data: skydb.Data{
+				"note":    skydb.NewReference("note", "0"),
+				"content": "hello",
+			},
+		}), ShouldBeNil)
+		db.RecordSchemaMap["comment"] = skydb.RecordSchema{
+			"note": skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "note",
+				OnDelete:      skydb.SetNullAction,
+			},
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		}
+		db.savedIDs = nil
+
+		Convey("sets referencing fields to null without saving the records", func() {
+			resp := r.POST(`{"ids": ["note/0"]}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0", "_type": "record"}
+	]
+}`)
+			So(db.RecordMap, ShouldNotContainKey, "note/0")
+			So(db.RecordMap["comment/0"].Data["note"], ShouldBeNil)
+			So(db.RecordMap["comment/0"].Data["content"], ShouldEqual, "hello")
+			So(db.RecordMap["comment/0"].UpdaterID, ShouldEqual, "user0")
+			So(db.savedIDs, ShouldBeEmpty)
+		})
+	})
+}
+
 // trueStore is a TokenStore that always noop on Put and assign itself on Get
 type trueStore authtoken.Token
 
@@ -224,6 +606,115 @@ func TestRecordSaveHandler(t *testing.T) {
 			}`)
 		})
 
+		Convey("Rejects record failing field validation", func() {
+			min := float64(0)
+			maxLength := 5
+			db.RecordSchemaMap["note"] = skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type: skydb.TypeString,
+					Validation: &skydb.FieldValidation{
+						Required:  true,
+						MaxLength: &maxLength,
+					},
+				},
+				"count": skydb.FieldType{
+					Type:       skydb.TypeNumber,
+					Validation: &skydb.FieldValidation{Min: &min},
+				},
+			}
+
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/invalid",
+					"title": "too long",
+					"count": -1
+				}, {
+					"_id": "note/valid",
+					"title": "short",
+					"count": 1
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/invalid",
+					"_type": "error",
+					"code": 108,
+					"message": "record failed validation",
+					"name": "InvalidArgument",
+					"info": {
+						"arguments": ["count", "title"],
+						"fields": {
+							"count": ["min"],
+							"title": ["max_length"]
+						}
+					}
+				}, {
+					"_id": "note/valid",
+					"_type": "record",
+					"_access": null,
+					"title": "short",
+					"count": 1,
+					"_created_by":"user0",
+					"_updated_by":"user0",
+					"_ownerID": "user0"
+				}]
+			}`)
+			So(db.RecordMap, ShouldNotContainKey, "note/invalid")
+		})
+
+		Convey("Applies default values to new records", func() {
+			db.RecordSchemaMap["note"] = skydb.RecordSchema{
+				"status": skydb.FieldType{
+					Type:    skydb.TypeString,
+					Default: &skydb.FieldDefault{Type: skydb.LiteralDefault, Value: "draft"},
+				},
+				"author": skydb.FieldType{
+					Type:    skydb.TypeString,
+					Default: &skydb.FieldDefault{Type: skydb.CurrentUserDefault},
+				},
+				"slug": skydb.FieldType{
+					Type:    skydb.TypeString,
+					Default: &skydb.FieldDefault{Type: skydb.ExpressionDefault, Expression: "_id"},
+				},
+			}
+
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/new",
+					"status": "published"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/new",
+					"_type": "record",
+					"_access": null,
+					"status": "published",
+					"author": "user0",
+					"slug": "new",
+					"_created_by":"user0",
+					"_updated_by":"user0",
+					"_ownerID": "user0"
+				}]
+			}`)
+
+			Convey("but not to existing records", func() {
+				db.Save(&skydb.Record{
+					ID:      skydb.NewRecordID("note", "old"),
+					OwnerID: "user0",
+					Data:    skydb.Data{"title": "old"},
+				})
+				resp := r.POST(`{
+					"records": [{
+						"_id": "note/old",
+						"title": "updated"
+					}]
+				}`)
+				So(resp.Code, ShouldEqual, 200)
+				So(db.RecordMap["note/old"].Data, ShouldResemble, skydb.Data{"title": "updated"})
+			})
+		})
+
 		Convey("Should not be able to create record when no permission", func() {
 			resp := r.POST(`{
 				"records": [{
@@ -421,9 +912,304 @@ func TestRecordSaveHandler(t *testing.T) {
 			},
 			{
 				RecordType:  "note",
-				RecordField: "*",
+				RecordField: "*",
+				UserRole:    publicRole,
+				Writable:    true,
+				Readable:    true,
+			},
+		}))
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
+			payload.DBConn = conn
+			payload.Database = db
+			payload.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+			if apiKey, ok := payload.Data["api_key"]; ok && apiKey == "master" {
+				payload.AccessKey = router.MasterAccessKey
+			}
+		})
+
+		Convey("should not save to read only field", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"content": "Bye World!",
+					"favorite": false
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note0",
+					"_type": "record",
+					"_access": null,
+					"content": "Hello World!",
+					"favorite": false,
+					"_created_by":"user0",
+					"_updated_by":"user0",
+					"_ownerID": "user0"
+				}]
+			}`)
+			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
+			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+		})
+
+		Convey("should save to read only field with master key", func() {
+			resp := r.POST(`{
+				"api_key": "master",
+				"records": [{
+					"_id": "note/note0",
+					"content": "Bye World!",
+					"favorite": false
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note0",
+					"_type": "record",
+					"_access": null,
+					"content": "Bye World!",
+					"favorite": false,
+					"category": "interesting",
+					"_created_by":"user0",
+					"_updated_by":"user0",
+					"_ownerID": "user0"
+				}]
+			}`)
+			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Bye World!")
+			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+		})
+
+		Convey("should fail request if atomic save to read only field", func() {
+			resp := r.POST(`{
+				"atomic": true,
+				"records": [{
+					"_id": "note/note0",
+					"content": "Bye World!",
+					"favorite": false
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code":115,
+					"info": {
+						"note/note0": {
+							"code":123,
+							"info": { "arguments":["content"] },
+							"message":"Unable to save to some record fields because of Field ACL denied update.",
+							"name":"DeniedArgument"
+						}
+					},
+					"message":"Atomic Operation rolled back due to one or more errors",
+					"name":"AtomicOperationFailure"
+				}
+			}`)
+			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
+			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, true)
+		})
+
+		Convey("should not fail request if read only field did not change", func() {
+			resp := r.POST(`{
+				"atomic": true,
+				"records": [{
+					"_id": "note/note0",
+					"content": "Hello World!",
+					"favorite": false
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note0",
+					"_type": "record",
+					"_access": null,
+					"content": "Hello World!",
+					"favorite": false,
+					"_created_by":"user0",
+					"_updated_by":"user0",
+					"_ownerID": "user0"
+				}]
+			}`)
+			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
+			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+		})
+	})
+}
+
+type conditionalSaveDatabase struct {
+	*skydbtest.MapDB
+	conflict bool
+}
+
+func (db *conditionalSaveDatabase) SaveIfUnchanged(record *skydb.Record, updatedAt time.Time) error {
+	if db.conflict {
+		return skydb.ErrRecordConflict
+	}
+	origRecord, ok := db.RecordMap[record.ID.String()]
+	if !ok || !origRecord.UpdatedAt.Equal(updatedAt) {
+		return skydb.ErrRecordConflict
+	}
+	return db.Save(record)
+}
+
+func TestRecordSaveExpectedUpdatedAt(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("RecordSaveHandler with expected _updated_at", t, func() {
+		updatedAt := time.Date(2017, 1, 2, 3, 4, 5, 6000, time.UTC)
+		conn := skydbtest.NewMapConn()
+		db := &conditionalSaveDatabase{MapDB: skydbtest.NewMapDB()}
+		db.Save(&skydb.Record{
+			ID:        skydb.NewRecordID("note", "note0"),
+			OwnerID:   "user0",
+			UpdatedAt: updatedAt,
+			Data: skydb.Data{
+				"content": "Hello",
+			},
+		})
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
+			payload.DBConn = conn
+			payload.Database = db
+			payload.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("saves record if _updated_at matches", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"_updated_at": "2017-01-02T03:04:05.000006Z",
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
+		})
+
+		Convey("saves record if _updated_at is a date", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"_updated_at": {"$type": "date", "$date": "2017-01-02T03:04:05.000006Z"},
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
+		})
+
+		Convey("returns conflict if _updated_at mismatches", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"_updated_at": "2017-01-01T00:00:00Z",
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note0",
+					"_type": "error",
+					"code": 125,
+					"message": "record note/note0 has been changed since it was last fetched",
+					"name": "RecordConflict"
+				}]
+			}`)
+			So(db.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello")
+		})
+
+		Convey("returns conflict if record is changed during save", func() {
+			db.conflict = true
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"_updated_at": "2017-01-02T03:04:05.000006Z",
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note0",
+					"_type": "error",
+					"code": 125,
+					"message": "record note/note0 has been changed since it was last fetched",
+					"name": "RecordConflict"
+				}]
+			}`)
+		})
+
+		Convey("returns conflict if record does not exist", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note1",
+					"_updated_at": "2017-01-02T03:04:05.000006Z",
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/note1",
+					"_type": "error",
+					"code": 125,
+					"message": "record note/note1 has been changed since it was last fetched",
+					"name": "RecordConflict"
+				}]
+			}`)
+		})
+
+		Convey("returns error if _updated_at is malformed", func() {
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/note0",
+					"_updated_at": "yesterday",
+					"content": "Hello World!"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_type": "error",
+					"code": 108,
+					"message": "_updated_at is not a valid time: yesterday",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["_updated_at"]}
+				}]
+			}`)
+		})
+	})
+}
+
+func TestRecordSaveFieldOperation(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("RecordSaveHandler with field operations", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		publicRole := skydb.FieldUserRole{skydb.PublicFieldUserRoleType, ""}
+		db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "note0"),
+			OwnerID: "user0",
+			Data: skydb.Data{
+				"likes":    float64(1),
+				"tags":     []interface{}{"a", "b"},
+				"category": "interesting",
+			},
+		})
+
+		conn.SetRecordFieldAccess(skydb.NewFieldACL(skydb.FieldACLEntryList{
+			{
+				RecordType:  "note",
+				RecordField: "views",
 				UserRole:    publicRole,
-				Writable:    true,
+				Writable:    false,
 				Readable:    true,
 			},
 		}))
@@ -434,112 +1220,149 @@ func TestRecordSaveHandler(t *testing.T) {
 			payload.AuthInfo = &skydb.AuthInfo{
 				ID: "user0",
 			}
-			if apiKey, ok := payload.Data["api_key"]; ok && apiKey == "master" {
-				payload.AccessKey = router.MasterAccessKey
-			}
 		})
 
-		Convey("should not save to read only field", func() {
+		Convey("performs field operations", func() {
 			resp := r.POST(`{
 				"records": [{
 					"_id": "note/note0",
-					"content": "Bye World!",
-					"favorite": false
-				}]
-			}`)
-			So(resp.Body.Bytes(), ShouldEqualJSON, `{
-				"result": [{
-					"_id": "note/note0",
-					"_type": "record",
-					"_access": null,
-					"content": "Hello World!",
-					"favorite": false,
-					"_created_by":"user0",
-					"_updated_by":"user0",
-					"_ownerID": "user0"
+					"likes": {"$inc": 2},
+					"tags": {"$append": ["c"]}
 				}]
 			}`)
-			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
-			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data["likes"], ShouldEqual, 3)
+			So(db.RecordMap["note/note0"].Data["tags"], ShouldResemble, []interface{}{"a", "b", "c"})
 		})
 
-		Convey("should save to read only field with master key", func() {
+		Convey("removes elements", func() {
 			resp := r.POST(`{
-				"api_key": "master",
 				"records": [{
 					"_id": "note/note0",
-					"content": "Bye World!",
-					"favorite": false
+					"tags": {"$remove": ["a"]}
 				}]
 			}`)
-			So(resp.Body.Bytes(), ShouldEqualJSON, `{
-				"result": [{
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data["tags"], ShouldResemble, []interface{}{"b"})
+		})
+
+		Convey("unsets field", func() {
+			resp := r.POST(`{
+				"records": [{
 					"_id": "note/note0",
-					"_type": "record",
-					"_access": null,
-					"content": "Bye World!",
-					"favorite": false,
-					"category": "interesting",
-					"_created_by":"user0",
-					"_updated_by":"user0",
-					"_ownerID": "user0"
+					"category": {"$unset": true}
 				}]
 			}`)
-			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Bye World!")
-			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data["category"], ShouldBeNil)
 		})
 
-		Convey("should fail request if atomic save to read only field", func() {
+		Convey("does not perform operation on non-writable field", func() {
 			resp := r.POST(`{
-				"atomic": true,
 				"records": [{
 					"_id": "note/note0",
-					"content": "Bye World!",
-					"favorite": false
+					"views": {"$inc": 1}
 				}]
 			}`)
-			So(resp.Body.Bytes(), ShouldEqualJSON, `{
-				"error": {
-					"code":115,
-					"info": {
-						"note/note0": {
-							"code":123,
-							"info": { "arguments":["content"] },
-							"message":"Unable to save to some record fields because of Field ACL denied update.",
-							"name":"DeniedArgument"
-						}
-					},
-					"message":"Atomic Operation rolled back due to one or more errors",
-					"name":"AtomicOperationFailure"
-				}
-			}`)
-			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
-			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, true)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordMap["note/note0"].Data, ShouldNotContainKey, "views")
 		})
 
-		Convey("should not fail request if read only field did not change", func() {
+		Convey("rejects malformed operand", func() {
 			resp := r.POST(`{
-				"atomic": true,
 				"records": [{
 					"_id": "note/note0",
-					"content": "Hello World!",
-					"favorite": false
+					"likes": {"$inc": "1"}
 				}]
 			}`)
 			So(resp.Body.Bytes(), ShouldEqualJSON, `{
 				"result": [{
-					"_id": "note/note0",
-					"_type": "record",
-					"_access": null,
-					"content": "Hello World!",
-					"favorite": false,
-					"_created_by":"user0",
-					"_updated_by":"user0",
-					"_ownerID": "user0"
+					"_type": "error",
+					"code": 108,
+					"message": "field \"likes\": $inc expects a number, got string",
+					"name": "InvalidArgument"
 				}]
 			}`)
-			So(mapDB.RecordMap["note/note0"].Data["content"], ShouldEqual, "Hello World!")
-			So(mapDB.RecordMap["note/note0"].Data["favorite"], ShouldEqual, false)
+		})
+
+		Convey("with validation rules", func() {
+			max := float64(5)
+			db.RecordSchemaMap["note"] = skydb.RecordSchema{
+				"likes": skydb.FieldType{
+					Type:       skydb.TypeNumber,
+					Validation: &skydb.FieldValidation{Max: &max},
+				},
+			}
+			txDB := skydbtest.NewMockTxDatabase(db)
+			r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
+				payload.DBConn = conn
+				payload.Database = txDB
+				payload.AuthInfo = &skydb.AuthInfo{
+					ID: "user0",
+				}
+			})
+
+			Convey("commits valid result of operation", func() {
+				resp := r.POST(`{
+					"records": [{
+						"_id": "note/note0",
+						"likes": {"$inc": 2}
+					}]
+				}`)
+				So(resp.Code, ShouldEqual, 200)
+				So(txDB.DidCommit, ShouldBeTrue)
+				So(txDB.DidRollback, ShouldBeFalse)
+			})
+
+			Convey("rolls back invalid result of operation", func() {
+				resp := r.POST(`{
+					"records": [{
+						"_id": "note/note0",
+						"likes": {"$inc": 5}
+					}]
+				}`)
+				So(resp.Body.Bytes(), ShouldEqualJSON, `{
+					"result": [{
+						"_id": "note/note0",
+						"_type": "error",
+						"code": 108,
+						"message": "record failed validation",
+						"name": "InvalidArgument",
+						"info": {
+							"arguments": ["likes"],
+							"fields": {"likes": ["max"]}
+						}
+					}]
+				}`)
+				So(txDB.DidCommit, ShouldBeFalse)
+				So(txDB.DidRollback, ShouldBeTrue)
+			})
+
+			Convey("rejects operation if database is not transactional", func() {
+				r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
+					payload.DBConn = conn
+					payload.Database = db
+					payload.AuthInfo = &skydb.AuthInfo{
+						ID: "user0",
+					}
+				})
+				resp := r.POST(`{
+					"records": [{
+						"_id": "note/note0",
+						"likes": {"$inc": 2}
+					}]
+				}`)
+				So(resp.Body.Bytes(), ShouldEqualJSON, `{
+					"result": [{
+						"_id": "note/note0",
+						"_type": "error",
+						"code": 111,
+						"message": "field operations require a transactional database",
+						"name": "NotSupported"
+					}]
+				}`)
+				So(db.RecordMap["note/note0"].Data["likes"], ShouldEqual, 1)
+			})
 		})
 	})
 }
@@ -752,6 +1575,10 @@ func (db bogusFieldDatabase) GetSchema(recordType string) (skydb.RecordSchema, e
 	return skydb.RecordSchema{}, nil
 }
 
+func (db bogusFieldDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return skydb.RecordSchema{}, nil
+}
+
 func TestRecordSaveBogusField(t *testing.T) {
 	realTimeNow := timeNow
 	timeNow = func() time.Time {
@@ -894,6 +1721,7 @@ func TestRecordSaveNoExtendIfRecordMalformed(t *testing.T) {
 type queryDatabase struct {
 	lastquery  *skydb.Query
 	databaseID string
+	typemap    map[string]skydb.RecordSchema
 	skydb.Database
 }
 
@@ -916,6 +1744,10 @@ func (db *queryDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
 	return skydb.EmptyRows, nil
 }
 
+func (db *queryDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return db.typemap[recordType], nil
+}
+
 type queryResultsDatabase struct {
 	records    []skydb.Record
 	databaseID string
@@ -958,36 +1790,224 @@ func TestRecordQueryResults(t *testing.T) {
 
 		conn := skydbtest.NewMapConn()
 		db := &queryResultsDatabase{}
-		db.records = []skydb.Record{record1, record0, record2}
+		db.records = []skydb.Record{record1, record0, record2}
+
+		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+		})
+
+		Convey("REGRESSION #227: query returns correct results from db", func() {
+			resp := r.POST(`{
+				"record_type": "note"
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": [{
+					"_type": "record",
+					"_id": "note/1",
+					"_access": null
+				},
+				{
+					"_type": "record",
+					"_id": "note/0",
+					"_access": null
+				},
+				{
+					"_type": "record",
+					"_id": "note/2",
+					"_access": null
+				}]
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+		})
+
+		Convey("query with limit returns cursors", func() {
+			resp := r.POST(`{
+				"record_type": "note",
+				"limit": 3
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": [{
+					"_type": "record",
+					"_id": "note/1",
+					"_access": null
+				},
+				{
+					"_type": "record",
+					"_id": "note/0",
+					"_access": null
+				},
+				{
+					"_type": "record",
+					"_id": "note/2",
+					"_access": null
+				}],
+				"info": {
+					"prev_cursor": "eyJ2IjpbXSwiaWQiOiIxIn0",
+					"next_cursor": "eyJ2IjpbXSwiaWQiOiIyIn0"
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+		})
+
+		Convey("with a sort key comparable but not readable", func() {
+			publicRole := skydb.FieldUserRole{skydb.PublicFieldUserRoleType, ""}
+			conn.SetRecordFieldAccess(skydb.NewFieldACL(skydb.FieldACLEntryList{
+				{
+					RecordType:   "note",
+					RecordField:  "secret",
+					UserRole:     publicRole,
+					Writable:     true,
+					Readable:     false,
+					Comparable:   true,
+					Discoverable: true,
+				},
+			}))
+
+			Convey("query with limit returns no cursors", func() {
+				resp := r.POST(`{
+					"record_type": "note",
+					"sort": [[{"$type": "keypath", "$val": "secret"}, "asc"]],
+					"limit": 3
+				}`)
+
+				So(resp.Body.String(), ShouldEqualJSON, `{
+					"result": [{
+						"_type": "record",
+						"_id": "note/1",
+						"_access": null
+					},
+					{
+						"_type": "record",
+						"_id": "note/0",
+						"_access": null
+					},
+					{
+						"_type": "record",
+						"_id": "note/2",
+						"_access": null
+					}]
+				}`)
+				So(resp.Code, ShouldEqual, 200)
+			})
+
+			Convey("query with cursor is denied", func() {
+				cursor, err := encodeCursor(&skydb.Cursor{
+					Values: []interface{}{"hello"},
+					ID:     "1",
+				})
+				So(err, ShouldBeNil)
+
+				resp := r.POST(fmt.Sprintf(`{
+					"record_type": "note",
+					"sort": [[{"$type": "keypath", "$val": "secret"}, "asc"]],
+					"limit": 3,
+					"after": "%s"
+				}`, cursor))
+
+				So(resp.Body.String(), ShouldEqualJSON, `{
+					"error": {
+						"code": 124,
+						"message": "Cannot query on field \"secret\" due to Field ACL, need to be readable",
+						"name": "RecordQueryDenied"
+					}
+				}`)
+			})
+		})
+	})
+}
+
+type aggregateQueryDatabase struct {
+	queryResultsDatabase
+	results   []map[string]interface{}
+	lastquery *skydb.Query
+}
+
+func (db *aggregateQueryDatabase) QueryAggregation(query *skydb.Query) ([]map[string]interface{}, error) {
+	db.lastquery = query
+	return db.results, nil
+}
+
+func TestRecordQueryAggregation(t *testing.T) {
+	Convey("Given a Database supporting aggregation", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := &aggregateQueryDatabase{}
+		db.records = []skydb.Record{
+			{ID: skydb.NewRecordID("order", "1")},
+		}
+		db.results = []map[string]interface{}{
+			{
+				"category": skydb.NewReference("category", "book"),
+				"total":    float64(42),
+				"latest":   time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+			},
+			{
+				"category": nil,
+				"total":    float64(3),
+				"latest":   nil,
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+		})
+
+		Convey("returns aggregation in info without records", func() {
+			resp := r.POST(`{
+				"record_type": "order",
+				"aggregate": {
+					"total": ["func", "sum", {"$type": "keypath", "$val": "amount"}],
+					"latest": ["func", "max", {"$type": "keypath", "$val": "_created_at"}]
+				},
+				"group_by": [{"$type": "keypath", "$val": "category"}]
+			}`)
+
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": [],
+				"info": {
+					"aggregation": [{
+						"category": {"$type": "ref", "$id": "category/book"},
+						"total": 42,
+						"latest": {"$type": "date", "$date": "2017-01-02T03:04:05Z"}
+					}, {
+						"category": null,
+						"total": 3,
+						"latest": null
+					}]
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(db.lastquery.GroupBy, ShouldResemble, []string{"category"})
+		})
+	})
+
+	Convey("Given a Database not supporting aggregation", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := &queryResultsDatabase{}
 
 		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{}, func(p *router.Payload) {
 			p.DBConn = conn
 			p.Database = db
 		})
 
-		Convey("REGRESSION #227: query returns correct results from db", func() {
+		Convey("returns not supported", func() {
 			resp := r.POST(`{
-				"record_type": "note"
+				"record_type": "order",
+				"aggregate": {
+					"orders": ["func", "count"]
+				}
 			}`)
 
 			So(resp.Body.String(), ShouldEqualJSON, `{
-				"result": [{
-					"_type": "record",
-					"_id": "note/1",
-					"_access": null
-				},
-				{
-					"_type": "record",
-					"_id": "note/0",
-					"_access": null
-				},
-				{
-					"_type": "record",
-					"_id": "note/2",
-					"_access": null
-				}]
+				"error": {
+					"code": 111,
+					"message": "database does not support aggregation",
+					"name": "NotSupported"
+				}
 			}`)
-			So(resp.Code, ShouldEqual, 200)
 		})
 	})
 }
@@ -1413,6 +2433,93 @@ func TestRecordQuery(t *testing.T) {
 			So(db.lastquery.Offset, ShouldEqual, 400)
 		})
 
+		Convey("Queries records with cursor", func() {
+			cursor, err := encodeCursor(&skydb.Cursor{
+				Values: []interface{}{
+					time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+					"hello",
+				},
+				ID: "note1",
+			})
+			So(err, ShouldBeNil)
+
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"sort": []interface{}{
+						[]interface{}{
+							map[string]interface{}{"$type": "keypath", "$val": "_created_at"},
+							"desc",
+						},
+						[]interface{}{
+							map[string]interface{}{"$type": "keypath", "$val": "title"},
+							"asc",
+						},
+					},
+					"limit": float64(20),
+					"after": cursor,
+				},
+				DBConn:   conn,
+				Database: db,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldBeNil)
+			So(db.lastquery.Before, ShouldBeNil)
+			So(*db.lastquery.After, ShouldResemble, skydb.Cursor{
+				Values: []interface{}{
+					time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+					"hello",
+				},
+				ID: "note1",
+			})
+		})
+
+		Convey("Queries records with mismatched cursor", func() {
+			cursor, err := encodeCursor(&skydb.Cursor{
+				Values: []interface{}{"hello"},
+				ID:     "note1",
+			})
+			So(err, ShouldBeNil)
+
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"before":      cursor,
+				},
+				DBConn:   conn,
+				Database: db,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldNotBeNil)
+			So(response.Err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
+		Convey("Queries records with malformed cursor", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"after":       "not a cursor",
+				},
+				DBConn:   conn,
+				Database: db,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldNotBeNil)
+			So(response.Err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
 		Convey("Queries records with count", func() {
 			payload := router.Payload{
 				Data: map[string]interface{}{
@@ -1495,7 +2602,79 @@ func TestRecordQuery(t *testing.T) {
 					Comparable:   false,
 					Discoverable: true,
 				},
+				{
+					RecordType:   "note",
+					RecordField:  "secret",
+					UserRole:     publicRole,
+					Writable:     true,
+					Readable:     true,
+					Comparable:   false,
+					Discoverable: false,
+				},
 			}))
+			db.typemap = map[string]skydb.RecordSchema{
+				"note": skydb.RecordSchema{
+					"settings": skydb.FieldType{Type: skydb.TypeJSON},
+					"secret":   skydb.FieldType{Type: skydb.TypeJSON},
+				},
+			}
+
+			Convey("should allow keypath into comparable json field", func() {
+				payload := router.Payload{
+					Data: map[string]interface{}{
+						"record_type": "note",
+						"predicate": []interface{}{
+							"eq",
+							map[string]interface{}{
+								"$type": "keypath",
+								"$val":  "settings.font.size",
+							},
+							float64(12),
+						},
+						"sort": []interface{}{
+							[]interface{}{
+								map[string]interface{}{
+									"$type": "keypath",
+									"$val":  "settings.theme",
+								},
+								"asc",
+							},
+						},
+					},
+					DBConn:   conn,
+					Database: db,
+				}
+				response := router.Response{}
+
+				handler := &RecordQueryHandler{}
+				handler.Handle(&payload, &response)
+				So(response.Err, ShouldBeNil)
+				So(db.lastquery.Predicate.GetExpressions()[0].Value, ShouldEqual, "settings.font.size")
+			})
+
+			Convey("should block keypath into non-comparable json field", func() {
+				payload := router.Payload{
+					Data: map[string]interface{}{
+						"record_type": "note",
+						"predicate": []interface{}{
+							"eq",
+							map[string]interface{}{
+								"$type": "keypath",
+								"$val":  "secret.pin",
+							},
+							"1234",
+						},
+					},
+					DBConn:   conn,
+					Database: db,
+				}
+				response := router.Response{}
+
+				handler := &RecordQueryHandler{}
+				handler.Handle(&payload, &response)
+				So(response.Err, ShouldNotBeNil)
+				So(response.Err.Code(), ShouldEqual, skyerr.RecordQueryDenied)
+			})
 
 			Convey("should block non-comparable, non-discoverable field", func() {
 				payload := router.Payload{
@@ -1680,6 +2859,10 @@ func (db *singleRecordDatabase) GetSchema(recordType string) (skydb.RecordSchema
 	return db.recordSchema, nil
 }
 
+func (db *singleRecordDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return db.recordSchema, nil
+}
+
 func TestRecordFetchHandler(t *testing.T) {
 	realTime := timeNow
 	timeNow = func() time.Time { return ZeroTime }
@@ -2317,6 +3500,194 @@ func TestRecordQueryWithEagerLoad(t *testing.T) {
 	})
 }
 
+// includeDatabase is a MapDB which supports querying records of a type,
+// optionally by the value of a reference field.
+type includeDatabase struct {
+	*skydbtest.MapDB
+}
+
+func (db *includeDatabase) GetByIDs(ids []skydb.RecordID) (*skydb.Rows, error) {
+	records := []skydb.Record{}
+	for _, id := range ids {
+		if record, ok := db.RecordMap[id.String()]; ok {
+			records = append(records, record)
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *includeDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type != query.Type {
+			continue
+		}
+		if query.Predicate.Operator == skydb.In {
+			field := query.Predicate.Children[0].(skydb.Expression).Value.(string)
+			refs := query.Predicate.Children[1].(skydb.Expression).Value.([]interface{})
+			found := false
+			for _, ref := range refs {
+				if r, ok := record.Get(field).(skydb.Reference); ok && r.ID == ref.(skydb.Reference).ID {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		records = append(records, record)
+	}
+
+	descending := len(query.Sorts) > 0 && query.Sorts[0].Order == skydb.Descending
+	sort.Slice(records, func(i, j int) bool {
+		return (records[i].ID.Key < records[j].ID.Key) != descending
+	})
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *includeDatabase) QueryCount(query *skydb.Query) (uint64, error) {
+	return 0, nil
+}
+
+func (db *includeDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return db.GetSchema(recordType)
+}
+
+func TestRecordQueryWithNestedAndReverseInclude(t *testing.T) {
+	Convey("Given records referencing each other", t, func() {
+		db := &includeDatabase{skydbtest.NewMapDB()}
+		db.RecordSchemaMap = skydbtest.RecordSchemaMap{
+			"post": skydb.RecordSchema{
+				"author": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "author"},
+			},
+			"author": skydb.RecordSchema{
+				"company": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "company"},
+			},
+			"company": skydb.RecordSchema{
+				"name": skydb.FieldType{Type: skydb.TypeString},
+			},
+			"comment": skydb.RecordSchema{
+				"post": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "post"},
+			},
+		}
+
+		records := []skydb.Record{
+			{
+				ID:      skydb.NewRecordID("post", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"author": skydb.NewReference("author", "a")},
+			},
+			{
+				ID:      skydb.NewRecordID("post", "2"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"author": skydb.NewReference("author", "b")},
+			},
+			{
+				ID:      skydb.NewRecordID("author", "a"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"company": skydb.NewReference("company", "x")},
+			},
+			{
+				ID:      skydb.NewRecordID("author", "b"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"company": skydb.NewReference("company", "y")},
+			},
+			{
+				ID:      skydb.NewRecordID("company", "x"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"name": "X"},
+			},
+			{
+				ID:      skydb.NewRecordID("company", "y"),
+				OwnerID: "user1",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+				},
+				Data: skydb.Data{"name": "Y"},
+			},
+			{
+				ID:      skydb.NewRecordID("comment", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"post": skydb.NewReference("post", "1")},
+			},
+			{
+				ID:      skydb.NewRecordID("comment", "2"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"post": skydb.NewReference("post", "1")},
+			},
+			{
+				ID:      skydb.NewRecordID("comment", "3"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"post": skydb.NewReference("post", "1")},
+			},
+		}
+		for i := range records {
+			So(db.Save(&records[i]), ShouldBeNil)
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{}, func(p *router.Payload) {
+			p.Database = db
+			p.DBConn = skydbtest.NewMapConn()
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("includes records with nested key path and reverse reference", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"company": {"$type": "keypath", "$val": "author.company"},
+					"comments": {
+						"$type": "reverse",
+						"record_type": "comment",
+						"field": "post",
+						"sort": [[{"$type": "keypath", "$val": "_created_at"}, "desc"]],
+						"limit": 2
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "post/1",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user0",
+					"author": {"$id": "author/a", "$type": "ref"},
+					"_transient": {
+						"company": {"_id": "company/x", "_type": "record", "_access": null, "_ownerID": "user0", "name": "X"},
+						"comments": [
+							{"_id": "comment/3", "_type": "record", "_access": null, "_ownerID": "user0", "post": {"$id": "post/1", "$type": "ref"}},
+							{"_id": "comment/2", "_type": "record", "_access": null, "_ownerID": "user0", "post": {"$id": "post/1", "$type": "ref"}}
+						]
+					}
+				}, {
+					"_id": "post/2",
+					"_type": "record",
+					"_access": null,
+					"_ownerID": "user0",
+					"author": {"$id": "author/b", "$type": "ref"},
+					"_transient": {
+						"company": null,
+						"comments": []
+					}
+				}]
+			}`)
+		})
+
+		Convey("rejects reverse include of non-existent field", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"comments": {"$type": "reverse", "record_type": "comment"}
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+	})
+}
+
 func TestRecordQueryWithCount(t *testing.T) {
 	Convey("Given a Database with records", t, func() {
 		record0 := skydb.Record{
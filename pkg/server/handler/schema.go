@@ -15,8 +15,12 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	pluginEvent "github.com/skygeario/skygear-server/pkg/server/plugin/event"
@@ -238,6 +242,198 @@ func (h *SchemaDeleteHandler) Handle(rpayload *router.Payload, response *router.
 	}
 }
 
+/*
+SchemaAlterHandler handles the action of changing the type of a column.
+The existing values are converted to the new type, and the column is not
+changed if any value cannot be converted. The number of records whose
+value cannot be converted is returned in a dry run.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/alter <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:alter",
+	"record_type": "student",
+	"item_name": "score",
+	"type": "number",
+	"dry_run": true
+}
+EOF
+*/
+type SchemaAlterHandler struct {
+	EventSender   pluginEvent.Sender `inject:"PluginEventSender"`
+	AccessKey     router.Processor   `preprocessor:"accesskey"`
+	DevOnly       router.Processor   `preprocessor:"dev_only"`
+	DBConn        router.Processor   `preprocessor:"dbconn"`
+	InjectDB      router.Processor   `preprocessor:"inject_db"`
+	PluginReady   router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaAlterHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaAlterHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaAlterPayload struct {
+	RecordType string `mapstructure:"record_type"`
+	ColumnName string `mapstructure:"item_name"`
+	TypeName   string `mapstructure:"type"`
+	OnDelete   string `mapstructure:"on_delete"`
+	DryRun     bool   `mapstructure:"dry_run"`
+
+	FieldType skydb.FieldType
+}
+
+func (payload *schemaAlterPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if err := payload.Validate(); err != nil {
+		return err
+	}
+
+	fieldType, err := skydb.SimpleNameToFieldType(payload.TypeName)
+	if err != nil {
+		return skyerr.NewInvalidArgument("unexpected field type", []string{payload.TypeName})
+	}
+	if payload.OnDelete != "" {
+		fieldType.OnDelete = skydb.ReferentialAction(payload.OnDelete)
+		if fieldType.Type != skydb.TypeReference {
+			return skyerr.NewInvalidArgument("on_delete is only allowed for reference field", []string{"on_delete"})
+		}
+		if !fieldType.OnDelete.IsValid() {
+			return skyerr.NewInvalidArgument("unexpected on_delete action", []string{payload.OnDelete})
+		}
+	}
+	payload.FieldType = fieldType
+	return nil
+}
+
+func (payload *schemaAlterPayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.ColumnName == "" {
+		missingArgs = append(missingArgs, "item_name")
+	}
+	if payload.TypeName == "" {
+		missingArgs = append(missingArgs, "type")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	if strings.HasPrefix(payload.ColumnName, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved key", []string{"item_name"})
+	}
+	return nil
+}
+
+func (h *SchemaAlterHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaAlterPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	alterDB, ok := db.(skydb.SchemaAlterDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support changing type of field")
+		return
+	}
+
+	schema, err := db.GetSchema(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	current, ok := schema[payload.ColumnName]
+	if !ok {
+		response.Err = skyerr.NewErrorf(skyerr.ResourceNotFound,
+			`field "%s" of record type "%s" does not exist`, payload.ColumnName, payload.RecordType)
+		return
+	}
+
+	fieldType := payload.FieldType
+	if !current.CastableTo(fieldType) {
+		response.Err = skyerr.NewInvalidArgument(
+			fmt.Sprintf("cannot change type of field from %s to %s", current.ToSimpleName(), fieldType.ToSimpleName()),
+			[]string{"type"},
+		)
+		return
+	}
+
+	// The validation rules and default value of the field are kept, so
+	// they have to be applicable to the new type.
+	fieldType.Validation = current.Validation
+	fieldType.Default = current.Default
+	if fieldType.Validation != nil {
+		if err := validateFieldValidation(fieldType); err != nil {
+			response.Err = skyerr.NewInvalidArgument(err.Error(), []string{payload.ColumnName})
+			return
+		}
+	}
+	if err := validateFieldDefault(fieldType); err != nil {
+		response.Err = skyerr.NewInvalidArgument(err.Error(), []string{payload.ColumnName})
+		return
+	}
+
+	if expiryDB, ok := db.(skydb.RecordExpiryDatabase); ok && fieldType.Type != skydb.TypeDateTime {
+		expiry, err := expiryDB.GetRecordExpiry(payload.RecordType)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		if expiry.Field == payload.ColumnName {
+			response.Err = skyerr.NewInvalidArgument("field of record expiry must be datetime", []string{payload.ColumnName})
+			return
+		}
+	}
+
+	var failures uint64
+	if payload.DryRun {
+		failures, err = alterDB.CountAlterFailures(payload.RecordType, payload.ColumnName, fieldType)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+	} else {
+		if err := alterDB.AlterSchema(payload.RecordType, payload.ColumnName, fieldType); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		if h.EventSender != nil {
+			err := sendSchemaChangedEvent(h.EventSender, db)
+			if err != nil {
+				log.WithField("err", err).Warn("Fail to send schema changed event")
+			}
+		}
+	}
+
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"item_name":   payload.ColumnName,
+		"type":        fieldType.ToSimpleName(),
+		"dry_run":     payload.DryRun,
+		"failures":    failures,
+	}
+}
+
 /*
 SchemaCreateHandler handles the action of creating new columns
 curl -X POST -H "Content-Type: application/json" \
@@ -248,8 +444,11 @@ curl -X POST -H "Content-Type: application/json" \
 	"record_types":{
 		"student": {
 			"fields":[
-				{"name": "age", "type": "number"},
-				{"name": "nickname" "type": "string"}
+				{"name": "age", "type": "number", "validation": {"required": true, "min": 0}},
+				{"name": "nickname" "type": "string", "validation": {"max_length": 20}},
+				{"name": "school", "type": "ref(school)", "on_delete": "set_null"},
+				{"name": "status", "type": "string", "default": {"type": "literal", "value": "active"}},
+				{"name": "enrolled_at", "type": "datetime", "default": {"type": "now"}}
 			]
 		}
 	}
@@ -290,22 +489,144 @@ func (payload *schemaCreatePayload) Decode(data map[string]interface{}) skyerr.E
 	if err := mapstructure.Decode(data, payload); err != nil {
 		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
 	}
+	return payload.decodeSchemas()
+}
 
+// decodeSchemas converts the fields in RawSchemas to Schemas.
+func (payload *schemaCreatePayload) decodeSchemas() skyerr.Error {
 	payload.Schemas = make(map[string]skydb.RecordSchema)
 	for recordType, schema := range payload.RawSchemas {
 		payload.Schemas[recordType] = make(skydb.RecordSchema)
 		for _, field := range schema.Fields {
-			var err error
-			payload.Schemas[recordType][field.Name], err = skydb.SimpleNameToFieldType(field.TypeName)
+			fieldType, err := skydb.SimpleNameToFieldType(field.TypeName)
 			if err != nil {
 				return skyerr.NewInvalidArgument("unexpected field type", []string{field.TypeName})
 			}
+
+			if field.OnDelete != "" {
+				fieldType.OnDelete = skydb.ReferentialAction(field.OnDelete)
+				if fieldType.Type != skydb.TypeReference {
+					return skyerr.NewInvalidArgument("on_delete is only allowed for reference field", []string{field.Name})
+				}
+				if !fieldType.OnDelete.IsValid() {
+					return skyerr.NewInvalidArgument("unexpected on_delete action", []string{field.OnDelete})
+				}
+			}
+
+			if field.Validation != nil {
+				fieldType.Validation = field.Validation.FieldValidation()
+				if err := validateFieldValidation(fieldType); err != nil {
+					return skyerr.NewInvalidArgument(err.Error(), []string{field.Name})
+				}
+			}
+
+			if field.Default != nil {
+				fieldType.Default = field.Default.FieldDefault()
+				if err := validateFieldDefault(fieldType); err != nil {
+					return skyerr.NewInvalidArgument(err.Error(), []string{field.Name})
+				}
+			}
+			payload.Schemas[recordType][field.Name] = fieldType
 		}
 	}
 
 	return payload.Validate()
 }
 
+// validateFieldValidation checks that the validation rules are
+// applicable to the type of the field.
+func validateFieldValidation(fieldType skydb.FieldType) error {
+	v := fieldType.Validation
+	if (v.Min != nil || v.Max != nil) && !fieldType.Type.IsNumberCompatibleType() {
+		return errors.New("min and max are only allowed for number field")
+	}
+	if v.Min != nil && v.Max != nil && *v.Min > *v.Max {
+		return errors.New("min is greater than max")
+	}
+
+	if (v.MinLength != nil || v.MaxLength != nil) &&
+		fieldType.Type != skydb.TypeString && fieldType.Type != skydb.TypeList {
+		return errors.New("min_length and max_length are only allowed for string or list field")
+	}
+	if (v.MinLength != nil && *v.MinLength < 0) || (v.MaxLength != nil && *v.MaxLength < 0) {
+		return errors.New("min_length and max_length cannot be negative")
+	}
+	if v.MinLength != nil && v.MaxLength != nil && *v.MinLength > *v.MaxLength {
+		return errors.New("min_length is greater than max_length")
+	}
+
+	if v.Pattern != "" {
+		if fieldType.Type != skydb.TypeString {
+			return errors.New("pattern is only allowed for string field")
+		}
+		if _, err := regexp.Compile(v.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+	}
+	return nil
+}
+
+// validateFieldDefault checks that the default value can be generated
+// for the type of the field.
+func validateFieldDefault(fieldType skydb.FieldType) error {
+	d := fieldType.Default
+	if d.IsEmpty() {
+		return nil
+	}
+
+	switch d.Type {
+	case skydb.LiteralDefault:
+		return validateLiteralDefault(fieldType.Type, d.Value)
+	case skydb.NowDefault:
+		if fieldType.Type != skydb.TypeDateTime {
+			return errors.New("now default is only allowed for datetime field")
+		}
+	case skydb.CurrentUserDefault:
+		if fieldType.Type != skydb.TypeString &&
+			!(fieldType.Type == skydb.TypeReference && fieldType.ReferenceType == "user") {
+			return errors.New("current_user default is only allowed for string or ref(user) field")
+		}
+	case skydb.UUIDDefault:
+		if fieldType.Type != skydb.TypeString {
+			return errors.New("uuid default is only allowed for string field")
+		}
+	case skydb.ExpressionDefault:
+		if d.Expression == "" {
+			return errors.New("expression default requires an expression")
+		}
+	default:
+		return fmt.Errorf("unexpected default type %s", d.Type)
+	}
+	return nil
+}
+
+func validateLiteralDefault(dataType skydb.DataType, value interface{}) error {
+	if value == nil {
+		return errors.New("literal default requires a value")
+	}
+
+	var ok bool
+	switch dataType {
+	case skydb.TypeString:
+		_, ok = value.(string)
+	case skydb.TypeNumber, skydb.TypeInteger:
+		_, ok = value.(float64)
+	case skydb.TypeBoolean:
+		_, ok = value.(bool)
+	case skydb.TypeList:
+		_, ok = value.([]interface{})
+	case skydb.TypeJSON:
+		ok = true
+	default:
+		return errors.New("literal default is not supported for the type of field")
+	}
+
+	if !ok {
+		return errors.New("literal default does not match the type of field")
+	}
+	return nil
+}
+
 func (payload *schemaCreatePayload) Validate() skyerr.Error {
 	for recordType, schema := range payload.Schemas {
 		if strings.HasPrefix(recordType, "_") {
@@ -330,43 +651,364 @@ func (h *SchemaCreateHandler) Handle(rpayload *router.Payload, response *router.
 		return
 	}
 
-	db := rpayload.Database
-	for recordType, recordSchema := range payload.Schemas {
-		_, err := db.Extend(recordType, recordSchema)
-		if err != nil {
-			response.Err = skyerr.NewError(skyerr.IncompatibleSchema, err.Error())
+	db := rpayload.Database
+	for recordType, recordSchema := range payload.Schemas {
+		_, err := db.Extend(recordType, recordSchema)
+		if err != nil {
+			response.Err = skyerr.NewError(skyerr.IncompatibleSchema, err.Error())
+			return
+		}
+	}
+
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	response.Result = &schemaResponse{
+		Schemas: encodeRecordSchemas(schemas),
+	}
+
+	if h.EventSender != nil {
+		err := sendSchemaChangedEvent(h.EventSender, db)
+		if err != nil {
+			log.WithField("err", err).Warn("Fail to send schema changed event")
+		}
+	}
+}
+
+/*
+SchemaFetchHandler handles the action of returing information of record schema
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/fetch <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:fetch"
+}
+EOF
+*/
+type SchemaFetchHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaFetchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaFetchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	db := rpayload.Database
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = &schemaResponse{
+		Schemas: encodeRecordSchemas(schemas),
+	}
+}
+
+/*
+SchemaSearchIndexCreateHandler handles the action of creating index for
+full-text search on a string field
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/search_index/create <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:search_index:create",
+	"record_type": "article",
+	"field": "content",
+	"language": "english"
+}
+EOF
+*/
+type SchemaSearchIndexCreateHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaSearchIndexCreateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaSearchIndexCreateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaSearchIndexCreatePayload struct {
+	RecordType string `mapstructure:"record_type"`
+	Field      string `mapstructure:"field"`
+	Language   string `mapstructure:"language"`
+}
+
+func (payload *schemaSearchIndexCreatePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if payload.Language == "" {
+		payload.Language = skydb.DefaultSearchLanguage
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaSearchIndexCreatePayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.Field == "" {
+		missingArgs = append(missingArgs, "field")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	if !skydb.IsValidSearchLanguage(payload.Language) {
+		return skyerr.NewInvalidArgument("invalid search language", []string{"language"})
+	}
+	return nil
+}
+
+func (h *SchemaSearchIndexCreateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaSearchIndexCreatePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := rpayload.Database.(skydb.SearchIndexDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support search index")
+		return
+	}
+
+	indexName, err := db.SaveSearchIndex(payload.RecordType, payload.Field, payload.Language)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"field":       payload.Field,
+		"language":    payload.Language,
+		"name":        indexName,
+	}
+}
+
+/*
+SchemaSoftDeleteHandler handles the action of enabling or disabling soft
+delete of a record type. The current setting is returned if enabled is
+not specified.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/soft_delete <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:soft_delete",
+	"record_type": "note",
+	"enabled": true
+}
+EOF
+*/
+type SchemaSoftDeleteHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaSoftDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaSoftDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+// schemaTogglePayload is the payload for enabling or disabling a feature
+// of a record type.
+type schemaTogglePayload struct {
+	RecordType string `mapstructure:"record_type"`
+	Enabled    *bool  `mapstructure:"enabled"`
+}
+
+func (payload *schemaTogglePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaTogglePayload) Validate() skyerr.Error {
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"record_type"})
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	return nil
+}
+
+func (h *SchemaSoftDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaTogglePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := rpayload.Database.(skydb.SoftDeleteDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+		return
+	}
+
+	if payload.Enabled != nil {
+		if err := db.SetSoftDeleteEnabled(payload.RecordType, *payload.Enabled); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+	}
+
+	enabled, err := db.SoftDeleteEnabled(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"enabled":     enabled,
+	}
+}
+
+/*
+SchemaHistoryHandler handles the action of enabling or disabling keeping
+revisions of records of a record type. The current setting is returned if
+enabled is not specified. Existing revisions are removed when it is
+disabled.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/history <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:history",
+	"record_type": "note",
+	"enabled": true
+}
+EOF
+*/
+type SchemaHistoryHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaHistoryHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaHistoryHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaHistoryHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaTogglePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := rpayload.Database.(skydb.RecordHistoryDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support record history")
+		return
+	}
+
+	if payload.Enabled != nil {
+		if err := db.SetRecordHistoryEnabled(payload.RecordType, *payload.Enabled); err != nil {
+			response.Err = skyerr.MakeError(err)
 			return
 		}
 	}
 
-	schemas, err := db.GetRecordSchemas()
+	enabled, err := db.RecordHistoryEnabled(payload.RecordType)
 	if err != nil {
 		response.Err = skyerr.MakeError(err)
 		return
 	}
-	response.Result = &schemaResponse{
-		Schemas: encodeRecordSchemas(schemas),
-	}
 
-	if h.EventSender != nil {
-		err := sendSchemaChangedEvent(h.EventSender, db)
-		if err != nil {
-			log.WithField("err", err).Warn("Fail to send schema changed event")
-		}
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"enabled":     enabled,
 	}
 }
 
 /*
-SchemaFetchHandler handles the action of returing information of record schema
+SchemaExpiryHandler handles the action of setting when records of a record
+type expire. Records expire at the time in a datetime field, or when a TTL
+in seconds has elapsed since they were created. Setting both field and ttl
+to empty values removes the expiry. The current setting is returned if
+neither field nor ttl is specified.
+
+Expired records are not returned by queries, and are deleted by a
+background sweeper.
 curl -X POST -H "Content-Type: application/json" \
-  -d @- http://localhost:3000/schema/fetch <<EOF
+  -d @- http://localhost:3000/schema/expiry <<EOF
 {
 	"master_key": "MASTER_KEY",
-	"action": "schema:fetch"
+	"action": "schema:expiry",
+	"record_type": "session",
+	"ttl": 86400
 }
 EOF
 */
-type SchemaFetchHandler struct {
+type SchemaExpiryHandler struct {
 	AccessKey     router.Processor `preprocessor:"accesskey"`
 	DevOnly       router.Processor `preprocessor:"dev_only"`
 	DBConn        router.Processor `preprocessor:"dbconn"`
@@ -375,7 +1017,7 @@ type SchemaFetchHandler struct {
 	preprocessors []router.Processor
 }
 
-func (h *SchemaFetchHandler) Setup() {
+func (h *SchemaExpiryHandler) Setup() {
 	h.preprocessors = []router.Processor{
 		h.AccessKey,
 		h.DevOnly,
@@ -385,20 +1027,82 @@ func (h *SchemaFetchHandler) Setup() {
 	}
 }
 
-func (h *SchemaFetchHandler) GetPreprocessors() []router.Processor {
+func (h *SchemaExpiryHandler) GetPreprocessors() []router.Processor {
 	return h.preprocessors
 }
 
-func (h *SchemaFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
-	db := rpayload.Database
-	schemas, err := db.GetRecordSchemas()
+type schemaExpiryPayload struct {
+	RecordType string  `mapstructure:"record_type"`
+	Field      *string `mapstructure:"field"`
+	TTL        *int64  `mapstructure:"ttl"`
+}
+
+func (payload *schemaExpiryPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaExpiryPayload) Validate() skyerr.Error {
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"record_type"})
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	if payload.TTL != nil && *payload.TTL < 0 {
+		return skyerr.NewInvalidArgument("ttl must not be negative", []string{"ttl"})
+	}
+	if payload.Field != nil && *payload.Field != "" && payload.TTL != nil && *payload.TTL != 0 {
+		return skyerr.NewInvalidArgument("only one of field and ttl can be specified", []string{"field", "ttl"})
+	}
+	return nil
+}
+
+// Expiry returns the record expiry specified by the payload.
+func (payload *schemaExpiryPayload) Expiry() skydb.RecordExpiry {
+	expiry := skydb.RecordExpiry{}
+	if payload.Field != nil {
+		expiry.Field = *payload.Field
+	}
+	if payload.TTL != nil {
+		expiry.TTL = time.Duration(*payload.TTL) * time.Second
+	}
+	return expiry
+}
+
+func (h *SchemaExpiryHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaExpiryPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db, ok := rpayload.Database.(skydb.RecordExpiryDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support record expiry")
+		return
+	}
+
+	if payload.Field != nil || payload.TTL != nil {
+		if err := db.SetRecordExpiry(payload.RecordType, payload.Expiry()); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+	}
+
+	expiry, err := db.GetRecordExpiry(payload.RecordType)
 	if err != nil {
 		response.Err = skyerr.MakeError(err)
 		return
 	}
 
-	response.Result = &schemaResponse{
-		Schemas: encodeRecordSchemas(schemas),
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"field":       expiry.Field,
+		"ttl":         int64(expiry.TTL / time.Second),
 	}
 }
 
@@ -770,3 +1474,381 @@ func (h *SchemaFieldAccessUpdateHandler) Handle(rpayload *router.Payload, respon
 
 	response.Result = schemaFieldAccessResponse{}.WithAccess(payload.FieldACL)
 }
+
+type schemaIndexExpression struct {
+	Function string `mapstructure:"function" json:"function"`
+	Field    string `mapstructure:"field" json:"field"`
+}
+
+type schemaIndexResponse struct {
+	Fields      []string                `json:"fields"`
+	Expressions []schemaIndexExpression `json:"expressions"`
+	Unique      bool                    `json:"unique"`
+	Status      skydb.IndexStatus       `json:"status"`
+	Definition  string                  `json:"definition,omitempty"`
+}
+
+func newSchemaIndexResponse(index skydb.Index) schemaIndexResponse {
+	r := schemaIndexResponse{
+		Fields:      index.Fields,
+		Expressions: []schemaIndexExpression{},
+		Unique:      index.Unique,
+		Status:      index.Status,
+		Definition:  index.Definition,
+	}
+	if r.Fields == nil {
+		r.Fields = []string{}
+	}
+	for _, expr := range index.Expressions {
+		r.Expressions = append(r.Expressions, schemaIndexExpression{
+			Function: string(expr.Function),
+			Field:    expr.Field,
+		})
+	}
+	return r
+}
+
+type schemaIndexPayload struct {
+	RecordType string `mapstructure:"record_type"`
+	Name       string `mapstructure:"name"`
+}
+
+func (payload *schemaIndexPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaIndexPayload) Validate() skyerr.Error {
+	if payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"record_type"})
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	return nil
+}
+
+/*
+SchemaIndexFetchHandler handles the action of fetching the indexes of a
+record type, and reports whether each index is ready, still building, or
+invalid because it failed to build.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/fetch <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:fetch",
+	"record_type": "note"
+}
+
+{
+	"result": {
+		"record_type": "note",
+		"indexes": {
+			"note_title_idx": {
+				"fields": ["title"],
+				"expressions": [],
+				"unique": false,
+				"status": "ready",
+				"definition": "CREATE INDEX note_title_idx ON app_demo.note USING btree (title)"
+			}
+		}
+	}
+}
+EOF
+*/
+type SchemaIndexFetchHandler struct {
+	Authenticator  router.Processor `preprocessor:"authenticator"`
+	DBConn         router.Processor `preprocessor:"dbconn"`
+	InjectUser     router.Processor `preprocessor:"inject_user"`
+	InjectPublicDB router.Processor `preprocessor:"inject_public_db"`
+	RequireAdmin   router.Processor `preprocessor:"require_admin"`
+	PluginReady    router.Processor `preprocessor:"plugin_ready"`
+	preprocessors  []router.Processor
+}
+
+func (h *SchemaIndexFetchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.InjectPublicDB,
+		h.RequireAdmin,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexFetchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaIndexFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaIndexPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	indexes, err := rpayload.Database.GetIndexesByRecordType(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	result := map[string]schemaIndexResponse{}
+	for name, index := range indexes {
+		result[name] = newSchemaIndexResponse(index)
+	}
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"indexes":     result,
+	}
+}
+
+type schemaIndexCreatePayload struct {
+	schemaIndexPayload `mapstructure:",squash"`
+	Fields             []string                `mapstructure:"fields"`
+	Expressions        []schemaIndexExpression `mapstructure:"expressions"`
+	Unique             bool                    `mapstructure:"unique"`
+	RawPredicate       []interface{}           `mapstructure:"predicate"`
+	Index              skydb.Index
+}
+
+func (payload *schemaIndexCreatePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if err := payload.schemaIndexPayload.Validate(); err != nil {
+		return err
+	}
+
+	payload.Index = skydb.Index{
+		Fields:      payload.Fields,
+		Expressions: []skydb.IndexExpression{},
+		Unique:      payload.Unique,
+	}
+	for _, expr := range payload.Expressions {
+		payload.Index.Expressions = append(payload.Index.Expressions, skydb.IndexExpression{
+			Function: skydb.IndexFunction(expr.Function),
+			Field:    expr.Field,
+		})
+	}
+
+	if payload.RawPredicate != nil {
+		parser := QueryParser{}
+		query := skydb.Query{}
+		rawQuery := map[string]interface{}{
+			"record_type": payload.RecordType,
+			"predicate":   payload.RawPredicate,
+		}
+		if err := parser.queryFromRaw(rawQuery, &query); err != nil {
+			return err
+		}
+		payload.Index.Predicate = query.Predicate
+	}
+
+	return payload.Validate()
+}
+
+func (payload *schemaIndexCreatePayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.Name == "" {
+		missingArgs = append(missingArgs, "name")
+	}
+	if len(payload.Index.Fields) == 0 && len(payload.Index.Expressions) == 0 {
+		missingArgs = append(missingArgs, "fields")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+
+	for _, expr := range payload.Index.Expressions {
+		if !expr.Function.IsValid() {
+			return skyerr.NewInvalidArgument(
+				`unknown index function "`+string(expr.Function)+`"`,
+				[]string{"expressions"},
+			)
+		}
+	}
+	return nil
+}
+
+/*
+SchemaIndexCreateHandler handles the action of creating an index on a
+record type. The index can be on multiple fields, on the lowercase or
+uppercase of string fields, unique, and partial with a predicate in the
+format of record:query.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/create <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:create",
+	"record_type": "user",
+	"name": "user_email_key",
+	"expressions": [{"function": "lower", "field": "email"}],
+	"unique": true,
+	"predicate": ["eq", {"$type": "keypath", "$val": "active"}, true]
+}
+EOF
+*/
+type SchemaIndexCreateHandler struct {
+	Authenticator  router.Processor `preprocessor:"authenticator"`
+	DBConn         router.Processor `preprocessor:"dbconn"`
+	InjectUser     router.Processor `preprocessor:"inject_user"`
+	InjectPublicDB router.Processor `preprocessor:"inject_public_db"`
+	RequireAdmin   router.Processor `preprocessor:"require_admin"`
+	PluginReady    router.Processor `preprocessor:"plugin_ready"`
+	preprocessors  []router.Processor
+}
+
+func (h *SchemaIndexCreateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.InjectPublicDB,
+		h.RequireAdmin,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexCreateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaIndexCreateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaIndexCreatePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	schema, err := db.GetSchema(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	if len(schema) == 0 {
+		response.Err = skyerr.NewErrorf(skyerr.ResourceNotFound, `record type "%s" does not exist`, payload.RecordType)
+		return
+	}
+	if err := validateIndexFields(schema, payload.Index); err != nil {
+		response.Err = err
+		return
+	}
+
+	if err := db.SaveIndex(payload.RecordType, payload.Name, payload.Index); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	indexes, err := db.GetIndexesByRecordType(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	index, ok := indexes[payload.Name]
+	if !ok {
+		response.Err = skyerr.NewErrorf(skyerr.UnexpectedError, `index "%s" is not found after creation`, payload.Name)
+		return
+	}
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"name":        payload.Name,
+		"index":       newSchemaIndexResponse(index),
+	}
+}
+
+// validateIndexFields checks that the indexed fields exist in the record
+// schema. Reserved fields such as _created_at are not in the record schema
+// and are allowed.
+func validateIndexFields(schema skydb.RecordSchema, index skydb.Index) skyerr.Error {
+	for _, field := range index.Fields {
+		if _, ok := schema[field]; !ok && !strings.HasPrefix(field, "_") {
+			return skyerr.NewInvalidArgument(`field "`+field+`" does not exist`, []string{"fields"})
+		}
+	}
+	for _, expr := range index.Expressions {
+		fieldType, ok := schema[expr.Field]
+		if !ok {
+			return skyerr.NewInvalidArgument(`field "`+expr.Field+`" does not exist`, []string{"expressions"})
+		}
+		if fieldType.Type != skydb.TypeString {
+			return skyerr.NewInvalidArgument(
+				`index function "`+string(expr.Function)+`" requires field "`+expr.Field+`" to be a string`,
+				[]string{"expressions"},
+			)
+		}
+	}
+	return nil
+}
+
+/*
+SchemaIndexDeleteHandler handles the action of deleting an index of a
+record type. An invalid index should be deleted and created again.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/delete <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:delete",
+	"record_type": "user",
+	"name": "user_email_key"
+}
+EOF
+*/
+type SchemaIndexDeleteHandler struct {
+	Authenticator  router.Processor `preprocessor:"authenticator"`
+	DBConn         router.Processor `preprocessor:"dbconn"`
+	InjectUser     router.Processor `preprocessor:"inject_user"`
+	InjectPublicDB router.Processor `preprocessor:"inject_public_db"`
+	RequireAdmin   router.Processor `preprocessor:"require_admin"`
+	PluginReady    router.Processor `preprocessor:"plugin_ready"`
+	preprocessors  []router.Processor
+}
+
+func (h *SchemaIndexDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectUser,
+		h.InjectPublicDB,
+		h.RequireAdmin,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaIndexDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaIndexPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+	if payload.Name == "" {
+		response.Err = skyerr.NewInvalidArgument("missing required fields", []string{"name"})
+		return
+	}
+
+	err := rpayload.Database.DeleteIndex(payload.RecordType, payload.Name)
+	if err == skydb.ErrIndexNotFound {
+		response.Err = skyerr.NewErrorf(skyerr.ResourceNotFound, `index "%s" does not exist`, payload.Name)
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"record_type": payload.RecordType,
+		"name":        payload.Name,
+	}
+}
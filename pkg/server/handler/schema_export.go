@@ -0,0 +1,758 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	pluginEvent "github.com/skygeario/skygear-server/pkg/server/plugin/event"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// schemaDocumentVersion is the version of the document produced by
+// schema:export. schema:import rejects a document of another version.
+const schemaDocumentVersion = 1
+
+type schemaDocument struct {
+	Version     int                                 `json:"version"`
+	RecordTypes map[string]schemaDocumentRecordType `json:"record_types"`
+	FieldAccess skydb.FieldACLEntryList             `json:"field_access"`
+}
+
+type schemaDocumentRecordType struct {
+	Fields        []schemaField                  `json:"fields"`
+	Indexes       map[string]schemaDocumentIndex `json:"indexes"`
+	CreateRoles   []string                       `json:"create_roles"`
+	DefaultAccess skydb.RecordACL                `json:"default_access"`
+}
+
+type schemaDocumentIndex struct {
+	Fields      []string                `mapstructure:"fields" json:"fields"`
+	Expressions []schemaIndexExpression `mapstructure:"expressions" json:"expressions"`
+	Unique      bool                    `mapstructure:"unique" json:"unique"`
+}
+
+func newSchemaDocumentIndex(index skydb.Index) schemaDocumentIndex {
+	r := newSchemaIndexResponse(index)
+	return schemaDocumentIndex{
+		Fields:      r.Fields,
+		Expressions: r.Expressions,
+		Unique:      r.Unique,
+	}
+}
+
+func (i schemaDocumentIndex) Index() skydb.Index {
+	index := skydb.Index{
+		Fields:      i.Fields,
+		Expressions: []skydb.IndexExpression{},
+		Unique:      i.Unique,
+	}
+	for _, expr := range i.Expressions {
+		index.Expressions = append(index.Expressions, skydb.IndexExpression{
+			Function: skydb.IndexFunction(expr.Function),
+			Field:    expr.Field,
+		})
+	}
+	return index
+}
+
+// schemaSnapshot is the part of the database schema covered by a schema
+// document. When decoded from a document to import, a nil Indexes,
+// CreateRoles, DefaultAccess or FieldAccess is not specified by the
+// document and is left unchanged.
+type schemaSnapshot struct {
+	RecordTypes map[string]*recordTypeSnapshot
+	FieldAccess skydb.FieldACLEntryList
+}
+
+type recordTypeSnapshot struct {
+	Schema        skydb.RecordSchema
+	Indexes       map[string]skydb.Index
+	CreateRoles   []string
+	DefaultAccess skydb.RecordACL
+}
+
+func newRecordTypeSnapshot() *recordTypeSnapshot {
+	return &recordTypeSnapshot{
+		Schema:        skydb.RecordSchema{},
+		Indexes:       map[string]skydb.Index{},
+		CreateRoles:   []string{},
+		DefaultAccess: skydb.RecordACL{},
+	}
+}
+
+// loadSchemaSnapshot reads the schema of all record types from the
+// database. Reserved fields and the index of the primary key are
+// excluded as they are managed by the database.
+func loadSchemaSnapshot(db skydb.Database, conn skydb.Conn) (*schemaSnapshot, error) {
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &schemaSnapshot{
+		RecordTypes: map[string]*recordTypeSnapshot{},
+	}
+	for recordType, schema := range schemas {
+		s := newRecordTypeSnapshot()
+		for field, fieldType := range schema {
+			if !strings.HasPrefix(field, "_") {
+				s.Schema[field] = fieldType
+			}
+		}
+
+		indexes, err := db.GetIndexesByRecordType(recordType)
+		if err != nil {
+			return nil, err
+		}
+		for name, index := range indexes {
+			if !isPrimaryKeyIndex(index) {
+				s.Indexes[name] = index
+			}
+		}
+
+		createAccess, err := conn.GetRecordAccess(recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, ace := range createAccess {
+			if ace.Role != "" {
+				s.CreateRoles = append(s.CreateRoles, ace.Role)
+			}
+		}
+		sort.Strings(s.CreateRoles)
+
+		defaultAccess, err := conn.GetRecordDefaultAccess(recordType)
+		if err != nil {
+			return nil, err
+		}
+		if defaultAccess != nil {
+			s.DefaultAccess = defaultAccess
+		}
+
+		snapshot.RecordTypes[recordType] = s
+	}
+
+	fieldACL, err := conn.GetRecordFieldAccess()
+	if err != nil {
+		return nil, err
+	}
+	snapshot.FieldAccess = schemaFieldAccessResponse{}.WithAccess(fieldACL).Access
+
+	return snapshot, nil
+}
+
+func isPrimaryKeyIndex(index skydb.Index) bool {
+	return index.Unique && len(index.Expressions) == 0 &&
+		reflect.DeepEqual(index.Fields, []string{"_id"})
+}
+
+// Document encodes the snapshot as a schema document.
+func (s *schemaSnapshot) Document() schemaDocument {
+	doc := schemaDocument{
+		Version:     schemaDocumentVersion,
+		RecordTypes: map[string]schemaDocumentRecordType{},
+		FieldAccess: s.FieldAccess,
+	}
+	for recordType, snapshot := range s.RecordTypes {
+		fieldList := encodeRecordSchemas(map[string]skydb.RecordSchema{
+			recordType: snapshot.Schema,
+		})[recordType]
+
+		indexes := map[string]schemaDocumentIndex{}
+		for name, index := range snapshot.Indexes {
+			indexes[name] = newSchemaDocumentIndex(index)
+		}
+
+		doc.RecordTypes[recordType] = schemaDocumentRecordType{
+			Fields:        fieldList.Fields,
+			Indexes:       indexes,
+			CreateRoles:   snapshot.CreateRoles,
+			DefaultAccess: snapshot.DefaultAccess,
+		}
+	}
+	return doc
+}
+
+/*
+SchemaExportHandler handles the action of exporting the schema of all
+record types, including fields, indexes, creation access, default access
+and field access, as a versioned document that can be imported with
+schema:import.
+
+The predicate of a partial index is not exported.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/export <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:export"
+}
+
+{
+	"result": {
+		"version": 1,
+		"record_types": {
+			"note": {
+				"fields": [
+					{"name": "title", "type": "string", "validation": {"required": true}}
+				],
+				"indexes": {
+					"note_title_idx": {"fields": ["title"], "expressions": [], "unique": false}
+				},
+				"create_roles": ["writer"],
+				"default_access": [{"public": true, "level": "read"}]
+			}
+		},
+		"field_access": []
+	}
+}
+EOF
+*/
+type SchemaExportHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaExportHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaExportHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaExportHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	snapshot, err := loadSchemaSnapshot(rpayload.Database, rpayload.DBConn)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = snapshot.Document()
+}
+
+type schemaImportRecordType struct {
+	Fields        []schemaField                  `mapstructure:"fields"`
+	Indexes       map[string]schemaDocumentIndex `mapstructure:"indexes"`
+	CreateRoles   []string                       `mapstructure:"create_roles"`
+	DefaultAccess []map[string]interface{}       `mapstructure:"default_access"`
+}
+
+type schemaImportDocument struct {
+	Version     int                               `mapstructure:"version"`
+	RecordTypes map[string]schemaImportRecordType `mapstructure:"record_types"`
+	FieldAccess []map[string]interface{}          `mapstructure:"field_access"`
+}
+
+type schemaImportPayload struct {
+	RawSchema map[string]interface{} `mapstructure:"schema"`
+	DryRun    bool                   `mapstructure:"dry_run"`
+	Prune     bool                   `mapstructure:"prune"`
+
+	Schema *schemaSnapshot
+}
+
+func (payload *schemaImportPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if payload.RawSchema == nil {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"schema"})
+	}
+
+	doc := schemaImportDocument{}
+	if err := mapstructure.Decode(payload.RawSchema, &doc); err != nil {
+		return skyerr.NewInvalidArgument("fails to decode the schema document", []string{"schema"})
+	}
+	if doc.Version != schemaDocumentVersion {
+		return skyerr.NewInvalidArgument(
+			fmt.Sprintf("unsupported schema document version %d", doc.Version),
+			[]string{"version"},
+		)
+	}
+
+	fields := schemaCreatePayload{
+		RawSchemas: map[string]schemaFieldList{},
+	}
+	for recordType, rt := range doc.RecordTypes {
+		fields.RawSchemas[recordType] = schemaFieldList{Fields: rt.Fields}
+	}
+	if err := fields.decodeSchemas(); err != nil {
+		return err
+	}
+
+	payload.Schema = &schemaSnapshot{
+		RecordTypes: map[string]*recordTypeSnapshot{},
+	}
+	for recordType, rt := range doc.RecordTypes {
+		s := &recordTypeSnapshot{
+			Schema:      fields.Schemas[recordType],
+			CreateRoles: rt.CreateRoles,
+		}
+		if s.CreateRoles != nil {
+			sort.Strings(s.CreateRoles)
+		}
+
+		if rt.Indexes != nil {
+			s.Indexes = map[string]skydb.Index{}
+			for name, rawIndex := range rt.Indexes {
+				index := rawIndex.Index()
+				if len(index.Fields) == 0 && len(index.Expressions) == 0 {
+					return skyerr.NewInvalidArgument(`index "`+name+`" has no fields or expressions`, []string{"indexes"})
+				}
+				for _, expr := range index.Expressions {
+					if !expr.Function.IsValid() {
+						return skyerr.NewInvalidArgument(
+							`unknown index function "`+string(expr.Function)+`"`,
+							[]string{"indexes"},
+						)
+					}
+				}
+				s.Indexes[name] = index
+			}
+		}
+
+		if rt.DefaultAccess != nil {
+			s.DefaultAccess = skydb.RecordACL{}
+			for _, v := range rt.DefaultAccess {
+				ace := skydb.RecordACLEntry{}
+				if err := (*skyconv.MapACLEntry)(&ace).FromMap(v); err != nil {
+					return skyerr.NewInvalidArgument("invalid default_access entry", []string{"default_access"})
+				}
+				s.DefaultAccess = append(s.DefaultAccess, ace)
+			}
+		}
+
+		payload.Schema.RecordTypes[recordType] = s
+	}
+
+	if doc.FieldAccess != nil {
+		entries := skydb.FieldACLEntryList{}
+		for _, v := range doc.FieldAccess {
+			ace := skydb.FieldACLEntry{}
+			if err := (*skyconv.MapFieldACLEntry)(&ace).FromMap(v); err != nil {
+				return skyerr.NewInvalidArgument("invalid field_access entry", []string{"field_access"})
+			}
+			entries = append(entries, ace)
+		}
+		sort.Sort(entries)
+		payload.Schema.FieldAccess = entries
+	}
+
+	return nil
+}
+
+// schemaChange is a change to the database schema found by diffSchema.
+type schemaChange struct {
+	Action     string      `json:"action"`
+	RecordType string      `json:"record_type,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	From       interface{} `json:"from,omitempty"`
+	To         interface{} `json:"to,omitempty"`
+
+	apply func(db skydb.Database, conn skydb.Conn) error
+}
+
+// diffSchema returns the changes to be applied to the current schema so
+// that it matches the target schema, in the order they are applied.
+// Record types are created first, so that reference fields can be added
+// to them. Fields and indexes not in the target schema are removed only
+// if prune is true. Record types not in the target schema are kept.
+//
+// An error is returned if the type of a field cannot be changed to the
+// type in the target schema.
+func diffSchema(current *schemaSnapshot, target *schemaSnapshot, prune bool) ([]schemaChange, skyerr.Error) {
+	var (
+		addRecordTypes   []schemaChange
+		removeIndexes    []schemaChange
+		updateFields     []schemaChange
+		updateReferences []schemaChange
+		removeFields     []schemaChange
+		addIndexes       []schemaChange
+		setAccess        []schemaChange
+	)
+
+	recordTypes := []string{}
+	for recordType := range target.RecordTypes {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+
+	for _, recordType := range recordTypes {
+		recordType := recordType
+		t := target.RecordTypes[recordType]
+		c, ok := current.RecordTypes[recordType]
+		if !ok {
+			c = newRecordTypeSnapshot()
+			addRecordTypes = append(addRecordTypes, schemaChange{
+				Action:     "add_record_type",
+				RecordType: recordType,
+				apply:      extendSchema(recordType, skydb.RecordSchema{}),
+			})
+		}
+
+		for _, field := range sortedSchemaFields(t.Schema) {
+			fieldType := t.Schema[field]
+			change := schemaChange{
+				RecordType: recordType,
+				Name:       field,
+			}
+
+			currentType, ok := c.Schema[field]
+			if !ok {
+				change.Action = "add_field"
+			} else if !currentType.DefinitionCompatibleTo(fieldType) {
+				return nil, skyerr.NewErrorf(
+					skyerr.IncompatibleSchema,
+					`conflicting type of field "%s" of record type "%s": %s => %s`,
+					field, recordType, currentType.ToSimpleName(), fieldType.ToSimpleName(),
+				)
+			} else if !fieldTypeChanged(currentType, fieldType) {
+				continue
+			} else {
+				// Rules not in the target schema are removed by
+				// extending the schema with empty rules.
+				if fieldType.Validation == nil {
+					fieldType.Validation = &skydb.FieldValidation{}
+				}
+				if fieldType.Default == nil {
+					fieldType.Default = &skydb.FieldDefault{}
+				}
+				change.Action = "update_field"
+				change.From = newSchemaField(field, currentType)
+			}
+			change.To = newSchemaField(field, fieldType)
+			change.apply = extendSchema(recordType, skydb.RecordSchema{field: fieldType})
+
+			if fieldType.Type == skydb.TypeReference {
+				updateReferences = append(updateReferences, change)
+			} else {
+				updateFields = append(updateFields, change)
+			}
+		}
+
+		if prune {
+			for _, field := range sortedSchemaFields(c.Schema) {
+				if _, ok := t.Schema[field]; ok {
+					continue
+				}
+				field := field
+				removeFields = append(removeFields, schemaChange{
+					Action:     "remove_field",
+					RecordType: recordType,
+					Name:       field,
+					From:       newSchemaField(field, c.Schema[field]),
+					apply: func(db skydb.Database, conn skydb.Conn) error {
+						return db.DeleteSchema(recordType, field)
+					},
+				})
+			}
+		}
+
+		if t.Indexes != nil {
+			// A changed index is removed and created again.
+			for _, name := range sortedIndexNames(c.Indexes) {
+				index, ok := t.Indexes[name]
+				if ok && indexEqual(c.Indexes[name], index) || !ok && !prune {
+					continue
+				}
+				name := name
+				removeIndexes = append(removeIndexes, schemaChange{
+					Action:     "remove_index",
+					RecordType: recordType,
+					Name:       name,
+					From:       newSchemaDocumentIndex(c.Indexes[name]),
+					apply: func(db skydb.Database, conn skydb.Conn) error {
+						return db.DeleteIndex(recordType, name)
+					},
+				})
+			}
+
+			schema := skydb.RecordSchema{}
+			for field, fieldType := range c.Schema {
+				schema[field] = fieldType
+			}
+			for field, fieldType := range t.Schema {
+				schema[field] = fieldType
+			}
+			for _, name := range sortedIndexNames(t.Indexes) {
+				index := t.Indexes[name]
+				if currentIndex, ok := c.Indexes[name]; ok && indexEqual(currentIndex, index) {
+					continue
+				}
+				if err := validateIndexFields(schema, index); err != nil {
+					return nil, err
+				}
+				name := name
+				addIndexes = append(addIndexes, schemaChange{
+					Action:     "add_index",
+					RecordType: recordType,
+					Name:       name,
+					To:         newSchemaDocumentIndex(index),
+					apply: func(db skydb.Database, conn skydb.Conn) error {
+						return db.SaveIndex(recordType, name, index)
+					},
+				})
+			}
+		}
+
+		if t.CreateRoles != nil && !reflect.DeepEqual(c.CreateRoles, t.CreateRoles) {
+			acl := skydb.RecordACL{}
+			for _, role := range t.CreateRoles {
+				acl = append(acl, skydb.NewRecordACLEntryRole(role, skydb.CreateLevel))
+			}
+			setAccess = append(setAccess, schemaChange{
+				Action:     "set_create_roles",
+				RecordType: recordType,
+				From:       c.CreateRoles,
+				To:         t.CreateRoles,
+				apply: func(db skydb.Database, conn skydb.Conn) error {
+					return conn.SetRecordAccess(recordType, acl)
+				},
+			})
+		}
+
+		if t.DefaultAccess != nil && !recordACLEqual(c.DefaultAccess, t.DefaultAccess) {
+			acl := t.DefaultAccess
+			setAccess = append(setAccess, schemaChange{
+				Action:     "set_default_access",
+				RecordType: recordType,
+				From:       c.DefaultAccess,
+				To:         acl,
+				apply: func(db skydb.Database, conn skydb.Conn) error {
+					return conn.SetRecordDefaultAccess(recordType, acl)
+				},
+			})
+		}
+	}
+
+	if target.FieldAccess != nil &&
+		!(len(current.FieldAccess) == 0 && len(target.FieldAccess) == 0) &&
+		!reflect.DeepEqual(current.FieldAccess, target.FieldAccess) {
+		fieldACL := skydb.NewFieldACL(target.FieldAccess)
+		setAccess = append(setAccess, schemaChange{
+			Action: "set_field_access",
+			From:   current.FieldAccess,
+			To:     target.FieldAccess,
+			apply: func(db skydb.Database, conn skydb.Conn) error {
+				return conn.SetRecordFieldAccess(fieldACL)
+			},
+		})
+	}
+
+	changes := []schemaChange{}
+	for _, c := range [][]schemaChange{
+		addRecordTypes,
+		removeIndexes,
+		updateFields,
+		updateReferences,
+		removeFields,
+		addIndexes,
+		setAccess,
+	} {
+		changes = append(changes, c...)
+	}
+	return changes, nil
+}
+
+func extendSchema(recordType string, schema skydb.RecordSchema) func(skydb.Database, skydb.Conn) error {
+	return func(db skydb.Database, conn skydb.Conn) error {
+		if _, err := db.Extend(recordType, schema); err != nil {
+			return skyerr.NewError(skyerr.IncompatibleSchema, err.Error())
+		}
+		return nil
+	}
+}
+
+func sortedSchemaFields(schema skydb.RecordSchema) []string {
+	fields := []string{}
+	for field := range schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func sortedIndexNames(indexes map[string]skydb.Index) []string {
+	names := []string{}
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fieldTypeChanged returns whether the referential action, validation
+// rules or default value of the field is changed. The referential action
+// is only changed if it is specified.
+func fieldTypeChanged(current skydb.FieldType, target skydb.FieldType) bool {
+	if target.OnDelete != skydb.NoReferentialAction && target.OnDelete != current.OnDelete {
+		return true
+	}
+	if current.Validation.IsEmpty() != target.Validation.IsEmpty() ||
+		!current.Validation.IsEmpty() && !reflect.DeepEqual(current.Validation, target.Validation) {
+		return true
+	}
+	if current.Default.IsEmpty() != target.Default.IsEmpty() ||
+		!current.Default.IsEmpty() && !reflect.DeepEqual(current.Default, target.Default) {
+		return true
+	}
+	return false
+}
+
+func indexEqual(a skydb.Index, b skydb.Index) bool {
+	return reflect.DeepEqual(newSchemaDocumentIndex(a), newSchemaDocumentIndex(b))
+}
+
+func recordACLEqual(a skydb.RecordACL, b skydb.RecordACL) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+/*
+SchemaImportHandler handles the action of importing a document exported
+by schema:export. The changes needed for the schema to match the document
+are returned, and are applied in one transaction unless dry_run is true.
+
+Fields and indexes not in the document are removed only if prune is true.
+Record types not in the document are kept. Settings missing from the
+document, such as field_access, are left unchanged.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/import <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:import",
+	"dry_run": true,
+	"schema": {
+		"version": 1,
+		"record_types": {
+			"note": {
+				"fields": [
+					{"name": "title", "type": "string"}
+				]
+			}
+		}
+	}
+}
+
+{
+	"result": {
+		"dry_run": true,
+		"changes": [
+			{"action": "add_record_type", "record_type": "note"},
+			{"action": "add_field", "record_type": "note", "name": "title", "to": {"name": "title", "type": "string"}}
+		]
+	}
+}
+EOF
+*/
+type SchemaImportHandler struct {
+	EventSender   pluginEvent.Sender `inject:"PluginEventSender"`
+	AccessKey     router.Processor   `preprocessor:"accesskey"`
+	DevOnly       router.Processor   `preprocessor:"dev_only"`
+	DBConn        router.Processor   `preprocessor:"dbconn"`
+	InjectDB      router.Processor   `preprocessor:"inject_db"`
+	PluginReady   router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaImportHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaImportHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaImportHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaImportPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	conn := rpayload.DBConn
+	current, err := loadSchemaSnapshot(db, conn)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	changes, skyErr := diffSchema(current, payload.Schema, payload.Prune)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if !payload.DryRun && len(changes) > 0 {
+		txDB, ok := db.(skydb.Transactional)
+		if !ok {
+			response.Err = skyerr.NewError(skyerr.NotSupported, "database impl does not support transaction")
+			return
+		}
+
+		err := skydb.WithTransaction(txDB, func() error {
+			for _, change := range changes {
+				if err := change.apply(db, conn); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		if h.EventSender != nil {
+			err := sendSchemaChangedEvent(h.EventSender, db)
+			if err != nil {
+				log.WithField("err", err).Warn("Fail to send schema changed event")
+			}
+		}
+	}
+
+	response.Result = map[string]interface{}{
+		"dry_run": payload.DryRun,
+		"changes": changes,
+	}
+}
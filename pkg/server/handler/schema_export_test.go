@@ -0,0 +1,373 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+)
+
+type schemaImportDatabase struct {
+	*skydbtest.MapDB
+	indexes   map[string]map[string]skydb.Index
+	began     int
+	committed int
+}
+
+func (db *schemaImportDatabase) GetIndexesByRecordType(recordType string) (map[string]skydb.Index, error) {
+	return db.indexes[recordType], nil
+}
+
+func (db *schemaImportDatabase) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	if db.indexes[recordType] == nil {
+		db.indexes[recordType] = map[string]skydb.Index{}
+	}
+	db.indexes[recordType][indexName] = index
+	return nil
+}
+
+func (db *schemaImportDatabase) DeleteIndex(recordType string, indexName string) error {
+	if _, ok := db.indexes[recordType][indexName]; !ok {
+		return skydb.ErrIndexNotFound
+	}
+	delete(db.indexes[recordType], indexName)
+	return nil
+}
+
+func (db *schemaImportDatabase) Begin() error {
+	db.began++
+	return nil
+}
+
+func (db *schemaImportDatabase) Commit() error {
+	db.committed++
+	return nil
+}
+
+func (db *schemaImportDatabase) Rollback() error {
+	return nil
+}
+
+func TestSchemaExportImportHandlers(t *testing.T) {
+	Convey("Schema export and import handlers", t, func() {
+		db := &schemaImportDatabase{
+			MapDB: skydbtest.NewMapDB(),
+			indexes: map[string]map[string]skydb.Index{
+				"note": map[string]skydb.Index{
+					"note_pkey": skydb.Index{
+						Fields: []string{"_id"},
+						Unique: true,
+					},
+					"note_title_idx": skydb.Index{
+						Fields: []string{"title"},
+					},
+				},
+			},
+		}
+		db.RecordSchemaMap["note"] = skydb.RecordSchema{
+			"_id": skydb.FieldType{Type: skydb.TypeString},
+			"title": skydb.FieldType{
+				Type:       skydb.TypeString,
+				Validation: &skydb.FieldValidation{Required: true},
+			},
+			"category": skydb.FieldType{Type: skydb.TypeString},
+		}
+
+		conn := skydbtest.NewMapConn()
+		conn.SetRecordAccess("note", skydb.RecordACL{
+			skydb.NewRecordACLEntryRole("writer", skydb.CreateLevel),
+		})
+		conn.SetRecordDefaultAccess("note", skydb.RecordACL{
+			skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+		})
+
+		injectDB := func(p *router.Payload) {
+			p.Database = db
+			p.DBConn = conn
+		}
+		exportRouter := handlertest.NewSingleRouteRouter(&SchemaExportHandler{}, injectDB)
+		importRouter := handlertest.NewSingleRouteRouter(&SchemaImportHandler{}, injectDB)
+
+		Convey("export schema", func() {
+			resp := exportRouter.POST(`{}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"version": 1,
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "category", "type": "string"},
+								{"name": "title", "type": "string", "validation": {"required": true}}
+							],
+							"indexes": {
+								"note_title_idx": {
+									"fields": ["title"],
+									"expressions": [],
+									"unique": false
+								}
+							},
+							"create_roles": ["writer"],
+							"default_access": [{"public": true, "level": "read"}]
+						}
+					},
+					"field_access": []
+				}
+			}`)
+		})
+
+		Convey("import exported schema without changes", func() {
+			resp := exportRouter.POST(`{}`)
+			exported := string(resp.Body.Bytes())
+			exported = exported[len(`{"result":`) : len(exported)-len("}\n")]
+
+			resp = importRouter.POST(`{"schema": ` + exported + `}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": false,
+					"changes": []
+				}
+			}`)
+			So(db.began, ShouldEqual, 0)
+		})
+
+		importDoc := `{
+			"version": 1,
+			"record_types": {
+				"comment": {
+					"fields": [
+						{"name": "note", "type": "ref(note)", "on_delete": "cascade"},
+						{"name": "body", "type": "string"}
+					]
+				},
+				"note": {
+					"fields": [
+						{"name": "title", "type": "string"},
+						{"name": "category", "type": "string"}
+					],
+					"create_roles": ["admin", "writer"]
+				}
+			}
+		}`
+
+		Convey("show changes in dry run", func() {
+			resp := importRouter.POST(`{"dry_run": true, "schema": ` + importDoc + `}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": true,
+					"changes": [
+						{"action": "add_record_type", "record_type": "comment"},
+						{
+							"action": "add_field",
+							"record_type": "comment",
+							"name": "body",
+							"to": {"name": "body", "type": "string"}
+						},
+						{
+							"action": "update_field",
+							"record_type": "note",
+							"name": "title",
+							"from": {"name": "title", "type": "string", "validation": {"required": true}},
+							"to": {"name": "title", "type": "string"}
+						},
+						{
+							"action": "add_field",
+							"record_type": "comment",
+							"name": "note",
+							"to": {"name": "note", "type": "ref(note)", "on_delete": "cascade"}
+						},
+						{
+							"action": "set_create_roles",
+							"record_type": "note",
+							"from": ["writer"],
+							"to": ["admin", "writer"]
+						}
+					]
+				}
+			}`)
+			So(db.RecordSchemaMap, ShouldNotContainKey, "comment")
+			So(db.began, ShouldEqual, 0)
+		})
+
+		Convey("apply changes in a transaction", func() {
+			resp := importRouter.POST(`{"schema": ` + importDoc + `}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			So(db.began, ShouldEqual, 1)
+			So(db.committed, ShouldEqual, 1)
+			So(db.RecordSchemaMap["comment"], ShouldResemble, skydb.RecordSchema{
+				"body": skydb.FieldType{Type: skydb.TypeString},
+				"note": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      skydb.CascadeAction,
+				},
+			})
+			So(db.RecordSchemaMap["note"]["title"].Validation.IsEmpty(), ShouldBeTrue)
+
+			acl, _ := conn.GetRecordAccess("note")
+			So(acl, ShouldResemble, skydb.RecordACL{
+				skydb.NewRecordACLEntryRole("admin", skydb.CreateLevel),
+				skydb.NewRecordACLEntryRole("writer", skydb.CreateLevel),
+			})
+		})
+
+		Convey("remove fields and indexes only with prune", func() {
+			doc := `{
+				"version": 1,
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "title", "type": "string", "validation": {"required": true}}
+						],
+						"indexes": {
+							"note_category_idx": {"fields": ["category"]}
+						}
+					}
+				}
+			}`
+
+			resp := importRouter.POST(`{"dry_run": true, "schema": ` + doc + `}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": true,
+					"changes": [
+						{
+							"action": "add_index",
+							"record_type": "note",
+							"name": "note_category_idx",
+							"to": {"fields": ["category"], "expressions": [], "unique": false}
+						}
+					]
+				}
+			}`)
+
+			resp = importRouter.POST(`{"prune": true, "schema": ` + doc + `}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": false,
+					"changes": [
+						{
+							"action": "remove_index",
+							"record_type": "note",
+							"name": "note_title_idx",
+							"from": {"fields": ["title"], "expressions": [], "unique": false}
+						},
+						{
+							"action": "remove_field",
+							"record_type": "note",
+							"name": "category",
+							"from": {"name": "category", "type": "string"}
+						},
+						{
+							"action": "add_index",
+							"record_type": "note",
+							"name": "note_category_idx",
+							"to": {"fields": ["category"], "expressions": [], "unique": false}
+						}
+					]
+				}
+			}`)
+			So(db.RecordSchemaMap["note"], ShouldNotContainKey, "category")
+			So(db.indexes["note"], ShouldNotContainKey, "note_title_idx")
+			So(db.indexes["note"], ShouldContainKey, "note_category_idx")
+		})
+
+		Convey("replace field access", func() {
+			resp := importRouter.POST(`{
+				"dry_run": true,
+				"schema": {
+					"version": 1,
+					"field_access": [{
+						"record_type": "note",
+						"record_field": "title",
+						"user_role": "_any_user",
+						"writable": false,
+						"readable": true,
+						"comparable": true,
+						"discoverable": true
+					}]
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": true,
+					"changes": [
+						{
+							"action": "set_field_access",
+							"from": [],
+							"to": [{
+								"record_type": "note",
+								"record_field": "title",
+								"user_role": "_any_user",
+								"writable": false,
+								"readable": true,
+								"comparable": true,
+								"discoverable": true
+							}]
+						}
+					]
+				}
+			}`)
+		})
+
+		Convey("reject conflicting field type", func() {
+			resp := importRouter.POST(`{
+				"schema": {
+					"version": 1,
+					"record_types": {
+						"note": {
+							"fields": [{"name": "title", "type": "number"}]
+						}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 114,
+					"message": "conflicting type of field \"title\" of record type \"note\": string => number",
+					"name": "IncompatibleSchema"
+				}
+			}`)
+			So(db.began, ShouldEqual, 0)
+		})
+
+		Convey("reject unsupported version", func() {
+			resp := importRouter.POST(`{
+				"schema": {"version": 2}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "unsupported schema document version 2",
+					"info": {"arguments": ["version"]},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
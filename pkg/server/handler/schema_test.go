@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
@@ -160,6 +161,65 @@ func TestSchemaCreatePayload(t *testing.T) {
 			So(skyErr, ShouldNotBeNil)
 		})
 
+		Convey("reference with on_delete", func() {
+			raw := []byte(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "collection", "type": "ref(collection)", "on_delete": "cascade"}
+						]
+					}
+				}
+			}`)
+			var data map[string]interface{}
+			err := json.Unmarshal(raw, &data)
+			So(err, ShouldBeNil)
+
+			skyErr := payload.Decode(data)
+			So(skyErr, ShouldBeNil)
+			So(payload.Schemas["note"]["collection"], ShouldResemble, skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "collection",
+				OnDelete:      skydb.CascadeAction,
+			})
+		})
+
+		Convey("on_delete of non-reference field", func() {
+			raw := []byte(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field1", "type": "string", "on_delete": "cascade"}
+						]
+					}
+				}
+			}`)
+			var data map[string]interface{}
+			err := json.Unmarshal(raw, &data)
+			So(err, ShouldBeNil)
+
+			skyErr := payload.Decode(data)
+			So(skyErr, ShouldNotBeNil)
+		})
+
+		Convey("unknown on_delete", func() {
+			raw := []byte(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "collection", "type": "ref(collection)", "on_delete": "explode"}
+						]
+					}
+				}
+			}`)
+			var data map[string]interface{}
+			err := json.Unmarshal(raw, &data)
+			So(err, ShouldBeNil)
+
+			skyErr := payload.Decode(data)
+			So(skyErr, ShouldNotBeNil)
+		})
+
 		Convey("reserved type", func() {
 			raw := []byte(`{
 				"record_types": {
@@ -329,6 +389,155 @@ func TestSchemaCreateHandler(t *testing.T) {
 			}`)
 		})
 
+		Convey("create fields with validation", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field1", "type": "string", "validation": {"required": true, "max_length": 10, "pattern": "^[a-z]+$"}},
+							{"name": "field3", "type": "number", "validation": {"min": 0, "max": 5}},
+							{"name": "field4", "type": "string", "validation": {"enum": ["draft", "published"]}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field1", "type": "string", "validation": {"required": true, "max_length": 10, "pattern": "^[a-z]+$"}},
+								{"name": "field2", "type": "datetime"},
+								{"name": "field3", "type": "number", "validation": {"min": 0, "max": 5}},
+								{"name": "field4", "type": "string", "validation": {"enum": ["draft", "published"]}}
+							]
+						}
+					}
+				}
+			}`)
+
+			Convey("and keep validation when the field is created again", func() {
+				router.POST(`{
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field3", "type": "number"}
+							]
+						}
+					}
+				}`)
+				So(db.RecordSchemaMap["note"]["field3"].Validation, ShouldNotBeNil)
+			})
+		})
+
+		Convey("create field with inapplicable validation", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "boolean", "validation": {"min": 1}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "min and max are only allowed for number field",
+					"info": {
+						"arguments": [
+							"field3"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("create field with invalid pattern", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "string", "validation": {"pattern": "[a-"}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 400)
+		})
+
+		Convey("create fields with default", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field1", "type": "string", "default": {"type": "literal", "value": ""}},
+							{"name": "field3", "type": "ref(user)", "default": {"type": "current_user"}},
+							{"name": "field4", "type": "string", "default": {"type": "expression", "expression": "_id"}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field1", "type": "string", "default": {"type": "literal", "value": ""}},
+								{"name": "field2", "type": "datetime"},
+								{"name": "field3", "type": "ref(user)", "default": {"type": "current_user"}},
+								{"name": "field4", "type": "string", "default": {"type": "expression", "expression": "_id"}}
+							]
+						}
+					}
+				}
+			}`)
+		})
+
+		Convey("create field with inapplicable default", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "number", "default": {"type": "now"}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "now default is only allowed for datetime field",
+					"info": {
+						"arguments": [
+							"field3"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("create field with mismatched literal default", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "number", "default": {"type": "literal", "value": "1"}}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 400)
+		})
+
 	})
 }
 
@@ -646,93 +855,577 @@ func TestSchemaDeleteHandler(t *testing.T) {
 	})
 }
 
-func TestSchemaFetchHandler(t *testing.T) {
-	Convey("SchemaFetchHandler", t, func() {
+type alterSchemaDatabase struct {
+	*skydbtest.MapDB
+	failures uint64
+	altered  map[string]skydb.FieldType
+}
+
+func (db *alterSchemaDatabase) CountAlterFailures(recordType, field string, fieldType skydb.FieldType) (uint64, error) {
+	return db.failures, nil
+}
+
+func (db *alterSchemaDatabase) AlterSchema(recordType, field string, fieldType skydb.FieldType) error {
+	if db.failures > 0 {
+		return skyerr.NewErrorf(skyerr.IncompatibleSchema, "%d records cannot be converted to %s", db.failures, fieldType.ToSimpleName())
+	}
+	db.altered[recordType+"."+field] = fieldType
+	return nil
+}
+
+func TestSchemaAlterHandler(t *testing.T) {
+	Convey("SchemaAlterHandler", t, func() {
 		note := skydb.RecordSchema{
-			"field1": skydb.FieldType{
+			"score": skydb.FieldType{
+				Type: skydb.TypeInteger,
+			},
+			"title": skydb.FieldType{
 				Type: skydb.TypeString,
 			},
-			"field2": skydb.FieldType{
+			"created": skydb.FieldType{
 				Type: skydb.TypeDateTime,
 			},
 		}
 
-		user := skydb.RecordSchema{}
-
-		db := skydbtest.NewMapDB()
-		var err error
-		_, err = db.Extend("note", note)
-		So(err, ShouldBeNil)
-		_, err = db.Extend("user", user)
+		db := &alterSchemaDatabase{
+			MapDB:   skydbtest.NewMapDB(),
+			altered: map[string]skydb.FieldType{},
+		}
+		_, err := db.Extend("note", note)
 		So(err, ShouldBeNil)
 
-		router := handlertest.NewSingleRouteRouter(&SchemaFetchHandler{}, func(p *router.Payload) {
+		router := handlertest.NewSingleRouteRouter(&SchemaAlterHandler{}, func(p *router.Payload) {
 			p.Database = db
 		})
 
-		Convey("fetch schemas", func() {
-			resp := router.POST(`{}`)
+		Convey("change integer to number", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "score",
+				"type": "number"
+			}`)
 
 			So(resp.Body.Bytes(), ShouldEqualJSON, `{
 				"result": {
-					"record_types": {
-						"note": {
-							"fields": [
-								{"name": "field1", "type": "string"},
-								{"name": "field2", "type": "datetime"}
-							]
-						},
-						"user": {
-							"fields": []
-						}
-					}
+					"record_type": "note",
+					"item_name": "score",
+					"type": "number",
+					"dry_run": false,
+					"failures": 0
 				}
 			}`)
+			So(db.altered["note.score"], ShouldResemble, skydb.FieldType{Type: skydb.TypeNumber})
 		})
-	})
-}
-
-func TestSchemaAccessPayload(t *testing.T) {
-	Convey("SchemaAccessPayload", t, func() {
-		Convey("Valid Data", func() {
-			payload := schemaAccessPayload{}
-			skyErr := payload.Decode(map[string]interface{}{
-				"action": "schema:access",
-				"type":   "script",
-				"create_roles": []string{
-					"Admin",
-					"Writer",
-				},
-			})
 
-			So(skyErr, ShouldBeNil)
-			So(payload.Validate(), ShouldBeNil)
+		Convey("count failures in dry run", func() {
+			db.failures = 3
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "title",
+				"type": "datetime",
+				"dry_run": true
+			}`)
 
-			roleNames := []string{}
-			for _, perACE := range payload.ACL {
-				if perACE.Role != "" {
-					roleNames = append(roleNames, perACE.Role)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"item_name": "title",
+					"type": "datetime",
+					"dry_run": true,
+					"failures": 3
 				}
-			}
-
-			So(roleNames, ShouldContain, "Admin")
-			So(roleNames, ShouldContain, "Writer")
+			}`)
+			So(db.altered, ShouldBeEmpty)
 		})
 
-		Convey("Invalid Data", func() {
-			payload := schemaAccessPayload{}
-			err := payload.Decode(map[string]interface{}{
-				"action": "schema:access",
-				"create_roles": []string{
-					"Admin",
-					"Writer",
-				},
-			})
+		Convey("reject when records cannot be converted", func() {
+			db.failures = 3
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "title",
+				"type": "datetime"
+			}`)
 
-			So(err, ShouldResemble,
-				skyerr.NewInvalidArgument("missing required fields", []string{"type"}))
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 114,
+					"message": "3 records cannot be converted to datetime",
+					"name": "IncompatibleSchema"
+				}
+			}`)
+		})
 
-			err = payload.Decode(map[string]interface{}{
+		Convey("reject unsupported conversion", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "created",
+				"type": "integer"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "cannot change type of field from datetime to integer",
+					"info": {
+						"arguments": [
+							"type"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("reject nonexisting field", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "notexist",
+				"type": "string"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 110,
+					"message": "field \"notexist\" of record type \"note\" does not exist",
+					"name": "ResourceNotFound"
+				}
+			}`)
+		})
+
+		Convey("reject on_delete for non-reference field", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "score",
+				"type": "string",
+				"on_delete": "cascade"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "on_delete is only allowed for reference field",
+					"info": {
+						"arguments": [
+							"on_delete"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
+
+func TestSchemaFetchHandler(t *testing.T) {
+	Convey("SchemaFetchHandler", t, func() {
+		note := skydb.RecordSchema{
+			"field1": skydb.FieldType{
+				Type: skydb.TypeString,
+			},
+			"field2": skydb.FieldType{
+				Type: skydb.TypeDateTime,
+			},
+			"owner": skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "user",
+				OnDelete:      skydb.CascadeAction,
+			},
+		}
+
+		user := skydb.RecordSchema{}
+
+		db := skydbtest.NewMapDB()
+		var err error
+		_, err = db.Extend("note", note)
+		So(err, ShouldBeNil)
+		_, err = db.Extend("user", user)
+		So(err, ShouldBeNil)
+
+		router := handlertest.NewSingleRouteRouter(&SchemaFetchHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("fetch schemas", func() {
+			resp := router.POST(`{}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field1", "type": "string"},
+								{"name": "field2", "type": "datetime"},
+								{"name": "owner", "type": "ref(user)", "on_delete": "cascade"}
+							]
+						},
+						"user": {
+							"fields": []
+						}
+					}
+				}
+			}`)
+		})
+	})
+}
+
+type searchIndexDatabase struct {
+	*skydbtest.MapDB
+	recordType string
+	field      string
+	language   string
+}
+
+func (db *searchIndexDatabase) SaveSearchIndex(recordType, field, language string) (string, error) {
+	db.recordType = recordType
+	db.field = field
+	db.language = language
+	return recordType + "_" + field + "_" + language + "_search", nil
+}
+
+func TestSchemaSearchIndexCreateHandler(t *testing.T) {
+	Convey("SchemaSearchIndexCreateHandler", t, func() {
+		db := &searchIndexDatabase{MapDB: skydbtest.NewMapDB()}
+
+		router := handlertest.NewSingleRouteRouter(&SchemaSearchIndexCreateHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("create search index", func() {
+			resp := router.POST(`{
+				"record_type": "article",
+				"field": "content",
+				"language": "english"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "article",
+					"field": "content",
+					"language": "english",
+					"name": "article_content_english_search"
+				}
+			}`)
+			So(db.recordType, ShouldEqual, "article")
+			So(db.field, ShouldEqual, "content")
+			So(db.language, ShouldEqual, "english")
+		})
+
+		Convey("create search index with default language", func() {
+			resp := router.POST(`{
+				"record_type": "article",
+				"field": "content"
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			So(db.language, ShouldEqual, "simple")
+		})
+
+		Convey("create search index with invalid language", func() {
+			resp := router.POST(`{
+				"record_type": "article",
+				"field": "content",
+				"language": "english'); DROP TABLE article; --"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "invalid search language",
+					"info": {
+						"arguments": [
+							"language"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+			So(db.recordType, ShouldEqual, "")
+		})
+	})
+}
+
+type softDeleteSchemaDatabase struct {
+	trashDatabase
+	enabled map[string]bool
+}
+
+func (db *softDeleteSchemaDatabase) SoftDeleteEnabled(recordType string) (bool, error) {
+	return db.enabled[recordType], nil
+}
+
+func (db *softDeleteSchemaDatabase) SetSoftDeleteEnabled(recordType string, enabled bool) error {
+	db.enabled[recordType] = enabled
+	return nil
+}
+
+func TestSchemaSoftDeleteHandler(t *testing.T) {
+	Convey("SchemaSoftDeleteHandler", t, func() {
+		db := &softDeleteSchemaDatabase{
+			trashDatabase: *newTrashDatabase(),
+			enabled:       map[string]bool{"note": true},
+		}
+
+		router := handlertest.NewSingleRouteRouter(&SchemaSoftDeleteHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("enable soft delete", func() {
+			resp := router.POST(`{
+				"record_type": "article",
+				"enabled": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "article",
+					"enabled": true
+				}
+			}`)
+			So(db.enabled["article"], ShouldBeTrue)
+		})
+
+		Convey("disable soft delete", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"enabled": false
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"enabled": false
+				}
+			}`)
+			So(db.enabled["note"], ShouldBeFalse)
+		})
+
+		Convey("fetch soft delete setting", func() {
+			resp := router.POST(`{
+				"record_type": "note"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"enabled": true
+				}
+			}`)
+		})
+
+		Convey("reject reserved record type", func() {
+			resp := router.POST(`{
+				"record_type": "_auth",
+				"enabled": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "attempts to change reserved table",
+					"info": {
+						"arguments": [
+							"record_type"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
+
+type expirySchemaDatabase struct {
+	*skydbtest.MapDB
+	expiries map[string]skydb.RecordExpiry
+	expired  map[skydb.RecordID]bool
+}
+
+func (db *expirySchemaDatabase) GetRecordExpiry(recordType string) (skydb.RecordExpiry, error) {
+	return db.expiries[recordType], nil
+}
+
+func (db *expirySchemaDatabase) SetRecordExpiry(recordType string, expiry skydb.RecordExpiry) error {
+	if expiry.IsEmpty() {
+		delete(db.expiries, recordType)
+	} else {
+		db.expiries[recordType] = expiry
+	}
+	return nil
+}
+
+func (db *expirySchemaDatabase) GetRecordExpiries() (map[string]skydb.RecordExpiry, error) {
+	return db.expiries, nil
+}
+
+func (db *expirySchemaDatabase) QueryExpired(recordType string, at time.Time, after string, limit uint64) ([]skydb.Record, error) {
+	return []skydb.Record{}, nil
+}
+
+func (db *expirySchemaDatabase) IsExpired(id skydb.RecordID) (bool, error) {
+	return db.expired[id], nil
+}
+
+func TestSchemaExpiryHandler(t *testing.T) {
+	Convey("SchemaExpiryHandler", t, func() {
+		db := &expirySchemaDatabase{
+			MapDB: skydbtest.NewMapDB(),
+			expiries: map[string]skydb.RecordExpiry{
+				"session": skydb.RecordExpiry{TTL: time.Hour},
+			},
+		}
+
+		router := handlertest.NewSingleRouteRouter(&SchemaExpiryHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("set expiry field", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"field": "expire_at"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"field": "expire_at",
+					"ttl": 0
+				}
+			}`)
+			So(db.expiries["note"], ShouldResemble, skydb.RecordExpiry{Field: "expire_at"})
+		})
+
+		Convey("set ttl", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"ttl": 60
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"field": "",
+					"ttl": 60
+				}
+			}`)
+			So(db.expiries["note"], ShouldResemble, skydb.RecordExpiry{TTL: time.Minute})
+		})
+
+		Convey("remove expiry", func() {
+			resp := router.POST(`{
+				"record_type": "session",
+				"ttl": 0
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "session",
+					"field": "",
+					"ttl": 0
+				}
+			}`)
+			So(db.expiries, ShouldNotContainKey, "session")
+		})
+
+		Convey("fetch expiry setting", func() {
+			resp := router.POST(`{
+				"record_type": "session"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "session",
+					"field": "",
+					"ttl": 3600
+				}
+			}`)
+		})
+
+		Convey("reject both field and ttl", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"field": "expire_at",
+				"ttl": 60
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "only one of field and ttl can be specified",
+					"info": {
+						"arguments": [
+							"field",
+							"ttl"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+			So(db.expiries, ShouldNotContainKey, "note")
+		})
+
+		Convey("reject negative ttl", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"ttl": -1
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "ttl must not be negative",
+					"info": {
+						"arguments": [
+							"ttl"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+}
+
+func TestSchemaAccessPayload(t *testing.T) {
+	Convey("SchemaAccessPayload", t, func() {
+		Convey("Valid Data", func() {
+			payload := schemaAccessPayload{}
+			skyErr := payload.Decode(map[string]interface{}{
+				"action": "schema:access",
+				"type":   "script",
+				"create_roles": []string{
+					"Admin",
+					"Writer",
+				},
+			})
+
+			So(skyErr, ShouldBeNil)
+			So(payload.Validate(), ShouldBeNil)
+
+			roleNames := []string{}
+			for _, perACE := range payload.ACL {
+				if perACE.Role != "" {
+					roleNames = append(roleNames, perACE.Role)
+				}
+			}
+
+			So(roleNames, ShouldContain, "Admin")
+			So(roleNames, ShouldContain, "Writer")
+		})
+
+		Convey("Invalid Data", func() {
+			payload := schemaAccessPayload{}
+			err := payload.Decode(map[string]interface{}{
+				"action": "schema:access",
+				"create_roles": []string{
+					"Admin",
+					"Writer",
+				},
+			})
+
+			So(err, ShouldResemble,
+				skyerr.NewInvalidArgument("missing required fields", []string{"type"}))
+
+			err = payload.Decode(map[string]interface{}{
 				"action":       "schema:access",
 				"type":         "script",
 				"create_roles": "Admin",
@@ -1117,3 +1810,265 @@ func TestSchemaFieldAccessUpdateHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestSchemaHistoryHandler(t *testing.T) {
+	Convey("SchemaHistoryHandler", t, func() {
+		db := newHistoryDatabase()
+
+		router := handlertest.NewSingleRouteRouter(&SchemaHistoryHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("enable history", func() {
+			resp := router.POST(`{
+				"record_type": "article",
+				"enabled": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "article",
+					"enabled": true
+				}
+			}`)
+			So(db.enabled["article"], ShouldBeTrue)
+		})
+
+		Convey("disable history", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"enabled": false
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"enabled": false
+				}
+			}`)
+			So(db.enabled["note"], ShouldBeFalse)
+		})
+	})
+}
+
+type indexDatabase struct {
+	*skydbtest.MapDB
+	indexes map[string]skydb.Index
+}
+
+func (db *indexDatabase) GetIndexesByRecordType(recordType string) (map[string]skydb.Index, error) {
+	return db.indexes, nil
+}
+
+func (db *indexDatabase) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	index.Status = skydb.IndexBuilding
+	db.indexes[indexName] = index
+	return nil
+}
+
+func (db *indexDatabase) DeleteIndex(recordType string, indexName string) error {
+	if _, ok := db.indexes[indexName]; !ok {
+		return skydb.ErrIndexNotFound
+	}
+	delete(db.indexes, indexName)
+	return nil
+}
+
+func TestSchemaIndexHandlers(t *testing.T) {
+	Convey("Schema index handlers", t, func() {
+		db := &indexDatabase{
+			MapDB: skydbtest.NewMapDB(),
+			indexes: map[string]skydb.Index{
+				"note_title_idx": skydb.Index{
+					Fields: []string{"title"},
+					Status: skydb.IndexReady,
+				},
+				"note_category_key": skydb.Index{
+					Fields: []string{"category"},
+					Unique: true,
+					Status: skydb.IndexInvalid,
+				},
+			},
+		}
+		db.RecordSchemaMap["note"] = skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"category": skydb.FieldType{Type: skydb.TypeString},
+			"order":    skydb.FieldType{Type: skydb.TypeNumber},
+		}
+		injectDB := func(p *router.Payload) {
+			p.Database = db
+		}
+
+		Convey("fetch indexes", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexFetchHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"indexes": {
+						"note_title_idx": {
+							"fields": ["title"],
+							"expressions": [],
+							"unique": false,
+							"status": "ready"
+						},
+						"note_category_key": {
+							"fields": ["category"],
+							"expressions": [],
+							"unique": true,
+							"status": "invalid"
+						}
+					}
+				}
+			}`)
+		})
+
+		Convey("create partial expression index", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_order_lower_title_idx",
+				"fields": ["order"],
+				"expressions": [{"function": "lower", "field": "title"}],
+				"unique": true,
+				"predicate": ["eq", {"$type": "keypath", "$val": "category"}, "news"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"name": "note_order_lower_title_idx",
+					"index": {
+						"fields": ["order"],
+						"expressions": [{"function": "lower", "field": "title"}],
+						"unique": true,
+						"status": "building"
+					}
+				}
+			}`)
+
+			index := db.indexes["note_order_lower_title_idx"]
+			So(index.Predicate, ShouldResemble, skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "category"},
+					skydb.Expression{Type: skydb.Literal, Value: "news"},
+				},
+			})
+		})
+
+		Convey("does not create index on non-existent field", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_author_idx",
+				"fields": ["author"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "field \"author\" does not exist",
+					"info": {
+						"arguments": ["fields"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+			So(db.indexes, ShouldNotContainKey, "note_author_idx")
+		})
+
+		Convey("does not create expression index on non-string field", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_order_idx",
+				"expressions": [{"function": "lower", "field": "order"}]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "index function \"lower\" requires field \"order\" to be a string",
+					"info": {
+						"arguments": ["expressions"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("does not create index with unknown function", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_title_idx2",
+				"expressions": [{"function": "md5", "field": "title"}]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "unknown index function \"md5\"",
+					"info": {
+						"arguments": ["expressions"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("does not create index without fields", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "missing required fields",
+					"info": {
+						"arguments": ["name", "fields"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("delete index", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexDeleteHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_category_key"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"name": "note_category_key"
+				}
+			}`)
+			So(db.indexes, ShouldNotContainKey, "note_category_key")
+		})
+
+		Convey("delete non-existent index", func() {
+			r := handlertest.NewSingleRouteRouter(&SchemaIndexDeleteHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"name": "note_not_exist"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 110,
+					"message": "index \"note_not_exist\" does not exist",
+					"name": "ResourceNotFound"
+				}
+			}`)
+		})
+	})
+}
@@ -40,8 +40,88 @@ func (s schemaFieldList) Less(i, j int) bool {
 }
 
 type schemaField struct {
-	Name     string `mapstructure:"name" json:"name"`
-	TypeName string `mapstructure:"type" json:"type"`
+	Name       string                 `mapstructure:"name" json:"name"`
+	TypeName   string                 `mapstructure:"type" json:"type"`
+	OnDelete   string                 `mapstructure:"on_delete" json:"on_delete,omitempty"`
+	Validation *schemaFieldValidation `mapstructure:"validation" json:"validation,omitempty"`
+	Default    *schemaFieldDefault    `mapstructure:"default" json:"default,omitempty"`
+}
+
+type schemaFieldValidation struct {
+	Required  bool          `mapstructure:"required" json:"required,omitempty"`
+	Min       *float64      `mapstructure:"min" json:"min,omitempty"`
+	Max       *float64      `mapstructure:"max" json:"max,omitempty"`
+	MinLength *int          `mapstructure:"min_length" json:"min_length,omitempty"`
+	MaxLength *int          `mapstructure:"max_length" json:"max_length,omitempty"`
+	Pattern   string        `mapstructure:"pattern" json:"pattern,omitempty"`
+	Enum      []interface{} `mapstructure:"enum" json:"enum,omitempty"`
+}
+
+func newSchemaFieldValidation(v *skydb.FieldValidation) *schemaFieldValidation {
+	if v.IsEmpty() {
+		return nil
+	}
+	return &schemaFieldValidation{
+		Required:  v.Required,
+		Min:       v.Min,
+		Max:       v.Max,
+		MinLength: v.MinLength,
+		MaxLength: v.MaxLength,
+		Pattern:   v.Pattern,
+		Enum:      v.Enum,
+	}
+}
+
+func (v *schemaFieldValidation) FieldValidation() *skydb.FieldValidation {
+	return &skydb.FieldValidation{
+		Required:  v.Required,
+		Min:       v.Min,
+		Max:       v.Max,
+		MinLength: v.MinLength,
+		MaxLength: v.MaxLength,
+		Pattern:   v.Pattern,
+		Enum:      v.Enum,
+	}
+}
+
+type schemaFieldDefault struct {
+	Type       string      `mapstructure:"type"`
+	Value      interface{} `mapstructure:"value"`
+	Expression string      `mapstructure:"expression"`
+}
+
+func newSchemaFieldDefault(d *skydb.FieldDefault) *schemaFieldDefault {
+	if d.IsEmpty() {
+		return nil
+	}
+	return &schemaFieldDefault{
+		Type:       string(d.Type),
+		Value:      d.Value,
+		Expression: d.Expression,
+	}
+}
+
+func (d *schemaFieldDefault) FieldDefault() *skydb.FieldDefault {
+	return &skydb.FieldDefault{
+		Type:       skydb.DefaultType(d.Type),
+		Value:      d.Value,
+		Expression: d.Expression,
+	}
+}
+
+// MarshalJSON encodes the value only for a literal default, as a literal
+// value can be null.
+func (d *schemaFieldDefault) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"type": d.Type,
+	}
+	switch skydb.DefaultType(d.Type) {
+	case skydb.LiteralDefault:
+		m["value"] = d.Value
+	case skydb.ExpressionDefault:
+		m["expression"] = d.Expression
+	}
+	return json.Marshal(m)
 }
 
 func encodeRecordSchemas(data map[string]skydb.RecordSchema) map[string]schemaFieldList {
@@ -57,10 +137,7 @@ func encodeRecordSchemas(data map[string]skydb.RecordSchema) map[string]schemaFi
 				continue
 			}
 
-			fieldList.Fields = append(fieldList.Fields, schemaField{
-				Name:     fieldName,
-				TypeName: val.ToSimpleName(),
-			})
+			fieldList.Fields = append(fieldList.Fields, newSchemaField(fieldName, val))
 		}
 		sort.Sort(fieldList)
 		schemaMap[recordType] = fieldList
@@ -69,6 +146,16 @@ func encodeRecordSchemas(data map[string]skydb.RecordSchema) map[string]schemaFi
 	return schemaMap
 }
 
+func newSchemaField(name string, fieldType skydb.FieldType) schemaField {
+	return schemaField{
+		Name:       name,
+		TypeName:   fieldType.ToSimpleName(),
+		OnDelete:   string(fieldType.OnDelete),
+		Validation: newSchemaFieldValidation(fieldType.Validation),
+		Default:    newSchemaFieldDefault(fieldType.Default),
+	}
+}
+
 func sendSchemaChangedEvent(sender pluginEvent.Sender, db skydb.Database) error {
 	schemas, err := db.GetRecordSchemas()
 	if err != nil {
@@ -162,6 +162,14 @@ func opString(op skydb.Operator) string {
 		return "ilike"
 	case skydb.In:
 		return "in"
+	case skydb.ContainsAny:
+		return "contains_any"
+	case skydb.ContainsAll:
+		return "contains_all"
+	case skydb.Contains:
+		return "contains"
+	case skydb.HasKey:
+		return "has_key"
 	default:
 		return "UNKNOWN_OPERATOR"
 	}
@@ -0,0 +1,485 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+/*
+RecordTrashHandler queries records in trash of a record type with soft
+delete enabled. The payload is the same as that of record:query, except
+that aggregation is not supported.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:trash",
+    "access_token": "validToken",
+    "database_id": "_private",
+    "record_type": "note",
+    "sort": [
+        [{"$val": "_updated_at", "$type": "keypath"}, "desc"]
+    ]
+}
+EOF
+*/
+type RecordTrashHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordTrashHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordTrashHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordTrashHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordQueryPayload{}
+	parser := QueryParser{UserID: payload.AuthInfoID}
+	skyErr := p.Decode(payload.Data, &parser)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if len(p.Query.Aggregations) > 0 {
+		response.Err = skyerr.NewInvalidArgument(
+			"aggregation is not supported when querying trash",
+			[]string{"aggregate"},
+		)
+		return
+	}
+
+	db, ok := payload.Database.(skydb.SoftDeleteDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+		return
+	}
+
+	if payload.AuthInfo != nil {
+		p.Query.ViewAsUser = payload.AuthInfo
+	}
+
+	if payload.HasMasterKey() {
+		p.Query.BypassAccessControl = true
+	}
+
+	if !p.Query.BypassAccessControl {
+		fieldACL, err := payload.DBConn.GetRecordFieldAccess()
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+
+		visitor := &queryAccessVisitor{
+			FieldACL:   fieldACL,
+			RecordType: p.Query.Type,
+			AuthInfo:   p.Query.ViewAsUser,
+			ExpressionACLChecker: ExpressionACLChecker{
+				FieldACL:   fieldACL,
+				RecordType: p.Query.Type,
+				AuthInfo:   payload.AuthInfo,
+				Database:   payload.Database,
+			},
+		}
+		p.Query.Accept(visitor)
+		if err := visitor.Error(); err != nil {
+			response.Err = err
+			return
+		}
+	}
+
+	results, err := db.QueryTrash(&p.Query)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	defer results.Close()
+
+	records := []skydb.Record{}
+	for results.Scan() {
+		records = append(records, results.Record())
+	}
+
+	if results.Err() != nil {
+		response.Err = skyerr.MakeError(results.Err())
+		return
+	}
+
+	recordutil.MakeAssetsComplete(payload.Database, payload.DBConn, records)
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		p.Query.BypassAccessControl,
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	output := make([]interface{}, len(records))
+	for i := range records {
+		record := records[i]
+		output[i] = resultFilter.JSONResult(&record)
+	}
+
+	response.Result = output
+}
+
+/*
+RecordRestoreHandler moves records out of trash
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:restore",
+    "access_token": "validToken",
+    "database_id": "_private",
+    "ids": ["note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8"]
+}
+EOF
+*/
+type RecordRestoreHandler struct {
+	HookRegistry  *hook.Registry   `inject:"HookRegistry"`
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	RequireAuth   router.Processor `preprocessor:"require_auth"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordRestoreHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.RequireAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordRestoreHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordRestoreHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordDeletePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:               payload.Database,
+		Conn:             payload.DBConn,
+		AssetStore:       h.AssetStore,
+		HookRegistry:     h.HookRegistry,
+		TrashedRecordIDs: p.RecordIDs,
+		Atomic:           p.Atomic,
+		WithMasterKey:    payload.HasMasterKey(),
+		Context:          payload.Context,
+		AuthInfo:         payload.AuthInfo,
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+
+	var restoreFunc recordModifyFunc
+	if p.Atomic {
+		restoreFunc = atomicModifyFunc(&req, &resp, recordutil.RecordRestoreHandler)
+	} else {
+		restoreFunc = recordutil.RecordRestoreHandler
+	}
+
+	if err := restoreFunc(&req, &resp); err != nil {
+		log.Debugf("Failed to restore records: %v", err)
+		response.Err = err
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	restoredRecords := map[skydb.RecordID]*skydb.Record{}
+	for _, record := range resp.SavedRecords {
+		restoredRecords[record.ID] = record
+	}
+
+	results := make([]interface{}, 0, p.ItemLen())
+	for _, recordID := range p.RecordIDs {
+		var result interface{}
+
+		if err, ok := resp.ErrMap[recordID]; ok {
+			log.WithFields(logrus.Fields{
+				"recordID": recordID,
+				"err":      err,
+			}).Debugln("failed to restore record")
+			result = newSerializedError(
+				recordID.String(),
+				err,
+			)
+		} else {
+			result = resultFilter.JSONResult(restoredRecords[recordID])
+		}
+
+		results = append(results, result)
+	}
+
+	response.Result = results
+}
+
+type recordPurgePayload struct {
+	RawIDs     []string `mapstructure:"ids"`
+	Atomic     bool     `mapstructure:"atomic"`
+	RecordType string   `mapstructure:"record_type"`
+	RawBefore  string   `mapstructure:"before"`
+
+	RecordIDs     []skydb.RecordID
+	DeletedBefore time.Time
+}
+
+func (payload *recordPurgePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordPurgePayload) Validate() skyerr.Error {
+	if payload.RecordType == "" && payload.RawBefore == "" {
+		idsPayload := recordDeletePayload{RawIDs: payload.RawIDs}
+		if err := idsPayload.Validate(); err != nil {
+			return err
+		}
+		payload.RecordIDs = idsPayload.RecordIDs
+		return nil
+	}
+
+	if len(payload.RawIDs) > 0 {
+		return skyerr.NewInvalidArgument(
+			"ids cannot be specified together with record_type and before",
+			[]string{"ids"},
+		)
+	}
+
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.RawBefore == "" {
+		missingArgs = append(missingArgs, "before")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+
+	before, err := time.Parse(time.RFC3339Nano, payload.RawBefore)
+	if err != nil {
+		return skyerr.NewInvalidArgument("before is not a valid time", []string{"before"})
+	}
+	payload.DeletedBefore = before
+	return nil
+}
+
+func (payload *recordPurgePayload) IsPurgingByAge() bool {
+	return payload.RecordType != ""
+}
+
+/*
+RecordPurgeHandler permanently removes records in trash, either by ids
+or, with master key, those of a record type moved to trash before the
+specified time.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:purge",
+    "access_token": "validToken",
+    "database_id": "_private",
+    "ids": ["note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8"]
+}
+EOF
+
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:purge",
+    "master_key": "MASTER_KEY",
+    "database_id": "_public",
+    "record_type": "note",
+    "before": "2017-01-01T00:00:00Z"
+}
+EOF
+*/
+type RecordPurgeHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	RequireAuth   router.Processor `preprocessor:"require_auth"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordPurgeHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.RequireAuth,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordPurgeHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordPurgeHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordPurgePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	if p.IsPurgingByAge() {
+		h.purgeByAge(payload, response, p)
+		return
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:               payload.Database,
+		Conn:             payload.DBConn,
+		TrashedRecordIDs: p.RecordIDs,
+		Atomic:           p.Atomic,
+		WithMasterKey:    payload.HasMasterKey(),
+		Context:          payload.Context,
+		AuthInfo:         payload.AuthInfo,
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+
+	var purgeFunc recordModifyFunc
+	if p.Atomic {
+		purgeFunc = atomicModifyFunc(&req, &resp, recordutil.RecordPurgeHandler)
+	} else {
+		purgeFunc = recordutil.RecordPurgeHandler
+	}
+
+	if err := purgeFunc(&req, &resp); err != nil {
+		log.Debugf("Failed to purge records: %v", err)
+		response.Err = err
+		return
+	}
+
+	results := make([]interface{}, 0, len(p.RecordIDs))
+	for _, recordID := range p.RecordIDs {
+		var result interface{}
+
+		if err, ok := resp.ErrMap[recordID]; ok {
+			log.WithFields(logrus.Fields{
+				"recordID": recordID,
+				"err":      err,
+			}).Debugln("failed to purge record")
+			result = newSerializedError(
+				recordID.String(),
+				err,
+			)
+		} else {
+			result = struct {
+				ID   skydb.RecordID `json:"_id"`
+				Type string         `json:"_type"`
+			}{recordID, "record"}
+		}
+
+		results = append(results, result)
+	}
+
+	response.Result = results
+}
+
+func (h *RecordPurgeHandler) purgeByAge(payload *router.Payload, response *router.Response, p *recordPurgePayload) {
+	if !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "purging records by age requires master key")
+		return
+	}
+
+	db, ok := payload.Database.(skydb.SoftDeleteDatabase)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+		return
+	}
+
+	count, err := db.PurgeTrash(p.RecordType, p.DeletedBefore)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = map[string]interface{}{
+		"record_type": p.RecordType,
+		"purged":      count,
+	}
+}
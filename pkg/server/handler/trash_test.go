@@ -0,0 +1,309 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// trashDatabase is a MapDB with soft delete enabled for all record types
+type trashDatabase struct {
+	*skydbtest.MapDB
+	trash map[string]skydb.Record
+}
+
+func newTrashDatabase() *trashDatabase {
+	return &trashDatabase{
+		MapDB: skydbtest.NewMapDB(),
+		trash: map[string]skydb.Record{},
+	}
+}
+
+func (db *trashDatabase) Delete(id skydb.RecordID) error {
+	record := skydb.Record{}
+	if err := db.Get(id, &record); err != nil {
+		return err
+	}
+	record.DeletedAt = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	db.trash[id.String()] = record
+	return db.MapDB.Delete(id)
+}
+
+func (db *trashDatabase) SoftDeleteEnabled(recordType string) (bool, error) {
+	return true, nil
+}
+
+func (db *trashDatabase) SetSoftDeleteEnabled(recordType string, enabled bool) error {
+	return nil
+}
+
+func (db *trashDatabase) GetTrashed(id skydb.RecordID, record *skydb.Record) error {
+	trashed, ok := db.trash[id.String()]
+	if !ok {
+		return skydb.ErrRecordNotFound
+	}
+	*record = trashed
+	return nil
+}
+
+func (db *trashDatabase) QueryTrash(query *skydb.Query) (*skydb.Rows, error) {
+	keys := []string{}
+	for key, record := range db.trash {
+		if record.ID.Type == query.Type {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	records := []skydb.Record{}
+	for _, key := range keys {
+		records = append(records, db.trash[key])
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *trashDatabase) Restore(id skydb.RecordID) error {
+	record, ok := db.trash[id.String()]
+	if !ok {
+		return skydb.ErrRecordNotFound
+	}
+	delete(db.trash, id.String())
+	record.DeletedAt = time.Time{}
+	db.RecordMap[id.String()] = record
+	return nil
+}
+
+func (db *trashDatabase) Purge(id skydb.RecordID) error {
+	if _, ok := db.trash[id.String()]; !ok {
+		return skydb.ErrRecordNotFound
+	}
+	delete(db.trash, id.String())
+	return nil
+}
+
+func (db *trashDatabase) PurgeTrash(recordType string, before time.Time) (int64, error) {
+	var count int64
+	for key, record := range db.trash {
+		if record.ID.Type == recordType && record.DeletedAt.Before(before) {
+			delete(db.trash, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestRecordTrashHandlers(t *testing.T) {
+	Convey("Given a Database with records in trash", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := newTrashDatabase()
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "0"),
+			OwnerID: "user0",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user0", skydb.WriteLevel),
+			},
+			Data: skydb.Data{"content": "deleted"},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "readonly"),
+			OwnerID: "user1",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user0", skydb.ReadLevel),
+			},
+			Data: skydb.Data{"content": "readonly"},
+		}), ShouldBeNil)
+		So(db.Delete(skydb.NewRecordID("note", "0")), ShouldBeNil)
+		So(db.Delete(skydb.NewRecordID("note", "readonly")), ShouldBeNil)
+
+		injectDB := func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		}
+
+		Convey("queries records in trash", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordTrashHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/0",
+					"_type": "record",
+					"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+					"_ownerID": "user0",
+					"_deleted_at": "2017-01-02T03:04:05Z",
+					"content": "deleted"
+				}, {
+					"_id": "note/readonly",
+					"_type": "record",
+					"_access": [{"relation": "$direct", "user_id": "user0", "level": "read"}],
+					"_ownerID": "user1",
+					"_deleted_at": "2017-01-02T03:04:05Z",
+					"content": "readonly"
+				}]
+			}`)
+		})
+
+		Convey("rejects aggregation on trash", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordTrashHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"aggregate": {
+					"count": ["func", "count"]
+				}
+			}`)
+			So(resp.Code, ShouldEqual, 400)
+		})
+
+		Convey("restores records", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordRestoreHandler{}, injectDB)
+			resp := r.POST(`{
+				"ids": ["note/0", "note/readonly", "note/notexist"]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/0",
+					"_type": "record",
+					"_access": [{"relation": "$direct", "user_id": "user0", "level": "write"}],
+					"_ownerID": "user0",
+					"content": "deleted"
+				}, {
+					"_id": "note/readonly",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to perform operation",
+					"name": "PermissionDenied"
+				}, {
+					"_id": "note/notexist",
+					"_type": "error",
+					"code": 110,
+					"message": "record not found",
+					"name": "ResourceNotFound"
+				}]
+			}`)
+			So(db.RecordMap, ShouldContainKey, "note/0")
+			So(db.trash, ShouldNotContainKey, "note/0")
+		})
+
+		Convey("purges records by ids", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordPurgeHandler{}, injectDB)
+			resp := r.POST(`{
+				"ids": ["note/0", "note/readonly"]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/0",
+					"_type": "record"
+				}, {
+					"_id": "note/readonly",
+					"_type": "error",
+					"code": 102,
+					"message": "no permission to perform operation",
+					"name": "PermissionDenied"
+				}]
+			}`)
+			So(db.trash, ShouldNotContainKey, "note/0")
+			So(db.trash, ShouldContainKey, "note/readonly")
+		})
+
+		Convey("purges records by age with master key", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordPurgeHandler{}, func(p *router.Payload) {
+				injectDB(p)
+				p.AccessKey = router.MasterAccessKey
+			})
+			resp := r.POST(`{
+				"record_type": "note",
+				"before": "2017-02-01T00:00:00Z"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"purged": 2
+				}
+			}`)
+			So(db.trash, ShouldBeEmpty)
+		})
+
+		Convey("does not purge records by age without master key", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordPurgeHandler{}, injectDB)
+			resp := r.POST(`{
+				"record_type": "note",
+				"before": "2017-02-01T00:00:00Z"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 102,
+					"message": "purging records by age requires master key",
+					"name": "PermissionDenied"
+				}
+			}`)
+			So(db.trash, ShouldHaveLength, 2)
+		})
+
+		Convey("does not create record with the id of a record in trash", func() {
+			r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, injectDB)
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/0",
+					"content": "new"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/0",
+					"_type": "error",
+					"code": 109,
+					"message": "record is in trash",
+					"name": "Duplicated"
+				}]
+			}`)
+		})
+	})
+
+	Convey("Given a Database not supporting soft delete", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+
+		r := handlertest.NewSingleRouteRouter(&RecordTrashHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+		})
+
+		Convey("returns not supported", func() {
+			resp := r.POST(`{
+				"record_type": "note"
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 111,
+					"message": "database does not support soft delete",
+					"name": "NotSupported"
+				}
+			}`)
+		})
+	})
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -28,7 +29,10 @@ func CreateHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.Func {
 	hookFunc := func(ctx context.Context, record *skydb.Record, oldRecord *skydb.Record) skyerr.Error {
 		recordout, err := p.transport.RunHook(ctx, hookInfo.Name, record, oldRecord, hookInfo.Async)
 		if err == nil && hookInfo.Trigger == string(hook.BeforeSave) && !hookInfo.Async {
-			*record = *recordout
+			err = parseHookFieldOperations(recordout, record)
+			if err == nil {
+				*record = *recordout
+			}
 		}
 
 		if err == nil {
@@ -51,3 +55,27 @@ func CreateHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.Func {
 
 	return hookFunc
 }
+
+// parseHookFieldOperations parses the field operations in the record
+// returned by a before save hook. Only the fields that are field operations
+// in the record passed to the hook are parsed, so that a JSON object
+// set by the hook is not mistaken for a field operation.
+func parseHookFieldOperations(recordout *skydb.Record, record *skydb.Record) error {
+	data := map[string]interface{}{}
+	for key, value := range record.Data {
+		if _, ok := value.(skydb.FieldOperation); !ok {
+			continue
+		}
+		if outValue, ok := recordout.Data[key]; ok {
+			data[key] = outValue
+		}
+	}
+
+	if err := skyconv.ParseFieldOperations(data); err != nil {
+		return skyerr.NewError(skyerr.InvalidArgument, err.Error())
+	}
+	for key, value := range data {
+		recordout.Data[key] = value
+	}
+	return nil
+}
@@ -72,6 +72,35 @@ func TestCreateHookFunc(t *testing.T) {
 			})
 		})
 
+		Convey("synced before save with field operations", func() {
+			hookFunc := CreateHookFunc(&plugin, pluginHookInfo{
+				Async:   false,
+				Trigger: string(hook.BeforeSave),
+				Type:    "note",
+				Name:    "note_beforeSave",
+			})
+
+			recordin.Data = skydb.Data{
+				"likes": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(1)},
+			}
+			transport.RunHookFunc = func(ctx context.Context, hookName string, record *skydb.Record, originalRecord *skydb.Record) (*skydb.Record, error) {
+				return &skydb.Record{
+					ID: skydb.NewRecordID("note", "id"),
+					Data: skydb.Data{
+						"likes":    map[string]interface{}{"$inc": float64(2)},
+						"settings": map[string]interface{}{"$inc": float64(3)},
+					},
+				}, nil
+			}
+
+			err := hookFunc(nil, &recordin, &originalRecord)
+			So(err, ShouldBeNil)
+			So(recordin.Data, ShouldResemble, skydb.Data{
+				"likes":    skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+				"settings": map[string]interface{}{"$inc": float64(3)},
+			})
+		})
+
 		Convey("synced before save error result", func() {
 			hookFunc := CreateHookFunc(&plugin, pluginHookInfo{
 				Async:   false,
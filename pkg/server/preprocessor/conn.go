@@ -43,6 +43,10 @@ func (p ConnPreprocessor) Preprocess(payload *router.Payload, response *router.R
 	}
 	payload.DBConn = conn
 
+	if replicaConn, ok := conn.(skydb.ReplicaConn); ok && payload.ReadYourWrites() {
+		replicaConn.SetReadYourWrites(true)
+	}
+
 	log.Debugf("Get DB OK")
 
 	return http.StatusOK
@@ -15,6 +15,7 @@
 package preprocessor
 
 import (
+	"context"
 	"net/http"
 	"testing"
 	"time"
@@ -68,6 +69,55 @@ func (db *injectDatabasePreprocessorDB) ID() string {
 	return db.userID
 }
 
+type replicaConn struct {
+	skydb.Conn
+	readYourWrites bool
+}
+
+func (conn *replicaConn) SetReadYourWrites(enabled bool) {
+	conn.readYourWrites = enabled
+}
+
+func (conn *replicaConn) ReadYourWrites() bool {
+	return conn.readYourWrites
+}
+
+func TestConnPreprocessor(t *testing.T) {
+	Convey("ConnPreprocessor", t, func() {
+		conn := &replicaConn{}
+		pp := ConnPreprocessor{
+			DBOpener: func(context.Context, string, string, string, string, bool) (skydb.Conn, error) {
+				return conn, nil
+			},
+		}
+
+		Convey("should read from replicas by default", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{},
+				Meta: map[string]interface{}{},
+			}
+			resp := router.Response{}
+
+			So(pp.Preprocess(&payload, &resp), ShouldEqual, http.StatusOK)
+			So(payload.DBConn, ShouldEqual, conn)
+			So(conn.readYourWrites, ShouldBeFalse)
+		})
+
+		Convey("should read your writes if requested", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"read_your_writes": true,
+				},
+				Meta: map[string]interface{}{},
+			}
+			resp := router.Response{}
+
+			So(pp.Preprocess(&payload, &resp), ShouldEqual, http.StatusOK)
+			So(conn.readYourWrites, ShouldBeTrue)
+		})
+	})
+}
+
 func TestInjectDatabaseProcessor(t *testing.T) {
 	Convey("InjectDatabase", t, func() {
 		pp := InjectDatabase{}
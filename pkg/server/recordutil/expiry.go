@@ -0,0 +1,167 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordutil
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+const defaultSweepBatchSize = 100
+
+// ExpiredRecordSweeper deletes the expired records of the record types
+// with a record expiry.
+//
+// Expired records are deleted like records deleted by RecordDeleteHandler,
+// so delete hooks are executed and record events are emitted for them.
+type ExpiredRecordSweeper struct {
+	ConnOpener   func() (skydb.Conn, error)
+	HookRegistry *hook.Registry
+
+	// BatchSize is the maximum number of records deleted at a time.
+	// Zero means 100.
+	BatchSize uint64
+}
+
+// Sweep deletes the records expired now, a batch at a time until no
+// expired records are left. Records that fail to be deleted are skipped
+// until the next sweep. It does nothing if the database does not
+// support record expiry.
+func (s *ExpiredRecordSweeper) Sweep(ctx context.Context) error {
+	conn, err := s.ConnOpener()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	db, ok := conn.UnionDB().(skydb.RecordExpiryDatabase)
+	if !ok {
+		return nil
+	}
+
+	expiries, err := db.GetRecordExpiries()
+	if err != nil {
+		return err
+	}
+
+	recordTypes := []string{}
+	for recordType := range expiries {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+
+	batchSize := s.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultSweepBatchSize
+	}
+
+	now := time.Now().UTC()
+	for _, recordType := range recordTypes {
+		if err := s.sweepRecordType(ctx, conn, db, recordType, now, batchSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepRecordType deletes the expired records of the record type in
+// batches. The records of a batch are fetched after the last record of
+// the previous batch, so that records failed to be deleted are not
+// fetched again.
+func (s *ExpiredRecordSweeper) sweepRecordType(ctx context.Context, conn skydb.Conn, db skydb.RecordExpiryDatabase, recordType string, now time.Time, batchSize uint64) error {
+	expired := 0
+	deleted := 0
+	after := ""
+	for {
+		records, err := db.QueryExpired(recordType, now, after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		expired += len(records)
+		deleted += s.deleteRecords(ctx, conn, records, now)
+		after = records[len(records)-1].ID.Key
+
+		if uint64(len(records)) < batchSize {
+			break
+		}
+	}
+
+	if expired > 0 {
+		log.WithFields(log.Fields{
+			"type":    recordType,
+			"expired": expired,
+			"deleted": deleted,
+		}).Info("Swept expired records")
+	}
+	return nil
+}
+
+// deleteRecords deletes the records from the databases they belong to,
+// and returns the number of records deleted.
+func (s *ExpiredRecordSweeper) deleteRecords(ctx context.Context, conn skydb.Conn, records []skydb.Record, now time.Time) int {
+	recordsByDatabase := map[string][]*skydb.Record{}
+	databaseIDs := []string{}
+	for i := range records {
+		databaseID := records[i].DatabaseID
+		if _, ok := recordsByDatabase[databaseID]; !ok {
+			databaseIDs = append(databaseIDs, databaseID)
+		}
+		recordsByDatabase[databaseID] = append(recordsByDatabase[databaseID], &records[i])
+	}
+
+	deleted := 0
+	for _, databaseID := range databaseIDs {
+		db := conn.PublicDB()
+		if databaseID != "" {
+			db = conn.PrivateDB(databaseID)
+		}
+
+		req := RecordModifyRequest{
+			Db:            db,
+			Conn:          conn,
+			HookRegistry:  s.HookRegistry,
+			WithMasterKey: true,
+			Context:       ctx,
+			ModifyAt:      now,
+		}
+		resp := RecordModifyResponse{
+			ErrMap: map[skydb.RecordID]skyerr.Error{},
+		}
+
+		if err := deleteRecords(&req, &resp, recordsByDatabase[databaseID]); err != nil {
+			log.WithField("err", err).Error("Unable to delete expired records")
+			continue
+		}
+		for recordID, err := range resp.ErrMap {
+			log.WithFields(log.Fields{
+				"id":  recordID,
+				"err": err,
+			}).Error("Unable to delete expired record")
+		}
+		deleted += len(resp.DeletedRecordIDs)
+	}
+	return deleted
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,6 +14,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbcache"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -78,11 +81,32 @@ type RecordModifyRequest struct {
 	AuthInfo      *skydb.AuthInfo
 	ModifyAt      time.Time
 
+	// Cache is invalidated for the record types of the modified records,
+	// so that the modifications are reflected in the cache of the server
+	// before the record events are received.
+	Cache *skydbcache.Cache
+
 	// Save only
 	RecordsToSave []*skydb.Record
 
+	// ExpectedUpdatedAt contains the last update time of records as known
+	// by the client. A record is saved only if it is not updated since then.
+	ExpectedUpdatedAt map[skydb.RecordID]time.Time
+
+	// OwnerIDs contains the owners of records to be created. A record
+	// created without an owner specified is owned by the user saving it.
+	OwnerIDs map[skydb.RecordID]string
+
+	// UpdateOnly is true if only existing records are saved. Saving a
+	// record that does not exist fails with ResourceNotFound instead of
+	// creating the record.
+	UpdateOnly bool
+
 	// Delete Only
 	RecordIDsToDelete []skydb.RecordID
+
+	// Restore and purge only
+	TrashedRecordIDs []skydb.RecordID
 }
 
 type RecordModifyResponse struct {
@@ -94,6 +118,7 @@ type RecordModifyResponse struct {
 type RecordFetcher struct {
 	db                     skydb.Database
 	conn                   skydb.Conn
+	cache                  *skydbcache.Cache
 	withMasterKey          bool
 	creationAccessCacheMap map[string]skydb.RecordACL
 	defaultAccessCacheMap  map[string]skydb.RecordACL
@@ -110,6 +135,14 @@ func NewRecordFetcher(db skydb.Database, conn skydb.Conn, withMasterKey bool) Re
 	}
 }
 
+// NewCachedRecordFetcher is similar to NewRecordFetcher, except that
+// FetchRecord returns the records cached in the cache.
+func NewCachedRecordFetcher(db skydb.Database, conn skydb.Conn, withMasterKey bool, cache *skydbcache.Cache) RecordFetcher {
+	fetcher := NewRecordFetcher(db, conn, withMasterKey)
+	fetcher.cache = cache
+	return fetcher
+}
+
 func (f RecordFetcher) getCreationAccess(recordType string) skydb.RecordACL {
 	creationAccess, creationAccessCached := f.creationAccessCacheMap[recordType]
 	if creationAccessCached == false {
@@ -139,8 +172,29 @@ func (f RecordFetcher) getDefaultAccess(recordType string) skydb.RecordACL {
 }
 
 func (f RecordFetcher) FetchRecord(recordID skydb.RecordID, authInfo *skydb.AuthInfo, accessLevel skydb.RecordACLLevel) (record *skydb.Record, err skyerr.Error) {
+	get := f.db.Get
+	if f.cache != nil {
+		get = func(id skydb.RecordID, record *skydb.Record) error {
+			return f.cache.Get(f.db, id, record)
+		}
+	}
+	return f.fetchRecord(recordID, authInfo, accessLevel, get)
+}
+
+// FetchTrashedRecord is similar to FetchRecord, except that the record
+// in trash is fetched.
+func (f RecordFetcher) FetchTrashedRecord(recordID skydb.RecordID, authInfo *skydb.AuthInfo, accessLevel skydb.RecordACLLevel) (record *skydb.Record, err skyerr.Error) {
+	db, ok := f.db.(skydb.SoftDeleteDatabase)
+	if !ok {
+		err = skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+		return
+	}
+	return f.fetchRecord(recordID, authInfo, accessLevel, db.GetTrashed)
+}
+
+func (f RecordFetcher) fetchRecord(recordID skydb.RecordID, authInfo *skydb.AuthInfo, accessLevel skydb.RecordACLLevel, get func(skydb.RecordID, *skydb.Record) error) (record *skydb.Record, err skyerr.Error) {
 	dbRecord := skydb.Record{}
-	if dbErr := f.db.Get(recordID, &dbRecord); dbErr != nil {
+	if dbErr := get(recordID, &dbRecord); dbErr != nil {
 		if dbErr == skydb.ErrRecordNotFound {
 			err = skyerr.NewError(skyerr.ResourceNotFound, "record not found")
 		} else {
@@ -172,6 +226,25 @@ func (f RecordFetcher) FetchOrCreateRecord(recordID skydb.RecordID, authInfo *sk
 	}
 
 	if err.Code() == skyerr.ResourceNotFound {
+		if f.isTrashed(recordID) {
+			err = skyerr.NewError(
+				skyerr.Duplicated,
+				"record is in trash",
+			)
+			return
+		}
+
+		if expired, expiredErr := f.isExpired(recordID); expiredErr != nil {
+			err = expiredErr
+			return
+		} else if expired {
+			err = skyerr.NewError(
+				skyerr.Duplicated,
+				"record has expired and is pending deletion",
+			)
+			return
+		}
+
 		allowCreation := func() bool {
 			if f.withMasterKey {
 				return true
@@ -197,6 +270,31 @@ func (f RecordFetcher) FetchOrCreateRecord(recordID skydb.RecordID, authInfo *sk
 	return
 }
 
+// isExpired returns whether the record has expired but not yet been
+// deleted, in which case a record with the same ID cannot be created.
+func (f RecordFetcher) isExpired(recordID skydb.RecordID) (bool, skyerr.Error) {
+	db, ok := f.db.(skydb.RecordExpiryDatabase)
+	if !ok {
+		return false, nil
+	}
+
+	expired, err := db.IsExpired(recordID)
+	if err != nil {
+		return false, skyerr.MakeError(err)
+	}
+	return expired, nil
+}
+
+// isTrashed returns whether the record is in trash, in which case a record
+// with the same ID cannot be created.
+func (f RecordFetcher) isTrashed(recordID skydb.RecordID) bool {
+	db, ok := f.db.(skydb.SoftDeleteDatabase)
+	if !ok {
+		return false
+	}
+	return db.GetTrashed(recordID, &skydb.Record{}) == nil
+}
+
 func removeRecordFieldTypeHints(r *skydb.Record) {
 	for k, v := range r.Data {
 		switch v.(type) {
@@ -208,16 +306,109 @@ func removeRecordFieldTypeHints(r *skydb.Record) {
 	}
 }
 
+// applyFieldDefaults sets the fields absent in the newly created record to
+// their default values in the schema of the record type. Defaults copying
+// another field are applied last, so that they can refer to the value of
+// other defaults.
+func applyFieldDefaults(db skydb.Database, record *skydb.Record, ctx skydb.DefaultContext) skyerr.Error {
+	schema, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	fields := []string{}
+	for field, fieldType := range schema {
+		if fieldType.Default.IsEmpty() {
+			continue
+		}
+		if _, ok := record.Data[field]; ok {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Strings(fields)
+
+	if record.Data == nil {
+		record.Data = skydb.Data{}
+	}
+
+	expressionFields := []string{}
+	for _, field := range fields {
+		fieldType := schema[field]
+		if fieldType.Default.Type == skydb.ExpressionDefault {
+			expressionFields = append(expressionFields, field)
+			continue
+		}
+		record.Set(field, fieldType.Default.Evaluate(ctx, record, fieldType))
+	}
+	for _, field := range expressionFields {
+		fieldType := schema[field]
+		record.Set(field, fieldType.Default.Evaluate(ctx, record, fieldType))
+	}
+
+	return nil
+}
+
+// validateRecord checks the values of the fields of the record against
+// the validation rules in the schema of the record type. The returned
+// error lists the rules violated by each invalid field.
+func validateRecord(db skydb.Database, record *skydb.Record) skyerr.Error {
+	schema, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	fields := []string{}
+	violations := map[string]interface{}{}
+	for field, fieldType := range schema {
+		if fieldType.Validation == nil {
+			continue
+		}
+		if rules := fieldType.Validation.Validate(record.Get(field)); len(rules) > 0 {
+			fields = append(fields, field)
+			violations[field] = rules
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	sort.Strings(fields)
+	return skyerr.NewErrorWithInfo(skyerr.InvalidArgument, "record failed validation", map[string]interface{}{
+		"arguments": fields,
+		"fields":    violations,
+	})
+}
+
+// readFromPrimary routes the reads of the connection to the primary
+// database. Records read to be modified, such as the original records
+// and the records referencing deleted records, would otherwise be read
+// from a read replica and might not reflect the latest writes.
+func readFromPrimary(conn skydb.Conn) {
+	if replicaConn, ok := conn.(skydb.ReplicaConn); ok {
+		replicaConn.SetReadYourWrites(true)
+	}
+}
+
 // RecordSaveHandler iterate the record to perform the following:
 // 1. Query the db for original record
-// 2. Execute before save hooks with original record and new record
-// 3. Clean up some transport only data (sequence for example) away from record
-// 4. Populate meta data and save the record (like updated_at/by)
-// 5. Execute after save hooks with original record and new record
+// 2. Apply default access and default values of fields to new record
+// 3. Execute before save hooks with original record and new record
+// 4. Clean up some transport only data (sequence for example) away from record
+// 5. Validate the record against the validation rules of the schema
+// 6. Populate meta data and save the record (like updated_at/by); results of
+//    field operations are validated, and the revision is kept in history,
+//    in the transaction of the save
+// 7. Execute after save hooks with original record and new record
 func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) skyerr.Error {
 	db := req.Db
 	records := req.RecordsToSave
 
+	readFromPrimary(req.Conn)
 	fetcher := NewRecordFetcher(db, req.Conn, req.WithMasterKey)
 	fieldACL, err := req.Conn.GetRecordFieldAccess()
 	if err != nil {
@@ -231,6 +422,15 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		if err != nil {
 			return err
 		}
+		if created && req.UpdateOnly {
+			return skyerr.NewError(skyerr.ResourceNotFound, "record not found")
+		}
+
+		if expected, ok := req.ExpectedUpdatedAt[record.ID]; ok {
+			if created || !dbRecord.UpdatedAt.Equal(expected) {
+				return newRecordConflictErr(record.ID)
+			}
+		}
 
 		if !req.WithMasterKey {
 			if err = scrubRecordFieldsForWrite(
@@ -249,6 +449,9 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 			dbRecord.ID = record.ID
 			dbRecord.DatabaseID = db.ID()
 			dbRecord.OwnerID = req.AuthInfo.ID
+			if ownerID, ok := req.OwnerIDs[record.ID]; ok && ownerID != "" {
+				dbRecord.OwnerID = ownerID
+			}
 			dbRecord.CreatedAt = now
 			dbRecord.CreatorID = req.AuthInfo.ID
 			dbRecord.UpdatedAt = now
@@ -278,6 +481,18 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		return nil
 	})
 
+	// Apply default values of fields to new records
+	defaultContext := skydb.DefaultContext{
+		UserID: req.AuthInfo.ID,
+		Now:    req.ModifyAt,
+	}
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+		if _, ok := originalRecordMap[record.ID]; ok {
+			return nil
+		}
+		return applyFieldDefaults(db, record, defaultContext)
+	})
+
 	// execute before save hooks
 	if req.HookRegistry != nil {
 		records = newSaveHookTriggerer(req.Context, req.HookRegistry, originalRecordMap, resp.ErrMap, false).
@@ -289,14 +504,44 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		removeRecordFieldTypeHints(r)
 	}
 
+	// validate records against the validation rules of the schema
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+		return validateRecord(db, record)
+	})
+
 	// save records
+	recorder := newHistoryRecorder(db)
 	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
 		var deltaRecord skydb.Record
 		originalRecord, _ := originalRecordMap[record.ID]
 		DeriveDeltaRecord(&deltaRecord, originalRecord, record)
 
-		if dbErr := db.Save(&deltaRecord); dbErr != nil {
-			err = skyerr.MakeError(dbErr)
+		validated, err := hasValidatedFieldOperation(db, &deltaRecord)
+		if err != nil {
+			return err
+		}
+		recording, err := recorder.Enabled(deltaRecord.ID.Type)
+		if err != nil {
+			return err
+		}
+
+		if validated {
+			// the results of field operations are known only after the
+			// record is saved, so they are validated before the save
+			// is committed
+			err = withSaveTransaction(db, "field operations require a transactional database", func() skyerr.Error {
+				if err := saveRecord(req, db, recorder, &deltaRecord, originalRecord); err != nil {
+					return err
+				}
+				return validateRecord(db, &deltaRecord)
+			})
+		} else if recording {
+			// the save is not committed without its revision
+			err = withSaveTransaction(db, "record history requires a transactional database", func() skyerr.Error {
+				return saveRecord(req, db, recorder, &deltaRecord, originalRecord)
+			})
+		} else {
+			err = saveRecord(req, db, recorder, &deltaRecord, originalRecord)
 		}
 		*record = deltaRecord
 
@@ -307,6 +552,10 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		return skyerr.NewError(skyerr.UnexpectedError, "atomic operation failed")
 	}
 
+	for _, record := range records {
+		req.Cache.Invalidate(record.ID.Type)
+	}
+
 	makeAssetsCompleteAndInjectSigner(db, req.Conn, records, req.AssetStore)
 
 	// execute after save hooks
@@ -320,6 +569,186 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 	return nil
 }
 
+// hasValidatedFieldOperation returns whether a field of the record with
+// validation rules is a skydb.FieldOperation.
+func hasValidatedFieldOperation(db skydb.Database, record *skydb.Record) (bool, skyerr.Error) {
+	fields := []string{}
+	for field, value := range record.Data {
+		if _, ok := value.(skydb.FieldOperation); ok {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return false, nil
+	}
+
+	schema, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return false, skyerr.MakeError(err)
+	}
+	for _, field := range fields {
+		if !schema[field].Validation.IsEmpty() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withSaveTransaction calls do in a transaction of the database, which
+// is rolled back if do returns an error. If a transaction has already
+// begun, such as in an atomic save, do is called in that transaction.
+// A database not supporting transactions cannot undo a save, so do is
+// not called and NotSupported is returned with the message.
+func withSaveTransaction(db skydb.Database, message string, do func() skyerr.Error) skyerr.Error {
+	txDB, ok := db.(skydb.Transactional)
+	if !ok {
+		return skyerr.NewError(skyerr.NotSupported, message)
+	}
+
+	var doErr skyerr.Error
+	err := skydb.WithTransaction(txDB, func() error {
+		doErr = do()
+		if doErr != nil {
+			return doErr
+		}
+		return nil
+	})
+	if err == skydb.ErrDatabaseTxDidBegin {
+		return do()
+	} else if doErr != nil {
+		return doErr
+	} else if err != nil {
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+// saveRecord saves the delta record, and the revision of the saved
+// record if history is enabled for the record type. The delta record is
+// updated with the values of all fields of the saved record.
+func saveRecord(req *RecordModifyRequest, db skydb.Database, recorder historyRecorder, deltaRecord *skydb.Record, originalRecord *skydb.Record) (err skyerr.Error) {
+	_, conditional := db.(skydb.ConditionalSaveDatabase)
+	if expected, ok := req.ExpectedUpdatedAt[deltaRecord.ID]; ok {
+		err = saveIfUnchanged(db, deltaRecord, expected)
+	} else if req.UpdateOnly && conditional {
+		// the record is not re-created if it is deleted after
+		// it is fetched
+		err = saveIfUnchanged(db, deltaRecord, originalRecord.UpdatedAt)
+	} else if dbErr := db.Save(deltaRecord); dbErr != nil {
+		err = skyerr.MakeError(dbErr)
+	}
+
+	if err == nil {
+		operation := skydb.RecordUpdateOperation
+		if originalRecord == nil {
+			operation = skydb.RecordCreateOperation
+		}
+		// the saved record contains the values of all fields, including
+		// the results of field operations
+		revision := skydb.NewRecordRevision(deltaRecord, operation)
+		err = recorder.Record(originalRecord, &revision)
+	}
+
+	return
+}
+
+// historyRecorder saves revisions of records to the history of the
+// record types with history enabled. Whether history is enabled is
+// cached for each record type.
+type historyRecorder struct {
+	db      skydb.RecordHistoryDatabase
+	enabled map[string]bool
+}
+
+func newHistoryRecorder(db skydb.Database) historyRecorder {
+	historyDB, _ := db.(skydb.RecordHistoryDatabase)
+	return historyRecorder{
+		db:      historyDB,
+		enabled: map[string]bool{},
+	}
+}
+
+// Enabled returns whether history is enabled for the record type.
+func (r historyRecorder) Enabled(recordType string) (bool, skyerr.Error) {
+	if r.db == nil {
+		return false, nil
+	}
+
+	enabled, ok := r.enabled[recordType]
+	if !ok {
+		var err error
+		enabled, err = r.db.RecordHistoryEnabled(recordType)
+		if err != nil {
+			return false, skyerr.MakeError(err)
+		}
+		r.enabled[recordType] = enabled
+	}
+	return enabled, nil
+}
+
+// Record saves the revision if history is enabled for the record type of
+// the revision. It does nothing if the database does not support history.
+//
+// The original is the Record before the change, which is nil for a
+// created Record. If the Record has no revisions, such as when it is
+// created before history is enabled, the original is saved as a baseline
+// revision first, so that the state before the change is kept.
+func (r historyRecorder) Record(original *skydb.Record, revision *skydb.RecordRevision) skyerr.Error {
+	if r.db == nil {
+		return nil
+	}
+
+	enabled, err := r.Enabled(revision.RecordID.Type)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	if original != nil {
+		hasRevisions, err := r.db.HasRecordRevisions(original.ID)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		if !hasRevisions {
+			baseline := skydb.NewRecordRevision(original, skydb.RecordBaselineOperation)
+			if err := r.db.SaveRecordRevision(&baseline); err != nil {
+				return skyerr.MakeError(err)
+			}
+		}
+	}
+
+	if err := r.db.SaveRecordRevision(revision); err != nil {
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+// saveIfUnchanged saves the record only if the stored record is last
+// updated at the expected time. The check is done by the database so that
+// the record cannot be changed between the check and the save.
+func saveIfUnchanged(db skydb.Database, record *skydb.Record, expected time.Time) skyerr.Error {
+	conditionalDB, ok := db.(skydb.ConditionalSaveDatabase)
+	if !ok {
+		return skyerr.NewError(skyerr.NotSupported,
+			"database does not support saving with expected _updated_at")
+	}
+
+	if err := conditionalDB.SaveIfUnchanged(record, expected); err != nil {
+		if err == skydb.ErrRecordConflict {
+			return newRecordConflictErr(record.ID)
+		}
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+func newRecordConflictErr(recordID skydb.RecordID) skyerr.Error {
+	return skyerr.NewErrorf(skyerr.RecordConflict,
+		"record %s has been changed since it was last fetched", recordID)
+}
+
 type saveHookTriggerer struct {
 	Context           context.Context
 	HookRegistry      *hook.Registry
@@ -443,6 +872,7 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 	db := req.Db
 	recordIDs := req.RecordIDsToDelete
 
+	readFromPrimary(req.Conn)
 	fetcher := NewRecordFetcher(db, req.Conn, req.WithMasterKey)
 
 	var records []*skydb.Record
@@ -460,6 +890,12 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 		records = append(records, record)
 	}
 
+	return deleteRecords(req, resp, records)
+}
+
+// deleteRecords deletes the fetched records, executing the delete hooks
+// before and after the records are deleted.
+func deleteRecords(req *RecordModifyRequest, resp *RecordModifyResponse, records []*skydb.Record) skyerr.Error {
 	if req.HookRegistry != nil {
 		records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
 			err = req.HookRegistry.ExecuteHooks(req.Context, hook.BeforeDelete, record, nil)
@@ -467,18 +903,17 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 		})
 	}
 
-	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
-
-		if dbErr := db.Delete(record.ID); dbErr != nil {
-			return skyerr.MakeError(dbErr)
-		}
-		return nil
-	})
+	deleter := newReferentialDeleter(req)
+	records = executeRecordFunc(records, resp.ErrMap, deleter.Delete)
 
 	if req.Atomic && len(resp.ErrMap) > 0 {
 		return skyerr.NewError(skyerr.UnexpectedError, "atomic operation failed")
 	}
 
+	for recordType := range deleter.changedTypes {
+		req.Cache.Invalidate(recordType)
+	}
+
 	if req.HookRegistry != nil {
 		records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
 			err = req.HookRegistry.ExecuteHooks(req.Context, hook.AfterDelete, record, nil)
@@ -495,6 +930,431 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 	return nil
 }
 
+// referencingField is a reference field with a referential action.
+type referencingField struct {
+	RecordType string
+	Field      string
+	OnDelete   skydb.ReferentialAction
+}
+
+// referentialDeleter deletes records after performing the referential
+// actions of the reference fields referencing them.
+//
+// Referencing records are modified regardless of the access control of
+// the user, as the referential actions are declared in the record schema.
+// Delete hooks are executed for records deleted by cascade.
+type referentialDeleter struct {
+	req          *RecordModifyRequest
+	recorder     historyRecorder
+	fields       map[string][]referencingField
+	deleted      map[skydb.RecordID]bool
+	changedTypes map[string]bool
+}
+
+func newReferentialDeleter(req *RecordModifyRequest) *referentialDeleter {
+	return &referentialDeleter{
+		req:          req,
+		recorder:     newHistoryRecorder(req.Db),
+		deleted:      map[skydb.RecordID]bool{},
+		changedTypes: map[string]bool{},
+	}
+}
+
+// referencingFields returns the reference fields with referential actions
+// referencing the record type. Fields without referential action are left
+// to the database.
+func (d *referentialDeleter) referencingFields(recordType string) ([]referencingField, error) {
+	if d.fields == nil {
+		schemas, err := d.req.Db.GetRecordSchemas()
+		if err != nil {
+			return nil, err
+		}
+
+		d.fields = map[string][]referencingField{}
+		for referencingType, schema := range schemas {
+			for field, fieldType := range schema {
+				if fieldType.Type != skydb.TypeReference || fieldType.OnDelete == skydb.NoReferentialAction {
+					continue
+				}
+				d.fields[fieldType.ReferenceType] = append(d.fields[fieldType.ReferenceType], referencingField{
+					RecordType: referencingType,
+					Field:      field,
+					OnDelete:   fieldType.OnDelete,
+				})
+			}
+		}
+		for _, fields := range d.fields {
+			sort.Slice(fields, func(i, j int) bool {
+				if fields[i].RecordType != fields[j].RecordType {
+					return fields[i].RecordType < fields[j].RecordType
+				}
+				return fields[i].Field < fields[j].Field
+			})
+		}
+	}
+	return d.fields[recordType], nil
+}
+
+// referencingBatchSize is the maximum number of referencing records
+// fetched at a time.
+const referencingBatchSize = 100
+
+// referencingRecords returns at most limit records referencing the record
+// with the field, ordered by ID and starting at offset. Records already
+// deleted are skipped but counted in the returned number of records
+// fetched.
+func (d *referentialDeleter) referencingRecords(id skydb.RecordID, field referencingField, offset uint64, limit uint64) ([]skydb.Record, uint64, error) {
+	query := skydb.Query{
+		Type: field.RecordType,
+		Predicate: skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{Type: skydb.KeyPath, Value: field.Field},
+				skydb.Expression{Type: skydb.Literal, Value: skydb.NewReference(id.Type, id.Key)},
+			},
+		},
+		Sorts: []skydb.Sort{{
+			Expression: skydb.Expression{Type: skydb.KeyPath, Value: "_id"},
+			Order:      skydb.Ascending,
+		}},
+		Limit:               &limit,
+		Offset:              offset,
+		BypassAccessControl: true,
+	}
+	rows, err := d.req.Db.Query(&query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []skydb.Record{}
+	var fetched uint64
+	for rows.Scan() {
+		fetched++
+		record := rows.Record()
+		if !d.deleted[record.ID] {
+			records = append(records, record)
+		}
+	}
+	return records, fetched, rows.Err()
+}
+
+// eachReferencingRecord calls fn with the records referencing the record
+// with the field, in batches of referencingBatchSize. fn is expected to
+// make the record no longer referencing the record, so that the next batch
+// starts after the records already deleted, which are skipped.
+func (d *referentialDeleter) eachReferencingRecord(id skydb.RecordID, field referencingField, fn func(*skydb.Record) skyerr.Error) skyerr.Error {
+	var skipped uint64
+	for {
+		records, fetched, err := d.referencingRecords(id, field, skipped, referencingBatchSize)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		skipped += fetched - uint64(len(records))
+
+		for i := range records {
+			if err := fn(&records[i]); err != nil {
+				return err
+			}
+		}
+
+		if fetched < referencingBatchSize {
+			return nil
+		}
+	}
+}
+
+// maxRestrictingIDs is the maximum number of IDs of the records restricting
+// a deletion reported in the error.
+const maxRestrictingIDs = 10
+
+// Delete deletes the record in a transaction with the referential actions
+// and the revisions of the records changed, so that a failed referential
+// action does not leave the referencing records partially changed. The
+// records are also read from the primary in the transaction.
+//
+// A database not supporting transactions can only delete records without
+// referential actions or history.
+func (d *referentialDeleter) Delete(record *skydb.Record) skyerr.Error {
+	fields, err := d.referencingFields(record.ID.Type)
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	message := "referential actions require a transactional database"
+	if len(fields) == 0 {
+		enabled, err := d.recorder.Enabled(record.ID.Type)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return d.delete(record)
+		}
+		message = "record history requires a transactional database"
+	}
+
+	// records deleted in a rolled back transaction are not deleted
+	deleted := make(map[skydb.RecordID]bool, len(d.deleted))
+	for id, ok := range d.deleted {
+		deleted[id] = ok
+	}
+	if err := withSaveTransaction(d.req.Db, message, func() skyerr.Error {
+		return d.delete(record)
+	}); err != nil {
+		d.deleted = deleted
+		return err
+	}
+	return nil
+}
+
+// delete deletes the record after performing the referential actions.
+// The deletion fails with ConstraintViolated if the record is referenced
+// by a field with RestrictAction.
+func (d *referentialDeleter) delete(record *skydb.Record) skyerr.Error {
+	d.deleted[record.ID] = true
+
+	fields, err := d.referencingFields(record.ID.Type)
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	restrictingRecords := []interface{}{}
+	for _, field := range fields {
+		if field.OnDelete != skydb.RestrictAction {
+			continue
+		}
+
+		records, _, err := d.referencingRecords(record.ID, field, 0, maxRestrictingIDs)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		if len(records) > 0 {
+			ids := make([]string, len(records))
+			for j, r := range records {
+				ids[j] = r.ID.String()
+			}
+			restrictingRecords = append(restrictingRecords, map[string]interface{}{
+				"record_type": field.RecordType,
+				"field":       field.Field,
+				"ids":         ids,
+			})
+		}
+	}
+	if len(restrictingRecords) > 0 {
+		d.deleted[record.ID] = false
+		return skyerr.NewErrorWithInfo(
+			skyerr.ConstraintViolated,
+			fmt.Sprintf("cannot delete %s because other records have reference to it", record.ID),
+			map[string]interface{}{"referenced_by": restrictingRecords},
+		)
+	}
+
+	for _, field := range fields {
+		var err skyerr.Error
+		switch field.OnDelete {
+		case skydb.CascadeAction:
+			err = d.eachReferencingRecord(record.ID, field, d.cascade)
+		case skydb.SetNullAction:
+			err = d.setNull(record.ID, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if dbErr := d.req.Db.Delete(record.ID); dbErr != nil {
+		return skyerr.MakeError(dbErr)
+	}
+	d.changedTypes[record.ID.Type] = true
+
+	revision := skydb.NewRecordRevision(record, skydb.RecordDeleteOperation)
+	revision.UpdaterID = d.updaterID()
+	revision.UpdatedAt = d.req.ModifyAt
+	return d.recorder.Record(record, &revision)
+}
+
+func (d *referentialDeleter) updaterID() string {
+	if d.req.AuthInfo == nil {
+		return ""
+	}
+	return d.req.AuthInfo.ID
+}
+
+func (d *referentialDeleter) cascade(record *skydb.Record) skyerr.Error {
+	if d.deleted[record.ID] {
+		return nil
+	}
+
+	registry := d.req.HookRegistry
+	if registry != nil {
+		if err := registry.ExecuteHooks(d.req.Context, hook.BeforeDelete, record, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := d.delete(record); err != nil {
+		return err
+	}
+
+	if registry != nil {
+		if err := registry.ExecuteHooks(d.req.Context, hook.AfterDelete, record, nil); err != nil {
+			log.Errorf("Error occurred while executing hooks: %s", err)
+		}
+	}
+	return nil
+}
+
+// setNull sets the field of the records referencing the record to null.
+// Only the field is updated if the database supports it, so that
+// concurrent changes to other fields of the referencing records are kept.
+func (d *referentialDeleter) setNull(id skydb.RecordID, field referencingField) skyerr.Error {
+	d.changedTypes[field.RecordType] = true
+
+	db, ok := d.req.Db.(skydb.ReferenceNullingDatabase)
+	if !ok {
+		return d.eachReferencingRecord(id, field, func(record *skydb.Record) skyerr.Error {
+			return d.saveNull(record, field.Field)
+		})
+	}
+
+	enabled, skyErr := d.recorder.Enabled(field.RecordType)
+	if skyErr != nil {
+		return skyErr
+	}
+
+	// the records before the update are kept as baseline revisions of
+	// records without history
+	originals := map[skydb.RecordID]*skydb.Record{}
+	for offset := uint64(0); enabled; offset += referencingBatchSize {
+		records, fetched, err := d.referencingRecords(id, field, offset, referencingBatchSize)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		for i := range records {
+			originals[records[i].ID] = &records[i]
+		}
+		if fetched < referencingBatchSize {
+			break
+		}
+	}
+
+	ids, err := db.SetNullReferences(field.RecordType, field.Field, id, d.req.ModifyAt, d.updaterID())
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	if !enabled {
+		return nil
+	}
+	for _, updatedID := range ids {
+		record := skydb.Record{}
+		if err := d.req.Db.Get(updatedID, &record); err != nil {
+			return skyerr.MakeError(err)
+		}
+		revision := skydb.NewRecordRevision(&record, skydb.RecordUpdateOperation)
+		if err := d.recorder.Record(originals[updatedID], &revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *referentialDeleter) saveNull(record *skydb.Record, field string) skyerr.Error {
+	original := record.Copy()
+	record.Set(field, nil)
+	record.UpdatedAt = d.req.ModifyAt
+	record.UpdaterID = d.updaterID()
+
+	if err := d.req.Db.Save(record); err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	revision := skydb.NewRecordRevision(record, skydb.RecordUpdateOperation)
+	return d.recorder.Record(&original, &revision)
+}
+
+// RecordRestoreHandler moves the records in trash out of trash. After save
+// hooks are executed on the restored records as if they were created.
+func RecordRestoreHandler(req *RecordModifyRequest, resp *RecordModifyResponse) skyerr.Error {
+	db, ok := req.Db.(skydb.SoftDeleteDatabase)
+	if !ok {
+		return skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+	}
+
+	fetcher := NewRecordFetcher(req.Db, req.Conn, req.WithMasterKey)
+
+	var records []*skydb.Record
+	for _, recordID := range req.TrashedRecordIDs {
+		record, err := fetcher.FetchTrashedRecord(recordID, req.AuthInfo, skydb.WriteLevel)
+		if err != nil {
+			resp.ErrMap[recordID] = err
+			continue
+		}
+		records = append(records, record)
+	}
+
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
+		if dbErr := db.Restore(record.ID); dbErr != nil {
+			return skyerr.MakeError(dbErr)
+		}
+		record.DeletedAt = time.Time{}
+		return nil
+	})
+
+	if req.Atomic && len(resp.ErrMap) > 0 {
+		return skyerr.NewError(skyerr.UnexpectedError, "atomic operation failed")
+	}
+
+	makeAssetsCompleteAndInjectSigner(req.Db, req.Conn, records, req.AssetStore)
+
+	if req.HookRegistry != nil {
+		records = newSaveHookTriggerer(req.Context, req.HookRegistry, map[skydb.RecordID]*skydb.Record{}, resp.ErrMap, true).
+			trigger(records, hook.AfterSave)
+	}
+
+	resp.SavedRecords = records
+
+	return nil
+}
+
+// RecordPurgeHandler permanently removes the records in trash. No hooks
+// are executed because delete hooks are executed when the records are
+// moved to trash.
+func RecordPurgeHandler(req *RecordModifyRequest, resp *RecordModifyResponse) skyerr.Error {
+	db, ok := req.Db.(skydb.SoftDeleteDatabase)
+	if !ok {
+		return skyerr.NewError(skyerr.NotSupported, "database does not support soft delete")
+	}
+
+	fetcher := NewRecordFetcher(req.Db, req.Conn, req.WithMasterKey)
+
+	var records []*skydb.Record
+	for _, recordID := range req.TrashedRecordIDs {
+		record, err := fetcher.FetchTrashedRecord(recordID, req.AuthInfo, skydb.WriteLevel)
+		if err != nil {
+			resp.ErrMap[recordID] = err
+			continue
+		}
+		records = append(records, record)
+	}
+
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
+		if dbErr := db.Purge(record.ID); dbErr != nil {
+			return skyerr.MakeError(dbErr)
+		}
+		return nil
+	})
+
+	if req.Atomic && len(resp.ErrMap) > 0 {
+		return skyerr.NewError(skyerr.UnexpectedError, "atomic operation failed")
+	}
+
+	for _, record := range records {
+		resp.DeletedRecordIDs = append(resp.DeletedRecordIDs, record.ID)
+	}
+	return nil
+}
+
 type schemaMerger struct {
 	finalSchema skydb.RecordSchema
 	err         error
@@ -546,28 +1406,6 @@ func deriveRecordSchema(m skydb.Data) skydb.RecordSchema {
 	return schema
 }
 
-func EagerIDs(db skydb.Database, records []skydb.Record, query skydb.Query) map[string][]skydb.RecordID {
-	eagers := map[string][]skydb.RecordID{}
-	for _, transientExpression := range query.ComputedKeys {
-		if transientExpression.Type != skydb.KeyPath {
-			continue
-		}
-		keyPath := transientExpression.Value.(string)
-		eagers[keyPath] = make([]skydb.RecordID, len(records))
-	}
-
-	for i, record := range records {
-		for keyPath := range eagers {
-			ref := getReferenceWithKeyPath(db, &record, keyPath)
-			if ref.IsEmpty() {
-				continue
-			}
-			eagers[keyPath][i] = ref.ID
-		}
-	}
-	return eagers
-}
-
 // getReferenceWithKeyPath returns a reference for use in eager loading
 // It handles the case where reserved attribute is a string ID instead of
 // a referenced ID.
@@ -595,30 +1433,144 @@ func getReferenceWithKeyPath(db skydb.Database, record *skydb.Record, keyPath st
 	}
 }
 
-func DoQueryEager(db skydb.Database, eagersIDs map[string][]skydb.RecordID) map[string]map[string]*skydb.Record {
+// DoQueryEager returns the records referenced by the records with the key
+// paths in the computed keys of the query, keyed by key path and then by
+// record key.
+//
+// A key path consisting of multiple components, such as `author.company`,
+// is resolved one component at a time. The referenced records of each
+// leading part of the key path (e.g. `author`) are also returned.
+//
+// Records not readable by the user of the query are excluded unless
+// the query bypasses access control.
+func DoQueryEager(db skydb.Database, records []skydb.Record, query skydb.Query) map[string]map[string]*skydb.Record {
 	eagerRecords := map[string]map[string]*skydb.Record{}
 
-	for keyPath, ids := range eagersIDs {
-		log.Debugf("Getting value for keypath %v", keyPath)
-		eagerScanner, err := db.GetByIDs(ids)
-		if err != nil {
-			log.Debugf("No Records found in the eager load key path: %s", keyPath)
-			eagerRecords[keyPath] = map[string]*skydb.Record{}
+	for _, transientExpression := range query.ComputedKeys {
+		if transientExpression.Type != skydb.KeyPath {
 			continue
 		}
-		for eagerScanner.Scan() {
-			er := eagerScanner.Record()
-			if eagerRecords[keyPath] == nil {
-				eagerRecords[keyPath] = map[string]*skydb.Record{}
+
+		referencing := records
+		components := strings.Split(transientExpression.Value.(string), ".")
+		for i, component := range components {
+			keyPath := strings.Join(components[:i+1], ".")
+			if _, ok := eagerRecords[keyPath]; !ok {
+				eagerRecords[keyPath] = queryEagerRecords(db, referencing, component, query)
+			}
+
+			referencing = []skydb.Record{}
+			for _, record := range eagerRecords[keyPath] {
+				referencing = append(referencing, *record)
 			}
-			eagerRecords[keyPath][er.ID.Key] = &er
 		}
-		eagerScanner.Close()
 	}
 
 	return eagerRecords
 }
 
+func queryEagerRecords(db skydb.Database, records []skydb.Record, keyPath string, query skydb.Query) map[string]*skydb.Record {
+	eagerRecords := map[string]*skydb.Record{}
+
+	ids := []skydb.RecordID{}
+	for i := range records {
+		ref := getReferenceWithKeyPath(db, &records[i], keyPath)
+		if !ref.IsEmpty() {
+			ids = append(ids, ref.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return eagerRecords
+	}
+
+	log.Debugf("Getting value for keypath %v", keyPath)
+	eagerScanner, err := db.GetByIDs(ids)
+	if err != nil {
+		log.Debugf("No Records found in the eager load key path: %s", keyPath)
+		return eagerRecords
+	}
+	defer eagerScanner.Close()
+
+	for eagerScanner.Scan() {
+		er := eagerScanner.Record()
+		if !query.BypassAccessControl && !er.Accessible(query.ViewAsUser, skydb.ReadLevel) {
+			continue
+		}
+		eagerRecords[er.ID.Key] = &er
+	}
+	return eagerRecords
+}
+
+// DoQueryReverseIncludes returns the records referencing the records, for
+// each of the reverse includes of the query. The result is keyed by the
+// transient key of the include and then by the key of the referenced
+// record.
+//
+// The referencing records are queried as the user of the query, so that
+// records not readable by the user are excluded. The limit of the includes
+// is applied by the database if it supports skydb.PartitionQueryDatabase.
+func DoQueryReverseIncludes(db skydb.Database, records []skydb.Record, query skydb.Query) (map[string]map[string][]*skydb.Record, error) {
+	reverseRecords := map[string]map[string][]*skydb.Record{}
+	if len(records) == 0 {
+		return reverseRecords, nil
+	}
+
+	refs := make([]interface{}, len(records))
+	for i, record := range records {
+		refs[i] = skydb.NewReference(record.ID.Type, record.ID.Key)
+	}
+
+	for transientKey, include := range query.ReverseIncludes {
+		includeQuery := skydb.Query{
+			Type: include.RecordType,
+			Predicate: skydb.Predicate{
+				Operator: skydb.In,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: include.Field},
+					skydb.Expression{Type: skydb.Literal, Value: refs},
+				},
+			},
+			Sorts:               include.Sorts,
+			ViewAsUser:          query.ViewAsUser,
+			BypassAccessControl: query.BypassAccessControl,
+		}
+
+		var rows *skydb.Rows
+		var err error
+		if partitionDB, ok := db.(skydb.PartitionQueryDatabase); ok && include.Limit != nil {
+			rows, err = partitionDB.QueryPartitioned(&includeQuery, include.Field, *include.Limit)
+		} else {
+			rows, err = db.Query(&includeQuery)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		referencing := map[string][]*skydb.Record{}
+		for rows.Scan() {
+			record := rows.Record()
+			ref, ok := record.Get(include.Field).(skydb.Reference)
+			if !ok {
+				continue
+			}
+			key := ref.ID.Key
+			if include.Limit != nil && uint64(len(referencing[key])) >= *include.Limit {
+				continue
+			}
+			referencing[key] = append(referencing[key], &record)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		reverseRecords[transientKey] = referencing
+	}
+
+	return reverseRecords, nil
+}
+
 func getRecordCount(db skydb.Database, query *skydb.Query, results *skydb.Rows) (uint64, error) {
 	if results != nil {
 		recordCount := results.OverallRecordCount()
@@ -763,6 +1715,7 @@ type QueryResultFilter struct {
 	Database           skydb.Database
 	Query              skydb.Query
 	EagerRecords       map[string]map[string]*skydb.Record
+	ReverseRecords     map[string]map[string][]*skydb.Record
 	RecordResultFilter RecordResultFilter
 }
 
@@ -777,11 +1730,8 @@ func (f *QueryResultFilter) JSONResult(record *skydb.Record) *skyconv.JSONRecord
 			continue
 		}
 
-		keyPath := transientExpression.Value.(string)
-		ref := getReferenceWithKeyPath(f.Database, &recordCopy, keyPath)
 		var transientValue interface{}
-		eagerRecord := f.EagerRecords[keyPath][ref.ID.Key]
-		if eagerRecord != nil {
+		if eagerRecord := f.eagerRecord(&recordCopy, transientExpression.Value.(string)); eagerRecord != nil {
 			transientValue = f.RecordResultFilter.JSONResult(eagerRecord)
 		}
 
@@ -791,5 +1741,32 @@ func (f *QueryResultFilter) JSONResult(record *skydb.Record) *skyconv.JSONRecord
 		recordCopy.Transient[transientKey] = transientValue
 	}
 
+	for transientKey := range f.Query.ReverseIncludes {
+		referencing := f.ReverseRecords[transientKey][recordCopy.ID.Key]
+		transientValue := make([]interface{}, len(referencing))
+		for i, r := range referencing {
+			transientValue[i] = f.RecordResultFilter.JSONResult(r)
+		}
+
+		if recordCopy.Transient == nil {
+			recordCopy.Transient = map[string]interface{}{}
+		}
+		recordCopy.Transient[transientKey] = transientValue
+	}
+
 	return f.RecordResultFilter.JSONResult(&recordCopy)
 }
+
+// eagerRecord returns the eager loaded record referenced by the record
+// with the key path, following each component of the key path.
+func (f *QueryResultFilter) eagerRecord(record *skydb.Record, keyPath string) *skydb.Record {
+	components := strings.Split(keyPath, ".")
+	for i, component := range components {
+		ref := getReferenceWithKeyPath(f.Database, record, component)
+		record = f.EagerRecords[strings.Join(components[:i+1], ".")][ref.ID.Key]
+		if record == nil {
+			return nil
+		}
+	}
+	return record
+}
@@ -116,6 +116,9 @@ func (g *Gateway) newPayload(req *http.Request) (p *Payload, err error) {
 	} else if accessToken := query.Get("access_token"); accessToken != "" {
 		p.Data["access_token"] = accessToken
 	}
+	if req.Header.Get("X-Skygear-Read-Your-Writes") == "true" {
+		p.Data["read_your_writes"] = true
+	}
 
 	p.Meta["path"] = req.URL.Path
 	p.Meta["method"] = req.Method
@@ -119,6 +119,25 @@ func TestRouterMatchByURL(t *testing.T) {
             }`)
 		})
 
+		Convey("fill in read your writes from Header", func() {
+			g := NewGateway("endpoint", "/endpoint", nil)
+			g.POST(NewFuncHandler(func(p *Payload, resp *Response) {
+				writeEntity(resp.Writer(), struct {
+					ReadYourWrites bool `json:"read-your-writes"`
+				}{p.ReadYourWrites()})
+			}))
+
+			req, _ := http.NewRequest("POST", `http://skygear.test/endpoint`, nil)
+			req.Header.Add("X-Skygear-Read-Your-Writes", "true")
+
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, req)
+
+			So(w.Body.Bytes(), ShouldEqualJSON, `{
+                "read-your-writes": true
+            }`)
+		})
+
 		Convey("fill in api key from query string", func() {
 			g := NewGateway("endpoint", "/endpoint", nil)
 			g.POST(NewFuncHandler(func(p *Payload, resp *Response) {
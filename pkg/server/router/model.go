@@ -146,6 +146,13 @@ func (p *Payload) AccessTokenString() string {
 	}
 }
 
+// ReadYourWrites returns whether the request requires reads to reflect
+// all previous writes, i.e. reads are not served by read replicas.
+func (p *Payload) ReadYourWrites() bool {
+	readYourWrites, _ := p.Data["read_your_writes"].(bool)
+	return readYourWrites
+}
+
 // HasMasterKey returns whether the payload has master access key
 func (p *Payload) HasMasterKey() bool {
 	return p.AccessKey == MasterAccessKey
@@ -136,6 +136,9 @@ func (r *Router) newPayload(req *http.Request) (p *Payload, err error) {
 	if accessToken := req.Header.Get("X-Skygear-Access-Token"); accessToken != "" {
 		p.Data["access_token"] = accessToken
 	}
+	if req.Header.Get("X-Skygear-Read-Your-Writes") == "true" {
+		p.Data["read_your_writes"] = true
+	}
 
 	p.Meta["path"] = req.URL.Path
 	p.Meta["method"] = req.Method
@@ -120,10 +120,32 @@ type Configuration struct {
 		CORSHost        string     `json:"cors_host"`
 		Slave           bool       `json:"slave"`
 		ResponseTimeout int64      `json:"response_timeout"`
+		// RecordExpirySweepInterval is the number of seconds between
+		// sweeps deleting expired records. Zero disables the sweeps.
+		RecordExpirySweepInterval int64 `json:"record_expiry_sweep_interval"`
+		// RecordExpirySweepBatchSize is the maximum number of expired
+		// records deleted at a time in a sweep. Zero means 100.
+		RecordExpirySweepBatchSize int64 `json:"record_expiry_sweep_batch_size"`
+		// RecordChangeRetention is the number of seconds record changes
+		// are kept for record:changes. Zero keeps them forever.
+		RecordChangeRetention int64 `json:"record_change_retention"`
 	} `json:"app"`
 	DB struct {
 		ImplName string `json:"implementation"`
 		Option   string `json:"option"`
+		// Replicas are the connection strings of read replicas of the
+		// database. Only supported by the pq implementation.
+		Replicas []string `json:"replicas"`
+		// ReplicaHealthCheckInterval is the number of seconds between
+		// health checks of the read replicas.
+		ReplicaHealthCheckInterval int64 `json:"replica_health_check_interval"`
+		// QueryCacheSize is the number of query results and records
+		// cached in memory. Zero disables the cache.
+		QueryCacheSize int `json:"query_cache_size"`
+		// QueryCacheMaxAge is the number of seconds query results and
+		// records are cached at most. Zero means they are cached until
+		// they are invalidated.
+		QueryCacheMaxAge int64 `json:"query_cache_max_age"`
 	} `json:"database"`
 	TokenStore struct {
 		ImplName string `json:"implementation"`
@@ -206,8 +228,13 @@ func NewConfiguration() Configuration {
 	config.App.CORSHost = "*"
 	config.App.Slave = false
 	config.App.ResponseTimeout = 60
+	config.App.RecordExpirySweepInterval = 60
+	config.App.RecordExpirySweepBatchSize = 100
+	config.App.RecordChangeRetention = 30 * 24 * 60 * 60
+	config.DB.QueryCacheMaxAge = 5 * 60
 	config.DB.ImplName = "pq"
 	config.DB.Option = "postgres://postgres:@localhost/postgres?sslmode=disable"
+	config.DB.ReplicaHealthCheckInterval = 10
 	config.TokenStore.ImplName = "fs"
 	config.TokenStore.Path = "data/token"
 	config.TokenStore.Expiry = 0
@@ -259,6 +286,27 @@ func (config *Configuration) Validate() error {
 	if config.APNS.Enable && !regexp.MustCompile("^(cert|token)$").MatchString(config.APNS.Type) {
 		return fmt.Errorf("APNS_TYPE must be cert or token")
 	}
+	if len(config.DB.Replicas) > 0 && config.DB.ImplName != "pq" {
+		return errors.New("DATABASE_REPLICA_URLS is only supported by the pq database implementation")
+	}
+	if len(config.DB.Replicas) > 0 && config.DB.ReplicaHealthCheckInterval <= 0 {
+		return errors.New("DATABASE_REPLICA_HEALTH_CHECK_INTERVAL must be positive")
+	}
+	if config.App.RecordExpirySweepInterval < 0 {
+		return errors.New("RECORD_EXPIRY_SWEEP_INTERVAL must not be negative")
+	}
+	if config.App.RecordExpirySweepBatchSize < 0 {
+		return errors.New("RECORD_EXPIRY_SWEEP_BATCH_SIZE must not be negative")
+	}
+	if config.App.RecordChangeRetention < 0 {
+		return errors.New("RECORD_CHANGE_RETENTION must not be negative")
+	}
+	if config.DB.QueryCacheSize < 0 {
+		return errors.New("QUERY_CACHE_SIZE must not be negative")
+	}
+	if config.DB.QueryCacheMaxAge < 0 {
+		return errors.New("QUERY_CACHE_MAX_AGE must not be negative")
+	}
 	if err := config.checkAuthRecordKeysDuplication(); err != nil {
 		return err
 	}
@@ -325,10 +373,31 @@ func (config *Configuration) ReadFromEnv() {
 		config.DB.ImplName = dbImplName
 	}
 
-	if config.DB.ImplName == "pq" && os.Getenv("DATABASE_URL") != "" {
+	if (config.DB.ImplName == "pq" || config.DB.ImplName == "sqlite") && os.Getenv("DATABASE_URL") != "" {
 		config.DB.Option = os.Getenv("DATABASE_URL")
 	}
 
+	if replicas := os.Getenv("DATABASE_REPLICA_URLS"); replicas != "" {
+		config.DB.Replicas = []string{}
+		for _, replica := range strings.Split(replicas, ",") {
+			if replica = strings.TrimSpace(replica); replica != "" {
+				config.DB.Replicas = append(config.DB.Replicas, replica)
+			}
+		}
+	}
+
+	if interval, err := strconv.ParseInt(os.Getenv("DATABASE_REPLICA_HEALTH_CHECK_INTERVAL"), 10, 64); err == nil {
+		config.DB.ReplicaHealthCheckInterval = interval
+	}
+
+	if size, err := strconv.ParseInt(os.Getenv("QUERY_CACHE_SIZE"), 10, 0); err == nil {
+		config.DB.QueryCacheSize = int(size)
+	}
+
+	if maxAge, err := strconv.ParseInt(os.Getenv("QUERY_CACHE_MAX_AGE"), 10, 64); err == nil {
+		config.DB.QueryCacheMaxAge = maxAge
+	}
+
 	if slave, err := parseBool(os.Getenv("SLAVE")); err == nil {
 		config.App.Slave = slave
 	}
@@ -337,6 +406,18 @@ func (config *Configuration) ReadFromEnv() {
 		config.App.ResponseTimeout = timeout
 	}
 
+	if interval, err := strconv.ParseInt(os.Getenv("RECORD_EXPIRY_SWEEP_INTERVAL"), 10, 64); err == nil {
+		config.App.RecordExpirySweepInterval = interval
+	}
+
+	if batchSize, err := strconv.ParseInt(os.Getenv("RECORD_EXPIRY_SWEEP_BATCH_SIZE"), 10, 64); err == nil {
+		config.App.RecordExpirySweepBatchSize = batchSize
+	}
+
+	if retention, err := strconv.ParseInt(os.Getenv("RECORD_CHANGE_RETENTION"), 10, 64); err == nil {
+		config.App.RecordChangeRetention = retention
+	}
+
 	if bounceCount, err := strconv.ParseInt(os.Getenv("ZMQ_MAX_BOUNCE"), 10, 0); err == nil {
 		config.Zmq.MaxBounce = int(bounceCount)
 	}
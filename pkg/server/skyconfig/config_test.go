@@ -109,6 +109,117 @@ func TestRequiredConfig(t *testing.T) {
 			os.Setenv("APNS_ENABLE", "")
 		})
 
+		Convey("Read database replicas correctly", func() {
+			config := NewConfigurationWithKeys()
+			os.Setenv("DATABASE_REPLICA_URLS", "postgres://replica1/postgres,postgres://replica2/postgres")
+			os.Setenv("DATABASE_REPLICA_HEALTH_CHECK_INTERVAL", "5")
+
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.DB.Replicas, ShouldResemble, []string{
+				"postgres://replica1/postgres",
+				"postgres://replica2/postgres",
+			})
+			So(config.DB.ReplicaHealthCheckInterval, ShouldEqual, 5)
+
+			config.DB.ImplName = "mysql"
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("DATABASE_REPLICA_URLS", " postgres://replica1/postgres, ,postgres://replica2/postgres ,")
+			config.ReadFromEnv()
+			So(config.DB.Replicas, ShouldResemble, []string{
+				"postgres://replica1/postgres",
+				"postgres://replica2/postgres",
+			})
+
+			os.Setenv("DATABASE_REPLICA_URLS", "")
+			os.Setenv("DATABASE_REPLICA_HEALTH_CHECK_INTERVAL", "")
+		})
+
+		Convey("Read record expiry sweep interval correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.App.RecordExpirySweepInterval, ShouldEqual, 60)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_INTERVAL", "300")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.App.RecordExpirySweepInterval, ShouldEqual, 300)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_INTERVAL", "0")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_INTERVAL", "-1")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_INTERVAL", "")
+		})
+
+		Convey("Read record expiry sweep batch size correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.App.RecordExpirySweepBatchSize, ShouldEqual, 100)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_BATCH_SIZE", "500")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.App.RecordExpirySweepBatchSize, ShouldEqual, 500)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_BATCH_SIZE", "-1")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("RECORD_EXPIRY_SWEEP_BATCH_SIZE", "")
+		})
+
+		Convey("Read record change retention correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.App.RecordChangeRetention, ShouldEqual, 30*24*60*60)
+
+			os.Setenv("RECORD_CHANGE_RETENTION", "3600")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.App.RecordChangeRetention, ShouldEqual, 3600)
+
+			os.Setenv("RECORD_CHANGE_RETENTION", "-1")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("RECORD_CHANGE_RETENTION", "")
+		})
+
+		Convey("Read query cache size correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.DB.QueryCacheSize, ShouldEqual, 0)
+
+			os.Setenv("QUERY_CACHE_SIZE", "1000")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.DB.QueryCacheSize, ShouldEqual, 1000)
+
+			os.Setenv("QUERY_CACHE_SIZE", "-1")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("QUERY_CACHE_SIZE", "")
+		})
+
+		Convey("Read query cache max age correctly", func() {
+			config := NewConfigurationWithKeys()
+			So(config.DB.QueryCacheMaxAge, ShouldEqual, 300)
+
+			os.Setenv("QUERY_CACHE_MAX_AGE", "60")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldBeNil)
+			So(config.DB.QueryCacheMaxAge, ShouldEqual, 60)
+
+			os.Setenv("QUERY_CACHE_MAX_AGE", "-1")
+			config.ReadFromEnv()
+			So(config.Validate(), ShouldNotBeNil)
+
+			os.Setenv("QUERY_CACHE_MAX_AGE", "")
+		})
+
 		Convey("Read token store config correctly", func() {
 			config := NewConfigurationWithKeys()
 			os.Setenv("TOKEN_STORE", "redis")
@@ -0,0 +1,56 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRecordChangesPruned is returned from GetRecordChanges when changes
+// after the cursor have been pruned from the change log.
+var ErrRecordChangesPruned = errors.New("skydb: Record changes after the cursor have been pruned")
+
+// RecordChangeCursor is the position of a change in the change log.
+// Changes are ordered by the ID of the transaction making the change,
+// then by the ID of the change. The zero RecordChangeCursor is the
+// position before all changes.
+type RecordChangeCursor struct {
+	TxID int64
+	ID   int64
+}
+
+// RecordChange is the latest change to a Record kept in the change log
+// of a record type.
+//
+// Record is the state of the Record after the change. For
+// RecordDeleteOperation, Record is nil and RecordID is the only
+// information left of the deleted Record.
+type RecordChange struct {
+	ID        int64
+	TxID      int64
+	RecordID  RecordID
+	Operation RecordOperation
+	Record    *Record
+	ChangedAt time.Time
+}
+
+// Cursor returns the position of the change in the change log.
+func (change *RecordChange) Cursor() RecordChangeCursor {
+	return RecordChangeCursor{
+		TxID: change.TxID,
+		ID:   change.ID,
+	}
+}
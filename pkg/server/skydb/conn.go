@@ -196,6 +196,56 @@ type Conn interface {
 	Close() error
 }
 
+// ReplicaConn is a Conn which routes reads outside transactions to read
+// replicas of the database. Reads from a replica might not reflect the
+// latest writes because of replication lag.
+type ReplicaConn interface {
+	// SetReadYourWrites sets whether reads are served by the primary
+	// database, so that they reflect all writes.
+	SetReadYourWrites(enabled bool)
+
+	// ReadYourWrites returns whether reads are served by the primary
+	// database.
+	ReadYourWrites() bool
+}
+
+// SnapshotConn is a Conn which can read in a transaction seeing a single
+// snapshot of the database, so that several reads are consistent with
+// each other.
+type SnapshotConn interface {
+	Transactional
+
+	// BeginSnapshot begins a read-only transaction in which all reads see
+	// the database as of the first read. The transaction is ended by
+	// Rollback.
+	//
+	// Calling BeginSnapshot when a transaction has begun returns
+	// ErrDatabaseTxDidBegin.
+	BeginSnapshot() error
+
+	// WithSavepoint calls do in the transaction. If do returns an error,
+	// the statements of do are undone, so that the transaction can
+	// continue after a failed statement.
+	WithSavepoint(do func() error) error
+}
+
+// RecordChangeLogConn is a Conn which keeps a log of changes to records
+// for ChangeFeedDatabase.
+type RecordChangeLogConn interface {
+	// PruneRecordChanges deletes the changes made before the time from
+	// the change log, except the latest change to each existing Record.
+	PruneRecordChanges(before time.Time) error
+}
+
+// RecordEventLossConn is a Conn which reports when the record events
+// sent to the channels registered by Subscribe might have been lost,
+// such as when the connection receiving them is lost.
+type RecordEventLossConn interface {
+	// SubscribeEventLoss registers the channel to receive a value
+	// whenever record events might have been lost.
+	SubscribeEventLoss(ch chan struct{}) error
+}
+
 // AccessModel indicates the type of access control model while db query.
 //go:generate stringer -type=AccessModel
 type AccessModel int
@@ -19,12 +19,17 @@ package skydb
 import (
 	"errors"
 	"io"
+	"time"
 )
 
 // ErrRecordNotFound is returned from Get and Delete when Database
 // cannot find the Record by the specified key
 var ErrRecordNotFound = errors.New("skydb: Record not found for the specified key")
 
+// ErrRecordConflict is returned from SaveIfUnchanged when the Record
+// stored in Database has been changed
+var ErrRecordConflict = errors.New("skydb: Record has been changed")
+
 // EmptyRows is a convenient variable that acts as an empty Rows.
 // Useful for skydb implementators and testing.
 var EmptyRows = NewRows(emptyRowsIter(0))
@@ -206,6 +211,205 @@ type TxDatabase interface {
 	Database
 }
 
+// AggregateDatabase defines the methods for a Database that supports
+// computing aggregations of a query.
+type AggregateDatabase interface {
+	// QueryAggregation computes Query.Aggregations over records matching
+	// the query predicate.
+	//
+	// One map is returned for each group of records, containing the
+	// aggregated values keyed by the aggregation name, and the values of
+	// the Query.GroupBy key paths of the group.
+	QueryAggregation(query *Query) ([]map[string]interface{}, error)
+}
+
+// PartitionQueryDatabase defines the methods for a Database that supports
+// limiting the number of records queried for each value of a field.
+type PartitionQueryDatabase interface {
+	// QueryPartitioned is similar to Query, but at most limit Records
+	// are returned for each value of the field. The Records returned for
+	// each value are the first ones in the sorting order of the query.
+	QueryPartitioned(query *Query, field string, limit uint64) (*Rows, error)
+}
+
+// SearchIndexDatabase defines the methods for a Database that supports
+// creating index for full-text search.
+type SearchIndexDatabase interface {
+	// SaveSearchIndex creates an index for full-text search on the field
+	// with the specified language, and returns the name of the index.
+	//
+	// Saving an index that already exists has no effect.
+	SaveSearchIndex(recordType, field, language string) (string, error)
+}
+
+// ConditionalSaveDatabase defines the methods for a Database that supports
+// saving a record only if the stored record has not been changed.
+type ConditionalSaveDatabase interface {
+	// SaveIfUnchanged updates the supplied Record in the Database like Save,
+	// but only if the Record stored in the Database was last updated at
+	// updatedAt. Unlike Save, the Record is never created.
+	//
+	// SaveIfUnchanged returns ErrRecordConflict if the Record does not
+	// exist or it was last updated at a different time.
+	SaveIfUnchanged(record *Record, updatedAt time.Time) error
+}
+
+// ReferenceNullingDatabase defines the methods for a Database that supports
+// setting the references to a record to null without saving the
+// referencing records.
+type ReferenceNullingDatabase interface {
+	// SetNullReferences sets the field of the Records of the record type
+	// referencing the Record identified by the id to null, and updates
+	// their _updated_at and _updated_by. Other fields of the referencing
+	// Records are not changed.
+	//
+	// SetNullReferences returns the IDs of the Records updated.
+	SetNullReferences(recordType, field string, id RecordID, updatedAt time.Time, updaterID string) ([]RecordID, error)
+}
+
+// SoftDeleteDatabase defines the methods for a Database that supports
+// soft delete.
+//
+// When soft delete is enabled for a record type, Delete moves a Record to
+// trash instead of removing it permanently. Records in trash are
+// hidden from Get, GetByIDs, Query and QueryCount.
+type SoftDeleteDatabase interface {
+	// SoftDeleteEnabled returns whether soft delete is enabled for the
+	// record type.
+	SoftDeleteEnabled(recordType string) (bool, error)
+
+	// SetSoftDeleteEnabled enables or disables soft delete for the
+	// record type. Records in trash are purged when soft delete is
+	// disabled.
+	SetSoftDeleteEnabled(recordType string, enabled bool) error
+
+	// GetTrashed fetches the Record in trash identified by the id.
+	//
+	// GetTrashed returns an ErrRecordNotFound if no such Record is
+	// in trash.
+	GetTrashed(id RecordID, record *Record) error
+
+	// QueryTrash is similar to Query, but only Records in trash
+	// are returned.
+	QueryTrash(query *Query) (*Rows, error)
+
+	// Restore moves the Record identified by the id out of trash.
+	//
+	// Restore returns an ErrRecordNotFound if no such Record is in trash.
+	Restore(id RecordID) error
+
+	// Purge permanently removes the Record in trash identified by the id.
+	//
+	// Purge returns an ErrRecordNotFound if no such Record is in trash.
+	Purge(id RecordID) error
+
+	// PurgeTrash permanently removes Records of the record type that
+	// were moved to trash before the specified time. It returns the number
+	// of Records removed.
+	PurgeTrash(recordType string, before time.Time) (int64, error)
+}
+
+// RecordHistoryDatabase defines the methods for a Database that supports
+// keeping the revisions of records in history.
+type RecordHistoryDatabase interface {
+	// RecordHistoryEnabled returns whether revisions of records of the
+	// record type are kept in history.
+	RecordHistoryEnabled(recordType string) (bool, error)
+
+	// SetRecordHistoryEnabled enables or disables keeping revisions
+	// of records of the record type. The history of the record type is
+	// removed when it is disabled.
+	SetRecordHistoryEnabled(recordType string, enabled bool) error
+
+	// SaveRecordRevision appends the revision to the history of the
+	// Record. The ID of the revision is assigned by the Database.
+	SaveRecordRevision(revision *RecordRevision) error
+
+	// HasRecordRevisions returns whether the Record identified by the id
+	// has any revision in history.
+	HasRecordRevisions(id RecordID) (bool, error)
+
+	// GetRecordRevisions returns the revisions of the Record identified
+	// by the id, with the latest revision first.
+	GetRecordRevisions(id RecordID) ([]RecordRevision, error)
+
+	// GetRecordRevision fetches the revision of the Record identified
+	// by the id.
+	//
+	// GetRecordRevision returns an ErrRevisionNotFound if no such
+	// revision exists.
+	GetRecordRevision(id RecordID, revisionID int64, revision *RecordRevision) error
+}
+
+// ChangeFeedDatabase defines the methods for a Database that keeps a log
+// of changes to records, such that clients can fetch the records changed
+// since their last synchronization.
+type ChangeFeedDatabase interface {
+	// GetRecordChanges returns the latest change to each Record of the
+	// query type made after the cursor, ordered by the cursor of the
+	// change. A Record moved to trash is reported as deleted, and so is
+	// a Record the user could read before the latest change but not
+	// after it.
+	//
+	// Only changes that no running transaction can precede are
+	// returned, so that a change is never committed before the cursor
+	// of a change already returned.
+	//
+	// Only the Type, Limit, ViewAsUser and BypassAccessControl of the
+	// query are respected.
+	//
+	// GetRecordChanges returns ErrRecordChangesPruned if deletions or
+	// changes of access after the cursor have been pruned.
+	GetRecordChanges(query *Query, after RecordChangeCursor) ([]RecordChange, error)
+}
+
+// RecordExpiryDatabase defines the methods for a Database that supports
+// expiry of records.
+//
+// Expired Records are hidden from Get, GetByIDs, Query and QueryCount
+// immediately, and are deleted later by a sweeper.
+type RecordExpiryDatabase interface {
+	// GetRecordExpiry returns the expiry of the record type. An empty
+	// RecordExpiry is returned if Records of the type never expire.
+	GetRecordExpiry(recordType string) (RecordExpiry, error)
+
+	// SetRecordExpiry sets the expiry of the record type. Records of
+	// the type no longer expire if the expiry is empty.
+	SetRecordExpiry(recordType string, expiry RecordExpiry) error
+
+	// GetRecordExpiries returns the expiry of the record types whose
+	// Records expire, keyed by record type.
+	GetRecordExpiries() (map[string]RecordExpiry, error)
+
+	// QueryExpired returns at most limit Records of the record type
+	// which have expired at the specified time, ordered by key. Only
+	// Records with a key greater than after are returned, so that
+	// Records failed to be deleted can be skipped.
+	QueryExpired(recordType string, at time.Time, after string, limit uint64) ([]Record, error)
+
+	// IsExpired returns whether the Record has expired but not yet been
+	// deleted by the sweeper. Such a Record is hidden from Get, but a
+	// Record with the same ID cannot be saved.
+	IsExpired(id RecordID) (bool, error)
+}
+
+// SchemaAlterDatabase defines the methods for a Database that supports
+// changing the type of a field, converting the existing values of the
+// field to the new type.
+//
+// The type of a field can only be changed to a FieldType the current
+// FieldType is CastableTo.
+type SchemaAlterDatabase interface {
+	// CountAlterFailures returns the number of Records of the record type
+	// whose value of the field cannot be converted to the FieldType.
+	// Records without a value of the field are not counted.
+	CountAlterFailures(recordType, field string, fieldType FieldType) (uint64, error)
+
+	// AlterSchema changes the type of the field to the FieldType. Nothing
+	// is changed if the value of any Record cannot be converted.
+	AlterSchema(recordType, field string, fieldType FieldType) error
+}
+
 // Rows implements a scanner-like interface for easy iteration on a
 // result set returned from a query
 type Rows struct {
@@ -0,0 +1,96 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+// DefaultType is the kind of value a FieldDefault generates.
+type DefaultType string
+
+// List of DefaultType
+const (
+	// LiteralDefault sets the field to a fixed value.
+	LiteralDefault DefaultType = "literal"
+	// NowDefault sets the field to the time the record is created.
+	NowDefault DefaultType = "now"
+	// CurrentUserDefault sets the field to the ID of the user creating
+	// the record, or a reference to the user for a reference field.
+	CurrentUserDefault DefaultType = "current_user"
+	// UUIDDefault sets the field to a newly generated UUID.
+	UUIDDefault DefaultType = "uuid"
+	// ExpressionDefault sets the field to the value of the key path in
+	// Expression, evaluated against the record being created.
+	ExpressionDefault DefaultType = "expression"
+)
+
+// IsValid returns true if the DefaultType is one of the known types.
+func (t DefaultType) IsValid() bool {
+	switch t {
+	case LiteralDefault, NowDefault, CurrentUserDefault, UUIDDefault, ExpressionDefault:
+		return true
+	}
+	return false
+}
+
+// FieldDefault is the value generated for a field when a Record is
+// created without the field.
+type FieldDefault struct {
+	Type       DefaultType `json:"type"`
+	Value      interface{} `json:"value"`                // used only by LiteralDefault
+	Expression string      `json:"expression,omitempty"` // used only by ExpressionDefault
+}
+
+// IsEmpty returns true if the FieldDefault generates no value.
+func (d *FieldDefault) IsEmpty() bool {
+	return d == nil || d.Type == ""
+}
+
+// DefaultContext is the information about the creation of a Record
+// needed to generate default values.
+type DefaultContext struct {
+	UserID string
+	Now    time.Time
+}
+
+// Evaluate returns the default value for a field of the specified
+// FieldType in the record.
+func (d *FieldDefault) Evaluate(ctx DefaultContext, record *Record, fieldType FieldType) interface{} {
+	switch d.Type {
+	case LiteralDefault:
+		return d.Value
+	case NowDefault:
+		return ctx.Now
+	case CurrentUserDefault:
+		if ctx.UserID == "" {
+			return nil
+		}
+		if fieldType.Type == TypeReference {
+			return NewReference(fieldType.ReferenceType, ctx.UserID)
+		}
+		return ctx.UserID
+	case UUIDDefault:
+		return uuid.New()
+	case ExpressionDefault:
+		if d.Expression == "" {
+			return nil
+		}
+		return record.Get(d.Expression)
+	}
+	return nil
+}
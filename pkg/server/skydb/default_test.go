@@ -0,0 +1,76 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldDefault(t *testing.T) {
+	Convey("FieldDefault", t, func() {
+		now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		ctx := DefaultContext{UserID: "user0", Now: now}
+		record := &Record{
+			ID:   NewRecordID("note", "1"),
+			Data: Data{"title": "Hello"},
+		}
+		stringField := FieldType{Type: TypeString}
+
+		Convey("is empty without type", func() {
+			var d *FieldDefault
+			So(d.IsEmpty(), ShouldBeTrue)
+			So((&FieldDefault{}).IsEmpty(), ShouldBeTrue)
+			So((&FieldDefault{Type: NowDefault}).IsEmpty(), ShouldBeFalse)
+		})
+
+		Convey("evaluates literal", func() {
+			d := &FieldDefault{Type: LiteralDefault, Value: false}
+			So(d.Evaluate(ctx, record, FieldType{Type: TypeBoolean}), ShouldEqual, false)
+		})
+
+		Convey("evaluates now", func() {
+			d := &FieldDefault{Type: NowDefault}
+			So(d.Evaluate(ctx, record, FieldType{Type: TypeDateTime}), ShouldResemble, now)
+		})
+
+		Convey("evaluates current user", func() {
+			d := &FieldDefault{Type: CurrentUserDefault}
+			So(d.Evaluate(ctx, record, stringField), ShouldEqual, "user0")
+			So(d.Evaluate(ctx, record, FieldType{
+				Type:          TypeReference,
+				ReferenceType: "user",
+			}), ShouldResemble, NewReference("user", "user0"))
+			So(d.Evaluate(DefaultContext{}, record, stringField), ShouldBeNil)
+		})
+
+		Convey("evaluates uuid", func() {
+			d := &FieldDefault{Type: UUIDDefault}
+			first := d.Evaluate(ctx, record, stringField)
+			So(first, ShouldHaveLength, 36)
+			So(d.Evaluate(ctx, record, stringField), ShouldNotEqual, first)
+		})
+
+		Convey("evaluates expression", func() {
+			d := &FieldDefault{Type: ExpressionDefault, Expression: "title"}
+			So(d.Evaluate(ctx, record, stringField), ShouldEqual, "Hello")
+
+			d = &FieldDefault{Type: ExpressionDefault, Expression: "_id"}
+			So(d.Evaluate(ctx, record, stringField), ShouldEqual, "1")
+		})
+	})
+}
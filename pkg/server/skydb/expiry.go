@@ -0,0 +1,53 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"time"
+)
+
+// RecordExpiry specifies when Records of a record type expire.
+//
+// A Record expires at the time in Field if Field is not empty, and the
+// Record never expires if the Field is null. Otherwise, a Record expires
+// when TTL has elapsed since it was created.
+type RecordExpiry struct {
+	Field string
+	TTL   time.Duration
+}
+
+// IsEmpty returns true if Records with the RecordExpiry never expire.
+func (expiry RecordExpiry) IsEmpty() bool {
+	return expiry.Field == "" && expiry.TTL == 0
+}
+
+// ExpiresAt returns the time at which the Record expires. It returns
+// false if the Record never expires.
+func (expiry RecordExpiry) ExpiresAt(record *Record) (time.Time, bool) {
+	if expiry.Field != "" {
+		expiresAt, ok := record.Get(expiry.Field).(time.Time)
+		return expiresAt, ok
+	}
+	if expiry.TTL != 0 {
+		return record.CreatedAt.Add(expiry.TTL), true
+	}
+	return time.Time{}, false
+}
+
+// Expired returns true if the Record has expired at the specified time.
+func (expiry RecordExpiry) Expired(record *Record, at time.Time) bool {
+	expiresAt, ok := expiry.ExpiresAt(record)
+	return ok && !expiresAt.After(at)
+}
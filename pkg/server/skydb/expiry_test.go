@@ -0,0 +1,61 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordExpiry(t *testing.T) {
+	Convey("RecordExpiry", t, func() {
+		createdAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		expireAt := createdAt.Add(time.Minute)
+		record := &Record{
+			ID:        NewRecordID("session", "1"),
+			CreatedAt: createdAt,
+			Data:      Data{"expire_at": expireAt},
+		}
+
+		Convey("is empty without field and ttl", func() {
+			So(RecordExpiry{}.IsEmpty(), ShouldBeTrue)
+			So(RecordExpiry{Field: "expire_at"}.IsEmpty(), ShouldBeFalse)
+			So(RecordExpiry{TTL: time.Hour}.IsEmpty(), ShouldBeFalse)
+
+			_, ok := RecordExpiry{}.ExpiresAt(record)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("expires at time of field", func() {
+			expiry := RecordExpiry{Field: "expire_at"}
+			expiresAt, ok := expiry.ExpiresAt(record)
+			So(ok, ShouldBeTrue)
+			So(expiresAt, ShouldResemble, expireAt)
+			So(expiry.Expired(record, expireAt.Add(-time.Second)), ShouldBeFalse)
+			So(expiry.Expired(record, expireAt), ShouldBeTrue)
+
+			delete(record.Data, "expire_at")
+			So(expiry.Expired(record, expireAt), ShouldBeFalse)
+		})
+
+		Convey("expires after ttl since creation", func() {
+			expiry := RecordExpiry{TTL: time.Hour}
+			So(expiry.Expired(record, createdAt.Add(59*time.Minute)), ShouldBeFalse)
+			So(expiry.Expired(record, createdAt.Add(time.Hour)), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRevisionNotFound is returned from GetRecordRevision when the
+// specified revision cannot be found.
+var ErrRevisionNotFound = errors.New("skydb: Record revision not found")
+
+// RecordOperation is the kind of change to a Record that results in
+// a RecordRevision.
+type RecordOperation string
+
+// List of RecordOperation
+const (
+	RecordCreateOperation RecordOperation = "create"
+	RecordUpdateOperation RecordOperation = "update"
+	RecordDeleteOperation RecordOperation = "delete"
+
+	// RecordBaselineOperation is the operation of the revision keeping
+	// the state of a Record before its first change with history
+	// enabled, such as a Record created before history is enabled.
+	RecordBaselineOperation RecordOperation = "baseline"
+)
+
+// RecordRevision is the state of a Record after a change, kept in the
+// history of the Record. Reverting to a revision restores the Record as
+// it was right after the change. The state before the first change kept
+// in history is the revision of RecordBaselineOperation.
+//
+// For RecordDeleteOperation, the revision contains the state of the Record
+// before it is deleted, and UpdaterID and UpdatedAt are the user deleting
+// the Record and the time of the deletion.
+type RecordRevision struct {
+	ID        int64
+	RecordID  RecordID
+	Operation RecordOperation
+	OwnerID   string
+	ACL       RecordACL
+	Data      Data
+	UpdaterID string
+	UpdatedAt time.Time
+}
+
+// NewRecordRevision returns a RecordRevision with the current state of
+// the Record.
+func NewRecordRevision(record *Record, operation RecordOperation) RecordRevision {
+	return RecordRevision{
+		RecordID:  record.ID,
+		Operation: operation,
+		OwnerID:   record.OwnerID,
+		ACL:       record.ACL,
+		Data:      record.Data.Copy(),
+		UpdaterID: record.UpdaterID,
+		UpdatedAt: record.UpdatedAt,
+	}
+}
+
+// Accessible returns true when the Record at this revision is accessible
+// by the user with the specified access level.
+func (revision *RecordRevision) Accessible(authinfo *AuthInfo, level RecordACLLevel) bool {
+	record := Record{
+		ID:      revision.RecordID,
+		OwnerID: revision.OwnerID,
+		ACL:     revision.ACL,
+	}
+	return record.Accessible(authinfo, level)
+}
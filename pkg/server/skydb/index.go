@@ -0,0 +1,78 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"errors"
+)
+
+// ErrIndexNotFound is returned from DeleteIndex when the specified index
+// cannot be found.
+var ErrIndexNotFound = errors.New("skydb: Index not found")
+
+// IndexFunction is a function applied on a field in an IndexExpression.
+type IndexFunction string
+
+// List of IndexFunction
+const (
+	IndexLowerFunction IndexFunction = "lower"
+	IndexUpperFunction IndexFunction = "upper"
+)
+
+// IsValid returns whether the IndexFunction is supported.
+func (f IndexFunction) IsValid() bool {
+	return f == IndexLowerFunction || f == IndexUpperFunction
+}
+
+// IndexExpression is the result of a function applied on a field, which
+// is indexed in an expression index.
+type IndexExpression struct {
+	Function IndexFunction
+	Field    string
+}
+
+// IndexStatus is the status of an Index reported by the Database.
+type IndexStatus string
+
+// List of IndexStatus
+const (
+	// IndexReady means the index is built and used by queries.
+	IndexReady IndexStatus = "ready"
+	// IndexBuilding means the index is being built and is not yet used
+	// by queries.
+	IndexBuilding IndexStatus = "building"
+	// IndexInvalid means the index failed to build. It is not used by
+	// queries and should be deleted.
+	IndexInvalid IndexStatus = "invalid"
+)
+
+// Index is an index on fields of a record type.
+//
+// The index keys are Fields followed by Expressions. If Unique is true,
+// the index keys of records cannot be duplicated. If Predicate is not
+// empty, only records satisfying the Predicate are indexed.
+type Index struct {
+	Fields      []string
+	Expressions []IndexExpression
+	Unique      bool
+	Predicate   Predicate
+
+	// Status and Definition are reported by the Database when the Index
+	// is fetched, and are ignored when the Index is saved. Definition is
+	// the definition of the index specific to the Database, which
+	// includes the Predicate.
+	Status     IndexStatus
+	Definition string
+}
@@ -0,0 +1,137 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SetRecordAccess(recordType string, acl skydb.RecordACL) error {
+	creationRoles := []string{}
+	for _, ace := range acl {
+		if ace.Role != "" {
+			creationRoles = append(creationRoles, ace.Role)
+		}
+	}
+	creationRoles = sortedRoles(creationRoles)
+
+	return c.update(func(s *state) error {
+		s.ensureRoles(creationRoles)
+		if len(creationRoles) == 0 {
+			delete(s.recordCreationRoles, recordType)
+			return nil
+		}
+		s.recordCreationRoles[recordType] = creationRoles
+		return nil
+	})
+}
+
+func (c *conn) GetRecordAccess(recordType string) (skydb.RecordACL, error) {
+	currentCreationRoles := []skydb.RecordACLEntry{}
+	err := c.view(func(s *state) error {
+		for _, role := range s.recordCreationRoles[recordType] {
+			currentCreationRoles = append(currentCreationRoles,
+				skydb.NewRecordACLEntryRole(role, skydb.CreateLevel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return skydb.NewRecordACL(currentCreationRoles), nil
+}
+
+func (c *conn) SetRecordDefaultAccess(recordType string, acl skydb.RecordACL) error {
+	stored := copyACL(acl)
+	return c.update(func(s *state) error {
+		s.recordDefaultAccess[recordType] = stored
+		return nil
+	})
+}
+
+func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error) {
+	var acl skydb.RecordACL
+	err := c.view(func(s *state) error {
+		acl = copyACL(s.recordDefaultAccess[recordType])
+		return nil
+	})
+	return acl, err
+}
+
+func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) error {
+	entries := append(skydb.FieldACLEntryList{}, acl.AllEntries()...)
+	return c.update(func(s *state) error {
+		s.fieldACL = entries
+		return nil
+	})
+}
+
+func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
+	var entries skydb.FieldACLEntryList
+	err := c.view(func(s *state) error {
+		entries = append(skydb.FieldACLEntryList{}, s.fieldACL...)
+		return nil
+	})
+	if err != nil {
+		return skydb.FieldACL{}, err
+	}
+	return skydb.NewFieldACL(entries), nil
+}
+
+func (c *conn) GetAsset(name string, asset *skydb.Asset) error {
+	assets, err := c.GetAssets([]string{name})
+	if err != nil {
+		return err
+	}
+
+	if len(assets) == 0 {
+		return errors.New("asset not found")
+	}
+
+	*asset = assets[0]
+	return nil
+}
+
+func (c *conn) GetAssets(names []string) ([]skydb.Asset, error) {
+	results := []skydb.Asset{}
+	err := c.view(func(s *state) error {
+		for _, name := range names {
+			if asset, ok := s.assets[name]; ok {
+				results = append(results, asset)
+			}
+		}
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results, err
+}
+
+func (c *conn) SaveAsset(asset *skydb.Asset) error {
+	stored := skydb.Asset{
+		Name:        asset.Name,
+		ContentType: asset.ContentType,
+		Size:        asset.Size,
+	}
+	return c.update(func(s *state) error {
+		s.assets[stored.Name] = stored
+		return nil
+	})
+}
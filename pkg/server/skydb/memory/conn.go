@@ -0,0 +1,256 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+type conn struct {
+	store       *store
+	tx          *transaction // nil when no transaction
+	appName     string
+	option      string
+	accessModel skydb.AccessModel
+	canMigrate  bool
+	context     context.Context
+
+	// pendingEvents are the record events of the current transaction,
+	// which are emitted when the transaction is committed.
+	pendingEvents []skydb.RecordEvent
+}
+
+// transaction keeps the changes made in a transaction.
+//
+// Changes are made to a snapshot of the store taken when the transaction
+// begins. The changes are made again to the store when the transaction
+// is committed, so that changes committed by other connections in the
+// meantime are not lost.
+type transaction struct {
+	state   *state
+	changes []func(s *state) error
+}
+
+// view calls read with the state seen by the connection.
+func (c *conn) view(read func(s *state) error) error {
+	if c.tx != nil {
+		return read(c.tx.state)
+	}
+
+	c.store.mutex.RLock()
+	defer c.store.mutex.RUnlock()
+	return read(c.store.state)
+}
+
+// update calls write to change the state seen by the connection. In a
+// transaction, write is called again with the state of the store when
+// the transaction is committed.
+//
+// write must not change the state if it returns an error.
+func (c *conn) update(write func(s *state) error) error {
+	if c.tx != nil {
+		if err := write(c.tx.state); err != nil {
+			return err
+		}
+		c.tx.changes = append(c.tx.changes, write)
+		return nil
+	}
+
+	c.store.mutex.Lock()
+	defer c.store.mutex.Unlock()
+	return write(c.store.state)
+}
+
+// Begin begins a transaction.
+func (c *conn) Begin() error {
+	log.Debugf("%p: Beginning transaction", c)
+	if c.tx != nil {
+		return skydb.ErrDatabaseTxDidBegin
+	}
+
+	c.store.mutex.RLock()
+	defer c.store.mutex.RUnlock()
+	c.tx = &transaction{state: c.store.state.clone()}
+	log.Debugf("%p: Done beginning transaction %p", c, c.tx)
+	return nil
+}
+
+// Commit commits a transaction.
+//
+// The changes of the transaction are applied to the store atomically.
+// If a change cannot be applied because of changes committed by other
+// connections, no changes are applied and an error is returned.
+func (c *conn) Commit() error {
+	if c.tx == nil {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	tx := c.tx
+	events := c.pendingEvents
+	c.tx = nil
+	c.pendingEvents = nil
+
+	if len(tx.changes) > 0 {
+		c.store.mutex.Lock()
+		committed := c.store.state.clone()
+		for _, change := range tx.changes {
+			if err := change(committed); err != nil {
+				c.store.mutex.Unlock()
+				log.Errorf("%p: Unable to commit transaction %p: %v", c, tx, err)
+				return err
+			}
+		}
+		c.store.state = committed
+		c.store.mutex.Unlock()
+	}
+	log.Debugf("%p: Committed transaction", c)
+
+	for _, event := range events {
+		emit(c.option, event)
+	}
+	return nil
+}
+
+// Rollback rollbacks a transaction.
+func (c *conn) Rollback() error {
+	if c.tx == nil {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	c.tx = nil
+	c.pendingEvents = nil
+	log.Debugf("%p: Rolled back transaction", c)
+	return nil
+}
+
+// withTx calls do in a transaction. If a transaction is already in
+// effect, do is called in that transaction.
+func (c *conn) withTx(do func() error) error {
+	if c.tx != nil {
+		return do()
+	}
+	return skydb.WithTransaction(c, do)
+}
+
+func (c *conn) PublicDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PublicDatabase,
+	}
+}
+
+func (c *conn) PrivateDB(userKey string) skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PrivateDatabase,
+		userID:       userKey,
+	}
+}
+
+func (c *conn) UnionDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.UnionDatabase,
+	}
+}
+
+func (c *conn) Close() error { return nil }
+
+var storeEventChannelsMap = map[string][]chan skydb.RecordEvent{}
+var storeEventChannelsMutex sync.RWMutex
+
+// Subscribe registers the channel to receive the record events of the
+// store of the connection.
+func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
+	storeEventChannelsMutex.Lock()
+	defer storeEventChannelsMutex.Unlock()
+
+	storeEventChannelsMap[c.option] = append(storeEventChannelsMap[c.option], recordEventChan)
+	return nil
+}
+
+// notify emits the record event when the current transaction is
+// committed, or immediately if there is no transaction.
+func (c *conn) notify(event skydb.RecordEvent) {
+	if c.tx != nil {
+		c.pendingEvents = append(c.pendingEvents, event)
+		return
+	}
+	emit(c.option, event)
+}
+
+func emit(storeName string, event skydb.RecordEvent) {
+	storeEventChannelsMutex.RLock()
+	defer storeEventChannelsMutex.RUnlock()
+
+	for _, channel := range storeEventChannelsMap[storeName] {
+		go func(ch chan skydb.RecordEvent) {
+			ch <- event
+		}(channel)
+	}
+}
+
+type database struct {
+	c            *conn
+	userID       string
+	databaseType skydb.DatabaseType
+}
+
+func (db *database) Conn() skydb.Conn       { return db.c }
+func (db *database) UserRecordType() string { return "user" }
+
+func (db *database) ID() string {
+	if db.DatabaseType() == skydb.PublicDatabase {
+		return skydb.PublicDatabaseIdentifier
+	} else if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.UnionDatabaseIdentifier
+	}
+
+	if db.userID == "" {
+		panic("Private database but userID is empty")
+	}
+	return db.userID
+}
+
+func (db *database) DatabaseType() skydb.DatabaseType { return db.databaseType }
+func (db *database) IsReadOnly() bool                 { return db.DatabaseType() == skydb.UnionDatabase }
+
+// TableName returns the name of the record type, since records are not
+// kept in tables.
+func (db *database) TableName(table string) string {
+	return table
+}
+
+func (db *database) Begin() error {
+	return db.c.Begin()
+}
+
+func (db *database) Commit() error {
+	return db.c.Commit()
+}
+
+func (db *database) Rollback() error {
+	return db.c.Rollback()
+}
+
+// this ensures that our structure conform to certain interfaces.
+var (
+	_ skydb.Conn       = &conn{}
+	_ skydb.Database   = &database{}
+	_ skydb.TxDatabase = &database{}
+)
@@ -0,0 +1,129 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) GetDevice(id string, device *skydb.Device) error {
+	return c.view(func(s *state) error {
+		stored, ok := s.devices[id]
+		if !ok {
+			return skydb.ErrDeviceNotFound
+		}
+		*device = stored
+		return nil
+	})
+}
+
+func (c *conn) queryDevices(match func(device skydb.Device) bool) ([]skydb.Device, error) {
+	results := []skydb.Device{}
+	err := c.view(func(s *state) error {
+		for _, device := range s.devices {
+			if match(device) {
+				results = append(results, device)
+			}
+		}
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results, err
+}
+
+func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	return c.queryDevices(func(device skydb.Device) bool {
+		return device.AuthInfoID == user
+	})
+}
+
+func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
+	return c.queryDevices(func(device skydb.Device) bool {
+		return device.AuthInfoID == user && device.Topic == topic
+	})
+}
+
+func (c *conn) SaveDevice(device *skydb.Device) error {
+	if device.ID == "" || device.Type == "" || device.LastRegisteredAt.IsZero() {
+		return errors.New("invalid device: empty id, type, or last registered at")
+	}
+
+	saved := *device
+	return c.update(func(s *state) error {
+		stored := saved
+		if existing, ok := s.devices[stored.ID]; ok {
+			// Token and topic are not updated if they are not specified.
+			if stored.Token == "" {
+				stored.Token = existing.Token
+			}
+			if stored.Topic == "" {
+				stored.Topic = existing.Topic
+			}
+		}
+		s.devices[stored.ID] = stored
+		return nil
+	})
+}
+
+func (c *conn) DeleteDevice(id string) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		return device.ID == id
+	})
+}
+
+func (c *conn) DeleteDevicesByToken(token string, t time.Time) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		return device.Token == token &&
+			(t == skydb.ZeroTime || device.LastRegisteredAt.Before(t))
+	})
+}
+
+func (c *conn) DeleteEmptyDevicesByTime(t time.Time) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		return device.Token == "" &&
+			(t == skydb.ZeroTime || device.LastRegisteredAt.Before(t))
+	})
+}
+
+// deleteDevices deletes the matching devices and their subscriptions.
+func (c *conn) deleteDevices(match func(device skydb.Device) bool) error {
+	return c.update(func(s *state) error {
+		deleted := map[string]bool{}
+		for id, device := range s.devices {
+			if match(device) {
+				deleted[id] = true
+			}
+		}
+		if len(deleted) == 0 {
+			return skydb.ErrDeviceNotFound
+		}
+
+		for id := range deleted {
+			delete(s.devices, id)
+		}
+		for key := range s.subscriptions {
+			if deleted[key.deviceID] {
+				delete(s.subscriptions, key)
+			}
+		}
+		return nil
+	})
+}
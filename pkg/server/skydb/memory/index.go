@@ -0,0 +1,228 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+func (db *database) GetIndexesByRecordType(recordType string) (map[string]skydb.Index, error) {
+	indexes := map[string]skydb.Index{}
+	err := db.c.view(func(s *state) error {
+		for name, ri := range s.indexes {
+			if ri.recordType == recordType {
+				indexes[name] = ri.index
+			}
+		}
+		return nil
+	})
+	return indexes, err
+}
+
+// SaveIndex saves the index of the record type. Creating a unique index
+// fails if the index keys of existing records are duplicated.
+func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	index = skydb.Index{
+		Fields:      append([]string{}, index.Fields...),
+		Expressions: append([]skydb.IndexExpression{}, index.Expressions...),
+		Unique:      index.Unique,
+		Predicate:   index.Predicate,
+		Status:      skydb.IndexReady,
+	}
+	index.Definition = indexDefinition(index)
+
+	return db.c.update(func(s *state) error {
+		if _, ok := s.indexes[indexName]; ok {
+			return skyerr.NewErrorf(skyerr.Duplicated, "index %s already exists", indexName)
+		}
+
+		schema := s.recordSchema(recordType)
+		if schema == nil {
+			return skyerr.NewErrorf(skyerr.ResourceNotFound, "record type %s does not exist", recordType)
+		}
+		for _, field := range indexFields(index) {
+			if _, ok := schema[field]; !ok {
+				return skyerr.NewErrorf(skyerr.ResourceNotFound, "field %s of %s does not exist", field, recordType)
+			}
+		}
+
+		if index.Unique {
+			seen := map[string]skydb.RecordID{}
+			for _, record := range s.records[recordType] {
+				key, ok, err := s.indexKey(recordType, index, &record)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				if other, ok := seen[key]; ok {
+					return skyerr.NewErrorf(skyerr.Duplicated,
+						"failed to create index %s: %s and %s are duplicated", indexName, other, record.ID)
+				}
+				seen[key] = record.ID
+			}
+		}
+
+		s.indexes[indexName] = recordIndex{recordType, index}
+		return nil
+	})
+}
+
+func (db *database) DeleteIndex(recordType string, indexName string) error {
+	return db.c.update(func(s *state) error {
+		if ri, ok := s.indexes[indexName]; !ok || ri.recordType != recordType {
+			return skydb.ErrIndexNotFound
+		}
+
+		delete(s.indexes, indexName)
+		return nil
+	})
+}
+
+// indexDefinition describes the keys and the predicate of the index.
+func indexDefinition(index skydb.Index) string {
+	keys := append([]string{}, index.Fields...)
+	for _, expr := range index.Expressions {
+		keys = append(keys, fmt.Sprintf("%s(%s)", expr.Function, expr.Field))
+	}
+
+	definition := fmt.Sprintf("INDEX (%s)", strings.Join(keys, ", "))
+	if index.Unique {
+		definition = "UNIQUE " + definition
+	}
+	if !index.Predicate.IsEmpty() {
+		definition += fmt.Sprintf(" WHERE %v", index.Predicate)
+	}
+	return definition
+}
+
+// indexFields returns the fields of the index keys.
+func indexFields(index skydb.Index) []string {
+	fields := append([]string{}, index.Fields...)
+	for _, expr := range index.Expressions {
+		fields = append(fields, expr.Field)
+	}
+	return fields
+}
+
+func indexHasField(index skydb.Index, field string) bool {
+	for _, f := range indexFields(index) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func renameIndexField(index skydb.Index, oldName, newName string) skydb.Index {
+	renamed := index
+	renamed.Fields = make([]string, len(index.Fields))
+	for i, field := range index.Fields {
+		if field == oldName {
+			field = newName
+		}
+		renamed.Fields[i] = field
+	}
+	renamed.Expressions = make([]skydb.IndexExpression, len(index.Expressions))
+	for i, expr := range index.Expressions {
+		if expr.Field == oldName {
+			expr.Field = newName
+		}
+		renamed.Expressions[i] = expr
+	}
+	renamed.Definition = indexDefinition(renamed)
+	return renamed
+}
+
+// indexKey returns the text of the index keys of the record. It returns
+// false if the record is not indexed, because a key is null or the record
+// does not satisfy the predicate of the index.
+func (s *state) indexKey(recordType string, index skydb.Index, record *skydb.Record) (string, bool, error) {
+	if !index.Predicate.IsEmpty() {
+		matched, err := newEvaluator(s, recordType).match(index.Predicate, record)
+		if err != nil || !matched {
+			return "", false, err
+		}
+	}
+
+	keys := []interface{}{}
+	for _, field := range index.Fields {
+		keys = append(keys, record.Get(field))
+	}
+	for _, expr := range index.Expressions {
+		value, ok := record.Get(expr.Field).(string)
+		if !ok {
+			keys = append(keys, nil)
+			continue
+		}
+		switch expr.Function {
+		case skydb.IndexLowerFunction:
+			keys = append(keys, strings.ToLower(value))
+		case skydb.IndexUpperFunction:
+			keys = append(keys, strings.ToUpper(value))
+		}
+	}
+
+	for _, key := range keys {
+		// Null keys are never duplicated.
+		if key == nil {
+			return "", false, nil
+		}
+	}
+	text, err := json.Marshal(keys)
+	if err != nil {
+		return "", false, err
+	}
+	return string(text), true, nil
+}
+
+// checkUniqueIndexes returns an error if the record duplicates the index
+// keys of another record of the record type in a unique index.
+func (s *state) checkUniqueIndexes(recordType string, record *skydb.Record) error {
+	for name, ri := range s.indexes {
+		if ri.recordType != recordType || !ri.index.Unique {
+			continue
+		}
+
+		key, ok, err := s.indexKey(recordType, ri.index, record)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		for _, other := range s.records[recordType] {
+			if other.ID == record.ID && other.DatabaseID == record.DatabaseID {
+				continue
+			}
+			otherKey, ok, err := s.indexKey(recordType, ri.index, &other)
+			if err != nil {
+				return err
+			}
+			if ok && otherKey == key {
+				return skyerr.NewErrorf(skyerr.Duplicated,
+					"failed to save %s: duplicated keys of index %s", record.ID, name)
+			}
+		}
+	}
+	return nil
+}
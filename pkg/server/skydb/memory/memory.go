@@ -0,0 +1,86 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory implements skydb interfaces by keeping data in memory.
+//
+// Data is kept in the server process and is lost when the process exits.
+// It is intended for local development and tests of apps and plugins,
+// where running a database is not desirable. Spatial queries and
+// full-text search are not supported.
+//
+// The option of the driver names the store holding the data, connections
+// opened with the same option share the same data. The app name is used
+// if the option is empty.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var log = logging.LoggerEntry("skydb")
+
+// Open returns a new connection to memory implementation
+func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, option string, migrate bool) (skydb.Conn, error) {
+	if accessModel == skydb.RelationBasedAccess {
+		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
+	}
+
+	name := option
+	if name == "" {
+		name = appName
+	}
+
+	return &conn{
+		store:       getStore(name),
+		appName:     appName,
+		option:      name,
+		accessModel: accessModel,
+		canMigrate:  migrate,
+		context:     ctx,
+	}, nil
+}
+
+var stores = map[string]*store{}
+var storesMutex sync.Mutex
+
+func getStore(name string) *store {
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+
+	if s, ok := stores[name]; ok {
+		return s
+	}
+
+	s := &store{state: initialState()}
+	stores[name] = s
+	return s
+}
+
+// dropStore removes the data of the named store, so that the next Open
+// starts with an empty store.
+func dropStore(name string) {
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+
+	delete(stores, name)
+}
+
+func init() {
+	skydb.Register("memory", skydb.DriverFunc(Open))
+}
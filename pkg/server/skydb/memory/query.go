@@ -0,0 +1,532 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// truth is a value of three-valued logic, in which predicates are
+// evaluated as conditions are in SQL. A comparison with null is unknown,
+// and only records satisfying a predicate with true are matched.
+type truth int
+
+const (
+	falseTruth truth = iota
+	trueTruth
+	unknownTruth
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return trueTruth
+	}
+	return falseTruth
+}
+
+// evaluator evaluates predicates and key paths on records of a record
+// type.
+//
+// Key paths through reference fields are evaluated on the referenced
+// records in the same database.
+type evaluator struct {
+	s          *state
+	recordType string
+	schema     skydb.RecordSchema
+}
+
+func newEvaluator(s *state, recordType string) *evaluator {
+	return &evaluator{
+		s:          s,
+		recordType: recordType,
+		schema:     s.recordSchema(recordType),
+	}
+}
+
+// match returns whether the record satisfies the predicate.
+func (e *evaluator) match(p skydb.Predicate, record *skydb.Record) (bool, error) {
+	if p.IsEmpty() {
+		return true, nil
+	}
+
+	t, err := e.evaluate(p, record)
+	return t == trueTruth, err
+}
+
+func (e *evaluator) evaluate(p skydb.Predicate, record *skydb.Record) (truth, error) {
+	switch p.Operator {
+	case skydb.And, skydb.Or:
+		// The result of And is false if any child is false, and the
+		// result of Or is true if any child is true.
+		decisive := falseTruth
+		if p.Operator == skydb.Or {
+			decisive = trueTruth
+		}
+
+		result := truthOf(p.Operator == skydb.And)
+		for _, child := range p.Children {
+			t, err := e.evaluate(child.(skydb.Predicate), record)
+			if err != nil {
+				return falseTruth, err
+			}
+			if t == decisive {
+				return decisive, nil
+			}
+			if t == unknownTruth {
+				result = unknownTruth
+			}
+		}
+		return result, nil
+	case skydb.Not:
+		t, err := e.evaluate(p.Children[0].(skydb.Predicate), record)
+		if err != nil || t == unknownTruth {
+			return t, err
+		}
+		return truthOf(t == falseTruth), nil
+	case skydb.Functional:
+		return e.evaluateFunction(p.Children[0].(skydb.Expression), record)
+	}
+
+	lhsExpr := p.Children[0].(skydb.Expression)
+	rhsExpr := p.Children[1].(skydb.Expression)
+	lhs, err := e.value(lhsExpr, record)
+	if err != nil {
+		return falseTruth, err
+	}
+	rhs, err := e.value(rhsExpr, record)
+	if err != nil {
+		return falseTruth, err
+	}
+
+	switch p.Operator {
+	case skydb.In:
+		return evaluateIn(lhsExpr, lhs, rhsExpr, rhs)
+	case skydb.ContainsAny, skydb.ContainsAll:
+		return evaluateListContains(lhsExpr, lhs, rhsExpr, rhs, p.Operator)
+	case skydb.HasKey, skydb.Contains:
+		return evaluateJSON(lhsExpr, lhs, rhsExpr, rhs, p.Operator)
+	}
+	return evaluateComparison(lhsExpr, lhs, rhsExpr, rhs, p.Operator)
+}
+
+func (e *evaluator) evaluateFunction(expr skydb.Expression, record *skydb.Record) (truth, error) {
+	fn, ok := expr.Value.(skydb.UserRelationFunc)
+	if !ok {
+		return falseTruth, skyerr.NewErrorf(skyerr.NotSupported,
+			"function %T is not supported by the memory driver", expr.Value)
+	}
+
+	direction := fn.RelationDirection
+	if direction == "" {
+		direction = "outward"
+	}
+	keyPath := fn.KeyPath
+	if keyPath == "_owner" || keyPath == "" {
+		keyPath = "_owner_id"
+	}
+
+	value, err := e.keyPathValue(keyPath, record)
+	if err != nil {
+		return falseTruth, err
+	}
+	userID, ok := keyString(value)
+	if !ok {
+		return falseTruth, nil
+	}
+
+	outward := e.s.related(fn.RelationName, fn.User, userID)
+	inward := e.s.related(fn.RelationName, userID, fn.User)
+	switch direction {
+	case "outward":
+		return truthOf(outward), nil
+	case "inward":
+		return truthOf(inward), nil
+	default:
+		return truthOf(outward && inward), nil
+	}
+}
+
+// value returns the value of a literal or a key path of the record.
+func (e *evaluator) value(expr skydb.Expression, record *skydb.Record) (interface{}, error) {
+	switch expr.Type {
+	case skydb.Literal:
+		return expr.Value, nil
+	case skydb.KeyPath:
+		return e.keyPathValue(expr.Value.(string), record)
+	}
+	return nil, skyerr.NewErrorf(skyerr.NotSupported,
+		"expression %v is not supported by the memory driver", expr.Value)
+}
+
+// keyPathValue returns the value at the key path of the record.
+func (e *evaluator) keyPathValue(keyPath string, record *skydb.Record) (interface{}, error) {
+	components := strings.Split(keyPath, ".")
+	if e.schema == nil && len(components) == 1 {
+		// The record type has not been created when a subscription
+		// is matched against a record, so the record is not checked
+		// against the schema.
+		return record.Get(keyPath), nil
+	}
+
+	field, ok := e.schema[components[0]]
+	if !ok {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" does not exist`, keyPath)
+	}
+	value := record.Get(components[0])
+	if len(components) == 1 {
+		return value, nil
+	}
+
+	if field.Type == skydb.TypeJSON {
+		return jsonPathValue(value, components[1:]), nil
+	}
+	if len(components) > 2 {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" with more than 2 components is not supported`, keyPath)
+	}
+	if field.Type != skydb.TypeReference {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`field "%s" in keypath "%s" is not a reference`, components[0], keyPath)
+	}
+	if _, ok := e.s.recordSchema(field.ReferenceType)[components[1]]; !ok {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" does not exist`, keyPath)
+	}
+
+	reference, ok := value.(skydb.Reference)
+	if !ok {
+		return nil, nil
+	}
+	referenced, ok := e.s.records[field.ReferenceType][recordKey{record.DatabaseID, reference.ID.Key}]
+	if !ok {
+		return nil, nil
+	}
+	return referenced.Get(components[1]), nil
+}
+
+// jsonPathValue returns the value at the path of a JSON value, or nil if
+// there is no such value.
+func jsonPathValue(value interface{}, path []string) interface{} {
+	for _, element := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[element]
+	}
+	return value
+}
+
+func evaluateIn(lhsExpr skydb.Expression, lhs interface{}, rhsExpr skydb.Expression, rhs interface{}) (truth, error) {
+	switch {
+	case lhsExpr.Type == skydb.KeyPath && rhsExpr.IsLiteralArray():
+		if lhs == nil {
+			return unknownTruth, nil
+		}
+		for _, element := range rhs.([]interface{}) {
+			if element != nil && equalValues(lhs, element) {
+				return trueTruth, nil
+			}
+		}
+		return falseTruth, nil
+	case lhsExpr.Type == skydb.Literal && rhsExpr.Type == skydb.KeyPath:
+		list, _ := rhs.([]interface{})
+		return truthOf(lhs != nil && listContains(list, lhs)), nil
+	}
+	return falseTruth, skyerr.NewError(skyerr.RecordQueryInvalid,
+		"comparison operator `in` requires a keypath and an array, or a value and a list keypath")
+}
+
+func listContains(list []interface{}, value interface{}) bool {
+	for _, element := range list {
+		if element != nil && equalValues(element, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateListContains evaluates whether a list contains any or all of
+// the values in an array.
+func evaluateListContains(lhsExpr skydb.Expression, lhs interface{}, rhsExpr skydb.Expression, rhs interface{}, operator skydb.Operator) (truth, error) {
+	if lhsExpr.Type != skydb.KeyPath || !rhsExpr.IsLiteralArray() {
+		return falseTruth, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"comparison operator `%v` requires a list keypath and an array", operator)
+	}
+
+	values := rhs.([]interface{})
+	if len(values) == 0 {
+		// Every list contains all values of an empty array, but
+		// no list contains any of them.
+		return truthOf(operator == skydb.ContainsAll), nil
+	}
+
+	list, _ := lhs.([]interface{})
+	matched := 0
+	for _, value := range values {
+		if listContains(list, value) {
+			matched++
+		}
+	}
+	if operator == skydb.ContainsAll {
+		return truthOf(matched == len(values)), nil
+	}
+	return truthOf(matched > 0), nil
+}
+
+// evaluateJSON evaluates whether a JSON value has a key, or contains
+// another JSON value.
+func evaluateJSON(lhsExpr skydb.Expression, lhs interface{}, rhsExpr skydb.Expression, rhs interface{}, operator skydb.Operator) (truth, error) {
+	if lhsExpr.Type != skydb.KeyPath || rhsExpr.Type != skydb.Literal || rhs == nil {
+		return falseTruth, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"comparison operator `%v` requires a json keypath and a literal value", operator)
+	}
+	if lhs == nil {
+		return unknownTruth, nil
+	}
+
+	if operator == skydb.HasKey {
+		key, ok := rhs.(string)
+		if !ok {
+			return falseTruth, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+				"comparison operator `%v` requires a string key", operator)
+		}
+		object, ok := lhs.(map[string]interface{})
+		if !ok {
+			return falseTruth, nil
+		}
+		_, ok = object[key]
+		return truthOf(ok), nil
+	}
+
+	container, err := jsonValue(lhs)
+	if err != nil {
+		return falseTruth, err
+	}
+	contained, err := jsonValue(rhs)
+	if err != nil {
+		return falseTruth, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+	return truthOf(jsonContains(container, contained)), nil
+}
+
+// jsonContains returns whether the JSON value contains the other JSON
+// value, as the containment of jsonb in PostgreSQL.
+func jsonContains(container interface{}, contained interface{}) bool {
+	switch v := contained.(type) {
+	case map[string]interface{}:
+		object, ok := container.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range v {
+			element, ok := object[key]
+			if !ok || !jsonContains(element, value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		array, ok := container.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range v {
+			found := false
+			for _, element := range array {
+				if jsonContains(element, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	// An array contains a primitive value which is one of its elements.
+	if array, ok := container.([]interface{}); ok {
+		for _, element := range array {
+			if reflect.DeepEqual(element, contained) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(container, contained)
+}
+
+func evaluateComparison(lhsExpr skydb.Expression, lhs interface{}, rhsExpr skydb.Expression, rhs interface{}, operator skydb.Operator) (truth, error) {
+	if operator == skydb.Equal || operator == skydb.NotEqual {
+		// Comparing with a null literal is `IS NULL` or `IS NOT NULL`.
+		if lhsExpr.IsLiteralNull() {
+			lhs, rhs = rhs, lhs
+			lhsExpr, rhsExpr = rhsExpr, lhsExpr
+		}
+		if rhsExpr.IsLiteralNull() {
+			return truthOf((lhs == nil) == (operator == skydb.Equal)), nil
+		}
+	}
+
+	if lhs == nil || rhs == nil {
+		return unknownTruth, nil
+	}
+
+	switch operator {
+	case skydb.Equal:
+		return truthOf(equalValues(lhs, rhs)), nil
+	case skydb.NotEqual:
+		return truthOf(!equalValues(lhs, rhs)), nil
+	case skydb.GreaterThan, skydb.GreaterThanOrEqual, skydb.LessThan, skydb.LessThanOrEqual:
+		c, ok := compareValues(lhs, rhs)
+		if !ok {
+			return unknownTruth, nil
+		}
+		switch operator {
+		case skydb.GreaterThan:
+			return truthOf(c > 0), nil
+		case skydb.GreaterThanOrEqual:
+			return truthOf(c >= 0), nil
+		case skydb.LessThan:
+			return truthOf(c < 0), nil
+		default:
+			return truthOf(c <= 0), nil
+		}
+	case skydb.Like, skydb.ILike:
+		s, ok := lhs.(string)
+		pattern, patternOK := rhs.(string)
+		if !ok || !patternOK {
+			return unknownTruth, nil
+		}
+		re, err := likeRegexp(pattern, operator == skydb.ILike)
+		if err != nil {
+			return falseTruth, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		return truthOf(re.MatchString(s)), nil
+	}
+
+	return falseTruth, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+		"comparison operator `%v` is not supported", operator)
+}
+
+// likeRegexp returns the regular expression matching the same strings as
+// the pattern of LIKE, in which `%` and `_` are wildcards and `\` escapes
+// the following character.
+func likeRegexp(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString("(?s)")
+	if caseInsensitive {
+		buf.WriteString("(?i)")
+	}
+	buf.WriteString("^")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			buf.WriteString(".*")
+		case r == '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// compareSortValues compares the values of a sort, null values are greater than
+// any other values.
+func compareSortValues(a interface{}, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	c, _ := compareValues(a, b)
+	return c
+}
+
+// sortKey is the position of a record in the order of a query, which is
+// the values of the record for the sorts followed by the record ID.
+type sortKey struct {
+	values []interface{}
+	id     string
+}
+
+// compareSortKeys compares the position of two records in the order of
+// the sorts, followed by the ascending order of `_id`.
+//
+// Null values are sorted last in ascending order and first in descending
+// order, as PostgreSQL does.
+func compareSortKeys(a sortKey, b sortKey, sorts []skydb.Sort) int {
+	for i, sort := range sorts {
+		c := compareSortValues(a.values[i], b.values[i])
+		if sort.Order == skydb.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// checkSorts returns an error if the records cannot be sorted by the
+// sorts.
+func checkSorts(sorts []skydb.Sort) error {
+	for _, sort := range sorts {
+		if sort.Expression.Type != skydb.KeyPath {
+			return skyerr.NewError(skyerr.NotSupported,
+				"sorting by function is not supported by the memory driver")
+		}
+	}
+	return nil
+}
+
+// sortKey returns the position of the record in the order of the sorts,
+// which are checked by checkSorts.
+func (e *evaluator) sortKey(record *skydb.Record, sorts []skydb.Sort) (sortKey, error) {
+	key := sortKey{
+		values: make([]interface{}, len(sorts)),
+		id:     record.ID.Key,
+	}
+	for i, sort := range sorts {
+		value, err := e.keyPathValue(sort.Expression.Value.(string), record)
+		if err != nil {
+			return sortKey{}, err
+		}
+		key.values[i] = value
+	}
+	return key, nil
+}
@@ -0,0 +1,627 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// findRecord returns the record of the database identified by the id.
+// The union database finds the record in all databases.
+func (db *database) findRecord(s *state, id skydb.RecordID) (skydb.Record, bool) {
+	records := s.records[id.Type]
+	if db.DatabaseType() != skydb.UnionDatabase {
+		record, ok := records[recordKey{db.userID, id.Key}]
+		return record, ok
+	}
+
+	for key, record := range records {
+		if key.key == id.Key {
+			return record, true
+		}
+	}
+	return skydb.Record{}, false
+}
+
+func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	return db.c.view(func(s *state) error {
+		stored, ok := db.findRecord(s, id)
+		if !ok {
+			return skydb.ErrRecordNotFound
+		}
+		*record = copyRecord(stored)
+		return nil
+	})
+}
+
+// GetByIDs only support one type of records at a time, like the other
+// drivers do.
+func (db *database) GetByIDs(ids []skydb.RecordID) (*skydb.Rows, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("db.GetByIDs received empty array")
+	}
+	recordType := ""
+	for _, recordID := range ids {
+		if recordID.Type != "" && recordType == "" {
+			recordType = recordID.Type
+		}
+	}
+
+	records := []skydb.Record{}
+	err := db.c.view(func(s *state) error {
+		if _, ok := s.schemas[recordType]; !ok {
+			return skydb.ErrRecordNotFound
+		}
+
+		for _, recordID := range ids {
+			if recordID.Key == "" {
+				continue
+			}
+			id := skydb.NewRecordID(recordType, recordID.Key)
+			if record, ok := db.findRecord(s, id); ok {
+				records = append(records, copyRecord(record))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return skydb.NewRows(&recordsIter{records: records}), nil
+}
+
+// Save inserts the record, or updates the record if it exists.
+//
+// Field operations are applied to the existing value of the field in the
+// same transaction as the update.
+func (db *database) Save(record *skydb.Record) error {
+	if record.ID.Key == "" {
+		return errors.New("db.save: got empty record id")
+	}
+	if record.ID.Type == "" {
+		return fmt.Errorf("db.save %s: got empty record type", record.ID.Key)
+	}
+	if record.OwnerID == "" {
+		return fmt.Errorf("db.save %s: got empty OwnerID", record.ID.Key)
+	}
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	saving := copyRecord(*record)
+	exists := false
+	err := db.c.update(func(s *state) error {
+		var err error
+		exists, err = db.saveRecord(s, saving)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Get(record.ID, record); err != nil {
+		return err
+	}
+
+	event := skydb.RecordCreated
+	if exists {
+		event = skydb.RecordUpdated
+	}
+	saved := record.Copy()
+	db.c.notify(skydb.RecordEvent{Record: &saved, Event: event})
+	return nil
+}
+
+// saveRecord saves the record to the state, and returns whether the
+// record existed. The owner and creation of an existing record are not
+// updated.
+func (db *database) saveRecord(s *state, record skydb.Record) (bool, error) {
+	typemap := s.recordSchema(record.ID.Type)
+	if typemap == nil {
+		return false, fmt.Errorf("db.save %s: record type has not been created", record.ID)
+	}
+
+	key := recordKey{db.userID, record.ID.Key}
+	existing, exists := s.records[record.ID.Type][key]
+
+	var stored skydb.Record
+	if exists {
+		stored = copyRecord(existing)
+	} else {
+		stored = skydb.Record{
+			ID:         record.ID,
+			DatabaseID: db.userID,
+			OwnerID:    record.OwnerID,
+			CreatedAt:  record.CreatedAt.UTC(),
+			CreatorID:  record.CreatorID,
+			Data:       skydb.Data{},
+		}
+	}
+
+	for field, value := range record.Data {
+		switch v := value.(type) {
+		case skydb.Unknown:
+			// Do not modify fields with unknown type because they are
+			// managed by the developer.
+			continue
+		case skydb.FieldOperation:
+			result, err := v.Apply(stored.Data[field])
+			if err != nil {
+				return false, skyerr.NewInvalidArgument(
+					fmt.Sprintf("cannot apply %s to field %s: %s", v.Operator, field, err),
+					[]string{field},
+				)
+			}
+			value = result
+		}
+
+		fieldType, ok := typemap[field]
+		if !ok || strings.HasPrefix(field, "_") {
+			return false, skyerr.NewErrorf(skyerr.InvalidArgument,
+				"failed to save %s: field %s has not been created", record.ID, field)
+		}
+		converted, err := fieldValue(fieldType, value)
+		if err != nil {
+			return false, skyerr.NewErrorf(skyerr.InvalidArgument, "failed to save %s: %s", record.ID, err)
+		}
+		if converted == nil {
+			delete(stored.Data, field)
+		} else {
+			stored.Data[field] = converted
+		}
+	}
+
+	if !exists {
+		for field, fieldType := range typemap {
+			if _, ok := stored.Data[field]; ok || fieldType.Type != skydb.TypeSequence {
+				continue
+			}
+			stored.Data[field] = s.nextSequence(record.ID.Type, field)
+		}
+	}
+
+	stored.ACL = copyACL(record.ACL)
+	stored.UpdatedAt = record.UpdatedAt.UTC()
+	stored.UpdaterID = record.UpdaterID
+
+	if err := s.checkReferences(typemap, &stored); err != nil {
+		return false, err
+	}
+	if err := s.checkUniqueIndexes(record.ID.Type, &stored); err != nil {
+		return false, err
+	}
+
+	s.records[record.ID.Type][key] = stored
+	return exists, nil
+}
+
+// nextSequence returns the next value of the sequence field, which is one
+// more than the greatest value of the field.
+func (s *state) nextSequence(recordType string, field string) int64 {
+	var max int64
+	for _, record := range s.records[recordType] {
+		if value, ok := record.Data[field].(int64); ok && value > max {
+			max = value
+		}
+	}
+	return max + 1
+}
+
+// checkReferences returns an error if a record or an asset referenced by
+// the record does not exist.
+func (s *state) checkReferences(typemap skydb.RecordSchema, record *skydb.Record) error {
+	for field, fieldType := range typemap {
+		switch value := record.Data[field].(type) {
+		case skydb.Reference:
+			if value.ID == record.ID || s.recordExists(value.ID) {
+				continue
+			}
+			return skyerr.NewErrorf(skyerr.ConstraintViolated,
+				"failed to save %s: referenced record %s does not exist", record.ID, value.ID)
+		case *skydb.Asset:
+			if _, ok := s.assets[value.Name]; ok || fieldType.Type != skydb.TypeAsset {
+				continue
+			}
+			return skyerr.NewErrorf(skyerr.ConstraintViolated,
+				"failed to save %s: asset %s does not exist", record.ID, value.Name)
+		}
+	}
+	return nil
+}
+
+// recordExists returns whether the record exists in any database.
+func (s *state) recordExists(id skydb.RecordID) bool {
+	for key := range s.records[id.Type] {
+		if key.key == id.Key {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *database) Delete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	var deleted skydb.Record
+	err := db.c.update(func(s *state) error {
+		record, ok := s.records[id.Type][recordKey{db.userID, id.Key}]
+		if !ok {
+			return skydb.ErrRecordNotFound
+		}
+		if err := s.deleteRecord(record); err != nil {
+			return err
+		}
+		deleted = copyRecord(record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db.c.notify(skydb.RecordEvent{Record: &deleted, Event: skydb.RecordDeleted})
+	return nil
+}
+
+// recordRef identifies a record in all databases.
+type recordRef struct {
+	recordType string
+	recordKey
+}
+
+func refOf(record skydb.Record) recordRef {
+	return recordRef{record.ID.Type, recordKey{record.DatabaseID, record.ID.Key}}
+}
+
+// referencing calls fn with each record referencing the record, and the
+// field type of the reference.
+func (s *state) referencing(record skydb.Record, fn func(referencing skydb.Record, field string, fieldType skydb.FieldType)) {
+	for recordType, schema := range s.schemas {
+		for field, fieldType := range schema {
+			if fieldType.Type != skydb.TypeReference || fieldType.ReferenceType != record.ID.Type {
+				continue
+			}
+			for _, other := range s.records[recordType] {
+				reference, ok := other.Data[field].(skydb.Reference)
+				if ok && reference.ID.Key == record.ID.Key {
+					fn(other, field, fieldType)
+				}
+			}
+		}
+	}
+}
+
+// deleteRecord deletes the record, and performs the referential actions
+// of the fields referencing it. No records are changed if the record is
+// referenced by a field without a cascade or set null action.
+func (s *state) deleteRecord(record skydb.Record) error {
+	deleting := map[recordRef]skydb.Record{}
+	var collect func(record skydb.Record)
+	collect = func(record skydb.Record) {
+		if _, ok := deleting[refOf(record)]; ok {
+			return
+		}
+		deleting[refOf(record)] = record
+		s.referencing(record, func(other skydb.Record, field string, fieldType skydb.FieldType) {
+			if fieldType.OnDelete == skydb.CascadeAction {
+				collect(other)
+			}
+		})
+	}
+	collect(record)
+
+	nulling := map[recordRef][]string{}
+	var err error
+	for _, deleted := range deleting {
+		s.referencing(deleted, func(other skydb.Record, field string, fieldType skydb.FieldType) {
+			if _, ok := deleting[refOf(other)]; ok {
+				return
+			}
+			if fieldType.OnDelete == skydb.SetNullAction {
+				nulling[refOf(other)] = append(nulling[refOf(other)], field)
+			} else if err == nil {
+				err = skyerr.NewError(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("delete %s: failed to delete record because other records have reference to it", record.ID),
+				)
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	for ref := range deleting {
+		delete(s.records[ref.recordType], ref.recordKey)
+	}
+	for ref, fields := range nulling {
+		modified := copyRecord(s.records[ref.recordType][ref.recordKey])
+		for _, field := range fields {
+			delete(modified.Data, field)
+		}
+		s.records[ref.recordType][ref.recordKey] = modified
+	}
+	return nil
+}
+
+// matchRecords returns the records of the database matching the
+// predicate of the query that the user of the query can access.
+func (db *database) matchRecords(s *state, query *skydb.Query) ([]skydb.Record, error) {
+	e := newEvaluator(s, query.Type)
+	level := query.AccessLevel
+	if level == "" {
+		level = skydb.ReadLevel
+	}
+
+	records := []skydb.Record{}
+	for key, record := range s.records[query.Type] {
+		if db.DatabaseType() != skydb.UnionDatabase && key.databaseID != db.userID {
+			continue
+		}
+
+		matched, err := e.match(query.Predicate, &record)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		if db.DatabaseType() == skydb.PublicDatabase && !query.BypassAccessControl &&
+			!record.Accessible(query.ViewAsUser, level) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Query returns the records matching the query.
+func (db *database) Query(query *skydb.Query) (*skydb.Rows, error) {
+	if query.Type == "" {
+		return nil, errors.New("got empty query type")
+	}
+
+	var records []skydb.Record
+	var count uint64
+	err := db.c.view(func(s *state) error {
+		typemap := s.recordSchema(query.Type)
+		if typemap == nil { // record type has not been created
+			return nil
+		}
+
+		typemap, err := updateTypemapForQuery(query, typemap)
+		if err != nil {
+			return err
+		}
+
+		matched, err := db.matchRecords(s, query)
+		if err != nil {
+			return err
+		}
+		count = uint64(len(matched))
+
+		matched, err = db.pageRecords(s, query, matched)
+		if err != nil {
+			return err
+		}
+
+		records = make([]skydb.Record, len(matched))
+		for i, record := range matched {
+			records[i] = projectRecord(record, typemap)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		return skydb.EmptyRows, nil
+	}
+
+	iter := &recordsIter{records: records}
+
+	// The overall count is not available for cursor query, since the
+	// cursor excludes records from the count.
+	if query.GetCount && query.After == nil && query.Before == nil {
+		iter.recordCount = &count
+	}
+	return skydb.NewRows(iter), nil
+}
+
+// pageRecords sorts the records in the order of the query, and returns
+// the records in the page selected by the cursors, offset and limit of
+// the query.
+func (db *database) pageRecords(s *state, query *skydb.Query, records []skydb.Record) ([]skydb.Record, error) {
+	if err := checkSorts(query.Sorts); err != nil {
+		return nil, err
+	}
+
+	e := newEvaluator(s, query.Type)
+	keys := make([]sortKey, len(records))
+	for i := range records {
+		key, err := e.sortKey(&records[i], query.Sorts)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	cursorKey := func(cursor *skydb.Cursor) (sortKey, error) {
+		if len(cursor.Values) != len(query.Sorts) {
+			return sortKey{}, fmt.Errorf("cursor has %d values, want %d", len(cursor.Values), len(query.Sorts))
+		}
+		return sortKey{values: cursor.Values, id: cursor.ID}, nil
+	}
+	var after, before *sortKey
+	if query.After != nil {
+		key, err := cursorKey(query.After)
+		if err != nil {
+			return nil, err
+		}
+		after = &key
+	}
+	if query.Before != nil {
+		key, err := cursorKey(query.Before)
+		if err != nil {
+			return nil, err
+		}
+		before = &key
+	}
+
+	indices := []int{}
+	for i, key := range keys {
+		if after != nil && compareSortKeys(key, *after, query.Sorts) <= 0 {
+			continue
+		}
+		if before != nil && compareSortKeys(key, *before, query.Sorts) >= 0 {
+			continue
+		}
+		indices = append(indices, i)
+	}
+
+	// When paging backward from a cursor, the records closest to the
+	// cursor are selected in reverse order and reversed again.
+	reversed := query.Before != nil && query.After == nil && query.Limit != nil
+	sort.SliceStable(indices, func(i, j int) bool {
+		c := compareSortKeys(keys[indices[i]], keys[indices[j]], query.Sorts)
+		if reversed {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	if query.Offset >= uint64(len(indices)) {
+		indices = nil
+	} else {
+		indices = indices[query.Offset:]
+	}
+	if query.Limit != nil && *query.Limit < uint64(len(indices)) {
+		indices = indices[:*query.Limit]
+	}
+	if reversed {
+		for i, j := 0, len(indices)-1; i < j; i, j = i+1, j-1 {
+			indices[i], indices[j] = indices[j], indices[i]
+		}
+	}
+
+	paged := make([]skydb.Record, len(indices))
+	for i, index := range indices {
+		paged[i] = records[index]
+	}
+	return paged, nil
+}
+
+// projectRecord returns a copy of the record with the fields in the
+// typemap only.
+func projectRecord(record skydb.Record, typemap skydb.RecordSchema) skydb.Record {
+	projected := copyRecord(record)
+	for field := range projected.Data {
+		if _, ok := typemap[field]; !ok {
+			delete(projected.Data, field)
+		}
+	}
+	return projected
+}
+
+func (db *database) QueryCount(query *skydb.Query) (uint64, error) {
+	if query.Type == "" {
+		return 0, errors.New("got empty query type")
+	}
+
+	var count uint64
+	err := db.c.view(func(s *state) error {
+		if _, ok := s.schemas[query.Type]; !ok { // record type has not been created
+			return nil
+		}
+
+		records, err := db.matchRecords(s, query)
+		count = uint64(len(records))
+		return err
+	})
+	return count, err
+}
+
+// recordsIter iterates over records that are already queried.
+type recordsIter struct {
+	records     []skydb.Record
+	recordCount *uint64
+}
+
+func (rs *recordsIter) Close() error {
+	return nil
+}
+
+func (rs *recordsIter) Next(record *skydb.Record) error {
+	if len(rs.records) == 0 {
+		return io.EOF
+	}
+
+	*record = rs.records[0]
+	rs.records = rs.records[1:]
+	return nil
+}
+
+func (rs *recordsIter) OverallRecordCount() *uint64 {
+	return rs.recordCount
+}
+
+func updateTypemapForQuery(query *skydb.Query, typemap skydb.RecordSchema) (skydb.RecordSchema, error) {
+	if query.DesiredKeys != nil {
+		newtypemap, err := whitelistedRecordSchema(typemap, query.DesiredKeys)
+		if err != nil {
+			return nil, err
+		}
+		typemap = newtypemap
+	}
+
+	for _, value := range query.ComputedKeys {
+		if value.Type == skydb.KeyPath {
+			// recorddb does not support querying with computed keys
+			continue
+		}
+
+		return nil, skyerr.NewError(skyerr.NotSupported,
+			"computed keys are not supported by the memory driver")
+	}
+	return typemap, nil
+}
+
+func whitelistedRecordSchema(schema skydb.RecordSchema, whitelistKeys []string) (skydb.RecordSchema, error) {
+	wlSchema := skydb.RecordSchema{}
+
+	for _, key := range whitelistKeys {
+		columnType, ok := schema[key]
+		if !ok {
+			return nil, fmt.Errorf(`unexpected key "%s"`, key)
+		}
+		wlSchema[key] = columnType
+	}
+	for key, value := range schema {
+		if strings.HasPrefix(key, "_") {
+			wlSchema[key] = value
+		}
+	}
+
+	return wlSchema, nil
+}
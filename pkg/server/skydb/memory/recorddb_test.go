@@ -0,0 +1,459 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func keyPath(key string) skydb.Expression {
+	return skydb.Expression{Type: skydb.KeyPath, Value: key}
+}
+
+func literal(value interface{}) skydb.Expression {
+	return skydb.Expression{Type: skydb.Literal, Value: value}
+}
+
+func recordKeys(records []skydb.Record) []string {
+	keys := []string{}
+	for _, record := range records {
+		keys = append(keys, record.ID.Key)
+	}
+	return keys
+}
+
+func TestRecordCRUD(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"rating":   skydb.FieldType{Type: skydb.TypeNumber},
+			"count":    skydb.FieldType{Type: skydb.TypeInteger},
+			"done":     skydb.FieldType{Type: skydb.TypeBoolean},
+			"due":      skydb.FieldType{Type: skydb.TypeDateTime},
+			"meta":     skydb.FieldType{Type: skydb.TypeJSON},
+			"tags":     skydb.FieldType{Type: skydb.TypeList, ElementType: skydb.TypeString},
+			"place":    skydb.FieldType{Type: skydb.TypeLocation},
+			"parent":   skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "note"},
+			"position": skydb.FieldType{Type: skydb.TypeSequence},
+		})
+		So(err, ShouldBeNil)
+
+		createdAt := time.Date(2017, 1, 2, 3, 4, 5, 678000000, time.UTC)
+		note := skydb.Record{
+			ID:        skydb.NewRecordID("note", "1"),
+			OwnerID:   "user0",
+			CreatedAt: createdAt,
+			CreatorID: "user0",
+			UpdatedAt: createdAt,
+			UpdaterID: "user0",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			},
+			Data: skydb.Data{
+				"title":  "Hello",
+				"rating": 4.5,
+				"count":  int64(3),
+				"done":   true,
+				"due":    time.Date(2017, 2, 3, 4, 5, 6, 0, time.UTC),
+				"meta":   map[string]interface{}{"color": "red"},
+				"tags":   []interface{}{"a", "b"},
+				"place":  skydb.NewLocation(1, 2),
+			},
+		}
+
+		Convey("saves and gets record of all types", func() {
+			So(db.Save(&note), ShouldBeNil)
+			So(note.Data["position"], ShouldEqual, 1)
+			So(note.DatabaseID, ShouldEqual, "")
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record, ShouldResemble, skydb.Record{
+				ID:        skydb.NewRecordID("note", "1"),
+				OwnerID:   "user0",
+				CreatedAt: createdAt,
+				CreatorID: "user0",
+				UpdatedAt: createdAt,
+				UpdaterID: "user0",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+				},
+				Data: skydb.Data{
+					"title":    "Hello",
+					"rating":   4.5,
+					"count":    int64(3),
+					"done":     true,
+					"due":      time.Date(2017, 2, 3, 4, 5, 6, 0, time.UTC),
+					"meta":     map[string]interface{}{"color": "red"},
+					"tags":     []interface{}{"a", "b"},
+					"place":    skydb.NewLocation(1, 2),
+					"position": int64(1),
+				},
+			})
+		})
+
+		Convey("updates record without changing owner and creation", func() {
+			So(db.Save(&note), ShouldBeNil)
+
+			updatedAt := createdAt.Add(time.Hour)
+			So(db.Save(&skydb.Record{
+				ID:        skydb.NewRecordID("note", "1"),
+				OwnerID:   "user1",
+				CreatedAt: updatedAt,
+				CreatorID: "user1",
+				UpdatedAt: updatedAt,
+				UpdaterID: "user1",
+				Data: skydb.Data{
+					"title":  "World",
+					"parent": skydb.NewReference("note", "1"),
+					"count":  skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: int64(2)},
+					"tags":   skydb.FieldOperation{Operator: skydb.AppendOperator, Value: []interface{}{"c"}},
+				},
+			}), ShouldBeNil)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.OwnerID, ShouldEqual, "user0")
+			So(record.CreatedAt, ShouldResemble, createdAt)
+			So(record.UpdatedAt, ShouldResemble, updatedAt)
+			So(record.UpdaterID, ShouldEqual, "user1")
+			So(record.ACL, ShouldBeNil)
+			So(record.Data["title"], ShouldEqual, "World")
+			So(record.Data["parent"], ShouldResemble, skydb.NewReference("note", "1"))
+			So(record.Data["count"], ShouldEqual, 5)
+			So(record.Data["tags"], ShouldResemble, []interface{}{"a", "b", "c"})
+		})
+
+		Convey("rejects reference to non-existent record", func() {
+			note.Data["parent"] = skydb.NewReference("note", "missing")
+			err := db.Save(&note)
+			So(err, ShouldHaveSameTypeAs, skyerr.NewError(skyerr.ConstraintViolated, ""))
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+		})
+
+		Convey("deletes record", func() {
+			So(db.Save(&note), ShouldBeNil)
+			So(db.Delete(skydb.NewRecordID("note", "1")), ShouldBeNil)
+			So(db.Get(skydb.NewRecordID("note", "1"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Delete(skydb.NewRecordID("note", "1")), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("keeps records of private database separated", func() {
+			privateDB := c.PrivateDB("user0")
+			So(privateDB.Save(&note), ShouldBeNil)
+			So(note.DatabaseID, ShouldEqual, "user0")
+			So(db.Get(skydb.NewRecordID("note", "1"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+
+			records, err := exhaustRows(c.UnionDB().Query(&skydb.Query{Type: "note"}))
+			So(err, ShouldBeNil)
+			So(recordKeys(records), ShouldResemble, []string{"1"})
+			So(c.UnionDB().Save(&note), ShouldEqual, skydb.ErrDatabaseIsReadOnly)
+		})
+
+		Convey("gets records by IDs", func() {
+			So(db.Save(&note), ShouldBeNil)
+			note.ID.Key = "2"
+			delete(note.Data, "position")
+			So(db.Save(&note), ShouldBeNil)
+
+			records, err := exhaustRows(db.GetByIDs([]skydb.RecordID{
+				skydb.NewRecordID("note", "2"),
+				skydb.NewRecordID("note", "3"),
+			}))
+			So(err, ShouldBeNil)
+			So(recordKeys(records), ShouldResemble, []string{"2"})
+			So(records[0].Data["position"], ShouldEqual, 2)
+		})
+
+		Convey("performs referential action when deleting referenced record", func() {
+			_, err := db.Extend("comment", skydb.RecordSchema{
+				"note": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      skydb.CascadeAction,
+				},
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Extend("like", skydb.RecordSchema{
+				"note": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      skydb.SetNullAction,
+				},
+			})
+			So(err, ShouldBeNil)
+
+			So(db.Save(&note), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("comment", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"note": skydb.NewReference("note", "1")},
+			}), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("like", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"note": skydb.NewReference("note", "1")},
+			}), ShouldBeNil)
+
+			So(db.Delete(note.ID), ShouldBeNil)
+			So(db.Get(skydb.NewRecordID("comment", "1"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			like := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("like", "1"), &like), ShouldBeNil)
+			So(like.Data, ShouldBeEmpty)
+		})
+
+		Convey("rejects deleting record referenced by other records", func() {
+			So(db.Save(&note), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", "2"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"parent": skydb.NewReference("note", "1")},
+			}), ShouldBeNil)
+
+			err := db.Delete(note.ID)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+			So(db.Get(note.ID, &skydb.Record{}), ShouldBeNil)
+		})
+
+		Convey("emits record events after commit", func() {
+			events := make(chan skydb.RecordEvent, 2)
+			So(c.Subscribe(events), ShouldBeNil)
+
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&note), ShouldBeNil)
+			So(db.Delete(note.ID), ShouldBeNil)
+			So(events, ShouldBeEmpty)
+			So(c.Commit(), ShouldBeNil)
+
+			received := map[skydb.RecordHookEvent]string{}
+			for i := 0; i < 2; i++ {
+				select {
+				case event := <-events:
+					received[event.Event] = event.Record.ID.String()
+				case <-time.After(time.Second):
+					t.Fatal("timed out waiting for record events")
+				}
+			}
+			So(received, ShouldResemble, map[skydb.RecordHookEvent]string{
+				skydb.RecordCreated: "note/1",
+				skydb.RecordDeleted: "note/1",
+			})
+		})
+	})
+}
+
+func TestQuery(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("category", skydb.RecordSchema{
+			"name": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Extend("note", skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"order":    skydb.FieldType{Type: skydb.TypeInteger},
+			"tags":     skydb.FieldType{Type: skydb.TypeList, ElementType: skydb.TypeString},
+			"meta":     skydb.FieldType{Type: skydb.TypeJSON},
+			"category": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "category"},
+		})
+		So(err, ShouldBeNil)
+
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("category", "work"),
+			OwnerID: "user0",
+			Data:    skydb.Data{"name": "Work"},
+		}), ShouldBeNil)
+
+		for _, record := range []skydb.Record{
+			{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "user0",
+				Data: skydb.Data{
+					"title":    "Apple",
+					"order":    int64(2),
+					"tags":     []interface{}{"fruit", "red"},
+					"meta":     map[string]interface{}{"rank": 3.0},
+					"category": skydb.NewReference("category", "work"),
+				},
+			},
+			{
+				ID:      skydb.NewRecordID("note", "2"),
+				OwnerID: "user0",
+				Data: skydb.Data{
+					"title": "banana",
+					"order": int64(1),
+					"tags":  []interface{}{"fruit"},
+					"meta":  map[string]interface{}{"rank": 1.0},
+				},
+			},
+			{
+				ID:      skydb.NewRecordID("note", "3"),
+				OwnerID: "user1",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryRole("editor", skydb.WriteLevel),
+				},
+				Data: skydb.Data{
+					"title": "Cherry",
+				},
+			},
+		} {
+			r := record
+			So(db.Save(&r), ShouldBeNil)
+		}
+
+		query := func(q skydb.Query) []string {
+			q.Type = "note"
+			if q.ViewAsUser == nil {
+				q.BypassAccessControl = true
+			}
+			records, err := exhaustRows(db.Query(&q))
+			So(err, ShouldBeNil)
+			return recordKeys(records)
+		}
+		predicate := func(operator skydb.Operator, lhs, rhs skydb.Expression) skydb.Predicate {
+			return skydb.Predicate{Operator: operator, Children: []interface{}{lhs, rhs}}
+		}
+		byOrder := []skydb.Sort{{Expression: keyPath("order"), Order: skydb.Ascending}}
+
+		Convey("queries with comparison", func() {
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.GreaterThan, keyPath("order"), literal(int64(1))),
+			}), ShouldResemble, []string{"1"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.Equal, keyPath("order"), literal(nil)),
+			}), ShouldResemble, []string{"3"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.Like, keyPath("title"), literal("b%")),
+			}), ShouldResemble, []string{"2"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.ILike, keyPath("title"), literal("a%")),
+			}), ShouldResemble, []string{"1"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.In, keyPath("title"), literal([]interface{}{"Apple", "Cherry"})),
+				Sorts:     byOrder,
+			}), ShouldResemble, []string{"1", "3"})
+		})
+
+		Convey("queries lists and json", func() {
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.In, literal("red"), keyPath("tags")),
+			}), ShouldResemble, []string{"1"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.ContainsAll, keyPath("tags"), literal([]interface{}{"fruit", "red"})),
+			}), ShouldResemble, []string{"1"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.ContainsAny, keyPath("tags"), literal([]interface{}{"fruit", "red"})),
+				Sorts:     byOrder,
+			}), ShouldResemble, []string{"2", "1"})
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.GreaterThan, keyPath("meta.rank"), literal(2.0)),
+			}), ShouldResemble, []string{"1"})
+		})
+
+		Convey("queries by field of referenced record", func() {
+			So(query(skydb.Query{
+				Predicate: predicate(skydb.Equal, keyPath("category.name"), literal("Work")),
+			}), ShouldResemble, []string{"1"})
+		})
+
+		Convey("sorts null last and pages with limit and offset", func() {
+			limit := uint64(2)
+			So(query(skydb.Query{Sorts: byOrder}), ShouldResemble, []string{"2", "1", "3"})
+			So(query(skydb.Query{
+				Sorts: []skydb.Sort{{Expression: keyPath("order"), Order: skydb.Descending}},
+			}), ShouldResemble, []string{"3", "1", "2"})
+			So(query(skydb.Query{Sorts: byOrder, Limit: &limit, Offset: 1}), ShouldResemble, []string{"1", "3"})
+			So(query(skydb.Query{Sorts: byOrder, Offset: 2}), ShouldResemble, []string{"3"})
+		})
+
+		Convey("pages with cursors", func() {
+			limit := uint64(1)
+			So(query(skydb.Query{
+				Sorts: byOrder,
+				After: &skydb.Cursor{Values: []interface{}{int64(1)}, ID: "2"},
+			}), ShouldResemble, []string{"1", "3"})
+			So(query(skydb.Query{
+				Sorts:  byOrder,
+				Before: &skydb.Cursor{Values: []interface{}{nil}, ID: "3"},
+				Limit:  &limit,
+			}), ShouldResemble, []string{"1"})
+		})
+
+		Convey("returns overall count", func() {
+			limit := uint64(1)
+			rows, err := db.Query(&skydb.Query{
+				Type:                "note",
+				Predicate:           predicate(skydb.NotEqual, keyPath("title"), literal("Apple")),
+				Limit:               &limit,
+				GetCount:            true,
+				BypassAccessControl: true,
+			})
+			So(err, ShouldBeNil)
+			So(*rows.OverallRecordCount(), ShouldEqual, 2)
+
+			count, err := db.QueryCount(&skydb.Query{Type: "note", BypassAccessControl: true})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+		})
+
+		Convey("filters records by access control", func() {
+			So(query(skydb.Query{
+				ViewAsUser: &skydb.AuthInfo{ID: "user0"},
+				Sorts:      byOrder,
+			}), ShouldResemble, []string{"2", "1"})
+			So(query(skydb.Query{
+				ViewAsUser:  &skydb.AuthInfo{ID: "user2", Roles: []string{"editor"}},
+				AccessLevel: skydb.WriteLevel,
+				Sorts:       byOrder,
+			}), ShouldResemble, []string{"2", "1", "3"})
+			So(query(skydb.Query{
+				ViewAsUser:          &skydb.AuthInfo{ID: "user2"},
+				BypassAccessControl: true,
+				Sorts:               byOrder,
+			}), ShouldResemble, []string{"2", "1", "3"})
+		})
+
+		Convey("rejects unsupported query", func() {
+			_, err := db.Query(&skydb.Query{
+				Type: "note",
+				Sorts: []skydb.Sort{{
+					Expression: skydb.Expression{
+						Type:  skydb.Function,
+						Value: skydb.DistanceFunc{Field: "place", Location: skydb.NewLocation(1, 2)},
+					},
+				}},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.NotSupported)
+
+			_, err = db.Query(&skydb.Query{
+				Type:      "note",
+				Predicate: predicate(skydb.Equal, keyPath("missing"), literal("a")),
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// relatedUserIDs returns the IDs of users related to the user in the
+// direction, in sorted order.
+func (s *state) relatedUserIDs(user string, name string, direction string) []string {
+	ids := []string{}
+	for r := range s.relations {
+		if r.name != name {
+			continue
+		}
+
+		switch direction {
+		case "outward":
+			if r.left == user {
+				ids = append(ids, r.right)
+			}
+		case "inward":
+			if r.right == user {
+				ids = append(ids, r.left)
+			}
+		default:
+			if r.left == user && s.related(name, r.right, user) {
+				ids = append(ids, r.right)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// related returns whether the left user is related to the right user.
+func (s *state) related(name string, left string, right string) bool {
+	_, ok := s.relations[relation{name, left, right}]
+	return ok
+}
+
+func (c *conn) QueryRelation(user string, name string, direction string, config skydb.QueryConfig) []skydb.AuthInfo {
+	log.Debugf("Query Relation: %v, %v", user, name)
+	var ids []string
+	c.view(func(s *state) error {
+		ids = s.relatedUserIDs(user, name, direction)
+		return nil
+	})
+
+	if config.Offset >= uint64(len(ids)) {
+		ids = nil
+	} else {
+		ids = ids[config.Offset:]
+	}
+	if config.Limit != 0 && config.Limit < uint64(len(ids)) {
+		ids = ids[:config.Limit]
+	}
+
+	results := []skydb.AuthInfo{}
+	for _, id := range ids {
+		results = append(results, skydb.AuthInfo{
+			ID: id,
+		})
+	}
+	return results
+}
+
+func (c *conn) QueryRelationCount(user string, name string, direction string) (uint64, error) {
+	log.Debugf("Query Relation Count: %v, %v, %v", user, name, direction)
+	var count uint64
+	err := c.view(func(s *state) error {
+		count = uint64(len(s.relatedUserIDs(user, name, direction)))
+		return nil
+	})
+	return count, err
+}
+
+func (c *conn) AddRelation(user string, name string, targetUser string) error {
+	return c.update(func(s *state) error {
+		if _, ok := s.auths[user]; !ok {
+			return fmt.Errorf("userID not exist")
+		}
+		if _, ok := s.auths[targetUser]; !ok {
+			return fmt.Errorf("userID not exist")
+		}
+
+		s.relations[relation{name, user, targetUser}] = struct{}{}
+		return nil
+	})
+}
+
+func (c *conn) RemoveRelation(user string, name string, targetUser string) error {
+	return c.update(func(s *state) error {
+		if !s.related(name, user, targetUser) {
+			return fmt.Errorf("%v relation not exist {%v} => {%v}",
+				name, user, targetUser)
+		}
+
+		delete(s.relations, relation{name, user, targetUser})
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// sortedRoles returns the distinct roles in sorted order, or nil if
+// there are no roles.
+func sortedRoles(roles []string) []string {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	set := map[string]struct{}{}
+	for _, role := range roles {
+		set[role] = struct{}{}
+	}
+	sorted := []string{}
+	for role := range set {
+		sorted = append(sorted, role)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// ensureRoles creates the roles which do not exist.
+func (s *state) ensureRoles(roles []string) {
+	for _, roleID := range roles {
+		if _, ok := s.roles[roleID]; !ok {
+			s.roles[roleID] = role{}
+		}
+	}
+}
+
+func (c *conn) getRolesByType(match func(r role) bool) ([]string, error) {
+	roles := []string{}
+	err := c.view(func(s *state) error {
+		for roleID, r := range s.roles {
+			if match(r) {
+				roles = append(roles, roleID)
+			}
+		}
+		return nil
+	})
+	sort.Strings(roles)
+	return roles, err
+}
+
+func (c *conn) GetAdminRoles() ([]string, error) {
+	return c.getRolesByType(func(r role) bool { return r.isAdmin })
+}
+
+func (c *conn) SetAdminRoles(roles []string) error {
+	log.Debugf("SetAdminRoles %v", roles)
+	return c.setRoleType(roles, func(r role, is bool) role {
+		r.isAdmin = is
+		return r
+	})
+}
+
+func (c *conn) GetDefaultRoles() ([]string, error) {
+	return c.getRolesByType(func(r role) bool { return r.byDefault })
+}
+
+func (c *conn) SetDefaultRoles(roles []string) error {
+	log.Debugf("SetDefaultRoles %v", roles)
+	return c.setRoleType(roles, func(r role, is bool) role {
+		r.byDefault = is
+		return r
+	})
+}
+
+// setRoleType sets the roles to be of a role type, and the other roles
+// not to be of the role type.
+func (c *conn) setRoleType(roles []string, set func(r role, is bool) role) error {
+	return c.update(func(s *state) error {
+		s.ensureRoles(roles)
+
+		isOfType := map[string]bool{}
+		for _, roleID := range roles {
+			isOfType[roleID] = true
+		}
+		for roleID, r := range s.roles {
+			s.roles[roleID] = set(r, isOfType[roleID])
+		}
+		return nil
+	})
+}
+
+func (c *conn) AssignRoles(userIDs []string, roles []string) error {
+	log.Debugf("AssignRoles %v to %v", roles, userIDs)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+
+	return c.update(func(s *state) error {
+		for _, userID := range userIDs {
+			if _, ok := s.auths[userID]; !ok {
+				return skydb.ErrUserNotFound
+			}
+		}
+
+		s.ensureRoles(roles)
+		for _, userID := range userIDs {
+			authinfo := copyAuthInfo(s.auths[userID])
+			authinfo.Roles = sortedRoles(append(authinfo.Roles, roles...))
+			s.auths[userID] = authinfo
+		}
+		return nil
+	})
+}
+
+func (c *conn) RevokeRoles(userIDs []string, roles []string) error {
+	log.Debugf("RevokeRoles %v to %v", roles, userIDs)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+
+	revoked := map[string]bool{}
+	for _, roleID := range roles {
+		revoked[roleID] = true
+	}
+
+	return c.update(func(s *state) error {
+		for _, userID := range userIDs {
+			authinfo, ok := s.auths[userID]
+			if !ok {
+				continue
+			}
+
+			authinfo = copyAuthInfo(authinfo)
+			remaining := []string{}
+			for _, roleID := range authinfo.Roles {
+				if !revoked[roleID] {
+					remaining = append(remaining, roleID)
+				}
+			}
+			authinfo.Roles = sortedRoles(remaining)
+			s.auths[userID] = authinfo
+		}
+		return nil
+	})
+}
+
+func (c *conn) GetRoles(userIDs []string) (map[string][]string, error) {
+	roleMap := map[string][]string{}
+	err := c.view(func(s *state) error {
+		for _, userID := range userIDs {
+			// keep an empty array even no roles found for that user
+			roleMap[userID] = append([]string{}, s.auths[userID].Roles...)
+		}
+		return nil
+	})
+	return roleMap, err
+}
@@ -0,0 +1,323 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// reservedSchema is the schema of the reserved fields of every record type.
+var reservedSchema = skydb.RecordSchema{
+	"_id":          skydb.FieldType{Type: skydb.TypeString},
+	"_database_id": skydb.FieldType{Type: skydb.TypeString},
+	"_owner_id":    skydb.FieldType{Type: skydb.TypeString},
+	"_access":      skydb.FieldType{Type: skydb.TypeACL},
+	"_created_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+	"_created_by":  skydb.FieldType{Type: skydb.TypeString},
+	"_updated_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+	"_updated_by":  skydb.FieldType{Type: skydb.TypeString},
+}
+
+func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (extended bool, err error) {
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+
+	// The referential action of an existing field is not altered, like
+	// other drivers. An empty action does not change the action of an
+	// existing field.
+	for key, fieldType := range recordSchema {
+		remoteFieldType, ok := remoteRecordSchema[key]
+		if ok && fieldType.Type == skydb.TypeReference &&
+			remoteFieldType.DefinitionCompatibleTo(fieldType) &&
+			fieldType.OnDelete != skydb.NoReferentialAction &&
+			fieldType.OnDelete != remoteFieldType.OnDelete {
+			return false, skyerr.NewErrorf(
+				skyerr.NotSupported,
+				"changing the referential action of field %s is not supported",
+				key,
+			)
+		}
+	}
+
+	// Find fields with changed validation rules or default values
+	updatingMetadata := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if validationChanged(remoteRecordSchema[key], fieldType) ||
+			defaultChanged(remoteRecordSchema[key], fieldType) {
+			updatingMetadata[key] = fieldType
+		}
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) &&
+		len(updatingMetadata) == 0 {
+		// The current record schema is superset of requested record
+		// schema. There is no need to extend the schema.
+		return
+	}
+
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		err = skyerr.NewError(
+			skyerr.IncompatibleSchema,
+			"Record schema requires migration but migration is disabled.",
+		)
+		return
+	}
+
+	// Find new fields
+	updatingSchema := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if remoteFieldType, ok := remoteRecordSchema[key]; ok {
+			if !remoteFieldType.DefinitionCompatibleTo(fieldType) {
+				return false, skyerr.NewError(
+					skyerr.IncompatibleSchema,
+					fmt.Sprintf("conflicting schema %v => %v", remoteFieldType, fieldType),
+				)
+			}
+		} else {
+			updatingSchema[key] = fieldType
+		}
+	}
+
+	err = db.c.update(func(s *state) error {
+		for _, fieldType := range updatingSchema {
+			if fieldType.Type != skydb.TypeReference || fieldType.ReferenceType == recordType {
+				continue
+			}
+			if _, ok := s.schemas[fieldType.ReferenceType]; !ok {
+				return skyerr.NewErrorf(
+					skyerr.IncompatibleSchema,
+					"referenced record type %s does not exist", fieldType.ReferenceType,
+				)
+			}
+		}
+
+		schema := skydb.RecordSchema{}
+		for key, fieldType := range s.schemas[recordType] {
+			schema[key] = fieldType
+		}
+		for key, fieldType := range updatingSchema {
+			schema[key] = fieldType
+		}
+		for key, fieldType := range updatingMetadata {
+			stored := schema[key]
+			if validationChanged(stored, fieldType) {
+				stored.Validation = nil
+				if !fieldType.Validation.IsEmpty() {
+					stored.Validation = fieldType.Validation
+				}
+			}
+			if defaultChanged(stored, fieldType) {
+				stored.Default = nil
+				if !fieldType.Default.IsEmpty() {
+					stored.Default = fieldType.Default
+				}
+			}
+			schema[key] = stored
+		}
+		s.schemas[recordType] = schema
+		if _, ok := s.records[recordType]; !ok {
+			s.records[recordType] = map[recordKey]skydb.Record{}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func validationChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Validation == nil {
+		return false
+	}
+	if requested.Validation.IsEmpty() {
+		return !remote.Validation.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Validation, requested.Validation)
+}
+
+func defaultChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Default == nil {
+		return false
+	}
+	if requested.Default.IsEmpty() {
+		return !remote.Default.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Default, requested.Default)
+}
+
+func (db *database) RenameSchema(recordType, oldName, newName string) error {
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	return db.c.update(func(s *state) error {
+		schema, ok := s.schemas[recordType]
+		if !ok {
+			return fmt.Errorf("record type %s does not exist", recordType)
+		}
+		fieldType, ok := schema[oldName]
+		if !ok {
+			return fmt.Errorf("field %s of %s does not exist", oldName, recordType)
+		}
+		if _, ok := schema[newName]; ok {
+			return fmt.Errorf("field %s of %s already exists", newName, recordType)
+		}
+
+		renamed := skydb.RecordSchema{}
+		for key, value := range schema {
+			if key != oldName {
+				renamed[key] = value
+			}
+		}
+		renamed[newName] = fieldType
+		s.schemas[recordType] = renamed
+
+		s.updateRecords(recordType, func(record *skydb.Record) {
+			if value, ok := record.Data[oldName]; ok {
+				delete(record.Data, oldName)
+				record.Data[newName] = value
+			}
+		})
+
+		// Indexes keep indexing the renamed field.
+		for name, ri := range s.indexes {
+			if ri.recordType == recordType {
+				ri.index = renameIndexField(ri.index, oldName, newName)
+				s.indexes[name] = ri
+			}
+		}
+		return nil
+	})
+}
+
+func (db *database) DeleteSchema(recordType, columnName string) error {
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	return db.c.update(func(s *state) error {
+		schema, ok := s.schemas[recordType]
+		if !ok {
+			return fmt.Errorf("record type %s does not exist", recordType)
+		}
+		if _, ok := schema[columnName]; !ok {
+			return fmt.Errorf("field %s of %s does not exist", columnName, recordType)
+		}
+
+		remaining := skydb.RecordSchema{}
+		for key, value := range schema {
+			if key != columnName {
+				remaining[key] = value
+			}
+		}
+		s.schemas[recordType] = remaining
+
+		s.updateRecords(recordType, func(record *skydb.Record) {
+			delete(record.Data, columnName)
+		})
+
+		// Indexes on the deleted field are deleted with the field.
+		for name, ri := range s.indexes {
+			if ri.recordType == recordType && indexHasField(ri.index, columnName) {
+				delete(s.indexes, name)
+			}
+		}
+		return nil
+	})
+}
+
+// updateRecords calls modify with a copy of each record of the record
+// type, and replaces the record with the modified copy.
+func (s *state) updateRecords(recordType string, modify func(record *skydb.Record)) {
+	records := s.records[recordType]
+	for key, record := range records {
+		modified := copyRecord(record)
+		modify(&modified)
+		records[key] = modified
+	}
+}
+
+func (db *database) GetSchema(recordType string) (skydb.RecordSchema, error) {
+	return db.RemoteColumnTypes(recordType)
+}
+
+func (db *database) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
+	recordTypes := []string{}
+	err := db.c.view(func(s *state) error {
+		for recordType := range s.schemas {
+			recordTypes = append(recordTypes, recordType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]skydb.RecordSchema{}
+	for _, recordType := range recordTypes {
+		schema, err := db.GetSchema(recordType)
+		if err != nil {
+			return nil, err
+		}
+
+		result[recordType] = schema
+	}
+	log.Debugf("GetRecordSchemas Success")
+
+	return result, nil
+}
+
+// RemoteColumnTypes returns the schema of the record type, including the
+// reserved fields. It returns nil if the record type does not exist.
+func (db *database) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	var typemap skydb.RecordSchema
+	err := db.c.view(func(s *state) error {
+		typemap = s.recordSchema(recordType)
+		return nil
+	})
+	return typemap, err
+}
+
+// recordSchema returns a copy of the schema of the record type, including
+// the reserved fields. It returns nil if the record type does not exist.
+func (s *state) recordSchema(recordType string) skydb.RecordSchema {
+	schema, ok := s.schemas[recordType]
+	if !ok {
+		return nil
+	}
+
+	typemap := skydb.RecordSchema{}
+	for key, fieldType := range reservedSchema {
+		typemap[key] = fieldType
+	}
+	for key, fieldType := range schema {
+		typemap[key] = fieldType
+	}
+	return typemap
+}
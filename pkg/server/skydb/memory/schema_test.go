@@ -0,0 +1,311 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtend(t *testing.T) {
+	Convey("Extend", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+
+		Convey("creates table and reports field types", func() {
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+				"tags":    skydb.FieldType{Type: skydb.TypeList, ElementType: skydb.TypeInteger},
+				"image":   skydb.FieldType{Type: skydb.TypeAsset},
+				"parent": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      skydb.CascadeAction,
+				},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"], ShouldResemble, skydb.FieldType{Type: skydb.TypeString})
+			So(schema["tags"], ShouldResemble, skydb.FieldType{Type: skydb.TypeList, ElementType: skydb.TypeInteger})
+			So(schema["image"], ShouldResemble, skydb.FieldType{Type: skydb.TypeAsset})
+			So(schema["parent"], ShouldResemble, skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "note",
+				OnDelete:      skydb.CascadeAction,
+			})
+			So(schema["_created_at"], ShouldResemble, skydb.FieldType{Type: skydb.TypeDateTime})
+
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			So(schemas, ShouldContainKey, "note")
+			So(schemas, ShouldContainKey, "user")
+			So(schemas, ShouldNotContainKey, "_auth")
+		})
+
+		Convey("does not extend with compatible schema", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			c.canMigrate = false
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeFalse)
+
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+		})
+
+		Convey("rejects conflicting field type", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeNumber},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+		})
+
+		Convey("rejects reference to non-existent record type", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"author": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "author"},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+		})
+
+		Convey("rejects change of referential action", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"parent": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "note"},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"parent": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "note",
+					OnDelete:      skydb.SetNullAction,
+				},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
+		Convey("saves and removes validation and default", func() {
+			min := 1.0
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"rating": skydb.FieldType{
+					Type:       skydb.TypeNumber,
+					Validation: &skydb.FieldValidation{Min: &min},
+					Default:    &skydb.FieldDefault{Type: skydb.LiteralDefault, Value: 3.0},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["rating"].Validation, ShouldResemble, &skydb.FieldValidation{Min: &min})
+			So(schema["rating"].Default, ShouldResemble, &skydb.FieldDefault{Type: skydb.LiteralDefault, Value: 3.0})
+
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"rating": skydb.FieldType{
+					Type:       skydb.TypeNumber,
+					Validation: &skydb.FieldValidation{},
+					Default:    &skydb.FieldDefault{},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err = db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["rating"], ShouldResemble, skydb.FieldType{Type: skydb.TypeNumber})
+		})
+	})
+}
+
+func TestRenameAndDeleteSchema(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		min := 1
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{
+				Type:       skydb.TypeString,
+				Validation: &skydb.FieldValidation{MinLength: &min},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "user0",
+			Data:    skydb.Data{"title": "Hello"},
+		}), ShouldBeNil)
+
+		Convey("renames field with its data and metadata", func() {
+			So(db.RenameSchema("note", "title", "name"), ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema, ShouldNotContainKey, "title")
+			So(schema["name"].Validation, ShouldResemble, &skydb.FieldValidation{MinLength: &min})
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data, ShouldResemble, skydb.Data{"name": "Hello"})
+		})
+
+		Convey("deletes field with its metadata", func() {
+			So(db.DeleteSchema("note", "title"), ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema, ShouldNotContainKey, "title")
+
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeNumber},
+			})
+			So(err, ShouldBeNil)
+			schema, err = db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["title"], ShouldResemble, skydb.FieldType{Type: skydb.TypeNumber})
+		})
+	})
+}
+
+func TestIndex(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"category": skydb.FieldType{Type: skydb.TypeString},
+			"order":    skydb.FieldType{Type: skydb.TypeNumber},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("creates composite index", func() {
+			err := db.SaveIndex("note", "note_category_order", skydb.Index{
+				Fields: []string{"category", "order"},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldContainKey, "note_category_order")
+			index := indexes["note_category_order"]
+			So(index.Fields, ShouldResemble, []string{"category", "order"})
+			So(index.Unique, ShouldBeFalse)
+			So(index.Status, ShouldEqual, skydb.IndexReady)
+
+		})
+
+		Convey("creates partial expression index", func() {
+			err := db.SaveIndex("note", "note_lower_title", skydb.Index{
+				Expressions: []skydb.IndexExpression{
+					{Function: skydb.IndexLowerFunction, Field: "title"},
+				},
+				Unique: true,
+				Predicate: skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "category"},
+						skydb.Expression{Type: skydb.Literal, Value: "it's"},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			index := indexes["note_lower_title"]
+			So(index.Fields, ShouldBeEmpty)
+			So(index.Expressions, ShouldResemble, []skydb.IndexExpression{
+				{Function: skydb.IndexLowerFunction, Field: "title"},
+			})
+			So(index.Unique, ShouldBeTrue)
+			So(index.Definition, ShouldContainSubstring, "WHERE")
+
+			So(db.DeleteIndex("note", "note_lower_title"), ShouldBeNil)
+			indexes, err = db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldNotContainKey, "note_lower_title")
+		})
+
+		Convey("rejects unique index over duplicated values", func() {
+			for _, key := range []string{"1", "2"} {
+				So(db.Save(&skydb.Record{
+					ID:      skydb.NewRecordID("note", key),
+					OwnerID: "user0",
+					Data:    skydb.Data{"title": "Hello"},
+				}), ShouldBeNil)
+			}
+
+			err := db.SaveIndex("note", "note_title", skydb.Index{
+				Fields: []string{"title"},
+				Unique: true,
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+
+		Convey("rejects record violating unique index", func() {
+			So(db.SaveIndex("note", "note_title", skydb.Index{
+				Fields: []string{"title"},
+				Unique: true,
+			}), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"title": "Hello"},
+			}), ShouldBeNil)
+
+			err := db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", "2"),
+				OwnerID: "user0",
+				Data:    skydb.Data{"title": "Hello"},
+			})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+
+		Convey("rejects duplicated index", func() {
+			index := skydb.Index{Fields: []string{"title"}}
+			So(db.SaveIndex("note", "note_title", index), ShouldBeNil)
+			err := db.SaveIndex("note", "note_title", index)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+
+		Convey("returns error when deleting non-existent index", func() {
+			err := db.DeleteIndex("note", "note_not_exist")
+			So(err, ShouldEqual, skydb.ErrIndexNotFound)
+		})
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// store holds the data shared by connections opened with the same option.
+type store struct {
+	mutex sync.RWMutex
+	state *state
+}
+
+// state is the data in a store.
+//
+// Values in the maps of a state are never modified in place, they are
+// replaced when changed. Therefore copying the maps makes a snapshot of
+// the state.
+type state struct {
+	auths               map[string]skydb.AuthInfo
+	roles               map[string]role
+	recordCreationRoles map[string][]string
+	recordDefaultAccess map[string]skydb.RecordACL
+	fieldACL            skydb.FieldACLEntryList
+	assets              map[string]skydb.Asset
+	relations           map[relation]struct{}
+	devices             map[string]skydb.Device
+	schemas             map[string]skydb.RecordSchema
+	indexes             map[string]recordIndex
+	records             map[string]map[recordKey]skydb.Record
+	subscriptions       map[subscriptionKey]skydb.Subscription
+}
+
+type role struct {
+	isAdmin   bool
+	byDefault bool
+}
+
+// relation is a directed relation between two users, such as the left
+// user follows the right user.
+type relation struct {
+	name  string
+	left  string
+	right string
+}
+
+// recordIndex is an index on a record type. Index names are unique among
+// all record types.
+type recordIndex struct {
+	recordType string
+	index      skydb.Index
+}
+
+// recordKey identifies a record among the records of a record type.
+type recordKey struct {
+	databaseID string
+	key        string
+}
+
+type subscriptionKey struct {
+	databaseID string
+	deviceID   string
+	id         string
+}
+
+func newState() *state {
+	return &state{
+		auths:               map[string]skydb.AuthInfo{},
+		roles:               map[string]role{},
+		recordCreationRoles: map[string][]string{},
+		recordDefaultAccess: map[string]skydb.RecordACL{},
+		assets:              map[string]skydb.Asset{},
+		relations:           map[relation]struct{}{},
+		devices:             map[string]skydb.Device{},
+		schemas:             map[string]skydb.RecordSchema{},
+		indexes:             map[string]recordIndex{},
+		records:             map[string]map[recordKey]skydb.Record{},
+		subscriptions:       map[subscriptionKey]skydb.Subscription{},
+	}
+}
+
+const adminRoleDefaultName = "Admin"
+
+// initialState returns the state of a new store, which has the user
+// record type and the admin role like a new database of the other
+// drivers.
+func initialState() *state {
+	s := newState()
+	s.roles[adminRoleDefaultName] = role{isAdmin: true}
+	s.schemas["user"] = skydb.RecordSchema{
+		"username":      skydb.FieldType{Type: skydb.TypeString},
+		"email":         skydb.FieldType{Type: skydb.TypeString},
+		"last_login_at": skydb.FieldType{Type: skydb.TypeDateTime},
+	}
+	s.records["user"] = map[recordKey]skydb.Record{}
+	for _, field := range []string{"username", "email"} {
+		index := skydb.Index{
+			Fields: []string{field},
+			Unique: true,
+			Status: skydb.IndexReady,
+		}
+		index.Definition = indexDefinition(index)
+		s.indexes[managedIndexName("user", index)] = recordIndex{"user", index}
+	}
+	return s
+}
+
+// clone returns a snapshot of the state.
+func (s *state) clone() *state {
+	c := newState()
+	for k, v := range s.auths {
+		c.auths[k] = v
+	}
+	for k, v := range s.roles {
+		c.roles[k] = v
+	}
+	for k, v := range s.recordCreationRoles {
+		c.recordCreationRoles[k] = v
+	}
+	for k, v := range s.recordDefaultAccess {
+		c.recordDefaultAccess[k] = v
+	}
+	c.fieldACL = s.fieldACL
+	for k, v := range s.assets {
+		c.assets[k] = v
+	}
+	for k, v := range s.relations {
+		c.relations[k] = v
+	}
+	for k, v := range s.devices {
+		c.devices[k] = v
+	}
+	for k, v := range s.schemas {
+		c.schemas[k] = v
+	}
+	for k, v := range s.indexes {
+		c.indexes[k] = v
+	}
+	for recordType, records := range s.records {
+		copied := make(map[recordKey]skydb.Record, len(records))
+		for k, v := range records {
+			copied[k] = v
+		}
+		c.records[recordType] = copied
+	}
+	for k, v := range s.subscriptions {
+		c.subscriptions[k] = v
+	}
+	return c
+}
+
+// copyValue returns a deep copy of a field value, so that the value kept
+// in a state is not modified by the caller.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			values[i] = copyValue(element)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			values[key] = copyValue(element)
+		}
+		return values
+	case skydb.Geometry:
+		return skydb.Geometry(copyValue(map[string]interface{}(v)).(map[string]interface{}))
+	case skydb.RecordACL:
+		return copyACL(v)
+	case *skydb.Asset:
+		asset := *v
+		return &asset
+	default:
+		return value
+	}
+}
+
+func copyACL(acl skydb.RecordACL) skydb.RecordACL {
+	if acl == nil {
+		return nil
+	}
+	return append(skydb.RecordACL{}, acl...)
+}
+
+// copyRecord returns a deep copy of a record.
+func copyRecord(record skydb.Record) skydb.Record {
+	copied := record
+	copied.ACL = copyACL(record.ACL)
+	copied.Data = skydb.Data{}
+	for key, value := range record.Data {
+		copied.Data[key] = copyValue(value)
+	}
+	copied.Transient = nil
+	return copied
+}
+
+// copyAuthInfo returns a deep copy of an AuthInfo.
+func copyAuthInfo(authinfo skydb.AuthInfo) skydb.AuthInfo {
+	copied := authinfo
+	if authinfo.HashedPassword != nil {
+		copied.HashedPassword = append([]byte{}, authinfo.HashedPassword...)
+	}
+	if authinfo.Roles != nil {
+		copied.Roles = append([]string{}, authinfo.Roles...)
+	}
+	copied.ProviderInfo = skydb.ProviderInfo{}
+	for principalID, info := range authinfo.ProviderInfo {
+		copied.ProviderInfo[principalID] = copyValue(info).(map[string]interface{})
+	}
+	if authinfo.TokenValidSince != nil {
+		t := *authinfo.TokenValidSince
+		copied.TokenValidSince = &t
+	}
+	if authinfo.LastSeenAt != nil {
+		t := *authinfo.LastSeenAt
+		copied.LastSeenAt = &t
+	}
+	return copied
+}
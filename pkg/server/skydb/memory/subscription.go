@@ -0,0 +1,142 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (db *database) GetSubscription(key string, deviceID string, subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	return db.c.view(func(s *state) error {
+		stored, ok := s.subscriptions[subscriptionKey{db.userID, deviceID, key}]
+		if !ok {
+			return skydb.ErrSubscriptionNotFound
+		}
+		*subscription = stored
+		return nil
+	})
+}
+
+func (db *database) SaveSubscription(subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+	if subscription.ID == "" {
+		return errors.New("empty id")
+	}
+	if subscription.Type == "" {
+		return errors.New("empty type")
+	}
+	if subscription.Query.Type == "" {
+		return errors.New("empty query type")
+	}
+	if subscription.DeviceID == "" {
+		return errors.New("empty device id")
+	}
+
+	stored := *subscription
+	if subscription.NotificationInfo != nil {
+		info := *subscription.NotificationInfo
+		stored.NotificationInfo = &info
+	}
+	return db.c.update(func(s *state) error {
+		if _, ok := s.devices[stored.DeviceID]; !ok {
+			return skydb.ErrDeviceNotFound
+		}
+		s.subscriptions[subscriptionKey{db.userID, stored.DeviceID, stored.ID}] = stored
+		return nil
+	})
+}
+
+func (db *database) DeleteSubscription(key string, deviceID string) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	return db.c.update(func(s *state) error {
+		k := subscriptionKey{db.userID, deviceID, key}
+		if _, ok := s.subscriptions[k]; !ok {
+			return skydb.ErrSubscriptionNotFound
+		}
+		delete(s.subscriptions, k)
+		return nil
+	})
+}
+
+// querySubscriptions returns the subscriptions of the database matching
+// the condition, sorted by ID.
+func (db *database) querySubscriptions(match func(s *state, subscription skydb.Subscription) bool) []skydb.Subscription {
+	subscriptions := []skydb.Subscription{}
+	db.c.view(func(s *state) error {
+		for k, subscription := range s.subscriptions {
+			if k.databaseID == db.userID && match(s, subscription) {
+				subscriptions = append(subscriptions, subscription)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+func (db *database) GetSubscriptionsByDeviceID(deviceID string) []skydb.Subscription {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		log.WithFields(logrus.Fields{
+			"auth_id":  db.userID,
+			"deviceID": deviceID,
+		}).Errorln("GetSubscriptionsByDeviceID on union database is not implemented")
+		return nil
+	}
+
+	return db.querySubscriptions(func(s *state, subscription skydb.Subscription) bool {
+		return subscription.DeviceID == deviceID
+	})
+}
+
+func (db *database) GetMatchingSubscriptions(record *skydb.Record) []skydb.Subscription {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		log.WithFields(logrus.Fields{
+			"auth_id": db.userID,
+		}).Errorln("GetMatchingSubscriptions on union database is not implemented")
+		return nil
+	}
+
+	return db.querySubscriptions(func(s *state, subscription skydb.Subscription) bool {
+		if subscription.Query.Type != record.ID.Type {
+			return false
+		}
+
+		matched, err := newEvaluator(s, record.ID.Type).match(subscription.Query.Predicate, record)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"subscription": subscription.ID,
+				"err":          err,
+			}).Errorln("failed to match subscription")
+			return false
+		}
+		return matched
+	})
+}
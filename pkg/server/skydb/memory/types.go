@@ -0,0 +1,242 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// fieldValue converts the value of a record field to the value kept for
+// the field type, like a database converts a value to the type of the
+// column it is saved to.
+func fieldValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeNumber:
+		if f, ok := numberValue(value); ok {
+			return f, nil
+		}
+	case skydb.TypeInteger, skydb.TypeSequence:
+		if f, ok := numberValue(value); ok {
+			return int64(f), nil
+		}
+	case skydb.TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case skydb.TypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case skydb.TypeDateTime:
+		if t, ok := value.(time.Time); ok {
+			return t.UTC(), nil
+		}
+	case skydb.TypeReference:
+		switch v := value.(type) {
+		case skydb.Reference:
+			return skydb.NewReference(fieldType.ReferenceType, v.ID.Key), nil
+		case string:
+			return skydb.NewReference(fieldType.ReferenceType, v), nil
+		}
+	case skydb.TypeAsset:
+		switch v := value.(type) {
+		case *skydb.Asset:
+			return &skydb.Asset{Name: v.Name}, nil
+		case string:
+			return &skydb.Asset{Name: v}, nil
+		}
+	case skydb.TypeLocation:
+		switch v := value.(type) {
+		case skydb.Location:
+			return v, nil
+		case *skydb.Location:
+			return *v, nil
+		}
+	case skydb.TypeGeometry:
+		switch v := value.(type) {
+		case skydb.Location:
+			return v.Geometry(), nil
+		case skydb.Geometry:
+			return copyValue(v), nil
+		case map[string]interface{}:
+			return skydb.Geometry(copyValue(v).(map[string]interface{})), nil
+		}
+	case skydb.TypeACL:
+		if acl, ok := value.(skydb.RecordACL); ok {
+			return copyACL(acl), nil
+		}
+	case skydb.TypeJSON:
+		return jsonValue(value)
+	case skydb.TypeList:
+		return listValue(fieldType.ElementType, value)
+	default:
+		return copyValue(value), nil
+	}
+
+	return nil, fmt.Errorf("unexpected value of type %T for field of type %v", value, fieldType.Type)
+}
+
+// jsonValue returns the value decoded from its JSON text, as a value of
+// a JSON column is read.
+func jsonValue(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func listValue(elementType skydb.DataType, value interface{}) (interface{}, error) {
+	v, err := jsonValue(value)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of type %T for list", value)
+	}
+
+	for i, element := range list {
+		switch v := element.(type) {
+		case float64:
+			if elementType == skydb.TypeInteger {
+				list[i] = int64(v)
+			}
+		case string:
+			if elementType == skydb.TypeDateTime {
+				t, err := time.Parse(time.RFC3339Nano, v)
+				if err != nil {
+					return nil, fmt.Errorf("malformed datetime %s", v)
+				}
+				list[i] = t.UTC()
+			}
+		}
+	}
+	return list, nil
+}
+
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// compareValues compares two non-null values. It returns false if the
+// values cannot be ordered, such as values of different types.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if af, ok := numberValue(a); ok {
+		bf, ok := numberValue(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch av := a.(type) {
+	case string:
+		if bs, ok := keyString(b); ok {
+			return strings.Compare(av, bs), true
+		}
+	case skydb.Reference:
+		if bs, ok := keyString(b); ok {
+			return strings.Compare(av.ID.Key, bs), true
+		}
+	case *skydb.Asset:
+		if bs, ok := keyString(b); ok {
+			return strings.Compare(av.Name, bs), true
+		}
+	case time.Time:
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bt):
+				return -1, true
+			case av.After(bt):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if bb, ok := b.(bool); ok {
+			switch {
+			case av == bb:
+				return 0, true
+			case bb:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// keyString returns the string of a value compared as a string. A
+// reference is compared by the key of the referenced record, and an
+// asset is compared by its name.
+func keyString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case skydb.Reference:
+		return v.ID.Key, true
+	case *skydb.Asset:
+		return v.Name, true
+	}
+	return "", false
+}
+
+// equalValues returns whether two non-null values are equal.
+func equalValues(a interface{}, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+
+	// Values which cannot be ordered, such as lists and JSON objects,
+	// are compared by their JSON text.
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aJSON) == string(bJSON)
+}
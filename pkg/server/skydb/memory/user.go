@@ -0,0 +1,189 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) CreateAuth(authinfo *skydb.AuthInfo) error {
+	stored := copyAuthInfo(*authinfo)
+	stored.Roles = sortedRoles(stored.Roles)
+	return c.update(func(s *state) error {
+		if _, ok := s.auths[stored.ID]; ok {
+			return skydb.ErrUserDuplicated
+		}
+
+		s.ensureRoles(stored.Roles)
+		s.auths[stored.ID] = stored
+		return nil
+	})
+}
+
+func (c *conn) UpdateAuth(authinfo *skydb.AuthInfo) error {
+	stored := copyAuthInfo(*authinfo)
+	stored.Roles = sortedRoles(stored.Roles)
+	return c.update(func(s *state) error {
+		if _, ok := s.auths[stored.ID]; !ok {
+			return skydb.ErrUserNotFound
+		}
+
+		s.ensureRoles(stored.Roles)
+		s.auths[stored.ID] = stored
+		return nil
+	})
+}
+
+func (c *conn) GetAuth(id string, authinfo *skydb.AuthInfo) error {
+	return c.view(func(s *state) error {
+		stored, ok := s.auths[id]
+		if !ok {
+			return skydb.ErrUserNotFound
+		}
+		*authinfo = copyAuthInfo(stored)
+		return nil
+	})
+}
+
+func (c *conn) GetAuthByPrincipalID(principalID string, authinfo *skydb.AuthInfo) error {
+	return c.view(func(s *state) error {
+		for _, stored := range s.auths {
+			if _, ok := stored.ProviderInfo[principalID]; ok {
+				*authinfo = copyAuthInfo(stored)
+				return nil
+			}
+		}
+		return skydb.ErrUserNotFound
+	})
+}
+
+func (c *conn) DeleteAuth(id string) error {
+	return c.update(func(s *state) error {
+		if _, ok := s.auths[id]; !ok {
+			return skydb.ErrUserNotFound
+		}
+
+		delete(s.auths, id)
+		for r := range s.relations {
+			if r.left == id || r.right == id {
+				delete(s.relations, r)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *conn) EnsureAuthRecordKeysExist(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+	schema, err := db.GetSchema(userRecordType)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve user record schema")
+	}
+
+	schemaToExtend := skydb.RecordSchema{}
+	for _, keys := range authRecordKeys {
+		for _, key := range keys {
+			if _, ok := schema[key]; ok {
+				continue
+			}
+
+			schemaToExtend[key] = skydb.FieldType{
+				Type: skydb.TypeString,
+			}
+		}
+	}
+
+	if _, err := db.Extend(userRecordType, schemaToExtend); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+
+	allIndexesByName, err := db.GetIndexesByRecordType(userRecordType)
+	if err != nil {
+		return err
+	}
+
+	// Only unique indexes on all values of fields make the fields
+	// unique.
+	indexesByFields := map[string]string{}
+	for indexName, index := range allIndexesByName {
+		if isFieldsUniqueIndex(index) {
+			indexesByFields[joinFields(index.Fields)] = indexName
+		}
+	}
+
+	requiredIndexesByFields := map[string]skydb.Index{}
+	for _, keys := range authRecordKeys {
+		requiredIndexesByFields[joinFields(keys)] = skydb.Index{
+			Fields: keys,
+			Unique: true,
+		}
+	}
+
+	for fieldsString, index := range requiredIndexesByFields {
+		if _, ok := indexesByFields[fieldsString]; ok {
+			continue
+		}
+
+		if !c.canMigrate {
+			return fmt.Errorf("Index of %v is required in user record schema", index.Fields)
+		}
+
+		if err := db.SaveIndex(userRecordType, managedIndexName(userRecordType, index), index); err != nil {
+			return err
+		}
+	}
+
+	// cleanup unused unique index
+	if c.canMigrate {
+		for fieldsString, indexName := range indexesByFields {
+			_, isRequired := requiredIndexesByFields[fieldsString]
+			if !isRequired && indexName == managedIndexName(userRecordType, allIndexesByName[indexName]) {
+				db.DeleteIndex(userRecordType, indexName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isFieldsUniqueIndex(index skydb.Index) bool {
+	return index.Unique &&
+		len(index.Expressions) == 0 &&
+		index.Predicate.IsEmpty()
+}
+
+func joinFields(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func managedIndexName(recordType string, index skydb.Index) string {
+	fields := append([]string{}, index.Fields...)
+	sort.Strings(fields)
+	return fmt.Sprintf("auth_record_keys_%s_%s_key", recordType, strings.Join(fields, "_"))
+}
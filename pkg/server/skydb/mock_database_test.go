@@ -5,6 +5,7 @@ package skydb
 
 import (
 	gomock "github.com/golang/mock/gomock"
+	time "time"
 )
 
 // Mock of Database interface
@@ -666,6 +667,428 @@ func (_mr *_MockTxDatabaseRecorder) DeleteIndex(arg0, arg1 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteIndex", arg0, arg1)
 }
 
+// Mock of AggregateDatabase interface
+type MockAggregateDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockAggregateDatabaseRecorder
+}
+
+// Recorder for MockAggregateDatabase (not exported)
+type _MockAggregateDatabaseRecorder struct {
+	mock *MockAggregateDatabase
+}
+
+func NewMockAggregateDatabase(ctrl *gomock.Controller) *MockAggregateDatabase {
+	mock := &MockAggregateDatabase{ctrl: ctrl}
+	mock.recorder = &_MockAggregateDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockAggregateDatabase) EXPECT() *_MockAggregateDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockAggregateDatabase) QueryAggregation(query *Query) ([]map[string]interface{}, error) {
+	ret := _m.ctrl.Call(_m, "QueryAggregation", query)
+	ret0, _ := ret[0].([]map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAggregateDatabaseRecorder) QueryAggregation(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QueryAggregation", arg0)
+}
+
+// Mock of SearchIndexDatabase interface
+type MockSearchIndexDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockSearchIndexDatabaseRecorder
+}
+
+// Recorder for MockSearchIndexDatabase (not exported)
+type _MockSearchIndexDatabaseRecorder struct {
+	mock *MockSearchIndexDatabase
+}
+
+func NewMockSearchIndexDatabase(ctrl *gomock.Controller) *MockSearchIndexDatabase {
+	mock := &MockSearchIndexDatabase{ctrl: ctrl}
+	mock.recorder = &_MockSearchIndexDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockSearchIndexDatabase) EXPECT() *_MockSearchIndexDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockSearchIndexDatabase) SaveSearchIndex(recordType string, field string, language string) (string, error) {
+	ret := _m.ctrl.Call(_m, "SaveSearchIndex", recordType, field, language)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSearchIndexDatabaseRecorder) SaveSearchIndex(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveSearchIndex", arg0, arg1, arg2)
+}
+
+// Mock of ConditionalSaveDatabase interface
+type MockConditionalSaveDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockConditionalSaveDatabaseRecorder
+}
+
+// Recorder for MockConditionalSaveDatabase (not exported)
+type _MockConditionalSaveDatabaseRecorder struct {
+	mock *MockConditionalSaveDatabase
+}
+
+func NewMockConditionalSaveDatabase(ctrl *gomock.Controller) *MockConditionalSaveDatabase {
+	mock := &MockConditionalSaveDatabase{ctrl: ctrl}
+	mock.recorder = &_MockConditionalSaveDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockConditionalSaveDatabase) EXPECT() *_MockConditionalSaveDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockConditionalSaveDatabase) SaveIfUnchanged(record *Record, updatedAt time.Time) error {
+	ret := _m.ctrl.Call(_m, "SaveIfUnchanged", record, updatedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockConditionalSaveDatabaseRecorder) SaveIfUnchanged(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveIfUnchanged", arg0, arg1)
+}
+
+// Mock of SoftDeleteDatabase interface
+type MockSoftDeleteDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockSoftDeleteDatabaseRecorder
+}
+
+// Recorder for MockSoftDeleteDatabase (not exported)
+type _MockSoftDeleteDatabaseRecorder struct {
+	mock *MockSoftDeleteDatabase
+}
+
+func NewMockSoftDeleteDatabase(ctrl *gomock.Controller) *MockSoftDeleteDatabase {
+	mock := &MockSoftDeleteDatabase{ctrl: ctrl}
+	mock.recorder = &_MockSoftDeleteDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockSoftDeleteDatabase) EXPECT() *_MockSoftDeleteDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockSoftDeleteDatabase) SoftDeleteEnabled(recordType string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "SoftDeleteEnabled", recordType)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) SoftDeleteEnabled(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SoftDeleteEnabled", arg0)
+}
+
+func (_m *MockSoftDeleteDatabase) SetSoftDeleteEnabled(recordType string, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetSoftDeleteEnabled", recordType, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) SetSoftDeleteEnabled(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetSoftDeleteEnabled", arg0, arg1)
+}
+
+func (_m *MockSoftDeleteDatabase) GetTrashed(id RecordID, record *Record) error {
+	ret := _m.ctrl.Call(_m, "GetTrashed", id, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) GetTrashed(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetTrashed", arg0, arg1)
+}
+
+func (_m *MockSoftDeleteDatabase) QueryTrash(query *Query) (*Rows, error) {
+	ret := _m.ctrl.Call(_m, "QueryTrash", query)
+	ret0, _ := ret[0].(*Rows)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) QueryTrash(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QueryTrash", arg0)
+}
+
+func (_m *MockSoftDeleteDatabase) Restore(id RecordID) error {
+	ret := _m.ctrl.Call(_m, "Restore", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) Restore(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Restore", arg0)
+}
+
+func (_m *MockSoftDeleteDatabase) Purge(id RecordID) error {
+	ret := _m.ctrl.Call(_m, "Purge", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) Purge(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Purge", arg0)
+}
+
+func (_m *MockSoftDeleteDatabase) PurgeTrash(recordType string, before time.Time) (int64, error) {
+	ret := _m.ctrl.Call(_m, "PurgeTrash", recordType, before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSoftDeleteDatabaseRecorder) PurgeTrash(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PurgeTrash", arg0, arg1)
+}
+
+// Mock of RecordHistoryDatabase interface
+type MockRecordHistoryDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockRecordHistoryDatabaseRecorder
+}
+
+// Recorder for MockRecordHistoryDatabase (not exported)
+type _MockRecordHistoryDatabaseRecorder struct {
+	mock *MockRecordHistoryDatabase
+}
+
+func NewMockRecordHistoryDatabase(ctrl *gomock.Controller) *MockRecordHistoryDatabase {
+	mock := &MockRecordHistoryDatabase{ctrl: ctrl}
+	mock.recorder = &_MockRecordHistoryDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockRecordHistoryDatabase) EXPECT() *_MockRecordHistoryDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockRecordHistoryDatabase) RecordHistoryEnabled(recordType string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "RecordHistoryEnabled", recordType)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) RecordHistoryEnabled(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RecordHistoryEnabled", arg0)
+}
+
+func (_m *MockRecordHistoryDatabase) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordHistoryEnabled", recordType, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) SetRecordHistoryEnabled(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRecordHistoryEnabled", arg0, arg1)
+}
+
+func (_m *MockRecordHistoryDatabase) SaveRecordRevision(revision *RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "SaveRecordRevision", revision)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) SaveRecordRevision(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveRecordRevision", arg0)
+}
+
+func (_m *MockRecordHistoryDatabase) HasRecordRevisions(id RecordID) (bool, error) {
+	ret := _m.ctrl.Call(_m, "HasRecordRevisions", id)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) HasRecordRevisions(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "HasRecordRevisions", arg0)
+}
+
+func (_m *MockRecordHistoryDatabase) GetRecordRevisions(id RecordID) ([]RecordRevision, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordRevisions", id)
+	ret0, _ := ret[0].([]RecordRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) GetRecordRevisions(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordRevisions", arg0)
+}
+
+func (_m *MockRecordHistoryDatabase) GetRecordRevision(id RecordID, revisionID int64, revision *RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "GetRecordRevision", id, revisionID, revision)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockRecordHistoryDatabaseRecorder) GetRecordRevision(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordRevision", arg0, arg1, arg2)
+}
+
+// Mock of ChangeFeedDatabase interface
+type MockChangeFeedDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockChangeFeedDatabaseRecorder
+}
+
+// Recorder for MockChangeFeedDatabase (not exported)
+type _MockChangeFeedDatabaseRecorder struct {
+	mock *MockChangeFeedDatabase
+}
+
+func NewMockChangeFeedDatabase(ctrl *gomock.Controller) *MockChangeFeedDatabase {
+	mock := &MockChangeFeedDatabase{ctrl: ctrl}
+	mock.recorder = &_MockChangeFeedDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockChangeFeedDatabase) EXPECT() *_MockChangeFeedDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockChangeFeedDatabase) GetRecordChanges(query *Query, after RecordChangeCursor) ([]RecordChange, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordChanges", query, after)
+	ret0, _ := ret[0].([]RecordChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockChangeFeedDatabaseRecorder) GetRecordChanges(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordChanges", arg0, arg1)
+}
+
+// Mock of RecordExpiryDatabase interface
+type MockRecordExpiryDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockRecordExpiryDatabaseRecorder
+}
+
+// Recorder for MockRecordExpiryDatabase (not exported)
+type _MockRecordExpiryDatabaseRecorder struct {
+	mock *MockRecordExpiryDatabase
+}
+
+func NewMockRecordExpiryDatabase(ctrl *gomock.Controller) *MockRecordExpiryDatabase {
+	mock := &MockRecordExpiryDatabase{ctrl: ctrl}
+	mock.recorder = &_MockRecordExpiryDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockRecordExpiryDatabase) EXPECT() *_MockRecordExpiryDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockRecordExpiryDatabase) GetRecordExpiry(recordType string) (RecordExpiry, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordExpiry", recordType)
+	ret0, _ := ret[0].(RecordExpiry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordExpiryDatabaseRecorder) GetRecordExpiry(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordExpiry", arg0)
+}
+
+func (_m *MockRecordExpiryDatabase) SetRecordExpiry(recordType string, expiry RecordExpiry) error {
+	ret := _m.ctrl.Call(_m, "SetRecordExpiry", recordType, expiry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockRecordExpiryDatabaseRecorder) SetRecordExpiry(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRecordExpiry", arg0, arg1)
+}
+
+func (_m *MockRecordExpiryDatabase) GetRecordExpiries() (map[string]RecordExpiry, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordExpiries")
+	ret0, _ := ret[0].(map[string]RecordExpiry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordExpiryDatabaseRecorder) GetRecordExpiries() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRecordExpiries")
+}
+
+func (_m *MockRecordExpiryDatabase) QueryExpired(recordType string, at time.Time, after string, limit uint64) ([]Record, error) {
+	ret := _m.ctrl.Call(_m, "QueryExpired", recordType, at, after, limit)
+	ret0, _ := ret[0].([]Record)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordExpiryDatabaseRecorder) QueryExpired(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "QueryExpired", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockRecordExpiryDatabase) IsExpired(id RecordID) (bool, error) {
+	ret := _m.ctrl.Call(_m, "IsExpired", id)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockRecordExpiryDatabaseRecorder) IsExpired(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsExpired", arg0)
+}
+
+// Mock of SchemaAlterDatabase interface
+type MockSchemaAlterDatabase struct {
+	ctrl     *gomock.Controller
+	recorder *_MockSchemaAlterDatabaseRecorder
+}
+
+// Recorder for MockSchemaAlterDatabase (not exported)
+type _MockSchemaAlterDatabaseRecorder struct {
+	mock *MockSchemaAlterDatabase
+}
+
+func NewMockSchemaAlterDatabase(ctrl *gomock.Controller) *MockSchemaAlterDatabase {
+	mock := &MockSchemaAlterDatabase{ctrl: ctrl}
+	mock.recorder = &_MockSchemaAlterDatabaseRecorder{mock}
+	return mock
+}
+
+func (_m *MockSchemaAlterDatabase) EXPECT() *_MockSchemaAlterDatabaseRecorder {
+	return _m.recorder
+}
+
+func (_m *MockSchemaAlterDatabase) CountAlterFailures(recordType string, field string, fieldType FieldType) (uint64, error) {
+	ret := _m.ctrl.Call(_m, "CountAlterFailures", recordType, field, fieldType)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockSchemaAlterDatabaseRecorder) CountAlterFailures(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CountAlterFailures", arg0, arg1, arg2)
+}
+
+func (_m *MockSchemaAlterDatabase) AlterSchema(recordType string, field string, fieldType FieldType) error {
+	ret := _m.ctrl.Call(_m, "AlterSchema", recordType, field, fieldType)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSchemaAlterDatabaseRecorder) AlterSchema(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AlterSchema", arg0, arg1, arg2)
+}
+
 // Mock of RowsIter interface
 type MockRowsIter struct {
 	ctrl     *gomock.Controller
@@ -0,0 +1,117 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// FieldOperator is an operator of a FieldOperation.
+type FieldOperator string
+
+// List of FieldOperator
+const (
+	// IncrementOperator adds a number to a numeric field.
+	IncrementOperator FieldOperator = "$inc"
+	// AppendOperator appends elements to the end of a list field.
+	AppendOperator FieldOperator = "$append"
+	// RemoveOperator removes all occurrences of elements from a list field.
+	RemoveOperator FieldOperator = "$remove"
+)
+
+// FieldOperation is a field value of a Record which modifies the stored
+// value of the field when the Record is saved, rather than replacing it.
+//
+// A Database should perform the operation atomically, so that concurrent
+// operations on the same field are not lost.
+type FieldOperation struct {
+	Operator FieldOperator
+	Value    interface{}
+}
+
+// Apply returns the result of performing the operation on value. It is
+// used by implementations of Database that cannot perform the operation
+// in the storage.
+func (op FieldOperation) Apply(value interface{}) (interface{}, error) {
+	switch op.Operator {
+	case IncrementOperator:
+		return op.increment(value)
+	case AppendOperator, RemoveOperator:
+		return op.modifyList(value)
+	default:
+		return nil, fmt.Errorf("unknown field operator %s", op.Operator)
+	}
+}
+
+func (op FieldOperation) increment(value interface{}) (interface{}, error) {
+	var delta float64
+	switch v := op.Value.(type) {
+	case float64:
+		delta = v
+	case int64:
+		delta = float64(v)
+	default:
+		return nil, fmt.Errorf("%s expects a number, got %T", op.Operator, op.Value)
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return op.Value, nil
+	case float64:
+		return v + delta, nil
+	case int64:
+		return v + int64(delta), nil
+	default:
+		return nil, fmt.Errorf("cannot apply %s to value of type %T", op.Operator, value)
+	}
+}
+
+func (op FieldOperation) modifyList(value interface{}) (interface{}, error) {
+	elements, ok := op.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s expects a list, got %T", op.Operator, op.Value)
+	}
+
+	var list []interface{}
+	switch v := value.(type) {
+	case nil:
+		list = []interface{}{}
+	case []interface{}:
+		list = v
+	default:
+		return nil, fmt.Errorf("cannot apply %s to value of type %T", op.Operator, value)
+	}
+
+	result := []interface{}{}
+	if op.Operator == AppendOperator {
+		result = append(result, list...)
+		return append(result, elements...), nil
+	}
+
+	for _, element := range list {
+		removed := false
+		for _, e := range elements {
+			if reflect.DeepEqual(element, e) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			result = append(result, element)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldOperation(t *testing.T) {
+	Convey("FieldOperation", t, func() {
+		Convey("increments number", func() {
+			op := FieldOperation{IncrementOperator, float64(2)}
+
+			result, err := op.Apply(float64(1))
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, float64(3))
+
+			result, err = op.Apply(int64(1))
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, int64(3))
+
+			result, err = op.Apply(nil)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, float64(2))
+
+			_, err = op.Apply("1")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("appends to list", func() {
+			op := FieldOperation{AppendOperator, []interface{}{"c"}}
+
+			result, err := op.Apply([]interface{}{"a", "b"})
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []interface{}{"a", "b", "c"})
+
+			result, err = op.Apply(nil)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []interface{}{"c"})
+		})
+
+		Convey("removes from list", func() {
+			op := FieldOperation{RemoveOperator, []interface{}{"a", float64(1)}}
+
+			result, err := op.Apply([]interface{}{"a", "b", float64(1), "a"})
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, []interface{}{"b"})
+		})
+
+		Convey("derives field type from operand", func() {
+			fieldType, err := DeriveFieldType(FieldOperation{IncrementOperator, float64(1)})
+			So(err, ShouldBeNil)
+			So(fieldType, ShouldResemble, FieldType{Type: TypeNumber})
+		})
+	})
+}
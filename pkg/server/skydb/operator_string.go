@@ -4,9 +4,9 @@ package skydb
 
 import "fmt"
 
-const _Operator_name = "AndOrNotEqualGreaterThanLessThanGreaterThanOrEqualLessThanOrEqualNotEqualLikeILikeInFunctional"
+const _Operator_name = "AndOrNotEqualGreaterThanLessThanGreaterThanOrEqualLessThanOrEqualNotEqualLikeILikeInFunctionalContainsAnyContainsAllContainsHasKey"
 
-var _Operator_index = [...]uint8{0, 3, 5, 8, 13, 24, 32, 50, 65, 73, 77, 82, 84, 94}
+var _Operator_index = [...]uint8{0, 3, 5, 8, 13, 24, 32, 50, 65, 73, 77, 82, 84, 94, 105, 116, 124, 130}
 
 func (i Operator) String() string {
 	i -= 1
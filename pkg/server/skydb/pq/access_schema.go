@@ -177,7 +177,9 @@ func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) (err error) {
 			return
 		}
 
+		c.cacheMutex.Lock()
 		c.FieldACL = nil // invalidate cached FieldACL
+		c.cacheMutex.Unlock()
 	}()
 
 	deleteBuilder := psql.
@@ -222,8 +224,11 @@ func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) (err error) {
 }
 
 func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
-	if c.FieldACL != nil {
-		return *c.FieldACL, nil
+	c.cacheMutex.RLock()
+	cached := c.FieldACL
+	c.cacheMutex.RUnlock()
+	if cached != nil {
+		return *cached, nil
 	}
 
 	builder := psql.
@@ -279,6 +284,8 @@ func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
 
 	acl := skydb.NewFieldACL(skydb.FieldACLEntryList(entries))
 
+	c.cacheMutex.Lock()
 	c.FieldACL = &acl
+	c.cacheMutex.Unlock()
 	return acl, nil
 }
@@ -0,0 +1,260 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+var _ skydb.SchemaAlterDatabase = &database{}
+
+// Patterns of strings that can be converted to other types. A string not
+// matching the pattern fails the conversion. A string matching
+// integerPattern also fails if it is out of the range of integerRange.
+const (
+	numberPattern   = `^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$`
+	integerPattern  = `^[-+]?[0-9]+$`
+	datetimePattern = `^[0-9]{4}-[0-9]{2}-[0-9]{2}([ T][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]+)?)?)?(Z|[+-][0-9]{2}(:?[0-9]{2})?)?$`
+	booleanStrings  = `'t', 'true', 'y', 'yes', 'on', '1', 'f', 'false', 'n', 'no', 'off', '0'`
+)
+
+// integerRange is the condition of a value, as in BETWEEN, within the
+// range of the integer column of TypeInteger.
+var integerRange = fmt.Sprintf("%d AND %d", math.MinInt32, math.MaxInt32)
+
+// fieldCast is the conversion of the values of a field when the type of
+// the field is changed.
+type fieldCast struct {
+	// using is the expression converting a value, as in
+	// ALTER TABLE ... USING.
+	using string
+
+	// fails is the condition of a non-null value that cannot be
+	// converted. It is empty if every value can be converted.
+	fails string
+}
+
+// newFieldCast returns the conversion of the value, which is an SQL
+// expression of the field, from one type to another.
+//
+// A string is converted to datetime in the time zone of the session if it
+// has no time zone.
+func (db *database) newFieldCast(value string, from, to skydb.FieldType) (fieldCast, error) {
+	if !from.CastableTo(to) {
+		return fieldCast{}, skyerr.NewErrorf(
+			skyerr.IncompatibleSchema,
+			"cannot change type of field from %s to %s", from.ToSimpleName(), to.ToSimpleName(),
+		)
+	}
+
+	switch to.Type {
+	case skydb.TypeString:
+		switch from.Type {
+		case skydb.TypeReference:
+			return fieldCast{using: value}, nil
+		case skydb.TypeDateTime:
+			return fieldCast{
+				using: fmt.Sprintf(`to_char(%s, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')`, value),
+			}, nil
+		case skydb.TypeJSON:
+			return fieldCast{using: fmt.Sprintf(`%s #>> '{}'`, value)}, nil
+		default:
+			return fieldCast{using: value + "::text"}, nil
+		}
+	case skydb.TypeNumber:
+		switch from.Type {
+		case skydb.TypeString:
+			return fieldCast{
+				using: fmt.Sprintf("trim(%s)::double precision", value),
+				fails: fmt.Sprintf("trim(%s) !~ '%s'", value, numberPattern),
+			}, nil
+		case skydb.TypeBoolean:
+			return fieldCast{using: value + "::integer::double precision"}, nil
+		default:
+			return fieldCast{using: value + "::double precision"}, nil
+		}
+	case skydb.TypeInteger:
+		switch from.Type {
+		case skydb.TypeString:
+			// The range is checked only if the string is an integer,
+			// as CASE evaluates the casting only after the matching.
+			return fieldCast{
+				using: fmt.Sprintf("trim(%s)::integer", value),
+				fails: fmt.Sprintf(
+					"CASE WHEN trim(%[1]s) ~ '%[2]s' THEN trim(%[1]s)::numeric NOT BETWEEN %[3]s ELSE TRUE END",
+					value, integerPattern, integerRange,
+				),
+			}, nil
+		case skydb.TypeBoolean:
+			return fieldCast{using: value + "::integer"}, nil
+		default:
+			// A number with a fractional part would be rounded.
+			return fieldCast{
+				using: value + "::integer",
+				fails: fmt.Sprintf("%[1]s <> trunc(%[1]s) OR %[1]s NOT BETWEEN %[2]s", value, integerRange),
+			}, nil
+		}
+	case skydb.TypeBoolean:
+		if from.Type == skydb.TypeString {
+			return fieldCast{
+				using: fmt.Sprintf("lower(trim(%s))::boolean", value),
+				fails: fmt.Sprintf("lower(trim(%s)) NOT IN (%s)", value, booleanStrings),
+			}, nil
+		}
+		return fieldCast{
+			using: value + " <> 0",
+			fails: value + " NOT IN (0, 1)",
+		}, nil
+	case skydb.TypeDateTime:
+		return fieldCast{
+			using: fmt.Sprintf("trim(%s)::timestamp with time zone AT TIME ZONE 'UTC'", value),
+			fails: fmt.Sprintf("trim(%s) !~ '%s'", value, datetimePattern),
+		}, nil
+	case skydb.TypeReference:
+		return fieldCast{
+			using: value,
+			fails: fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s AS referent WHERE referent._id = %s)",
+				db.TableName(to.ReferenceType), value),
+		}, nil
+	}
+	return fieldCast{}, fmt.Errorf("unexpected conversion from %s to %s", from.ToSimpleName(), to.ToSimpleName())
+}
+
+func (db *database) remoteFieldType(recordType, field string) (skydb.FieldType, error) {
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return skydb.FieldType{}, err
+	}
+	fieldType, ok := typemap[field]
+	if !ok {
+		return skydb.FieldType{}, skyerr.NewErrorf(skyerr.ResourceNotFound,
+			`field "%s" of record type "%s" does not exist`, field, recordType)
+	}
+	return fieldType, nil
+}
+
+// CountAlterFailures returns the number of records whose value of the
+// field cannot be converted to the field type.
+func (db *database) CountAlterFailures(recordType, field string, fieldType skydb.FieldType) (uint64, error) {
+	remoteFieldType, err := db.remoteFieldType(recordType, field)
+	if err != nil {
+		return 0, err
+	}
+	return db.countAlterFailures(recordType, field, remoteFieldType, fieldType)
+}
+
+func (db *database) countAlterFailures(recordType, field string, from, to skydb.FieldType) (uint64, error) {
+	value := "record." + pq.QuoteIdentifier(field)
+	cast, err := db.newFieldCast(value, from, to)
+	if err != nil {
+		return 0, err
+	}
+	if cast.fails == "" {
+		return 0, nil
+	}
+
+	stmt := fmt.Sprintf("SELECT COUNT(*) FROM %s AS record WHERE %s IS NOT NULL AND (%s)",
+		db.TableName(recordType), value, cast.fails)
+	var count uint64
+	if err := db.c.QueryRowx(stmt).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count values failing conversion: %s", err)
+	}
+	return count, nil
+}
+
+// AlterSchema changes the type of the column with ALTER TABLE ... USING,
+// in the transaction of the connection if there is one. The foreign key
+// constraint of a reference field is replaced.
+func (db *database) AlterSchema(recordType, field string, fieldType skydb.FieldType) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	if db.c.tx != nil {
+		return db.alterSchema(recordType, field, fieldType)
+	}
+	return skydb.WithTransaction(db.c, func() error {
+		return db.alterSchema(recordType, field, fieldType)
+	})
+}
+
+func (db *database) alterSchema(recordType, field string, fieldType skydb.FieldType) error {
+	remoteFieldType, err := db.remoteFieldType(recordType, field)
+	if err != nil {
+		return err
+	}
+
+	count, err := db.countAlterFailures(recordType, field, remoteFieldType, fieldType)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return skyerr.NewErrorWithInfo(
+			skyerr.IncompatibleSchema,
+			fmt.Sprintf("%d records cannot be converted to %s", count, fieldType.ToSimpleName()),
+			map[string]interface{}{"failures": count},
+		)
+	}
+
+	column := pq.QuoteIdentifier(field)
+	cast, err := db.newFieldCast(column, remoteFieldType, fieldType)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	if remoteFieldType.Type == skydb.TypeReference {
+		var constraintName string
+		err := db.c.QueryRowx(foreignKeyConstraintQuery, db.schemaName(), recordType, field).Scan(&constraintName)
+		if err != nil {
+			return fmt.Errorf("failed to find foreign key of %s.%s: %s", recordType, field, err)
+		}
+		buf.WriteString("DROP CONSTRAINT ")
+		buf.WriteString(pq.QuoteIdentifier(constraintName))
+		buf.WriteByte(',')
+	}
+	if pqFieldType(remoteFieldType) != pqFieldType(fieldType) {
+		fmt.Fprintf(&buf, "ALTER COLUMN %s TYPE %s USING %s,", column, pqFieldType(fieldType), cast.using)
+	}
+	if fieldType.Type == skydb.TypeReference {
+		db.writeForeignKeyConstraint(&buf, field, fieldType.ReferenceType, "_id")
+	}
+	buf.Truncate(buf.Len() - 1)
+
+	stmt := fmt.Sprintf("ALTER TABLE %s %s", db.TableName(recordType), buf.String())
+	log.WithField("stmt", stmt).Debugln("Changing type of column")
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to alter table: %s", err)
+	}
+
+	if remoteFieldType.Type == skydb.TypeReference || fieldType.Type == skydb.TypeReference {
+		action := skydb.NoReferentialAction
+		if fieldType.Type == skydb.TypeReference {
+			action = fieldType.OnDelete
+		}
+		if err := db.saveReferentialAction(db.c, recordType, field, action); err != nil {
+			return err
+		}
+	}
+
+	db.c.invalidateRecordSchema(recordType, false)
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlterSchema(t *testing.T) {
+	Convey("Database altering schema", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"score": skydb.FieldType{Type: skydb.TypeString},
+			"count": skydb.FieldType{Type: skydb.TypeInteger},
+		})
+		So(err, ShouldBeNil)
+
+		for key, score := range map[string]interface{}{
+			"number":  "2.5",
+			"integer": " 3 ",
+			"text":    "abc",
+			"null":    nil,
+		} {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", key),
+				OwnerID: "userid",
+				Data:    map[string]interface{}{"score": score, "count": 1},
+			}
+			So(db.Save(&record), ShouldBeNil)
+		}
+
+		numberType := skydb.FieldType{Type: skydb.TypeNumber}
+
+		Convey("counts values failing conversion", func() {
+			count, err := db.CountAlterFailures("note", "score", numberType)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			count, err = db.CountAlterFailures("note", "count", numberType)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("does not change type if a value fails conversion", func() {
+			err := db.AlterSchema("note", "score", numberType)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["score"].Type, ShouldEqual, skydb.TypeString)
+		})
+
+		Convey("changes type and converts values", func() {
+			So(db.Delete(skydb.NewRecordID("note", "text")), ShouldBeNil)
+
+			err := db.AlterSchema("note", "score", numberType)
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["score"].Type, ShouldEqual, skydb.TypeNumber)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "integer"), &record), ShouldBeNil)
+			So(record.Data["score"], ShouldEqual, float64(3))
+		})
+
+		Convey("converts strings of integers within the range of integer", func() {
+			So(db.Delete(skydb.NewRecordID("note", "number")), ShouldBeNil)
+			So(db.Delete(skydb.NewRecordID("note", "text")), ShouldBeNil)
+
+			integerType := skydb.FieldType{Type: skydb.TypeInteger}
+			for key, score := range map[string]interface{}{
+				"max":       "2147483647",
+				"min":       "-2147483648",
+				"too-large": "2147483648",
+				"too-small": "-99999999999999999999",
+			} {
+				record := skydb.Record{
+					ID:      skydb.NewRecordID("note", key),
+					OwnerID: "userid",
+					Data:    map[string]interface{}{"score": score},
+				}
+				So(db.Save(&record), ShouldBeNil)
+			}
+
+			count, err := db.CountAlterFailures("note", "score", integerType)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			So(db.Delete(skydb.NewRecordID("note", "too-large")), ShouldBeNil)
+			So(db.Delete(skydb.NewRecordID("note", "too-small")), ShouldBeNil)
+			So(db.AlterSchema("note", "score", integerType), ShouldBeNil)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "max"), &record), ShouldBeNil)
+			So(record.Data["score"], ShouldEqual, int64(2147483647))
+		})
+
+		Convey("changes the referenced record type", func() {
+			for _, recordType := range []string{"category", "tag"} {
+				_, err := db.Extend(recordType, skydb.RecordSchema{
+					"name": skydb.FieldType{Type: skydb.TypeString},
+				})
+				So(err, ShouldBeNil)
+			}
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"category": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "category"},
+			})
+			So(err, ShouldBeNil)
+
+			category := skydb.Record{
+				ID:      skydb.NewRecordID("category", "c1"),
+				OwnerID: "userid",
+				Data:    map[string]interface{}{},
+			}
+			So(db.Save(&category), ShouldBeNil)
+			note := skydb.Record{
+				ID:      skydb.NewRecordID("note", "number"),
+				OwnerID: "userid",
+				Data: map[string]interface{}{
+					"category": skydb.NewReference("category", "c1"),
+				},
+			}
+			So(db.Save(&note), ShouldBeNil)
+
+			tagType := skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "tag"}
+			count, err := db.CountAlterFailures("note", "category", tagType)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			tag := skydb.Record{
+				ID:      skydb.NewRecordID("tag", "c1"),
+				OwnerID: "userid",
+				Data:    map[string]interface{}{},
+			}
+			So(db.Save(&tag), ShouldBeNil)
+
+			err = db.AlterSchema("note", "category", tagType)
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["category"].ReferenceType, ShouldEqual, "tag")
+		})
+
+		Convey("rejects conversion not supported", func() {
+			err := db.AlterSchema("note", "score", skydb.FieldType{Type: skydb.TypeLocation})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+		})
+
+		Convey("rejects field not exist", func() {
+			_, err := db.CountAlterFailures("note", "missing", numberType)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ResourceNotFound)
+		})
+	})
+}
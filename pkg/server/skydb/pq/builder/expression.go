@@ -50,6 +50,11 @@ type expressionSqlizer struct {
 	// available, the field type may be empty.
 	fieldType skydb.FieldType
 
+	// JSONPath is the path into a JSON column when the expression is
+	// a keypath into a JSON column. The first keypath component is the
+	// column and the remaining components are the path.
+	jsonPath []string
+
 	skydb.Expression
 }
 
@@ -67,27 +72,45 @@ func newExpressionSqlizer(alias string, fieldType skydb.FieldType, expr skydb.Ex
 		alias,
 		requireCast,
 		fieldType,
+		nil,
 		expr,
 	}
 }
 
+// isJSONPath returns true if the expression is a keypath into a JSON column.
+func (expr expressionSqlizer) isJSONPath() bool {
+	return len(expr.jsonPath) > 0
+}
+
 func (expr expressionSqlizer) ToSql() (sql string, args []interface{}, err error) {
 	switch expr.Type {
 	case skydb.KeyPath:
 		components := expr.KeyPathComponents()
+		args = []interface{}{}
+		if expr.isJSONPath() {
+			// Value at a JSON path is compared as text if casting
+			// is required, otherwise it is compared as jsonb.
+			sql = jsonPathSQL(expr.alias, components[0], expr.jsonPath, expr.requireCast)
+			return
+		}
+
 		lastComponent := components[len(components)-1]
 		sql = fullQuoteIdentifier(expr.alias, lastComponent)
-		args = []interface{}{}
 
 		if expr.requireCast {
 			switch expr.fieldType.Type {
 			case skydb.TypeLocation, skydb.TypeGeometry:
 				sql = fmt.Sprintf("ST_AsGeoJSON(%s)", sql)
+			case skydb.TypeList:
+				sql = fmt.Sprintf("to_jsonb(%s)", sql)
 			}
 		}
 	case skydb.Function:
 		sql, args = funcToSQLOperand(expr.alias, expr.Value.(skydb.Func))
 	default:
+		if expr.requireCast && expr.fieldType.Type == skydb.TypeJSON && !expr.IsLiteralNull() {
+			return jsonLiteralToSQLOperand(expr.Value)
+		}
 		sql, args = LiteralToSQLOperand(expr.Value)
 	}
 	return
@@ -119,11 +142,39 @@ func funcToSQLOperand(alias string, fun skydb.Func) (string, []interface{}) {
 		}
 		args := []interface{}{}
 		return sql, args
+	case skydb.WithinFunc, skydb.IntersectsFunc, skydb.BoundingBoxFunc:
+		sql, args, _ := (&spatialPredicateSqlizer{alias, f}).ToSql()
+		return sql, args
+	case skydb.SearchFunc:
+		sql := searchRankSQL(alias, f, "?")
+		args := []interface{}{f.Terms}
+		return sql, args
+	case skydb.AggregateFunc:
+		sql := fmt.Sprintf("%s(%s)",
+			aggregateOperatorSQL(f.Operator),
+			fullQuoteIdentifier(alias, f.Field))
+		args := []interface{}{}
+		return sql, args
 	default:
 		panic(fmt.Errorf("got unrecgonized skydb.Func = %T", fun))
 	}
 }
 
+func aggregateOperatorSQL(op skydb.AggregateOperator) string {
+	switch op {
+	case skydb.Sum:
+		return "SUM"
+	case skydb.Avg:
+		return "AVG"
+	case skydb.Min:
+		return "MIN"
+	case skydb.Max:
+		return "MAX"
+	default:
+		panic(fmt.Errorf("got unrecgonized skydb.AggregateOperator = %v", op))
+	}
+}
+
 func LiteralToSQLOperand(literal interface{}) (string, []interface{}) {
 	// Array detection is borrowed from squirrel's expr.go
 	switch literalValue := literal.(type) {
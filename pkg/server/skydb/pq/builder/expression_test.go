@@ -31,6 +31,17 @@ func TestSqlizer(t *testing.T) {
 			So(args, ShouldResemble, []interface{}{})
 			So(err, ShouldBeNil)
 		})
+
+		Convey("aggregate function expression", func() {
+			expr := newExpressionSqlizer("table", skydb.FieldType{}, skydb.Expression{
+				skydb.Function,
+				skydb.AggregateFunc{Operator: skydb.Avg, Field: "price"},
+			})
+			sql, args, err := expr.ToSql()
+			So(sql, ShouldEqual, `AVG("table"."price")`)
+			So(args, ShouldResemble, []interface{}{})
+			So(err, ShouldBeNil)
+		})
 	})
 }
 
@@ -16,6 +16,7 @@ package builder
 
 import (
 	"fmt"
+	"strings"
 
 	sq "github.com/lann/squirrel"
 	"github.com/lib/pq"
@@ -103,6 +104,14 @@ func (f *predicateSqlizerFactory) newFunctionalPredicateSqlizer(predicate skydb.
 	switch fn := expr.Value.(type) {
 	case skydb.UserRelationFunc:
 		return f.newUserRelationFunctionalPredicateSqlizer(fn)
+	case skydb.SearchFunc:
+		return f.newSearchFunctionalPredicateSqlizer(fn)
+	case skydb.WithinFunc:
+		return f.newSpatialFunctionalPredicateSqlizer(fn.Field, fn)
+	case skydb.IntersectsFunc:
+		return f.newSpatialFunctionalPredicateSqlizer(fn.Field, fn)
+	case skydb.BoundingBoxFunc:
+		return f.newSpatialFunctionalPredicateSqlizer(fn.Field, fn)
 	default:
 		panic("the specified function cannot be used as a functional predicate")
 	}
@@ -134,6 +143,48 @@ func (f *predicateSqlizerFactory) newUserRelationFunctionalPredicateSqlizer(fn s
 	}, nil
 }
 
+func (f *predicateSqlizerFactory) newSearchFunctionalPredicateSqlizer(fn skydb.SearchFunc) (sq.Sqlizer, error) {
+	if strings.Contains(fn.Field, ".") {
+		return nil, skyerr.NewErrorf(skyerr.NotSupported,
+			`search on keypath "%s" of a related record is not supported`, fn.Field)
+	}
+
+	fields, err := skydb.TraverseColumnTypes(f.db, f.primaryTable, fn.Field)
+	if err != nil {
+		return nil, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+	if fields[0].Type != skydb.TypeString {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`search on keypath "%s" of non-string type is not supported`, fn.Field)
+	}
+
+	return &searchPredicateSqlizer{
+		alias: f.primaryTable,
+		fn:    fn,
+	}, nil
+}
+
+func (f *predicateSqlizerFactory) newSpatialFunctionalPredicateSqlizer(field string, fn skydb.Func) (sq.Sqlizer, error) {
+	if strings.Contains(field, ".") {
+		return nil, skyerr.NewErrorf(skyerr.NotSupported,
+			`spatial predicate on keypath "%s" of a related record is not supported`, field)
+	}
+
+	fields, err := skydb.TraverseColumnTypes(f.db, f.primaryTable, field)
+	if err != nil {
+		return nil, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+	if !fields[0].Type.IsGeometryCompatibleType() {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`spatial predicate on keypath "%s" of non-geometry type is not supported`, field)
+	}
+
+	return &spatialPredicateSqlizer{
+		alias: f.primaryTable,
+		fn:    fn,
+	}, nil
+}
+
 func (f *predicateSqlizerFactory) NewAccessControlSqlizer(user *skydb.AuthInfo, aclLevel skydb.RecordACLLevel) (sq.Sqlizer, error) {
 	return &accessPredicateSqlizer{
 		f.primaryTable,
@@ -157,11 +208,52 @@ func (f *predicateSqlizerFactory) newComparisonPredicateSqlizer(p skydb.Predicat
 	}
 
 	if p.Operator == skydb.In {
+		// Value at a JSON path is compared with an array of values as text.
+		if sqlizers[0].isJSONPath() {
+			sqlizers[0].requireCast = true
+		}
 		return &containsComparisonPredicateSqlizer{sqlizers}, nil
 	}
+	if p.Operator == skydb.ContainsAny || p.Operator == skydb.ContainsAll {
+		return &listContainsPredicateSqlizer{sqlizers, p.Operator}, nil
+	}
+	if p.Operator == skydb.Contains || p.Operator == skydb.HasKey {
+		return &jsonPredicateSqlizer{sqlizers, p.Operator}, nil
+	}
+	castJSONPathOperands(p.Operator, sqlizers)
 	return &comparisonPredicateSqlizer{sqlizers, p.Operator}, nil
 }
 
+// castJSONPathOperands casts the operands of a comparison with a JSON path
+// so that both sides of the comparison are of the same SQL type.
+//
+// Values at JSON paths are compared with literals as jsonb, so that numbers
+// are compared numerically. For pattern matching, values at JSON paths
+// are compared as text instead.
+func castJSONPathOperands(operator skydb.Operator, sqlizers []expressionSqlizer) {
+	hasJSONPath := false
+	for _, sqlizer := range sqlizers {
+		if sqlizer.isJSONPath() {
+			hasJSONPath = true
+		}
+	}
+	if !hasJSONPath {
+		return
+	}
+
+	for i, sqlizer := range sqlizers {
+		if operator == skydb.Like || operator == skydb.ILike {
+			if sqlizer.isJSONPath() {
+				sqlizer.requireCast = true
+			}
+		} else if sqlizer.Type == skydb.Literal && !sqlizer.IsLiteralNull() {
+			sqlizer.fieldType = skydb.FieldType{Type: skydb.TypeJSON}
+			sqlizer.requireCast = true
+		}
+		sqlizers[i] = sqlizer
+	}
+}
+
 // tryOptimizeDistancePredicate returns a sqlizer that is more efficient
 // at querying whether two points are within certain distance.
 //
@@ -236,6 +328,17 @@ func (f *predicateSqlizerFactory) newExpressionSqlizerForKeyPath(expr skydb.Expr
 
 	components := expr.KeyPathComponents()
 	keyPath := expr.Value.(string)
+	if len(components) > 1 {
+		schema, err := f.db.RemoteColumnTypes(f.primaryTable)
+		if err != nil {
+			return expressionSqlizer{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		if field, ok := schema[components[0]]; ok && field.Type == skydb.TypeJSON {
+			sqlizer := newExpressionSqlizer(f.primaryTable, field, expr)
+			sqlizer.jsonPath = components[1:]
+			return sqlizer, nil
+		}
+	}
 	if len(components) > 2 {
 		return expressionSqlizer{}, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
 			`keypath "%s" with more than 2 components is not supported`, keyPath)
@@ -0,0 +1,108 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// jsonPathSQL returns the SQL expression that extracts the value at
+// the path of a JSON column. The value is extracted as jsonb, or as text
+// if asText is true.
+//
+// The path is written as a literal rather than as an argument, so that
+// the expression can also be used in ORDER BY clause.
+func jsonPathSQL(alias string, column string, path []string, asText bool) string {
+	operator := "#>"
+	if asText {
+		operator = "#>>"
+	}
+	return fmt.Sprintf("%s %s %s",
+		fullQuoteIdentifier(alias, column),
+		operator,
+		jsonPathLiteral(path))
+}
+
+// jsonPathLiteral quotes a JSON path as a SQL text array literal.
+func jsonPathLiteral(path []string) string {
+	elements := make([]string, len(path))
+	for i, element := range path {
+		element = strings.Replace(element, `\`, `\\`, -1)
+		element = strings.Replace(element, `"`, `\"`, -1)
+		elements[i] = `"` + element + `"`
+	}
+	return QuoteLiteral("{" + strings.Join(elements, ",") + "}")
+}
+
+// jsonLiteralToSQLOperand returns the SQL operand of a literal value
+// casted to jsonb.
+func jsonLiteralToSQLOperand(literal interface{}) (string, []interface{}, error) {
+	data, err := json.Marshal(literal)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to marshal literal to json: %s", err)
+	}
+	return "?::jsonb", []interface{}{string(data)}, nil
+}
+
+// jsonPredicateSqlizer generates SQL condition that determines if
+// a JSON value contains another JSON value or if it has a key.
+type jsonPredicateSqlizer struct {
+	sqlizers []expressionSqlizer
+	operator skydb.Operator
+}
+
+func (p *jsonPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	lhs := p.sqlizers[0]
+	rhs := p.sqlizers[1]
+
+	if lhs.Type != skydb.KeyPath || lhs.fieldType.Type != skydb.TypeJSON {
+		err = fmt.Errorf("comparison operator `%v` requires a json keypath", p.operator)
+		return
+	}
+	if rhs.Type != skydb.Literal {
+		err = fmt.Errorf("comparison operator `%v` requires a literal value", p.operator)
+		return
+	}
+
+	column, args, err := lhs.ToSql()
+	if err != nil {
+		return
+	}
+
+	switch p.operator {
+	case skydb.Contains:
+		operand, operandArgs, err := jsonLiteralToSQLOperand(rhs.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		sql = fmt.Sprintf("%s @> %s", column, operand)
+		args = append(args, operandArgs...)
+	case skydb.HasKey:
+		key, ok := rhs.Value.(string)
+		if !ok {
+			err = fmt.Errorf("comparison operator `%v` requires a string key", p.operator)
+			return
+		}
+		sql = fmt.Sprintf("jsonb_exists(%s, ?)", column)
+		args = append(args, key)
+	default:
+		err = fmt.Errorf("comparison operator `%v` is not supported", p.operator)
+	}
+	return
+}
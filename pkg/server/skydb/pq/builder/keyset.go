@@ -0,0 +1,139 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// KeysetSqlizer generates SQL condition that selects records positioned
+// after (or before) a cursor in the order specified by sorts.
+//
+// The order is the same as the ORDER BY clause generated by SortOrderBySQL
+// for each sort, followed by the ascending order of `_id`. Since PostgreSQL
+// treats NULL as larger than any other value, NULL values are sorted last
+// in ascending order and first in descending order.
+type KeysetSqlizer struct {
+	Alias  string
+	Sorts  []skydb.Sort
+	Cursor skydb.Cursor
+
+	// Before is true if records positioned before the cursor are
+	// selected. Otherwise records positioned after the cursor are selected.
+	Before bool
+}
+
+// NewKeysetSqlizer returns a KeysetSqlizer that selects records after
+// the cursor, or before the cursor if before is true.
+func NewKeysetSqlizer(alias string, sorts []skydb.Sort, cursor skydb.Cursor, before bool) KeysetSqlizer {
+	return KeysetSqlizer{
+		Alias:  alias,
+		Sorts:  sorts,
+		Cursor: cursor,
+		Before: before,
+	}
+}
+
+// ToSql generates SQL for KeysetSqlizer
+//
+// For sorts (a, b) and a cursor (x, y, id), the generated condition is
+// equivalent to `a > x OR (a = x AND b > y) OR (a = x AND b = y AND _id > id)`
+// with comparison operators chosen according to the sort order.
+func (s KeysetSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	if len(s.Cursor.Values) != len(s.Sorts) {
+		err = fmt.Errorf("cursor has %d values, want %d", len(s.Cursor.Values), len(s.Sorts))
+		return
+	}
+
+	terms := []string{}
+	equalities := []string{}
+	equalityArgs := []interface{}{}
+	args = []interface{}{}
+	for i, sort := range s.Sorts {
+		if sort.Expression.Type != skydb.KeyPath {
+			err = fmt.Errorf("cursor is not supported for sort by %v", sort.Expression.Type)
+			return
+		}
+
+		column := fullQuoteIdentifier(s.Alias, sort.Expression.Value.(string))
+		value := s.Cursor.Values[i]
+
+		// Records are positioned after the cursor if the column is greater
+		// than the value in ascending order, or less than the value in
+		// descending order. The opposite holds for records before the cursor.
+		greater := (sort.Order == skydb.Descending) == s.Before
+
+		cmpSQL, cmpArgs := keysetComparison(column, value, greater)
+		terms = append(terms, keysetTerm(equalities, cmpSQL))
+		args = append(args, equalityArgs...)
+		args = append(args, cmpArgs...)
+
+		eqSQL, eqArgs := keysetEquality(column, value)
+		equalities = append(equalities, eqSQL)
+		equalityArgs = append(equalityArgs, eqArgs...)
+	}
+
+	idOperator := ">"
+	if s.Before {
+		idOperator = "<"
+	}
+	idSQL := fmt.Sprintf("%s %s ?", fullQuoteIdentifier(s.Alias, "_id"), idOperator)
+	terms = append(terms, keysetTerm(equalities, idSQL))
+	args = append(args, equalityArgs...)
+	args = append(args, s.Cursor.ID)
+
+	sql = "(" + strings.Join(terms, " OR ") + ")"
+	return
+}
+
+// keysetTerm joins the equalities of the preceding sorts and the comparison
+// of the current sort with AND.
+func keysetTerm(equalities []string, comparison string) string {
+	if len(equalities) == 0 {
+		return comparison
+	}
+	return "(" + strings.Join(equalities, " AND ") + " AND " + comparison + ")"
+}
+
+// keysetComparison generates SQL condition that selects values larger
+// (or smaller) than the specified value, with NULL larger than any value.
+func keysetComparison(column string, value interface{}, greater bool) (string, []interface{}) {
+	if value == nil {
+		if greater {
+			return "FALSE", []interface{}{}
+		}
+		return fmt.Sprintf("%s IS NOT NULL", column), []interface{}{}
+	}
+
+	sql, args := LiteralToSQLOperand(value)
+	if greater {
+		return fmt.Sprintf("(%s > %s OR %s IS NULL)", column, sql, column), args
+	}
+	return fmt.Sprintf("%s < %s", column, sql), args
+}
+
+// keysetEquality generates SQL condition that selects values equal to
+// the specified value, with NULL equals to NULL.
+func keysetEquality(column string, value interface{}) (string, []interface{}) {
+	if value == nil {
+		return fmt.Sprintf("%s IS NULL", column), []interface{}{}
+	}
+
+	sql, args := LiteralToSQLOperand(value)
+	return fmt.Sprintf("%s = %s", column, sql), args
+}
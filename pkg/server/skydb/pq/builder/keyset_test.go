@@ -0,0 +1,101 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func TestKeysetSqlizer(t *testing.T) {
+	Convey("KeysetSqlizer", t, func() {
+		sorts := []skydb.Sort{
+			{
+				Expression: skydb.Expression{Type: skydb.KeyPath, Value: "category"},
+				Order:      skydb.Ascending,
+			},
+			{
+				Expression: skydb.Expression{Type: skydb.KeyPath, Value: "score"},
+				Order:      skydb.Descending,
+			},
+		}
+
+		Convey("records after cursor", func() {
+			sqlizer := NewKeysetSqlizer("note", sorts, skydb.Cursor{
+				Values: []interface{}{"news", float64(10)},
+				ID:     "note1",
+			}, false)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(("note"."category" > ? OR "note"."category" IS NULL) OR `+
+				`("note"."category" = ? AND "note"."score" < ?) OR `+
+				`("note"."category" = ? AND "note"."score" = ? AND "note"."_id" > ?))`)
+			So(args, ShouldResemble, []interface{}{
+				"news", "news", float64(10), "news", float64(10), "note1",
+			})
+		})
+
+		Convey("records before cursor", func() {
+			sqlizer := NewKeysetSqlizer("note", sorts, skydb.Cursor{
+				Values: []interface{}{"news", float64(10)},
+				ID:     "note1",
+			}, true)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("note"."category" < ? OR `+
+				`("note"."category" = ? AND ("note"."score" > ? OR "note"."score" IS NULL)) OR `+
+				`("note"."category" = ? AND "note"."score" = ? AND "note"."_id" < ?))`)
+			So(args, ShouldResemble, []interface{}{
+				"news", "news", float64(10), "news", float64(10), "note1",
+			})
+		})
+
+		Convey("cursor with null values", func() {
+			sqlizer := NewKeysetSqlizer("note", sorts, skydb.Cursor{
+				Values: []interface{}{nil, nil},
+				ID:     "note1",
+			}, false)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(FALSE OR `+
+				`("note"."category" IS NULL AND "note"."score" IS NOT NULL) OR `+
+				`("note"."category" IS NULL AND "note"."score" IS NULL AND "note"."_id" > ?))`)
+			So(args, ShouldResemble, []interface{}{"note1"})
+		})
+
+		Convey("cursor without sorts", func() {
+			sqlizer := NewKeysetSqlizer("note", []skydb.Sort{}, skydb.Cursor{
+				Values: []interface{}{},
+				ID:     "note1",
+			}, false)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("note"."_id" > ?)`)
+			So(args, ShouldResemble, []interface{}{"note1"})
+		})
+
+		Convey("cursor not matching sorts", func() {
+			sqlizer := NewKeysetSqlizer("note", sorts, skydb.Cursor{
+				Values: []interface{}{"news"},
+				ID:     "note1",
+			}, false)
+			_, _, err := sqlizer.ToSql()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
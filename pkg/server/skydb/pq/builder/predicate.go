@@ -45,6 +45,13 @@ func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
 	b.WriteString(`(`)
 	args := []interface{}{}
 
+	// Entries of any level grant read access, while only entries of write
+	// level grant write access.
+	levelCondition := ""
+	if p.level == skydb.WriteLevel {
+		levelCondition = `, "level": "write"`
+	}
+
 	if p.user != nil {
 		if p.user.ID == "" {
 			panic("cannot build access predicate without user")
@@ -60,9 +67,9 @@ func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
 			if err != nil {
 				panic("unexpected serialize error on role")
 			}
-			b.WriteString(fmt.Sprintf(`%s @> '[{"role": %s}]' OR `, fullQuoteIdentifier(p.alias, "_access"), escapedRole))
+			b.WriteString(fmt.Sprintf(`%s @> '[{"role": %s%s}]' OR `, fullQuoteIdentifier(p.alias, "_access"), escapedRole, levelCondition))
 		}
-		b.WriteString(fmt.Sprintf(`%s @> '[{"user_id": %s}]' OR `, fullQuoteIdentifier(p.alias, "_access"), escapedID))
+		b.WriteString(fmt.Sprintf(`%s @> '[{"user_id": %s%s}]' OR `, fullQuoteIdentifier(p.alias, "_access"), escapedID, levelCondition))
 
 		b.WriteString(fmt.Sprintf(`%s = ? OR `, fullQuoteIdentifier(p.alias, "_owner_id")))
 		args = append(args, p.user.ID)
@@ -135,6 +142,27 @@ func (p *containsComparisonPredicateSqlizer) ToSql() (sql string, args []interfa
 
 		buffer.WriteString(`)`)
 
+		sql = buffer.String()
+		return sql, args, err
+	} else if lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath && rhs.fieldType.Type == skydb.TypeList {
+		sqlOperand, opArgs, err := lhs.ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		buffer.WriteString(sqlOperand)
+		args = append(args, opArgs...)
+
+		buffer.WriteString(` = ANY(`)
+
+		sqlOperand, opArgs, err = rhs.ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		buffer.WriteString(sqlOperand)
+		args = append(args, opArgs...)
+
+		buffer.WriteString(`)`)
+
 		sql = buffer.String()
 		return sql, args, err
 	} else if lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath {
@@ -188,6 +216,68 @@ func (p *containsComparisonPredicateSqlizer) ToSql() (sql string, args []interfa
 	return "", []interface{}{}, ErrCannotCompareUsingInOperator
 }
 
+// listContainsPredicateSqlizer generates SQL condition that determines
+// if a list contains any or all of the values in an array.
+//
+// For a list column, the array is compared with the array operators
+// `&&` and `@>`. For a JSON column, the array is compared with the
+// string elements of the JSON array.
+type listContainsPredicateSqlizer struct {
+	sqlizers []expressionSqlizer
+	operator skydb.Operator
+}
+
+func (p *listContainsPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	lhs := p.sqlizers[0]
+	rhs := p.sqlizers[1]
+
+	values, ok := rhs.Value.([]interface{})
+	if lhs.Type != skydb.KeyPath || rhs.Type != skydb.Literal || !ok {
+		err = fmt.Errorf("comparison operator `%v` requires a keypath and an array", p.operator)
+		return
+	}
+
+	if len(values) == 0 {
+		// Every list contains all values of an empty array, but
+		// no list contains any of them.
+		if p.operator == skydb.ContainsAll {
+			return "TRUE", []interface{}{}, nil
+		}
+		return "FALSE", []interface{}{}, nil
+	}
+
+	column, args, err := lhs.ToSql()
+	if err != nil {
+		return
+	}
+
+	array := "ARRAY[" + sq.Placeholders(len(values)) + "]"
+	for _, value := range values {
+		args = append(args, literalToSQLValue(value))
+	}
+
+	switch lhs.fieldType.Type {
+	case skydb.TypeList:
+		if lhs.fieldType.UnderlyingType != "" {
+			array = array + "::" + lhs.fieldType.UnderlyingType
+		}
+		operator := "&&"
+		if p.operator == skydb.ContainsAll {
+			operator = "@>"
+		}
+		sql = fmt.Sprintf("%s %s %s", column, operator, array)
+	case skydb.TypeJSON:
+		function := "jsonb_exists_any"
+		if p.operator == skydb.ContainsAll {
+			function = "jsonb_exists_all"
+		}
+		sql = fmt.Sprintf("%s(%s, %s)", function, column, array)
+	default:
+		err = fmt.Errorf("comparison operator `%v` is not supported for field of type %v", p.operator, lhs.fieldType.Type)
+	}
+	return
+}
+
 type comparisonPredicateSqlizer struct {
 	sqlizers []expressionSqlizer
 	operator skydb.Operator
@@ -208,6 +298,10 @@ func (p *comparisonPredicateSqlizer) ToSql() (sql string, args []interface{}, er
 			}
 		}
 
+		if rhs.IsLiteralNull() && lhs.isJSONPath() && !lhs.requireCast {
+			return p.jsonNullToSql(lhs)
+		}
+
 		sqlOperand, opArgs, err := lhs.ToSql()
 		if err != nil {
 			return "", nil, err
@@ -275,6 +369,25 @@ func (p *comparisonPredicateSqlizer) writeOperatorForNullOperand(buffer *bytes.B
 	return nil
 }
 
+// jsonNullToSql compares the value at a JSON path with null. A JSON path
+// is null if the key is missing or if the stored value is a JSON null.
+func (p *comparisonPredicateSqlizer) jsonNullToSql(lhs expressionSqlizer) (sql string, args []interface{}, err error) {
+	column, args, err := lhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch p.operator {
+	case skydb.Equal:
+		sql = fmt.Sprintf("(%s IS NULL OR %s = 'null'::jsonb)", column, column)
+	case skydb.NotEqual:
+		sql = fmt.Sprintf("(%s IS NOT NULL AND %s <> 'null'::jsonb)", column, column)
+	default:
+		err = fmt.Errorf("comparison operator `%v` is not supported", p.operator)
+	}
+	return
+}
+
 // NotSqlizer generates SQL condition that negates a boolean condition
 type NotSqlizer struct {
 	Predicate sq.Sqlizer
@@ -324,3 +437,39 @@ func (s distancePredicateSqlizer) ToSql() (sql string, args []interface{}, err e
 	args = append(args, distanceArgs...)
 	return
 }
+
+// spatialPredicateSqlizer generates SQL condition that determines the
+// spatial relationship between a geometry field and a user supplied
+// geometry or box.
+type spatialPredicateSqlizer struct {
+	alias string
+	fn    skydb.Func
+}
+
+// ToSql generates SQL for spatialPredicateSqlizer
+func (s *spatialPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	switch fn := s.fn.(type) {
+	case skydb.WithinFunc:
+		geometrySQL, geometryArgs := LiteralToSQLOperand(fn.Geometry)
+		sql = fmt.Sprintf("ST_Within(%s, %s)",
+			fullQuoteIdentifier(s.alias, fn.Field), geometrySQL)
+		args = geometryArgs
+	case skydb.IntersectsFunc:
+		geometrySQL, geometryArgs := LiteralToSQLOperand(fn.Geometry)
+		sql = fmt.Sprintf("ST_Intersects(%s, %s)",
+			fullQuoteIdentifier(s.alias, fn.Field), geometrySQL)
+		args = geometryArgs
+	case skydb.BoundingBoxFunc:
+		// The && operator compares bounding boxes, which makes use of
+		// the spatial index of the field.
+		sql = fmt.Sprintf("%s && ST_MakeEnvelope(?, ?, ?, ?)",
+			fullQuoteIdentifier(s.alias, fn.Field))
+		args = []interface{}{
+			fn.SouthWest.Lng(), fn.SouthWest.Lat(),
+			fn.NorthEast.Lng(), fn.NorthEast.Lat(),
+		}
+	default:
+		err = fmt.Errorf("got unrecgonized spatial function = %T", s.fn)
+	}
+	return
+}
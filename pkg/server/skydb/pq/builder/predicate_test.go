@@ -212,6 +212,417 @@ func TestPredicateSqlizerFactory(t *testing.T) {
 	})
 }
 
+func TestSearchPredicateSqlizer(t *testing.T) {
+	Convey("Search Predicate", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		db := mock_skydb.NewMockDatabase(ctrl)
+		db.EXPECT().RemoteColumnTypes(gomock.Eq("note")).
+			Return(
+				skydb.RecordSchema{
+					"content": skydb.FieldType{Type: skydb.TypeString},
+					"order":   skydb.FieldType{Type: skydb.TypeNumber},
+				}, nil,
+			).AnyTimes()
+
+		f := NewPredicateSqlizerFactory(db, "note").(*predicateSqlizerFactory)
+
+		Convey("search on string field", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{
+						skydb.Function,
+						skydb.SearchFunc{
+							Field:    "content",
+							Terms:    "quick fox",
+							Language: "english",
+						},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `to_tsvector('english', "note"."content") @@ plainto_tsquery('english', ?)`)
+			So(args, ShouldResemble, []interface{}{"quick fox"})
+		})
+
+		Convey("search phrase", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{
+						skydb.Function,
+						skydb.SearchFunc{
+							Field: "content",
+							Terms: "quick fox",
+							Mode:  skydb.PhraseSearch,
+						},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `to_tsvector('simple', "note"."content") @@ phraseto_tsquery('simple', ?)`)
+			So(args, ShouldResemble, []interface{}{"quick fox"})
+		})
+
+		Convey("search on non-string field", func() {
+			_, err := f.NewPredicateSqlizer(skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{
+						skydb.Function,
+						skydb.SearchFunc{Field: "order", Terms: "1"},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+
+		Convey("sort by relevance", func() {
+			rank := skydb.Expression{
+				skydb.Function,
+				skydb.SearchFunc{
+					Field:    "content",
+					Terms:    "fox's den",
+					Language: "english",
+				},
+			}
+			_, err := SortOrderBySQL("note", skydb.RecordSchema{}, skydb.Sort{
+				Expression: rank,
+				Order:      skydb.Descending,
+			})
+			So(err, ShouldNotBeNil)
+
+			sql, args, err := NewExpressionSqlizer("note", skydb.FieldType{Type: skydb.TypeNumber}, rank).ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `ts_rank(to_tsvector('english', "note"."content"), plainto_tsquery('english', ?))`)
+			So(args, ShouldResemble, []interface{}{"fox's den"})
+
+			orderBy, err := SortColumnOrderBySQL("_sort_0", skydb.Descending)
+			So(err, ShouldBeNil)
+			So(orderBy, ShouldEqual, `"_sort_0" DESC`)
+		})
+	})
+}
+
+func TestListContainsPredicateSqlizer(t *testing.T) {
+	Convey("List Contains Predicate", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		db := mock_skydb.NewMockDatabase(ctrl)
+		db.EXPECT().RemoteColumnTypes(gomock.Eq("note")).
+			Return(
+				skydb.RecordSchema{
+					"tags": skydb.FieldType{
+						Type:           skydb.TypeList,
+						ElementType:    skydb.TypeString,
+						UnderlyingType: "text[]",
+					},
+					"categories": skydb.FieldType{Type: skydb.TypeJSON},
+					"content":    skydb.FieldType{Type: skydb.TypeString},
+				}, nil,
+			).AnyTimes()
+
+		f := NewPredicateSqlizerFactory(db, "note").(*predicateSqlizerFactory)
+
+		predicate := func(operator skydb.Operator, keyPath string, values []interface{}) skydb.Predicate {
+			return skydb.Predicate{
+				operator,
+				[]interface{}{
+					skydb.Expression{skydb.KeyPath, keyPath},
+					skydb.Expression{skydb.Literal, values},
+				},
+			}
+		}
+
+		Convey("list contains any", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.ContainsAny, "tags", []interface{}{"a", "b"}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."tags" && ARRAY[?,?]::text[]`)
+			So(args, ShouldResemble, []interface{}{"a", "b"})
+		})
+
+		Convey("list contains all", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.ContainsAll, "tags", []interface{}{"a", "b"}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."tags" @> ARRAY[?,?]::text[]`)
+			So(args, ShouldResemble, []interface{}{"a", "b"})
+		})
+
+		Convey("json array contains any", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.ContainsAny, "categories", []interface{}{"a"}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `jsonb_exists_any("note"."categories", ARRAY[?])`)
+			So(args, ShouldResemble, []interface{}{"a"})
+		})
+
+		Convey("contains empty array", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.ContainsAny, "tags", []interface{}{}))
+			So(err, ShouldBeNil)
+			sql, _, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `FALSE`)
+
+			sqlizer, err = f.NewPredicateSqlizer(predicate(skydb.ContainsAll, "tags", []interface{}{}))
+			So(err, ShouldBeNil)
+			sql, _, err = sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `TRUE`)
+		})
+
+		Convey("value is in list", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				skydb.In,
+				[]interface{}{
+					skydb.Expression{skydb.Literal, "a"},
+					skydb.Expression{skydb.KeyPath, "tags"},
+				},
+			})
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `? = ANY("note"."tags")`)
+			So(args, ShouldResemble, []interface{}{"a"})
+		})
+
+		Convey("contains on string field", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.ContainsAny, "content", []interface{}{"a"}))
+			So(err, ShouldBeNil)
+			_, _, err = sqlizer.ToSql()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestJSONPredicateSqlizer(t *testing.T) {
+	Convey("JSON Predicate", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		typemap := skydb.RecordSchema{
+			"settings": skydb.FieldType{Type: skydb.TypeJSON},
+			"content":  skydb.FieldType{Type: skydb.TypeString},
+		}
+		db := mock_skydb.NewMockDatabase(ctrl)
+		db.EXPECT().RemoteColumnTypes(gomock.Eq("note")).
+			Return(typemap, nil).AnyTimes()
+
+		f := NewPredicateSqlizerFactory(db, "note").(*predicateSqlizerFactory)
+
+		predicate := func(operator skydb.Operator, keyPath string, value interface{}) skydb.Predicate {
+			return skydb.Predicate{
+				operator,
+				[]interface{}{
+					skydb.Expression{skydb.KeyPath, keyPath},
+					skydb.Expression{skydb.Literal, value},
+				},
+			}
+		}
+
+		Convey("json path equal value", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.Equal, "settings.theme", "dark"))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."settings" #> '{"theme"}'=?::jsonb`)
+			So(args, ShouldResemble, []interface{}{`"dark"`})
+		})
+
+		Convey("nested json path greater than number", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.GreaterThan, "settings.font.size", float64(12)))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."settings" #> '{"font","size"}'>?::jsonb`)
+			So(args, ShouldResemble, []interface{}{`12`})
+		})
+
+		Convey("json path equal null", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.Equal, "settings.theme", nil))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("note"."settings" #> '{"theme"}' IS NULL OR "note"."settings" #> '{"theme"}' = 'null'::jsonb)`)
+			So(args, ShouldBeEmpty)
+		})
+
+		Convey("json path not equal null", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.NotEqual, "settings.theme", nil))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("note"."settings" #> '{"theme"}' IS NOT NULL AND "note"."settings" #> '{"theme"}' <> 'null'::jsonb)`)
+			So(args, ShouldBeEmpty)
+		})
+
+		Convey("json path like pattern", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.Like, "settings.theme", "d%"))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."settings" #>> '{"theme"}' LIKE ?`)
+			So(args, ShouldResemble, []interface{}{"d%"})
+		})
+
+		Convey("json path in array of values", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.In, "settings.theme", []interface{}{"dark", "light"}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."settings" #>> '{"theme"}' IN (?,?)`)
+			So(args, ShouldResemble, []interface{}{"dark", "light"})
+		})
+
+		Convey("json contains value", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.Contains, "settings", map[string]interface{}{"theme": "dark"}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."settings" @> ?::jsonb`)
+			So(args, ShouldResemble, []interface{}{`{"theme":"dark"}`})
+		})
+
+		Convey("json path has key", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.HasKey, "settings.font", "size"))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `jsonb_exists("note"."settings" #> '{"font"}', ?)`)
+			So(args, ShouldResemble, []interface{}{"size"})
+		})
+
+		Convey("has key on string field", func() {
+			sqlizer, err := f.NewPredicateSqlizer(predicate(skydb.HasKey, "content", "size"))
+			So(err, ShouldBeNil)
+			_, _, err = sqlizer.ToSql()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("keypath into non-json field", func() {
+			_, err := f.NewPredicateSqlizer(predicate(skydb.Equal, "content.theme", "dark"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("sort by json path", func() {
+			orderBy, err := SortOrderBySQL("note", typemap, skydb.Sort{
+				Expression: skydb.Expression{skydb.KeyPath, "settings.font.size"},
+				Order:      skydb.Descending,
+			})
+			So(err, ShouldBeNil)
+			So(orderBy, ShouldEqual, `"note"."settings" #> '{"font","size"}' DESC`)
+		})
+
+		Convey("sort by keypath into non-json field", func() {
+			_, err := SortOrderBySQL("note", typemap, skydb.Sort{
+				Expression: skydb.Expression{skydb.KeyPath, "content.theme"},
+				Order:      skydb.Descending,
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSpatialPredicateSqlizer(t *testing.T) {
+	Convey("Spatial Predicate", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		db := mock_skydb.NewMockDatabase(ctrl)
+		db.EXPECT().RemoteColumnTypes(gomock.Eq("restaurant")).
+			Return(
+				skydb.RecordSchema{
+					"location": skydb.FieldType{Type: skydb.TypeLocation},
+					"zone":     skydb.FieldType{Type: skydb.TypeGeometry},
+					"name":     skydb.FieldType{Type: skydb.TypeString},
+				}, nil,
+			).AnyTimes()
+
+		f := NewPredicateSqlizerFactory(db, "restaurant").(*predicateSqlizerFactory)
+
+		functional := func(fn skydb.Func) skydb.Predicate {
+			return skydb.Predicate{
+				skydb.Functional,
+				[]interface{}{
+					skydb.Expression{skydb.Function, fn},
+				},
+			}
+		}
+		polygon := skydb.Geometry{
+			"type": "Polygon",
+			"coordinates": []interface{}{
+				[]interface{}{
+					[]interface{}{0.0, 0.0},
+					[]interface{}{1.0, 0.0},
+					[]interface{}{1.0, 1.0},
+					[]interface{}{0.0, 0.0},
+				},
+			},
+		}
+
+		Convey("location within polygon", func() {
+			sqlizer, err := f.NewPredicateSqlizer(functional(skydb.WithinFunc{
+				Field:    "location",
+				Geometry: polygon,
+			}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `ST_Within("restaurant"."location", ST_GeomFromGeoJSON(?))`)
+			So(args, ShouldResemble, []interface{}{
+				[]byte(`{"coordinates":[[[0,0],[1,0],[1,1],[0,0]]],"type":"Polygon"}`),
+			})
+		})
+
+		Convey("geometry intersects polygon", func() {
+			sqlizer, err := f.NewPredicateSqlizer(functional(skydb.IntersectsFunc{
+				Field:    "zone",
+				Geometry: polygon,
+			}))
+			So(err, ShouldBeNil)
+			sql, _, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `ST_Intersects("restaurant"."zone", ST_GeomFromGeoJSON(?))`)
+		})
+
+		Convey("location in bounding box", func() {
+			sqlizer, err := f.NewPredicateSqlizer(functional(skydb.BoundingBoxFunc{
+				Field:     "location",
+				SouthWest: skydb.NewLocation(1, 2),
+				NorthEast: skydb.NewLocation(3, 4),
+			}))
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"restaurant"."location" && ST_MakeEnvelope(?, ?, ?, ?)`)
+			So(args, ShouldResemble, []interface{}{
+				float64(1), float64(2), float64(3), float64(4),
+			})
+		})
+
+		Convey("spatial predicate on non-geometry field", func() {
+			_, err := f.NewPredicateSqlizer(functional(skydb.WithinFunc{
+				Field:    "name",
+				Geometry: polygon,
+			}))
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+	})
+}
+
 func TestNotSqlizer(t *testing.T) {
 	Convey("NotSqlizer", t, func() {
 		Convey("should generate not predicate", func() {
@@ -307,6 +718,27 @@ func TestAccessPredicateSqlizer(t *testing.T) {
 					`"_access" IS NULL)`)
 			So(args, ShouldResemble, []interface{}{"userid"})
 		})
+
+		Convey("serialized for user and role based ACE and write", func() {
+			authinfo := skydb.AuthInfo{
+				ID:    "userid",
+				Roles: []string{"admin"},
+			}
+			sqlizer := &accessPredicateSqlizer{
+				"",
+				&authinfo,
+				skydb.WriteLevel,
+			}
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual,
+				`("_access" @> '[{"role": "admin", "level": "write"}]' OR `+
+					`"_access" @> '[{"user_id": "userid", "level": "write"}]' OR `+
+					`"_owner_id" = ? OR `+
+					`"_access" @> '[{"public": true, "level": "write"}]' OR `+
+					`"_access" IS NULL)`)
+			So(args, ShouldResemble, []interface{}{"userid"})
+		})
 	})
 }
 
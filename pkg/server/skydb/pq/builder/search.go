@@ -0,0 +1,92 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// SearchVectorSQL returns the SQL expression that converts a field to
+// a text search vector.
+//
+// The language is written as a literal rather than as an argument, so that
+// the expression matches the expression of the GIN index created for
+// the field. The language is assumed to be validated by
+// skydb.IsValidSearchLanguage.
+func SearchVectorSQL(alias string, field string, language string) string {
+	return fmt.Sprintf("to_tsvector(%s, %s)",
+		QuoteLiteral(searchLanguage(language)),
+		fullQuoteIdentifier(alias, field))
+}
+
+// searchQuerySQL returns the SQL expression that converts the terms
+// to a text search query. The placeholder of terms is replaced with
+// the specified terms SQL.
+func searchQuerySQL(f skydb.SearchFunc, terms string) string {
+	function := "plainto_tsquery"
+	if f.Mode == skydb.PhraseSearch {
+		function = "phraseto_tsquery"
+	}
+	return fmt.Sprintf("%s(%s, %s)",
+		function,
+		QuoteLiteral(searchLanguage(f.Language)),
+		terms)
+}
+
+// searchRankSQL returns the SQL expression that evaluates the relevance
+// of the field to the terms.
+func searchRankSQL(alias string, f skydb.SearchFunc, terms string) string {
+	return fmt.Sprintf("ts_rank(%s, %s)",
+		SearchVectorSQL(alias, f.Field, f.Language),
+		searchQuerySQL(f, terms))
+}
+
+func searchLanguage(language string) string {
+	if language == "" {
+		return skydb.DefaultSearchLanguage
+	}
+	return language
+}
+
+// QuoteLiteral quotes a string as a SQL string literal, for statements
+// which cannot take arguments, such as the expression of an index. If the
+// string contains backslashes, it is quoted as an escape string so that
+// the result does not depend on standard_conforming_strings.
+func QuoteLiteral(literal string) string {
+	literal = strings.Replace(literal, `'`, `''`, -1)
+	if strings.Contains(literal, `\`) {
+		literal = strings.Replace(literal, `\`, `\\`, -1)
+		return `E'` + literal + `'`
+	}
+	return `'` + literal + `'`
+}
+
+// searchPredicateSqlizer generates SQL condition that determines
+// if a field matches the search terms.
+type searchPredicateSqlizer struct {
+	alias string
+	fn    skydb.SearchFunc
+}
+
+func (p *searchPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	sql = fmt.Sprintf("%s @@ %s",
+		SearchVectorSQL(p.alias, p.fn.Field, p.fn.Language),
+		searchQuerySQL(p.fn, "?"))
+	args = []interface{}{p.fn.Terms}
+	return
+}
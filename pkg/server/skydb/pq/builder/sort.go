@@ -18,23 +18,32 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 )
 
-func SortOrderBySQL(alias string, sort skydb.Sort) (string, error) {
-	var expr string
+// SortOrderBySQL returns the ORDER BY expression of a sort by key path.
+//
+// A sort by function is not supported, as squirrel does not bind arguments
+// of ORDER BY. Select the function as a column and order by the column
+// with SortColumnOrderBySQL instead.
+//
+// A keypath with more than one component is sorted by the value at the
+// path into a JSON field, the type of which is looked up in typemap.
+func SortOrderBySQL(alias string, typemap skydb.RecordSchema, sort skydb.Sort) (string, error) {
+	if sort.Expression.Type != skydb.KeyPath {
+		return "", errors.New("invalid Sort: specify a KeyPath")
+	}
 
-	switch sort.Expression.Type {
-	case skydb.KeyPath:
-		expr = fullQuoteIdentifier(alias, sort.Expression.Value.(string))
-	case skydb.Function:
-		var err error
-		expr, err = funcOrderBySQL(alias, sort.Expression.Value.(skydb.Func))
-		if err != nil {
-			return "", err
+	var expr string
+	components := sort.Expression.KeyPathComponents()
+	if len(components) > 1 {
+		if typemap[components[0]].Type != skydb.TypeJSON {
+			return "", fmt.Errorf(`cannot sort by keypath "%s": field "%s" is not a json field`, sort.Expression.Value, components[0])
 		}
-	default:
-		return "", errors.New("invalid Sort: specify either KeyPath or Func")
+		expr = jsonPathSQL(alias, components[0], components[1:], false)
+	} else {
+		expr = fullQuoteIdentifier(alias, components[0])
 	}
 
 	order, err := sortOrderOrderBySQL(sort.Order)
@@ -42,23 +51,18 @@ func SortOrderBySQL(alias string, sort skydb.Sort) (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf(expr + " " + order), nil
+	return expr + " " + order, nil
 }
 
-// due to sq not being able to pass args in OrderBy, we can't re-use funcToSQLOperand
-func funcOrderBySQL(alias string, fun skydb.Func) (string, error) {
-	switch f := fun.(type) {
-	case skydb.DistanceFunc:
-		sql := fmt.Sprintf(
-			"ST_Distance_Sphere(%s, ST_MakePoint(%f, %f))",
-			fullQuoteIdentifier(alias, f.Field),
-			f.Location.Lng(),
-			f.Location.Lat(),
-		)
-		return sql, nil
-	default:
-		return "", fmt.Errorf("got unrecgonized skydb.Func = %T", fun)
+// SortColumnOrderBySQL returns the ORDER BY expression of a sort by the
+// selected column.
+func SortColumnOrderBySQL(column string, order skydb.SortOrder) (string, error) {
+	orderSQL, err := sortOrderOrderBySQL(order)
+	if err != nil {
+		return "", err
 	}
+
+	return pq.QuoteIdentifier(column) + " " + orderSQL, nil
 }
 
 func sortOrderOrderBySQL(order skydb.SortOrder) (string, error) {
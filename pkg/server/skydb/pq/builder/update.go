@@ -0,0 +1,146 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+)
+
+// UpdateQueryBuilder is a sqlizer for UPDATE SQL that updates a row only
+// if the row matches the specified conditions.
+//
+// Let table = 'schema.note',
+//     pkData = {'_id': '1'},
+//     conditions = {'_updated_at': t},
+//     data = {'content': 'hello'}
+//
+// The following is generated:
+//
+//	UPDATE schema.note
+//	SET "content" = $3
+//	WHERE "_id" = $1 AND "_updated_at" = $2
+//	RETURNING *
+//
+// Unlike UpsertQueryBuilder, no row is inserted if there is no matching row,
+// in which case the query returns no rows.
+type UpdateQueryBuilder struct {
+	table          string
+	pkData         map[string]interface{}
+	conditions     map[string]interface{}
+	data           map[string]interface{}
+	updateIngnores map[string]struct{}
+	wrappers       map[string]func(string) string
+	updateWrappers map[string]func(string) string
+	selectColumns  map[string]sq.Sqlizer
+}
+
+// UpdateQueryWithWrappers returns an UpdateQueryBuilder. The value of
+// a column is wrapped by the wrapper of the column if specified.
+func UpdateQueryWithWrappers(table string, pkData, conditions, data map[string]interface{}, wrappers map[string]func(string) string) *UpdateQueryBuilder {
+	return &UpdateQueryBuilder{
+		table,
+		pkData,
+		conditions,
+		data,
+		map[string]struct{}{},
+		wrappers,
+		map[string]func(string) string{},
+		map[string]sq.Sqlizer{},
+	}
+}
+
+func (update *UpdateQueryBuilder) IgnoreKeyOnUpdate(col string) *UpdateQueryBuilder {
+	update.updateIngnores[col] = struct{}{}
+	return update
+}
+
+// WrapOnUpdate sets the wrapper of the value of a column, which is used
+// instead of the wrapper specified in UpdateQueryWithWrappers. It exists
+// so that UpdateQueryBuilder can be used in place of UpsertQueryBuilder.
+func (update *UpdateQueryBuilder) WrapOnUpdate(col string, wrapper func(string) string) *UpdateQueryBuilder {
+	update.updateWrappers[col] = wrapper
+	return update
+}
+
+func (update *UpdateQueryBuilder) SelectColumn(col string, sqlizer sq.Sqlizer) *UpdateQueryBuilder {
+	update.selectColumns[col] = sqlizer
+	return update
+}
+
+func (update *UpdateQueryBuilder) ToSql() (sql string, args []interface{}, err error) {
+	args = []interface{}{}
+	placeholder := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	whereClauses := []string{}
+	for _, col := range sortedKeys(update.pkData) {
+		whereClauses = append(whereClauses,
+			fmt.Sprintf("%s = %s", pq.QuoteIdentifier(col), placeholder(update.pkData[col])))
+	}
+	for _, col := range sortedKeys(update.conditions) {
+		whereClauses = append(whereClauses,
+			fmt.Sprintf("%s = %s", pq.QuoteIdentifier(col), placeholder(update.conditions[col])))
+	}
+
+	setClauses := []string{}
+	for _, col := range sortedKeys(update.data) {
+		if _, ok := update.updateIngnores[col]; ok {
+			continue
+		}
+		value := placeholder(update.data[col])
+		if wrapper, ok := update.updateWrappers[col]; ok {
+			value = wrapper(value)
+		} else if wrapper, ok := update.wrappers[col]; ok {
+			value = wrapper(value)
+		}
+		setClauses = append(setClauses,
+			fmt.Sprintf("%s = %s", pq.QuoteIdentifier(col), value))
+	}
+
+	if len(setClauses) == 0 {
+		err = fmt.Errorf("no column to update in table %s", update.table)
+		return
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString("UPDATE ")
+	b.WriteString(update.table)
+	b.WriteString(" SET ")
+	b.WriteString(strings.Join(setClauses, ", "))
+	b.WriteString(" WHERE ")
+	b.WriteString(strings.Join(whereClauses, " AND "))
+	b.WriteString(" RETURNING ")
+	b.WriteString(upsertSelectClause(update.selectColumns))
+
+	sql = b.String()
+	return
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
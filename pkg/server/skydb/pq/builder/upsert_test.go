@@ -0,0 +1,69 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpsertQueryBuilder(t *testing.T) {
+	Convey("UpsertQueryBuilder", t, func() {
+		increment := func(val string) string {
+			return fmt.Sprintf(`COALESCE("count", 0) + %s`, val)
+		}
+
+		Convey("wraps value on update only", func() {
+			upsert := UpsertQueryWithWrappers(
+				"note",
+				map[string]interface{}{"_id": "1"},
+				map[string]interface{}{"count": 1},
+				map[string]func(string) string{},
+			).WrapOnUpdate("count", increment)
+
+			sql, args, err := upsert.ToSql()
+			So(err, ShouldBeNil)
+			So(strings.Join(strings.Fields(sql), " "), ShouldContainSubstring,
+				`UPDATE note SET ("count") = (COALESCE("count", 0) + $2)`)
+			So(strings.Join(strings.Fields(sql), " "), ShouldContainSubstring,
+				`INSERT INTO note ("_id", "count") SELECT $1,$2`)
+			So(args, ShouldResemble, []interface{}{"1", 1})
+		})
+	})
+}
+
+func TestUpdateQueryBuilder(t *testing.T) {
+	Convey("UpdateQueryBuilder", t, func() {
+		Convey("wraps value on update", func() {
+			update := UpdateQueryWithWrappers(
+				"note",
+				map[string]interface{}{"_id": "1"},
+				map[string]interface{}{},
+				map[string]interface{}{"count": 1},
+				map[string]func(string) string{},
+			).WrapOnUpdate("count", func(val string) string {
+				return fmt.Sprintf(`COALESCE("count", 0) + %s`, val)
+			})
+
+			sql, args, err := update.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `UPDATE note SET "count" = COALESCE("count", 0) + $2 WHERE "_id" = $1 RETURNING *`)
+			So(args, ShouldResemble, []interface{}{"1", 1})
+		})
+	})
+}
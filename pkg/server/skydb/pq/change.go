@@ -0,0 +1,262 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+)
+
+var _ skydb.ChangeFeedDatabase = &database{}
+
+// recordChangeOperations maps the trigger operation logged in the
+// `_record_change` table to RecordOperation.
+var recordChangeOperations = map[string]skydb.RecordOperation{
+	"INSERT": skydb.RecordCreateOperation,
+	"UPDATE": skydb.RecordUpdateOperation,
+	"DELETE": skydb.RecordDeleteOperation,
+}
+
+var _ skydb.RecordChangeLogConn = &conn{}
+
+// GetRecordChanges reads the `_record_change` table, which is appended
+// by the trigger on each record table. Only the latest change to each
+// record is returned, and access control is applied using the access
+// of the record at the time of that change. If the user could read an
+// earlier change to the record but not the latest one, the change is
+// returned as a deletion without the record, so that the user removes
+// the record it can no longer read.
+//
+// The ID of a change is assigned when the change is made, but the
+// change is visible only after its transaction commits. Changes are
+// therefore ordered by the ID of the transaction first, and only the
+// changes of transactions older than the oldest running transaction
+// are returned. A transaction committed later always has an ID not less
+// than that, so its changes are ordered after the changes returned.
+func (db *database) GetRecordChanges(query *skydb.Query, after skydb.RecordChangeCursor) ([]skydb.RecordChange, error) {
+	if err := db.checkRecordChangesPruned(after); err != nil {
+		return nil, err
+	}
+
+	tableName := db.TableName("_record_change")
+	q := psql.Select("c.id", "c.txid", "c.op", "c.record_id", "c.changed_at").
+		From(tableName+" AS c").
+		Where("c.record_type = ? AND (c.txid, c.id) > (?, ?)", query.Type, after.TxID, after.ID).
+		Where("c.txid < txid_snapshot_xmin(txid_current_snapshot())").
+		Where(fmt.Sprintf(`NOT EXISTS (
+			SELECT 1 FROM %s AS n
+			WHERE n.record_type = c.record_type
+				AND n.record_id = c.record_id
+				AND n._database_id = c._database_id
+				AND n.id > c.id
+		)`, tableName)).
+		OrderBy("c.txid", "c.id")
+
+	switch db.DatabaseType() {
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		q = q.Where("c._database_id = ?", db.userID)
+	}
+
+	if db.DatabaseType() == skydb.PublicDatabase && !query.BypassAccessControl {
+		readableSQL, readableArgs, err := db.changeAccessControlSQL("c", query.ViewAsUser)
+		if err != nil {
+			return nil, err
+		}
+		earlierSQL, earlierArgs, err := db.changeAccessControlSQL("e", query.ViewAsUser)
+		if err != nil {
+			return nil, err
+		}
+
+		q = q.Column(readableSQL+" AS readable", readableArgs...).
+			Where(fmt.Sprintf(`(%s OR EXISTS (
+				SELECT 1 FROM %s AS e
+				WHERE e.record_type = c.record_type
+					AND e.record_id = c.record_id
+					AND e._database_id = c._database_id
+					AND e.id < c.id
+					AND %s
+			))`, readableSQL, tableName, earlierSQL), append(readableArgs, earlierArgs...)...)
+	} else {
+		q = q.Column("TRUE AS readable")
+	}
+
+	if query.Limit != nil {
+		q = q.Limit(*query.Limit)
+	}
+
+	rows, err := db.c.QueryWith(q)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query record changes: %s", err)
+	}
+	defer rows.Close()
+
+	changes := []skydb.RecordChange{}
+	for rows.Next() {
+		var (
+			change   skydb.RecordChange
+			op       string
+			recordID string
+			readable bool
+		)
+		if err := rows.Scan(&change.ID, &change.TxID, &op, &recordID, &change.ChangedAt, &readable); err != nil {
+			return nil, err
+		}
+		change.RecordID = skydb.NewRecordID(query.Type, recordID)
+		change.Operation = recordChangeOperations[op]
+		if !readable {
+			change.Operation = skydb.RecordDeleteOperation
+		}
+		change.ChangedAt = change.ChangedAt.UTC()
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.fillChangedRecords(changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// changeAccessControlSQL returns the condition of the change with the
+// alias being readable by the user.
+func (db *database) changeAccessControlSQL(alias string, user *skydb.AuthInfo) (string, []interface{}, error) {
+	factory := builder.NewPredicateSqlizerFactory(db, alias)
+	aclSqlizer, err := factory.NewAccessControlSqlizer(user, skydb.ReadLevel)
+	if err != nil {
+		return "", nil, err
+	}
+	return aclSqlizer.ToSql()
+}
+
+// checkRecordChangesPruned returns ErrRecordChangesPruned if a deletion
+// after the cursor has been pruned. Clients starting over from the zero
+// cursor miss nothing.
+func (db *database) checkRecordChangesPruned(after skydb.RecordChangeCursor) error {
+	if after == (skydb.RecordChangeCursor{}) {
+		return nil
+	}
+
+	var pruned bool
+	err := db.c.QueryRowx(fmt.Sprintf(
+		"SELECT (txid, id) > ($1, $2) FROM %s",
+		db.TableName("_record_change_pruned"),
+	), after.TxID, after.ID).Scan(&pruned)
+	if err != nil {
+		return fmt.Errorf("failed to query pruned record changes: %s", err)
+	}
+	if pruned {
+		return skydb.ErrRecordChangesPruned
+	}
+	return nil
+}
+
+// PruneRecordChanges deletes the changes superseded by a later change
+// to the same record, and deletions, made before the time. The cursor of
+// the latest deletion pruned is kept, so that a client resuming from an
+// earlier cursor, which would miss the deletion, can be told to start
+// over. A later change with access different from a pruned change is
+// kept as if it were a deletion, as a user losing access to the record
+// by that change is told of it only while an earlier change readable by
+// the user is kept.
+func (c *conn) PruneRecordChanges(before time.Time) error {
+	tableName := c.tableName("_record_change")
+	prunedTableName := c.tableName("_record_change_pruned")
+	_, err := c.Exec(fmt.Sprintf(`
+		WITH deleted AS (
+			DELETE FROM %[1]s AS c
+			WHERE c.changed_at < $1
+				AND (c.op = 'DELETE' OR EXISTS (
+					SELECT 1 FROM %[1]s AS n
+					WHERE n.record_type = c.record_type
+						AND n.record_id = c.record_id
+						AND n._database_id = c._database_id
+						AND n.id > c.id
+				))
+			RETURNING c.op, c.txid, c.id, c.record_type, c.record_id, c._database_id, c._owner_id, c._access
+		), latest AS (
+			SELECT txid, id FROM deleted
+			WHERE op = 'DELETE'
+			UNION ALL
+			SELECT n.txid, n.id FROM deleted AS d
+				JOIN %[1]s AS n
+					ON n.record_type = d.record_type
+					AND n.record_id = d.record_id
+					AND n._database_id = d._database_id
+					AND n.id > d.id
+			WHERE n._owner_id IS DISTINCT FROM d._owner_id
+				OR n._access IS DISTINCT FROM d._access
+			ORDER BY txid DESC, id DESC
+			LIMIT 1
+		)
+		UPDATE %[2]s AS p
+		SET txid = latest.txid, id = latest.id
+		FROM latest
+		WHERE (latest.txid, latest.id) > (p.txid, p.id)
+	`, tableName, prunedTableName), before.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to prune record changes: %s", err)
+	}
+	return nil
+}
+
+// fillChangedRecords fetches the current state of the records changed
+// by changes other than deletions. A record that cannot be fetched,
+// such as one moved to trash, is considered deleted.
+func (db *database) fillChangedRecords(changes []skydb.RecordChange) error {
+	ids := []skydb.RecordID{}
+	for _, change := range changes {
+		if change.Operation != skydb.RecordDeleteOperation {
+			ids = append(ids, change.RecordID)
+		}
+	}
+
+	records := map[skydb.RecordID]skydb.Record{}
+	if len(ids) > 0 {
+		rows, err := db.GetByIDs(ids)
+		if err != nil && err != skydb.ErrRecordNotFound {
+			return err
+		}
+		if err == nil {
+			for rows.Scan() {
+				record := rows.Record()
+				records[record.ID] = record
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		if change.Operation == skydb.RecordDeleteOperation {
+			continue
+		}
+		if record, ok := records[change.RecordID]; ok {
+			change.Record = &record
+		} else {
+			change.Operation = skydb.RecordDeleteOperation
+		}
+	}
+	return nil
+}
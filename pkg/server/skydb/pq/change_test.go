@@ -0,0 +1,190 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordChanges(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		save := func(key string, content string, acl skydb.RecordACL) {
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", key),
+				OwnerID: "user0",
+				ACL:     acl,
+				Data:    skydb.Data{"content": content},
+			}), ShouldBeNil)
+		}
+		save("1", "first", nil)
+		save("2", "second", nil)
+		save("1", "first updated", nil)
+		So(db.Delete(skydb.NewRecordID("note", "2")), ShouldBeNil)
+		save("private", "private", skydb.RecordACL{
+			skydb.NewRecordACLEntryDirect("user0", skydb.ReadLevel),
+		})
+
+		Convey("returns the latest change to each record", func() {
+			changes, err := db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 3)
+
+			So(changes[0].RecordID, ShouldResemble, skydb.NewRecordID("note", "1"))
+			So(changes[0].Operation, ShouldEqual, skydb.RecordUpdateOperation)
+			So(changes[0].Record.Data["content"], ShouldEqual, "first updated")
+
+			So(changes[1].RecordID, ShouldResemble, skydb.NewRecordID("note", "2"))
+			So(changes[1].Operation, ShouldEqual, skydb.RecordDeleteOperation)
+			So(changes[1].Record, ShouldBeNil)
+
+			So(changes[2].RecordID, ShouldResemble, skydb.NewRecordID("note", "private"))
+			So(changes[2].Operation, ShouldEqual, skydb.RecordCreateOperation)
+
+			Convey("after the resume token", func() {
+				after, err := db.GetRecordChanges(&skydb.Query{
+					Type:                "note",
+					BypassAccessControl: true,
+				}, changes[1].Cursor())
+				So(err, ShouldBeNil)
+				So(after, ShouldHaveLength, 1)
+				So(after[0].Cursor(), ShouldResemble, changes[2].Cursor())
+			})
+		})
+
+		Convey("filters changes by access control", func() {
+			changes, err := db.GetRecordChanges(&skydb.Query{
+				Type:       "note",
+				ViewAsUser: &skydb.AuthInfo{ID: "user1"},
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 2)
+			So(changes[0].RecordID.Key, ShouldEqual, "1")
+			So(changes[1].RecordID.Key, ShouldEqual, "2")
+		})
+
+		Convey("returns deletion of record no longer readable", func() {
+			user1 := &skydb.AuthInfo{ID: "user1"}
+			changes, err := db.GetRecordChanges(&skydb.Query{
+				Type:       "note",
+				ViewAsUser: user1,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 2)
+
+			save("1", "first revoked", skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user0", skydb.ReadLevel),
+			})
+
+			after, err := db.GetRecordChanges(&skydb.Query{
+				Type:       "note",
+				ViewAsUser: user1,
+			}, changes[1].Cursor())
+			So(err, ShouldBeNil)
+			So(after, ShouldHaveLength, 1)
+			So(after[0].RecordID.Key, ShouldEqual, "1")
+			So(after[0].Operation, ShouldEqual, skydb.RecordDeleteOperation)
+			So(after[0].Record, ShouldBeNil)
+
+			Convey("and tells clients to start over after pruning", func() {
+				So(c.PruneRecordChanges(time.Now().Add(time.Minute)), ShouldBeNil)
+
+				_, err := db.GetRecordChanges(&skydb.Query{
+					Type:       "note",
+					ViewAsUser: user1,
+				}, changes[1].Cursor())
+				So(err, ShouldEqual, skydb.ErrRecordChangesPruned)
+			})
+		})
+
+		Convey("hides changes until older transactions finish", func() {
+			tx, err := c.db.Beginx()
+			So(err, ShouldBeNil)
+			defer tx.Rollback()
+			_, err = tx.Exec("SELECT txid_current()")
+			So(err, ShouldBeNil)
+
+			save("3", "third", nil)
+
+			changes, err := db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 3)
+
+			So(tx.Rollback(), ShouldBeNil)
+			changes, err = db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 4)
+			So(changes[3].RecordID.Key, ShouldEqual, "3")
+		})
+
+		Convey("prunes superseded changes and deletions", func() {
+			changes, err := db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(changes, ShouldHaveLength, 3)
+
+			So(c.PruneRecordChanges(time.Now().Add(time.Minute)), ShouldBeNil)
+
+			var count int
+			So(c.Get(&count, "SELECT count(*) FROM _record_change"), ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			pruned, err := db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, skydb.RecordChangeCursor{})
+			So(err, ShouldBeNil)
+			So(pruned, ShouldHaveLength, 2)
+			So(pruned[0].RecordID.Key, ShouldEqual, "1")
+			So(pruned[1].RecordID.Key, ShouldEqual, "private")
+
+			_, err = db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, changes[0].Cursor())
+			So(err, ShouldEqual, skydb.ErrRecordChangesPruned)
+
+			after, err := db.GetRecordChanges(&skydb.Query{
+				Type:                "note",
+				BypassAccessControl: true,
+			}, changes[1].Cursor())
+			So(err, ShouldBeNil)
+			So(after, ShouldHaveLength, 1)
+		})
+	})
+}
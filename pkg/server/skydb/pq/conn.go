@@ -21,6 +21,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 	sq "github.com/lann/squirrel"
@@ -75,10 +76,18 @@ type ExtContext interface {
 }
 
 type conn struct {
-	db             *sqlx.DB // database wrapper
-	tx             *sqlx.Tx // transaction wrapper, nil when no transaction
+	db             *sqlx.DB    // database wrapper
+	tx             *sqlx.Tx    // transaction wrapper, nil when no transaction
+	replicas       *replicaSet // read replicas, nil when there are no replicas
+	readYourWrites bool        // whether reads are served by the primary
+
+	// cacheMutex guards the caches below, so that a connection not in a
+	// transaction can be used concurrently.
+	cacheMutex     sync.RWMutex
 	RecordSchema   map[string]skydb.RecordSchema
+	recordExpiries map[string]skydb.RecordExpiry // nil until first requested
 	FieldACL       *skydb.FieldACL
+
 	appName        string
 	option         string
 	statementCount uint64
@@ -87,6 +96,32 @@ type conn struct {
 	context        context.Context
 }
 
+// cachedRecordSchema returns the cached schema of the record type. The
+// schema is nil if the record type is cached as not existing.
+func (c *conn) cachedRecordSchema(recordType string) (skydb.RecordSchema, bool) {
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+	schema, ok := c.RecordSchema[recordType]
+	return schema, ok
+}
+
+func (c *conn) cacheRecordSchema(recordType string, schema skydb.RecordSchema) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	c.RecordSchema[recordType] = schema
+}
+
+// invalidateRecordSchema removes the cached schema of the record type.
+// The cached record expiries are removed as well when expiries is true.
+func (c *conn) invalidateRecordSchema(recordType string, expiries bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	delete(c.RecordSchema, recordType)
+	if expiries {
+		c.recordExpiries = nil
+	}
+}
+
 // Db returns the current database wrapper, or a transaction wrapper when
 // a transaction is in effect.
 func (c *conn) Db() ExtContext {
@@ -113,6 +148,43 @@ func (c *conn) Begin() error {
 	return nil
 }
 
+var _ skydb.SnapshotConn = &conn{}
+
+// BeginSnapshot begins a read-only transaction with the REPEATABLE READ
+// isolation level, in which all statements see the snapshot taken by the
+// first statement.
+func (c *conn) BeginSnapshot() error {
+	if err := c.Begin(); err != nil {
+		return err
+	}
+
+	if _, err := c.tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+		c.Rollback()
+		return err
+	}
+	return nil
+}
+
+// WithSavepoint calls do after creating a savepoint in the transaction,
+// and rolls back to the savepoint if do returns an error.
+func (c *conn) WithSavepoint(do func() error) error {
+	if c.tx == nil {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	if _, err := c.tx.Exec("SAVEPOINT skygear_savepoint"); err != nil {
+		return err
+	}
+	if err := do(); err != nil {
+		if _, rbErr := c.tx.Exec("ROLLBACK TO SAVEPOINT skygear_savepoint"); rbErr != nil {
+			log.Errorf("%p: Unable to rollback to savepoint: %v", c, rbErr)
+		}
+		return err
+	}
+	_, err := c.tx.Exec("RELEASE SAVEPOINT skygear_savepoint")
+	return err
+}
+
 // Commit commits a transaction.
 func (c *conn) Commit() error {
 	if c.tx == nil {
@@ -215,8 +287,9 @@ func (db *database) TableName(table string) string {
 
 // this ensures that our structure conform to certain interfaces.
 var (
-	_ skydb.Conn     = &conn{}
-	_ skydb.Database = &database{}
+	_ skydb.Conn        = &conn{}
+	_ skydb.ReplicaConn = &conn{}
+	_ skydb.Database    = &database{}
 
 	_ driver.Valuer = providerInfoValue{}
 )
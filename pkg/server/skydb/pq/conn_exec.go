@@ -16,6 +16,7 @@ package pq
 
 import (
 	"database/sql"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 	"github.com/jmoiron/sqlx"
@@ -23,13 +24,13 @@ import (
 )
 
 func (c *conn) Get(dest interface{}, query string, args ...interface{}) (err error) {
-	c.statementCount++
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
 	err = c.Db().GetContext(c.context, dest, query, args...)
 	logFields := logrus.Fields{
 		"sql":            query,
 		"args":           args,
 		"error":          err,
-		"executionCount": c.statementCount,
+		"executionCount": statementCount,
 	}
 	if err != nil {
 		log.WithFields(logFields).Errorln("Failed to execute SQL with sql.Get")
@@ -47,8 +48,46 @@ func (c *conn) GetWith(dest interface{}, sqlizeri sq.Sqlizer) (err error) {
 	return c.Get(dest, sql, args...)
 }
 
+// ReadGet executes a read-only query like Get, which is routed to a read
+// replica if possible. The query is retried on the primary if the replica
+// cannot be reached.
+func (c *conn) ReadGet(dest interface{}, query string, args ...interface{}) (err error) {
+	db, r := c.readDb()
+	if r == nil {
+		return c.Get(dest, query, args...)
+	}
+
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
+	err = db.GetContext(c.context, dest, query, args...)
+	logFields := logrus.Fields{
+		"sql":            query,
+		"args":           args,
+		"error":          err,
+		"executionCount": statementCount,
+		"replica":        r.index,
+	}
+	if err != nil {
+		log.WithFields(logFields).Errorln("Failed to execute SQL with sql.Get on replica")
+		if isNetworkError(err) {
+			r.setHealthy(false)
+			return c.Get(dest, query, args...)
+		}
+	} else {
+		log.WithFields(logFields).Debugln("Executed SQL successfully with sql.Get on replica")
+	}
+	return
+}
+
+func (c *conn) ReadGetWith(dest interface{}, sqlizeri sq.Sqlizer) error {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		panic(err)
+	}
+	return c.ReadGet(dest, sql, args...)
+}
+
 func (c *conn) Exec(query string, args ...interface{}) (result sql.Result, err error) {
-	c.statementCount++
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
 	result, err = c.Db().ExecContext(c.context, query, args...)
 
 	var rowsAffected int64
@@ -66,7 +105,7 @@ func (c *conn) Exec(query string, args ...interface{}) (result sql.Result, err e
 		"sql":            query,
 		"args":           args,
 		"error":          err,
-		"executionCount": c.statementCount,
+		"executionCount": statementCount,
 		"rowsAffected":   rowsAffected,
 	}
 	if err != nil {
@@ -86,13 +125,13 @@ func (c *conn) ExecWith(sqlizeri sq.Sqlizer) (sql.Result, error) {
 }
 
 func (c *conn) Queryx(query string, args ...interface{}) (rows *sqlx.Rows, err error) {
-	c.statementCount++
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
 	rows, err = c.Db().QueryxContext(c.context, query, args...)
 	logFields := logrus.Fields{
 		"sql":            query,
 		"args":           args,
 		"error":          err,
-		"executionCount": c.statementCount,
+		"executionCount": statementCount,
 	}
 	if err != nil {
 		log.WithFields(logFields).Errorln("Failed to execute SQL with sql.Queryx")
@@ -110,13 +149,51 @@ func (c *conn) QueryWith(sqlizeri sq.Sqlizer) (*sqlx.Rows, error) {
 	return c.Queryx(sql, args...)
 }
 
+// ReadQueryx executes a read-only query, which is routed to a read
+// replica if possible. The query is retried on the primary if the replica
+// cannot be reached.
+func (c *conn) ReadQueryx(query string, args ...interface{}) (rows *sqlx.Rows, err error) {
+	db, r := c.readDb()
+	if r == nil {
+		return c.Queryx(query, args...)
+	}
+
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
+	rows, err = db.QueryxContext(c.context, query, args...)
+	logFields := logrus.Fields{
+		"sql":            query,
+		"args":           args,
+		"error":          err,
+		"executionCount": statementCount,
+		"replica":        r.index,
+	}
+	if err != nil {
+		log.WithFields(logFields).Errorln("Failed to execute SQL with sql.Queryx on replica")
+		if isNetworkError(err) {
+			r.setHealthy(false)
+			return c.Queryx(query, args...)
+		}
+	} else {
+		log.WithFields(logFields).Debugln("Executed SQL successfully with sql.Queryx on replica")
+	}
+	return
+}
+
+func (c *conn) ReadQueryWith(sqlizeri sq.Sqlizer) (*sqlx.Rows, error) {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		panic(err)
+	}
+	return c.ReadQueryx(sql, args...)
+}
+
 func (c *conn) QueryRowx(query string, args ...interface{}) (row *sqlx.Row) {
-	c.statementCount++
+	statementCount := atomic.AddUint64(&c.statementCount, 1)
 	row = c.Db().QueryRowxContext(c.context, query, args...)
 	log.WithFields(logrus.Fields{
 		"sql":            query,
 		"args":           args,
-		"executionCount": c.statementCount,
+		"executionCount": statementCount,
 	}).Debugln("Executed SQL with sql.QueryRowx")
 	return
 }
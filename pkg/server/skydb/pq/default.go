@@ -0,0 +1,95 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// defaultChanged returns whether the default value of a field is changed
+// by the requested field type. A nil default does not change the default
+// value, while an empty default removes it.
+func defaultChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Default == nil {
+		return false
+	}
+	if requested.Default.IsEmpty() {
+		return !remote.Default.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Default, requested.Default)
+}
+
+// saveFieldDefault saves the default value of the field to the
+// `_record_field_default` table, or removes it if it is empty.
+func (db *database) saveFieldDefault(tx *sqlx.Tx, recordType, field string, fieldDefault *skydb.FieldDefault) error {
+	tableName := db.TableName("_record_field_default")
+	if fieldDefault.IsEmpty() {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE record_type = $1 AND record_field = $2`, tableName)
+		if _, err := tx.Exec(stmt, recordType, field); err != nil {
+			return fmt.Errorf("failed to remove default of %s.%s: %s", recordType, field, err)
+		}
+		return nil
+	}
+
+	value, err := json.Marshal(fieldDefault)
+	if err != nil {
+		return fmt.Errorf("failed to encode default of %s.%s: %s", recordType, field, err)
+	}
+
+	stmt := fmt.Sprintf(`
+INSERT INTO %s (record_type, record_field, value) VALUES ($1, $2, $3)
+ON CONFLICT (record_type, record_field) DO UPDATE SET value = EXCLUDED.value`, tableName)
+	if _, err := tx.Exec(stmt, recordType, field, value); err != nil {
+		return fmt.Errorf("failed to save default of %s.%s: %s", recordType, field, err)
+	}
+	return nil
+}
+
+// getFieldDefaults returns the default values of the fields of the
+// record type, keyed by field name.
+func (db *database) getFieldDefaults(recordType string) (map[string]*skydb.FieldDefault, error) {
+	builder := psql.Select("record_field", "value").
+		From(db.TableName("_record_field_default")).
+		Where("record_type = ?", recordType)
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	defaults := map[string]*skydb.FieldDefault{}
+	for rows.Next() {
+		var (
+			field string
+			value []byte
+		)
+		if err := rows.Scan(&field, &value); err != nil {
+			return nil, err
+		}
+
+		fieldDefault := skydb.FieldDefault{}
+		if err := json.Unmarshal(value, &fieldDefault); err != nil {
+			return nil, fmt.Errorf("failed to decode default of %s.%s: %s", recordType, field, err)
+		}
+		defaults[field] = &fieldDefault
+	}
+	return defaults, rows.Err()
+}
@@ -0,0 +1,251 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+var _ skydb.RecordExpiryDatabase = &database{}
+
+// timeNow returns the current time, it is replaced in tests.
+var timeNow = func() time.Time { return time.Now().UTC() }
+
+// recordExpiry returns the expiry of the record type. The expiries of all
+// record types are cached in the connection when first requested.
+func (db *database) recordExpiry(recordType string) (skydb.RecordExpiry, error) {
+	db.c.cacheMutex.RLock()
+	expiries := db.c.recordExpiries
+	db.c.cacheMutex.RUnlock()
+
+	if expiries == nil {
+		var err error
+		expiries, err = db.GetRecordExpiries()
+		if err != nil {
+			return skydb.RecordExpiry{}, err
+		}
+
+		db.c.cacheMutex.Lock()
+		db.c.recordExpiries = expiries
+		db.c.cacheMutex.Unlock()
+	}
+	return expiries[recordType], nil
+}
+
+// expiryCondition returns the condition selecting records that have
+// expired at the specified time if expired is true, or records that have
+// not expired otherwise.
+func expiryCondition(recordType string, expiry skydb.RecordExpiry, at time.Time, expired bool) (string, []interface{}) {
+	alias := pq.QuoteIdentifier(recordType)
+	switch {
+	case expiry.Field != "":
+		column := alias + "." + pq.QuoteIdentifier(expiry.Field)
+		if expired {
+			return column + " <= ?", []interface{}{at}
+		}
+		return fmt.Sprintf("(%s IS NULL OR %s > ?)", column, column), []interface{}{at}
+	case expiry.TTL != 0:
+		column := alias + `."_created_at"`
+		if expired {
+			return column + " <= ?", []interface{}{at.Add(-expiry.TTL)}
+		}
+		return column + " > ?", []interface{}{at.Add(-expiry.TTL)}
+	case expired:
+		return "FALSE", nil
+	}
+	return "TRUE", nil
+}
+
+// filterExpired adds condition to the select query such that expired
+// records are not selected. The query is unchanged if records of the
+// record type never expire.
+func (db *database) filterExpired(q sq.SelectBuilder, recordType string) (sq.SelectBuilder, error) {
+	expiry, err := db.recordExpiry(recordType)
+	if err != nil || expiry.IsEmpty() {
+		return q, err
+	}
+
+	condition, args := expiryCondition(recordType, expiry, timeNow(), false)
+	return q.Where(condition, args...), nil
+}
+
+func (db *database) GetRecordExpiry(recordType string) (skydb.RecordExpiry, error) {
+	expiries, err := db.GetRecordExpiries()
+	if err != nil {
+		return skydb.RecordExpiry{}, err
+	}
+	return expiries[recordType], nil
+}
+
+// SetRecordExpiry saves the expiry of the record type to the
+// `_record_expiry` table, or removes it if the expiry is empty.
+//
+// The expiry field must be a datetime field of the record type.
+func (db *database) SetRecordExpiry(recordType string, expiry skydb.RecordExpiry) error {
+	tableName := db.TableName("_record_expiry")
+	defer func() {
+		db.c.cacheMutex.Lock()
+		db.c.recordExpiries = nil
+		db.c.cacheMutex.Unlock()
+	}()
+
+	if expiry.IsEmpty() {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE record_type = $1`, tableName)
+		if _, err := db.c.Exec(stmt, recordType); err != nil {
+			return fmt.Errorf("failed to remove expiry of %s: %s", recordType, err)
+		}
+		return nil
+	}
+
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+	if len(typemap) == 0 {
+		return skyerr.NewErrorf(skyerr.ResourceNotFound, "record type %s does not exist", recordType)
+	}
+
+	var field sql.NullString
+	if expiry.Field != "" {
+		if typemap[expiry.Field].Type != skydb.TypeDateTime {
+			return skyerr.NewInvalidArgument(
+				fmt.Sprintf("expiry field %s of %s is not a datetime field", expiry.Field, recordType),
+				[]string{"field"},
+			)
+		}
+		field = sql.NullString{String: expiry.Field, Valid: true}
+	}
+
+	var ttl sql.NullInt64
+	if expiry.TTL != 0 {
+		ttl = sql.NullInt64{Int64: int64(expiry.TTL / time.Second), Valid: true}
+	}
+
+	stmt := fmt.Sprintf(`
+INSERT INTO %s (record_type, record_field, ttl_seconds) VALUES ($1, $2, $3)
+ON CONFLICT (record_type) DO UPDATE SET record_field = EXCLUDED.record_field, ttl_seconds = EXCLUDED.ttl_seconds`, tableName)
+	if _, err := db.c.Exec(stmt, recordType, field, ttl); err != nil {
+		return fmt.Errorf("failed to save expiry of %s: %s", recordType, err)
+	}
+	return nil
+}
+
+func (db *database) GetRecordExpiries() (map[string]skydb.RecordExpiry, error) {
+	builder := psql.Select("record_type", "record_field", "ttl_seconds").
+		From(db.TableName("_record_expiry"))
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expiries := map[string]skydb.RecordExpiry{}
+	for rows.Next() {
+		var (
+			recordType string
+			field      sql.NullString
+			ttl        sql.NullInt64
+		)
+		if err := rows.Scan(&recordType, &field, &ttl); err != nil {
+			return nil, err
+		}
+
+		expiries[recordType] = skydb.RecordExpiry{
+			Field: field.String,
+			TTL:   time.Duration(ttl.Int64) * time.Second,
+		}
+	}
+	return expiries, rows.Err()
+}
+
+func (db *database) QueryExpired(recordType string, at time.Time, after string, limit uint64) ([]skydb.Record, error) {
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return nil, err
+	}
+	if len(typemap) == 0 { // record type has not been created
+		return []skydb.Record{}, nil
+	}
+
+	expiry, err := db.recordExpiry(recordType)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, args := expiryCondition(recordType, expiry, at.UTC(), true)
+	q := db.selectQuery(psql.Select(), recordType, typemap).
+		Where(condition, args...).
+		OrderBy(pq.QuoteIdentifier(recordType) + `."_id"`).
+		Limit(limit)
+	if after != "" {
+		q = q.Where(pq.QuoteIdentifier(recordType)+`."_id" > ?`, after)
+	}
+	q = filterTrash(q, recordType, typemap, false)
+
+	rows, err := db.c.QueryWith(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scanner := newRecordScanner(recordType, typemap, rows)
+	records := []skydb.Record{}
+	for rows.Next() {
+		record := skydb.Record{}
+		if err := scanner.Scan(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (db *database) IsExpired(id skydb.RecordID) (bool, error) {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return false, err
+	}
+	if len(typemap) == 0 { // record type has not been created
+		return false, nil
+	}
+
+	expiry, err := db.recordExpiry(id.Type)
+	if err != nil || expiry.IsEmpty() {
+		return false, err
+	}
+
+	condition, args := expiryCondition(id.Type, expiry, timeNow(), true)
+	q := db.selectQuery(psql.Select(), id.Type, typemap).
+		Where(pq.QuoteIdentifier(id.Type)+`."_id" = ?`, id.Key).
+		Where(condition, args...)
+	q = filterTrash(q, id.Type, typemap, false)
+
+	record := skydb.Record{}
+	row := db.c.QueryRowWith(q)
+	if err := newRecordScanner(id.Type, typemap, row).Scan(&record); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
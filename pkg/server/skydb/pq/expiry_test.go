@@ -0,0 +1,150 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordExpiry(t *testing.T) {
+	Convey("Database with record expiry", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		timeNow = func() time.Time { return now }
+		defer func() { timeNow = func() time.Time { return time.Now().UTC() } }()
+
+		db := c.PrivateDB("userid").(*database)
+		_, err := db.Extend("session", skydb.RecordSchema{
+			"expire_at": skydb.FieldType{Type: skydb.TypeDateTime},
+			"content":   skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		for key, expireAt := range map[string]interface{}{
+			"expired": now.Add(-time.Minute),
+			"active":  now.Add(time.Minute),
+			"forever": nil,
+		} {
+			record := skydb.Record{
+				ID:        skydb.NewRecordID("session", key),
+				OwnerID:   "userid",
+				CreatedAt: now.Add(-time.Hour),
+				Data:      map[string]interface{}{"expire_at": expireAt},
+			}
+			So(db.Save(&record), ShouldBeNil)
+		}
+
+		query := &skydb.Query{
+			Type: "session",
+			Sorts: []skydb.Sort{{
+				Expression: skydb.Expression{Type: skydb.KeyPath, Value: "_id"},
+				Order:      skydb.Ascending,
+			}},
+		}
+
+		Convey("hides records expired at field", func() {
+			So(db.SetRecordExpiry("session", skydb.RecordExpiry{Field: "expire_at"}), ShouldBeNil)
+
+			expiry, err := db.GetRecordExpiry("session")
+			So(err, ShouldBeNil)
+			So(expiry, ShouldResemble, skydb.RecordExpiry{Field: "expire_at"})
+
+			So(db.Get(skydb.NewRecordID("session", "expired"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Get(skydb.NewRecordID("session", "active"), &skydb.Record{}), ShouldBeNil)
+
+			records, err := exhaustRows(db.Query(query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 2)
+			So(records[0].ID.Key, ShouldEqual, "active")
+			So(records[1].ID.Key, ShouldEqual, "forever")
+
+			count, err := db.QueryCount(query)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			expired, err := c.UnionDB().(*database).QueryExpired("session", now, "", 10)
+			So(err, ShouldBeNil)
+			So(len(expired), ShouldEqual, 1)
+			So(expired[0].ID.Key, ShouldEqual, "expired")
+			So(expired[0].DatabaseID, ShouldEqual, "userid")
+
+			isExpired, err := db.IsExpired(skydb.NewRecordID("session", "expired"))
+			So(err, ShouldBeNil)
+			So(isExpired, ShouldBeTrue)
+
+			isExpired, err = db.IsExpired(skydb.NewRecordID("session", "active"))
+			So(err, ShouldBeNil)
+			So(isExpired, ShouldBeFalse)
+		})
+
+		Convey("hides records expired after ttl", func() {
+			So(db.SetRecordExpiry("session", skydb.RecordExpiry{TTL: 30 * time.Minute}), ShouldBeNil)
+
+			records, err := exhaustRows(db.Query(query))
+			So(err, ShouldBeNil)
+			So(records, ShouldBeEmpty)
+
+			expired, err := db.QueryExpired("session", now, "", 2)
+			So(err, ShouldBeNil)
+			So(len(expired), ShouldEqual, 2)
+			So(expired[0].ID.Key, ShouldEqual, "active")
+			So(expired[1].ID.Key, ShouldEqual, "expired")
+
+			expired, err = db.QueryExpired("session", now, "active", 2)
+			So(err, ShouldBeNil)
+			So(len(expired), ShouldEqual, 1)
+			So(expired[0].ID.Key, ShouldEqual, "expired")
+		})
+
+		Convey("removes expiry", func() {
+			So(db.SetRecordExpiry("session", skydb.RecordExpiry{TTL: time.Minute}), ShouldBeNil)
+			So(db.SetRecordExpiry("session", skydb.RecordExpiry{}), ShouldBeNil)
+
+			expiries, err := db.GetRecordExpiries()
+			So(err, ShouldBeNil)
+			So(expiries, ShouldBeEmpty)
+
+			records, err := exhaustRows(db.Query(query))
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 3)
+		})
+
+		Convey("rejects expiry field which is not datetime", func() {
+			err := db.SetRecordExpiry("session", skydb.RecordExpiry{Field: "content"})
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
+		Convey("renames and removes expiry field with the field", func() {
+			So(db.SetRecordExpiry("session", skydb.RecordExpiry{Field: "expire_at"}), ShouldBeNil)
+			So(db.RenameSchema("session", "expire_at", "expires"), ShouldBeNil)
+
+			expiry, err := db.GetRecordExpiry("session")
+			So(err, ShouldBeNil)
+			So(expiry, ShouldResemble, skydb.RecordExpiry{Field: "expires"})
+
+			So(db.DeleteSchema("session", "expires"), ShouldBeNil)
+			expiry, err = db.GetRecordExpiry("session")
+			So(err, ShouldBeNil)
+			So(expiry.IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+var _ skydb.RecordHistoryDatabase = &database{}
+
+// historyTableName returns the name of the table keeping revisions of
+// records of the record type. The name is prefixed with an underscore so
+// that it is not mistaken as a record type.
+func (db *database) historyTableName(recordType string) string {
+	return db.TableName("_history_" + recordType)
+}
+
+// RecordHistoryEnabled returns whether the history table of the record
+// type exists.
+func (db *database) RecordHistoryEnabled(recordType string) (bool, error) {
+	var exists bool
+	err := db.c.Get(&exists, `
+	SELECT EXISTS (
+		SELECT 1
+		FROM information_schema.tables
+		WHERE table_schema = $1 AND table_name = $2
+	)
+	`, db.schemaName(), "_history_"+recordType)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SetRecordHistoryEnabled creates the history table of the record type to
+// enable history, and drops the table to disable it.
+func (db *database) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	tableName := db.historyTableName(recordType)
+	if !enabled {
+		if _, err := db.c.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, tableName)); err != nil {
+			return fmt.Errorf("failed to drop history table: %s", err)
+		}
+		return nil
+	}
+
+	stmt := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
+    id bigserial PRIMARY KEY,
+    record_id text NOT NULL,
+    database_id text NOT NULL,
+    operation text NOT NULL,
+    owner_id text,
+    access jsonb,
+    data jsonb NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    updated_by text
+);
+CREATE INDEX ON %[1]s (record_id, database_id);
+`, tableName)
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to create history table: %s", err)
+	}
+	return nil
+}
+
+func (db *database) SaveRecordRevision(revision *skydb.RecordRevision) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	data := map[string]interface{}{}
+	skyconv.MapData(revision.Data).ToMap(data)
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode revision data: %s", err)
+	}
+
+	builder := psql.Insert(db.historyTableName(revision.RecordID.Type)).
+		Columns("record_id", "database_id", "operation", "owner_id", "access", "data", "updated_at", "updated_by").
+		Values(
+			revision.RecordID.Key,
+			db.userID,
+			string(revision.Operation),
+			revision.OwnerID,
+			aclValue(revision.ACL),
+			dataBytes,
+			revision.UpdatedAt.UTC(),
+			revision.UpdaterID,
+		).
+		Suffix("RETURNING id")
+
+	if err := db.c.QueryRowWith(builder).Scan(&revision.ID); err != nil {
+		if isUndefinedTable(err) {
+			return skyerr.NewErrorf(skyerr.NotSupported, "history is not enabled for record type %s", revision.RecordID.Type)
+		}
+		return fmt.Errorf("failed to save revision of %s: %s", revision.RecordID, err)
+	}
+	return nil
+}
+
+func (db *database) HasRecordRevisions(id skydb.RecordID) (bool, error) {
+	var exists bool
+	err := db.c.Get(&exists, fmt.Sprintf(`
+	SELECT EXISTS (
+		SELECT 1
+		FROM %s
+		WHERE record_id = $1 AND database_id = $2
+	)
+	`, db.historyTableName(id.Type)), id.Key, db.userID)
+	if isUndefinedTable(err) {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (db *database) GetRecordRevisions(id skydb.RecordID) ([]skydb.RecordRevision, error) {
+	builder := db.selectRevisionQuery(id).OrderBy("id DESC")
+
+	rows, err := db.c.QueryWith(builder)
+	if isUndefinedTable(err) {
+		return []skydb.RecordRevision{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []skydb.RecordRevision{}
+	for rows.Next() {
+		revision := skydb.RecordRevision{RecordID: id}
+		if err := scanRevision(rows, &revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (db *database) GetRecordRevision(id skydb.RecordID, revisionID int64, revision *skydb.RecordRevision) error {
+	builder := db.selectRevisionQuery(id).Where("id = ?", revisionID)
+
+	*revision = skydb.RecordRevision{RecordID: id}
+	err := scanRevision(db.c.QueryRowWith(builder), revision)
+	if err == sql.ErrNoRows || isUndefinedTable(err) {
+		return skydb.ErrRevisionNotFound
+	}
+	return err
+}
+
+func (db *database) selectRevisionQuery(id skydb.RecordID) sq.SelectBuilder {
+	return psql.Select("id", "operation", "owner_id", "access", "data", "updated_at", "updated_by").
+		From(db.historyTableName(id.Type)).
+		Where("record_id = ? AND database_id = ?", id.Key, db.userID)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRevision(scanner rowScanner, revision *skydb.RecordRevision) error {
+	var (
+		operation string
+		ownerID   sql.NullString
+		access    []byte
+		data      []byte
+		updatedBy sql.NullString
+	)
+	err := scanner.Scan(&revision.ID, &operation, &ownerID, &access, &data, &revision.UpdatedAt, &updatedBy)
+	if err != nil {
+		return err
+	}
+
+	revision.Operation = skydb.RecordOperation(operation)
+	revision.OwnerID = ownerID.String
+	revision.UpdaterID = updatedBy.String
+	revision.UpdatedAt = revision.UpdatedAt.UTC()
+
+	if access != nil {
+		acl := skydb.RecordACL{}
+		if err := json.Unmarshal(access, &acl); err != nil {
+			return fmt.Errorf("failed to decode access of revision: %s", err)
+		}
+		revision.ACL = acl
+	}
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to decode data of revision: %s", err)
+	}
+	mapData := skyconv.MapData{}
+	if err := mapData.FromMap(m); err != nil {
+		return fmt.Errorf("failed to decode data of revision: %s", err)
+	}
+	revision.Data = skydb.Data(mapData)
+	return nil
+}
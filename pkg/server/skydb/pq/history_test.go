@@ -0,0 +1,115 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordHistory(t *testing.T) {
+	Convey("Database with record history enabled", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		So(db.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+
+		enabled, err := db.RecordHistoryEnabled("note")
+		So(err, ShouldBeNil)
+		So(enabled, ShouldBeTrue)
+
+		recordID := skydb.NewRecordID("note", "someid")
+		updatedAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		first := skydb.RecordRevision{
+			RecordID:  recordID,
+			Operation: skydb.RecordCreateOperation,
+			OwnerID:   "user_id",
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user_id", skydb.WriteLevel),
+			},
+			Data: skydb.Data{
+				"content":   "first",
+				"published": updatedAt,
+			},
+			UpdaterID: "user_id",
+			UpdatedAt: updatedAt,
+		}
+		So(db.SaveRecordRevision(&first), ShouldBeNil)
+		So(first.ID, ShouldNotEqual, 0)
+
+		second := skydb.RecordRevision{
+			RecordID:  recordID,
+			Operation: skydb.RecordDeleteOperation,
+			OwnerID:   "user_id",
+			Data: skydb.Data{
+				"content": "second",
+			},
+			UpdaterID: "user_id",
+			UpdatedAt: updatedAt.Add(time.Hour),
+		}
+		So(db.SaveRecordRevision(&second), ShouldBeNil)
+
+		Convey("lists revisions with latest first", func() {
+			revisions, err := db.GetRecordRevisions(recordID)
+			So(err, ShouldBeNil)
+			So(revisions, ShouldResemble, []skydb.RecordRevision{second, first})
+		})
+
+		Convey("checks whether record has revisions", func() {
+			hasRevisions, err := db.HasRecordRevisions(recordID)
+			So(err, ShouldBeNil)
+			So(hasRevisions, ShouldBeTrue)
+
+			hasRevisions, err = db.HasRecordRevisions(skydb.NewRecordID("note", "otherid"))
+			So(err, ShouldBeNil)
+			So(hasRevisions, ShouldBeFalse)
+
+			hasRevisions, err = db.HasRecordRevisions(skydb.NewRecordID("comment", "someid"))
+			So(err, ShouldBeNil)
+			So(hasRevisions, ShouldBeFalse)
+		})
+
+		Convey("gets revision", func() {
+			revision := skydb.RecordRevision{}
+			So(db.GetRecordRevision(recordID, first.ID, &revision), ShouldBeNil)
+			So(revision, ShouldResemble, first)
+
+			err := db.GetRecordRevision(recordID, second.ID+1, &revision)
+			So(err, ShouldEqual, skydb.ErrRevisionNotFound)
+		})
+
+		Convey("removes history when disabled", func() {
+			So(db.SetRecordHistoryEnabled("note", false), ShouldBeNil)
+
+			enabled, err := db.RecordHistoryEnabled("note")
+			So(err, ShouldBeNil)
+			So(enabled, ShouldBeFalse)
+
+			revisions, err := db.GetRecordRevisions(recordID)
+			So(err, ShouldBeNil)
+			So(revisions, ShouldBeEmpty)
+		})
+
+		Convey("does not list history table as record type", func() {
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			So(schemas, ShouldNotContainKey, "_history_note")
+		})
+	})
+}
@@ -0,0 +1,378 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// GetIndexesByRecordType returns all indexes of the record type, including
+// the index of the primary key.
+//
+// An index that is not valid is reported as building if the table is
+// locked by another session, as CREATE INDEX CONCURRENTLY does while
+// building the index. Otherwise the build has failed and the index is
+// reported as invalid.
+func (db *database) GetIndexesByRecordType(recordType string) (indexes map[string]skydb.Index, err error) {
+	rows, err := db.c.Queryx(`
+SELECT
+    i.relname AS index_name,
+    ix.indisunique,
+    ix.indisvalid,
+    NOT ix.indisvalid AND EXISTS (
+        SELECT 1
+        FROM pg_locks l
+        WHERE l.relation = t.oid
+            AND l.mode = 'ShareUpdateExclusiveLock'
+            AND l.granted
+            AND l.pid <> pg_backend_pid()
+    ) AS building,
+    pg_get_indexdef(i.oid) AS definition,
+    array_to_json(ARRAY(
+        SELECT COALESCE(a.attname, '')
+        FROM generate_series(1, ix.indnatts) AS k
+        LEFT JOIN pg_attribute a
+            ON a.attrelid = t.oid AND a.attnum = ix.indkey[k - 1]
+        ORDER BY k
+    )) AS column_names,
+    array_to_json(ARRAY(
+        SELECT pg_get_indexdef(i.oid, k, true)
+        FROM generate_series(1, ix.indnatts) AS k
+        ORDER BY k
+    )) AS key_definitions
+FROM
+    pg_class t
+    JOIN pg_index ix ON t.oid = ix.indrelid
+    JOIN pg_class i ON i.oid = ix.indexrelid
+    JOIN pg_namespace ns ON ns.oid = t.relnamespace
+WHERE
+    t.relkind = 'r'
+    AND ns.nspname = $1
+    AND t.relname = $2;`,
+		db.schemaName(), recordType)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	indexes = map[string]skydb.Index{}
+	for rows.Next() {
+		var (
+			name               string
+			unique             bool
+			valid              bool
+			building           bool
+			definition         string
+			columnNamesJSON    []byte
+			keyDefinitionsJSON []byte
+			columnNames        []string
+			keyDefinitions     []string
+		)
+		err = rows.Scan(&name, &unique, &valid, &building,
+			&definition, &columnNamesJSON, &keyDefinitionsJSON)
+		if err != nil {
+			return
+		}
+		if err = json.Unmarshal(columnNamesJSON, &columnNames); err != nil {
+			return
+		}
+		if err = json.Unmarshal(keyDefinitionsJSON, &keyDefinitions); err != nil {
+			return
+		}
+
+		index := skydb.Index{
+			Fields:      []string{},
+			Expressions: []skydb.IndexExpression{},
+			Unique:      unique,
+			Status:      skydb.IndexReady,
+			Definition:  definition,
+		}
+		for i, column := range columnNames {
+			if column != "" {
+				index.Fields = append(index.Fields, column)
+			} else if expr, ok := parseIndexExpression(keyDefinitions[i]); ok {
+				index.Expressions = append(index.Expressions, expr)
+			}
+		}
+		if !valid {
+			if building {
+				index.Status = skydb.IndexBuilding
+			} else {
+				index.Status = skydb.IndexInvalid
+			}
+		}
+		indexes[name] = index
+	}
+	err = rows.Err()
+
+	return
+}
+
+var indexExpressionRegexp = regexp.MustCompile(`^(\w+)\(("(?:[^"]|"")+"|\w+)\)$`)
+
+// parseIndexExpression parses the definition of an expression index key
+// created by SaveIndex.
+func parseIndexExpression(definition string) (skydb.IndexExpression, bool) {
+	matches := indexExpressionRegexp.FindStringSubmatch(definition)
+	if matches == nil {
+		return skydb.IndexExpression{}, false
+	}
+
+	function := skydb.IndexFunction(matches[1])
+	if !function.IsValid() {
+		return skydb.IndexExpression{}, false
+	}
+
+	field := matches[2]
+	if strings.HasPrefix(field, `"`) {
+		field = strings.Replace(field[1:len(field)-1], `""`, `"`, -1)
+	}
+	return skydb.IndexExpression{
+		Function: function,
+		Field:    field,
+	}, true
+}
+
+// SaveIndex creates the index on the record type.
+//
+// A unique index on fields only is created as a unique constraint.
+// Other indexes are created concurrently so that the table is not locked
+// for writes while the index is being built. If building the index fails,
+// the index is left invalid and should be deleted. Indexes cannot be built
+// concurrently in a transaction, so the table is locked in that case.
+func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	keys, err := indexKeysSQL(index)
+	if err != nil {
+		return err
+	}
+
+	var stmt string
+	if index.Unique && len(index.Expressions) == 0 && index.Predicate.IsEmpty() {
+		stmt = fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s)`,
+			db.TableName(recordType), pq.QuoteIdentifier(indexName), keys)
+		log.WithField("stmt", stmt).Debugln("Creating unique constraint")
+	} else {
+		var buf bytes.Buffer
+		buf.WriteString("CREATE ")
+		if index.Unique {
+			buf.WriteString("UNIQUE ")
+		}
+		buf.WriteString("INDEX ")
+		if db.c.tx == nil {
+			buf.WriteString("CONCURRENTLY ")
+		}
+		fmt.Fprintf(&buf, "%s ON %s (%s)",
+			pq.QuoteIdentifier(indexName), db.TableName(recordType), keys)
+		if !index.Predicate.IsEmpty() {
+			where, err := indexPredicateSQL(index.Predicate)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, " WHERE %s", where)
+		}
+		stmt = buf.String()
+		log.WithField("stmt", stmt).Debugln("Creating index")
+	}
+
+	if _, err := db.c.Exec(stmt); err != nil {
+		if isDuplicateTable(err) {
+			return skyerr.NewErrorf(skyerr.Duplicated, `index "%s" already exists`, indexName)
+		}
+		if isUndefinedTable(err) || isUndefinedColumn(err) {
+			return skyerr.NewErrorf(skyerr.ResourceNotFound, "failed to create index: %s", err.(*pq.Error).Message)
+		}
+		return fmt.Errorf("failed to create index: %s", err)
+	}
+
+	return nil
+}
+
+func indexKeysSQL(index skydb.Index) (string, error) {
+	if len(index.Fields) == 0 && len(index.Expressions) == 0 {
+		return "", skyerr.NewInvalidArgument("index has no fields or expressions", []string{"fields"})
+	}
+
+	keys := []string{}
+	for _, field := range index.Fields {
+		keys = append(keys, pq.QuoteIdentifier(field))
+	}
+	for _, expr := range index.Expressions {
+		if !expr.Function.IsValid() {
+			return "", skyerr.NewInvalidArgument(
+				fmt.Sprintf(`unknown index function "%s"`, expr.Function),
+				[]string{"expressions"},
+			)
+		}
+		keys = append(keys, fmt.Sprintf("%s(%s)", expr.Function, pq.QuoteIdentifier(expr.Field)))
+	}
+	return strings.Join(keys, ", "), nil
+}
+
+// indexPredicateSQL returns the condition of a partial index. Values are
+// written into the condition because CREATE INDEX does not accept
+// parameters, so only comparisons of fields with values of simple types
+// are supported.
+func indexPredicateSQL(p skydb.Predicate) (string, error) {
+	switch p.Operator {
+	case skydb.And, skydb.Or:
+		conditions := []string{}
+		for _, child := range p.Children {
+			condition, err := indexPredicateSQL(child.(skydb.Predicate))
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, "("+condition+")")
+		}
+		if p.Operator == skydb.And {
+			return strings.Join(conditions, " AND "), nil
+		}
+		return strings.Join(conditions, " OR "), nil
+	case skydb.Not:
+		condition, err := indexPredicateSQL(p.Children[0].(skydb.Predicate))
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + condition + ")", nil
+	}
+
+	var op string
+	switch p.Operator {
+	case skydb.Equal:
+		op = "="
+	case skydb.NotEqual:
+		op = "<>"
+	case skydb.GreaterThan:
+		op = ">"
+	case skydb.GreaterThanOrEqual:
+		op = ">="
+	case skydb.LessThan:
+		op = "<"
+	case skydb.LessThanOrEqual:
+		op = "<="
+	default:
+		return "", errUnsupportedIndexPredicate
+	}
+
+	lhs := p.Children[0].(skydb.Expression)
+	rhs := p.Children[1].(skydb.Expression)
+	if lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath {
+		lhs, rhs = rhs, lhs
+		switch op {
+		case ">":
+			op = "<"
+		case ">=":
+			op = "<="
+		case "<":
+			op = ">"
+		case "<=":
+			op = ">="
+		}
+	}
+	if lhs.Type != skydb.KeyPath || rhs.Type != skydb.Literal || len(lhs.KeyPathComponents()) != 1 {
+		return "", errUnsupportedIndexPredicate
+	}
+	column := pq.QuoteIdentifier(lhs.Value.(string))
+
+	var value string
+	switch v := rhs.Value.(type) {
+	case nil:
+		switch op {
+		case "=":
+			return column + " IS NULL", nil
+		case "<>":
+			return column + " IS NOT NULL", nil
+		default:
+			return "", errUnsupportedIndexPredicate
+		}
+	case string:
+		value = builder.QuoteLiteral(v)
+	case bool:
+		value = strconv.FormatBool(v)
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		value = builder.QuoteLiteral(v.UTC().Format(time.RFC3339Nano)) + "::timestamp without time zone"
+	default:
+		return "", errUnsupportedIndexPredicate
+	}
+	return fmt.Sprintf("%s %s %s", column, op, value), nil
+}
+
+var errUnsupportedIndexPredicate = skyerr.NewInvalidArgument(
+	"partial index only supports comparing fields with strings, numbers, booleans, dates or null",
+	[]string{"predicate"},
+)
+
+// DeleteIndex drops the index of the record type. An index created as a
+// unique constraint is dropped by dropping the constraint.
+func (db *database) DeleteIndex(recordType string, indexName string) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	var isConstraint bool
+	err := db.c.QueryRowx(`
+SELECT c.conname IS NOT NULL
+FROM pg_class t
+    JOIN pg_index ix ON t.oid = ix.indrelid
+    JOIN pg_class i ON i.oid = ix.indexrelid
+    JOIN pg_namespace ns ON ns.oid = t.relnamespace
+    LEFT JOIN pg_constraint c ON c.conindid = i.oid AND c.conrelid = t.oid
+WHERE ns.nspname = $1 AND t.relname = $2 AND i.relname = $3`,
+		db.schemaName(), recordType, indexName).Scan(&isConstraint)
+	if err == sql.ErrNoRows {
+		return skydb.ErrIndexNotFound
+	} else if err != nil {
+		return err
+	}
+
+	var stmt string
+	if isConstraint {
+		stmt = fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT %s`,
+			db.TableName(recordType), pq.QuoteIdentifier(indexName))
+		log.WithField("stmt", stmt).Debugln("Dropping unique constraint")
+	} else {
+		concurrently := "CONCURRENTLY "
+		if db.c.tx != nil {
+			concurrently = ""
+		}
+		stmt = fmt.Sprintf(`DROP INDEX %s%s.%s`, concurrently,
+			pq.QuoteIdentifier(db.schemaName()), pq.QuoteIdentifier(indexName))
+		log.WithField("stmt", stmt).Debugln("Dropping index")
+	}
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to delete index: %s", err)
+	}
+
+	return nil
+}
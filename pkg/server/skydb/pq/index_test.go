@@ -0,0 +1,207 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndex(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB().(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"category": skydb.FieldType{Type: skydb.TypeString},
+			"order":    skydb.FieldType{Type: skydb.TypeNumber},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("creates composite index", func() {
+			err := db.SaveIndex("note", "note_category_order", skydb.Index{
+				Fields: []string{"category", "order"},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldContainKey, "note_category_order")
+			index := indexes["note_category_order"]
+			So(index.Fields, ShouldResemble, []string{"category", "order"})
+			So(index.Unique, ShouldBeFalse)
+			So(index.Status, ShouldEqual, skydb.IndexReady)
+		})
+
+		Convey("creates unique index as constraint", func() {
+			err := db.SaveIndex("note", "note_title_key", skydb.Index{
+				Fields: []string{"title"},
+				Unique: true,
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes["note_title_key"].Unique, ShouldBeTrue)
+
+			So(db.DeleteIndex("note", "note_title_key"), ShouldBeNil)
+			indexes, err = db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldNotContainKey, "note_title_key")
+		})
+
+		Convey("creates partial expression index", func() {
+			err := db.SaveIndex("note", "note_lower_title", skydb.Index{
+				Expressions: []skydb.IndexExpression{
+					{Function: skydb.IndexLowerFunction, Field: "title"},
+				},
+				Unique: true,
+				Predicate: skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "category"},
+						skydb.Expression{Type: skydb.Literal, Value: "it's"},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			index := indexes["note_lower_title"]
+			So(index.Fields, ShouldBeEmpty)
+			So(index.Expressions, ShouldResemble, []skydb.IndexExpression{
+				{Function: skydb.IndexLowerFunction, Field: "title"},
+			})
+			So(index.Unique, ShouldBeTrue)
+			So(index.Definition, ShouldContainSubstring, "WHERE")
+
+			So(db.DeleteIndex("note", "note_lower_title"), ShouldBeNil)
+		})
+
+		Convey("reports failed index as invalid", func() {
+			So(db.Save(&skydb.Record{
+				ID:   skydb.NewRecordID("note", "1"),
+				Data: skydb.Data{"title": "Hello"},
+			}), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:   skydb.NewRecordID("note", "2"),
+				Data: skydb.Data{"title": "hello"},
+			}), ShouldBeNil)
+
+			err := db.SaveIndex("note", "note_lower_title", skydb.Index{
+				Expressions: []skydb.IndexExpression{
+					{Function: skydb.IndexLowerFunction, Field: "title"},
+				},
+				Unique: true,
+			})
+			So(err, ShouldNotBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes["note_lower_title"].Status, ShouldEqual, skydb.IndexInvalid)
+		})
+
+		Convey("returns error when deleting non-existent index", func() {
+			err := db.DeleteIndex("note", "note_not_exist")
+			So(err, ShouldEqual, skydb.ErrIndexNotFound)
+		})
+	})
+}
+
+func TestIndexPredicateSQL(t *testing.T) {
+	keyPath := func(key string) skydb.Expression {
+		return skydb.Expression{Type: skydb.KeyPath, Value: key}
+	}
+	literal := func(value interface{}) skydb.Expression {
+		return skydb.Expression{Type: skydb.Literal, Value: value}
+	}
+
+	Convey("indexPredicateSQL", t, func() {
+		Convey("writes comparisons with values", func() {
+			sql, err := indexPredicateSQL(skydb.Predicate{
+				Operator: skydb.And,
+				Children: []interface{}{
+					skydb.Predicate{
+						Operator: skydb.Equal,
+						Children: []interface{}{keyPath("title"), literal(`it's \o/`)},
+					},
+					skydb.Predicate{
+						Operator: skydb.GreaterThan,
+						Children: []interface{}{literal(int64(1)), keyPath("order")},
+					},
+					skydb.Predicate{
+						Operator: skydb.Not,
+						Children: []interface{}{
+							skydb.Predicate{
+								Operator: skydb.Equal,
+								Children: []interface{}{keyPath("deleted"), literal(nil)},
+							},
+						},
+					},
+					skydb.Predicate{
+						Operator: skydb.LessThanOrEqual,
+						Children: []interface{}{
+							keyPath("published_at"),
+							literal(time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)),
+						},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `("title" = E'it''s \\o/') AND `+
+				`("order" < 1) AND `+
+				`(NOT ("deleted" IS NULL)) AND `+
+				`("published_at" <= '2017-01-02T03:04:05Z'::timestamp without time zone)`)
+		})
+
+		Convey("rejects unsupported predicate", func() {
+			_, err := indexPredicateSQL(skydb.Predicate{
+				Operator: skydb.Like,
+				Children: []interface{}{keyPath("title"), literal("a%")},
+			})
+			So(err, ShouldNotBeNil)
+
+			_, err = indexPredicateSQL(skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{keyPath("title"), literal([]interface{}{"a"})},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseIndexExpression(t *testing.T) {
+	Convey("parseIndexExpression", t, func() {
+		expr, ok := parseIndexExpression(`lower("camelCase")`)
+		So(ok, ShouldBeTrue)
+		So(expr, ShouldResemble, skydb.IndexExpression{
+			Function: skydb.IndexLowerFunction,
+			Field:    "camelCase",
+		})
+
+		expr, ok = parseIndexExpression(`upper(title)`)
+		So(ok, ShouldBeTrue)
+		So(expr.Field, ShouldEqual, "title")
+
+		_, ok = parseIndexExpression(`to_tsvector('english'::regconfig, content)`)
+		So(ok, ShouldBeFalse)
+	})
+}
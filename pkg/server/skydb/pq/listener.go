@@ -31,6 +31,9 @@ import (
 
 var subscribeListenOnce sync.Once
 var appEventChannelsMap map[string][]chan skydb.RecordEvent
+var eventLossChannels []chan struct{}
+
+var _ skydb.RecordEventLossConn = &conn{}
 
 // Assume all app resist on one Database
 func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
@@ -46,6 +49,22 @@ func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
 	return nil
 }
 
+// SubscribeEventLoss implements skydb.RecordEventLossConn. As all apps
+// share one listener, the channel receives a value whenever the listener
+// is disconnected or reconnected.
+func (c *conn) SubscribeEventLoss(ch chan struct{}) error {
+	eventLossChannels = append(eventLossChannels, ch)
+	return nil
+}
+
+func emitEventLoss() {
+	for _, channel := range eventLossChannels {
+		go func(ch chan struct{}) {
+			ch <- struct{}{}
+		}(channel)
+	}
+}
+
 func emit(n *notification) {
 	channels := appEventChannelsMap[n.AppName]
 	for _, channel := range channels {
@@ -61,10 +80,16 @@ func emit(n *notification) {
 // the channel to listen for record changes
 const recordChangeChannel = "record_change"
 
+// the layout of timestamp in the JSON representation of a row
+const notificationTimestampLayout = "2006-01-02T15:04:05.999999"
+
 type notification struct {
 	AppName     string
 	ChangeEvent skydb.RecordHookEvent
 	Record      skydb.Record
+
+	// Ignored is true if the change is not to be emitted
+	Ignored bool
 }
 
 type rawNotification struct {
@@ -93,6 +118,13 @@ func (l *recordListener) Listen() {
 		} else {
 			log.WithField("event", event).Infof("pq/listener: Received an event")
 		}
+
+		// Notifications sent while the listener is not connected are
+		// not received.
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventReconnected, pq.ListenerEventConnectionAttemptFailed:
+			emitEventLoss()
+		}
 	}
 
 	listener := pq.NewListener(
@@ -126,7 +158,9 @@ func (l *recordListener) Listen() {
 				continue
 			}
 
-			emit(&n)
+			if !n.Ignored {
+				emit(&n)
+			}
 
 			l.deleteNotification(pqNotification.Extra)
 		case <-time.After(60 * time.Second):
@@ -203,6 +237,19 @@ func parseNotification(raw *rawNotification, n *notification) error {
 	}
 	n.Record.ID.Type = raw.RecordType
 
+	// Moving a record to trash is a deletion as far as the record
+	// subscribers are concerned, and purging a record in trash is not
+	// a change to them. Restoring a record from trash is reported as
+	// an INSERT by the trigger, which is a creation to them.
+	if !n.Record.DeletedAt.IsZero() {
+		switch n.ChangeEvent {
+		case skydb.RecordUpdated:
+			n.ChangeEvent = skydb.RecordDeleted
+		case skydb.RecordDeleted:
+			n.Ignored = true
+		}
+	}
+
 	return nil
 }
 
@@ -238,11 +285,20 @@ func parseRecordData(data []byte, record *skydb.Record) error {
 	recordID, _ := recordData["_id"].(string)
 	rawDatabaseID, _ := recordData["_database_id"].(string)
 	rawOwnerID, _ := recordData["_owner_id"].(string)
+	rawDeletedAt, _ := recordData["_deleted_at"].(string)
 
 	if recordID == "" || rawOwnerID == "" {
 		return errors.New(`missing key "_id" or "_owner_id"`)
 	}
 
+	if rawDeletedAt != "" {
+		deletedAt, err := time.Parse(notificationTimestampLayout, rawDeletedAt)
+		if err != nil {
+			return fmt.Errorf("invalid _deleted_at: %v", err)
+		}
+		record.DeletedAt = deletedAt
+	}
+
 	for key := range recordData {
 		if key[0] == '_' {
 			delete(recordData, key)
@@ -0,0 +1,103 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_5c7e2b9d0f14 struct {
+}
+
+func (r *revision_5c7e2b9d0f14) Version() string {
+	return "5c7e2b9d0f14"
+}
+
+func (r *revision_5c7e2b9d0f14) Up(tx *sqlx.Tx) error {
+	// Referential actions are moved from the foreign keys to
+	// _record_field_reference, and are performed by the server
+	stmt := `
+CREATE TABLE _record_field_reference (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    on_delete text NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+DO $$
+DECLARE
+    fk record;
+BEGIN
+    FOR fk IN
+        SELECT tc.table_name, tc.constraint_name, kcu.column_name,
+            ccu.table_name AS referenced_table, ccu.column_name AS referenced_column,
+            rc.delete_rule
+        FROM information_schema.table_constraints AS tc
+            JOIN information_schema.key_column_usage AS kcu
+                ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+            JOIN information_schema.constraint_column_usage AS ccu
+                ON ccu.constraint_name = tc.constraint_name AND ccu.constraint_schema = tc.table_schema
+            JOIN information_schema.referential_constraints AS rc
+                ON rc.constraint_name = tc.constraint_name AND rc.constraint_schema = tc.table_schema
+        WHERE tc.constraint_type = 'FOREIGN KEY'
+            AND tc.table_schema = current_schema()
+            AND tc.table_name NOT LIKE '\_%'
+            AND ccu.table_name <> '_asset'
+            AND rc.delete_rule IN ('CASCADE', 'SET NULL', 'RESTRICT')
+    LOOP
+        INSERT INTO _record_field_reference (record_type, record_field, on_delete)
+        VALUES (fk.table_name, fk.column_name, replace(lower(fk.delete_rule), ' ', '_'));
+        EXECUTE format(
+            'ALTER TABLE %I DROP CONSTRAINT %I, ADD CONSTRAINT %I FOREIGN KEY (%I) REFERENCES %I (%I)',
+            fk.table_name, fk.constraint_name, fk.constraint_name,
+            fk.column_name, fk.referenced_table, fk.referenced_column);
+    END LOOP;
+END $$;
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_5c7e2b9d0f14) Down(tx *sqlx.Tx) error {
+	stmt := `
+DO $$
+DECLARE
+    fk record;
+BEGIN
+    FOR fk IN
+        SELECT tc.table_name, tc.constraint_name, kcu.column_name,
+            ccu.table_name AS referenced_table, ccu.column_name AS referenced_column,
+            ref.on_delete
+        FROM information_schema.table_constraints AS tc
+            JOIN information_schema.key_column_usage AS kcu
+                ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+            JOIN information_schema.constraint_column_usage AS ccu
+                ON ccu.constraint_name = tc.constraint_name AND ccu.constraint_schema = tc.table_schema
+            JOIN _record_field_reference AS ref
+                ON ref.record_type = tc.table_name AND ref.record_field = kcu.column_name
+        WHERE tc.constraint_type = 'FOREIGN KEY'
+            AND tc.table_schema = current_schema()
+    LOOP
+        EXECUTE format(
+            'ALTER TABLE %I DROP CONSTRAINT %I, ADD CONSTRAINT %I FOREIGN KEY (%I) REFERENCES %I (%I) ON DELETE %s',
+            fk.table_name, fk.constraint_name, fk.constraint_name,
+            fk.column_name, fk.referenced_table, fk.referenced_column,
+            upper(replace(fk.on_delete, '_', ' ')));
+    END LOOP;
+END $$;
+DROP TABLE _record_field_reference;
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
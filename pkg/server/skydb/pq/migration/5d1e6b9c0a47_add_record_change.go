@@ -0,0 +1,94 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_5d1e6b9c0a47 struct {
+}
+
+func (r *revision_5d1e6b9c0a47) Version() string {
+	return "5d1e6b9c0a47"
+}
+
+func (r *revision_5d1e6b9c0a47) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _record_change (
+	id bigserial PRIMARY KEY,
+	op text NOT NULL,
+	record_type text NOT NULL,
+	record_id text NOT NULL,
+	_database_id text NOT NULL,
+	_owner_id text,
+	_access jsonb,
+	changed_at timestamp without time zone NOT NULL DEFAULT (now() AT TIME ZONE 'UTC')
+);
+CREATE INDEX ON _record_change (record_type, record_id, _database_id, id);
+CREATE OR REPLACE FUNCTION public.log_record_change() RETURNS TRIGGER AS $$
+	DECLARE
+		affected_record RECORD;
+	BEGIN
+		IF (TG_OP = 'DELETE') THEN
+			affected_record := OLD;
+		ELSE
+			affected_record := NEW;
+		END IF;
+		EXECUTE format('INSERT INTO %I._record_change (op, record_type, record_id, _database_id, _owner_id, _access) VALUES ($1, $2, $3, $4, $5, $6)', TG_TABLE_SCHEMA)
+			USING TG_OP, TG_TABLE_NAME, affected_record._id, affected_record._database_id, affected_record._owner_id, affected_record._access;
+		RETURN affected_record;
+	END;
+$$ LANGUAGE plpgsql;
+DO $$
+	DECLARE
+		record_table text;
+	BEGIN
+		FOR record_table IN
+			SELECT DISTINCT event_object_table
+			FROM information_schema.triggers
+			WHERE trigger_schema = current_schema()
+				AND trigger_name = 'trigger_notify_record_change'
+		LOOP
+			EXECUTE format('CREATE TRIGGER trigger_log_record_change AFTER INSERT OR UPDATE OR DELETE ON %I FOR EACH ROW EXECUTE PROCEDURE public.log_record_change()', record_table);
+		END LOOP;
+	END;
+$$;
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_5d1e6b9c0a47) Down(tx *sqlx.Tx) error {
+	stmt := `
+DO $$
+	DECLARE
+		record_table text;
+	BEGIN
+		FOR record_table IN
+			SELECT DISTINCT event_object_table
+			FROM information_schema.triggers
+			WHERE trigger_schema = current_schema()
+				AND trigger_name = 'trigger_log_record_change'
+		LOOP
+			EXECUTE format('DROP TRIGGER trigger_log_record_change ON %I', record_table);
+		END LOOP;
+	END;
+$$;
+DROP TABLE _record_change;
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,50 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_b83e5f2a6c19 struct {
+}
+
+func (r *revision_b83e5f2a6c19) Version() string {
+	return "b83e5f2a6c19"
+}
+
+func (r *revision_b83e5f2a6c19) Up(tx *sqlx.Tx) error {
+	stmt := `
+ALTER TABLE _record_change ADD COLUMN txid bigint NOT NULL DEFAULT txid_current();
+CREATE INDEX ON _record_change (record_type, txid, id);
+CREATE INDEX ON _record_change (changed_at);
+CREATE TABLE _record_change_pruned (
+	txid bigint NOT NULL,
+	id bigint NOT NULL
+);
+INSERT INTO _record_change_pruned (txid, id) VALUES (0, 0);
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_b83e5f2a6c19) Down(tx *sqlx.Tx) error {
+	stmt := `
+DROP TABLE _record_change_pruned;
+DROP INDEX _record_change_changed_at_idx;
+ALTER TABLE _record_change DROP COLUMN txid;
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
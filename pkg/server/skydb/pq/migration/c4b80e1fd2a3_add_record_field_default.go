@@ -0,0 +1,45 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_c4b80e1fd2a3 struct {
+}
+
+func (r *revision_c4b80e1fd2a3) Version() string {
+	return "c4b80e1fd2a3"
+}
+
+func (r *revision_c4b80e1fd2a3) Up(tx *sqlx.Tx) error {
+	stmt := `
+CREATE TABLE _record_field_default (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    value jsonb NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_c4b80e1fd2a3) Down(tx *sqlx.Tx) error {
+	stmt := `DROP TABLE _record_field_default;`
+
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -25,7 +25,7 @@ import (
 type fullMigration struct {
 }
 
-func (r *fullMigration) Version() string { return "81beb4d8658c" }
+func (r *fullMigration) Version() string { return "5c7e2b9d0f14" }
 
 func (r *fullMigration) createTable(tx *sqlx.Tx) error {
 	const stmt = `
@@ -41,20 +41,41 @@ CREATE TABLE IF NOT EXISTS public.pending_notification (
 CREATE OR REPLACE FUNCTION public.notify_record_change() RETURNS TRIGGER AS $$
 	DECLARE
 		affected_record RECORD;
+		op text;
 		inserted_id integer;
 	BEGIN
+		op := TG_OP;
 		IF (TG_OP = 'DELETE') THEN
 			affected_record := OLD;
 		ELSE
 			affected_record := NEW;
 		END IF;
+		IF (TG_OP = 'UPDATE'
+			AND row_to_json(OLD)::jsonb->>'_deleted_at' IS NOT NULL
+			AND row_to_json(NEW)::jsonb->>'_deleted_at' IS NULL) THEN
+			op := 'INSERT';
+		END IF;
 		INSERT INTO public.pending_notification (op, appname, recordtype, record)
-			VALUES (TG_OP, TG_TABLE_SCHEMA, TG_TABLE_NAME, row_to_json(affected_record)::jsonb)
+			VALUES (op, TG_TABLE_SCHEMA, TG_TABLE_NAME, row_to_json(affected_record)::jsonb)
 			RETURNING id INTO inserted_id;
 		PERFORM pg_notify('record_change', inserted_id::TEXT);
 		RETURN affected_record;
 	END;
 $$ LANGUAGE plpgsql;
+CREATE OR REPLACE FUNCTION public.log_record_change() RETURNS TRIGGER AS $$
+	DECLARE
+		affected_record RECORD;
+	BEGIN
+		IF (TG_OP = 'DELETE') THEN
+			affected_record := OLD;
+		ELSE
+			affected_record := NEW;
+		END IF;
+		EXECUTE format('INSERT INTO %I._record_change (op, record_type, record_id, _database_id, _owner_id, _access) VALUES ($1, $2, $3, $4, $5, $6)', TG_TABLE_SCHEMA)
+			USING TG_OP, TG_TABLE_NAME, affected_record._id, affected_record._database_id, affected_record._owner_id, affected_record._access;
+		RETURN affected_record;
+	END;
+$$ LANGUAGE plpgsql;
 
 CREATE TABLE _auth (
 	id text PRIMARY KEY,
@@ -110,6 +131,25 @@ CREATE TABLE _follow (
 	right_id text REFERENCES _auth (id) NOT NULL,
 	PRIMARY KEY(left_id, right_id)
 );
+CREATE TABLE _record_change (
+	id bigserial PRIMARY KEY,
+	op text NOT NULL,
+	record_type text NOT NULL,
+	record_id text NOT NULL,
+	_database_id text NOT NULL,
+	_owner_id text,
+	_access jsonb,
+	changed_at timestamp without time zone NOT NULL DEFAULT (now() AT TIME ZONE 'UTC'),
+	txid bigint NOT NULL DEFAULT txid_current()
+);
+CREATE INDEX ON _record_change (record_type, record_id, _database_id, id);
+CREATE INDEX ON _record_change (record_type, txid, id);
+CREATE INDEX ON _record_change (changed_at);
+CREATE TABLE _record_change_pruned (
+	txid bigint NOT NULL,
+	id bigint NOT NULL
+);
+INSERT INTO _record_change_pruned (txid, id) VALUES (0, 0);
 CREATE TABLE _record_creation (
     record_type text NOT NULL,
     role_id text,
@@ -133,6 +173,29 @@ CREATE TABLE _record_field_access (
     discoverable boolean NOT NULL,
     PRIMARY KEY (record_type, record_field, user_role)
 );
+CREATE TABLE _record_field_validation (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    rules jsonb NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_field_default (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    value jsonb NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_field_reference (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    on_delete text NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_expiry (
+    record_type text PRIMARY KEY,
+    record_field text,
+    ttl_seconds bigint
+);
 CREATE TABLE "user" (
     _id text,
     _database_id text,
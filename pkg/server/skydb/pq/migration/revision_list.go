@@ -39,4 +39,11 @@ var revisions = []Revision{
 	&revision_cc97afd25016{},
 	&revision_83f549ff247b{},
 	&revision_81beb4d8658c{},
+	&revision_5d1e6b9c0a47{},
+	&revision_a37f2c9d61e8{},
+	&revision_c4b80e1fd2a3{},
+	&revision_e91d4c7a2b35{},
+	&revision_7a3f0d1c94e2{},
+	&revision_b83e5f2a6c19{},
+	&revision_5c7e2b9d0f14{},
 }
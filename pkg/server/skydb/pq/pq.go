@@ -66,6 +66,16 @@ func isUndefinedTable(err error) bool {
 	return false
 }
 
+func isUndefinedColumn(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "42703"
+}
+
+func isDuplicateTable(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "42P07"
+}
+
 func isNetworkError(err error) bool {
 	_, ok := err.(*net.OpError)
 	return ok
@@ -80,9 +90,14 @@ func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, co
 	if accessModel == skydb.RelationBasedAccess {
 		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
 	}
+	replicas, err := getReplicaSet(connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replica connection: %s", err)
+	}
 
 	return &conn{
 		db:           db,
+		replicas:     replicas,
 		RecordSchema: map[string]skydb.RecordSchema{},
 		appName:      appName,
 		option:       connString,
@@ -33,6 +33,10 @@ import (
 )
 
 func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	return db.get(id, record, false)
+}
+
+func (db *database) get(id skydb.RecordID, record *skydb.Record, trashed bool) error {
 	typemap, err := db.RemoteColumnTypes(id.Type)
 	if err != nil {
 		return err
@@ -42,7 +46,17 @@ func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
 		return skydb.ErrRecordNotFound
 	}
 
+	if trashed && !softDeleteEnabled(typemap) {
+		return skydb.ErrRecordNotFound
+	}
+
 	builder := db.selectQuery(psql.Select(), id.Type, typemap).Where("_id = ?", id.Key)
+	builder = filterTrash(builder, id.Type, typemap, trashed)
+	if !trashed {
+		if builder, err = db.filterExpired(builder, id.Type); err != nil {
+			return err
+		}
+	}
 	row := db.c.QueryRowWith(builder)
 	if err := newRecordScanner(id.Type, typemap, row).Scan(record); err == sql.ErrNoRows {
 		return skydb.ErrRecordNotFound
@@ -84,7 +98,11 @@ func (db *database) GetByIDs(ids []skydb.RecordID) (*skydb.Rows, error) {
 	inCause, inArgs := builder.LiteralToSQLOperand(idStrs)
 	query := db.selectQuery(psql.Select(), recordType, typemap).
 		Where(pq.QuoteIdentifier("_id")+" IN "+inCause, inArgs...)
-	rows, err := db.c.QueryWith(query)
+	query = filterTrash(query, recordType, typemap, false)
+	if query, err = db.filterExpired(query, recordType); err != nil {
+		return nil, err
+	}
+	rows, err := db.c.ReadQueryWith(query)
 	if err != nil {
 		log.Debugf("Getting records by ID failed %v", err)
 		return nil, err
@@ -122,19 +140,18 @@ func (db *database) Save(record *skydb.Record) error {
 		return err
 	}
 
-	wrappers := map[string]func(string) string{}
-	for column, fieldType := range typemap {
-		if fieldType.Type == skydb.TypeGeometry {
-			wrappers[column] = func(val string) string {
-				return fmt.Sprintf("ST_GeomFromGeoJSON(%s)", val)
-			}
-		}
+	data, wrappers, updateWrappers, err := saveDataWithWrappers(record, typemap)
+	if err != nil {
+		return err
 	}
 
-	upsert := builder.UpsertQueryWithWrappers(db.TableName(record.ID.Type), pkData, convert(record), wrappers).
+	upsert := builder.UpsertQueryWithWrappers(db.TableName(record.ID.Type), pkData, data, wrappers).
 		IgnoreKeyOnUpdate("_owner_id").
 		IgnoreKeyOnUpdate("_created_at").
 		IgnoreKeyOnUpdate("_created_by")
+	for column, wrapper := range updateWrappers {
+		upsert = upsert.WrapOnUpdate(column, wrapper)
+	}
 
 	// record type is empty in the following statement because upsert
 	// only concerns with one record type, and that specifying the
@@ -163,6 +180,192 @@ func (db *database) Save(record *skydb.Record) error {
 	return nil
 }
 
+// SaveIfUnchanged updates the record only if it was last updated at the
+// specified time.
+//
+// The condition is checked by the UPDATE statement itself, so that
+// a concurrent update between the check and the update is not possible.
+func (db *database) SaveIfUnchanged(record *skydb.Record, updatedAt time.Time) error {
+	if record.ID.Key == "" {
+		return errors.New("db.save: got empty record id")
+	}
+	if record.ID.Type == "" {
+		return fmt.Errorf("db.save %s: got empty record type", record.ID.Key)
+	}
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return err
+	}
+	if len(typemap) == 0 {
+		return skydb.ErrRecordConflict
+	}
+
+	pkData := map[string]interface{}{
+		"_id":          record.ID.Key,
+		"_database_id": db.userID,
+	}
+	conditions := map[string]interface{}{
+		"_updated_at": updatedAt.UTC(),
+	}
+	data, wrappers, updateWrappers, err := saveDataWithWrappers(record, typemap)
+	if err != nil {
+		return err
+	}
+
+	update := builder.UpdateQueryWithWrappers(db.TableName(record.ID.Type), pkData, conditions, data, wrappers).
+		IgnoreKeyOnUpdate("_owner_id").
+		IgnoreKeyOnUpdate("_created_at").
+		IgnoreKeyOnUpdate("_created_by")
+	for column, wrapper := range updateWrappers {
+		update = update.WrapOnUpdate(column, wrapper)
+	}
+	for column, sqlizer := range columnSqlizersForSelect("", typemap) {
+		update = update.SelectColumn(column, sqlizer)
+	}
+
+	if err := db.preSave(typemap, record); err != nil {
+		return err
+	}
+
+	row := db.c.QueryRowWith(update)
+	if err = newRecordScanner(record.ID.Type, typemap, row).Scan(record); err != nil {
+		if err == sql.ErrNoRows {
+			return skydb.ErrRecordConflict
+		}
+		if isInvalidInputSyntax(err) {
+			return skyerr.NewErrorf(
+				skyerr.InvalidArgument,
+				"failed to save %s: %s", record.ID, err,
+			)
+		}
+		return skyerr.MakeError(err)
+	}
+
+	record.DatabaseID = db.userID
+	return nil
+}
+
+var _ skydb.ConditionalSaveDatabase = &database{}
+
+// saveDataWithWrappers returns the column values of a record to be saved,
+// and the wrappers of the values that convert them to the column types.
+//
+// Fields with skydb.FieldOperation are saved with the operand as the
+// value. The update wrappers of these fields perform the operation on the
+// existing value of the column, while the wrappers compute the value of
+// the field of a new record.
+func saveDataWithWrappers(record *skydb.Record, typemap skydb.RecordSchema) (map[string]interface{}, map[string]func(string) string, map[string]func(string) string, error) {
+	data := convert(record)
+	wrappers := map[string]func(string) string{}
+	updateWrappers := map[string]func(string) string{}
+	for column, fieldType := range typemap {
+		if fieldType.Type == skydb.TypeGeometry {
+			// A location saved to a geometry column is converted to
+			// a point in GeoJSON.
+			if location, ok := record.Data[column].(skydb.Location); ok {
+				data[column] = geometryValue(location.Geometry())
+			}
+			wrappers[column] = func(val string) string {
+				return fmt.Sprintf("ST_GeomFromGeoJSON(%s)", val)
+			}
+		} else if fieldType.Type == skydb.TypeList {
+			elementType := fieldType.ElementType
+			wrappers[column] = func(val string) string {
+				return listValueSQL(val, elementType)
+			}
+		}
+	}
+
+	for column, value := range record.Data {
+		op, ok := value.(skydb.FieldOperation)
+		if !ok {
+			continue
+		}
+
+		insert, update, err := fieldOperationWrappers(column, typemap[column], op)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		wrappers[column] = insert
+		updateWrappers[column] = update
+	}
+
+	return data, wrappers, updateWrappers, nil
+}
+
+// fieldOperationWrappers returns the wrappers of the operand of a field
+// operation, which compute the value of the column for a new row and for
+// an existing row respectively.
+func fieldOperationWrappers(column string, fieldType skydb.FieldType, op skydb.FieldOperation) (insert func(string) string, update func(string) string, err error) {
+	quotedColumn := pq.QuoteIdentifier(column)
+
+	switch {
+	case op.Operator == skydb.IncrementOperator && (fieldType.Type == skydb.TypeNumber || fieldType.Type == skydb.TypeInteger):
+		castType := pqDataType(fieldType.Type)
+		insert = func(val string) string {
+			return fmt.Sprintf("%s::%s", val, castType)
+		}
+		update = func(val string) string {
+			return fmt.Sprintf("COALESCE(%s, 0) + %s::%s", quotedColumn, val, castType)
+		}
+	case op.Operator == skydb.AppendOperator && fieldType.Type == skydb.TypeList:
+		elementType := fieldType.ElementType
+		insert = func(val string) string {
+			return listValueSQL(val, elementType)
+		}
+		update = func(val string) string {
+			return fmt.Sprintf("COALESCE(%s, '{}') || %s", quotedColumn, listValueSQL(val, elementType))
+		}
+	case op.Operator == skydb.AppendOperator && fieldType.Type == skydb.TypeJSON:
+		insert = func(val string) string {
+			return fmt.Sprintf("%s::jsonb", val)
+		}
+		update = func(val string) string {
+			return fmt.Sprintf("COALESCE(%s, '[]'::jsonb) || %s::jsonb", quotedColumn, val)
+		}
+	case op.Operator == skydb.RemoveOperator && fieldType.Type == skydb.TypeList:
+		elementType := fieldType.ElementType
+		removeFrom := func(list string, val string) string {
+			return fmt.Sprintf(
+				"ARRAY(SELECT e.v FROM unnest(%s) AS e(v) WHERE NOT (e.v = ANY(%s)))",
+				list,
+				listValueSQL(val, elementType),
+			)
+		}
+		insert = func(val string) string {
+			return removeFrom(fmt.Sprintf("'{}'::%s", pqFieldType(fieldType)), val)
+		}
+		update = func(val string) string {
+			return removeFrom(quotedColumn, val)
+		}
+	case op.Operator == skydb.RemoveOperator && fieldType.Type == skydb.TypeJSON:
+		removeFrom := func(list string, val string) string {
+			return fmt.Sprintf(
+				"(SELECT COALESCE(jsonb_agg(e.v), '[]'::jsonb) FROM jsonb_array_elements(COALESCE(%s, '[]'::jsonb)) AS e(v) "+
+					"WHERE e.v NOT IN (SELECT jsonb_array_elements(%s::jsonb)))",
+				list,
+				val,
+			)
+		}
+		insert = func(val string) string {
+			return removeFrom("NULL", val)
+		}
+		update = func(val string) string {
+			return removeFrom(quotedColumn, val)
+		}
+	default:
+		err = skyerr.NewInvalidArgument(
+			fmt.Sprintf("cannot apply %s to field %s of type %s", op.Operator, column, fieldType.ToSimpleName()),
+			[]string{column},
+		)
+	}
+	return
+}
+
 func (db *database) preSave(schema skydb.RecordSchema, record *skydb.Record) error {
 	const SetSequenceMaxValue = `SELECT setval($1, GREATEST(max(%v), $2)) FROM %v;`
 
@@ -199,6 +402,13 @@ func convert(r *skydb.Record) map[string]interface{} {
 		case skydb.Unknown:
 			// Do not modify columns with unknown type because they are
 			// managed by the developer.
+		case skydb.FieldOperation:
+			// The operand is saved, see saveDataWithWrappers.
+			if operand, ok := value.Value.([]interface{}); ok {
+				m[key] = jsonSliceValue(operand)
+			} else {
+				m[key] = value.Value
+			}
 		default:
 			m[key] = rawValue
 		}
@@ -213,8 +423,61 @@ func convert(r *skydb.Record) map[string]interface{} {
 }
 
 func (db *database) Delete(id skydb.RecordID) error {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if softDeleteEnabled(typemap) {
+		return db.setTrashed(id, true)
+	}
+	return db.delete(id, false)
+}
+
+// delete removes the record permanently. If trashed is true, the record
+// is removed only if it is in trash.
+var _ skydb.ReferenceNullingDatabase = &database{}
+
+func (db *database) SetNullReferences(recordType, field string, id skydb.RecordID, updatedAt time.Time, updaterID string) ([]skydb.RecordID, error) {
+	builder := psql.Update(db.TableName(recordType)).
+		Set(pq.QuoteIdentifier(field), nil).
+		Set("_updated_at", updatedAt.UTC()).
+		Set("_updated_by", updaterID).
+		Where(pq.QuoteIdentifier(field)+" = ?", id.Key).
+		Suffix(`RETURNING "_id"`)
+
+	switch db.DatabaseType() {
+	case skydb.UnionDatabase:
+		return nil, skydb.ErrDatabaseIsReadOnly
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		builder = builder.Where("_database_id = ?", db.userID)
+	}
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set null references of %s in %s: %s", id, recordType, err)
+	}
+	defer rows.Close()
+
+	ids := []skydb.RecordID{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		ids = append(ids, skydb.NewRecordID(recordType, key))
+	}
+	return ids, rows.Err()
+}
+
+func (db *database) delete(id skydb.RecordID, trashed bool) error {
 	builder := psql.Delete(db.TableName(id.Type)).
 		Where("_id = ?", id.Key)
+	if trashed {
+		builder = builder.Where(pq.QuoteIdentifier("_deleted_at") + " IS NOT NULL")
+	}
 
 	switch db.DatabaseType() {
 	case skydb.UnionDatabase:
@@ -267,7 +530,11 @@ func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory builder.Pred
 	}
 
 	if db.DatabaseType() == skydb.PublicDatabase && !query.BypassAccessControl {
-		aclSqlizer, err := factory.NewAccessControlSqlizer(query.ViewAsUser, skydb.ReadLevel)
+		level := query.AccessLevel
+		if level == "" {
+			level = skydb.ReadLevel
+		}
+		aclSqlizer, err := factory.NewAccessControlSqlizer(query.ViewAsUser, level)
 		if err != nil {
 			return q, err
 		}
@@ -277,7 +544,32 @@ func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory builder.Pred
 	return q, nil
 }
 
+// sortColumnPrefix is the prefix of columns selecting the functions by
+// which the query is sorted. The columns are not scanned into records.
+const sortColumnPrefix = "_sort_"
+
 func (db *database) Query(query *skydb.Query) (*skydb.Rows, error) {
+	return db.query(query, false, nil)
+}
+
+var _ skydb.PartitionQueryDatabase = &database{}
+
+func (db *database) QueryPartitioned(query *skydb.Query, field string, limit uint64) (*skydb.Rows, error) {
+	return db.query(query, false, &queryPartition{Field: field, Limit: limit})
+}
+
+// queryPartition limits the number of records queried for each value of
+// the field.
+type queryPartition struct {
+	Field string
+	Limit uint64
+}
+
+// partitionRowColumn is the column numbering the records with the same
+// value of the partition field. It is not selected in the result.
+const partitionRowColumn = "_partition_row"
+
+func (db *database) query(query *skydb.Query, trashed bool, partition *queryPartition) (*skydb.Rows, error) {
 	if query.Type == "" {
 		return nil, errors.New("got empty query type")
 	}
@@ -291,19 +583,80 @@ func (db *database) Query(query *skydb.Query) (*skydb.Rows, error) {
 		return skydb.EmptyRows, nil
 	}
 
-	q := psql.Select()
+	if trashed && !softDeleteEnabled(typemap) {
+		return skydb.EmptyRows, nil
+	}
+
+	q := filterTrash(psql.Select(), query.Type, typemap, trashed)
+	if !trashed {
+		if q, err = db.filterExpired(q, query.Type); err != nil {
+			return nil, err
+		}
+	}
 	factory := builder.NewPredicateSqlizerFactory(db, query.Type)
 	q, err = db.applyQueryPredicate(q, factory, query)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, sort := range query.Sorts {
-		orderBy, err := builder.SortOrderBySQL(query.Type, sort)
+	if query.After != nil {
+		q = q.Where(builder.NewKeysetSqlizer(query.Type, query.Sorts, *query.After, false))
+	}
+
+	if query.Before != nil {
+		q = q.Where(builder.NewKeysetSqlizer(query.Type, query.Sorts, *query.Before, true))
+	}
+
+	// When paging backward from a cursor, the records closest to the
+	// cursor are fetched in reverse order and reversed again after scanning.
+	reversed := query.Before != nil && query.After == nil && query.Limit != nil
+
+	sortColumns := skydb.RecordSchema{}
+	partitionOrderBys := []string{}
+	for i, sort := range query.Sorts {
+		if reversed {
+			sort.Order = reverseSortOrder(sort.Order)
+		}
+
+		var orderBy string
+		if sort.Expression.Type == skydb.Function {
+			if partition != nil {
+				return nil, errors.New("sorting by function is not supported when limiting records for each value of a field")
+			}
+			// The function is selected as a column, so that its
+			// arguments are bound rather than written into ORDER BY.
+			column := fmt.Sprintf("%s%d", sortColumnPrefix, i)
+			sortColumns[column] = skydb.FieldType{
+				Type:       skydb.TypeNumber,
+				Expression: sort.Expression,
+			}
+			orderBy, err = builder.SortColumnOrderBySQL(column, sort.Order)
+		} else {
+			orderBy, err = builder.SortOrderBySQL(query.Type, typemap, sort)
+		}
+		if err != nil {
+			return nil, err
+		}
+		q = q.OrderBy(orderBy)
+		partitionOrderBys = append(partitionOrderBys, orderBy)
+	}
+
+	// Order by _id so that the order is deterministic for records with
+	// identical sort values, which is required for cursor paging.
+	if len(query.Sorts) > 0 || query.Limit != nil || query.After != nil || query.Before != nil || partition != nil {
+		idSort := skydb.Sort{
+			Expression: skydb.Expression{Type: skydb.KeyPath, Value: "_id"},
+			Order:      skydb.Ascending,
+		}
+		if reversed {
+			idSort.Order = reverseSortOrder(idSort.Order)
+		}
+		orderBy, err := builder.SortOrderBySQL(query.Type, typemap, idSort)
 		if err != nil {
 			return nil, err
 		}
 		q = q.OrderBy(orderBy)
+		partitionOrderBys = append(partitionOrderBys, orderBy)
 	}
 
 	if query.Limit != nil {
@@ -322,23 +675,67 @@ func (db *database) Query(query *skydb.Query) (*skydb.Rows, error) {
 		return nil, err
 	}
 	typemap = factory.UpdateTypemap(typemap)
+	for column, fieldType := range sortColumns {
+		typemap[column] = fieldType
+	}
 	q = db.selectQuery(q, query.Type, typemap)
 
-	rows, err := db.c.QueryWith(q)
+	var rows *sqlx.Rows
+	if partition != nil {
+		rows, err = db.queryPartitioned(q, query.Type, typemap, partition, partitionOrderBys)
+	} else {
+		rows, err = db.c.ReadQueryWith(q)
+	}
+	if reversed {
+		return newReversedRows(query.Type, typemap, rows, err)
+	}
 	return newRows(query.Type, typemap, rows, err)
 }
 
+// queryPartitioned executes the select query with at most partition.Limit
+// records for each value of the partition field, in the order of orderBys.
+// The records are numbered by a window function in a subquery, so that
+// the limit is applied by the database.
+func (db *database) queryPartitioned(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema, partition *queryPartition, orderBys []string) (*sqlx.Rows, error) {
+	q = q.Column(fmt.Sprintf(
+		"row_number() OVER (PARTITION BY %s.%s ORDER BY %s) AS %s",
+		pq.QuoteIdentifier(recordType),
+		pq.QuoteIdentifier(partition.Field),
+		strings.Join(orderBys, ", "),
+		pq.QuoteIdentifier(partitionRowColumn),
+	))
+	innerSQL, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(typemap))
+	for column := range typemap {
+		columns = append(columns, pq.QuoteIdentifier(column))
+	}
+	stmt := fmt.Sprintf(
+		"SELECT %s FROM (%s) AS %s WHERE %s <= %d ORDER BY %s",
+		strings.Join(columns, ", "),
+		innerSQL,
+		pq.QuoteIdentifier("_partitioned"),
+		pq.QuoteIdentifier(partitionRowColumn),
+		partition.Limit,
+		pq.QuoteIdentifier(partitionRowColumn),
+	)
+	return db.c.ReadQueryx(stmt, args...)
+}
+
 func (db *database) QueryCount(query *skydb.Query) (uint64, error) {
 	if query.Type == "" {
 		return 0, errors.New("got empty query type")
 	}
 
-	typemap, err := db.RemoteColumnTypes(query.Type)
-	if err != nil || len(typemap) == 0 { // error or record type has not been created
+	remoteTypemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil || len(remoteTypemap) == 0 { // error or record type has not been created
 		return 0, err
 	}
 
-	typemap = skydb.RecordSchema{
+	typemap := skydb.RecordSchema{
 		"_record_count": skydb.FieldType{
 			Type: skydb.TypeNumber,
 			Expression: skydb.Expression{
@@ -351,13 +748,17 @@ func (db *database) QueryCount(query *skydb.Query) (uint64, error) {
 	}
 
 	q := db.selectQuery(psql.Select(), query.Type, typemap)
+	q = filterTrash(q, query.Type, remoteTypemap, false)
+	if q, err = db.filterExpired(q, query.Type); err != nil {
+		return 0, err
+	}
 	factory := builder.NewPredicateSqlizerFactory(db, query.Type)
 	q, err = db.applyQueryPredicate(q, factory, query)
 	if err != nil {
 		return 0, err
 	}
 
-	rows, err := db.c.QueryWith(q)
+	rows, err := db.c.ReadQueryWith(q)
 	if err != nil {
 		return 0, err
 	}
@@ -376,6 +777,100 @@ func (db *database) QueryCount(query *skydb.Query) (uint64, error) {
 	return recordCount, nil
 }
 
+var _ skydb.AggregateDatabase = &database{}
+
+// QueryAggregation implements skydb.AggregateDatabase.
+func (db *database) QueryAggregation(query *skydb.Query) ([]map[string]interface{}, error) {
+	if query.Type == "" {
+		return nil, errors.New("got empty query type")
+	}
+
+	remoteTypemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []map[string]interface{}{}
+	if len(remoteTypemap) == 0 { // record type has not been created
+		return results, nil
+	}
+
+	typemap := skydb.RecordSchema{}
+	for name, expr := range query.Aggregations {
+		f, ok := expr.Value.(skydb.Func)
+		if !ok || expr.Type != skydb.Function || !skydb.IsAggregateFunc(f) {
+			return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+				`aggregation "%s" is not an aggregate function`, name)
+		}
+
+		fieldType := skydb.FieldType{Type: skydb.TypeNumber, Expression: expr}
+		if aggregateFunc, ok := f.(skydb.AggregateFunc); ok {
+			remoteFieldType, ok := remoteTypemap[aggregateFunc.Field]
+			if !ok {
+				return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+					`keypath "%s" does not exist`, aggregateFunc.Field)
+			}
+			if aggregateFunc.Operator == skydb.Min || aggregateFunc.Operator == skydb.Max {
+				fieldType.Type = remoteFieldType.Type
+				fieldType.ReferenceType = remoteFieldType.ReferenceType
+			}
+		}
+		typemap[name] = fieldType
+	}
+
+	q := psql.Select()
+	for _, keyPath := range query.GroupBy {
+		fieldType, ok := remoteTypemap[keyPath]
+		if !ok {
+			return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+				`keypath "%s" does not exist`, keyPath)
+		}
+		typemap[keyPath] = fieldType
+
+		groupBy, _, _ := builder.NewExpressionSqlizer(query.Type, fieldType, skydb.Expression{
+			Type:  skydb.KeyPath,
+			Value: keyPath,
+		}).ToSql()
+		q = q.GroupBy(groupBy).OrderBy(groupBy)
+	}
+
+	q = db.selectQuery(q, query.Type, typemap)
+	q = filterTrash(q, query.Type, remoteTypemap, false)
+	if q, err = db.filterExpired(q, query.Type); err != nil {
+		return nil, err
+	}
+	factory := builder.NewPredicateSqlizerFactory(db, query.Type)
+	q, err = db.applyQueryPredicate(q, factory, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.ReadQueryWith(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := newRecordScanner(query.Type, typemap, rows)
+	for rows.Next() {
+		record := skydb.Record{}
+		if err := rs.Scan(&record); err != nil {
+			return nil, err
+		}
+
+		result := map[string]interface{}{}
+		for name := range typemap {
+			result[name] = record.Get(name)
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 // columnsScanner wraps over sqlx.Rows and sqlx.Row to provide
 // a consistent interface for column scanning.
 type columnsScanner interface {
@@ -438,6 +933,9 @@ func (rs *recordScanner) Scan(record *skydb.Record) error {
 		case skydb.TypeGeometry:
 			var g nullGeometry
 			values = append(values, &g)
+		case skydb.TypeList:
+			l := nullList{ElementType: schema.ElementType}
+			values = append(values, &l)
 		case skydb.TypeUnknown:
 			var u nullUnknown
 			values = append(values, &u)
@@ -468,6 +966,10 @@ func (rs *recordScanner) Scan(record *skydb.Record) error {
 			continue
 		}
 
+		if strings.HasPrefix(column, sortColumnPrefix) {
+			continue
+		}
+
 		switch svalue := value.(type) {
 		default:
 			return fmt.Errorf("received unexpected scanned type = %T for column = %s", value, column)
@@ -518,6 +1020,10 @@ func (rs *recordScanner) Scan(record *skydb.Record) error {
 			if svalue.Valid {
 				record.Set(column, svalue.Geometry)
 			}
+		case *nullList:
+			if svalue.Valid {
+				record.Set(column, svalue.Slice)
+			}
 		case *nullUnknown:
 			if svalue.Valid {
 				val := skydb.Unknown{}
@@ -568,6 +1074,64 @@ func newRows(recordType string, typemap skydb.RecordSchema, rows *sqlx.Rows, err
 	return skydb.NewRows(rowsIter{rows, rs}), nil
 }
 
+// newReversedRows scans all rows and returns them in reverse order.
+func newReversedRows(recordType string, typemap skydb.RecordSchema, rows *sqlx.Rows, err error) (*skydb.Rows, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := newRecordScanner(recordType, typemap, rows)
+	records := []skydb.Record{}
+	for rows.Next() {
+		record := skydb.Record{}
+		if err := rs.Scan(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return skydb.NewRows(&recordsIter{records: records}), nil
+}
+
+// recordsIter iterates over records that are already scanned.
+//
+// Unlike skydb.MemoryRows, the overall record count is not available.
+type recordsIter struct {
+	records []skydb.Record
+}
+
+func (rs *recordsIter) Close() error {
+	return nil
+}
+
+func (rs *recordsIter) Next(record *skydb.Record) error {
+	if len(rs.records) == 0 {
+		return io.EOF
+	}
+
+	*record = rs.records[0]
+	rs.records = rs.records[1:]
+	return nil
+}
+
+func (rs *recordsIter) OverallRecordCount() *uint64 {
+	return nil
+}
+
+func reverseSortOrder(order skydb.SortOrder) skydb.SortOrder {
+	if order == skydb.Descending {
+		return skydb.Ascending
+	}
+	return skydb.Descending
+}
+
 func columnSqlizersForSelect(recordType string, typemap skydb.RecordSchema) map[string]sq.Sqlizer {
 	sqlizers := map[string]sq.Sqlizer{}
 	for column, fieldType := range typemap {
@@ -580,7 +1144,7 @@ func columnSqlizersForSelect(recordType string, typemap skydb.RecordSchema) map[
 		}
 
 		sqlizer := builder.NewExpressionSqlizer(recordType, fieldType, expr)
-		if fieldType.Type == skydb.TypeGeometry {
+		if fieldType.Type == skydb.TypeGeometry || fieldType.Type == skydb.TypeList {
 			sqlizer, _ = builder.RequireCast(sqlizer)
 		}
 		sqlizers[column] = sqlizer
@@ -629,7 +1193,10 @@ func updateTypemapForQuery(query *skydb.Query, typemap skydb.RecordSchema) (skyd
 		}
 	}
 
-	if query.GetCount {
+	// The overall count is not selected for cursor query, since the
+	// keyset condition excludes records from the count.
+	// QueryCount is used instead.
+	if query.GetCount && query.After == nil && query.Before == nil {
 		typemap["_record_count"] = skydb.FieldType{
 			Type: skydb.TypeNumber,
 			Expression: skydb.Expression{
@@ -25,6 +25,7 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
 )
 
@@ -1486,6 +1487,28 @@ func TestQuery(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(records, ShouldResemble, []skydb.Record{record1, record2, record3, record4, record5})
 		})
+
+		Convey("can be queried for records writable by user", func() {
+			record6 := skydb.Record{
+				ID:      skydb.NewRecordID("note", "id6"),
+				OwnerID: "alice",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("bob", skydb.WriteLevel),
+				},
+			}
+			So(db.Save(&record6), ShouldBeNil)
+
+			query := skydb.Query{
+				Type:        "note",
+				ViewAsUser:  &skydb.AuthInfo{ID: "bob"},
+				Sorts:       sortsByID,
+				AccessLevel: skydb.WriteLevel,
+			}
+			records, err := exhaustRows(db.Query(&query))
+
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, []skydb.Record{record6})
+		})
 	})
 
 	Convey("Empty Conn", t, func() {
@@ -2569,3 +2592,124 @@ func TestRecordUnknownField(t *testing.T) {
 		})
 	})
 }
+
+func TestRecordFieldOperation(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"likes":  skydb.FieldType{Type: skydb.TypeInteger},
+			"score":  skydb.FieldType{Type: skydb.TypeNumber},
+			"tags":   skydb.FieldType{Type: skydb.TypeList, ElementType: skydb.TypeString},
+			"blob":   skydb.FieldType{Type: skydb.TypeJSON},
+			"author": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "userid",
+			Data: map[string]interface{}{
+				"likes": int64(1),
+				"score": float64(1.5),
+				"tags":  []interface{}{"a", "b"},
+				"blob":  []interface{}{"x", "y"},
+			},
+		}
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("performs field operations on existing record", func() {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "userid",
+				Data: map[string]interface{}{
+					"likes": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+					"score": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(0.5)},
+					"tags":  skydb.FieldOperation{Operator: skydb.AppendOperator, Value: []interface{}{"c"}},
+					"blob":  skydb.FieldOperation{Operator: skydb.RemoveOperator, Value: []interface{}{"x"}},
+				},
+			}
+			So(db.Save(&record), ShouldBeNil)
+			So(record.Data["likes"], ShouldEqual, int64(3))
+			So(record.Data["score"], ShouldEqual, float64(2))
+			So(record.Data["tags"], ShouldResemble, []interface{}{"a", "b", "c"})
+			So(record.Data["blob"], ShouldResemble, []interface{}{"y"})
+		})
+
+		Convey("performs field operations on new record", func() {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", "2"),
+				OwnerID: "userid",
+				Data: map[string]interface{}{
+					"likes": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+					"tags":  skydb.FieldOperation{Operator: skydb.RemoveOperator, Value: []interface{}{"c"}},
+				},
+			}
+			So(db.Save(&record), ShouldBeNil)
+			So(record.Data["likes"], ShouldEqual, int64(2))
+			So(record.Data["tags"], ShouldResemble, []interface{}{})
+		})
+
+		Convey("rejects operation on field of incompatible type", func() {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "userid",
+				Data: map[string]interface{}{
+					"author": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(1)},
+				},
+			}
+			err := db.Save(&record)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+	})
+}
+
+func TestQueryPartitioned(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"category":  skydb.FieldType{Type: skydb.TypeString},
+			"noteOrder": skydb.FieldType{Type: skydb.TypeNumber},
+		})
+		So(err, ShouldBeNil)
+
+		for i, category := range []string{"a", "a", "a", "b", "b"} {
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", fmt.Sprintf("id%d", i)),
+				OwnerID: "user_id",
+				Data: map[string]interface{}{
+					"category":  category,
+					"noteOrder": float64(i),
+				},
+			}), ShouldBeNil)
+		}
+
+		Convey("returns at most limit records for each value", func() {
+			query := skydb.Query{
+				Type: "note",
+				Sorts: []skydb.Sort{{
+					Expression: skydb.Expression{Type: skydb.KeyPath, Value: "noteOrder"},
+					Order:      skydb.Descending,
+				}},
+			}
+			records, err := exhaustRows(db.(*database).QueryPartitioned(&query, "category", 2))
+			So(err, ShouldBeNil)
+
+			keys := map[string][]string{}
+			for _, record := range records {
+				category := record.Data["category"].(string)
+				keys[category] = append(keys[category], record.ID.Key)
+			}
+			So(keys, ShouldResemble, map[string][]string{
+				"a": []string{"id2", "id1"},
+				"b": []string{"id4", "id3"},
+			})
+		})
+	})
+}
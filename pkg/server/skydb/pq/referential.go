@@ -0,0 +1,72 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// saveReferentialAction saves the referential action of the reference
+// field to the `_record_field_reference` table, or removes it if there is
+// no action.
+//
+// The foreign key constraint of the field is always created without an
+// action, so that the referencing records are only changed by the record
+// handlers, which apply access control and record history to them.
+func (db *database) saveReferentialAction(execer sqlx.Execer, recordType, field string, action skydb.ReferentialAction) error {
+	tableName := db.TableName("_record_field_reference")
+	if action == skydb.NoReferentialAction {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE record_type = $1 AND record_field = $2`, tableName)
+		if _, err := execer.Exec(stmt, recordType, field); err != nil {
+			return fmt.Errorf("failed to remove referential action of %s.%s: %s", recordType, field, err)
+		}
+		return nil
+	}
+
+	stmt := fmt.Sprintf(`
+INSERT INTO %s (record_type, record_field, on_delete) VALUES ($1, $2, $3)
+ON CONFLICT (record_type, record_field) DO UPDATE SET on_delete = EXCLUDED.on_delete`, tableName)
+	if _, err := execer.Exec(stmt, recordType, field, string(action)); err != nil {
+		return fmt.Errorf("failed to save referential action of %s.%s: %s", recordType, field, err)
+	}
+	return nil
+}
+
+// getReferentialActions returns the referential actions of the reference
+// fields of the record type, keyed by field name.
+func (db *database) getReferentialActions(recordType string) (map[string]skydb.ReferentialAction, error) {
+	builder := psql.Select("record_field", "on_delete").
+		From(db.TableName("_record_field_reference")).
+		Where("record_type = ?", recordType)
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := map[string]skydb.ReferentialAction{}
+	for rows.Next() {
+		var field, onDelete string
+		if err := rows.Scan(&field, &onDelete); err != nil {
+			return nil, err
+		}
+		actions[field] = skydb.ReferentialAction(onDelete)
+	}
+	return actions, rows.Err()
+}
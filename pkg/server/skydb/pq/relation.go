@@ -51,7 +51,7 @@ func (c *conn) QueryRelation(user string, name string, direction string, config
 		selectBuilder = selectBuilder.Limit(config.Limit)
 	}
 
-	rows, err := c.QueryWith(selectBuilder)
+	rows, err := c.ReadQueryWith(selectBuilder)
 	if err != nil {
 		panic(err)
 	}
@@ -86,7 +86,7 @@ func (c *conn) QueryRelationCount(user string, name string, direction string) (u
 			Where("_secondary.right_id = ?", user)
 	}
 	var count uint64
-	err := c.GetWith(&count, query)
+	err := c.ReadGetWith(&count, query)
 	if err != nil {
 		panic(err)
 	}
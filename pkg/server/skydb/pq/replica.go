@@ -0,0 +1,183 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// replica is a read replica of the primary database.
+type replica struct {
+	index   int // position in the configured replicas, used in logs
+	db      *sqlx.DB
+	healthy int32 // accessed atomically, 1 if the replica is healthy
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	if atomic.SwapInt32(&r.healthy, value) != value {
+		if healthy {
+			log.Infof("skydb/pq: replica #%d is healthy", r.index)
+		} else {
+			log.Warnf("skydb/pq: replica #%d is unhealthy", r.index)
+		}
+	}
+}
+
+// replicaSet is the read replicas of a primary database. Reads are
+// distributed to the healthy replicas in a round-robin manner.
+type replicaSet struct {
+	replicas []*replica
+	next     uint32 // accessed atomically
+}
+
+// pick returns the next healthy replica, or nil if no replica is healthy.
+func (s *replicaSet) pick() *replica {
+	healthy := make([]*replica, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	next := atomic.AddUint32(&s.next, 1)
+	return healthy[next%uint32(len(healthy))]
+}
+
+// checkHealth pings every replica and updates its health.
+func (s *replicaSet) checkHealth() {
+	for _, r := range s.replicas {
+		err := r.db.Ping()
+		if err != nil {
+			log.Debugf("skydb/pq: failed to ping replica #%d: %v", r.index, err)
+		}
+		r.setHealthy(err == nil)
+	}
+}
+
+func (s *replicaSet) runHealthCheck(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.checkHealth()
+	}
+}
+
+type replicaConfig struct {
+	connStrings         []string
+	healthCheckInterval time.Duration
+}
+
+var (
+	replicaMutex   sync.Mutex
+	replicaConfigs = map[string]replicaConfig{}
+	replicaSets    = map[string]*replicaSet{}
+)
+
+// SetReplicas configures the read replicas of the primary database with
+// the specified connection string. Queries of records and relations
+// outside transactions are routed to the healthy replicas, unless read
+// your writes is enabled on the connection.
+//
+// Replicas are health checked with the specified interval. SetReplicas
+// has to be called before connections to the primary database are opened.
+func SetReplicas(connString string, replicaConnStrings []string, healthCheckInterval time.Duration) {
+	replicaMutex.Lock()
+	defer replicaMutex.Unlock()
+
+	replicaConfigs[connString] = replicaConfig{
+		connStrings:         replicaConnStrings,
+		healthCheckInterval: healthCheckInterval,
+	}
+}
+
+// getReplicaSet returns the replicas of the primary database, or nil if
+// the primary database has no replicas. The replicas are opened and
+// health checked the first time they are requested.
+func getReplicaSet(connString string) (*replicaSet, error) {
+	replicaMutex.Lock()
+	defer replicaMutex.Unlock()
+
+	if s, ok := replicaSets[connString]; ok {
+		return s, nil
+	}
+
+	config, ok := replicaConfigs[connString]
+	if !ok || len(config.connStrings) == 0 {
+		return nil, nil
+	}
+
+	s := &replicaSet{}
+	for i, replicaConnString := range config.connStrings {
+		db, err := sqlx.Open("postgres", replicaConnString)
+		if err != nil {
+			for _, r := range s.replicas {
+				r.db.Close()
+			}
+			return nil, err
+		}
+		db.SetMaxOpenConns(10)
+		s.replicas = append(s.replicas, &replica{
+			index: i,
+			db:    db,
+		})
+	}
+
+	s.checkHealth()
+	go s.runHealthCheck(config.healthCheckInterval)
+
+	replicaSets[connString] = s
+	return s, nil
+}
+
+// SetReadYourWrites sets whether reads of the connection are served by
+// the primary database, so that they reflect the writes not yet
+// replicated to the replicas.
+func (c *conn) SetReadYourWrites(enabled bool) {
+	c.readYourWrites = enabled
+}
+
+// ReadYourWrites returns whether reads of the connection are served by
+// the primary database.
+func (c *conn) ReadYourWrites() bool {
+	return c.readYourWrites
+}
+
+// readDb returns the database wrapper for a read-only statement, which
+// is a healthy replica when reads can be routed to replicas. The
+// returned replica is nil when the statement is executed on the primary.
+func (c *conn) readDb() (ExtContext, *replica) {
+	if c.tx != nil || c.readYourWrites || c.replicas == nil {
+		return c.Db(), nil
+	}
+
+	r := c.replicas.pick()
+	if r == nil {
+		return c.Db(), nil
+	}
+	return r.db, r
+}
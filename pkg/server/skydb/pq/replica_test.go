@@ -0,0 +1,89 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplicaSet(t *testing.T) {
+	Convey("replicaSet", t, func() {
+		r0 := &replica{index: 0, db: &sqlx.DB{}}
+		r1 := &replica{index: 1, db: &sqlx.DB{}}
+		r2 := &replica{index: 2, db: &sqlx.DB{}}
+		s := &replicaSet{replicas: []*replica{r0, r1, r2}}
+
+		Convey("picks nothing when no replica is healthy", func() {
+			So(s.pick(), ShouldBeNil)
+		})
+
+		Convey("picks healthy replicas in turn", func() {
+			r0.setHealthy(true)
+			r2.setHealthy(true)
+
+			picked := map[int]int{}
+			for i := 0; i < 4; i++ {
+				picked[s.pick().index]++
+			}
+			So(picked, ShouldResemble, map[int]int{0: 2, 2: 2})
+		})
+	})
+
+	Convey("conn", t, func() {
+		primary := &sqlx.DB{}
+		r := &replica{db: &sqlx.DB{}}
+		r.setHealthy(true)
+		c := &conn{
+			db:       primary,
+			replicas: &replicaSet{replicas: []*replica{r}},
+		}
+
+		Convey("reads from replica", func() {
+			db, picked := c.readDb()
+			So(db, ShouldEqual, r.db)
+			So(picked, ShouldEqual, r)
+		})
+
+		Convey("reads from primary when reading your writes", func() {
+			c.SetReadYourWrites(true)
+			db, picked := c.readDb()
+			So(db, ShouldEqual, primary)
+			So(picked, ShouldBeNil)
+		})
+
+		Convey("reads from primary in transaction", func() {
+			c.tx = &sqlx.Tx{}
+			db, picked := c.readDb()
+			So(db, ShouldEqual, c.tx)
+			So(picked, ShouldBeNil)
+		})
+
+		Convey("reads from primary when no replica is healthy", func() {
+			r.setHealthy(false)
+			db, picked := c.readDb()
+			So(db, ShouldEqual, primary)
+			So(picked, ShouldBeNil)
+		})
+
+		Convey("reads from primary without replicas", func() {
+			c.replicas = nil
+			db, _ := c.readDb()
+			So(db, ShouldEqual, primary)
+		})
+	})
+}
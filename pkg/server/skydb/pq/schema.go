@@ -16,15 +16,19 @@ package pq
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -34,7 +38,37 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		return
 	}
 
-	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) {
+	// Find reference fields with a different referential action. An
+	// empty action does not change the action of an existing field.
+	updatingActions := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		remoteFieldType, ok := remoteRecordSchema[key]
+		if ok && fieldType.Type == skydb.TypeReference &&
+			remoteFieldType.DefinitionCompatibleTo(fieldType) &&
+			fieldType.OnDelete != skydb.NoReferentialAction &&
+			fieldType.OnDelete != remoteFieldType.OnDelete {
+			updatingActions[key] = fieldType
+		}
+	}
+
+	// Find fields with changed validation rules
+	updatingValidations := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if validationChanged(remoteRecordSchema[key], fieldType) {
+			updatingValidations[key] = fieldType
+		}
+	}
+
+	// Find fields with changed default values
+	updatingDefaults := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if defaultChanged(remoteRecordSchema[key], fieldType) {
+			updatingDefaults[key] = fieldType
+		}
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) &&
+		len(updatingActions) == 0 && len(updatingValidations) == 0 && len(updatingDefaults) == 0 {
 		// The current record schema is superset of requested record
 		// schema. There is no need to extend the schema.
 		return
@@ -50,12 +84,15 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		return
 	}
 
-	// Begin transaction for schema migration
-	tx, err := db.c.db.Beginx()
-	if err != nil {
-		return
+	// Begin transaction for schema migration, unless the migration is
+	// part of the transaction of the connection.
+	tx := db.c.tx
+	if tx == nil {
+		if tx, err = db.c.db.Beginx(); err != nil {
+			return
+		}
+		defer tx.Rollback()
 	}
-	defer tx.Rollback()
 
 	if len(remoteRecordSchema) == 0 {
 		if err := createTable(tx, db.TableName(recordType)); err != nil {
@@ -90,15 +127,54 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		extended = true
 	}
 
-	if err = tx.Commit(); err != nil {
-		return false, fmt.Errorf("unable to commit transaction for Extend: %s", err)
+	for column, fieldType := range updatingSchema {
+		if fieldType.Type == skydb.TypeReference && fieldType.OnDelete != skydb.NoReferentialAction {
+			updatingActions[column] = fieldType
+		}
+	}
+
+	for column, fieldType := range updatingActions {
+		if err := db.saveReferentialAction(tx, recordType, column, fieldType.OnDelete); err != nil {
+			return false, err
+		}
+		extended = true
+	}
+
+	for column, fieldType := range updatingValidations {
+		if err := db.saveFieldValidation(tx, recordType, column, fieldType.Validation); err != nil {
+			return false, err
+		}
+		extended = true
+	}
+
+	for column, fieldType := range updatingDefaults {
+		if err := db.saveFieldDefault(tx, recordType, column, fieldType.Default); err != nil {
+			return false, err
+		}
+		extended = true
+	}
+
+	if tx != db.c.tx {
+		if err = tx.Commit(); err != nil {
+			return false, fmt.Errorf("unable to commit transaction for Extend: %s", err)
+		}
 	}
 
-	delete(db.c.RecordSchema, recordType)
+	db.c.invalidateRecordSchema(recordType, false)
 
 	return
 }
 
+// fieldMetadataTables are the tables storing information of fields which
+// are not part of the column definition, in the record_type and
+// record_field columns.
+var fieldMetadataTables = []string{
+	"_record_field_validation",
+	"_record_field_default",
+	"_record_field_reference",
+	"_record_expiry",
+}
+
 func (db *database) RenameSchema(recordType, oldName, newName string) error {
 	if !db.c.canMigrate {
 		// The record schemas are different, but the database connection
@@ -107,13 +183,23 @@ func (db *database) RenameSchema(recordType, oldName, newName string) error {
 	}
 
 	tableName := db.TableName(recordType)
-	oldName = pq.QuoteIdentifier(oldName)
-	newName = pq.QuoteIdentifier(newName)
+	quotedOldName := pq.QuoteIdentifier(oldName)
+	quotedNewName := pq.QuoteIdentifier(newName)
 
-	stmt := fmt.Sprintf("ALTER TABLE %s RENAME %s TO %s", tableName, oldName, newName)
+	stmt := fmt.Sprintf("ALTER TABLE %s RENAME %s TO %s", tableName, quotedOldName, quotedNewName)
 	if _, err := db.c.Exec(stmt); err != nil {
 		return fmt.Errorf("failed to alter table: %s", err)
 	}
+
+	for _, metadataTable := range fieldMetadataTables {
+		stmt = fmt.Sprintf("UPDATE %s SET record_field = $1 WHERE record_type = $2 AND record_field = $3",
+			db.TableName(metadataTable))
+		if _, err := db.c.Exec(stmt, newName, recordType, oldName); err != nil {
+			return fmt.Errorf("failed to rename field in %s: %s", metadataTable, err)
+		}
+	}
+
+	db.c.invalidateRecordSchema(recordType, true)
 	return nil
 }
 
@@ -125,12 +211,21 @@ func (db *database) DeleteSchema(recordType, columnName string) error {
 	}
 
 	tableName := db.TableName(recordType)
-	columnName = pq.QuoteIdentifier(columnName)
 
-	stmt := fmt.Sprintf("ALTER TABLE %s DROP %s", tableName, columnName)
+	stmt := fmt.Sprintf("ALTER TABLE %s DROP %s", tableName, pq.QuoteIdentifier(columnName))
 	if _, err := db.c.Exec(stmt); err != nil {
 		return fmt.Errorf("failed to alter table: %s", err)
 	}
+
+	for _, metadataTable := range fieldMetadataTables {
+		stmt = fmt.Sprintf("DELETE FROM %s WHERE record_type = $1 AND record_field = $2",
+			db.TableName(metadataTable))
+		if _, err := db.c.Exec(stmt, recordType, columnName); err != nil {
+			return fmt.Errorf("failed to remove field from %s: %s", metadataTable, err)
+		}
+	}
+
+	db.c.invalidateRecordSchema(recordType, true)
 	return nil
 }
 
@@ -191,6 +286,16 @@ func createTable(tx *sqlx.Tx, tableName string) error {
 		return err
 	}
 
+	stmt = fmt.Sprintf(`
+		CREATE TRIGGER trigger_log_record_change
+		AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW
+		EXECUTE PROCEDURE public.log_record_change();
+	`, tableName)
+	log.WithField("stmt", stmt).Debugln("Creating trigger")
+	if _, err := tx.Exec(stmt); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -205,6 +310,16 @@ func dropTable(tx *sqlx.Tx, tableName string) error {
 		return err
 	}
 
+	stmt = fmt.Sprintf(`
+		DROP TRIGGER IF EXISTS trigger_log_record_change
+		ON %s
+		CASCADE
+	`, tableName)
+	log.WithField("stmt", stmt).Debugln("Deleting trigger")
+	if _, err := tx.Exec(stmt); err != nil {
+		return err
+	}
+
 	stmt = fmt.Sprintf(`
 		DROP TABLE IF EXISTS %s
 		CASCADE
@@ -288,7 +403,7 @@ func (db *database) RemoteColumnTypes(recordType string) (skydb.RecordSchema, er
 	typemap := skydb.RecordSchema{}
 	var err error
 	// STEP 0: Return the cached ColumnType
-	if schema, ok := db.c.RecordSchema[recordType]; ok {
+	if schema, ok := db.c.cachedRecordSchema(recordType); ok {
 		log.Debugf("Using cached remoteColumnTypes %s", recordType)
 		return schema, nil
 	}
@@ -304,7 +419,7 @@ WHERE c.relname = $1
 		recordType, db.schemaName()).Scan(&oid)
 
 	if err == sql.ErrNoRows {
-		db.c.RecordSchema[recordType] = nil
+		db.c.cacheRecordSchema(recordType, nil)
 		log.Debugf("Cache remoteColumnTypes %s (no table)", recordType)
 		return nil, nil
 	}
@@ -372,7 +487,12 @@ WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped`,
 		case TypeGeometry:
 			schema.Type = skydb.TypeGeometry
 		default:
-			schema.Type = skydb.TypeUnknown
+			if elementType, ok := listElementDataType(pqType); ok {
+				schema.Type = skydb.TypeList
+				schema.ElementType = elementType
+			} else {
+				schema.Type = skydb.TypeUnknown
+			}
 		}
 
 		typemap[columnName] = schema
@@ -435,7 +555,64 @@ WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped`,
 		typemap[primaryColumn] = s
 	}
 
-	db.c.RecordSchema[recordType] = typemap
+	// STEP 3.1: Referential actions of reference fields
+	actions, err := db.getReferentialActions(recordType)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"schemaName": db.schemaName(),
+			"recordType": recordType,
+			"err":        err,
+		}).Errorln("Failed to query referential actions")
+
+		return nil, err
+	}
+
+	for column, action := range actions {
+		if schema, ok := typemap[column]; ok && schema.Type == skydb.TypeReference {
+			schema.OnDelete = action
+			typemap[column] = schema
+		}
+	}
+
+	// STEP 4: Validation rules of fields
+	validations, err := db.getFieldValidations(recordType)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"schemaName": db.schemaName(),
+			"recordType": recordType,
+			"err":        err,
+		}).Errorln("Failed to query field validations")
+
+		return nil, err
+	}
+
+	for column, validation := range validations {
+		if schema, ok := typemap[column]; ok {
+			schema.Validation = validation
+			typemap[column] = schema
+		}
+	}
+
+	// STEP 5: Default values of fields
+	defaults, err := db.getFieldDefaults(recordType)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"schemaName": db.schemaName(),
+			"recordType": recordType,
+			"err":        err,
+		}).Errorln("Failed to query field defaults")
+
+		return nil, err
+	}
+
+	for column, fieldDefault := range defaults {
+		if schema, ok := typemap[column]; ok {
+			schema.Default = fieldDefault
+			typemap[column] = schema
+		}
+	}
+
+	db.c.cacheRecordSchema(recordType, typemap)
 	log.Debugf("Cache remoteColumnTypes %s", recordType)
 	return typemap, nil
 }
@@ -454,7 +631,7 @@ func (db *database) addColumnStmt(recordType string, recordSchema skydb.RecordSc
 		buf.Write([]byte("ADD "))
 		buf.WriteString(pq.QuoteIdentifier(column))
 		buf.WriteByte(' ')
-		buf.WriteString(pqDataType(schema.Type))
+		buf.WriteString(pqFieldType(schema))
 		buf.WriteByte(',')
 		switch schema.Type {
 		case skydb.TypeAsset:
@@ -482,83 +659,74 @@ func (db *database) writeForeignKeyConstraint(buf *bytes.Buffer, localCol, refer
 	buf.Write([]byte(`),`))
 }
 
-func (db *database) GetIndexesByRecordType(recordType string) (indexes map[string]skydb.Index, err error) {
-	schemaName := db.schemaName()
-	rows, err := db.c.Queryx(`
-SELECT
-    t.relname AS table_name,
-    i.relname AS index_name,
-    array_to_string(array_agg(a.attname), ',') AS column_names
-FROM
-    pg_class t,
-    pg_class i,
-    pg_index ix,
-    pg_attribute a,
-    pg_namespace ns
-WHERE
-    t.oid = ix.indrelid
-    AND i.oid = ix.indexrelid
-    AND ns.oid = t.relnamespace
-    AND ns.oid = i.relnamespace
-    AND a.attrelid = t.oid
-    AND a.attnum = ANY(ix.indkey)
-    AND t.relkind = 'r'
-    AND ix.indisunique = TRUE
-    AND ns.nspname = $1
-    AND t.relname = $2
-GROUP BY
-    ns.nspname,
-    t.relname,
-    i.relname;`,
-		schemaName, recordType)
-
-	if err != nil {
-		return
+// foreignKeyConstraintQuery selects the name of the foreign key constraint
+// of a column, by the schema name, table name and column name.
+const foreignKeyConstraintQuery = `
+SELECT tc.constraint_name
+FROM information_schema.table_constraints AS tc
+    JOIN information_schema.key_column_usage AS kcu
+        ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema
+WHERE tc.constraint_type = 'FOREIGN KEY'
+    AND tc.table_schema = $1
+    AND tc.table_name = $2
+    AND kcu.column_name = $3`
+
+var _ skydb.SearchIndexDatabase = &database{}
+
+// SaveSearchIndex creates a GIN index on the text search vector of the
+// field, so that full-text search on the field does not scan the table.
+func (db *database) SaveSearchIndex(recordType, field, language string) (string, error) {
+	if !db.c.canMigrate {
+		return "", skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
 	}
 
-	indexes = map[string]skydb.Index{}
-	for rows.Next() {
-		var table string
-		var name string
-		var columnNames string
-		if err = rows.Scan(&table, &name, &columnNames); err != nil {
-			return
-		}
-
-		indexes[name] = skydb.Index{
-			Fields: strings.Split(columnNames, ","),
-		}
+	if !skydb.IsValidSearchLanguage(language) {
+		return "", skyerr.NewInvalidArgument(fmt.Sprintf(`invalid search language "%s"`, language), []string{"language"})
 	}
 
-	return
-}
-
-func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
-	quotedColumns := []string{}
-	for _, col := range index.Fields {
-		quotedColumns = append(quotedColumns, fmt.Sprintf("%s", col))
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return "", err
+	}
+	fieldType, ok := typemap[field]
+	if !ok {
+		return "", skyerr.NewErrorf(skyerr.ResourceNotFound, `field "%s" of record type "%s" does not exist`, field, recordType)
+	}
+	if fieldType.Type != skydb.TypeString {
+		return "", skyerr.NewInvalidArgument(fmt.Sprintf(`field "%s" is not a string`, field), []string{"field"})
 	}
 
-	stmt := fmt.Sprintf(`
-		ALTER TABLE "%s"."%s" ADD CONSTRAINT %s UNIQUE (%s);
-	`, db.schemaName(), recordType, indexName, strings.Join(quotedColumns, ","))
-	fmt.Println("Save Index", stmt)
-	log.WithField("stmt", stmt).Debugln("Creating unique constraint")
+	indexName := searchIndexName(recordType, field, language)
+	stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)",
+		pq.QuoteIdentifier(indexName),
+		db.TableName(recordType),
+		builder.SearchVectorSQL("", field, language))
+	log.WithField("stmt", stmt).Debugln("Creating search index")
 	if _, err := db.c.Exec(stmt); err != nil {
-		return err
+		return "", fmt.Errorf("failed to create search index: %s", err)
 	}
-
-	return nil
+	return indexName, nil
 }
 
-func (db *database) DeleteIndex(recordType string, indexName string) error {
-	stmt := fmt.Sprintf(`
-		ALTER TABLE "%s"."%s" DROP CONSTRAINT %s;
-	`, db.schemaName(), recordType, indexName)
-	log.WithField("stmt", stmt).Debugln("Dropping unique constraint")
-	if _, err := db.c.Exec(stmt); err != nil {
-		return err
+// maxIdentifierLength is the number of bytes PostgreSQL keeps of an
+// identifier, longer identifiers are truncated.
+const maxIdentifierLength = 63
+
+// searchIndexName returns the name of the search index on the field. A
+// name longer than an identifier is truncated and suffixed with a hash
+// of the full name, so that truncated names do not collide.
+func searchIndexName(recordType, field, language string) string {
+	name := fmt.Sprintf("%s_%s_%s_search", recordType, field, language)
+	if len(name) <= maxIdentifierLength {
+		return name
 	}
 
-	return nil
+	sum := sha1.Sum([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:])[:8] + "_search"
+	prefix := name[:maxIdentifierLength-len(suffix)]
+	// do not cut a multi-byte character in half
+	for len(prefix) > 0 && !utf8.RuneStart(name[len(prefix)]) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix + suffix
 }
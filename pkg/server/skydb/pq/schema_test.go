@@ -15,7 +15,9 @@
 package pq
 
 import (
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	. "github.com/smartystreets/goconvey/convey"
@@ -167,6 +169,146 @@ func TestExtend(t *testing.T) {
 			So(extended, ShouldBeTrue)
 		})
 
+		Convey("creates and updates reference with referential action", func() {
+			_, err := db.Extend("collection", skydb.RecordSchema{
+				"name": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"collection": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "collection",
+					OnDelete:      skydb.CascadeAction,
+				},
+			})
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["collection"].OnDelete, ShouldEqual, skydb.CascadeAction)
+
+			var deleteRule string
+			err = c.QueryRowx(`
+				SELECT rc.delete_rule
+				FROM information_schema.referential_constraints AS rc
+					JOIN information_schema.key_column_usage AS kcu
+						ON rc.constraint_name = kcu.constraint_name AND rc.constraint_schema = kcu.table_schema
+				WHERE kcu.table_schema = $1 AND kcu.table_name = 'note' AND kcu.column_name = 'collection'`,
+				db.(*database).schemaName()).Scan(&deleteRule)
+			So(err, ShouldBeNil)
+			So(deleteRule, ShouldEqual, "NO ACTION")
+
+			Convey("keeps action when extended without action", func() {
+				extended, err := db.Extend("note", skydb.RecordSchema{
+					"collection": skydb.FieldType{
+						Type:          skydb.TypeReference,
+						ReferenceType: "collection",
+					},
+				})
+				So(err, ShouldBeNil)
+				So(extended, ShouldBeFalse)
+
+				schema, err := db.GetSchema("note")
+				So(err, ShouldBeNil)
+				So(schema["collection"].OnDelete, ShouldEqual, skydb.CascadeAction)
+			})
+
+			Convey("updates action", func() {
+				extended, err := db.Extend("note", skydb.RecordSchema{
+					"collection": skydb.FieldType{
+						Type:          skydb.TypeReference,
+						ReferenceType: "collection",
+						OnDelete:      skydb.SetNullAction,
+					},
+				})
+				So(err, ShouldBeNil)
+				So(extended, ShouldBeTrue)
+
+				schema, err := db.GetSchema("note")
+				So(err, ShouldBeNil)
+				So(schema["collection"].OnDelete, ShouldEqual, skydb.SetNullAction)
+			})
+		})
+
+		Convey("creates and updates field validation", func() {
+			maxLength := 10
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type: skydb.TypeString,
+					Validation: &skydb.FieldValidation{
+						Required:  true,
+						MaxLength: &maxLength,
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["title"].Validation, ShouldResemble, &skydb.FieldValidation{
+				Required:  true,
+				MaxLength: &maxLength,
+			})
+
+			Convey("keeps validation when extended without validation", func() {
+				extended, err := db.Extend("note", skydb.RecordSchema{
+					"title": skydb.FieldType{Type: skydb.TypeString},
+				})
+				So(err, ShouldBeNil)
+				So(extended, ShouldBeFalse)
+
+				schema, err := db.GetSchema("note")
+				So(err, ShouldBeNil)
+				So(schema["title"].Validation.Required, ShouldBeTrue)
+			})
+
+			Convey("removes validation when extended with empty validation", func() {
+				extended, err := db.Extend("note", skydb.RecordSchema{
+					"title": skydb.FieldType{
+						Type:       skydb.TypeString,
+						Validation: &skydb.FieldValidation{},
+					},
+				})
+				So(err, ShouldBeNil)
+				So(extended, ShouldBeTrue)
+
+				schema, err := db.GetSchema("note")
+				So(err, ShouldBeNil)
+				So(schema["title"].Validation, ShouldBeNil)
+			})
+		})
+
+		Convey("creates and removes field default", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"status": skydb.FieldType{
+					Type:    skydb.TypeString,
+					Default: &skydb.FieldDefault{Type: skydb.LiteralDefault, Value: "draft"},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["status"].Default, ShouldResemble, &skydb.FieldDefault{
+				Type:  skydb.LiteralDefault,
+				Value: "draft",
+			})
+
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"status": skydb.FieldType{
+					Type:    skydb.TypeString,
+					Default: &skydb.FieldDefault{},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err = db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["status"].Default, ShouldBeNil)
+		})
+
 		Convey("REGRESSION #318: creates table with `:` with reference", func() {
 			extended, err := db.Extend("colon:fever", skydb.RecordSchema{
 				"name": skydb.FieldType{Type: skydb.TypeString},
@@ -560,3 +702,35 @@ func TestExtend(t *testing.T) {
 		})
 	})
 }
+
+func TestSearchIndexName(t *testing.T) {
+	Convey("searchIndexName", t, func() {
+		Convey("joins record type, field and language", func() {
+			So(searchIndexName("article", "content", "english"), ShouldEqual, "article_content_english_search")
+		})
+
+		Convey("fits a long name in an identifier", func() {
+			recordType := strings.Repeat("a", 40)
+			name := searchIndexName(recordType, "content_in_full", "english")
+			So(len(name), ShouldEqual, maxIdentifierLength)
+			So(name, ShouldStartWith, recordType)
+			So(name, ShouldEndWith, "_search")
+			So(searchIndexName(recordType, "content_in_full", "english"), ShouldEqual, name)
+		})
+
+		Convey("does not collide names sharing a long prefix", func() {
+			recordType := strings.Repeat("a", 60)
+			So(
+				searchIndexName(recordType, "title", "english"),
+				ShouldNotEqual,
+				searchIndexName(recordType, "title", "french"),
+			)
+		})
+
+		Convey("does not cut a multi-byte character", func() {
+			name := searchIndexName(strings.Repeat("文", 30), "content", "english")
+			So(len(name), ShouldBeLessThanOrEqualTo, maxIdentifierLength)
+			So(utf8.ValidString(name), ShouldBeTrue)
+		})
+	})
+}
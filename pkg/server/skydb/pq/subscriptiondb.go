@@ -409,6 +409,27 @@ func predMatchRecord(p *skydb.Predicate, record *skydb.Record) (b bool) {
 		}
 
 		return deepEqualIn(lv, haystack)
+	case skydb.ContainsAny, skydb.ContainsAll:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		haystack, ok := lv.([]interface{})
+		if !ok {
+			return false
+		}
+		needles, ok := rv.([]interface{})
+		if !ok {
+			log.Panicf("unknown value in right hand side of `%v` operand = %v", p.Operator, rv)
+		}
+
+		matched := 0
+		for _, needle := range needles {
+			if deepEqualIn(needle, haystack) {
+				matched++
+			}
+		}
+		if p.Operator == skydb.ContainsAll {
+			return matched == len(needles)
+		}
+		return matched > 0
 	// case skydb.Like:
 	// case skydb.ILike:
 	default:
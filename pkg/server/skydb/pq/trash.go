@@ -0,0 +1,231 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+var _ skydb.SoftDeleteDatabase = &database{}
+
+// softDeleteEnabled returns whether soft delete is enabled for a record
+// type, which is the case if the table of the record type has the
+// `_deleted_at` column. A record is in trash if `_deleted_at` is not null.
+func softDeleteEnabled(typemap skydb.RecordSchema) bool {
+	_, ok := typemap["_deleted_at"]
+	return ok
+}
+
+// filterTrash adds condition to the select query such that only records
+// in trash are selected if trashed is true, or only records not in trash
+// are selected otherwise. The query is unchanged if soft delete is not
+// enabled for the record type.
+func filterTrash(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema, trashed bool) sq.SelectBuilder {
+	if !softDeleteEnabled(typemap) {
+		return q
+	}
+
+	if trashed {
+		return q.Where(fmt.Sprintf(`%s."_deleted_at" IS NOT NULL`, pq.QuoteIdentifier(recordType)))
+	}
+	return q.Where(fmt.Sprintf(`%s."_deleted_at" IS NULL`, pq.QuoteIdentifier(recordType)))
+}
+
+// setTrashed moves the record to trash if trashed is true, or out of
+// trash otherwise.
+func (db *database) setTrashed(id skydb.RecordID, trashed bool) error {
+	builder := psql.Update(db.TableName(id.Type)).
+		Where("_id = ?", id.Key)
+	if trashed {
+		builder = builder.Set("_deleted_at", time.Now().UTC()).
+			Where(pq.QuoteIdentifier("_deleted_at") + " IS NULL")
+	} else {
+		builder = builder.Set("_deleted_at", nil).
+			Where(pq.QuoteIdentifier("_deleted_at") + " IS NOT NULL")
+	}
+
+	switch db.DatabaseType() {
+	case skydb.UnionDatabase:
+		return skydb.ErrDatabaseIsReadOnly
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		builder = builder.Where("_database_id = ?", db.userID)
+	}
+
+	result, err := db.c.ExecWith(builder)
+	if isUndefinedTable(err) {
+		return skydb.ErrRecordNotFound
+	} else if err != nil {
+		return fmt.Errorf("trash %s: failed to update record: %s", id, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("trash %s: failed to retrieve update status", id)
+	}
+
+	if rowsAffected == 0 {
+		return skydb.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (db *database) SoftDeleteEnabled(recordType string) (bool, error) {
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return false, err
+	}
+	return softDeleteEnabled(typemap), nil
+}
+
+// SetSoftDeleteEnabled adds the `_deleted_at` column to the table of the
+// record type to enable soft delete, and drops the column to disable it.
+// The table is created if it does not exist.
+func (db *database) SetSoftDeleteEnabled(recordType string, enabled bool) error {
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+
+	if softDeleteEnabled(typemap) == enabled {
+		return nil
+	}
+
+	if len(typemap) == 0 {
+		if _, err := db.Extend(recordType, skydb.RecordSchema{}); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tableName := db.TableName(recordType)
+	if enabled {
+		stmt := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "_deleted_at" timestamp without time zone`, tableName)
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+	} else {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE "_deleted_at" IS NOT NULL`, tableName)
+		if _, err := tx.Exec(stmt); isForeignKeyViolated(err) {
+			return skyerr.NewErrorf(
+				skyerr.ConstraintViolated,
+				"failed to purge records of %s in trash because other records have reference to them",
+				recordType,
+			)
+		} else if err != nil {
+			return fmt.Errorf("failed to purge records in trash: %s", err)
+		}
+
+		stmt = fmt.Sprintf(`ALTER TABLE %s DROP COLUMN "_deleted_at"`, tableName)
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction for SetSoftDeleteEnabled: %s", err)
+	}
+
+	db.c.invalidateRecordSchema(recordType, false)
+
+	return nil
+}
+
+func (db *database) GetTrashed(id skydb.RecordID, record *skydb.Record) error {
+	return db.get(id, record, true)
+}
+
+func (db *database) QueryTrash(query *skydb.Query) (*skydb.Rows, error) {
+	return db.query(query, true, nil)
+}
+
+func (db *database) Restore(id skydb.RecordID) error {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if !softDeleteEnabled(typemap) {
+		return skydb.ErrRecordNotFound
+	}
+	return db.setTrashed(id, false)
+}
+
+func (db *database) Purge(id skydb.RecordID) error {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if !softDeleteEnabled(typemap) {
+		return skydb.ErrRecordNotFound
+	}
+	return db.delete(id, true)
+}
+
+func (db *database) PurgeTrash(recordType string, before time.Time) (int64, error) {
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return 0, err
+	}
+
+	if !softDeleteEnabled(typemap) {
+		return 0, nil
+	}
+
+	builder := psql.Delete(db.TableName(recordType)).
+		Where(pq.QuoteIdentifier("_deleted_at")+" < ?", before.UTC())
+
+	switch db.DatabaseType() {
+	case skydb.UnionDatabase:
+		return 0, skydb.ErrDatabaseIsReadOnly
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		builder = builder.Where("_database_id = ?", db.userID)
+	}
+
+	result, err := db.c.ExecWith(builder)
+	if isForeignKeyViolated(err) {
+		return 0, skyerr.NewErrorf(
+			skyerr.ConstraintViolated,
+			"failed to purge records of %s in trash because other records have reference to them",
+			recordType,
+		)
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to purge records of %s in trash: %s", recordType, err)
+	}
+
+	return result.RowsAffected()
+}
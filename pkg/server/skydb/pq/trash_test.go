@@ -0,0 +1,132 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSoftDelete(t *testing.T) {
+	Convey("Database with soft delete enabled", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PrivateDB("userid").(*database)
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		So(db.SetSoftDeleteEnabled("note", true), ShouldBeNil)
+
+		enabled, err := db.SoftDeleteEnabled("note")
+		So(err, ShouldBeNil)
+		So(enabled, ShouldBeTrue)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "someid"),
+			OwnerID: "user_id",
+			Data: map[string]interface{}{
+				"content": "some content",
+			},
+		}
+		So(db.Save(&record), ShouldBeNil)
+		So(db.Delete(record.ID), ShouldBeNil)
+
+		Convey("moves deleted record to trash", func() {
+			err := db.Get(record.ID, &skydb.Record{})
+			So(err, ShouldEqual, skydb.ErrRecordNotFound)
+
+			trashed := skydb.Record{}
+			So(db.GetTrashed(record.ID, &trashed), ShouldBeNil)
+			So(trashed.Data["content"], ShouldEqual, "some content")
+			So(trashed.DeletedAt.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("hides record in trash from query", func() {
+			rows, err := db.Query(&skydb.Query{Type: "note"})
+			So(err, ShouldBeNil)
+			So(rows.Scan(), ShouldBeFalse)
+
+			count, err := db.QueryCount(&skydb.Query{Type: "note"})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+
+			rows, err = db.QueryTrash(&skydb.Query{Type: "note"})
+			So(err, ShouldBeNil)
+			So(rows.Scan(), ShouldBeTrue)
+			So(rows.Record().ID, ShouldResemble, record.ID)
+		})
+
+		Convey("restores record", func() {
+			So(db.Restore(record.ID), ShouldBeNil)
+
+			restored := skydb.Record{}
+			So(db.Get(record.ID, &restored), ShouldBeNil)
+			So(restored.DeletedAt.IsZero(), ShouldBeTrue)
+
+			So(db.Restore(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("notifies restored record as created", func() {
+			So(db.Restore(record.ID), ShouldBeNil)
+
+			var op string
+			err := c.QueryRowx(
+				"SELECT op FROM public.pending_notification WHERE appname = $1 AND recordtype = 'note' ORDER BY id DESC LIMIT 1",
+				c.schemaName(),
+			).Scan(&op)
+			So(err, ShouldBeNil)
+			So(op, ShouldEqual, "INSERT")
+
+			n := notification{}
+			So(parseNotification(&rawNotification{
+				AppName:    c.schemaName(),
+				Op:         op,
+				RecordType: "note",
+				Record:     []byte(`{"_id": "someid", "_database_id": "userid", "_owner_id": "user_id", "content": "some content"}`),
+			}, &n), ShouldBeNil)
+			So(n.ChangeEvent, ShouldEqual, skydb.RecordCreated)
+			So(n.Ignored, ShouldBeFalse)
+		})
+
+		Convey("purges record", func() {
+			So(db.Purge(record.ID), ShouldBeNil)
+			So(db.GetTrashed(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("purges record by age", func() {
+			count, err := db.PurgeTrash("note", time.Now().Add(-time.Hour))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+
+			count, err = db.PurgeTrash("note", time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("purges records in trash when soft delete is disabled", func() {
+			So(db.SetSoftDeleteEnabled("note", false), ShouldBeNil)
+
+			enabled, err := db.SoftDeleteEnabled("note")
+			So(err, ShouldBeNil)
+			So(enabled, ShouldBeFalse)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+	})
+}
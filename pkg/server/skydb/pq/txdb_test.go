@@ -134,6 +134,27 @@ func TestTxDB(t *testing.T) {
 
 			So(db.Begin(), ShouldEqual, nil)
 		})
+		Convey("reads one snapshot in a read-only snapshot transaction", func() {
+			So(c.BeginSnapshot(), ShouldBeNil)
+			defer c.Rollback()
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("record", "1"), &record), ShouldBeNil)
+
+			insertRow(t, c.db, `UPDATE "record" SET "content" = 'changed1' WHERE _id = '1'`)
+
+			So(db.Get(skydb.NewRecordID("record", "1"), &record), ShouldBeNil)
+			So(record.Data["content"], ShouldEqual, "original1")
+
+			err := c.WithSavepoint(func() error {
+				return db.Delete(skydb.NewRecordID("record", "1"))
+			})
+			So(err, ShouldNotBeNil)
+
+			So(c.WithSavepoint(func() error {
+				return db.Get(skydb.NewRecordID("record", "2"), &record)
+			}), ShouldBeNil)
+		})
 	})
 
 	Convey("TxDatabase with Context", t, func() {
@@ -19,6 +19,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/paulmach/go.geo"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -42,6 +44,10 @@ const (
 	TypeSerial                = "serial UNIQUE"
 	TypeBigInteger            = "bigint"
 	TypeGeometry              = "geometry"
+
+	// typeArraySuffix is appended to an element type to denote
+	// an array of the element type
+	typeArraySuffix = "[]"
 )
 
 func pqDataType(dataType skydb.DataType) string {
@@ -69,6 +75,51 @@ func pqDataType(dataType skydb.DataType) string {
 	}
 }
 
+// pqFieldType returns the postgreSQL type of a column for the field type.
+// Unlike pqDataType, the element type of a list is also taken into account.
+func pqFieldType(fieldType skydb.FieldType) string {
+	if fieldType.Type == skydb.TypeList {
+		if !fieldType.ElementType.IsListElementType() {
+			panic(fmt.Sprintf("Unsupported list element dataType = %s", fieldType.ElementType))
+		}
+		return pqDataType(fieldType.ElementType) + typeArraySuffix
+	}
+	return pqDataType(fieldType.Type)
+}
+
+// listElementDataType returns the element type of an array type in
+// postgreSQL. The second value is false if the type is not an array type
+// that can be represented as a TypeList.
+func listElementDataType(pqType string) (skydb.DataType, bool) {
+	if !strings.HasSuffix(pqType, typeArraySuffix) {
+		return 0, false
+	}
+
+	switch strings.TrimSuffix(pqType, typeArraySuffix) {
+	case TypeString, TypeCaseInsensitiveString:
+		return skydb.TypeString, true
+	case TypeNumber:
+		return skydb.TypeNumber, true
+	case TypeInteger, TypeBigInteger:
+		return skydb.TypeInteger, true
+	case TypeBoolean:
+		return skydb.TypeBoolean, true
+	case TypeTimestamp:
+		return skydb.TypeDateTime, true
+	}
+	return 0, false
+}
+
+// listValueSQL returns the SQL expression that converts a placeholder
+// of a JSON array to an array of the element type.
+func listValueSQL(placeholder string, elementType skydb.DataType) string {
+	return fmt.Sprintf(
+		"CASE WHEN %[1]s::jsonb IS NULL THEN NULL ELSE ARRAY(SELECT jsonb_array_elements_text(%[1]s::jsonb))::%[2]s END",
+		placeholder,
+		pqDataType(elementType)+typeArraySuffix,
+	)
+}
+
 type nullJSON struct {
 	JSON  interface{}
 	Valid bool
@@ -197,6 +248,49 @@ func (ng *nullGeometry) Scan(value interface{}) error {
 	return err
 }
 
+// nullList scans a list column, which is selected as a JSON array
+// by to_jsonb.
+type nullList struct {
+	ElementType skydb.DataType
+	Slice       []interface{}
+	Valid       bool
+}
+
+func (nl *nullList) Scan(value interface{}) error {
+	data, ok := value.([]byte)
+	if value == nil || !ok {
+		nl.Slice = nil
+		nl.Valid = false
+		return nil
+	}
+
+	nl.Slice = []interface{}{}
+	if err := json.Unmarshal(data, &nl.Slice); err != nil {
+		nl.Valid = false
+		return err
+	}
+
+	for i, element := range nl.Slice {
+		switch v := element.(type) {
+		case float64:
+			if nl.ElementType == skydb.TypeInteger {
+				nl.Slice[i] = int64(v)
+			}
+		case string:
+			if nl.ElementType == skydb.TypeDateTime {
+				t, err := time.ParseInLocation("2006-01-02T15:04:05.999999", v, time.UTC)
+				if err != nil {
+					return fmt.Errorf("failed to scan List: malformed datetime %s", v)
+				}
+				nl.Slice[i] = t
+			}
+		}
+	}
+
+	nl.Valid = true
+	return nil
+}
+
 type referenceValue skydb.Reference
 
 func (ref referenceValue) Value() (driver.Value, error) {
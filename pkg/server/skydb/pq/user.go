@@ -229,17 +229,24 @@ func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error
 	for _, keys := range authRecordKeys {
 		requiredIndexes = append(requiredIndexes, skydb.Index{
 			Fields: keys,
+			Unique: true,
 		})
 	}
 
-	indexesByName, err := db.GetIndexesByRecordType(userRecordType)
+	allIndexesByName, err := db.GetIndexesByRecordType(userRecordType)
 	if err != nil {
 		return err
 	}
 
+	// Only unique indexes on all values of fields make the fields
+	// unique.
+	indexesByName := map[string]skydb.Index{}
 	indexes := []skydb.Index{}
-	for _, index := range indexesByName {
-		indexes = append(indexes, index)
+	for indexName, index := range allIndexesByName {
+		if isFieldsUniqueIndex(index) {
+			indexesByName[indexName] = index
+			indexes = append(indexes, index)
+		}
 	}
 
 	requiredIndexesByFields := groupIndexesByFields(requiredIndexes)
@@ -272,6 +279,13 @@ func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error
 	return nil
 }
 
+func isFieldsUniqueIndex(index skydb.Index) bool {
+	return index.Unique &&
+		index.Status == skydb.IndexReady &&
+		len(index.Expressions) == 0 &&
+		!strings.Contains(index.Definition, " WHERE ")
+}
+
 func getAllAuthRecordKeys(authRecordKeys [][]string) []string {
 	recordKeyMap := map[string]bool{}
 	for _, keys := range authRecordKeys {
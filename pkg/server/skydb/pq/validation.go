@@ -0,0 +1,95 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// validationChanged returns whether the validation rules of a field is
+// changed by the requested field type. A nil validation does not change
+// the rules, while an empty validation removes them.
+func validationChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Validation == nil {
+		return false
+	}
+	if requested.Validation.IsEmpty() {
+		return !remote.Validation.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Validation, requested.Validation)
+}
+
+// saveFieldValidation saves the validation rules of the field to the
+// `_record_field_validation` table, or removes them if they are empty.
+func (db *database) saveFieldValidation(tx *sqlx.Tx, recordType, field string, validation *skydb.FieldValidation) error {
+	tableName := db.TableName("_record_field_validation")
+	if validation.IsEmpty() {
+		stmt := fmt.Sprintf(`DELETE FROM %s WHERE record_type = $1 AND record_field = $2`, tableName)
+		if _, err := tx.Exec(stmt, recordType, field); err != nil {
+			return fmt.Errorf("failed to remove validation of %s.%s: %s", recordType, field, err)
+		}
+		return nil
+	}
+
+	rules, err := json.Marshal(validation)
+	if err != nil {
+		return fmt.Errorf("failed to encode validation of %s.%s: %s", recordType, field, err)
+	}
+
+	stmt := fmt.Sprintf(`
+INSERT INTO %s (record_type, record_field, rules) VALUES ($1, $2, $3)
+ON CONFLICT (record_type, record_field) DO UPDATE SET rules = EXCLUDED.rules`, tableName)
+	if _, err := tx.Exec(stmt, recordType, field, rules); err != nil {
+		return fmt.Errorf("failed to save validation of %s.%s: %s", recordType, field, err)
+	}
+	return nil
+}
+
+// getFieldValidations returns the validation rules of the fields of the
+// record type, keyed by field name.
+func (db *database) getFieldValidations(recordType string) (map[string]*skydb.FieldValidation, error) {
+	builder := psql.Select("record_field", "rules").
+		From(db.TableName("_record_field_validation")).
+		Where("record_type = ?", recordType)
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	validations := map[string]*skydb.FieldValidation{}
+	for rows.Next() {
+		var (
+			field string
+			rules []byte
+		)
+		if err := rows.Scan(&field, &rules); err != nil {
+			return nil, err
+		}
+
+		validation := skydb.FieldValidation{}
+		if err := json.Unmarshal(rules, &validation); err != nil {
+			return nil, fmt.Errorf("failed to decode validation of %s.%s: %s", recordType, field, err)
+		}
+		validations[field] = &validation
+	}
+	return validations, rows.Err()
+}
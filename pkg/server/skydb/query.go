@@ -17,6 +17,7 @@
 package skydb
 
 import (
+	"regexp"
 	"strings"
 
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
@@ -73,6 +74,10 @@ const (
 	ILike
 	In
 	Functional
+	ContainsAny
+	ContainsAll
+	Contains
+	HasKey
 )
 
 // IsCompound checks whether the Operator is a compound operator, meaning the
@@ -92,7 +97,7 @@ func (op Operator) IsBinary() bool {
 	switch op {
 	default:
 		return false
-	case Equal, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual, NotEqual, Like, ILike, In:
+	case Equal, GreaterThan, LessThan, GreaterThanOrEqual, LessThanOrEqual, NotEqual, Like, ILike, In, ContainsAny, ContainsAll, Contains, HasKey:
 		return true
 	}
 }
@@ -228,11 +233,15 @@ func (p Predicate) validate(parentPredicate *Predicate) skyerr.Error {
 		}
 	} else {
 		for _, child := range p.Children {
-			_, ok := child.(Expression)
+			expr, ok := child.(Expression)
 			if !ok {
 				return skyerr.NewError(skyerr.RecordQueryInvalid,
 					"children of simple predicate must be an expression")
 			}
+			if expr.Type == Function && IsAggregateFunc(expr.Value.(Func)) {
+				return skyerr.NewError(skyerr.RecordQueryInvalid,
+					"aggregate function cannot be used in predicate")
+			}
 		}
 	}
 
@@ -241,6 +250,10 @@ func (p Predicate) validate(parentPredicate *Predicate) skyerr.Error {
 		return p.validateFunctionalPredicate(parentPredicate)
 	case Equal:
 		return p.validateEqualPredicate(parentPredicate)
+	case ContainsAny, ContainsAll:
+		return p.validateContainsPredicate(parentPredicate)
+	case Contains, HasKey:
+		return p.validateJSONPredicate(parentPredicate)
 	}
 	return nil
 }
@@ -259,6 +272,26 @@ func (p Predicate) validateFunctionalPredicate(parentPredicate *Predicate) skyer
 				`user relation predicate with "%d" relation is not supported`,
 				f.RelationName)
 		}
+	case SearchFunc:
+		if f.Terms == "" {
+			return skyerr.NewError(skyerr.RecordQueryInvalid,
+				`search predicate must have non-empty terms`)
+		}
+	case WithinFunc:
+		if len(f.Geometry) == 0 {
+			return skyerr.NewError(skyerr.RecordQueryInvalid,
+				`within predicate must have non-empty geometry`)
+		}
+	case IntersectsFunc:
+		if len(f.Geometry) == 0 {
+			return skyerr.NewError(skyerr.RecordQueryInvalid,
+				`intersects predicate must have non-empty geometry`)
+		}
+	case BoundingBoxFunc:
+		if f.SouthWest.Lng() > f.NorthEast.Lng() || f.SouthWest.Lat() > f.NorthEast.Lat() {
+			return skyerr.NewError(skyerr.RecordQueryInvalid,
+				`bbox predicate must have south-west corner before north-east corner`)
+		}
 	default:
 		return skyerr.NewError(skyerr.NotSupported,
 			`unsupported function for functional predicate`)
@@ -266,6 +299,40 @@ func (p Predicate) validateFunctionalPredicate(parentPredicate *Predicate) skyer
 	return nil
 }
 
+func (p Predicate) validateContainsPredicate(parentPredicate *Predicate) skyerr.Error {
+	lhs := p.Children[0].(Expression)
+	rhs := p.Children[1].(Expression)
+
+	if !lhs.IsKeyPath() {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`left operand of %v must be a keypath`, p.Operator)
+	}
+	if !rhs.IsLiteralArray() {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`right operand of %v must be an array`, p.Operator)
+	}
+	return nil
+}
+
+func (p Predicate) validateJSONPredicate(parentPredicate *Predicate) skyerr.Error {
+	lhs := p.Children[0].(Expression)
+	rhs := p.Children[1].(Expression)
+
+	if !lhs.IsKeyPath() {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`left operand of %v must be a keypath`, p.Operator)
+	}
+	if p.Operator == HasKey && !rhs.IsLiteralString() {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`right operand of %v must be a string`, p.Operator)
+	}
+	if rhs.Type != Literal || rhs.IsLiteralNull() {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`right operand of %v must be a non-null value`, p.Operator)
+	}
+	return nil
+}
+
 func (p Predicate) validateEqualPredicate(parentPredicate *Predicate) skyerr.Error {
 	lhs := p.Children[0].(Expression)
 	rhs := p.Children[1].(Expression)
@@ -355,10 +422,46 @@ type Query struct {
 	Limit        *uint64
 	Offset       uint64
 
+	// Aggregations specifies aggregate functions computed over records
+	// matching the predicate, keyed by the name of the aggregated value.
+	// The records themselves are not returned by a query with
+	// aggregations.
+	Aggregations map[string]Expression
+
+	// GroupBy specifies the key paths by which records are grouped
+	// when computing Aggregations.
+	GroupBy []string
+
+	// After and Before restrict the result to records positioned after
+	// or before the specified cursors in the sorting order of the query.
+	After  *Cursor
+	Before *Cursor
+
+	// ReverseIncludes specifies, keyed by transient key, the records
+	// referencing each of the query results to be included with the
+	// result. Like key paths in ComputedKeys, they are not queried by
+	// the Database.
+	ReverseIncludes map[string]ReverseInclude
+
 	// The following fields are generated from the server side, rather
 	// than supplied from the client side.
 	ViewAsUser          *AuthInfo
 	BypassAccessControl bool
+
+	// AccessLevel is the access level ViewAsUser is required to have on
+	// the records in the result. ReadLevel is assumed if it is empty.
+	AccessLevel RecordACLLevel
+}
+
+// ReverseInclude specifies the records of RecordType referencing a record
+// with the reference field Field. The referencing records are sorted by
+// Sorts, and at most Limit records are included for each referenced
+// record.
+type ReverseInclude struct {
+	RecordType string
+	Field      string
+	Sorts      []Sort
+	Limit      *uint64
 }
 
 // Accept implements the Visitor pattern.
@@ -382,7 +485,67 @@ func (q Query) Accept(visitor Visitor) {
 		for _, expr := range q.ComputedKeys {
 			expr.Accept(v)
 		}
+		for _, expr := range q.Aggregations {
+			expr.Accept(v)
+		}
+		for _, keyPath := range q.GroupBy {
+			Expression{Type: KeyPath, Value: keyPath}.Accept(v)
+		}
+	}
+}
+
+// Cursor denotes the position of a record in the result of a Query.
+//
+// Values contains the value of the record for each of the Sorts of the
+// Query. ID is the key of the record, which breaks ties between records
+// having identical values.
+type Cursor struct {
+	Values []interface{}
+	ID     string
+}
+
+// CursorForRecord returns the Cursor of the specified record in the
+// result of this Query.
+//
+// Only sorts on a field of the queried record type are supported, an error
+// is returned if the query is sorted by a function or a related record.
+func (q Query) CursorForRecord(record *Record) (*Cursor, error) {
+	if err := q.checkCursorSorts(); err != nil {
+		return nil, err
 	}
+
+	values := make([]interface{}, len(q.Sorts))
+	for i, sort := range q.Sorts {
+		values[i] = record.Get(sort.Expression.Value.(string))
+	}
+
+	return &Cursor{
+		Values: values,
+		ID:     record.ID.Key,
+	}, nil
+}
+
+// ValidateCursor checks whether the cursor can be applied to this Query.
+func (q Query) ValidateCursor(cursor *Cursor) skyerr.Error {
+	if cursor.ID == "" {
+		return skyerr.NewInvalidArgument("cursor is missing record id", []string{"cursor"})
+	}
+	if len(cursor.Values) != len(q.Sorts) {
+		return skyerr.NewInvalidArgument("cursor does not match sort of the query", []string{"cursor"})
+	}
+	return q.checkCursorSorts()
+}
+
+func (q Query) checkCursorSorts() skyerr.Error {
+	for _, sort := range q.Sorts {
+		if !sort.Expression.IsKeyPath() || len(sort.Expression.KeyPathComponents()) != 1 {
+			return skyerr.NewError(
+				skyerr.NotSupported,
+				"cursor is only supported for query sorted by fields of the record",
+			)
+		}
+	}
+	return nil
 }
 
 // Func is a marker interface to denote a type being a function in skydb.
@@ -424,6 +587,71 @@ func (f DistanceFunc) ReferencedKeyPaths() []string {
 	return []string{f.Field}
 }
 
+// WithinFunc represents a function that determines whether a Record's
+// geometry field lies completely inside a user supplied geometry
+type WithinFunc struct {
+	Field    string
+	Geometry Geometry
+}
+
+// Args implements the Func interface
+func (f WithinFunc) Args() []interface{} {
+	return []interface{}{f.Field, f.Geometry}
+}
+
+func (f WithinFunc) DataType() DataType {
+	return TypeBoolean
+}
+
+// ReferencedKeyPaths implements the KeyPathFunc interface.
+func (f WithinFunc) ReferencedKeyPaths() []string {
+	return []string{f.Field}
+}
+
+// IntersectsFunc represents a function that determines whether a Record's
+// geometry field shares any portion of space with a user supplied geometry
+type IntersectsFunc struct {
+	Field    string
+	Geometry Geometry
+}
+
+// Args implements the Func interface
+func (f IntersectsFunc) Args() []interface{} {
+	return []interface{}{f.Field, f.Geometry}
+}
+
+func (f IntersectsFunc) DataType() DataType {
+	return TypeBoolean
+}
+
+// ReferencedKeyPaths implements the KeyPathFunc interface.
+func (f IntersectsFunc) ReferencedKeyPaths() []string {
+	return []string{f.Field}
+}
+
+// BoundingBoxFunc represents a function that determines whether the
+// bounding box of a Record's geometry field intersects with a user
+// supplied box, which is specified by its south-west and north-east corners
+type BoundingBoxFunc struct {
+	Field     string
+	SouthWest Location
+	NorthEast Location
+}
+
+// Args implements the Func interface
+func (f BoundingBoxFunc) Args() []interface{} {
+	return []interface{}{f.Field, f.SouthWest, f.NorthEast}
+}
+
+func (f BoundingBoxFunc) DataType() DataType {
+	return TypeBoolean
+}
+
+// ReferencedKeyPaths implements the KeyPathFunc interface.
+func (f BoundingBoxFunc) ReferencedKeyPaths() []string {
+	return []string{f.Field}
+}
+
 // CountFunc represents a function that count number of rows matching
 // a query
 type CountFunc struct {
@@ -439,6 +667,107 @@ func (f CountFunc) DataType() DataType {
 	return TypeNumber
 }
 
+// SearchMode denotes how the terms of a SearchFunc are matched.
+type SearchMode int
+
+// A list of SearchMode.
+const (
+	// PlainSearch matches text containing all words of the terms.
+	PlainSearch SearchMode = iota
+	// PhraseSearch matches text containing the terms as a phrase.
+	PhraseSearch
+)
+
+// DefaultSearchLanguage is the text search configuration used when
+// the language of a SearchFunc is not specified. It performs no
+// language-specific stemming and stop words removal.
+const DefaultSearchLanguage = "simple"
+
+var searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// IsValidSearchLanguage returns true if the language is a valid name
+// of text search configuration, such as "english".
+func IsValidSearchLanguage(language string) bool {
+	return searchLanguagePattern.MatchString(language)
+}
+
+// SearchFunc represents a function that performs full-text search
+// on a text field of a record.
+//
+// When used as a functional predicate, the function determines whether
+// the field matches the search terms. When used in a sort or a computed
+// key, the function returns the relevance of the field to the terms.
+type SearchFunc struct {
+	Field    string
+	Terms    string
+	Language string
+	Mode     SearchMode
+}
+
+// Args implements the Func interface
+func (f SearchFunc) Args() []interface{} {
+	return []interface{}{f.Field, f.Terms}
+}
+
+// DataType implements the Func interface
+func (f SearchFunc) DataType() DataType {
+	return TypeNumber
+}
+
+// ReferencedKeyPaths implements the KeyPathFunc interface.
+func (f SearchFunc) ReferencedKeyPaths() []string {
+	return []string{f.Field}
+}
+
+// AggregateOperator denotes the operation of an AggregateFunc.
+type AggregateOperator int
+
+// A list of AggregateOperator.
+const (
+	Sum AggregateOperator = iota + 1
+	Avg
+	Min
+	Max
+)
+
+// AggregateFunc represents a function that computes a single value from
+// a field of all records in a group.
+type AggregateFunc struct {
+	Operator AggregateOperator
+	Field    string
+}
+
+// Args implements the Func interface
+func (f AggregateFunc) Args() []interface{} {
+	return []interface{}{f.Field}
+}
+
+// DataType implements the Func interface
+//
+// Min and Max can also be applied on fields of other comparable types,
+// in which case the result has the same type as the field.
+func (f AggregateFunc) DataType() DataType {
+	return TypeNumber
+}
+
+// ReferencedKeyPaths implements the KeyPathFunc interface.
+func (f AggregateFunc) ReferencedKeyPaths() []string {
+	return []string{f.Field}
+}
+
+// IsAggregateFunc returns true if the function computes a value from
+// a group of records, which can only be used in Query.Aggregations.
+func IsAggregateFunc(f Func) bool {
+	switch f := f.(type) {
+	case AggregateFunc:
+		return true
+	case CountFunc:
+		return !f.OverallRecords
+	default:
+		return false
+	}
+}
+
 // UserRelationFunc represents a function that is used to evaulate
 // whether a record satisfy certain user-based relation
 type UserRelationFunc struct {
@@ -213,4 +213,60 @@ func TestMalformedPredicate(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 	})
+
+	Convey("Predicate with ContainsAny", t, func() {
+		Convey("comparing keypath with array", func() {
+			predicate := Predicate{
+				Operator: ContainsAny,
+				Children: []interface{}{
+					Expression{
+						Type:  KeyPath,
+						Value: "tags",
+					},
+					Expression{
+						Type:  Literal,
+						Value: []interface{}{"a", "b"},
+					},
+				},
+			}
+			err := predicate.Validate()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("comparing keypath with string", func() {
+			predicate := Predicate{
+				Operator: ContainsAny,
+				Children: []interface{}{
+					Expression{
+						Type:  KeyPath,
+						Value: "tags",
+					},
+					Expression{
+						Type:  Literal,
+						Value: "a",
+					},
+				},
+			}
+			err := predicate.Validate()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("comparing array with keypath", func() {
+			predicate := Predicate{
+				Operator: ContainsAll,
+				Children: []interface{}{
+					Expression{
+						Type:  Literal,
+						Value: []interface{}{"a", "b"},
+					},
+					Expression{
+						Type:  KeyPath,
+						Value: "tags",
+					},
+				},
+			}
+			err := predicate.Validate()
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
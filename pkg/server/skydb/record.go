@@ -139,6 +139,14 @@ func (loc Location) Lat() float64 {
 	return loc[1]
 }
 
+// Geometry returns the location as a point in GeoJSON.
+func (loc Location) Geometry() Geometry {
+	return Geometry{
+		"type":        "Point",
+		"coordinates": []interface{}{loc.Lng(), loc.Lat()},
+	}
+}
+
 // String returns a human-readable representation of this Location.
 // Coincidentally it is in WKT.
 func (loc Location) String() string {
@@ -169,6 +177,7 @@ type Record struct {
 	CreatorID  string
 	UpdatedAt  time.Time
 	UpdaterID  string
+	DeletedAt  time.Time
 	ACL        RecordACL
 	Data       Data
 	Transient  Data `json:"-"`
@@ -209,6 +218,8 @@ func (r *Record) Get(key string) interface{} {
 			return r.UpdatedAt
 		case "_updated_by":
 			return r.UpdaterID
+		case "_deleted_at":
+			return r.DeletedAt
 		case "_transient":
 			return r.Transient
 		default:
@@ -247,6 +258,8 @@ func (r *Record) Set(key string, i interface{}) {
 			r.UpdatedAt = i.(time.Time)
 		case "_updated_by":
 			r.UpdaterID = i.(string)
+		case "_deleted_at":
+			r.DeletedAt = i.(time.Time)
 		case "_transient":
 			r.Transient = i.(Data)
 		default:
@@ -345,11 +358,6 @@ func (r *Record) MergedCopy(merge *Record) Record {
 	return dst
 }
 
-// Index indicates the value of fields within a record type cannot be duplicated
-type Index struct {
-	Fields []string
-}
-
 // RecordSchema is a mapping of record key to its value's data type or reference
 type RecordSchema map[string]FieldType
 
@@ -392,12 +400,46 @@ func (schema RecordSchema) HasFields(fields []string) bool {
 	return true
 }
 
+// ReferentialAction is the action performed on the records referencing
+// a record when the referenced record is deleted.
+type ReferentialAction string
+
+// List of ReferentialAction
+const (
+	// NoReferentialAction leaves the referencing records unchanged. The
+	// deletion fails if the database does not allow dangling references.
+	NoReferentialAction ReferentialAction = ""
+	// CascadeAction deletes the referencing records.
+	CascadeAction ReferentialAction = "cascade"
+	// SetNullAction sets the reference fields of the referencing records
+	// to null.
+	SetNullAction ReferentialAction = "set_null"
+	// RestrictAction fails the deletion if any record references the
+	// record being deleted.
+	RestrictAction ReferentialAction = "restrict"
+)
+
+// IsValid returns whether the ReferentialAction is one of the supported
+// actions.
+func (a ReferentialAction) IsValid() bool {
+	switch a {
+	case NoReferentialAction, CascadeAction, SetNullAction, RestrictAction:
+		return true
+	default:
+		return false
+	}
+}
+
 // FieldType represents the kind of data living within a field of a RecordSchema.
 type FieldType struct {
 	Type           DataType
-	ReferenceType  string     // used only by TypeReference
-	Expression     Expression // used by Computed Keys
-	UnderlyingType string     // indicates the underlying (pq) type
+	ReferenceType  string            // used only by TypeReference
+	OnDelete       ReferentialAction // used only by TypeReference
+	ElementType    DataType          // used only by TypeList
+	Expression     Expression        // used by Computed Keys
+	UnderlyingType string            // indicates the underlying (pq) type
+	Validation     *FieldValidation  // nil if the field is not validated
+	Default        *FieldDefault     // nil if the field has no default value
 }
 
 // DefinitionCompatibleTo returns if a value of the specifed FieldType can
@@ -419,15 +461,59 @@ func (f FieldType) DefinitionCompatibleTo(other FieldType) bool {
 	}
 
 	if f.Type == TypeGeometry && other.Type.IsGeometryCompatibleType() {
-		// Note: Saving skydb.Location to skydb.Geometry is currently
-		// not supported (see #343)
-		//return true
-		return other.Type == TypeGeometry
+		// A location is saved to a geometry as a point.
+		return true
+	}
+
+	if f.Type == TypeList {
+		// Arrays in a record are derived as TypeJSON, which can be
+		// saved to a list when elements are of the element type.
+		if other.Type == TypeJSON {
+			return true
+		}
+		return f.Type == other.Type && f.ElementType == other.ElementType
 	}
 
 	return f.Type == other.Type
 }
 
+// CastableTo returns if the values of a field of this FieldType can be
+// converted to the specified FieldType when the type of the field is
+// changed. Like DefinitionCompatibleTo, whether the conversion of a value
+// is successful is subject to the actual value.
+//
+// Besides converting between number types, values can be converted from
+// and to string, and a reference can be changed to refer to another
+// record type.
+func (f FieldType) CastableTo(other FieldType) bool {
+	switch other.Type {
+	case TypeString:
+		switch f.Type {
+		case TypeNumber, TypeInteger, TypeBoolean, TypeDateTime, TypeJSON, TypeReference:
+			return true
+		}
+	case TypeNumber, TypeInteger:
+		switch f.Type {
+		case TypeString, TypeBoolean:
+			return true
+		case TypeSequence:
+			return false
+		}
+		return f.Type != other.Type && f.DefinitionCompatibleTo(other)
+	case TypeBoolean:
+		switch f.Type {
+		case TypeString, TypeNumber, TypeInteger:
+			return true
+		}
+	case TypeDateTime:
+		return f.Type == TypeString
+	case TypeReference:
+		return f.Type == TypeString ||
+			f.Type == TypeReference && f.ReferenceType != other.ReferenceType
+	}
+	return false
+}
+
 func (f FieldType) ToSimpleName() string {
 	switch f.Type {
 	case TypeString:
@@ -456,6 +542,9 @@ func (f FieldType) ToSimpleName() string {
 		return "geometry"
 	case TypeUnknown:
 		return "unknown"
+	case TypeList:
+		element := FieldType{Type: f.ElementType}
+		return fmt.Sprintf("list(%s)", element.ToSimpleName())
 	}
 	return ""
 }
@@ -479,6 +568,7 @@ const (
 	TypeSequence
 	TypeGeometry
 	TypeUnknown
+	TypeList
 )
 
 // IsNumberCompatibleType returns true if the type is a numeric type
@@ -495,6 +585,17 @@ func (t DataType) IsGeometryCompatibleType() bool {
 	return t == TypeLocation || t == TypeGeometry
 }
 
+// IsListElementType returns true if the type can be the element type
+// of a TypeList
+func (t DataType) IsListElementType() bool {
+	switch t {
+	case TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeDateTime:
+		return true
+	default:
+		return false
+	}
+}
+
 func SimpleNameToFieldType(s string) (result FieldType, err error) {
 	switch s {
 	case "string":
@@ -525,6 +626,18 @@ func SimpleNameToFieldType(s string) (result FieldType, err error) {
 		if regexp.MustCompile(`^ref\(.+\)$`).MatchString(s) {
 			result.Type = TypeReference
 			result.ReferenceType = s[4 : len(s)-1]
+		} else if regexp.MustCompile(`^list\(.+\)$`).MatchString(s) {
+			var element FieldType
+			element, err = SimpleNameToFieldType(s[5 : len(s)-1])
+			if err != nil {
+				return
+			}
+			if !element.Type.IsListElementType() {
+				err = fmt.Errorf("Unexpected list element type name: %s", s[5:len(s)-1])
+				return
+			}
+			result.Type = TypeList
+			result.ElementType = element.Type
 		} else {
 			err = fmt.Errorf("Unexpected type name: %s", s)
 			return
@@ -594,6 +707,9 @@ func DeriveFieldType(value interface{}) (fieldType FieldType, err error) {
 			Type:           TypeUnknown,
 			UnderlyingType: val.UnderlyingType,
 		}
+	case FieldOperation:
+		// the field has the type of the operand of the operation
+		return DeriveFieldType(val.Value)
 	}
 	return
 }
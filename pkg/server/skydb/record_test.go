@@ -236,3 +236,92 @@ func TestRecordSchema(t *testing.T) {
 		})
 	})
 }
+
+func TestFieldTypeCastableTo(t *testing.T) {
+	Convey("FieldType", t, func() {
+		Convey("is castable between number types", func() {
+			So(FieldType{Type: TypeInteger}.CastableTo(FieldType{Type: TypeNumber}), ShouldBeTrue)
+			So(FieldType{Type: TypeNumber}.CastableTo(FieldType{Type: TypeInteger}), ShouldBeTrue)
+			So(FieldType{Type: TypeSequence}.CastableTo(FieldType{Type: TypeInteger}), ShouldBeFalse)
+		})
+
+		Convey("is castable from and to string", func() {
+			So(FieldType{Type: TypeString}.CastableTo(FieldType{Type: TypeDateTime}), ShouldBeTrue)
+			So(FieldType{Type: TypeDateTime}.CastableTo(FieldType{Type: TypeString}), ShouldBeTrue)
+			So(FieldType{Type: TypeString}.CastableTo(FieldType{Type: TypeBoolean}), ShouldBeTrue)
+			So(FieldType{Type: TypeString}.CastableTo(FieldType{Type: TypeLocation}), ShouldBeFalse)
+		})
+
+		Convey("is castable to reference of another type", func() {
+			ref := FieldType{Type: TypeReference, ReferenceType: "note"}
+			So(ref.CastableTo(FieldType{Type: TypeReference, ReferenceType: "comment"}), ShouldBeTrue)
+			So(ref.CastableTo(ref), ShouldBeFalse)
+			So(FieldType{Type: TypeString}.CastableTo(ref), ShouldBeTrue)
+			So(FieldType{Type: TypeNumber}.CastableTo(ref), ShouldBeFalse)
+		})
+
+		Convey("is not castable to the same type", func() {
+			So(FieldType{Type: TypeString}.CastableTo(FieldType{Type: TypeString}), ShouldBeFalse)
+			So(FieldType{Type: TypeNumber}.CastableTo(FieldType{Type: TypeNumber}), ShouldBeFalse)
+		})
+	})
+}
+
+func TestListFieldType(t *testing.T) {
+	Convey("FieldType of list", t, func() {
+		Convey("converts from simple name", func() {
+			fieldType, err := SimpleNameToFieldType("list(string)")
+			So(err, ShouldBeNil)
+			So(fieldType, ShouldResemble, FieldType{
+				Type:        TypeList,
+				ElementType: TypeString,
+			})
+			So(fieldType.ToSimpleName(), ShouldEqual, "list(string)")
+		})
+
+		Convey("rejects unsupported element type", func() {
+			_, err := SimpleNameToFieldType("list(ref(note))")
+			So(err, ShouldNotBeNil)
+
+			_, err = SimpleNameToFieldType("list(list(string))")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("is compatible with list of same element type", func() {
+			target := FieldType{Type: TypeList, ElementType: TypeString}
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeList, ElementType: TypeString}), ShouldBeTrue)
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeList, ElementType: TypeNumber}), ShouldBeFalse)
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeString}), ShouldBeFalse)
+		})
+
+		Convey("is compatible with json", func() {
+			target := FieldType{Type: TypeList, ElementType: TypeString}
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeJSON}), ShouldBeTrue)
+		})
+	})
+}
+
+func TestGeometryFieldType(t *testing.T) {
+	Convey("FieldType of geometry", t, func() {
+		target := FieldType{Type: TypeGeometry}
+
+		Convey("is compatible with location", func() {
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeLocation}), ShouldBeTrue)
+			So(target.DefinitionCompatibleTo(FieldType{Type: TypeGeometry}), ShouldBeTrue)
+		})
+
+		Convey("location is not compatible with geometry", func() {
+			location := FieldType{Type: TypeLocation}
+			So(location.DefinitionCompatibleTo(target), ShouldBeFalse)
+		})
+	})
+
+	Convey("Location", t, func() {
+		Convey("converts to point geometry", func() {
+			So(NewLocation(1, 2).Geometry(), ShouldResemble, Geometry{
+				"type":        "Point",
+				"coordinates": []interface{}{float64(1), float64(2)},
+			})
+		})
+	})
+}
@@ -323,6 +323,55 @@ func (val MapUnknown) ToMap(m map[string]interface{}) {
 	m["$underlying_type"] = val.UnderlyingType
 }
 
+// MapFieldOperation is skydb.FieldOperation that can be converted from
+// and to a map of the form {"$inc": 1}.
+type MapFieldOperation skydb.FieldOperation
+
+// FromMap implements FromMapper
+func (op *MapFieldOperation) FromMap(m map[string]interface{}) error {
+	if len(m) != 1 {
+		return errors.New("field operation must have exactly one operator")
+	}
+
+	for key, value := range m {
+		operator := skydb.FieldOperator(key)
+		switch operator {
+		case skydb.IncrementOperator:
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("%s expects a number, got %T", key, value)
+			}
+		case skydb.AppendOperator, skydb.RemoveOperator:
+			if _, ok := value.([]interface{}); !ok {
+				return fmt.Errorf("%s expects a list, got %T", key, value)
+			}
+		default:
+			return fmt.Errorf("unknown field operator %s", key)
+		}
+		*op = MapFieldOperation{operator, value}
+	}
+	return nil
+}
+
+// ToMap implements ToMapper
+func (op MapFieldOperation) ToMap(m map[string]interface{}) {
+	m[string(op.Operator)] = op.Value
+}
+
+// isFieldOperationMap returns whether the map denotes a field operation,
+// i.e. it has exactly one key which is a field operator or "$unset".
+func isFieldOperationMap(m map[string]interface{}) bool {
+	if len(m) != 1 {
+		return false
+	}
+	for key := range m {
+		switch skydb.FieldOperator(key) {
+		case skydb.IncrementOperator, skydb.AppendOperator, skydb.RemoveOperator, "$unset":
+			return true
+		}
+	}
+	return false
+}
+
 type MapACLEntry skydb.RecordACLEntry
 
 // FromMap initializes a RecordACLEntry from a unmarshalled JSON of
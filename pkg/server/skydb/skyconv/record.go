@@ -46,6 +46,8 @@ func (record *JSONRecord) MarshalJSON() ([]byte, error) {
 			data[key] = (MapSequence)(v)
 		case skydb.Unknown:
 			data[key] = (MapUnknown)(v)
+		case skydb.FieldOperation:
+			data[key] = (MapFieldOperation)(v)
 		default:
 			data[key] = value
 		}
@@ -73,6 +75,9 @@ func (record *JSONRecord) MarshalJSON() ([]byte, error) {
 	if record.UpdaterID != "" {
 		m["_updated_by"] = record.UpdaterID
 	}
+	if !record.DeletedAt.IsZero() {
+		m["_deleted_at"] = record.DeletedAt
+	}
 
 	transient := record.marshalTransient(record.Transient)
 	if len(transient) > 0 {
@@ -144,6 +149,37 @@ func (record *JSONRecord) FromMap(m map[string]interface{}) error {
 	return nil
 }
 
+// ParseFieldOperations replaces the field values of the form {"$inc": 1}
+// with skydb.FieldOperation. {"$unset": true} is replaced with nil, which
+// removes the value of the field.
+//
+// Only the fields of data are parsed. It is up to the caller to decide
+// whether the data may contain field operations, as a field value of the
+// same form is otherwise a plain JSON object.
+func ParseFieldOperations(data map[string]interface{}) error {
+	for key, value := range data {
+		m, ok := value.(map[string]interface{})
+		if !ok || !isFieldOperationMap(m) {
+			continue
+		}
+
+		if unset, ok := m["$unset"]; ok {
+			if unset != true {
+				return fmt.Errorf(`field "%s": $unset expects true`, key)
+			}
+			data[key] = nil
+			continue
+		}
+
+		var op skydb.FieldOperation
+		if err := (*MapFieldOperation)(&op).FromMap(m); err != nil {
+			return fmt.Errorf(`field "%s": %v`, key, err)
+		}
+		data[key] = op
+	}
+	return nil
+}
+
 func sanitizedDataMap(m map[string]interface{}) map[string]interface{} {
 	mm := map[string]interface{}{}
 	for key, value := range m {
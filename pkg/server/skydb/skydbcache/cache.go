@@ -0,0 +1,410 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var timeNow = time.Now
+
+// Cache caches the results of queries and fetches of records in a
+// Store.
+//
+// Query results are keyed by the normalized query, the database and the
+// access control context of the viewer. Queries with functions or key
+// paths spanning references are not cached, as their results depend on
+// records of other record types.
+//
+// Cached results are invalidated by the record events received by
+// Listen, and the whole cache is flushed when record events might have
+// been lost. Results are read from the primary database when they are
+// cached, as a result read from a lagging replica might be older than
+// the invalidation of its record type. Changes to records not emitting record events, such as
+// migrating the schema, are not reflected until the results of the
+// record type are invalidated again, or until the results are cached
+// longer than MaxAge.
+//
+// A nil Cache, or a Cache without a Store, does not cache anything.
+type Cache struct {
+	Store Store
+
+	// MaxAge is the longest duration results are cached. Zero means
+	// results are cached until they are invalidated.
+	MaxAge time.Duration
+
+	mutex       sync.Mutex
+	flushes     uint64
+	generations map[string]uint64
+}
+
+// generation identifies the cached results of a record type between
+// invalidations.
+type generation struct {
+	flushes       uint64
+	invalidations uint64
+}
+
+// NewCache returns a new Cache storing results in the store.
+func NewCache(store Store) *Cache {
+	return &Cache{
+		Store:       store,
+		generations: map[string]uint64{},
+	}
+}
+
+// Listen subscribes to the record events of the Conn, and invalidates
+// the cached results of the record type of each changed record. If the
+// Conn implements skydb.RecordEventLossConn, the cache is flushed
+// whenever record events might have been lost.
+func (c *Cache) Listen(conn skydb.Conn) error {
+	ch := make(chan skydb.RecordEvent)
+	if err := conn.Subscribe(ch); err != nil {
+		return err
+	}
+
+	lossCh := make(chan struct{})
+	if lossConn, ok := conn.(skydb.RecordEventLossConn); ok {
+		if err := lossConn.SubscribeEventLoss(lossCh); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event := <-ch:
+				c.Invalidate(event.Record.ID.Type)
+			case <-lossCh:
+				c.Flush()
+			}
+		}
+	}()
+	return nil
+}
+
+// Flush removes all cached results.
+func (c *Cache) Flush() {
+	if !c.enabled() {
+		return
+	}
+
+	c.mutex.Lock()
+	c.flushes++
+	c.mutex.Unlock()
+
+	c.Store.Flush()
+}
+
+// Invalidate removes the cached results of the record type.
+func (c *Cache) Invalidate(recordType string) {
+	if !c.enabled() {
+		return
+	}
+
+	// Results read from the database before the invalidation are not
+	// stored afterwards, as the generation has changed.
+	c.mutex.Lock()
+	if c.generations == nil {
+		c.generations = map[string]uint64{}
+	}
+	c.generations[recordType]++
+	c.mutex.Unlock()
+
+	c.Store.Invalidate(recordType)
+}
+
+// Query executes the query against the database, returning the cached
+// result if one is available.
+func (c *Cache) Query(db skydb.Database, query *skydb.Query) (*skydb.Rows, error) {
+	if !c.enabled() || !cacheable(query) {
+		return db.Query(query)
+	}
+
+	key, err := queryKey(db, query)
+	if err != nil {
+		return db.Query(query)
+	}
+
+	if entry, ok := c.get(key); ok {
+		return newEntryRows(entry), nil
+	}
+
+	generation := c.generation(query.Type)
+	defer readFromPrimary(db)()
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entry := &Entry{
+		RecordType: query.Type,
+		Records:    []skydb.Record{},
+	}
+	for rows.Scan() {
+		entry.Records = append(entry.Records, rows.Record())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	entry.OverallCount = rows.OverallRecordCount()
+
+	expiring, err := setEntryExpiry(db, entry)
+	if err != nil {
+		return nil, err
+	}
+	// The count of an expiring record type may include expired records
+	// not in the result, so it is not cached.
+	if !expiring || !query.GetCount {
+		c.set(key, generation, entry)
+	}
+
+	return newEntryRows(entry), nil
+}
+
+// Get fetches the record from the database, returning the cached record
+// if one is available. Records not found are not cached.
+func (c *Cache) Get(db skydb.Database, id skydb.RecordID, record *skydb.Record) error {
+	if !c.enabled() {
+		return db.Get(id, record)
+	}
+
+	key := fmt.Sprintf("get:%s:%s", db.ID(), id)
+	if entry, ok := c.get(key); ok {
+		*record = entry.Records[0].Copy()
+		return nil
+	}
+
+	generation := c.generation(id.Type)
+	defer readFromPrimary(db)()
+	if err := db.Get(id, record); err != nil {
+		return err
+	}
+
+	entry := &Entry{
+		RecordType: id.Type,
+		Records:    []skydb.Record{record.Copy()},
+	}
+	if _, err := setEntryExpiry(db, entry); err != nil {
+		return err
+	}
+	c.set(key, generation, entry)
+	return nil
+}
+
+// readFromPrimary routes the reads of the database to the primary
+// database, and returns a function restoring the routing of reads.
+func readFromPrimary(db skydb.Database) (restore func()) {
+	conn, ok := db.Conn().(skydb.ReplicaConn)
+	if !ok || conn.ReadYourWrites() {
+		return func() {}
+	}
+
+	conn.SetReadYourWrites(true)
+	return func() {
+		conn.SetReadYourWrites(false)
+	}
+}
+
+func (c *Cache) enabled() bool {
+	return c != nil && c.Store != nil
+}
+
+func (c *Cache) generation(recordType string) generation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return generation{c.flushes, c.generations[recordType]}
+}
+
+func (c *Cache) get(key string) (*Entry, bool) {
+	entry, ok := c.Store.Get(key)
+	if !ok || entry.Expired(timeNow()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// set stores the entry unless the results of the record type are
+// invalidated since the generation. The entry becomes stale after
+// MaxAge if its records do not expire earlier.
+func (c *Cache) set(key string, gen generation, entry *Entry) {
+	if c.MaxAge > 0 {
+		expiresAt := timeNow().Add(c.MaxAge)
+		if entry.ExpiresAt.IsZero() || expiresAt.Before(entry.ExpiresAt) {
+			entry.ExpiresAt = expiresAt
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if (generation{c.flushes, c.generations[entry.RecordType]}) != gen {
+		return
+	}
+	c.Store.Set(key, entry)
+}
+
+// setEntryExpiry sets the entry to become stale when the first of its
+// records expires. It returns true if records of the record type expire.
+func setEntryExpiry(db skydb.Database, entry *Entry) (bool, error) {
+	expiryDB, ok := db.(skydb.RecordExpiryDatabase)
+	if !ok {
+		return false, nil
+	}
+
+	expiry, err := expiryDB.GetRecordExpiry(entry.RecordType)
+	if err != nil {
+		return false, err
+	}
+	if expiry.IsEmpty() {
+		return false, nil
+	}
+
+	for i := range entry.Records {
+		expiresAt, ok := expiry.ExpiresAt(&entry.Records[i])
+		if ok && (entry.ExpiresAt.IsZero() || expiresAt.Before(entry.ExpiresAt)) {
+			entry.ExpiresAt = expiresAt
+		}
+	}
+	return true, nil
+}
+
+// cacheable returns true if the result of the query depends on records
+// of the queried record type only.
+func cacheable(query *skydb.Query) bool {
+	if query.Type == "" || len(query.Aggregations) > 0 {
+		return false
+	}
+
+	visitor := &cacheableVisitor{cacheable: true}
+	query.Accept(visitor)
+	return visitor.cacheable
+}
+
+type cacheableVisitor struct {
+	cacheable bool
+}
+
+func (v *cacheableVisitor) VisitPredicate(p skydb.Predicate)    {}
+func (v *cacheableVisitor) EndVisitPredicate(p skydb.Predicate) {}
+func (v *cacheableVisitor) VisitSort(sort skydb.Sort)           {}
+func (v *cacheableVisitor) EndVisitSort(sort skydb.Sort)        {}
+
+func (v *cacheableVisitor) VisitExpression(expr skydb.Expression) {
+	switch {
+	case expr.Type == skydb.Function:
+		v.cacheable = false
+	case expr.IsKeyPath() && len(expr.KeyPathComponents()) > 1:
+		v.cacheable = false
+	}
+}
+
+func (v *cacheableVisitor) EndVisitExpression(expr skydb.Expression) {}
+
+// queryKey returns the key of the query executed against the database.
+// Literals are keyed with their types, so that literals of different
+// types having the same JSON representation are not confused.
+func queryKey(db skydb.Database, query *skydb.Query) (string, error) {
+	sorts := make([]interface{}, len(query.Sorts))
+	for i, sort := range query.Sorts {
+		sorts[i] = []interface{}{expressionKey(sort.Expression), sort.Order}
+	}
+
+	var userID string
+	var roles []string
+	if query.ViewAsUser != nil {
+		userID = query.ViewAsUser.ID
+		roles = query.ViewAsUser.Roles
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"database":      db.ID(),
+		"type":          query.Type,
+		"predicate":     predicateKey(query.Predicate),
+		"sorts":         sorts,
+		"desired_keys":  query.DesiredKeys,
+		"count":         query.GetCount,
+		"limit":         query.Limit,
+		"offset":        query.Offset,
+		"after":         query.After,
+		"before":        query.Before,
+		"user_id":       userID,
+		"roles":         roles,
+		"bypass_access": query.BypassAccessControl,
+		"access_level":  query.AccessLevel,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return "query:" + hex.EncodeToString(sum[:]), nil
+}
+
+func predicateKey(p skydb.Predicate) interface{} {
+	children := make([]interface{}, len(p.Children))
+	for i, child := range p.Children {
+		switch child := child.(type) {
+		case skydb.Predicate:
+			children[i] = predicateKey(child)
+		case skydb.Expression:
+			children[i] = expressionKey(child)
+		}
+	}
+	return []interface{}{p.Operator, children}
+}
+
+func expressionKey(expr skydb.Expression) interface{} {
+	return []interface{}{expr.Type, fmt.Sprintf("%T", expr.Value), expr.Value}
+}
+
+// entryRows iterates the records of an entry. The records are copied,
+// so that the cached records are not modified by the caller.
+type entryRows struct {
+	entry *Entry
+	index int
+}
+
+func newEntryRows(entry *Entry) *skydb.Rows {
+	return skydb.NewRows(&entryRows{entry: entry})
+}
+
+func (rs *entryRows) Close() error {
+	return nil
+}
+
+func (rs *entryRows) Next(record *skydb.Record) error {
+	if rs.index >= len(rs.entry.Records) {
+		return io.EOF
+	}
+
+	*record = rs.entry.Records[rs.index].Copy()
+	rs.index++
+	return nil
+}
+
+func (rs *entryRows) OverallRecordCount() *uint64 {
+	return rs.entry.OverallCount
+}
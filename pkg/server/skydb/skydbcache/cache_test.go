@@ -0,0 +1,349 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type countingDatabase struct {
+	*skydbtest.MapDB
+	queries  int
+	gets     int
+	expiries map[string]skydb.RecordExpiry
+}
+
+func (db *countingDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	db.queries++
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type == query.Type {
+			records = append(records, record)
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *countingDatabase) Get(id skydb.RecordID, record *skydb.Record) error {
+	db.gets++
+	return db.MapDB.Get(id, record)
+}
+
+// replicaConn records whether reads are served by the primary database
+// on each read.
+type replicaConn struct {
+	skydbtest.MapConn
+	readYourWrites bool
+	primaryReads   []bool
+}
+
+func (conn *replicaConn) SetReadYourWrites(enabled bool) {
+	conn.readYourWrites = enabled
+}
+
+func (conn *replicaConn) ReadYourWrites() bool {
+	return conn.readYourWrites
+}
+
+// replicaDatabase is a countingDatabase recording the routing of reads
+// of its replicaConn.
+type replicaDatabase struct {
+	*countingDatabase
+	conn *replicaConn
+}
+
+func (db *replicaDatabase) Conn() skydb.Conn {
+	return db.conn
+}
+
+func (db *replicaDatabase) Query(query *skydb.Query) (*skydb.Rows, error) {
+	db.conn.primaryReads = append(db.conn.primaryReads, db.conn.readYourWrites)
+	return db.countingDatabase.Query(query)
+}
+
+func (db *countingDatabase) GetRecordExpiry(recordType string) (skydb.RecordExpiry, error) {
+	return db.expiries[recordType], nil
+}
+
+func (db *countingDatabase) SetRecordExpiry(recordType string, expiry skydb.RecordExpiry) error {
+	db.expiries[recordType] = expiry
+	return nil
+}
+
+func (db *countingDatabase) GetRecordExpiries() (map[string]skydb.RecordExpiry, error) {
+	return db.expiries, nil
+}
+
+func (db *countingDatabase) QueryExpired(recordType string, at time.Time, after string, limit uint64) ([]skydb.Record, error) {
+	return []skydb.Record{}, nil
+}
+
+func (db *countingDatabase) IsExpired(id skydb.RecordID) (bool, error) {
+	return false, nil
+}
+
+func scanRecords(rows *skydb.Rows) []skydb.Record {
+	records := []skydb.Record{}
+	for rows.Scan() {
+		records = append(records, rows.Record())
+	}
+	return records
+}
+
+// eventLossConn passes the channels subscribed to the test.
+type eventLossConn struct {
+	skydbtest.MapConn
+	events chan chan skydb.RecordEvent
+	losses chan chan struct{}
+}
+
+func (conn *eventLossConn) Subscribe(ch chan skydb.RecordEvent) error {
+	conn.events <- ch
+	return nil
+}
+
+func (conn *eventLossConn) SubscribeEventLoss(ch chan struct{}) error {
+	conn.losses <- ch
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	Convey("Cache", t, func() {
+		db := &countingDatabase{
+			MapDB:    skydbtest.NewMapDB(),
+			expiries: map[string]skydb.RecordExpiry{},
+		}
+		category := skydb.Record{
+			ID:   skydb.NewRecordID("category", "1"),
+			Data: skydb.Data{"name": "books"},
+		}
+		So(db.Save(&category), ShouldBeNil)
+
+		cache := NewCache(NewLRUStore(10))
+
+		Convey("caches query results", func() {
+			query := &skydb.Query{Type: "category"}
+			rows, err := cache.Query(db, query)
+			So(err, ShouldBeNil)
+			So(scanRecords(rows), ShouldResemble, []skydb.Record{category})
+
+			rows, err = cache.Query(db, &skydb.Query{Type: "category"})
+			So(err, ShouldBeNil)
+			So(scanRecords(rows), ShouldResemble, []skydb.Record{category})
+			So(db.queries, ShouldEqual, 1)
+		})
+
+		Convey("does not share results among viewers", func() {
+			cache.Query(db, &skydb.Query{
+				Type:       "category",
+				ViewAsUser: &skydb.AuthInfo{ID: "alice"},
+			})
+			cache.Query(db, &skydb.Query{
+				Type:       "category",
+				ViewAsUser: &skydb.AuthInfo{ID: "bob"},
+			})
+			cache.Query(db, &skydb.Query{
+				Type:       "category",
+				ViewAsUser: &skydb.AuthInfo{ID: "alice", Roles: []string{"admin"}},
+			})
+			So(db.queries, ShouldEqual, 3)
+		})
+
+		Convey("does not confuse literals of different types", func() {
+			predicate := func(value interface{}) skydb.Predicate {
+				return skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "name"},
+						skydb.Expression{Type: skydb.Literal, Value: value},
+					},
+				}
+			}
+			date := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+			cache.Query(db, &skydb.Query{Type: "category", Predicate: predicate(date)})
+			cache.Query(db, &skydb.Query{Type: "category", Predicate: predicate("2017-01-01T00:00:00Z")})
+			So(db.queries, ShouldEqual, 2)
+		})
+
+		Convey("does not cache queries spanning references", func() {
+			query := func() *skydb.Query {
+				return &skydb.Query{
+					Type: "category",
+					Sorts: []skydb.Sort{
+						{
+							Expression: skydb.Expression{Type: skydb.KeyPath, Value: "parent.name"},
+							Order:      skydb.Ascending,
+						},
+					},
+				}
+			}
+			cache.Query(db, query())
+			cache.Query(db, query())
+			So(db.queries, ShouldEqual, 2)
+		})
+
+		Convey("does not modify cached records", func() {
+			rows, _ := cache.Query(db, &skydb.Query{Type: "category"})
+			records := scanRecords(rows)
+			records[0].Set("name", "films")
+
+			rows, _ = cache.Query(db, &skydb.Query{Type: "category"})
+			So(scanRecords(rows)[0].Get("name"), ShouldEqual, "books")
+		})
+
+		Convey("invalidates results of record type", func() {
+			cache.Query(db, &skydb.Query{Type: "category"})
+			record := skydb.Record{}
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+
+			cache.Invalidate("note")
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+			So(db.queries, ShouldEqual, 1)
+			So(db.gets, ShouldEqual, 1)
+
+			cache.Invalidate("category")
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+			So(db.queries, ShouldEqual, 2)
+			So(db.gets, ShouldEqual, 2)
+		})
+
+		Convey("caches fetched records", func() {
+			record := skydb.Record{}
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+			So(record, ShouldResemble, category)
+
+			record = skydb.Record{}
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+			So(record, ShouldResemble, category)
+			So(db.gets, ShouldEqual, 1)
+		})
+
+		Convey("does not cache records not found", func() {
+			id := skydb.NewRecordID("category", "2")
+			So(cache.Get(db, id, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(cache.Get(db, id, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.gets, ShouldEqual, 2)
+		})
+
+		Convey("expires results when records expire", func() {
+			now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+			timeNow = func() time.Time { return now }
+			defer func() { timeNow = time.Now }()
+
+			db.expiries["category"] = skydb.RecordExpiry{Field: "expire_at"}
+			category.Set("expire_at", now.Add(time.Hour))
+			So(db.Save(&category), ShouldBeNil)
+
+			cache.Query(db, &skydb.Query{Type: "category"})
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 1)
+
+			now = now.Add(time.Hour)
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 2)
+		})
+
+		Convey("expires results after max age", func() {
+			now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+			timeNow = func() time.Time { return now }
+			defer func() { timeNow = time.Now }()
+
+			cache.MaxAge = time.Minute
+			cache.Query(db, &skydb.Query{Type: "category"})
+			now = now.Add(59 * time.Second)
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 1)
+
+			now = now.Add(time.Second)
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 2)
+		})
+
+		Convey("flushes results of all record types", func() {
+			cache.Query(db, &skydb.Query{Type: "category"})
+			record := skydb.Record{}
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+
+			cache.Flush()
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(cache.Get(db, category.ID, &record), ShouldBeNil)
+			So(db.queries, ShouldEqual, 2)
+			So(db.gets, ShouldEqual, 2)
+		})
+
+		Convey("flushes results when record events might be lost", func() {
+			conn := &eventLossConn{
+				events: make(chan chan skydb.RecordEvent, 1),
+				losses: make(chan chan struct{}, 1),
+			}
+			So(cache.Listen(conn), ShouldBeNil)
+			eventCh := <-conn.events
+			lossCh := <-conn.losses
+
+			cache.Query(db, &skydb.Query{Type: "category"})
+			eventCh <- skydb.RecordEvent{
+				Record: &skydb.Record{ID: skydb.NewRecordID("note", "1")},
+				Event:  skydb.RecordCreated,
+			}
+			lossCh <- struct{}{}
+			// The event is sent after the flush is handled.
+			eventCh <- skydb.RecordEvent{
+				Record: &skydb.Record{ID: skydb.NewRecordID("note", "1")},
+				Event:  skydb.RecordCreated,
+			}
+
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 2)
+		})
+
+		Convey("reads from primary when caching results", func() {
+			conn := &replicaConn{}
+			replicaDB := &replicaDatabase{db, conn}
+
+			_, err := cache.Query(replicaDB, &skydb.Query{Type: "category"})
+			So(err, ShouldBeNil)
+			So(conn.primaryReads, ShouldResemble, []bool{true})
+			So(conn.readYourWrites, ShouldBeFalse)
+
+			Convey("keeping reads from primary", func() {
+				conn.SetReadYourWrites(true)
+				cache.Invalidate("category")
+
+				_, err := cache.Query(replicaDB, &skydb.Query{Type: "category"})
+				So(err, ShouldBeNil)
+				So(conn.primaryReads, ShouldResemble, []bool{true, true})
+				So(conn.readYourWrites, ShouldBeTrue)
+			})
+		})
+
+		Convey("passes through without store", func() {
+			cache := &Cache{}
+			cache.Query(db, &skydb.Query{Type: "category"})
+			cache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 2)
+
+			var nilCache *Cache
+			nilCache.Query(db, &skydb.Query{Type: "category"})
+			So(db.queries, ShouldEqual, 3)
+		})
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// LRUStore is an in-process Store holding a limited number of entries.
+// The least recently used entry is evicted when the store is full.
+type LRUStore struct {
+	size       int
+	mutex      sync.Mutex
+	items      *list.List
+	elements   map[string]*list.Element
+	keysByType map[string]map[string]struct{}
+}
+
+// NewLRUStore returns a new LRUStore holding at most size entries.
+func NewLRUStore(size int) *LRUStore {
+	return &LRUStore{
+		size:       size,
+		items:      list.New(),
+		elements:   map[string]*list.Element{},
+		keysByType: map[string]map[string]struct{}{},
+	}
+}
+
+// Get implements Store.
+func (s *LRUStore) Get(key string) (*Entry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.elements[key]
+	if !ok {
+		return nil, false
+	}
+	s.items.MoveToFront(element)
+	return element.Value.(*lruItem).entry, true
+}
+
+// Set implements Store.
+func (s *LRUStore) Set(key string, entry *Entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.elements[key]; ok {
+		s.remove(element)
+	}
+
+	s.elements[key] = s.items.PushFront(&lruItem{key, entry})
+	keys, ok := s.keysByType[entry.RecordType]
+	if !ok {
+		keys = map[string]struct{}{}
+		s.keysByType[entry.RecordType] = keys
+	}
+	keys[key] = struct{}{}
+
+	for s.items.Len() > s.size {
+		s.remove(s.items.Back())
+	}
+}
+
+// Invalidate implements Store.
+func (s *LRUStore) Invalidate(recordType string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.keysByType[recordType] {
+		s.remove(s.elements[key])
+	}
+}
+
+// Flush implements Store.
+func (s *LRUStore) Flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.items.Init()
+	s.elements = map[string]*list.Element{}
+	s.keysByType = map[string]map[string]struct{}{}
+}
+
+// Len returns the number of entries in the store.
+func (s *LRUStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.items.Len()
+}
+
+func (s *LRUStore) remove(element *list.Element) {
+	item := element.Value.(*lruItem)
+	s.items.Remove(element)
+	delete(s.elements, item.key)
+
+	recordType := item.entry.RecordType
+	delete(s.keysByType[recordType], item.key)
+	if len(s.keysByType[recordType]) == 0 {
+		delete(s.keysByType, recordType)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbcache
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLRUStore(t *testing.T) {
+	Convey("LRUStore", t, func() {
+		store := NewLRUStore(2)
+
+		Convey("gets entry set", func() {
+			entry := &Entry{RecordType: "note"}
+			store.Set("a", entry)
+
+			got, ok := store.Get("a")
+			So(ok, ShouldBeTrue)
+			So(got, ShouldEqual, entry)
+
+			_, ok = store.Get("b")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("evicts least recently used entry", func() {
+			store.Set("a", &Entry{RecordType: "note"})
+			store.Set("b", &Entry{RecordType: "note"})
+			store.Get("a")
+			store.Set("c", &Entry{RecordType: "note"})
+
+			So(store.Len(), ShouldEqual, 2)
+			_, ok := store.Get("a")
+			So(ok, ShouldBeTrue)
+			_, ok = store.Get("b")
+			So(ok, ShouldBeFalse)
+			_, ok = store.Get("c")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("replaces entry with the same key", func() {
+			store.Set("a", &Entry{RecordType: "note"})
+			entry := &Entry{RecordType: "category"}
+			store.Set("a", entry)
+
+			So(store.Len(), ShouldEqual, 1)
+			got, _ := store.Get("a")
+			So(got, ShouldEqual, entry)
+
+			store.Invalidate("note")
+			So(store.Len(), ShouldEqual, 1)
+		})
+
+		Convey("invalidates entries of record type", func() {
+			store.Set("a", &Entry{RecordType: "note"})
+			store.Set("b", &Entry{RecordType: "category"})
+			store.Invalidate("note")
+
+			So(store.Len(), ShouldEqual, 1)
+			_, ok := store.Get("a")
+			So(ok, ShouldBeFalse)
+			_, ok = store.Get("b")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("flushes all entries", func() {
+			store.Set("a", &Entry{RecordType: "note"})
+			store.Set("b", &Entry{RecordType: "category"})
+			store.Flush()
+
+			So(store.Len(), ShouldEqual, 0)
+			_, ok := store.Get("a")
+			So(ok, ShouldBeFalse)
+
+			store.Set("a", &Entry{RecordType: "note"})
+			So(store.Len(), ShouldEqual, 1)
+		})
+	})
+}
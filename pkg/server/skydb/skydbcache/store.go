@@ -0,0 +1,62 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package skydbcache caches the results of skydb.Database.Query and
+// skydb.Database.Get. Cached results of a record type are invalidated
+// whenever a record of the type is created, updated or deleted.
+package skydbcache
+
+import (
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// Entry is the cached result of a query or a fetch of a record.
+type Entry struct {
+	// RecordType is the record type of the records in the result. The
+	// entry is invalidated when a record of the type is changed.
+	RecordType string
+
+	Records      []skydb.Record
+	OverallCount *uint64
+
+	// ExpiresAt is the time at which the entry becomes stale, because a
+	// record in the result expires. The zero value means the entry never
+	// becomes stale.
+	ExpiresAt time.Time
+}
+
+// Expired returns true if the entry is stale at the specified time.
+func (entry *Entry) Expired(at time.Time) bool {
+	return !entry.ExpiresAt.IsZero() && !entry.ExpiresAt.After(at)
+}
+
+// Store stores cache entries. Implementations backed by a shared cache
+// allow the entries to be shared among servers.
+type Store interface {
+	// Get returns the entry stored with the key, or false if there is
+	// no such entry.
+	Get(key string) (*Entry, bool)
+
+	// Set stores the entry with the key, replacing any entry stored
+	// with the key.
+	Set(key string, entry *Entry)
+
+	// Invalidate removes all entries of the record type.
+	Invalidate(recordType string)
+
+	// Flush removes all entries.
+	Flush()
+}
@@ -34,6 +34,9 @@ func ExpectDBSaveUser(db *mock_skydb.MockTxDatabase, extendedSchema *skydb.Recor
 		ExpectDBExtendSchema(db, *extendedSchema)
 	}
 
+	// no validation rules or default values
+	db.EXPECT().RemoteColumnTypes("user").Return(skydb.RecordSchema{}, nil).AnyTimes()
+
 	db.EXPECT().
 		Save(gomock.Any()).
 		Do(assertSavedUserRecord).
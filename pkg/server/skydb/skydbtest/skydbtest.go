@@ -290,6 +290,9 @@ func NewMapDB() *MapDB {
 	}
 }
 
+// Conn returns DBConn.
+func (db *MapDB) Conn() skydb.Conn { return db.DBConn }
+
 func (db *MapDB) IsReadOnly() bool { return false }
 
 func (db *MapDB) DatabaseType() skydb.DatabaseType { return skydb.PublicDatabase }
@@ -309,7 +312,8 @@ func (db *MapDB) Get(id skydb.RecordID, record *skydb.Record) error {
 	if !ok {
 		return skydb.ErrRecordNotFound
 	}
-	*record = r
+	// copy the record so that modifying it does not change the stored one
+	*record = r.Copy()
 	return nil
 
 }
@@ -318,7 +322,19 @@ func (db *MapDB) Get(id skydb.RecordID, record *skydb.Record) error {
 func (db *MapDB) Save(record *skydb.Record) error {
 	recordID := record.ID.String()
 
-	if origRecord, ok := db.RecordMap[recordID]; ok {
+	// apply field operations on the stored values
+	origRecord, exists := db.RecordMap[recordID]
+	for key, value := range record.Data {
+		if op, ok := value.(skydb.FieldOperation); ok {
+			result, err := op.Apply(origRecord.Data[key])
+			if err != nil {
+				return err
+			}
+			record.Data[key] = result
+		}
+	}
+
+	if exists {
 		// keep the meta-data of record, only update record.Data
 		origRecordMergedCopy := origRecord.MergedCopy(record)
 		record.Apply(&origRecordMergedCopy)
@@ -349,6 +365,16 @@ func (db *MapDB) Extend(recordType string, schema skydb.RecordSchema) (bool, err
 		for fieldName, fieldType := range schema {
 			if _, ok := db.RecordSchemaMap[recordType][fieldName]; ok {
 				ft := db.RecordSchemaMap[recordType][fieldName]
+				if fieldType.Validation == nil {
+					// keep the validation rules of the field
+					fieldType.Validation = ft.Validation
+				}
+				ft.Validation = fieldType.Validation
+				if fieldType.Default == nil {
+					// keep the default value of the field
+					fieldType.Default = ft.Default
+				}
+				ft.Default = fieldType.Default
 				if !reflect.DeepEqual(ft, fieldType) {
 					return false, fmt.Errorf("Wrong type")
 				}
@@ -401,6 +427,12 @@ func (db *MapDB) GetSchema(recordType string) (skydb.RecordSchema, error) {
 	return db.RecordSchemaMap[recordType], nil
 }
 
+// RemoteColumnTypes returns the schema of the record type, or nil if
+// the record type does not exist.
+func (db *MapDB) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return db.RecordSchemaMap[recordType], nil
+}
+
 // GetRecordSchemas returns a list of all existing record type
 func (db *MapDB) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
 	return db.RecordSchemaMap, nil
@@ -0,0 +1,222 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SetRecordAccess(recordType string, acl skydb.RecordACL) error {
+	creationRoles := []string{}
+	for _, ace := range acl {
+		if ace.Role != "" {
+			creationRoles = append(creationRoles, ace.Role)
+		}
+	}
+
+	return c.withTx(func() error {
+		if err := c.ensureRole(creationRoles); err != nil {
+			return err
+		}
+
+		builder := sq.Delete(c.tableName("_record_creation")).
+			Where("record_type = ?", recordType)
+		if _, err := c.ExecWith(builder); err != nil {
+			return err
+		}
+
+		if len(creationRoles) == 0 {
+			return nil
+		}
+
+		insert := sq.Insert(c.tableName("_record_creation")).
+			Options("OR IGNORE").
+			Columns("record_type", "role_id")
+		for _, role := range creationRoles {
+			insert = insert.Values(recordType, role)
+		}
+		_, err := c.ExecWith(insert)
+		return err
+	})
+}
+
+func (c *conn) GetRecordAccess(recordType string) (skydb.RecordACL, error) {
+	builder := sq.Select("role_id").
+		From(c.tableName("_record_creation")).
+		Where("record_type = ?", recordType).
+		OrderBy("role_id")
+
+	roles, err := c.queryStrings(builder)
+	if err != nil {
+		return nil, err
+	}
+
+	currentCreationRoles := []skydb.RecordACLEntry{}
+	for _, role := range roles {
+		currentCreationRoles = append(currentCreationRoles,
+			skydb.NewRecordACLEntryRole(role, skydb.CreateLevel))
+	}
+
+	return skydb.NewRecordACL(currentCreationRoles), nil
+}
+
+func (c *conn) SetRecordDefaultAccess(recordType string, acl skydb.RecordACL) error {
+	value, err := columnValue(acl)
+	if err != nil {
+		return err
+	}
+
+	builder := sq.Insert(c.tableName("_record_default_access")).
+		Options("OR REPLACE").
+		Columns("record_type", "default_access").
+		Values(recordType, value)
+	_, err = c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error) {
+	builder := sq.Select("default_access").
+		From(c.tableName("_record_default_access")).
+		Where("record_type = ?", recordType)
+
+	nullableACLString := sql.NullString{}
+	err := c.QueryRowWith(builder).Scan(&nullableACLString)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !nullableACLString.Valid {
+		return nil, nil
+	}
+
+	acl := skydb.RecordACL{}
+	if err := json.Unmarshal([]byte(nullableACLString.String), &acl); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) error {
+	err := c.withTx(func() error {
+		if _, err := c.ExecWith(sq.Delete(c.tableName("_record_field_access"))); err != nil {
+			return err
+		}
+
+		allEntries := acl.AllEntries()
+		if len(allEntries) == 0 {
+			// Do not insert if new setting is empty.
+			return nil
+		}
+
+		builder := sq.Insert(c.tableName("_record_field_access")).
+			Columns(
+				"record_type",
+				"record_field",
+				"user_role",
+				"writable",
+				"readable",
+				"comparable",
+				"discoverable",
+			)
+
+		for _, entry := range allEntries {
+			builder = builder.Values(
+				entry.RecordType,
+				entry.RecordField,
+				entry.UserRole.String(),
+				entry.Writable,
+				entry.Readable,
+				entry.Comparable,
+				entry.Discoverable,
+			)
+		}
+
+		_, err := c.ExecWith(builder)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to set record field access: %s", err)
+	}
+
+	c.cacheMutex.Lock()
+	c.FieldACL = nil // invalidate cached FieldACL
+	c.cacheMutex.Unlock()
+	return nil
+}
+
+func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
+	c.cacheMutex.RLock()
+	cached := c.FieldACL
+	c.cacheMutex.RUnlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	builder := sq.Select(
+		"record_type",
+		"record_field",
+		"user_role",
+		"writable",
+		"readable",
+		"comparable",
+		"discoverable",
+	).From(c.tableName("_record_field_access"))
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return skydb.FieldACL{}, err
+	}
+	defer rows.Close()
+
+	entries := []skydb.FieldACLEntry{}
+	for rows.Next() {
+		var entry skydb.FieldACLEntry
+		var userRole string
+		err := rows.Scan(
+			&entry.RecordType,
+			&entry.RecordField,
+			&userRole,
+			&entry.Writable,
+			&entry.Readable,
+			&entry.Comparable,
+			&entry.Discoverable,
+		)
+		if err != nil {
+			return skydb.FieldACL{}, err
+		}
+
+		entry.UserRole = skydb.NewFieldUserRole(userRole)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return skydb.FieldACL{}, err
+	}
+
+	acl := skydb.NewFieldACL(skydb.FieldACLEntryList(entries))
+
+	c.cacheMutex.Lock()
+	c.FieldACL = &acl
+	c.cacheMutex.Unlock()
+	return acl, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"errors"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) GetAsset(name string, asset *skydb.Asset) error {
+	assets, err := c.GetAssets([]string{name})
+	if err != nil {
+		return err
+	}
+
+	if len(assets) == 0 {
+		return errors.New("asset not found")
+	}
+
+	*asset = assets[0]
+	return nil
+}
+
+func (c *conn) GetAssets(names []string) ([]skydb.Asset, error) {
+	if len(names) == 0 {
+		return []skydb.Asset{}, nil
+	}
+
+	builder := sq.Select("id", "content_type", "size").
+		From(c.tableName("_asset")).
+		Where("id IN ("+sq.Placeholders(len(names))+")", stringArgs(names)...)
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Asset{}
+	for rows.Next() {
+		a := skydb.Asset{}
+		if err := rows.Scan(&a.Name, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+
+	return results, rows.Err()
+}
+
+func (c *conn) SaveAsset(asset *skydb.Asset) error {
+	builder := sq.Insert(c.tableName("_asset")).
+		Options("OR REPLACE").
+		Columns("id", "content_type", "size").
+		Values(asset.Name, asset.ContentType, asset.Size)
+	_, err := c.ExecWith(builder)
+	return err
+}
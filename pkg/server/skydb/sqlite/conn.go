@@ -0,0 +1,355 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	sq "github.com/lann/squirrel"
+	"github.com/sirupsen/logrus"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// ExtContext is an interface for both sqlx.DB and sqlx.Tx
+type ExtContext interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type conn struct {
+	db             *sqlx.DB // database wrapper
+	tx             *sqlx.Tx // transaction wrapper, nil when no transaction
+	appName        string
+	option         string
+	statementCount uint64
+	accessModel    skydb.AccessModel
+	canMigrate     bool
+	context        context.Context
+
+	// cacheMutex guards the caches below, so that a connection not in a
+	// transaction can be used concurrently.
+	cacheMutex   sync.RWMutex
+	RecordSchema map[string]skydb.RecordSchema
+	FieldACL     *skydb.FieldACL
+
+	// pendingEvents are the record events of the current transaction,
+	// which are emitted when the transaction is committed.
+	pendingEvents []skydb.RecordEvent
+}
+
+// Db returns the current database wrapper, or a transaction wrapper when
+// a transaction is in effect.
+func (c *conn) Db() ExtContext {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.db
+}
+
+// Begin begins a transaction.
+func (c *conn) Begin() error {
+	log.Debugf("%p: Beginning transaction", c)
+	if c.tx != nil {
+		return skydb.ErrDatabaseTxDidBegin
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		log.Debugf("%p: Unable to begin transaction: %v", c, err)
+		return err
+	}
+	c.tx = tx
+	log.Debugf("%p: Done beginning transaction %p", c, c.tx)
+	return nil
+}
+
+// Commit commits a transaction.
+func (c *conn) Commit() error {
+	if c.tx == nil {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		log.Errorf("%p: Unable to commit transaction %p: %v", c, c.tx, err)
+		return err
+	}
+	c.tx = nil
+	log.Debugf("%p: Committed transaction", c)
+
+	events := c.pendingEvents
+	c.pendingEvents = nil
+	for _, event := range events {
+		emit(c.appName, event)
+	}
+	return nil
+}
+
+// Rollback rollbacks a transaction.
+func (c *conn) Rollback() error {
+	if c.tx == nil {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	if err := c.tx.Rollback(); err != nil {
+		log.Errorf("%p: Unable to rollback transaction %p: %v", c, c.tx, err)
+		return err
+	}
+	c.tx = nil
+	c.pendingEvents = nil
+	log.Debugf("%p: Rolled back transaction", c)
+	return nil
+}
+
+// withTx calls do in a transaction. If a transaction is already in
+// effect, do is called in that transaction.
+func (c *conn) withTx(do func() error) error {
+	if c.tx != nil {
+		return do()
+	}
+	return skydb.WithTransaction(c, do)
+}
+
+func (c *conn) PublicDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PublicDatabase,
+	}
+}
+
+func (c *conn) PrivateDB(userKey string) skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PrivateDatabase,
+		userID:       userKey,
+	}
+}
+
+func (c *conn) UnionDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.UnionDatabase,
+	}
+}
+
+func (c *conn) Close() error { return nil }
+
+// cachedRecordSchema returns the cached schema of the record type. The
+// schema is nil if the record type is cached as not existing.
+func (c *conn) cachedRecordSchema(recordType string) (skydb.RecordSchema, bool) {
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+	schema, ok := c.RecordSchema[recordType]
+	return schema, ok
+}
+
+func (c *conn) cacheRecordSchema(recordType string, schema skydb.RecordSchema) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	c.RecordSchema[recordType] = schema
+}
+
+func (c *conn) invalidateRecordSchema(recordType string) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	delete(c.RecordSchema, recordType)
+}
+
+// tableName returns the quoted table name ready to be used as identifier.
+func (c *conn) tableName(table string) string {
+	return quoteIdentifier(table)
+}
+
+func (c *conn) Get(dest interface{}, query string, args ...interface{}) (err error) {
+	atomic.AddUint64(&c.statementCount, 1)
+	err = c.Db().GetContext(c.context, dest, query, args...)
+	c.logStatement("sql.Get", query, args, err)
+	return
+}
+
+func (c *conn) GetWith(dest interface{}, sqlizeri sq.Sqlizer) error {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		return err
+	}
+	return c.Get(dest, sql, args...)
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	atomic.AddUint64(&c.statementCount, 1)
+	result, err = c.Db().ExecContext(c.context, query, args...)
+	c.logStatement("sql.Exec", query, args, err)
+	return
+}
+
+func (c *conn) ExecWith(sqlizeri sq.Sqlizer) (sql.Result, error) {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return c.Exec(sql, args...)
+}
+
+func (c *conn) Queryx(query string, args ...interface{}) (rows *sqlx.Rows, err error) {
+	atomic.AddUint64(&c.statementCount, 1)
+	rows, err = c.Db().QueryxContext(c.context, query, args...)
+	c.logStatement("sql.Queryx", query, args, err)
+	return
+}
+
+func (c *conn) QueryWith(sqlizeri sq.Sqlizer) (*sqlx.Rows, error) {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return c.Queryx(sql, args...)
+}
+
+func (c *conn) QueryRowx(query string, args ...interface{}) (row *sqlx.Row) {
+	atomic.AddUint64(&c.statementCount, 1)
+	row = c.Db().QueryRowxContext(c.context, query, args...)
+	c.logStatement("sql.QueryRowx", query, args, row.Err())
+	return
+}
+
+func (c *conn) QueryRowWith(sqlizeri sq.Sqlizer) *sqlx.Row {
+	sql, args, err := sqlizeri.ToSql()
+	if err != nil {
+		panic(err)
+	}
+	return c.QueryRowx(sql, args...)
+}
+
+func (c *conn) logStatement(method string, query string, args []interface{}, err error) {
+	logFields := logrus.Fields{
+		"sql":            query,
+		"args":           args,
+		"error":          err,
+		"executionCount": atomic.LoadUint64(&c.statementCount),
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.WithFields(logFields).Errorf("Failed to execute SQL with %s", method)
+	} else {
+		log.WithFields(logFields).Debugf("Executed SQL successfully with %s", method)
+	}
+}
+
+// quoteIdentifier quotes an identifier such as a table or column name.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quoteLiteral quotes a string literal.
+func quoteLiteral(literal string) string {
+	return `'` + strings.Replace(literal, `'`, `''`, -1) + `'`
+}
+
+// fullQuoteIdentifier quotes a column name qualified by a table alias.
+func fullQuoteIdentifier(alias string, column string) string {
+	if alias == "" {
+		return quoteIdentifier(column)
+	}
+	return quoteIdentifier(alias) + "." + quoteIdentifier(column)
+}
+
+var appEventChannelsMap = map[string][]chan skydb.RecordEvent{}
+var appEventChannelsMutex sync.RWMutex
+
+// Subscribe registers the channel to receive the record events of the
+// app. Events are emitted by the server process which changes the
+// records, changes made by other processes are not notified.
+func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
+	appEventChannelsMutex.Lock()
+	defer appEventChannelsMutex.Unlock()
+
+	appEventChannelsMap[c.appName] = append(appEventChannelsMap[c.appName], recordEventChan)
+	return nil
+}
+
+// notify emits the record event when the current transaction is
+// committed, or immediately if there is no transaction.
+func (c *conn) notify(event skydb.RecordEvent) {
+	if c.tx != nil {
+		c.pendingEvents = append(c.pendingEvents, event)
+		return
+	}
+	emit(c.appName, event)
+}
+
+func emit(appName string, event skydb.RecordEvent) {
+	appEventChannelsMutex.RLock()
+	defer appEventChannelsMutex.RUnlock()
+
+	for _, channel := range appEventChannelsMap[appName] {
+		go func(ch chan skydb.RecordEvent) {
+			ch <- event
+		}(channel)
+	}
+}
+
+type database struct {
+	c            *conn
+	userID       string
+	databaseType skydb.DatabaseType
+}
+
+func (db *database) Conn() skydb.Conn       { return db.c }
+func (db *database) UserRecordType() string { return "user" }
+
+func (db *database) ID() string {
+	if db.DatabaseType() == skydb.PublicDatabase {
+		return skydb.PublicDatabaseIdentifier
+	} else if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.UnionDatabaseIdentifier
+	}
+
+	if db.userID == "" {
+		panic("Private database but userID is empty")
+	}
+	return db.userID
+}
+
+func (db *database) DatabaseType() skydb.DatabaseType { return db.databaseType }
+func (db *database) IsReadOnly() bool                 { return db.DatabaseType() == skydb.UnionDatabase }
+
+// TableName returns the quoted name of the table of the record type.
+func (db *database) TableName(table string) string {
+	return db.c.tableName(table)
+}
+
+func (db *database) Begin() error {
+	return db.c.Begin()
+}
+
+func (db *database) Commit() error {
+	return db.c.Commit()
+}
+
+func (db *database) Rollback() error {
+	return db.c.Rollback()
+}
+
+// this ensures that our structure conform to certain interfaces.
+var (
+	_ skydb.Conn       = &conn{}
+	_ skydb.Database   = &database{}
+	_ skydb.TxDatabase = &database{}
+)
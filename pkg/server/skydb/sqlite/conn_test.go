@@ -0,0 +1,276 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthAndRoles(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		tokenValidSince := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		authinfo := skydb.AuthInfo{
+			ID:             "user0",
+			HashedPassword: []byte("hashed"),
+			Roles:          []string{"editor"},
+			ProviderInfo: skydb.ProviderInfo{
+				"com.example:user0": map[string]interface{}{"name": "User 0"},
+			},
+			TokenValidSince: &tokenValidSince,
+		}
+		So(c.CreateAuth(&authinfo), ShouldBeNil)
+
+		Convey("gets auth by id and principal id", func() {
+			fetched := skydb.AuthInfo{}
+			So(c.GetAuth("user0", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, authinfo)
+
+			fetched = skydb.AuthInfo{}
+			So(c.GetAuthByPrincipalID("com.example:user0", &fetched), ShouldBeNil)
+			So(fetched.ID, ShouldEqual, "user0")
+
+			So(c.GetAuth("user1", &fetched), ShouldEqual, skydb.ErrUserNotFound)
+		})
+
+		Convey("rejects duplicated auth", func() {
+			So(c.CreateAuth(&skydb.AuthInfo{ID: "user0"}), ShouldEqual, skydb.ErrUserDuplicated)
+		})
+
+		Convey("updates and deletes auth", func() {
+			authinfo.Roles = []string{"admin", "editor"}
+			authinfo.TokenValidSince = nil
+			So(c.UpdateAuth(&authinfo), ShouldBeNil)
+
+			fetched := skydb.AuthInfo{}
+			So(c.GetAuth("user0", &fetched), ShouldBeNil)
+			So(fetched.Roles, ShouldResemble, []string{"admin", "editor"})
+			So(fetched.TokenValidSince, ShouldBeNil)
+
+			So(c.DeleteAuth("user0"), ShouldBeNil)
+			So(c.DeleteAuth("user0"), ShouldEqual, skydb.ErrUserNotFound)
+		})
+
+		Convey("assigns and revokes roles", func() {
+			addUser(t, c, "user1")
+			So(c.AssignRoles([]string{"user0", "user1"}, []string{"writer"}), ShouldBeNil)
+			So(c.RevokeRoles([]string{"user0"}, []string{"editor"}), ShouldBeNil)
+
+			roles, err := c.GetRoles([]string{"user0", "user1", "user2"})
+			So(err, ShouldBeNil)
+			So(roles, ShouldResemble, map[string][]string{
+				"user0": {"writer"},
+				"user1": {"writer"},
+				"user2": {},
+			})
+		})
+
+		Convey("sets admin and default roles", func() {
+			adminRoles, err := c.GetAdminRoles()
+			So(err, ShouldBeNil)
+			So(adminRoles, ShouldResemble, []string{"Admin"})
+
+			So(c.SetAdminRoles([]string{"god"}), ShouldBeNil)
+			So(c.SetDefaultRoles([]string{"human"}), ShouldBeNil)
+
+			adminRoles, err = c.GetAdminRoles()
+			So(err, ShouldBeNil)
+			So(adminRoles, ShouldResemble, []string{"god"})
+			defaultRoles, err := c.GetDefaultRoles()
+			So(err, ShouldBeNil)
+			So(defaultRoles, ShouldResemble, []string{"human"})
+		})
+
+		Convey("sets record access", func() {
+			So(c.SetRecordAccess("note", skydb.RecordACL{
+				skydb.NewRecordACLEntryRole("editor", skydb.CreateLevel),
+			}), ShouldBeNil)
+			acl, err := c.GetRecordAccess("note")
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, skydb.RecordACL{
+				skydb.NewRecordACLEntryRole("editor", skydb.CreateLevel),
+			})
+
+			acl, err = c.GetRecordDefaultAccess("note")
+			So(err, ShouldBeNil)
+			So(acl, ShouldBeNil)
+			So(c.SetRecordDefaultAccess("note", skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			}), ShouldBeNil)
+			acl, err = c.GetRecordDefaultAccess("note")
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			})
+		})
+
+		Convey("adds and queries relations", func() {
+			addUser(t, c, "user1")
+			So(c.AddRelation("user0", "_follow", "user1"), ShouldBeNil)
+			So(c.AddRelation("user0", "_follow", "user2"), ShouldNotBeNil)
+
+			users := c.QueryRelation("user0", "_follow", "outward", skydb.QueryConfig{})
+			So(len(users), ShouldEqual, 1)
+			So(users[0].ID, ShouldEqual, "user1")
+
+			count, err := c.QueryRelationCount("user1", "_follow", "inward")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			So(c.RemoveRelation("user0", "_follow", "user1"), ShouldBeNil)
+		})
+	})
+}
+
+func TestDeviceAndSubscription(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+		addUser(t, c, "user0")
+
+		registeredAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		device := skydb.Device{
+			ID:               "device0",
+			Type:             "ios",
+			Token:            "token0",
+			AuthInfoID:       "user0",
+			Topic:            "io.skygear.test",
+			LastRegisteredAt: registeredAt,
+		}
+		So(c.SaveDevice(&device), ShouldBeNil)
+
+		Convey("gets and queries devices", func() {
+			fetched := skydb.Device{}
+			So(c.GetDevice("device0", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, device)
+
+			devices, err := c.QueryDevicesByUserAndTopic("user0", "io.skygear.test")
+			So(err, ShouldBeNil)
+			So(devices, ShouldResemble, []skydb.Device{device})
+
+			So(c.DeleteDevice("device0"), ShouldBeNil)
+			So(c.GetDevice("device0", &fetched), ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+
+		Convey("keeps token when updating device without token", func() {
+			device.Token = ""
+			So(c.SaveDevice(&device), ShouldBeNil)
+
+			fetched := skydb.Device{}
+			So(c.GetDevice("device0", &fetched), ShouldBeNil)
+			So(fetched.Token, ShouldEqual, "token0")
+		})
+
+		Convey("matches subscriptions of record", func() {
+			db := c.PrivateDB("user0")
+			subscription := skydb.Subscription{
+				ID:       "subscription0",
+				Type:     "query",
+				DeviceID: "device0",
+				Query: skydb.Query{
+					Type: "note",
+					Predicate: skydb.Predicate{
+						Operator: skydb.Equal,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+							skydb.Expression{Type: skydb.Literal, Value: "Hello"},
+						},
+					},
+				},
+			}
+			So(db.SaveSubscription(&subscription), ShouldBeNil)
+
+			fetched := skydb.Subscription{}
+			So(db.GetSubscription("subscription0", "device0", &fetched), ShouldBeNil)
+			So(fetched.Query.Type, ShouldEqual, "note")
+			So(db.GetSubscriptionsByDeviceID("device0"), ShouldHaveLength, 1)
+
+			subscriptions := db.GetMatchingSubscriptions(&skydb.Record{
+				ID:   skydb.NewRecordID("note", "1"),
+				Data: skydb.Data{"title": "Hello"},
+			})
+			So(subscriptions, ShouldHaveLength, 1)
+			So(db.GetMatchingSubscriptions(&skydb.Record{
+				ID:   skydb.NewRecordID("note", "1"),
+				Data: skydb.Data{"title": "World"},
+			}), ShouldBeEmpty)
+
+			So(c.DeleteDevice("device0"), ShouldBeNil)
+			So(db.GetSubscriptionsByDeviceID("device0"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestConcurrentQueries(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "user0",
+			Data:    skydb.Data{"title": "Hello"},
+		}), ShouldBeNil)
+
+		Convey("runs queries concurrently with empty caches", func() {
+			c.RecordSchema = map[string]skydb.RecordSchema{}
+			c.FieldACL = nil
+
+			var wg sync.WaitGroup
+			errs := make([]error, 10)
+			counts := make([]int, 10)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if _, err := c.GetRecordFieldAccess(); err != nil {
+						errs[i] = err
+						return
+					}
+					rows, err := db.Query(&skydb.Query{Type: "note"})
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					defer rows.Close()
+					for rows.Scan() {
+						counts[i]++
+					}
+					errs[i] = rows.Err()
+				}(i)
+			}
+			wg.Wait()
+
+			for i := range errs {
+				So(errs[i], ShouldBeNil)
+				So(counts[i], ShouldEqual, 1)
+			}
+		})
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) deviceBuilder() sq.SelectBuilder {
+	return sq.Select("id", "type", "token", "auth_id", "topic", "last_registered_at").
+		From(c.tableName("_device"))
+}
+
+func scanDevice(scanner sq.RowScanner, device *skydb.Device) error {
+	var (
+		nullableToken    sql.NullString
+		nullableUserID   sql.NullString
+		nullableTopic    sql.NullString
+		lastRegisteredAt nullTime
+	)
+
+	err := scanner.Scan(
+		&device.ID,
+		&device.Type,
+		&nullableToken,
+		&nullableUserID,
+		&nullableTopic,
+		&lastRegisteredAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	device.Token = nullableToken.String
+	device.AuthInfoID = nullableUserID.String
+	device.Topic = nullableTopic.String
+	device.LastRegisteredAt = lastRegisteredAt.Time
+	return nil
+}
+
+func (c *conn) queryDevices(builder sq.SelectBuilder) ([]skydb.Device, error) {
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Device{}
+	for rows.Next() {
+		d := skydb.Device{}
+		if err := scanDevice(rows, &d); err != nil {
+			return nil, err
+		}
+		results = append(results, d)
+	}
+
+	return results, rows.Err()
+}
+
+func (c *conn) GetDevice(id string, device *skydb.Device) error {
+	builder := c.deviceBuilder().Where("id = ?", id)
+	err := scanDevice(c.QueryRowWith(builder), device)
+	if err == sql.ErrNoRows {
+		return skydb.ErrDeviceNotFound
+	}
+	return err
+}
+
+func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	return c.queryDevices(c.deviceBuilder().Where("auth_id = ?", user))
+}
+
+func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
+	return c.queryDevices(c.deviceBuilder().Where("auth_id = ? AND topic = ?", user, topic))
+}
+
+func (c *conn) SaveDevice(device *skydb.Device) error {
+	if device.ID == "" || device.Type == "" || device.LastRegisteredAt.IsZero() {
+		return errors.New("invalid device: empty id, type, or last registered at")
+	}
+
+	var authID interface{}
+	if device.AuthInfoID != "" {
+		authID = device.AuthInfoID
+	}
+
+	builder := sq.Insert(c.tableName("_device")).
+		Columns("id", "type", "auth_id", "last_registered_at").
+		Values(device.ID, device.Type, authID, formatTime(device.LastRegisteredAt)).
+		Suffix(`ON CONFLICT (id) DO UPDATE SET
+	type = excluded.type,
+	auth_id = excluded.auth_id,
+	last_registered_at = excluded.last_registered_at`)
+
+	return c.withTx(func() error {
+		if _, err := c.ExecWith(builder); err != nil {
+			return err
+		}
+
+		// Token and topic are not updated if they are not specified.
+		update := sq.Update(c.tableName("_device")).Where("id = ?", device.ID)
+		updated := false
+		if device.Token != "" {
+			update = update.Set("token", device.Token)
+			updated = true
+		}
+		if device.Topic != "" {
+			update = update.Set("topic", device.Topic)
+			updated = true
+		}
+		if !updated {
+			return nil
+		}
+		_, err := c.ExecWith(update)
+		return err
+	})
+}
+
+func (c *conn) DeleteDevice(id string) error {
+	builder := sq.Delete(c.tableName("_device")).
+		Where("id = ?", id)
+	return c.deleteDevices(builder)
+}
+
+func (c *conn) DeleteDevicesByToken(token string, t time.Time) error {
+	builder := sq.Delete(c.tableName("_device")).
+		Where("token = ?", token)
+	if t != skydb.ZeroTime {
+		builder = builder.Where("last_registered_at < ?", formatTime(t))
+	}
+	return c.deleteDevices(builder)
+}
+
+func (c *conn) DeleteEmptyDevicesByTime(t time.Time) error {
+	builder := sq.Delete(c.tableName("_device")).
+		Where("token IS NULL")
+	if t != skydb.ZeroTime {
+		builder = builder.Where("last_registered_at < ?", formatTime(t))
+	}
+	return c.deleteDevices(builder)
+}
+
+func (c *conn) deleteDevices(builder sq.DeleteBuilder) error {
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrDeviceNotFound
+	}
+
+	return nil
+}
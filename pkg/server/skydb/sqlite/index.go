@@ -0,0 +1,354 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/mattn/go-sqlite3"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// GetIndexesByRecordType returns all indexes of the record type, including
+// the index of the primary key.
+//
+// SQLite builds an index within the CREATE INDEX statement, so an index
+// that exists is always ready.
+func (db *database) GetIndexesByRecordType(recordType string) (map[string]skydb.Index, error) {
+	type indexInfo struct {
+		name       string
+		unique     bool
+		definition string
+	}
+
+	rows, err := db.c.Queryx(`
+SELECT il.name, il."unique", COALESCE(m.sql, '')
+FROM pragma_index_list(?) AS il
+    LEFT JOIN sqlite_master m ON m.type = 'index' AND m.name = il.name`,
+		recordType)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []indexInfo{}
+	for rows.Next() {
+		info := indexInfo{}
+		if err := rows.Scan(&info.name, &info.unique, &info.definition); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	indexes := map[string]skydb.Index{}
+	for _, info := range infos {
+		index := skydb.Index{
+			Fields:      []string{},
+			Expressions: []skydb.IndexExpression{},
+			Unique:      info.unique,
+			Status:      skydb.IndexReady,
+			Definition:  info.definition,
+		}
+
+		if info.definition != "" {
+			for _, key := range indexKeyDefinitions(info.definition) {
+				if expr, ok := parseIndexExpression(key); ok {
+					index.Expressions = append(index.Expressions, expr)
+				} else {
+					index.Fields = append(index.Fields, unquoteIdentifier(key))
+				}
+			}
+		} else {
+			// Indexes created by constraints of the table, such as the
+			// primary key, have no statement.
+			columns, err := db.c.queryStrings(sq.Select("name").
+				From(fmt.Sprintf("pragma_index_info(%s)", quoteLiteral(info.name))).
+				OrderBy("seqno"))
+			if err != nil {
+				return nil, err
+			}
+			index.Fields = columns
+		}
+		indexes[info.name] = index
+	}
+
+	return indexes, nil
+}
+
+// indexKeyDefinitions returns the definition of each key in the CREATE
+// INDEX statement created by SaveIndex.
+func indexKeyDefinitions(definition string) []string {
+	start := strings.Index(definition, "(")
+	if start < 0 {
+		return nil
+	}
+
+	keys := []string{}
+	depth := 0
+	quoted := false
+	begin := start + 1
+	for i := begin; i < len(definition); i++ {
+		switch ch := definition[i]; {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '(':
+			depth++
+		case ch == ',' && depth == 0:
+			keys = append(keys, strings.TrimSpace(definition[begin:i]))
+			begin = i + 1
+		case ch == ')':
+			if depth == 0 {
+				return append(keys, strings.TrimSpace(definition[begin:i]))
+			}
+			depth--
+		}
+	}
+	return keys
+}
+
+func unquoteIdentifier(identifier string) string {
+	if strings.HasPrefix(identifier, `"`) && strings.HasSuffix(identifier, `"`) {
+		return strings.Replace(identifier[1:len(identifier)-1], `""`, `"`, -1)
+	}
+	return identifier
+}
+
+var indexExpressionRegexp = regexp.MustCompile(`^(\w+)\(("(?:[^"]|"")+"|\w+)\)$`)
+
+// parseIndexExpression parses the definition of an expression index key
+// created by SaveIndex.
+func parseIndexExpression(definition string) (skydb.IndexExpression, bool) {
+	matches := indexExpressionRegexp.FindStringSubmatch(definition)
+	if matches == nil {
+		return skydb.IndexExpression{}, false
+	}
+
+	function := skydb.IndexFunction(matches[1])
+	if !function.IsValid() {
+		return skydb.IndexExpression{}, false
+	}
+
+	return skydb.IndexExpression{
+		Function: function,
+		Field:    unquoteIdentifier(matches[2]),
+	}, true
+}
+
+// SaveIndex creates the index on the record type.
+func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	keys, err := indexKeysSQL(index)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("CREATE ")
+	if index.Unique {
+		buf.WriteString("UNIQUE ")
+	}
+	fmt.Fprintf(&buf, "INDEX %s ON %s (%s)",
+		quoteIdentifier(indexName), db.TableName(recordType), keys)
+	if !index.Predicate.IsEmpty() {
+		where, err := indexPredicateSQL(index.Predicate)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, " WHERE %s", where)
+	}
+	stmt := buf.String()
+	log.WithField("stmt", stmt).Debugln("Creating index")
+
+	if _, err := db.c.Exec(stmt); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrError {
+			msg := sqliteErr.Error()
+			if strings.Contains(msg, "already exists") {
+				return skyerr.NewErrorf(skyerr.Duplicated, `index "%s" already exists`, indexName)
+			}
+			if strings.HasPrefix(msg, "no such") {
+				return skyerr.NewErrorf(skyerr.ResourceNotFound, "failed to create index: %s", msg)
+			}
+		}
+		return fmt.Errorf("failed to create index: %s", err)
+	}
+
+	return nil
+}
+
+func indexKeysSQL(index skydb.Index) (string, error) {
+	if len(index.Fields) == 0 && len(index.Expressions) == 0 {
+		return "", skyerr.NewInvalidArgument("index has no fields or expressions", []string{"fields"})
+	}
+
+	keys := []string{}
+	for _, field := range index.Fields {
+		keys = append(keys, quoteIdentifier(field))
+	}
+	for _, expr := range index.Expressions {
+		if !expr.Function.IsValid() {
+			return "", skyerr.NewInvalidArgument(
+				fmt.Sprintf(`unknown index function "%s"`, expr.Function),
+				[]string{"expressions"},
+			)
+		}
+		keys = append(keys, fmt.Sprintf("%s(%s)", expr.Function, quoteIdentifier(expr.Field)))
+	}
+	return strings.Join(keys, ", "), nil
+}
+
+// indexPredicateSQL returns the condition of a partial index. Values are
+// written into the condition because CREATE INDEX does not accept
+// parameters, so only comparisons of fields with values of simple types
+// are supported.
+func indexPredicateSQL(p skydb.Predicate) (string, error) {
+	switch p.Operator {
+	case skydb.And, skydb.Or:
+		conditions := []string{}
+		for _, child := range p.Children {
+			condition, err := indexPredicateSQL(child.(skydb.Predicate))
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, "("+condition+")")
+		}
+		if p.Operator == skydb.And {
+			return strings.Join(conditions, " AND "), nil
+		}
+		return strings.Join(conditions, " OR "), nil
+	case skydb.Not:
+		condition, err := indexPredicateSQL(p.Children[0].(skydb.Predicate))
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + condition + ")", nil
+	}
+
+	var op string
+	switch p.Operator {
+	case skydb.Equal:
+		op = "="
+	case skydb.NotEqual:
+		op = "<>"
+	case skydb.GreaterThan:
+		op = ">"
+	case skydb.GreaterThanOrEqual:
+		op = ">="
+	case skydb.LessThan:
+		op = "<"
+	case skydb.LessThanOrEqual:
+		op = "<="
+	default:
+		return "", errUnsupportedIndexPredicate
+	}
+
+	lhs := p.Children[0].(skydb.Expression)
+	rhs := p.Children[1].(skydb.Expression)
+	if lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath {
+		lhs, rhs = rhs, lhs
+		switch op {
+		case ">":
+			op = "<"
+		case ">=":
+			op = "<="
+		case "<":
+			op = ">"
+		case "<=":
+			op = ">="
+		}
+	}
+	if lhs.Type != skydb.KeyPath || rhs.Type != skydb.Literal || len(lhs.KeyPathComponents()) != 1 {
+		return "", errUnsupportedIndexPredicate
+	}
+	column := quoteIdentifier(lhs.Value.(string))
+
+	var value string
+	switch v := rhs.Value.(type) {
+	case nil:
+		switch op {
+		case "=":
+			return column + " IS NULL", nil
+		case "<>":
+			return column + " IS NOT NULL", nil
+		default:
+			return "", errUnsupportedIndexPredicate
+		}
+	case string:
+		value = quoteLiteral(v)
+	case bool:
+		if v {
+			value = "1"
+		} else {
+			value = "0"
+		}
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		value = quoteLiteral(formatTime(v))
+	default:
+		return "", errUnsupportedIndexPredicate
+	}
+	return fmt.Sprintf("%s %s %s", column, op, value), nil
+}
+
+var errUnsupportedIndexPredicate = skyerr.NewInvalidArgument(
+	"partial index only supports comparing fields with strings, numbers, booleans, dates or null",
+	[]string{"predicate"},
+)
+
+// DeleteIndex drops the index of the record type. Indexes created by
+// constraints of the table cannot be deleted.
+func (db *database) DeleteIndex(recordType string, indexName string) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	var count int
+	err := db.c.GetWith(&count, sq.Select("COUNT(*)").
+		From("sqlite_master").
+		Where("type = 'index' AND tbl_name = ? AND name = ? AND sql IS NOT NULL", recordType, indexName))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return skydb.ErrIndexNotFound
+	}
+
+	stmt := fmt.Sprintf(`DROP INDEX %s`, quoteIdentifier(indexName))
+	log.WithField("stmt", stmt).Debugln("Dropping index")
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to delete index: %s", err)
+	}
+
+	return nil
+}
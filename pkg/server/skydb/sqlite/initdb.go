@@ -0,0 +1,195 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schemaVersion is the version of the tables created by initDB. It is
+// recorded in the _version table so that a database created by an
+// incompatible version of the driver is not opened.
+const schemaVersion = "1"
+
+var errMigrationDisabled = errors.New("skydb/sqlite: database is not initialized and migration is disabled")
+
+const adminRoleDefaultName = "Admin"
+
+const initStmt = `
+CREATE TABLE _version (
+	version_num text NOT NULL
+);
+CREATE TABLE _auth (
+	id text PRIMARY KEY,
+	password text,
+	provider_info text,
+	token_valid_since text,
+	last_seen_at text
+);
+CREATE TABLE _role (
+	id text PRIMARY KEY,
+	by_default boolean NOT NULL DEFAULT FALSE,
+	is_admin boolean NOT NULL DEFAULT FALSE
+);
+CREATE TABLE _auth_role (
+	auth_id text NOT NULL REFERENCES _auth (id) ON DELETE CASCADE,
+	role_id text NOT NULL REFERENCES _role (id),
+	PRIMARY KEY (auth_id, role_id)
+);
+CREATE TABLE _asset (
+	id text PRIMARY KEY,
+	content_type text NOT NULL,
+	size integer NOT NULL
+);
+CREATE TABLE _device (
+	id text PRIMARY KEY,
+	auth_id text REFERENCES _auth (id),
+	type text NOT NULL,
+	token text,
+	topic text,
+	last_registered_at text NOT NULL,
+	UNIQUE (auth_id, type, token)
+);
+CREATE INDEX _device_token_last_registered_at ON _device (token, last_registered_at);
+CREATE TABLE _subscription (
+	id text NOT NULL,
+	auth_id text NOT NULL,
+	device_id text NOT NULL REFERENCES _device (id) ON DELETE CASCADE,
+	type text NOT NULL,
+	notification_info text,
+	query text,
+	PRIMARY KEY (auth_id, device_id, id)
+);
+CREATE TABLE _friend (
+	left_id text NOT NULL,
+	right_id text NOT NULL REFERENCES _auth (id),
+	PRIMARY KEY (left_id, right_id)
+);
+CREATE TABLE _follow (
+	left_id text NOT NULL,
+	right_id text NOT NULL REFERENCES _auth (id),
+	PRIMARY KEY (left_id, right_id)
+);
+CREATE TABLE _record_creation (
+	record_type text NOT NULL,
+	role_id text REFERENCES _role (id),
+	UNIQUE (record_type, role_id)
+);
+CREATE TABLE _record_default_access (
+	record_type text PRIMARY KEY,
+	default_access text
+);
+CREATE TABLE _record_field_access (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	user_role text NOT NULL,
+	writable boolean NOT NULL,
+	readable boolean NOT NULL,
+	comparable boolean NOT NULL,
+	discoverable boolean NOT NULL,
+	PRIMARY KEY (record_type, record_field, user_role)
+);
+CREATE TABLE _record_field (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	type text NOT NULL,
+	on_delete text NOT NULL DEFAULT '',
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_field_validation (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	rules text NOT NULL,
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_field_default (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	value text NOT NULL,
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE "user" (
+	_id text PRIMARY KEY,
+	_database_id text NOT NULL,
+	_owner_id text NOT NULL,
+	_access text,
+	_created_at text NOT NULL,
+	_created_by text,
+	_updated_at text NOT NULL,
+	_updated_by text,
+	username text COLLATE NOCASE,
+	email text COLLATE NOCASE,
+	last_login_at text
+);
+CREATE UNIQUE INDEX auth_record_keys_user_username_key ON "user" ("username");
+CREATE UNIQUE INDEX auth_record_keys_user_email_key ON "user" ("email");
+INSERT INTO _record_field (record_type, record_field, type) VALUES
+	('user', 'username', 'string'),
+	('user', 'email', 'string'),
+	('user', 'last_login_at', 'datetime');
+`
+
+// initDB creates the tables of the database if the database is empty.
+func initDB(db *sqlx.DB, migrate bool) error {
+	var version string
+	err := db.Get(&version, `SELECT version_num FROM _version`)
+	if err == nil {
+		if version != schemaVersion {
+			return fmt.Errorf("skydb/sqlite: database has version %s, want %s", version, schemaVersion)
+		}
+		return nil
+	}
+
+	var tableCount int
+	if err := db.Get(&tableCount, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = '_version'`); err != nil {
+		return err
+	}
+	if tableCount > 0 {
+		if err == sql.ErrNoRows {
+			return errors.New("skydb/sqlite: database has no version")
+		}
+		return err
+	}
+
+	if !migrate {
+		log.Warnf(`Database is not initialized and migration ` +
+			`is disabled. Database schema can only be modified in dev-mode.`)
+		return errMigrationDisabled
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(initStmt); err != nil {
+		return fmt.Errorf("skydb/sqlite: unable to initialize database: %s", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO _version (version_num) VALUES (?)`, schemaVersion); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO _role (id, is_admin) VALUES (?, TRUE)`, adminRoleDefaultName); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
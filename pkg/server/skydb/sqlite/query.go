@@ -0,0 +1,565 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// queryBuilder builds the SQL conditions of a query on a record type.
+//
+// Key paths through reference fields are queried by joining the table of
+// the referenced record type, the joins are added to the statement by
+// addJoins.
+type queryBuilder struct {
+	db           *database
+	primaryTable string
+	joinedTables []joinedTable
+}
+
+// joinedTable represents a specification for table join
+type joinedTable struct {
+	secondaryTable  string
+	primaryColumn   string
+	secondaryColumn string
+}
+
+func newQueryBuilder(db *database, primaryTable string) *queryBuilder {
+	return &queryBuilder{
+		db:           db,
+		primaryTable: primaryTable,
+		joinedTables: []joinedTable{},
+	}
+}
+
+// operand is an expression in a condition.
+type operand struct {
+	skydb.Expression
+	sql       string
+	args      []interface{}
+	fieldType skydb.FieldType
+}
+
+func (b *queryBuilder) predicateSQL(p skydb.Predicate) (string, []interface{}, error) {
+	switch p.Operator {
+	case skydb.And, skydb.Or:
+		conditions := []string{}
+		args := []interface{}{}
+		for _, child := range p.Children {
+			condition, childArgs, err := b.predicateSQL(child.(skydb.Predicate))
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, "("+condition+")")
+			args = append(args, childArgs...)
+		}
+		if len(conditions) == 0 {
+			if p.Operator == skydb.And {
+				return "1", args, nil
+			}
+			return "0", args, nil
+		}
+		if p.Operator == skydb.And {
+			return strings.Join(conditions, " AND "), args, nil
+		}
+		return strings.Join(conditions, " OR "), args, nil
+	case skydb.Not:
+		condition, args, err := b.predicateSQL(p.Children[0].(skydb.Predicate))
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + condition + ")", args, nil
+	case skydb.Functional:
+		return b.functionalPredicateSQL(p)
+	}
+
+	operands := []operand{}
+	for _, child := range p.Children {
+		o, err := b.operand(child.(skydb.Expression))
+		if err != nil {
+			return "", nil, err
+		}
+		operands = append(operands, o)
+	}
+
+	switch p.Operator {
+	case skydb.In:
+		return inSQL(operands[0], operands[1])
+	case skydb.ContainsAny, skydb.ContainsAll:
+		return listContainsSQL(operands[0], operands[1], p.Operator)
+	case skydb.HasKey:
+		return hasKeySQL(operands[0], operands[1])
+	case skydb.Contains:
+		return "", nil, skyerr.NewErrorf(skyerr.NotSupported,
+			"comparison operator `%v` is not supported by the sqlite driver", p.Operator)
+	}
+	return comparisonSQL(operands[0], operands[1], p.Operator)
+}
+
+func (b *queryBuilder) functionalPredicateSQL(p skydb.Predicate) (string, []interface{}, error) {
+	expr := p.Children[0].(skydb.Expression)
+	fn, ok := expr.Value.(skydb.UserRelationFunc)
+	if !ok {
+		return "", nil, skyerr.NewErrorf(skyerr.NotSupported,
+			"function %T is not supported by the sqlite driver", expr.Value)
+	}
+
+	direction := fn.RelationDirection
+	if direction == "" {
+		direction = "outward"
+	}
+	primaryColumn := fn.KeyPath
+	if primaryColumn == "_owner" || primaryColumn == "" {
+		primaryColumn = "_owner_id"
+	}
+
+	var outwardAlias, inwardAlias string
+	if direction == "outward" || direction == "mutual" {
+		outwardAlias = b.createLeftJoin(fn.RelationName, primaryColumn, "right_id")
+	}
+	if direction == "inward" || direction == "mutual" {
+		inwardAlias = b.createLeftJoin(fn.RelationName, primaryColumn, "left_id")
+	}
+
+	switch {
+	case outwardAlias != "" && inwardAlias != "":
+		return fmt.Sprintf("%s = %s AND %s = ?",
+			fullQuoteIdentifier(outwardAlias, "left_id"),
+			fullQuoteIdentifier(inwardAlias, "right_id"),
+			fullQuoteIdentifier(outwardAlias, "left_id")), []interface{}{fn.User}, nil
+	case outwardAlias != "":
+		return fmt.Sprintf("%s = ?", fullQuoteIdentifier(outwardAlias, "left_id")), []interface{}{fn.User}, nil
+	case inwardAlias != "":
+		return fmt.Sprintf("%s = ?", fullQuoteIdentifier(inwardAlias, "right_id")), []interface{}{fn.User}, nil
+	}
+	return "", nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid, "unknown relation direction %s", direction)
+}
+
+func (b *queryBuilder) operand(expr skydb.Expression) (operand, error) {
+	switch expr.Type {
+	case skydb.KeyPath:
+		return b.keyPathOperand(expr)
+	case skydb.Literal:
+		o := operand{Expression: expr}
+		if expr.Value == nil {
+			o.sql = "NULL"
+			return o, nil
+		}
+		fieldType, err := skydb.DeriveFieldType(expr.Value)
+		if err != nil {
+			return operand{}, err
+		}
+		o.fieldType = fieldType
+		if _, ok := expr.Value.([]interface{}); ok {
+			// Arrays are expanded by the operators accepting them.
+			return o, nil
+		}
+		value, err := columnValue(expr.Value)
+		if err != nil {
+			return operand{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		o.sql = "?"
+		o.args = []interface{}{value}
+		return o, nil
+	}
+	return operand{}, skyerr.NewErrorf(skyerr.NotSupported,
+		"expression %v is not supported by the sqlite driver", expr.Value)
+}
+
+func (b *queryBuilder) keyPathOperand(expr skydb.Expression) (operand, error) {
+	components := expr.KeyPathComponents()
+	keyPath := expr.Value.(string)
+
+	if len(components) > 1 {
+		schema, err := b.db.RemoteColumnTypes(b.primaryTable)
+		if err != nil {
+			return operand{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		if field, ok := schema[components[0]]; ok && field.Type == skydb.TypeJSON {
+			return operand{
+				Expression: expr,
+				sql:        jsonPathSQL(b.primaryTable, components[0], components[1:]),
+				args:       []interface{}{},
+				fieldType:  skydb.FieldType{Type: skydb.TypeJSON},
+			}, nil
+		}
+	}
+	if len(components) > 2 {
+		return operand{}, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" with more than 2 components is not supported`, keyPath)
+	}
+
+	alias := b.primaryTable
+	fields, err := skydb.TraverseColumnTypes(b.db, b.primaryTable, keyPath)
+	if err != nil {
+		return operand{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+
+	field := skydb.FieldType{}
+	for i, keyPathField := range fields {
+		isLast := (i == len(components)-1)
+		field = keyPathField
+		if field.Type == skydb.TypeReference && !isLast {
+			alias = b.createLeftJoin(field.ReferenceType, components[i], "_id")
+		}
+	}
+	return operand{
+		Expression: expr,
+		sql:        fullQuoteIdentifier(alias, components[len(components)-1]),
+		args:       []interface{}{},
+		fieldType:  field,
+	}, nil
+}
+
+// jsonPathSQL returns the SQL expression that extracts the value at
+// the path of a JSON column.
+func jsonPathSQL(alias string, column string, path []string) string {
+	var buf bytes.Buffer
+	buf.WriteString("$")
+	for _, element := range path {
+		buf.WriteString(`."`)
+		buf.WriteString(strings.Replace(element, `"`, `\"`, -1))
+		buf.WriteString(`"`)
+	}
+	return fmt.Sprintf("json_extract(%s, %s)",
+		fullQuoteIdentifier(alias, column), quoteLiteral(buf.String()))
+}
+
+// arrayArgs returns the placeholders and arguments of the elements of an
+// array literal.
+func arrayArgs(o operand) (string, []interface{}, error) {
+	values, ok := o.Value.([]interface{})
+	if o.Type != skydb.Literal || !ok {
+		return "", nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid, "expected an array, got %v", o.Value)
+	}
+
+	args := []interface{}{}
+	for _, value := range values {
+		arg, err := columnValue(value)
+		if err != nil {
+			return "", nil, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		}
+		args = append(args, arg)
+	}
+	return sq.Placeholders(len(values)), args, nil
+}
+
+func isListType(fieldType skydb.FieldType) bool {
+	return fieldType.Type == skydb.TypeList || fieldType.Type == skydb.TypeJSON
+}
+
+func inSQL(lhs, rhs operand) (string, []interface{}, error) {
+	switch {
+	case lhs.Type == skydb.KeyPath && rhs.Type == skydb.Literal:
+		placeholders, args, err := arrayArgs(rhs)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(args) == 0 {
+			return "0", args, nil
+		}
+		return fmt.Sprintf("%s IN (%s)", lhs.sql, placeholders), append(lhs.args, args...), nil
+	case lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath && isListType(rhs.fieldType):
+		sql := fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = %s)", rhs.sql, lhs.sql)
+		return sql, append(rhs.args, lhs.args...), nil
+	}
+	return "", nil, skyerr.NewError(skyerr.RecordQueryInvalid,
+		"comparison operator `in` requires a keypath and an array, or a value and a list keypath")
+}
+
+// listContainsSQL returns the condition that a list contains any or all
+// of the values in an array.
+func listContainsSQL(lhs, rhs operand, operator skydb.Operator) (string, []interface{}, error) {
+	if lhs.Type != skydb.KeyPath || !isListType(lhs.fieldType) {
+		return "", nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"comparison operator `%v` requires a list keypath and an array", operator)
+	}
+	_, values, err := arrayArgs(rhs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(values) == 0 {
+		// Every list contains all values of an empty array, but
+		// no list contains any of them.
+		if operator == skydb.ContainsAll {
+			return "1", []interface{}{}, nil
+		}
+		return "0", []interface{}{}, nil
+	}
+
+	if operator == skydb.ContainsAny {
+		sql := fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value IN (%s))",
+			lhs.sql, sq.Placeholders(len(values)))
+		return sql, append(lhs.args, values...), nil
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	for _, value := range values {
+		conditions = append(conditions,
+			fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = ?)", lhs.sql))
+		args = append(args, lhs.args...)
+		args = append(args, value)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+func hasKeySQL(lhs, rhs operand) (string, []interface{}, error) {
+	key, ok := rhs.Value.(string)
+	if lhs.Type != skydb.KeyPath || lhs.fieldType.Type != skydb.TypeJSON || !ok {
+		return "", nil, skyerr.NewError(skyerr.RecordQueryInvalid,
+			"comparison operator `haskey` requires a json keypath and a string key")
+	}
+	sql := fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE key = ?)", lhs.sql)
+	return sql, append(lhs.args, key), nil
+}
+
+func comparisonSQL(lhs, rhs operand, operator skydb.Operator) (string, []interface{}, error) {
+	if lhs.fieldType.Type.IsGeometryCompatibleType() || rhs.fieldType.Type.IsGeometryCompatibleType() {
+		if lhs.Type != skydb.Literal || rhs.Type != skydb.Literal {
+			return "", nil, skyerr.NewError(skyerr.NotSupported,
+				"comparison of locations and geometries is not supported by the sqlite driver")
+		}
+	}
+
+	if operator.IsCommutative() && lhs.IsLiteralNull() && !rhs.IsLiteralNull() {
+		// In SQL, NULL must be on the right side of a comparison
+		// operator.
+		lhs, rhs = rhs, lhs
+	}
+
+	if rhs.IsLiteralNull() {
+		switch operator {
+		case skydb.Equal:
+			return lhs.sql + " IS NULL", lhs.args, nil
+		case skydb.NotEqual:
+			return lhs.sql + " IS NOT NULL", lhs.args, nil
+		}
+	}
+
+	var format string
+	switch operator {
+	case skydb.Equal:
+		format = "%s = %s"
+	case skydb.NotEqual:
+		format = "%s <> %s"
+	case skydb.GreaterThan:
+		format = "%s > %s"
+	case skydb.GreaterThanOrEqual:
+		format = "%s >= %s"
+	case skydb.LessThan:
+		format = "%s < %s"
+	case skydb.LessThanOrEqual:
+		format = "%s <= %s"
+	case skydb.Like:
+		format = "%s LIKE %s"
+	case skydb.ILike:
+		format = "lower(%s) LIKE lower(%s)"
+	default:
+		return "", nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"comparison operator `%v` is not supported", operator)
+	}
+
+	if lhs.sql == "" || rhs.sql == "" {
+		return "", nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"comparison operator `%v` cannot compare arrays", operator)
+	}
+
+	args := append(append([]interface{}{}, lhs.args...), rhs.args...)
+	return fmt.Sprintf(format, lhs.sql, rhs.sql), args, nil
+}
+
+// createLeftJoin create an alias of a table to be joined to the primary table
+// and return the alias for the joined table
+func (b *queryBuilder) createLeftJoin(secondaryTable string, primaryColumn string, secondaryColumn string) string {
+	newAlias := joinedTable{secondaryTable, primaryColumn, secondaryColumn}
+	for i, alias := range b.joinedTables {
+		if alias == newAlias {
+			return fmt.Sprintf("_t%d", i)
+		}
+	}
+
+	b.joinedTables = append(b.joinedTables, newAlias)
+	return fmt.Sprintf("_t%d", len(b.joinedTables)-1)
+}
+
+// addJoins adds join clauses to a SelectBuilder
+func (b *queryBuilder) addJoins(q sq.SelectBuilder) sq.SelectBuilder {
+	for i, alias := range b.joinedTables {
+		joinClause := fmt.Sprintf("%s AS %s ON %s = %s",
+			b.db.TableName(alias.secondaryTable), quoteIdentifier(fmt.Sprintf("_t%d", i)),
+			fullQuoteIdentifier(b.primaryTable, alias.primaryColumn),
+			fullQuoteIdentifier(fmt.Sprintf("_t%d", i), alias.secondaryColumn))
+		q = q.LeftJoin(joinClause)
+	}
+
+	if len(b.joinedTables) > 0 {
+		q = q.Distinct()
+	}
+	return q
+}
+
+// accessControlSQL returns the condition that the user is granted the
+// access level on a record by its ACL.
+//
+// Entries of any level grant read access, while only entries of write
+// level grant write access. A record without ACL is accessible by
+// everyone.
+func accessControlSQL(alias string, user *skydb.AuthInfo, level skydb.RecordACLLevel) (string, []interface{}) {
+	access := fullQuoteIdentifier(alias, "_access")
+
+	grants := []string{}
+	args := []interface{}{}
+	if user != nil {
+		for _, role := range user.Roles {
+			grants = append(grants, "json_extract(e.value, '$.role') = ?")
+			args = append(args, role)
+		}
+		grants = append(grants, "json_extract(e.value, '$.user_id') = ?")
+		args = append(args, user.ID)
+	}
+	grants = append(grants, "json_extract(e.value, '$.public') = 1")
+
+	levelCondition := ""
+	if level == skydb.WriteLevel {
+		levelCondition = " AND json_extract(e.value, '$.level') = 'write'"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "(EXISTS (SELECT 1 FROM json_each(%s) AS e WHERE (%s)%s)",
+		access, strings.Join(grants, " OR "), levelCondition)
+	if user != nil {
+		fmt.Fprintf(&b, " OR %s = ?", fullQuoteIdentifier(alias, "_owner_id"))
+		args = append(args, user.ID)
+	}
+	fmt.Fprintf(&b, " OR %s IS NULL)", access)
+	return b.String(), args
+}
+
+// sortOrderBySQL returns the ORDER BY clause of a sort.
+//
+// NULL values are sorted last in ascending order and first in descending
+// order, as PostgreSQL does.
+func sortOrderBySQL(alias string, sort skydb.Sort) (string, error) {
+	if sort.Expression.Type != skydb.KeyPath {
+		return "", skyerr.NewError(skyerr.NotSupported,
+			"sorting by function is not supported by the sqlite driver")
+	}
+
+	var expr string
+	components := sort.Expression.KeyPathComponents()
+	if len(components) > 1 {
+		expr = jsonPathSQL(alias, components[0], components[1:])
+	} else {
+		expr = fullQuoteIdentifier(alias, components[0])
+	}
+
+	switch sort.Order {
+	case skydb.Asc:
+		return fmt.Sprintf("%s IS NULL ASC, %s ASC", expr, expr), nil
+	case skydb.Desc:
+		return fmt.Sprintf("%s IS NULL DESC, %s DESC", expr, expr), nil
+	default:
+		return "", fmt.Errorf("unknown sort order = %v", sort.Order)
+	}
+}
+
+// keysetSQL returns the condition that selects records positioned after
+// (or before) a cursor in the order specified by sorts, followed by the
+// ascending order of `_id`.
+//
+// For sorts (a, b) and a cursor (x, y, id), the condition is equivalent
+// to `a > x OR (a = x AND b > y) OR (a = x AND b = y AND _id > id)` with
+// comparison operators chosen according to the sort order.
+func keysetSQL(alias string, sorts []skydb.Sort, cursor skydb.Cursor, before bool) (string, []interface{}, error) {
+	if len(cursor.Values) != len(sorts) {
+		return "", nil, fmt.Errorf("cursor has %d values, want %d", len(cursor.Values), len(sorts))
+	}
+
+	terms := []string{}
+	equalities := []string{}
+	equalityArgs := []interface{}{}
+	args := []interface{}{}
+	for i, sort := range sorts {
+		if sort.Expression.Type != skydb.KeyPath {
+			return "", nil, fmt.Errorf("cursor is not supported for sort by %v", sort.Expression.Type)
+		}
+
+		column := fullQuoteIdentifier(alias, sort.Expression.Value.(string))
+		value, err := columnValue(cursor.Values[i])
+		if err != nil {
+			return "", nil, err
+		}
+
+		// Records are positioned after the cursor if the column is greater
+		// than the value in ascending order, or less than the value in
+		// descending order. The opposite holds for records before the cursor.
+		greater := (sort.Order == skydb.Descending) == before
+
+		var cmpSQL string
+		var cmpArgs []interface{}
+		switch {
+		case value == nil && greater:
+			cmpSQL = "0"
+		case value == nil:
+			cmpSQL = column + " IS NOT NULL"
+		case greater:
+			cmpSQL = fmt.Sprintf("(%s > ? OR %s IS NULL)", column, column)
+			cmpArgs = []interface{}{value}
+		default:
+			cmpSQL = fmt.Sprintf("%s < ?", column)
+			cmpArgs = []interface{}{value}
+		}
+		terms = append(terms, keysetTerm(equalities, cmpSQL))
+		args = append(args, equalityArgs...)
+		args = append(args, cmpArgs...)
+
+		if value == nil {
+			equalities = append(equalities, column+" IS NULL")
+		} else {
+			equalities = append(equalities, column+" = ?")
+			equalityArgs = append(equalityArgs, value)
+		}
+	}
+
+	idOperator := ">"
+	if before {
+		idOperator = "<"
+	}
+	terms = append(terms, keysetTerm(equalities,
+		fmt.Sprintf("%s %s ?", fullQuoteIdentifier(alias, "_id"), idOperator)))
+	args = append(args, equalityArgs...)
+	args = append(args, cursor.ID)
+
+	return "(" + strings.Join(terms, " OR ") + ")", args, nil
+}
+
+// keysetTerm joins the equalities of the preceding sorts and the comparison
+// of the current sort with AND.
+func keysetTerm(equalities []string, comparison string) string {
+	if len(equalities) == 0 {
+		return comparison
+	}
+	return "(" + strings.Join(equalities, " AND ") + " AND " + comparison + ")"
+}
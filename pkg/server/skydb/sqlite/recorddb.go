@@ -0,0 +1,560 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if len(typemap) == 0 { // record type has not been created
+		return skydb.ErrRecordNotFound
+	}
+
+	builder := db.selectQuery(sq.Select(), id.Type, typemap).
+		Where(fullQuoteIdentifier(id.Type, "_id")+" = ?", id.Key)
+	records, err := db.queryRecords(builder, id.Type, typemap)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return skydb.ErrRecordNotFound
+	}
+
+	*record = records[0]
+	return nil
+}
+
+// GetByIDs using SQL IN cause
+// GetByIDs only support one type of records at a time. If you want to query
+// array of ids belongs to different type, you need to call this method multiple
+// time.
+func (db *database) GetByIDs(ids []skydb.RecordID) (*skydb.Rows, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("db.GetByIDs received empty array")
+	}
+	keys := []interface{}{}
+	recordType := ""
+	for _, recordID := range ids {
+		if recordID.Key != "" {
+			keys = append(keys, recordID.Key)
+		}
+		if recordID.Type != "" && recordType == "" {
+			recordType = recordID.Type
+		}
+	}
+
+	log.Debugf("GetByIDs Type: %s", recordType)
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return nil, err
+	}
+	if len(typemap) == 0 {
+		log.Debugf("Record Type has not been created")
+		return nil, skydb.ErrRecordNotFound
+	}
+
+	builder := db.selectQuery(sq.Select(), recordType, typemap).
+		Where(fullQuoteIdentifier(recordType, "_id")+" IN ("+sq.Placeholders(len(keys))+")", keys...)
+	records, err := db.queryRecords(builder, recordType, typemap)
+	if err != nil {
+		log.Debugf("Getting records by ID failed %v", err)
+		return nil, err
+	}
+	return skydb.NewRows(&recordsIter{records: records}), nil
+}
+
+// Save inserts the record, or updates the record if it exists.
+//
+// Field operations are applied to the existing value of the field in the
+// same transaction as the update.
+func (db *database) Save(record *skydb.Record) error {
+	if record.ID.Key == "" {
+		return errors.New("db.save: got empty record id")
+	}
+	if record.ID.Type == "" {
+		return fmt.Errorf("db.save %s: got empty record type", record.ID.Key)
+	}
+	if record.OwnerID == "" {
+		return fmt.Errorf("db.save %s: got empty OwnerID", record.ID.Key)
+	}
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return err
+	}
+	if len(typemap) == 0 {
+		return fmt.Errorf("db.save %s: record type has not been created", record.ID)
+	}
+
+	return db.c.withTx(func() error {
+		existing := skydb.Record{}
+		err := db.Get(record.ID, &existing)
+		exists := err == nil
+		if err != nil && err != skydb.ErrRecordNotFound {
+			return err
+		}
+
+		data, err := db.saveData(record, typemap, existing, exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			data["_id"] = record.ID.Key
+			data["_database_id"] = db.userID
+			data["_owner_id"] = record.OwnerID
+			data["_created_at"] = formatTime(record.CreatedAt)
+			data["_created_by"] = record.CreatorID
+		}
+		columns := map[string]interface{}{}
+		for key, value := range data {
+			columns[quoteIdentifier(key)] = value
+		}
+
+		if exists {
+			builder := sq.Update(db.TableName(record.ID.Type)).
+				SetMap(columns).
+				Where("_id = ? AND _database_id = ?", record.ID.Key, db.userID)
+			_, err = db.c.ExecWith(builder)
+		} else {
+			builder := sq.Insert(db.TableName(record.ID.Type)).SetMap(columns)
+			_, err = db.c.ExecWith(builder)
+		}
+		if isUniqueViolated(err) {
+			return skyerr.NewErrorf(skyerr.Duplicated, "failed to save %s: %s", record.ID, err)
+		} else if isForeignKeyViolated(err) {
+			return skyerr.NewErrorf(skyerr.ConstraintViolated, "failed to save %s: %s", record.ID, err)
+		} else if err != nil {
+			return skyerr.MakeError(err)
+		}
+
+		if err := db.Get(record.ID, record); err != nil {
+			return err
+		}
+
+		event := skydb.RecordCreated
+		if exists {
+			event = skydb.RecordUpdated
+		}
+		saved := record.Copy()
+		db.c.notify(skydb.RecordEvent{Record: &saved, Event: event})
+		return nil
+	})
+}
+
+// saveData returns the column values of the record to be saved. The
+// owner and creation of an existing record are not updated.
+func (db *database) saveData(record *skydb.Record, typemap skydb.RecordSchema, existing skydb.Record, exists bool) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	for key, value := range record.Data {
+		switch v := value.(type) {
+		case skydb.Unknown:
+			// Do not modify columns with unknown type because they are
+			// managed by the developer.
+			continue
+		case skydb.FieldOperation:
+			var current interface{}
+			if exists {
+				current = existing.Data[key]
+			}
+			result, err := v.Apply(current)
+			if err != nil {
+				return nil, skyerr.NewInvalidArgument(
+					fmt.Sprintf("cannot apply %s to field %s: %s", v.Operator, key, err),
+					[]string{key},
+				)
+			}
+			value = result
+		}
+
+		columnValue, err := columnValue(value)
+		if err != nil {
+			return nil, skyerr.NewErrorf(skyerr.InvalidArgument, "failed to save %s: %s", record.ID, err)
+		}
+		data[key] = columnValue
+	}
+
+	if !exists {
+		for key, fieldType := range typemap {
+			if _, ok := data[key]; ok || fieldType.Type != skydb.TypeSequence {
+				continue
+			}
+			var next int64
+			err := db.c.Get(&next, fmt.Sprintf("SELECT COALESCE(MAX(%s), 0) + 1 FROM %s",
+				quoteIdentifier(key), db.TableName(record.ID.Type)))
+			if err != nil {
+				return nil, err
+			}
+			data[key] = next
+		}
+	}
+
+	acl, err := columnValue(record.ACL)
+	if err != nil {
+		return nil, err
+	}
+	data["_access"] = acl
+	data["_updated_at"] = formatTime(record.UpdatedAt)
+	data["_updated_by"] = record.UpdaterID
+	return data, nil
+}
+
+func (db *database) Delete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	return db.c.withTx(func() error {
+		record := skydb.Record{}
+		if err := db.Get(id, &record); err != nil {
+			return err
+		}
+
+		builder := sq.Delete(db.TableName(id.Type)).
+			Where("_id = ? AND _database_id = ?", id.Key, db.userID)
+		_, err := db.c.ExecWith(builder)
+		if isForeignKeyViolated(err) {
+			return skyerr.NewError(
+				skyerr.ConstraintViolated,
+				fmt.Sprintf("delete %s: failed to delete record because other records have reference to it", id),
+			)
+		} else if err != nil {
+			return fmt.Errorf("delete %s: failed to delete record", id)
+		}
+
+		db.c.notify(skydb.RecordEvent{Record: &record, Event: skydb.RecordDeleted})
+		return nil
+	})
+}
+
+func (db *database) applyQueryPredicate(q sq.SelectBuilder, b *queryBuilder, query *skydb.Query) (sq.SelectBuilder, error) {
+	if p := query.Predicate; !p.IsEmpty() {
+		condition, args, err := b.predicateSQL(p)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where(condition, args...)
+		q = b.addJoins(q)
+	}
+
+	if db.DatabaseType() == skydb.PublicDatabase && !query.BypassAccessControl {
+		level := query.AccessLevel
+		if level == "" {
+			level = skydb.ReadLevel
+		}
+		condition, args := accessControlSQL(b.primaryTable, query.ViewAsUser, level)
+		q = q.Where(condition, args...)
+	}
+
+	return q, nil
+}
+
+// Query returns the records matching the query. The records are read
+// before Query returns, so that the connection is free for other
+// statements while the rows are iterated.
+func (db *database) Query(query *skydb.Query) (*skydb.Rows, error) {
+	if query.Type == "" {
+		return nil, errors.New("got empty query type")
+	}
+
+	typemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(typemap) == 0 { // record type has not been created
+		return skydb.EmptyRows, nil
+	}
+
+	b := newQueryBuilder(db, query.Type)
+	q, err := db.applyQueryPredicate(sq.Select(), b, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.After != nil {
+		condition, args, err := keysetSQL(query.Type, query.Sorts, *query.After, false)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(condition, args...)
+	}
+
+	if query.Before != nil {
+		condition, args, err := keysetSQL(query.Type, query.Sorts, *query.Before, true)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(condition, args...)
+	}
+
+	// When paging backward from a cursor, the records closest to the
+	// cursor are fetched in reverse order and reversed again after scanning.
+	reversed := query.Before != nil && query.After == nil && query.Limit != nil
+
+	sorts := query.Sorts
+	// Order by _id so that the order is deterministic for records with
+	// identical sort values, which is required for cursor paging.
+	if len(query.Sorts) > 0 || query.Limit != nil || query.After != nil || query.Before != nil {
+		sorts = append(append([]skydb.Sort{}, sorts...), skydb.Sort{
+			Expression: skydb.Expression{Type: skydb.KeyPath, Value: "_id"},
+			Order:      skydb.Ascending,
+		})
+	}
+	for _, sort := range sorts {
+		if reversed {
+			sort.Order = reverseSortOrder(sort.Order)
+		}
+		orderBy, err := sortOrderBySQL(query.Type, sort)
+		if err != nil {
+			return nil, err
+		}
+		q = q.OrderBy(orderBy)
+	}
+
+	if query.Limit != nil {
+		q = q.Limit(*query.Limit)
+	}
+
+	if query.Offset > 0 {
+		if query.Limit == nil {
+			// SQLite does not accept OFFSET without LIMIT
+			q = q.Limit(1<<63 - 1)
+		}
+		q = q.Offset(query.Offset)
+	}
+
+	typemap, err = updateTypemapForQuery(query, typemap)
+	if err != nil {
+		return nil, err
+	}
+	q = db.selectQuery(q, query.Type, typemap)
+
+	records, err := db.queryRecords(q, query.Type, typemap)
+	if err != nil {
+		return nil, err
+	}
+	if reversed {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	iter := &recordsIter{records: records}
+
+	// The overall count is not available for cursor query, since the
+	// keyset condition excludes records from the count.
+	// QueryCount is used instead.
+	if query.GetCount && query.After == nil && query.Before == nil {
+		count, err := db.QueryCount(query)
+		if err != nil {
+			return nil, err
+		}
+		iter.recordCount = &count
+	}
+	return skydb.NewRows(iter), nil
+}
+
+func (db *database) QueryCount(query *skydb.Query) (uint64, error) {
+	if query.Type == "" {
+		return 0, errors.New("got empty query type")
+	}
+
+	typemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil || len(typemap) == 0 { // error or record type has not been created
+		return 0, err
+	}
+
+	b := newQueryBuilder(db, query.Type)
+	q, err := db.applyQueryPredicate(sq.Select(), b, query)
+	if err != nil {
+		return 0, err
+	}
+
+	// Records are counted in a subquery, so that the records joined with
+	// more than one row are counted once.
+	q = db.selectQuery(q, query.Type, skydb.RecordSchema{"_id": typemap["_id"]})
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var recordCount uint64
+	err = db.c.Get(&recordCount, "SELECT COUNT(*) FROM ("+sql+")", args...)
+	return recordCount, err
+}
+
+// queryRecords returns the records selected by the statement.
+func (db *database) queryRecords(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema) ([]skydb.Record, error) {
+	rows, err := db.c.QueryWith(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []skydb.Record{}
+	for rows.Next() {
+		record := skydb.Record{}
+		if err := scanRecord(rows, columns, recordType, typemap, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func scanRecord(scanner sq.RowScanner, columns []string, recordType string, typemap skydb.RecordSchema, record *skydb.Record) error {
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := scanner.Scan(dest...); err != nil {
+		return err
+	}
+
+	record.ID.Type = recordType
+	record.Data = map[string]interface{}{}
+	for i, column := range columns {
+		if values[i] == nil {
+			continue
+		}
+
+		fieldType, ok := typemap[column]
+		if !ok {
+			return fmt.Errorf("received unknown column = %s", column)
+		}
+		value, err := fieldValue(fieldType, values[i])
+		if err != nil {
+			return fmt.Errorf("failed to scan column = %s: %s", column, err)
+		}
+		record.Set(column, value)
+	}
+	return nil
+}
+
+// recordsIter iterates over records that are already scanned.
+type recordsIter struct {
+	records     []skydb.Record
+	recordCount *uint64
+}
+
+func (rs *recordsIter) Close() error {
+	return nil
+}
+
+func (rs *recordsIter) Next(record *skydb.Record) error {
+	if len(rs.records) == 0 {
+		return io.EOF
+	}
+
+	*record = rs.records[0]
+	rs.records = rs.records[1:]
+	return nil
+}
+
+func (rs *recordsIter) OverallRecordCount() *uint64 {
+	return rs.recordCount
+}
+
+func reverseSortOrder(order skydb.SortOrder) skydb.SortOrder {
+	if order == skydb.Descending {
+		return skydb.Ascending
+	}
+	return skydb.Descending
+}
+
+func (db *database) selectQuery(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema) sq.SelectBuilder {
+	for column := range typemap {
+		q = q.Column(fullQuoteIdentifier(recordType, column) + " AS " + quoteIdentifier(column))
+	}
+
+	q = q.From(db.TableName(recordType))
+
+	switch db.DatabaseType() {
+	case skydb.UnionDatabase:
+		// no filter on `_database_id` column
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		q = q.Where(fullQuoteIdentifier(recordType, "_database_id")+" = ?", db.userID)
+	}
+	return q
+}
+
+func updateTypemapForQuery(query *skydb.Query, typemap skydb.RecordSchema) (skydb.RecordSchema, error) {
+	if query.DesiredKeys != nil {
+		newtypemap, err := whitelistedRecordSchema(typemap, query.DesiredKeys)
+		if err != nil {
+			return nil, err
+		}
+		typemap = newtypemap
+	}
+
+	for _, value := range query.ComputedKeys {
+		if value.Type == skydb.KeyPath {
+			// recorddb does not support querying with computed keys
+			continue
+		}
+
+		return nil, skyerr.NewError(skyerr.NotSupported,
+			"computed keys are not supported by the sqlite driver")
+	}
+	return typemap, nil
+}
+
+func whitelistedRecordSchema(schema skydb.RecordSchema, whitelistKeys []string) (skydb.RecordSchema, error) {
+	wlSchema := skydb.RecordSchema{}
+
+	for _, key := range whitelistKeys {
+		columnType, ok := schema[key]
+		if !ok {
+			return nil, fmt.Errorf(`unexpected key "%s"`, key)
+		}
+		wlSchema[key] = columnType
+	}
+	for key, value := range schema {
+		if strings.HasPrefix(key, "_") {
+			wlSchema[key] = value
+		}
+	}
+
+	return wlSchema, nil
+}
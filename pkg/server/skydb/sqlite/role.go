@@ -0,0 +1,199 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func stringArgs(strs []string) []interface{} {
+	args := make([]interface{}, len(strs))
+	for i, s := range strs {
+		args[i] = s
+	}
+	return args
+}
+
+func (c *conn) queryStrings(builder sq.SelectBuilder) ([]string, error) {
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strs := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+	return strs, rows.Err()
+}
+
+func (c *conn) getRolesByType(roleType string) ([]string, error) {
+	var col string
+	switch roleType {
+	case "admin":
+		col = "is_admin"
+	case "default":
+		col = "by_default"
+	default:
+		panic("Unknow role type")
+	}
+	builder := sq.Select("id").
+		From(c.tableName("_role")).
+		Where(col + " = TRUE").
+		OrderBy("id")
+	return c.queryStrings(builder)
+}
+
+func (c *conn) GetAdminRoles() ([]string, error) {
+	return c.getRolesByType("admin")
+}
+
+func (c *conn) SetAdminRoles(roles []string) error {
+	log.Debugf("SetAdminRoles %v", roles)
+	return c.setRoleType(roles, "is_admin")
+}
+
+func (c *conn) GetDefaultRoles() ([]string, error) {
+	return c.getRolesByType("default")
+}
+
+func (c *conn) SetDefaultRoles(roles []string) error {
+	log.Debugf("SetDefaultRoles %v", roles)
+	return c.setRoleType(roles, "by_default")
+}
+
+func (c *conn) setRoleType(roles []string, col string) error {
+	if err := c.ensureRole(roles); err != nil {
+		return err
+	}
+
+	resetSQL := sq.Update(c.tableName("_role")).
+		Where(col+" = ?", true).Set(col, false)
+	if _, err := c.ExecWith(resetSQL); err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+
+	updateSQL := sq.Update(c.tableName("_role")).
+		Where("id IN ("+sq.Placeholders(len(roles))+")", stringArgs(roles)...).
+		Set(col, true)
+	_, err := c.ExecWith(updateSQL)
+	return err
+}
+
+// UpdateUserRoles replaces the roles of the user with the roles of the
+// AuthInfo. Roles not yet existed are created.
+func (c *conn) UpdateUserRoles(authinfo *skydb.AuthInfo) error {
+	log.Debugf("UpdateRoles %v", authinfo)
+	builder := sq.Delete(c.tableName("_auth_role")).Where("auth_id = ?", authinfo.ID)
+	if _, err := c.ExecWith(builder); err != nil {
+		return err
+	}
+
+	return c.AssignRoles([]string{authinfo.ID}, authinfo.Roles)
+}
+
+func (c *conn) AssignRoles(userIDs []string, roles []string) error {
+	log.Debugf("AssignRoles %v to %v", roles, userIDs)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+	if err := c.ensureRole(roles); err != nil {
+		return err
+	}
+
+	builder := sq.Insert(c.tableName("_auth_role")).
+		Options("OR IGNORE").
+		Columns("auth_id", "role_id")
+	for _, userID := range userIDs {
+		for _, role := range roles {
+			builder = builder.Values(userID, role)
+		}
+	}
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) RevokeRoles(userIDs []string, roles []string) error {
+	log.Debugf("RevokeRoles %v to %v", roles, userIDs)
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+
+	builder := sq.Delete(c.tableName("_auth_role")).
+		Where("auth_id IN ("+sq.Placeholders(len(userIDs))+")", stringArgs(userIDs)...).
+		Where("role_id IN ("+sq.Placeholders(len(roles))+")", stringArgs(roles)...)
+	_, err := c.ExecWith(builder)
+	return err
+}
+
+func (c *conn) GetRoles(userIDs []string) (map[string][]string, error) {
+	roleMap := map[string][]string{}
+	for _, eachUserID := range userIDs {
+		// keep an empty array even no roles found for that user
+		roleMap[eachUserID] = []string{}
+	}
+	if len(userIDs) == 0 {
+		return roleMap, nil
+	}
+
+	builder := sq.Select("auth_id", "role_id").
+		From(c.tableName("_auth_role")).
+		Where("auth_id IN ("+sq.Placeholders(len(userIDs))+")", stringArgs(userIDs)...).
+		OrderBy("auth_id", "role_id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		eachAuthID := ""
+		eachRole := ""
+		if err := rows.Scan(&eachAuthID, &eachRole); err != nil {
+			return nil, err
+		}
+		roleMap[eachAuthID] = append(roleMap[eachAuthID], eachRole)
+	}
+
+	return roleMap, rows.Err()
+}
+
+// ensureRole creates the roles which do not exist.
+func (c *conn) ensureRole(roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	builder := sq.Insert(c.tableName("_role")).
+		Options("OR IGNORE").
+		Columns("id")
+	for _, role := range roles {
+		builder = builder.Values(role)
+	}
+	_, err := c.ExecWith(builder)
+	return err
+}
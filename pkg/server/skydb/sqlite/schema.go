@@ -0,0 +1,512 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// reservedSchema is the schema of the columns of every record table.
+var reservedSchema = skydb.RecordSchema{
+	"_id":          skydb.FieldType{Type: skydb.TypeString},
+	"_database_id": skydb.FieldType{Type: skydb.TypeString},
+	"_owner_id":    skydb.FieldType{Type: skydb.TypeString},
+	"_access":      skydb.FieldType{Type: skydb.TypeACL},
+	"_created_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+	"_created_by":  skydb.FieldType{Type: skydb.TypeString},
+	"_updated_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+	"_updated_by":  skydb.FieldType{Type: skydb.TypeString},
+}
+
+func createTableStmt(tableName string) string {
+	return fmt.Sprintf(`
+CREATE TABLE %s (
+	_id text PRIMARY KEY,
+	_database_id text NOT NULL,
+	_owner_id text NOT NULL,
+	_access text,
+	_created_at text NOT NULL,
+	_created_by text,
+	_updated_at text NOT NULL,
+	_updated_by text
+);
+`, tableName)
+}
+
+// fieldMetadataTables are the tables storing information of fields which
+// are not part of the column definition, keyed by record type and field.
+var fieldMetadataTables = []string{
+	"_record_field",
+	"_record_field_validation",
+	"_record_field_default",
+}
+
+func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (extended bool, err error) {
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+
+	// The referential action of a foreign key cannot be altered in
+	// SQLite. An empty action does not change the action of an
+	// existing field.
+	for key, fieldType := range recordSchema {
+		remoteFieldType, ok := remoteRecordSchema[key]
+		if ok && fieldType.Type == skydb.TypeReference &&
+			remoteFieldType.DefinitionCompatibleTo(fieldType) &&
+			fieldType.OnDelete != skydb.NoReferentialAction &&
+			fieldType.OnDelete != remoteFieldType.OnDelete {
+			return false, skyerr.NewErrorf(
+				skyerr.NotSupported,
+				"changing the referential action of field %s is not supported",
+				key,
+			)
+		}
+	}
+
+	// Find fields with changed validation rules
+	updatingValidations := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if validationChanged(remoteRecordSchema[key], fieldType) {
+			updatingValidations[key] = fieldType
+		}
+	}
+
+	// Find fields with changed default values
+	updatingDefaults := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if defaultChanged(remoteRecordSchema[key], fieldType) {
+			updatingDefaults[key] = fieldType
+		}
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) &&
+		len(updatingValidations) == 0 && len(updatingDefaults) == 0 {
+		// The current record schema is superset of requested record
+		// schema. There is no need to extend the schema.
+		return
+	}
+
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		err = skyerr.NewError(
+			skyerr.IncompatibleSchema,
+			"Record schema requires migration but migration is disabled.",
+		)
+		return
+	}
+
+	// Find new columns
+	updatingSchema := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if remoteFieldType, ok := remoteRecordSchema[key]; ok {
+			if !remoteFieldType.DefinitionCompatibleTo(fieldType) {
+				return false, skyerr.NewError(
+					skyerr.IncompatibleSchema,
+					fmt.Sprintf("conflicting schema %v => %v", remoteFieldType, fieldType),
+				)
+			}
+		} else {
+			updatingSchema[key] = fieldType
+		}
+	}
+
+	for _, fieldType := range updatingSchema {
+		if fieldType.Type != skydb.TypeReference || fieldType.ReferenceType == recordType {
+			continue
+		}
+		referencedSchema, err := db.RemoteColumnTypes(fieldType.ReferenceType)
+		if err != nil {
+			return false, err
+		}
+		if len(referencedSchema) == 0 {
+			return false, skyerr.NewErrorf(
+				skyerr.IncompatibleSchema,
+				"referenced record type %s does not exist", fieldType.ReferenceType,
+			)
+		}
+	}
+
+	err = db.c.withTx(func() error {
+		if len(remoteRecordSchema) == 0 {
+			stmt := createTableStmt(db.TableName(recordType))
+			log.WithField("stmt", stmt).Debugln("Creating table")
+			if _, err := db.c.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to create table: %s", err)
+			}
+			extended = true
+		}
+
+		for _, column := range sortedKeys(updatingSchema) {
+			if err := db.addColumn(recordType, column, updatingSchema[column]); err != nil {
+				return err
+			}
+			extended = true
+		}
+
+		for column, fieldType := range updatingValidations {
+			if err := db.saveFieldValidation(recordType, column, fieldType.Validation); err != nil {
+				return err
+			}
+			extended = true
+		}
+
+		for column, fieldType := range updatingDefaults {
+			if err := db.saveFieldDefault(recordType, column, fieldType.Default); err != nil {
+				return err
+			}
+			extended = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	db.c.invalidateRecordSchema(recordType)
+
+	return
+}
+
+func sortedKeys(schema skydb.RecordSchema) []string {
+	keys := []string{}
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addColumn adds the column of the field to the table of the record type,
+// and records the field type in the _record_field table. The referential
+// action of a reference field is only recorded there, as the records
+// referencing a deleted record are changed by the record handlers.
+func (db *database) addColumn(recordType string, column string, fieldType skydb.FieldType) error {
+	buf := bytes.Buffer{}
+	buf.WriteString("ALTER TABLE ")
+	buf.WriteString(db.TableName(recordType))
+	buf.WriteString(" ADD COLUMN ")
+	buf.WriteString(quoteIdentifier(column))
+	buf.WriteByte(' ')
+	buf.WriteString(sqlDataType(fieldType.Type))
+
+	switch fieldType.Type {
+	case skydb.TypeReference:
+		fmt.Fprintf(&buf, " REFERENCES %s (_id)", db.TableName(fieldType.ReferenceType))
+	case skydb.TypeAsset:
+		fmt.Fprintf(&buf, " REFERENCES %s (id)", db.TableName("_asset"))
+	}
+
+	stmt := buf.String()
+	log.WithField("stmt", stmt).Debugln("Adding column to table")
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to alter table: %s", err)
+	}
+
+	builder := sq.Insert(db.TableName("_record_field")).
+		Columns("record_type", "record_field", "type", "on_delete").
+		Values(recordType, column, fieldType.ToSimpleName(), string(fieldType.OnDelete))
+	if _, err := db.c.ExecWith(builder); err != nil {
+		return fmt.Errorf("failed to save type of %s.%s: %s", recordType, column, err)
+	}
+	return nil
+}
+
+func validationChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Validation == nil {
+		return false
+	}
+	if requested.Validation.IsEmpty() {
+		return !remote.Validation.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Validation, requested.Validation)
+}
+
+func defaultChanged(remote skydb.FieldType, requested skydb.FieldType) bool {
+	if requested.Default == nil {
+		return false
+	}
+	if requested.Default.IsEmpty() {
+		return !remote.Default.IsEmpty()
+	}
+	return !reflect.DeepEqual(remote.Default, requested.Default)
+}
+
+// saveFieldMetadata saves the value to the metadata table of the field,
+// or removes it if the value is nil.
+func (db *database) saveFieldMetadata(table, column string, recordType, field string, value interface{}) error {
+	if value == nil {
+		builder := sq.Delete(db.TableName(table)).
+			Where("record_type = ? AND record_field = ?", recordType, field)
+		_, err := db.c.ExecWith(builder)
+		return err
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	builder := sq.Insert(db.TableName(table)).
+		Options("OR REPLACE").
+		Columns("record_type", "record_field", column).
+		Values(recordType, field, string(b))
+	_, err = db.c.ExecWith(builder)
+	return err
+}
+
+// getFieldMetadata calls decode with the metadata of each field of the
+// record type in the metadata table.
+func (db *database) getFieldMetadata(table, column string, recordType string, decode func(field string, value []byte) error) error {
+	builder := sq.Select("record_field", column).
+		From(db.TableName(table)).
+		Where("record_type = ?", recordType)
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var field, value string
+		if err := rows.Scan(&field, &value); err != nil {
+			return err
+		}
+		if err := decode(field, []byte(value)); err != nil {
+			return fmt.Errorf("failed to decode %s of %s.%s: %s", column, recordType, field, err)
+		}
+	}
+	return rows.Err()
+}
+
+func (db *database) saveFieldValidation(recordType, field string, validation *skydb.FieldValidation) error {
+	var value interface{}
+	if !validation.IsEmpty() {
+		value = validation
+	}
+	if err := db.saveFieldMetadata("_record_field_validation", "rules", recordType, field, value); err != nil {
+		return fmt.Errorf("failed to save validation of %s.%s: %s", recordType, field, err)
+	}
+	return nil
+}
+
+func (db *database) saveFieldDefault(recordType, field string, fieldDefault *skydb.FieldDefault) error {
+	var value interface{}
+	if !fieldDefault.IsEmpty() {
+		value = fieldDefault
+	}
+	if err := db.saveFieldMetadata("_record_field_default", "value", recordType, field, value); err != nil {
+		return fmt.Errorf("failed to save default of %s.%s: %s", recordType, field, err)
+	}
+	return nil
+}
+
+func (db *database) RenameSchema(recordType, oldName, newName string) error {
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	err := db.c.withTx(func() error {
+		stmt := fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+			db.TableName(recordType), quoteIdentifier(oldName), quoteIdentifier(newName))
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+
+		for _, metadataTable := range fieldMetadataTables {
+			builder := sq.Update(db.TableName(metadataTable)).
+				Set("record_field", newName).
+				Where("record_type = ? AND record_field = ?", recordType, oldName)
+			if _, err := db.c.ExecWith(builder); err != nil {
+				return fmt.Errorf("failed to rename field in %s: %s", metadataTable, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db.c.invalidateRecordSchema(recordType)
+	return nil
+}
+
+func (db *database) DeleteSchema(recordType, columnName string) error {
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	err := db.c.withTx(func() error {
+		stmt := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s",
+			db.TableName(recordType), quoteIdentifier(columnName))
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+
+		for _, metadataTable := range fieldMetadataTables {
+			builder := sq.Delete(db.TableName(metadataTable)).
+				Where("record_type = ? AND record_field = ?", recordType, columnName)
+			if _, err := db.c.ExecWith(builder); err != nil {
+				return fmt.Errorf("failed to remove field from %s: %s", metadataTable, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db.c.invalidateRecordSchema(recordType)
+	return nil
+}
+
+func (db *database) GetSchema(recordType string) (skydb.RecordSchema, error) {
+	return db.RemoteColumnTypes(recordType)
+}
+
+func (db *database) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
+	builder := sq.Select("name").
+		From("sqlite_master").
+		Where(`type = 'table' AND name NOT LIKE '\_%' ESCAPE '\' AND name NOT LIKE 'sqlite\_%' ESCAPE '\'`)
+
+	recordTypes, err := db.c.queryStrings(builder)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]skydb.RecordSchema{}
+	for _, recordType := range recordTypes {
+		schema, err := db.GetSchema(recordType)
+		if err != nil {
+			return nil, err
+		}
+
+		result[recordType] = schema
+	}
+	log.Debugf("GetRecordSchemas Success")
+
+	return result, nil
+}
+
+// RemoteColumnTypes returns the schema of the record type. The schema is
+// the reserved columns and the field types recorded in the _record_field
+// table, since SQLite column types do not tell the field types.
+func (db *database) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	// STEP 0: Return the cached ColumnType
+	if schema, ok := db.c.cachedRecordSchema(recordType); ok {
+		log.Debugf("Using cached remoteColumnTypes %s", recordType)
+		return schema, nil
+	}
+	log.Debugf("Querying remoteColumnTypes %s", recordType)
+
+	// STEP 1: Check if the table of the record type exists
+	var tableCount int
+	err := db.c.GetWith(&tableCount, sq.Select("COUNT(*)").
+		From("sqlite_master").
+		Where("type = 'table' AND name = ?", recordType))
+	if err != nil {
+		return nil, err
+	}
+
+	if tableCount == 0 {
+		db.c.cacheRecordSchema(recordType, nil)
+		log.Debugf("Cache remoteColumnTypes %s (no table)", recordType)
+		return nil, nil
+	}
+
+	typemap := skydb.RecordSchema{}
+	for column, fieldType := range reservedSchema {
+		typemap[column] = fieldType
+	}
+
+	// STEP 2: Get the field types
+	rows, err := db.c.QueryWith(sq.Select("record_field", "type", "on_delete").
+		From(db.TableName("_record_field")).
+		Where("record_type = ?", recordType))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var column, simpleName, onDelete string
+		if err := rows.Scan(&column, &simpleName, &onDelete); err != nil {
+			return nil, err
+		}
+
+		fieldType, err := skydb.SimpleNameToFieldType(simpleName)
+		if err != nil {
+			return nil, err
+		}
+		fieldType.OnDelete = skydb.ReferentialAction(onDelete)
+		typemap[column] = fieldType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// STEP 3: Validation rules of fields
+	err = db.getFieldMetadata("_record_field_validation", "rules", recordType, func(field string, value []byte) error {
+		validation := skydb.FieldValidation{}
+		if err := json.Unmarshal(value, &validation); err != nil {
+			return err
+		}
+		if fieldType, ok := typemap[field]; ok {
+			fieldType.Validation = &validation
+			typemap[field] = fieldType
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// STEP 4: Default values of fields
+	err = db.getFieldMetadata("_record_field_default", "value", recordType, func(field string, value []byte) error {
+		fieldDefault := skydb.FieldDefault{}
+		if err := json.Unmarshal(value, &fieldDefault); err != nil {
+			return err
+		}
+		if fieldType, ok := typemap[field]; ok {
+			fieldType.Default = &fieldDefault
+			typemap[field] = fieldType
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.c.cacheRecordSchema(recordType, typemap)
+	log.Debugf("Cache remoteColumnTypes %s", recordType)
+	return typemap, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+// Package sqlite implements skydb with an embedded SQLite database.
+//
+// It is intended for local development and integration tests where
+// running PostgreSQL is not desirable. Spatial queries and full-text
+// search are not supported.
+//
+// The option of the driver is the data source name understood by
+// go-sqlite3, such as the path of the database file. Since SQLite has no
+// schemas, a database file holds the data of one app only.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var log = logging.LoggerEntry("skydb")
+
+// driverName is the name of the database/sql driver which enables foreign
+// keys and case sensitive LIKE on every connection.
+const driverName = "skydb_sqlite3"
+
+func isUniqueViolated(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func isForeignKeyViolated(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// Open returns a new connection to sqlite implementation
+func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, dataSource string, migrate bool) (skydb.Conn, error) {
+	if accessModel == skydb.RelationBasedAccess {
+		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
+	}
+
+	db, err := getDB(dataSource, migrate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		db:           db,
+		RecordSchema: map[string]skydb.RecordSchema{},
+		appName:      appName,
+		option:       dataSource,
+		accessModel:  accessModel,
+		canMigrate:   migrate,
+		context:      ctx,
+	}, nil
+}
+
+var dbs = map[string]*sqlx.DB{}
+var dbsMutex sync.Mutex
+
+func getDB(dataSource string, migrate bool) (*sqlx.DB, error) {
+	dbsMutex.Lock()
+	defer dbsMutex.Unlock()
+
+	if db, ok := dbs[dataSource]; ok {
+		return db, nil
+	}
+
+	db, err := sqlx.Open(driverName, dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection: %s", err)
+	}
+
+	// SQLite allows one writer at a time. Sharing a single connection
+	// serializes the transactions of all skydb connections, and keeps an
+	// in-memory database alive.
+	db.SetMaxOpenConns(1)
+
+	if err := initDB(db, migrate); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open connection: %s", err)
+	}
+
+	dbs[dataSource] = db
+	return db, nil
+}
+
+// closeDB closes the database opened for the data source, so that the
+// next Open initializes the database again.
+func closeDB(dataSource string) error {
+	dbsMutex.Lock()
+	defer dbsMutex.Unlock()
+
+	db, ok := dbs[dataSource]
+	if !ok {
+		return nil
+	}
+	delete(dbs, dataSource)
+	return db.Close()
+}
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(c *sqlite3.SQLiteConn) error {
+			_, err := c.Exec(`
+PRAGMA foreign_keys = ON;
+PRAGMA case_sensitive_like = ON;
+PRAGMA busy_timeout = 5000;
+`, nil)
+			return err
+		},
+	})
+	skydb.Register("sqlite", skydb.DriverFunc(Open))
+}
@@ -0,0 +1,76 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func testAppName() string {
+	return "io.skygear.test"
+}
+
+func getTestConn(t *testing.T) *conn {
+	dir, err := ioutil.TempDir("", "skydb-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(context.Background(), testAppName(), skydb.RoleBasedAccess, filepath.Join(dir, "test.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*conn)
+}
+
+func cleanupConn(t *testing.T, c *conn) {
+	if c.tx != nil {
+		c.Rollback()
+	}
+	if err := closeDB(c.option); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Dir(c.option)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func addUser(t *testing.T, c *conn, userid string) {
+	if err := c.CreateAuth(&skydb.AuthInfo{ID: userid}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exhaustRows(rows *skydb.Rows, errin error) (records []skydb.Record, err error) {
+	if errin != nil {
+		err = errin
+		return
+	}
+
+	for rows.Scan() {
+		records = append(records, rows.Record())
+	}
+
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,285 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// timeLayout is the layout of datetime values stored in the database.
+//
+// SQLite has no datetime type, so datetime values are stored as text in
+// UTC. The layout has a fixed width so that the text of datetime values
+// sorts in the same order as the datetime values.
+const timeLayout = "2006-01-02T15:04:05.000000Z"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+func parseTime(s string) (time.Time, error) {
+	t, err := time.Parse(timeLayout, s)
+	if err != nil {
+		// Accept datetime values written in other layouts, such as the
+		// elements of a JSON array.
+		t, err = time.Parse(time.RFC3339Nano, s)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed datetime %s", s)
+	}
+	if t.IsZero() {
+		return time.Time{}, nil
+	}
+	return t.UTC(), nil
+}
+
+// sqlDataType returns the SQLite type of a column for the field type.
+//
+// Datetime, JSON and spatial values are stored as text, the field type
+// of a column is recorded in the _record_field table instead.
+func sqlDataType(dataType skydb.DataType) string {
+	switch dataType {
+	case skydb.TypeNumber:
+		return "real"
+	case skydb.TypeInteger, skydb.TypeSequence:
+		return "integer"
+	case skydb.TypeBoolean:
+		return "boolean"
+	default:
+		return "text"
+	}
+}
+
+// nullTime implements sql.Scanner for a nullable datetime column.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (nt *nullTime) Scan(value interface{}) error {
+	if value == nil {
+		nt.Time, nt.Valid = time.Time{}, false
+		return nil
+	}
+
+	s, err := stringValue(value)
+	if err != nil {
+		return err
+	}
+	nt.Time, err = parseTime(s)
+	nt.Valid = err == nil
+	return err
+}
+
+// nullTimeValue returns the column value of a nullable datetime.
+func nullTimeValue(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+// columnValue returns the column value of a record field.
+func columnValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		return formatTime(v), nil
+	case skydb.Reference:
+		return v.ID.Key, nil
+	case *skydb.Asset:
+		return v.Name, nil
+	case skydb.Location:
+		return jsonValue([]interface{}{v.Lng(), v.Lat()})
+	case skydb.RecordACL:
+		if v == nil {
+			return nil, nil
+		}
+		return jsonValue(v)
+	case skydb.Geometry:
+		return jsonValue(map[string]interface{}(v))
+	case map[string]interface{}, []interface{}, skydb.Data:
+		return jsonValue(v)
+	case bool, string, int64, float64, int:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// jsonValue returns the JSON text of the value, with datetime values
+// written in the layout of datetime columns.
+func jsonValue(value interface{}) (string, error) {
+	b, err := json.Marshal(normalizeJSON(value))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return formatTime(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, element := range v {
+			values[i] = normalizeJSON(element)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			values[key] = normalizeJSON(element)
+		}
+		return values
+	case skydb.Data:
+		return normalizeJSON(map[string]interface{}(v))
+	default:
+		return value
+	}
+}
+
+// fieldValue converts the value of a column to the value of a record
+// field of the field type.
+func fieldValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeNumber:
+		return floatValue(value)
+	case skydb.TypeInteger, skydb.TypeSequence:
+		f, err := floatValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return int64(f), nil
+	case skydb.TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+		return nil, fmt.Errorf("unexpected boolean value of type %T", value)
+	}
+
+	s, err := stringValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeString:
+		return s, nil
+	case skydb.TypeDateTime:
+		return parseTime(s)
+	case skydb.TypeReference:
+		return skydb.NewReference(fieldType.ReferenceType, s), nil
+	case skydb.TypeAsset:
+		return &skydb.Asset{Name: s}, nil
+	case skydb.TypeACL:
+		acl := skydb.RecordACL{}
+		if err := json.Unmarshal([]byte(s), &acl); err != nil {
+			return nil, err
+		}
+		return acl, nil
+	case skydb.TypeJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case skydb.TypeList:
+		return listValue(fieldType.ElementType, s)
+	case skydb.TypeLocation:
+		var lngLat [2]float64
+		if err := json.Unmarshal([]byte(s), &lngLat); err != nil {
+			return nil, err
+		}
+		return skydb.NewLocation(lngLat[0], lngLat[1]), nil
+	case skydb.TypeGeometry:
+		geometry := skydb.Geometry{}
+		if err := json.Unmarshal([]byte(s), &geometry); err != nil {
+			return nil, err
+		}
+		return geometry, nil
+	default:
+		return nil, fmt.Errorf("unexpected field type %v", fieldType.Type)
+	}
+}
+
+func listValue(elementType skydb.DataType, s string) ([]interface{}, error) {
+	list := []interface{}{}
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return nil, err
+	}
+
+	for i, element := range list {
+		switch v := element.(type) {
+		case float64:
+			if elementType == skydb.TypeInteger {
+				list[i] = int64(v)
+			}
+		case string:
+			if elementType == skydb.TypeDateTime {
+				t, err := parseTime(v)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = t
+			}
+		}
+	}
+	return list, nil
+}
+
+func stringValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unexpected text value of type %T", value)
+}
+
+func floatValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	}
+	return 0, fmt.Errorf("unexpected numeric value of type %T", value)
+}
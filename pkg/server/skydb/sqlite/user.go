@@ -0,0 +1,294 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func providerInfoValue(providerInfo skydb.ProviderInfo) (string, error) {
+	if providerInfo == nil {
+		providerInfo = skydb.ProviderInfo{}
+	}
+	b, err := json.Marshal(providerInfo)
+	return string(b), err
+}
+
+func (c *conn) CreateAuth(authinfo *skydb.AuthInfo) error {
+	providerInfo, err := providerInfoValue(authinfo.ProviderInfo)
+	if err != nil {
+		return err
+	}
+
+	builder := sq.Insert(c.tableName("_auth")).Columns(
+		"id",
+		"password",
+		"provider_info",
+		"token_valid_since",
+		"last_seen_at",
+	).Values(
+		authinfo.ID,
+		string(authinfo.HashedPassword),
+		providerInfo,
+		nullTimeValue(authinfo.TokenValidSince),
+		nullTimeValue(authinfo.LastSeenAt),
+	)
+
+	_, err = c.ExecWith(builder)
+	if isUniqueViolated(err) {
+		return skydb.ErrUserDuplicated
+	} else if err != nil {
+		return err
+	}
+
+	if err := c.UpdateUserRoles(authinfo); err != nil {
+		return skydb.ErrRoleUpdatesFailed
+	}
+	return nil
+}
+
+func (c *conn) UpdateAuth(authinfo *skydb.AuthInfo) error {
+	providerInfo, err := providerInfoValue(authinfo.ProviderInfo)
+	if err != nil {
+		return err
+	}
+
+	builder := sq.Update(c.tableName("_auth")).
+		Set("password", string(authinfo.HashedPassword)).
+		Set("provider_info", providerInfo).
+		Set("token_valid_since", nullTimeValue(authinfo.TokenValidSince)).
+		Set("last_seen_at", nullTimeValue(authinfo.LastSeenAt)).
+		Where("id = ?", authinfo.ID)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		if isUniqueViolated(err) {
+			return skydb.ErrUserDuplicated
+		}
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrUserNotFound
+	}
+
+	if err := c.UpdateUserRoles(authinfo); err != nil {
+		return skydb.ErrRoleUpdatesFailed
+	}
+	return nil
+}
+
+func (c *conn) baseUserBuilder() sq.SelectBuilder {
+	return sq.Select("id", "password", "provider_info",
+		"token_valid_since", "last_seen_at",
+		"(SELECT json_group_array(role_id) FROM _auth_role WHERE auth_id = id) AS roles").
+		From(c.tableName("_auth"))
+}
+
+func (c *conn) doScanAuth(authinfo *skydb.AuthInfo, scanner sq.RowScanner) error {
+	var (
+		id              string
+		password        sql.NullString
+		providerInfo    sql.NullString
+		tokenValidSince nullTime
+		lastSeenAt      nullTime
+		roles           string
+	)
+
+	err := scanner.Scan(
+		&id,
+		&password,
+		&providerInfo,
+		&tokenValidSince,
+		&lastSeenAt,
+		&roles,
+	)
+	if err == sql.ErrNoRows {
+		return skydb.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	authinfo.ID = id
+	authinfo.HashedPassword = []byte(password.String)
+	authinfo.ProviderInfo = skydb.ProviderInfo{}
+	if providerInfo.Valid {
+		if err := json.Unmarshal([]byte(providerInfo.String), &authinfo.ProviderInfo); err != nil {
+			return err
+		}
+	}
+	if tokenValidSince.Valid {
+		authinfo.TokenValidSince = &tokenValidSince.Time
+	} else {
+		authinfo.TokenValidSince = nil
+	}
+	if lastSeenAt.Valid {
+		authinfo.LastSeenAt = &lastSeenAt.Time
+	} else {
+		authinfo.LastSeenAt = nil
+	}
+
+	authinfo.Roles = nil
+	if err := json.Unmarshal([]byte(roles), &authinfo.Roles); err != nil {
+		return err
+	}
+	if len(authinfo.Roles) == 0 {
+		authinfo.Roles = nil
+	}
+	return nil
+}
+
+func (c *conn) GetAuth(id string, authinfo *skydb.AuthInfo) error {
+	builder := c.baseUserBuilder().Where("id = ?", id)
+	scanner := c.QueryRowWith(builder)
+	return c.doScanAuth(authinfo, scanner)
+}
+
+func (c *conn) GetAuthByPrincipalID(principalID string, authinfo *skydb.AuthInfo) error {
+	builder := c.baseUserBuilder().
+		Where("EXISTS (SELECT 1 FROM json_each(provider_info) WHERE key = ?)", principalID)
+	scanner := c.QueryRowWith(builder)
+	return c.doScanAuth(authinfo, scanner)
+}
+
+func (c *conn) DeleteAuth(id string) error {
+	builder := sq.Delete(c.tableName("_auth")).
+		Where("id = ?", id)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (c *conn) EnsureAuthRecordKeysExist(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+	schema, err := db.GetSchema(userRecordType)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve user record schema")
+	}
+
+	schemaToExtend := skydb.RecordSchema{}
+	for _, keys := range authRecordKeys {
+		for _, key := range keys {
+			if _, ok := schema[key]; ok {
+				continue
+			}
+
+			schemaToExtend[key] = skydb.FieldType{
+				Type: skydb.TypeString,
+			}
+		}
+	}
+
+	if _, err := db.Extend(userRecordType, schemaToExtend); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+
+	allIndexesByName, err := db.GetIndexesByRecordType(userRecordType)
+	if err != nil {
+		return err
+	}
+
+	// Only unique indexes on all values of fields make the fields
+	// unique.
+	indexesByFields := map[string]string{}
+	for indexName, index := range allIndexesByName {
+		if isFieldsUniqueIndex(index) {
+			indexesByFields[joinFields(index.Fields)] = indexName
+		}
+	}
+
+	requiredIndexesByFields := map[string]skydb.Index{}
+	for _, keys := range authRecordKeys {
+		requiredIndexesByFields[joinFields(keys)] = skydb.Index{
+			Fields: keys,
+			Unique: true,
+		}
+	}
+
+	for fieldsString, index := range requiredIndexesByFields {
+		if _, ok := indexesByFields[fieldsString]; ok {
+			continue
+		}
+
+		if !c.canMigrate {
+			return fmt.Errorf("Index of %v is required in user record schema", index.Fields)
+		}
+
+		if err := db.SaveIndex(userRecordType, managedIndexName(userRecordType, index), index); err != nil {
+			return err
+		}
+	}
+
+	// cleanup unused unique index
+	if c.canMigrate {
+		for fieldsString, indexName := range indexesByFields {
+			_, isRequired := requiredIndexesByFields[fieldsString]
+			if !isRequired && indexName == managedIndexName(userRecordType, allIndexesByName[indexName]) {
+				db.DeleteIndex(userRecordType, indexName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isFieldsUniqueIndex(index skydb.Index) bool {
+	return index.Unique &&
+		len(index.Expressions) == 0 &&
+		!strings.Contains(index.Definition, " WHERE ")
+}
+
+func joinFields(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func managedIndexName(recordType string, index skydb.Index) string {
+	fields := append([]string{}, index.Fields...)
+	sort.Strings(fields)
+	return fmt.Sprintf("auth_record_keys_%s_%s_key", recordType, strings.Join(fields, "_"))
+}
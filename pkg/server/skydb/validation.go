@@ -0,0 +1,146 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"reflect"
+	"regexp"
+	"unicode/utf8"
+)
+
+// List of rules of FieldValidation, used to report the rules a value
+// violates.
+const (
+	RequiredRule  = "required"
+	MinRule       = "min"
+	MaxRule       = "max"
+	MinLengthRule = "min_length"
+	MaxLengthRule = "max_length"
+	PatternRule   = "pattern"
+	EnumRule      = "enum"
+)
+
+// FieldValidation is the set of rules the value of a field has to satisfy
+// when a Record is saved. Rules other than Required are not checked
+// against a null value.
+//
+// Min and Max apply to numbers. MinLength and MaxLength apply to the
+// number of characters of a string and the number of elements of a list.
+// Pattern is a regular expression a string has to contain a match of;
+// anchor the expression to match the whole string. Enum lists the values
+// allowed.
+type FieldValidation struct {
+	Required  bool          `json:"required,omitempty"`
+	Min       *float64      `json:"min,omitempty"`
+	Max       *float64      `json:"max,omitempty"`
+	MinLength *int          `json:"min_length,omitempty"`
+	MaxLength *int          `json:"max_length,omitempty"`
+	Pattern   string        `json:"pattern,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+}
+
+// IsEmpty returns true if the FieldValidation has no rules.
+func (v *FieldValidation) IsEmpty() bool {
+	return v == nil || reflect.DeepEqual(*v, FieldValidation{})
+}
+
+// Validate returns the rules violated by the value, or an empty slice if
+// the value is valid. The result of a FieldOperation is not known before
+// the Record is saved, so a FieldOperation is always valid; the saved
+// value should be validated before the save is committed instead.
+func (v *FieldValidation) Validate(value interface{}) []string {
+	violations := []string{}
+	if v == nil {
+		return violations
+	}
+
+	if value == nil {
+		if v.Required {
+			violations = append(violations, RequiredRule)
+		}
+		return violations
+	}
+
+	if _, ok := value.(FieldOperation); ok {
+		return violations
+	}
+
+	if number, ok := numberValue(value); ok {
+		if v.Min != nil && number < *v.Min {
+			violations = append(violations, MinRule)
+		}
+		if v.Max != nil && number > *v.Max {
+			violations = append(violations, MaxRule)
+		}
+	}
+
+	if length, ok := lengthValue(value); ok {
+		if v.MinLength != nil && length < *v.MinLength {
+			violations = append(violations, MinLengthRule)
+		}
+		if v.MaxLength != nil && length > *v.MaxLength {
+			violations = append(violations, MaxLengthRule)
+		}
+	}
+
+	if str, ok := value.(string); ok && v.Pattern != "" {
+		matched, err := regexp.MatchString(v.Pattern, str)
+		if err != nil || !matched {
+			violations = append(violations, PatternRule)
+		}
+	}
+
+	if len(v.Enum) > 0 && !enumContains(v.Enum, value) {
+		violations = append(violations, EnumRule)
+	}
+
+	return violations
+}
+
+func numberValue(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func lengthValue(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []interface{}:
+		return len(v), true
+	}
+	return 0, false
+}
+
+func enumContains(enum []interface{}, value interface{}) bool {
+	number, isNumber := numberValue(value)
+	for _, allowed := range enum {
+		if isNumber {
+			if n, ok := numberValue(allowed); ok && n == number {
+				return true
+			}
+		} else if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
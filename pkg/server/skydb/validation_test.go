@@ -0,0 +1,84 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldValidation(t *testing.T) {
+	Convey("FieldValidation", t, func() {
+		min := float64(1)
+		max := float64(10)
+		minLength := 2
+		maxLength := 3
+
+		Convey("is empty without rules", func() {
+			var v *FieldValidation
+			So(v.IsEmpty(), ShouldBeTrue)
+			So((&FieldValidation{}).IsEmpty(), ShouldBeTrue)
+			So((&FieldValidation{Required: true}).IsEmpty(), ShouldBeFalse)
+		})
+
+		Convey("checks only required on null", func() {
+			v := &FieldValidation{Required: true, Min: &min}
+			So(v.Validate(nil), ShouldResemble, []string{RequiredRule})
+
+			v = &FieldValidation{Min: &min}
+			So(v.Validate(nil), ShouldBeEmpty)
+		})
+
+		Convey("checks range of number", func() {
+			v := &FieldValidation{Min: &min, Max: &max}
+			So(v.Validate(float64(5)), ShouldBeEmpty)
+			So(v.Validate(float64(0)), ShouldResemble, []string{MinRule})
+			So(v.Validate(int64(11)), ShouldResemble, []string{MaxRule})
+		})
+
+		Convey("checks length of string and list", func() {
+			v := &FieldValidation{MinLength: &minLength, MaxLength: &maxLength}
+			So(v.Validate("日本語"), ShouldBeEmpty)
+			So(v.Validate("a"), ShouldResemble, []string{MinLengthRule})
+			So(v.Validate("abcd"), ShouldResemble, []string{MaxLengthRule})
+			So(v.Validate([]interface{}{"a", "b"}), ShouldBeEmpty)
+			So(v.Validate([]interface{}{}), ShouldResemble, []string{MinLengthRule})
+		})
+
+		Convey("checks pattern of string", func() {
+			v := &FieldValidation{Pattern: "^[a-z]+$"}
+			So(v.Validate("abc"), ShouldBeEmpty)
+			So(v.Validate("ABC"), ShouldResemble, []string{PatternRule})
+		})
+
+		Convey("checks enum", func() {
+			v := &FieldValidation{Enum: []interface{}{"draft", float64(1)}}
+			So(v.Validate("draft"), ShouldBeEmpty)
+			So(v.Validate(int64(1)), ShouldBeEmpty)
+			So(v.Validate("published"), ShouldResemble, []string{EnumRule})
+		})
+
+		Convey("reports all violated rules", func() {
+			v := &FieldValidation{MaxLength: &maxLength, Pattern: "^[a-z]+$"}
+			So(v.Validate("ABCD"), ShouldResemble, []string{MaxLengthRule, PatternRule})
+		})
+
+		Convey("skips field operation", func() {
+			v := &FieldValidation{Max: &max}
+			So(v.Validate(FieldOperation{Operator: IncrementOperator, Value: float64(100)}), ShouldBeEmpty)
+		})
+	})
+}
@@ -142,6 +142,10 @@ const (
 	// Examples include referencing a field that is disallowed by Field ACL.
 	RecordQueryDenied
 
+	// RecordConflict is returned when a record cannot be saved because
+	// the record has been changed since the client last fetched it.
+	RecordConflict
+
 	// Error codes for expected error condition should be placed
 	// above this line.
 )